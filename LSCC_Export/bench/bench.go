@@ -0,0 +1,376 @@
+package bench
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"lscc/config"
+	"lscc/core"
+	"lscc/network"
+	"lscc/simnet"
+	"lscc/utils"
+)
+
+// Benchmark
+//
+// A benchmark starts a cluster, submits transfers at a fixed rate for a
+// while, waits for the last of them to commit and reports throughput and
+// latency. A transfer within a shard commits when a block of the shard
+// includes it; a cross-shard transfer when the relay block carrying it is
+// finalized.
+
+// Options configure a benchmark run
+type Options struct {
+	Nodes           int
+	Shards          int
+	ConsensusType   string
+	BlockTime       int           // Seconds between blocks
+	Rate            float64       // Transfers submitted per second
+	Duration        time.Duration // How long transfers are submitted
+	Drain           time.Duration // How long to wait for submitted transfers to commit
+	CrossShardRatio int           // Percentage of transfers to another shard
+	Accounts        int           // Funded accounts per shard
+	Distribution    string        // How senders and receivers are picked
+	ZipfS           float64       // Exponent of the zipf distribution
+	Seed            int64         // Seed of the load generator
+}
+
+// Percentiles summarize latencies, in seconds
+type Percentiles struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// Result is the outcome of a benchmark run
+type Result struct {
+	ConsensusType       string      `json:"consensus"`
+	Nodes               int         `json:"nodes"`
+	Shards              int         `json:"shards"`
+	Rate                float64     `json:"rate"`
+	Duration            float64     `json:"duration_seconds"` // From the first submission to the last commit
+	Submitted           int         `json:"submitted"`
+	Rejected            int         `json:"rejected"`
+	Committed           int         `json:"committed"`
+	CrossShardSubmitted int         `json:"cross_shard_submitted"`
+	CrossShardConfirmed int         `json:"cross_shard_confirmed"`
+	TPS                 float64     `json:"tps"`                 // Committed transfers per second
+	Latency             Percentiles `json:"latency"`             // Submission to block inclusion within a shard
+	CrossShardLatency   Percentiles `json:"cross_shard_latency"` // Submission to relay block finalization
+	DroppedEvents       uint64      `json:"dropped_events"`      // Commits missed because an event queue was full
+}
+
+// eventQueueSize bounds the events waiting to be measured per node
+const eventQueueSize = 65536
+
+// submitWorkers is the number of goroutines submitting transfers
+const submitWorkers = 4
+
+// tracker remembers when transfers were submitted and measures their
+// commits
+type tracker struct {
+	submitted  map[string]submission
+	latencies  []float64
+	crossShard []float64
+	lastCommit time.Time
+	mu         sync.Mutex
+}
+
+// submission is a transfer awaiting its commit
+type submission struct {
+	at         time.Time
+	crossShard bool
+}
+
+// Run runs a benchmark on a new cluster started from base
+func Run(base *config.Config, opts Options) (*Result, error) {
+	if opts.Rate <= 0 || opts.Duration <= 0 {
+		return nil, errors.New("rate and duration must be positive")
+	}
+	if opts.Accounts <= 0 {
+		return nil, errors.New("need at least one account per shard")
+	}
+	load, err := newLoadGenerator(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster, err := simnet.StartCluster(base, simnet.Topology{
+		Nodes:         opts.Nodes,
+		Shards:        opts.Shards,
+		ConsensusType: opts.ConsensusType,
+		BlockTime:     opts.BlockTime,
+		Accounts:      opts.Accounts,
+		Balance:       utils.Coins(1000000),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cluster.Stop()
+
+	t := &tracker{submitted: make(map[string]submission)}
+	stop := make(chan struct{})
+	var watchers sync.WaitGroup
+	var dropped uint64
+	var droppedMu sync.Mutex
+	for _, node := range cluster.Nodes {
+		sub := node.ShardManager.Events().Subscribe("bench", eventQueueSize,
+			core.EventBlockAdded, core.EventRelayBlockFinalized)
+		watchers.Add(1)
+		go func(node *network.Node, sub *core.Subscription) {
+			defer watchers.Done()
+			t.watch(node.Config.ShardID, sub, stop)
+			droppedMu.Lock()
+			dropped += sub.Dropped()
+			droppedMu.Unlock()
+		}(node, sub)
+	}
+
+	result := &Result{
+		ConsensusType: opts.ConsensusType,
+		Nodes:         opts.Nodes,
+		Shards:        opts.Shards,
+		Rate:          opts.Rate,
+	}
+	start := time.Now()
+	t.drive(cluster, load, opts, result)
+
+	// Wait for the submitted transfers to commit
+	deadline := time.Now().Add(opts.Drain)
+	for time.Now().Before(deadline) && t.pending() > 0 {
+		time.Sleep(100 * time.Millisecond)
+	}
+	close(stop)
+	watchers.Wait()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	end := t.lastCommit
+	if end.Before(start) {
+		end = start.Add(opts.Duration)
+	}
+	result.Duration = end.Sub(start).Seconds()
+	result.Committed = len(t.latencies) + len(t.crossShard)
+	result.CrossShardConfirmed = len(t.crossShard)
+	result.TPS = float64(result.Committed) / result.Duration
+	result.Latency = summarize(t.latencies)
+	result.CrossShardLatency = summarize(t.crossShard)
+	result.DroppedEvents = dropped
+	return result, nil
+}
+
+// drive submits transfers at the configured rate for the configured
+// duration, each to a node of its source shard
+func (t *tracker) drive(cluster *simnet.Cluster, load *loadGenerator, opts Options, result *Result) {
+	txs := make(chan *core.Transaction, submitWorkers*64)
+	var workers sync.WaitGroup
+	var mu sync.Mutex
+	for i := 0; i < submitWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			next := make(map[int]int) // Node to submit to next, by shard
+			for tx := range txs {
+				nodes := cluster.NodesOfShard(tx.SourceShard)
+				node := nodes[next[tx.SourceShard]%len(nodes)]
+				next[tx.SourceShard]++
+
+				t.track(tx)
+				err := node.SubmitTransaction(tx)
+				mu.Lock()
+				result.Submitted++
+				if tx.IsCrossShard() {
+					result.CrossShardSubmitted++
+				}
+				if err != nil {
+					result.Rejected++
+				}
+				mu.Unlock()
+				if err != nil {
+					t.forget(tx.Hash)
+				}
+			}
+		}()
+	}
+
+	// Submit in small steps so high rates are met without a timer per
+	// transfer
+	const step = 10 * time.Millisecond
+	ticker := time.NewTicker(step)
+	defer ticker.Stop()
+	start := time.Now()
+	sent := 0
+	for now := range ticker.C {
+		elapsed := now.Sub(start)
+		if elapsed > opts.Duration {
+			break
+		}
+		due := int(opts.Rate * elapsed.Seconds())
+		for ; sent < due; sent++ {
+			tx, err := load.next()
+			if err != nil {
+				continue
+			}
+			txs <- tx
+		}
+	}
+	close(txs)
+	workers.Wait()
+}
+
+// track records the submission of a transfer
+func (t *tracker) track(tx *core.Transaction) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.submitted[tx.Hash] = submission{at: time.Now(), crossShard: tx.IsCrossShard()}
+}
+
+// forget drops a rejected transfer
+func (t *tracker) forget(hash string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.submitted, hash)
+}
+
+// pending returns the number of transfers not committed yet
+func (t *tracker) pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.submitted)
+}
+
+// commit measures the commit of a transfer, the first time it is seen
+func (t *tracker) commit(hash string, crossShard bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, tracked := t.submitted[hash]
+	if !tracked || s.crossShard != crossShard {
+		return
+	}
+	delete(t.submitted, hash)
+	now := time.Now()
+	latency := now.Sub(s.at).Seconds()
+	if crossShard {
+		t.crossShard = append(t.crossShard, latency)
+	} else {
+		t.latencies = append(t.latencies, latency)
+	}
+	if now.After(t.lastCommit) {
+		t.lastCommit = now
+	}
+}
+
+// watch measures commits seen by a node of a shard until stop is closed:
+// blocks of its own shard and the relay blocks it finalizes
+func (t *tracker) watch(shardID int, sub *core.Subscription, stop <-chan struct{}) {
+	defer sub.Unsubscribe()
+	for {
+		select {
+		case <-stop:
+			return
+		case event := <-sub.Events():
+			switch event.Type {
+			case core.EventBlockAdded:
+				if event.ShardID != shardID {
+					continue
+				}
+				for _, tx := range event.Block.Transactions {
+					t.commit(tx.Hash, false)
+				}
+			case core.EventRelayBlockFinalized:
+				for _, tx := range event.RelayBlock.CrossShardTxs {
+					t.commit(tx.Hash, true)
+				}
+			}
+		}
+	}
+}
+
+// summarize computes the percentiles of latencies
+func summarize(latencies []float64) Percentiles {
+	if len(latencies) == 0 {
+		return Percentiles{}
+	}
+	sorted := append([]float64(nil), latencies...)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, latency := range sorted {
+		sum += latency
+	}
+	return Percentiles{
+		Count: len(sorted),
+		Mean:  sum / float64(len(sorted)),
+		P50:   percentile(sorted, 50),
+		P90:   percentile(sorted, 90),
+		P99:   percentile(sorted, 99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// percentile returns the nearest-rank percentile of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// csvHeader names the columns written by WriteCSV
+var csvHeader = []string{
+	"consensus", "nodes", "shards", "rate", "duration_seconds",
+	"submitted", "rejected", "committed", "cross_shard_submitted", "cross_shard_confirmed", "tps",
+	"latency_mean", "latency_p50", "latency_p90", "latency_p99", "latency_max",
+	"cross_shard_latency_mean", "cross_shard_latency_p50", "cross_shard_latency_p90",
+	"cross_shard_latency_p99", "cross_shard_latency_max",
+	"dropped_events",
+}
+
+// WriteCSV writes results as CSV, one row per run
+func WriteCSV(w io.Writer, results []*Result) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+	for _, r := range results {
+		row := []string{
+			r.ConsensusType, strconv.Itoa(r.Nodes), strconv.Itoa(r.Shards), f(r.Rate), f(r.Duration),
+			strconv.Itoa(r.Submitted), strconv.Itoa(r.Rejected), strconv.Itoa(r.Committed),
+			strconv.Itoa(r.CrossShardSubmitted), strconv.Itoa(r.CrossShardConfirmed), f(r.TPS),
+			f(r.Latency.Mean), f(r.Latency.P50), f(r.Latency.P90), f(r.Latency.P99), f(r.Latency.Max),
+			f(r.CrossShardLatency.Mean), f(r.CrossShardLatency.P50), f(r.CrossShardLatency.P90),
+			f(r.CrossShardLatency.P99), f(r.CrossShardLatency.Max),
+			strconv.FormatUint(r.DroppedEvents, 10),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes results as an indented JSON array
+func WriteJSON(w io.Writer, results []*Result) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(results); err != nil {
+		return fmt.Errorf("failed to encode results: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"lscc/config"
+	"lscc/core"
+)
+
+func TestSummarize(t *testing.T) {
+	if got := summarize(nil); got != (Percentiles{}) {
+		t.Errorf("summary of no latencies = %+v", got)
+	}
+
+	latencies := make([]float64, 0, 100)
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, float64(i))
+	}
+	want := Percentiles{Count: 100, Mean: 50.5, P50: 50, P90: 90, P99: 99, Max: 100}
+	if got := summarize(latencies); got != want {
+		t.Errorf("summary = %+v, want %+v", got, want)
+	}
+	if latencies[0] != 100 {
+		t.Error("summarize reordered its input")
+	}
+	if got := summarize([]float64{3}); got.P50 != 3 || got.P99 != 3 || got.Max != 3 {
+		t.Errorf("summary of one latency = %+v", got)
+	}
+}
+
+func TestTrackerMeasuresCommits(t *testing.T) {
+	tr := &tracker{submitted: make(map[string]submission)}
+	local := &core.Transaction{Hash: "local", Type: core.RegularTransaction}
+	remote := &core.Transaction{Hash: "remote", Type: core.CrossShardTransaction, SourceShard: 0, TargetShard: 1}
+	rejected := &core.Transaction{Hash: "rejected", Type: core.RegularTransaction}
+	for _, tx := range []*core.Transaction{local, remote, rejected} {
+		tr.track(tx)
+	}
+	tr.forget(rejected.Hash)
+
+	bus := core.NewEventBus()
+	sub := bus.Subscribe("bench", 16)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		tr.watch(0, sub, stop)
+		close(done)
+	}()
+
+	// A cross-shard transfer commits with its relay block, not with the
+	// block of its source shard, and blocks of other shards are ignored
+	bus.Publish(core.Event{Type: core.EventBlockAdded, ShardID: 1, Block: &core.Block{Transactions: []core.Transaction{*local}}})
+	bus.Publish(core.Event{Type: core.EventBlockAdded, ShardID: 0, Block: &core.Block{Transactions: []core.Transaction{*remote}}})
+	bus.Publish(core.Event{Type: core.EventBlockAdded, ShardID: 0, Block: &core.Block{Transactions: []core.Transaction{*local}}})
+	bus.Publish(core.Event{Type: core.EventRelayBlockFinalized, ShardID: -1, RelayBlock: &core.RelayBlock{CrossShardTxs: []*core.Transaction{remote}}})
+	bus.Publish(core.Event{Type: core.EventBlockAdded, ShardID: 0, Block: &core.Block{Transactions: []core.Transaction{*local}}})
+
+	deadline := time.Now().Add(time.Second)
+	for tr.pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(stop)
+	<-done
+
+	if tr.pending() != 0 {
+		t.Fatalf("%d transfers not committed", tr.pending())
+	}
+	if len(tr.latencies) != 1 || len(tr.crossShard) != 1 {
+		t.Errorf("measured %d shard and %d cross-shard commits, want 1 of each", len(tr.latencies), len(tr.crossShard))
+	}
+	if tr.lastCommit.IsZero() {
+		t.Error("last commit not recorded")
+	}
+	if len(bus.Stats().Subscribers) != 0 {
+		t.Error("watch did not unsubscribe")
+	}
+}
+
+func TestRunChecksOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"no rate", Options{Duration: time.Second, Accounts: 1}},
+		{"no duration", Options{Rate: 10, Accounts: 1}},
+		{"no accounts", Options{Rate: 10, Duration: time.Second}},
+		{"an unknown distribution", Options{Rate: 10, Duration: time.Second, Accounts: 1, Shards: 1, Distribution: "pareto"}},
+	}
+	for _, tt := range tests {
+		if _, err := Run(config.DefaultConfig(), tt.opts); err == nil {
+			t.Errorf("benchmark with %s ran", tt.name)
+		}
+	}
+}
+
+func TestWriteResults(t *testing.T) {
+	results := []*Result{
+		{ConsensusType: "pbft", Nodes: 4, Shards: 2, Rate: 50, Duration: 10, Submitted: 500, Committed: 480, TPS: 48,
+			Latency: Percentiles{Count: 400, Mean: 1.5, P50: 1, P90: 2, P99: 3, Max: 4}},
+		{ConsensusType: "pow", Nodes: 4, Shards: 2, Rate: 50, DroppedEvents: 2},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("%d CSV rows, want a header and 2 runs", len(rows))
+	}
+	for i, row := range rows {
+		if len(row) != len(csvHeader) {
+			t.Errorf("row %d has %d columns, want %d", i, len(row), len(csvHeader))
+		}
+	}
+	if rows[1][0] != "pbft" || rows[1][10] != "48.0000" || rows[1][12] != "1.0000" || rows[2][21] != "2" {
+		t.Errorf("CSV rows = %q", rows[1:])
+	}
+
+	buf.Reset()
+	if err := WriteJSON(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []*Result
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 2 || *decoded[0] != *results[0] || *decoded[1] != *results[1] {
+		t.Errorf("JSON results = %s", buf.Bytes())
+	}
+}
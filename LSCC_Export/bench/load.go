@@ -0,0 +1,82 @@
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+
+	"lscc/core"
+	"lscc/simnet"
+	"lscc/utils"
+)
+
+// Account distributions
+const (
+	DistributionUniform = "uniform" // Every account is equally likely to send and receive
+	DistributionZipf    = "zipf"    // Few accounts send and receive most transactions
+)
+
+// loadGenerator builds the transfers of a benchmark
+type loadGenerator struct {
+	shards          int
+	crossShardRatio int // Percentage of transfers to another shard
+	pickAccount     func() int
+	rng             *rand.Rand
+	nonce           uint64
+}
+
+// newLoadGenerator creates a generator picking accounts of each shard by a
+// distribution
+func newLoadGenerator(opts Options) (*loadGenerator, error) {
+	rng := rand.New(rand.NewSource(opts.Seed))
+	g := &loadGenerator{
+		shards:          opts.Shards,
+		crossShardRatio: opts.CrossShardRatio,
+		rng:             rng,
+	}
+
+	switch opts.Distribution {
+	case DistributionUniform, "":
+		g.pickAccount = func() int { return rng.Intn(opts.Accounts) }
+	case DistributionZipf:
+		if opts.ZipfS <= 1 {
+			return nil, fmt.Errorf("zipf exponent must be greater than 1, got %g", opts.ZipfS)
+		}
+		zipf := rand.NewZipf(rng, opts.ZipfS, 1, uint64(opts.Accounts-1))
+		g.pickAccount = func() int { return int(zipf.Uint64()) }
+	default:
+		return nil, fmt.Errorf("unknown account distribution %q", opts.Distribution)
+	}
+	return g, nil
+}
+
+// next returns a signed transfer of one base unit between two accounts.
+// The nonce makes transfers between the same accounts in the same second
+// distinct.
+func (g *loadGenerator) next() (*core.Transaction, error) {
+	source := g.rng.Intn(g.shards)
+	target := source
+	if g.shards > 1 && g.rng.Intn(100) < g.crossShardRatio {
+		target = (source + 1 + g.rng.Intn(g.shards-1)) % g.shards
+	}
+
+	from := simnet.AccountName(source, g.pickAccount())
+	to := simnet.AccountName(target, g.pickAccount())
+	txType := core.RegularTransaction
+	if source != target {
+		txType = core.CrossShardTransaction
+	}
+
+	tx, err := core.NewTransaction(from, to, utils.Amount(1), 0, source, target, 0, txType)
+	if err != nil {
+		return nil, err
+	}
+	g.nonce++
+	tx.Nonce = g.nonce
+	if tx.Hash, err = tx.CalculateHash(); err != nil {
+		return nil, err
+	}
+	if err := tx.Sign(from); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
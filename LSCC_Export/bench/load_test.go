@@ -0,0 +1,69 @@
+package bench
+
+import (
+	"strings"
+	"testing"
+
+	"lscc/simnet"
+)
+
+// accountPrefix returns the start of the names of a shard's accounts
+func accountPrefix(shardID int) string {
+	return strings.TrimSuffix(simnet.AccountName(shardID, 0), "0")
+}
+
+func TestLoadGeneratorOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"an unknown distribution", Options{Shards: 2, Accounts: 4, Distribution: "pareto"}},
+		{"a zipf exponent of 1", Options{Shards: 2, Accounts: 4, Distribution: DistributionZipf, ZipfS: 1}},
+	}
+	for _, tt := range tests {
+		if _, err := newLoadGenerator(tt.opts); err == nil {
+			t.Errorf("generator with %s created", tt.name)
+		}
+	}
+}
+
+func TestLoadGeneratorTransfers(t *testing.T) {
+	for _, distribution := range []string{DistributionUniform, DistributionZipf} {
+		for _, ratio := range []int{0, 100} {
+			g, err := newLoadGenerator(Options{
+				Shards: 3, Accounts: 5, CrossShardRatio: ratio,
+				Distribution: distribution, ZipfS: 1.5, Seed: 1,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			accounts := make(map[string]bool)
+			for shard := 0; shard < 3; shard++ {
+				for i := 0; i < 5; i++ {
+					accounts[simnet.AccountName(shard, i)] = true
+				}
+			}
+
+			hashes := make(map[string]bool)
+			for i := 0; i < 200; i++ {
+				tx, err := g.next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if hashes[tx.Hash] {
+					t.Fatalf("%s transfer %d repeats hash %s", distribution, i, tx.Hash)
+				}
+				hashes[tx.Hash] = true
+				if !accounts[tx.From] || !accounts[tx.To] || tx.Signature == "" {
+					t.Fatalf("%s transfer %d from %s to %s is not between funded accounts", distribution, i, tx.From, tx.To)
+				}
+				if !strings.HasPrefix(tx.From, accountPrefix(tx.SourceShard)) || !strings.HasPrefix(tx.To, accountPrefix(tx.TargetShard)) {
+					t.Fatalf("transfer from %s to %s is not from shard %d to %d", tx.From, tx.To, tx.SourceShard, tx.TargetShard)
+				}
+				if crossShard := tx.SourceShard != tx.TargetShard; crossShard != (ratio == 100) || tx.IsCrossShard() != crossShard {
+					t.Fatalf("transfer from shard %d to %d with a cross-shard ratio of %d", tx.SourceShard, tx.TargetShard, ratio)
+				}
+			}
+		}
+	}
+}
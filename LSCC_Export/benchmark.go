@@ -0,0 +1,101 @@
+package main
+
+import (
+        "flag"
+        "fmt"
+        "os"
+        "strings"
+        "time"
+
+        "lscc/bench"
+        "lscc/config"
+        "lscc/utils"
+)
+
+// runBench benchmarks an in-process network once per consensus engine and
+// writes the results as CSV or JSON
+func runBench(args []string) error {
+        defaults := config.DefaultConfig()
+
+        fs := flag.NewFlagSet("bench", flag.ExitOnError)
+        configFile := fs.String("config", "", "Config file the nodes' settings are taken from (default: built-in defaults)")
+        engines := fs.String("consensus", "pos,pbft,pow", "Comma-separated consensus engines to benchmark")
+        nodes := fs.Int("nodes", 4, "Number of nodes")
+        shards := fs.Int("shards", 2, "Number of shards")
+        blockTime := fs.Int("block-time", 1, "Seconds between blocks")
+        rate := fs.Float64("rate", 100, "Transfers submitted per second")
+        duration := fs.Duration("duration", 30*time.Second, "How long transfers are submitted")
+        drain := fs.Duration("drain", 30*time.Second, "How long to wait for submitted transfers to commit")
+        crossShard := fs.Int("cross-shard-ratio", -1, "Percentage of cross-shard transfers (default: the config's cross_shard_ratio)")
+        accounts := fs.Int("accounts", 1000, "Funded accounts per shard")
+        distribution := fs.String("distribution", bench.DistributionUniform, "Account distribution: uniform or zipf")
+        zipfS := fs.Float64("zipf-s", 1.1, "Exponent of the zipf distribution, greater than 1")
+        seed := fs.Int64("seed", 1, "Seed of the load generator")
+        format := fs.String("format", "csv", "Output format: csv or json")
+        out := fs.String("out", "", "File to write the results to (default: standard output)")
+        logLevel := fs.String("log-level", "error", "Level of the nodes' logs, written to standard error")
+        fs.Parse(args)
+
+        base := defaults
+        if *configFile != "" {
+                cfg, err := config.LoadConfig(*configFile)
+                if err != nil {
+                        return err
+                }
+                base = cfg
+        }
+        if *crossShard < 0 {
+                *crossShard = base.CrossShardRatio
+        }
+        if *format != "csv" && *format != "json" {
+                return fmt.Errorf("unknown output format %q", *format)
+        }
+
+        // Keep standard output for the results
+        utils.SetLogOutput(os.Stderr)
+        logging := base.Logging
+        logging.Level = *logLevel
+        logging.File = ""
+        if err := utils.ConfigureLogging(logging, ""); err != nil {
+                return err
+        }
+        base.Tracing.CollectorURL = ""
+
+        var results []*bench.Result
+        for _, engine := range strings.Split(*engines, ",") {
+                engine = strings.TrimSpace(engine)
+                fmt.Fprintf(os.Stderr, "Benchmarking %s: %d nodes, %d shards, %.0f tx/s for %s\n", engine, *nodes, *shards, *rate, *duration)
+                result, err := bench.Run(base, bench.Options{
+                        Nodes:           *nodes,
+                        Shards:          *shards,
+                        ConsensusType:   engine,
+                        BlockTime:       *blockTime,
+                        Rate:            *rate,
+                        Duration:        *duration,
+                        Drain:           *drain,
+                        CrossShardRatio: *crossShard,
+                        Accounts:        *accounts,
+                        Distribution:    *distribution,
+                        ZipfS:           *zipfS,
+                        Seed:            *seed,
+                })
+                if err != nil {
+                        return fmt.Errorf("%s: %w", engine, err)
+                }
+                results = append(results, result)
+        }
+
+        w := os.Stdout
+        if *out != "" {
+                file, err := os.Create(*out)
+                if err != nil {
+                        return err
+                }
+                defer file.Close()
+                w = file
+        }
+        if *format == "json" {
+                return bench.WriteJSON(w, results)
+        }
+        return bench.WriteCSV(w, results)
+}
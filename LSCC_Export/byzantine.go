@@ -0,0 +1,131 @@
+package main
+
+import (
+        "encoding/json"
+        "flag"
+        "fmt"
+        "os"
+        "sort"
+        "strings"
+        "text/tabwriter"
+        "time"
+
+        "lscc/byzantine"
+        "lscc/config"
+        "lscc/utils"
+)
+
+// runByzantine runs Byzantine fault injection scenarios on in-process
+// networks and reports which properties held
+func runByzantine(args []string) error {
+        fs := flag.NewFlagSet("byzantine", flag.ExitOnError)
+        configFile := fs.String("config", "", "Config file the nodes' settings are taken from (default: built-in defaults)")
+        scenario := fs.String("scenario", "all", "Comma-separated scenarios to run, or all")
+        list := fs.Bool("list", false, "List the scenarios and exit")
+        duration := fs.Duration("duration", 20*time.Second, "How long the adversary misbehaves in each scenario")
+        seed := fs.Int64("seed", 1, "Seed of the simulated network")
+        format := fs.String("format", "text", "Output format: text or json")
+        logLevel := fs.String("log-level", "error", "Level of the nodes' logs, written to standard error")
+        fs.Parse(args)
+
+        if *list {
+                w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+                for _, s := range byzantine.Scenarios() {
+                        fmt.Fprintf(w, "%s\t%s\n", s.Name, s.Description)
+                }
+                return w.Flush()
+        }
+        if *format != "text" && *format != "json" {
+                return fmt.Errorf("unknown output format %q", *format)
+        }
+
+        base := config.DefaultConfig()
+        if *configFile != "" {
+                cfg, err := config.LoadConfig(*configFile)
+                if err != nil {
+                        return err
+                }
+                base = cfg
+        }
+
+        // Keep standard output for the reports
+        utils.SetLogOutput(os.Stderr)
+        logging := base.Logging
+        logging.Level = *logLevel
+        logging.File = ""
+        if err := utils.ConfigureLogging(logging, ""); err != nil {
+                return err
+        }
+        base.Tracing.CollectorURL = ""
+
+        var names []string
+        if *scenario == "all" {
+                for _, s := range byzantine.Scenarios() {
+                        names = append(names, s.Name)
+                }
+        } else {
+                for _, name := range strings.Split(*scenario, ",") {
+                        names = append(names, strings.TrimSpace(name))
+                }
+        }
+
+        var reports []*byzantine.Report
+        failed := 0
+        for _, name := range names {
+                fmt.Fprintf(os.Stderr, "Running %s for %s\n", name, *duration)
+                report, err := byzantine.Run(base, name, byzantine.Options{Duration: *duration, Seed: *seed})
+                if err != nil {
+                        return err
+                }
+                if !report.Passed() {
+                        failed++
+                }
+                reports = append(reports, report)
+        }
+
+        if *format == "json" {
+                encoder := json.NewEncoder(os.Stdout)
+                encoder.SetIndent("", "  ")
+                if err := encoder.Encode(reports); err != nil {
+                        return err
+                }
+        } else if err := writeByzantineReports(reports); err != nil {
+                return err
+        }
+
+        if failed > 0 {
+                return fmt.Errorf("%d of %d scenarios failed", failed, len(reports))
+        }
+        return nil
+}
+
+// writeByzantineReports writes scenario reports as a table
+func writeByzantineReports(reports []*byzantine.Report) error {
+        w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+        for _, report := range reports {
+                result := "PASS"
+                if !report.Passed() {
+                        result = "FAIL"
+                }
+                fmt.Fprintf(w, "%s\t%s\tadversary %s, %.0fs\n", result, report.Scenario, report.Adversary, report.Duration)
+
+                behaviors := make([]string, 0, len(report.Actions))
+                for behavior := range report.Actions {
+                        behaviors = append(behaviors, behavior)
+                }
+                sort.Strings(behaviors)
+                for _, behavior := range behaviors {
+                        fmt.Fprintf(w, "\t  %s\t%d misbehaviors\n", behavior, report.Actions[behavior])
+                }
+                for _, property := range report.Properties {
+                        status := "ok"
+                        if !property.Holds {
+                                status = "VIOLATED"
+                        }
+                        fmt.Fprintf(w, "\t  %s %s\t%s: %s\n", property.Kind, property.Name, status, property.Detail)
+                }
+                fmt.Fprintf(w, "\t  network\t%d sent, %d delivered, %d filtered\n",
+                        report.Network.Sent, report.Network.Delivered, report.Network.Filtered)
+        }
+        return w.Flush()
+}
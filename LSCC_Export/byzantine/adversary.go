@@ -0,0 +1,159 @@
+package byzantine
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"lscc/network"
+	"lscc/simnet"
+)
+
+// Byzantine fault injection
+//
+// An adversary is a node of a simulated cluster that misbehaves. It runs
+// the same code as every other node; its behaviors rewrite, delay or drop
+// the messages it writes to its peers, through the simulated network's
+// filter, and act on their own next to it, like a tampered node would.
+// Scenarios corrupt nodes of a cluster and check that the honest nodes stay
+// safe and live.
+
+// Behavior is a way an adversary misbehaves
+type Behavior interface {
+	// Name names the behavior in reports
+	Name() string
+	// Filter returns the messages to send in place of one the adversary
+	// writes to a peer, and how much later to send them
+	Filter(a *Adversary, to string, msg network.Message) ([]network.Message, time.Duration)
+	// Run acts on its own until stop is closed
+	Run(a *Adversary, stop <-chan struct{})
+}
+
+// passive is embedded by behaviors that leave messages alone or do nothing
+// on their own
+type passive struct{}
+
+func (passive) Filter(a *Adversary, to string, msg network.Message) ([]network.Message, time.Duration) {
+	return []network.Message{msg}, 0
+}
+
+func (passive) Run(a *Adversary, stop <-chan struct{}) {}
+
+// Adversary is a corrupted node of a cluster
+type Adversary struct {
+	Node      *network.Node
+	Cluster   *simnet.Cluster
+	behaviors []Behavior
+	actions   map[string]int // Misbehaviors by behavior name
+	stop      chan struct{}
+	stopOnce  sync.Once
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+}
+
+// Corrupt makes a node of a cluster on a simulated network misbehave until
+// the adversary is stopped
+func Corrupt(cluster *simnet.Cluster, nodeID string, behaviors ...Behavior) (*Adversary, error) {
+	if cluster.Network == nil {
+		return nil, errors.New("corrupting a node needs a simulated network")
+	}
+	node := cluster.Node(nodeID)
+	if node == nil {
+		return nil, fmt.Errorf("unknown node %s", nodeID)
+	}
+
+	a := &Adversary{
+		Node:      node,
+		Cluster:   cluster,
+		behaviors: behaviors,
+		actions:   make(map[string]int),
+		stop:      make(chan struct{}),
+	}
+	cluster.Network.SetFilter(nodeID, a.filter)
+	for _, behavior := range behaviors {
+		a.wg.Add(1)
+		go func(behavior Behavior) {
+			defer a.wg.Done()
+			behavior.Run(a, a.stop)
+		}(behavior)
+	}
+	return a, nil
+}
+
+// Stop makes the adversary behave again
+func (a *Adversary) Stop() {
+	a.stopOnce.Do(func() {
+		a.Cluster.Network.SetFilter(a.Node.ID, nil)
+		close(a.stop)
+	})
+	a.wg.Wait()
+}
+
+// filter passes a message the adversary writes through its behaviors in
+// turn. Messages that are not JSON are let through.
+func (a *Adversary) filter(to string, data []byte) []simnet.Outgoing {
+	var msg network.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return []simnet.Outgoing{{Data: data}}
+	}
+
+	type pending struct {
+		msg   network.Message
+		delay time.Duration
+	}
+	queue := []pending{{msg: msg}}
+	for _, behavior := range a.behaviors {
+		var next []pending
+		for _, p := range queue {
+			msgs, delay := behavior.Filter(a, to, p.msg)
+			for _, m := range msgs {
+				next = append(next, pending{msg: m, delay: p.delay + delay})
+			}
+		}
+		queue = next
+	}
+
+	outgoing := make([]simnet.Outgoing, 0, len(queue))
+	for _, p := range queue {
+		encoded, err := json.Marshal(p.msg)
+		if err != nil {
+			continue
+		}
+		outgoing = append(outgoing, simnet.Outgoing{Data: encoded, Delay: p.delay})
+	}
+	return outgoing
+}
+
+// record counts a misbehavior
+func (a *Adversary) record(behavior string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.actions[behavior]++
+}
+
+// Actions returns how often each behavior has misbehaved
+func (a *Adversary) Actions() map[string]int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	actions := make(map[string]int, len(a.behaviors))
+	for _, behavior := range a.behaviors {
+		actions[behavior.Name()] = a.actions[behavior.Name()]
+	}
+	return actions
+}
+
+// peers returns the IDs of the other nodes of the cluster, sorted
+func (a *Adversary) peers() []string {
+	var peers []string
+	for _, node := range a.Cluster.Nodes {
+		if node.ID != a.Node.ID {
+			peers = append(peers, node.ID)
+		}
+	}
+	sort.Strings(peers)
+	return peers
+}
@@ -0,0 +1,379 @@
+package byzantine
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"lscc/consensus"
+	"lscc/core"
+	"lscc/network"
+	"lscc/utils"
+)
+
+// PBFT phases, as the engine names them on the wire
+const (
+	phasePrePrepare = "pre-prepare"
+	phasePrepare    = "prepare"
+	phaseCommit     = "commit"
+)
+
+// pbftMessage is a PBFT protocol message in the engine's wire format
+type pbftMessage struct {
+	Phase     string      `json:"phase"`
+	View      uint64      `json:"view"`
+	Height    uint64      `json:"height"`
+	BlockHash string      `json:"block_hash"`
+	Block     *core.Block `json:"block,omitempty"`
+	NodeID    string      `json:"node_id"`
+	Signature string      `json:"signature"`
+}
+
+// sign signs a PBFT message the way the engine signs its own
+func (m *pbftMessage) sign(nodeID string) error {
+	payload := fmt.Sprintf("pbft:%s:%d:%d:%s", m.Phase, m.View, m.Height, m.BlockHash)
+	signature, err := utils.Sign([]byte(payload), nodeID)
+	if err != nil {
+		return err
+	}
+	m.NodeID = nodeID
+	m.Signature = signature
+	return nil
+}
+
+// decodePBFT returns the PBFT message a network message carries
+func decodePBFT(msg network.Message) (*network.ConsensusMessage, *pbftMessage, bool) {
+	if msg.Type != network.MessageTypeConsensus {
+		return nil, nil, false
+	}
+	var wrapper network.ConsensusMessage
+	if err := json.Unmarshal(msg.Data, &wrapper); err != nil || wrapper.Type != string(consensus.PBFT) {
+		return nil, nil, false
+	}
+	var pbft pbftMessage
+	if err := json.Unmarshal(wrapper.Data, &pbft); err != nil {
+		return nil, nil, false
+	}
+	return &wrapper, &pbft, true
+}
+
+// encodePBFT replaces the PBFT message a network message carries
+func encodePBFT(msg network.Message, wrapper *network.ConsensusMessage, pbft *pbftMessage) (network.Message, error) {
+	data, err := json.Marshal(pbft)
+	if err != nil {
+		return msg, err
+	}
+	wrapper.Data = data
+	if msg.Data, err = json.Marshal(wrapper); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+// EquivocatingProposer proposes two different blocks for the same height
+// and view when it is the PBFT primary: every other peer, by sorted ID,
+// receives a conflicting block in place of the real one
+type EquivocatingProposer struct {
+	passive
+
+	conflicting map[string]pbftMessage // Conflicting pre-prepares by hash of the block they replace
+	mu          sync.Mutex
+}
+
+func (*EquivocatingProposer) Name() string {
+	return "equivocating-proposer"
+}
+
+func (e *EquivocatingProposer) Filter(a *Adversary, to string, msg network.Message) ([]network.Message, time.Duration) {
+	wrapper, pbft, ok := decodePBFT(msg)
+	if !ok || pbft.Phase != phasePrePrepare || pbft.NodeID != a.Node.ID || pbft.Block == nil {
+		return []network.Message{msg}, 0
+	}
+	peers := a.peers()
+	for i, peer := range peers {
+		if peer == to && i%2 == 0 {
+			return []network.Message{msg}, 0
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conflicting == nil {
+		e.conflicting = make(map[string]pbftMessage)
+	}
+	for _, conflicting := range e.conflicting {
+		// Gossip of a conflicting block passes as it is
+		if conflicting.BlockHash == pbft.BlockHash {
+			return []network.Message{msg}, 0
+		}
+	}
+	conflicting, ok := e.conflicting[pbft.BlockHash]
+	if !ok {
+		// A later timestamp is enough for a different, equally valid block
+		block := *pbft.Block
+		block.Header.Timestamp++
+		if err := block.Sign(a.Node.ID); err != nil {
+			return []network.Message{msg}, 0
+		}
+		if err := block.SignWithKey(a.Node.Config.ValidatorKey); err != nil {
+			return []network.Message{msg}, 0
+		}
+		hash, err := block.Hash()
+		if err != nil {
+			return []network.Message{msg}, 0
+		}
+		conflicting = *pbft
+		conflicting.Block = &block
+		conflicting.BlockHash = hash
+		if err := conflicting.sign(a.Node.ID); err != nil {
+			return []network.Message{msg}, 0
+		}
+		e.conflicting[pbft.BlockHash] = conflicting
+		a.record(e.Name())
+	}
+
+	equivocation, err := encodePBFT(msg, wrapper, &conflicting)
+	if err != nil {
+		return []network.Message{msg}, 0
+	}
+	return []network.Message{equivocation}, 0
+}
+
+// WithholdBlocks never passes a block on: neither its own nor relayed
+// PBFT proposals, nor blocks it is asked for
+type WithholdBlocks struct {
+	passive
+}
+
+func (WithholdBlocks) Name() string {
+	return "withhold-blocks"
+}
+
+func (w WithholdBlocks) Filter(a *Adversary, to string, msg network.Message) ([]network.Message, time.Duration) {
+	switch msg.Type {
+	case network.MessageTypeBlock, network.MessageTypeBlockResponse:
+		a.record(w.Name())
+		return nil, 0
+	}
+	if _, pbft, ok := decodePBFT(msg); ok && pbft.Block != nil {
+		a.record(w.Name())
+		return nil, 0
+	}
+	return []network.Message{msg}, 0
+}
+
+// DelayVotes sends its own PBFT prepares and commits late
+type DelayVotes struct {
+	passive
+	Delay time.Duration
+}
+
+func (DelayVotes) Name() string {
+	return "delay-votes"
+}
+
+func (d DelayVotes) Filter(a *Adversary, to string, msg network.Message) ([]network.Message, time.Duration) {
+	_, pbft, ok := decodePBFT(msg)
+	if !ok || pbft.NodeID != a.Node.ID || (pbft.Phase != phasePrepare && pbft.Phase != phaseCommit) {
+		return []network.Message{msg}, 0
+	}
+	a.record(d.Name())
+	return []network.Message{msg}, d.Delay
+}
+
+// Spam kinds, sent in turn
+const (
+	spamUnsigned   = iota // Transfer without a signature
+	spamUnfunded          // Transfer from an account without funds
+	spamOtherChain        // Transfer signed for another chain
+	spamReplay            // A transfer sent before, again
+	spamValid             // Dust transfer from the adversary's own account
+	spamKinds
+)
+
+// SpamTransactions floods the peers with transactions at Rate per second:
+// invalid ones, replays and valid dust transfers, without checking them
+// against its own chain first
+type SpamTransactions struct {
+	passive
+	Rate float64
+
+	invalid map[string]bool // Hashes of the invalid transactions sent
+	mu      sync.Mutex
+}
+
+func (*SpamTransactions) Name() string {
+	return "spam-transactions"
+}
+
+func (s *SpamTransactions) Run(a *Adversary, stop <-chan struct{}) {
+	interval := time.Duration(float64(time.Second) / s.Rate)
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	shardID := a.Node.Config.ShardID
+	var sent []*core.Transaction
+	for n := uint64(1); ; n++ {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		kind := int(n % spamKinds)
+		if kind == spamReplay && len(sent) == 0 {
+			kind = spamValid
+		}
+		var tx *core.Transaction
+		var err error
+		switch kind {
+		case spamUnsigned:
+			tx, err = transfer(a.Node.ID, "sink", shardID, shardID, n, 1, core.ChainID())
+		case spamUnfunded:
+			tx, err = transfer(fmt.Sprintf("spam-%d", n), "sink", shardID, shardID, n, utils.Coins(1000), core.ChainID())
+		case spamOtherChain:
+			tx, err = transfer(a.Node.ID, "sink", shardID, shardID, n, 1, "other-chain")
+		case spamReplay:
+			tx = sent[int(n)%len(sent)]
+		case spamValid:
+			tx, err = transfer(a.Node.ID, "sink", shardID, shardID, n, 1, core.ChainID())
+		}
+		if err != nil {
+			continue
+		}
+		if kind == spamUnsigned {
+			tx.Signature = ""
+		}
+		if kind != spamValid && kind != spamReplay {
+			s.mu.Lock()
+			if s.invalid == nil {
+				s.invalid = make(map[string]bool)
+			}
+			s.invalid[tx.Hash] = true
+			s.mu.Unlock()
+		}
+		if kind == spamValid && len(sent) < 64 {
+			sent = append(sent, tx)
+		}
+
+		a.Node.BroadcastTransaction(tx)
+		a.record(s.Name())
+	}
+}
+
+// Invalid returns whether a transaction is one of the invalid transactions
+// the spammer sent
+func (s *SpamTransactions) Invalid(hash string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.invalid[hash]
+}
+
+// transfer builds a signed transfer between two shards, or within one; the
+// nonce keeps transfers distinct
+func transfer(from, to string, sourceShard, targetShard int, nonce uint64, amount utils.Amount, chainID string) (*core.Transaction, error) {
+	txType := core.RegularTransaction
+	if sourceShard != targetShard {
+		txType = core.CrossShardTransaction
+	}
+	tx, err := core.NewTransaction(from, to, amount, 0, sourceShard, targetShard, 0, txType)
+	if err != nil {
+		return nil, err
+	}
+	tx.ChainID = chainID
+	tx.Nonce = nonce
+	if tx.Hash, err = tx.CalculateHash(); err != nil {
+		return nil, err
+	}
+	if err := tx.Sign(from); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// InvalidRelayBlocks has a relay propose a relay block carrying a transfer
+// that is not cross-shard every Interval, and sign a vote for it. The relay
+// block and the vote are handed to every other node the way POST
+// /relay/blocks and POST /relay/votes would.
+type InvalidRelayBlocks struct {
+	passive
+	Interval time.Duration
+
+	proposed map[string]bool // IDs of the relay blocks proposed
+	mu       sync.Mutex
+}
+
+func (*InvalidRelayBlocks) Name() string {
+	return "invalid-relay-blocks"
+}
+
+func (r *InvalidRelayBlocks) Run(a *Adversary, stop <-chan struct{}) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	shardID := a.Node.Config.ShardID
+	targetShard := (shardID + 1) % a.Node.Config.ShardCount
+	for n := uint64(1); ; n++ {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		tx, err := transfer(a.Node.ID, "sink", shardID, shardID, n, 1, core.ChainID())
+		if err != nil {
+			continue
+		}
+		relayBlock := &core.RelayBlock{
+			ID:            fmt.Sprintf("relay_%d_%d", targetShard, time.Now().UnixNano()),
+			Timestamp:     time.Now().Unix(),
+			CrossShardTxs: []*core.Transaction{tx},
+			SourceShards:  []int{shardID},
+			TargetShards:  []int{targetShard},
+			CreatedBy:     a.Node.ID,
+		}
+		relayBlock.Hash = relayBlock.CalculateHash()
+		if err := relayBlock.Sign(a.Node.ID); err != nil {
+			continue
+		}
+		vote, err := core.NewRelayVote(relayBlock, a.Node.ID, a.Node.ID)
+		if err != nil {
+			continue
+		}
+		// Signed with the relay's own key, the vote is proof against it
+		if err := vote.SignWithKey(a.Node.Config.ValidatorKey); err != nil {
+			continue
+		}
+
+		r.mu.Lock()
+		if r.proposed == nil {
+			r.proposed = make(map[string]bool)
+		}
+		r.proposed[relayBlock.ID] = true
+		r.mu.Unlock()
+
+		for _, node := range a.Cluster.Nodes {
+			if node == a.Node {
+				continue
+			}
+			relayConsensus := node.ShardManager.GetRelayConsensus()
+			if err := relayConsensus.SubmitRelayBlock(relayBlock); err != nil {
+				continue
+			}
+			// Honest relays refuse the vote, and report it
+			relayConsensus.SubmitRelayVote(vote)
+			a.record(r.Name())
+		}
+	}
+}
+
+// Proposed returns whether a relay block is one the relay proposed
+func (r *InvalidRelayBlocks) Proposed(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.proposed[id]
+}
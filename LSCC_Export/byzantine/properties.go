@@ -0,0 +1,152 @@
+package byzantine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"lscc/core"
+	"lscc/network"
+)
+
+// Property kinds
+const (
+	Safety         = "safety"         // Nothing bad happens to the honest nodes
+	Liveness       = "liveness"       // Something good still happens
+	Accountability = "accountability" // The adversary is caught
+)
+
+// Property is a checked property of a scenario
+type Property struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Holds  bool   `json:"holds"`
+	Detail string `json:"detail"`
+}
+
+// holds returns a property that holds
+func holds(name, kind, format string, args ...interface{}) Property {
+	return Property{Name: name, Kind: kind, Holds: true, Detail: fmt.Sprintf(format, args...)}
+}
+
+// violated returns a property that does not hold
+func violated(name, kind, format string, args ...interface{}) Property {
+	return Property{Name: name, Kind: kind, Detail: fmt.Sprintf(format, args...)}
+}
+
+// byShard groups nodes by their shard
+func byShard(nodes []*network.Node) map[int][]*network.Node {
+	shards := make(map[int][]*network.Node)
+	for _, node := range nodes {
+		shards[node.Config.ShardID] = append(shards[node.Config.ShardID], node)
+	}
+	return shards
+}
+
+// checkAgreement checks that the nodes of each shard have the same block
+// at every height they all have
+func checkAgreement(nodes []*network.Node) Property {
+	const name = "agreement"
+	blocks := 0
+	for shardID, members := range byShard(nodes) {
+		height := members[0].Blockchain.GetHeight()
+		for _, node := range members[1:] {
+			if h := node.Blockchain.GetHeight(); h < height {
+				height = h
+			}
+		}
+		for h := uint64(1); h <= height; h++ {
+			first, err := members[0].Blockchain.GetBlockByHeight(h).Hash()
+			if err != nil {
+				return violated(name, Safety, "%s has no hash for its block at height %d", members[0].ID, h)
+			}
+			for _, node := range members[1:] {
+				hash, err := node.Blockchain.GetBlockByHeight(h).Hash()
+				if err != nil || hash != first {
+					return violated(name, Safety, "shard %d: %s and %s have different blocks at height %d",
+						shardID, members[0].ID, node.ID, h)
+				}
+			}
+		}
+		blocks += int(height)
+	}
+	return holds(name, Safety, "honest nodes agree on all %d blocks they share", blocks)
+}
+
+// heights returns the chain height of each node
+func heights(nodes []*network.Node) map[string]uint64 {
+	heights := make(map[string]uint64, len(nodes))
+	for _, node := range nodes {
+		heights[node.ID] = node.Blockchain.GetHeight()
+	}
+	return heights
+}
+
+// checkProgress checks that every node's chain grew by at least min blocks
+// since start
+func checkProgress(nodes []*network.Node, start map[string]uint64, min uint64) Property {
+	const name = "progress"
+	var slowest string
+	var least uint64
+	for _, node := range nodes {
+		grown := node.Blockchain.GetHeight() - start[node.ID]
+		if slowest == "" || grown < least {
+			slowest, least = node.ID, grown
+		}
+	}
+	if least < min {
+		return violated(name, Liveness, "%s added %d blocks, fewer than %d", slowest, least, min)
+	}
+	return holds(name, Liveness, "every honest node added at least %d blocks", least)
+}
+
+// included returns the hashes of the transactions in a node's chain
+func included(node *network.Node) map[string]bool {
+	hashes := make(map[string]bool)
+	for h := uint64(1); h <= node.Blockchain.GetHeight(); h++ {
+		block := node.Blockchain.GetBlockByHeight(h)
+		if block == nil {
+			continue
+		}
+		for _, tx := range block.Transactions {
+			hashes[tx.Hash] = true
+		}
+	}
+	return hashes
+}
+
+// checkExcluded checks that no node's chain includes a transaction the
+// adversary sent invalid
+func checkExcluded(nodes []*network.Node, invalid func(hash string) bool) Property {
+	const name = "no-invalid-transactions"
+	for _, node := range nodes {
+		for hash := range included(node) {
+			if invalid(hash) {
+				return violated(name, Safety, "%s included invalid transaction %s", node.ID, hash)
+			}
+		}
+	}
+	return holds(name, Safety, "no honest chain includes an invalid transaction")
+}
+
+// checkIncluded checks that the chains of every node of each transaction's
+// shard include it
+func checkIncluded(nodes []*network.Node, txs []*core.Transaction) Property {
+	const name = "transfers-committed"
+	shards := byShard(nodes)
+	var missing []string
+	for _, node := range nodes {
+		hashes := included(node)
+		for _, tx := range txs {
+			if tx.SourceShard == node.Config.ShardID && !hashes[tx.Hash] {
+				missing = append(missing, node.ID)
+				break
+			}
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return violated(name, Liveness, "%s miss honest transfers", strings.Join(missing, ", "))
+	}
+	return holds(name, Liveness, "all %d honest transfers committed in %d shards", len(txs), len(shards))
+}
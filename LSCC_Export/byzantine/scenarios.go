@@ -0,0 +1,391 @@
+package byzantine
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"lscc/config"
+	"lscc/consensus"
+	"lscc/core"
+	"lscc/network"
+	"lscc/simnet"
+	"lscc/utils"
+)
+
+// Options configure a scenario run
+type Options struct {
+	Duration time.Duration // How long the adversary misbehaves before properties are checked
+	Seed     int64         // Seed of the simulated network's faults
+}
+
+// Report is the outcome of a scenario
+type Report struct {
+	Scenario   string         `json:"scenario"`
+	Adversary  string         `json:"adversary"`
+	Properties []Property     `json:"properties"`
+	Actions    map[string]int `json:"actions"` // Misbehaviors by behavior
+	Network    simnet.Stats   `json:"network"`
+	Duration   float64        `json:"duration_seconds"`
+}
+
+// Passed reports whether the adversary misbehaved and every property held.
+// A behavior that never misbehaved means the scenario tested nothing.
+func (r *Report) Passed() bool {
+	for _, count := range r.Actions {
+		if count == 0 {
+			return false
+		}
+	}
+	for _, property := range r.Properties {
+		if !property.Holds {
+			return false
+		}
+	}
+	return true
+}
+
+// Scenario is a cluster with a corrupted node and the properties its
+// honest nodes must keep
+type Scenario struct {
+	Name        string
+	Description string
+	run         func(base *config.Config, opts Options, report *Report) error
+}
+
+// scenarios are all scenarios, in the order they run
+var scenarios = []Scenario{
+	{
+		Name:        "equivocating-primary",
+		Description: "A PBFT primary sends conflicting blocks to different replicas",
+		run:         runEquivocatingPrimary,
+	},
+	{
+		Name:        "withholding-primary",
+		Description: "A PBFT member never passes blocks on, including its own proposals",
+		run:         runWithholdingPrimary,
+	},
+	{
+		Name:        "delayed-votes",
+		Description: "A PBFT member sends its prepares and commits after the view change timeout",
+		run:         runDelayedVotes,
+	},
+	{
+		Name:        "tx-spammer",
+		Description: "A node floods its peers with invalid, replayed and dust transactions",
+		run:         runTxSpammer,
+	},
+	{
+		Name:        "invalid-relay",
+		Description: "A relay proposes and signs relay blocks carrying transfers that are not cross-shard",
+		run:         runInvalidRelay,
+	},
+}
+
+// Scenarios returns all scenarios
+func Scenarios() []Scenario {
+	return append([]Scenario(nil), scenarios...)
+}
+
+// Run runs a scenario on a new cluster started from base
+func Run(base *config.Config, name string, opts Options) (*Report, error) {
+	for _, scenario := range scenarios {
+		if scenario.Name != name {
+			continue
+		}
+		if opts.Duration <= 0 {
+			return nil, fmt.Errorf("duration must be positive")
+		}
+		report := &Report{Scenario: name}
+		start := time.Now()
+		if err := scenario.run(base, opts, report); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		report.Duration = time.Since(start).Seconds()
+		return report, nil
+	}
+	return nil, fmt.Errorf("unknown scenario %q", name)
+}
+
+// viewChangeTimeout is the PBFT view change timeout of scenario clusters,
+// in seconds
+const viewChangeTimeout = 3
+
+// startCluster starts a PBFT cluster on a simulated network with a little
+// latency and jitter
+func startCluster(base *config.Config, opts Options, nodes, shards int) (*simnet.Cluster, error) {
+	net := simnet.NewNetwork(opts.Seed)
+	net.SetDefaultLink(simnet.Link{Latency: 10 * time.Millisecond, Jitter: 5 * time.Millisecond})
+	return simnet.StartCluster(base, simnet.Topology{
+		Nodes:             nodes,
+		Shards:            shards,
+		ConsensusType:     string(consensus.PBFT),
+		BlockTime:         1,
+		ViewChangeTimeout: viewChangeTimeout,
+		Accounts:          10,
+		Balance:           utils.Coins(1000),
+		Network:           net,
+	})
+}
+
+// honest returns the nodes of a cluster other than the adversary
+func honest(cluster *simnet.Cluster, adversary *Adversary) []*network.Node {
+	var nodes []*network.Node
+	for _, node := range cluster.Nodes {
+		if node != adversary.Node {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// minProgress is the number of blocks every honest node must add while a
+// scenario runs, given its duration: a quarter of the blocks an unhindered
+// cluster would make, and at least one
+func minProgress(opts Options) uint64 {
+	if blocks := uint64(opts.Duration/time.Second) / 4; blocks > 0 {
+		return blocks
+	}
+	return 1
+}
+
+// finish stops the adversary and fills in what a report always has
+func finish(report *Report, cluster *simnet.Cluster, adversary *Adversary) {
+	adversary.Stop()
+	report.Adversary = adversary.Node.ID
+	report.Actions = adversary.Actions()
+	report.Network = cluster.Network.Stats()
+}
+
+// runConsensusAttack corrupts the first node of a four-node PBFT shard with
+// a behavior and checks the others agree and make progress
+func runConsensusAttack(base *config.Config, opts Options, report *Report, behavior Behavior) error {
+	cluster, err := startCluster(base, opts, 4, 1)
+	if err != nil {
+		return err
+	}
+	defer cluster.Stop()
+
+	adversary, err := Corrupt(cluster, simnet.NodeName(0), behavior)
+	if err != nil {
+		return err
+	}
+	nodes := honest(cluster, adversary)
+	start := heights(nodes)
+	time.Sleep(opts.Duration)
+
+	finish(report, cluster, adversary)
+	report.Properties = append(report.Properties,
+		checkAgreement(nodes),
+		checkProgress(nodes, start, minProgress(opts)))
+	return nil
+}
+
+func runEquivocatingPrimary(base *config.Config, opts Options, report *Report) error {
+	return runConsensusAttack(base, opts, report, &EquivocatingProposer{})
+}
+
+func runWithholdingPrimary(base *config.Config, opts Options, report *Report) error {
+	return runConsensusAttack(base, opts, report, WithholdBlocks{})
+}
+
+func runDelayedVotes(base *config.Config, opts Options, report *Report) error {
+	return runConsensusAttack(base, opts, report, DelayVotes{Delay: (viewChangeTimeout + 2) * time.Second})
+}
+
+// runTxSpammer has a node of a four-node PBFT shard spam its peers while
+// the honest nodes take a transfer a second from the funded accounts
+func runTxSpammer(base *config.Config, opts Options, report *Report) error {
+	cluster, err := startCluster(base, opts, 4, 1)
+	if err != nil {
+		return err
+	}
+	defer cluster.Stop()
+
+	spammer := &SpamTransactions{Rate: 200}
+	adversary, err := Corrupt(cluster, simnet.NodeName(3), spammer)
+	if err != nil {
+		return err
+	}
+	nodes := honest(cluster, adversary)
+	start := heights(nodes)
+
+	var txs []*core.Transaction
+	deadline := time.Now().Add(opts.Duration)
+	for i := 0; time.Now().Before(deadline); i++ {
+		from := simnet.AccountName(0, i%10)
+		to := simnet.AccountName(0, (i+1)%10)
+		tx, err := transfer(from, to, 0, 0, uint64(i+1), utils.Coins(1), core.ChainID())
+		if err != nil {
+			return err
+		}
+		if err := nodes[i%len(nodes)].SubmitTransaction(tx); err != nil {
+			return fmt.Errorf("honest transfer refused: %w", err)
+		}
+		txs = append(txs, tx)
+		time.Sleep(time.Second)
+	}
+
+	// Give the last transfers time to commit
+	simnet.WaitFor(time.Duration(viewChangeTimeout*3)*time.Second, func() bool {
+		return checkIncluded(nodes, txs).Holds
+	})
+
+	finish(report, cluster, adversary)
+	report.Properties = append(report.Properties,
+		checkExcluded(nodes, spammer.Invalid),
+		checkAgreement(nodes),
+		checkIncluded(nodes, txs),
+		checkProgress(nodes, start, minProgress(opts)))
+	return nil
+}
+
+// runInvalidRelay corrupts the relay of the first of three shards, waits
+// for the honest nodes to slash it, and checks honest cross-shard transfers
+// are still finalized by the two relays left
+func runInvalidRelay(base *config.Config, opts Options, report *Report) error {
+	cluster, err := startCluster(base, opts, 6, 3)
+	if err != nil {
+		return err
+	}
+	defer cluster.Stop()
+
+	relay := &InvalidRelayBlocks{Interval: time.Second}
+	adversary, err := Corrupt(cluster, simnet.NodeName(0), relay)
+	if err != nil {
+		return err
+	}
+	nodes := honest(cluster, adversary)
+	start := heights(nodes)
+
+	// Watch what the honest nodes finalize
+	finalized := &relayWatch{invalid: make(map[string]string), finalized: make(map[string]bool)}
+	stop := make(chan struct{})
+	var watchers sync.WaitGroup
+	for _, node := range nodes {
+		sub := node.ShardManager.Events().Subscribe("byzantine", 1024, core.EventRelayBlockFinalized)
+		watchers.Add(1)
+		go func(node *network.Node, sub *core.Subscription) {
+			defer watchers.Done()
+			finalized.watch(node.ID, sub, relay.Proposed, stop)
+		}(node, sub)
+	}
+	defer func() {
+		close(stop)
+		watchers.Wait()
+	}()
+
+	deadline := time.Now().Add(opts.Duration)
+	simnet.WaitFor(opts.Duration, func() bool {
+		return checkSlashed(nodes, adversary.Node.ID).Holds
+	})
+	slashed := checkSlashed(nodes, adversary.Node.ID)
+
+	// One relay block's worth of transfers from the second shard to the
+	// third, taken by a node of the second shard
+	var txs []*core.Transaction
+	sender := cluster.NodesOfShard(1)[len(cluster.NodesOfShard(1))-1]
+	for i := 0; i < 5; i++ {
+		tx, err := transfer(simnet.AccountName(1, i), simnet.AccountName(2, i), 1, 2, uint64(i+1), utils.Coins(1), core.ChainID())
+		if err != nil {
+			return err
+		}
+		if err := sender.SubmitTransaction(tx); err != nil {
+			return fmt.Errorf("honest transfer refused: %w", err)
+		}
+		txs = append(txs, tx)
+	}
+	simnet.WaitFor(time.Duration(viewChangeTimeout*3)*time.Second, func() bool {
+		return finalized.carried(sender.ID, txs)
+	})
+	// Keep the slashed relay at it for the rest of the run
+	time.Sleep(time.Until(deadline))
+
+	finish(report, cluster, adversary)
+	report.Properties = append(report.Properties,
+		finalized.check(),
+		slashed,
+		checkFinalized(finalized, sender.ID, txs),
+		checkProgress(nodes, start, minProgress(opts)))
+	return nil
+}
+
+// relayWatch collects the relay blocks honest nodes finalize
+type relayWatch struct {
+	invalid   map[string]string // Why a finalized relay block is invalid, by node and ID
+	finalized map[string]bool   // Finalized transactions by node and hash
+	mu        sync.Mutex
+}
+
+// watch records the relay blocks a node finalizes until stop is closed
+func (w *relayWatch) watch(nodeID string, sub *core.Subscription, proposed func(id string) bool, stop <-chan struct{}) {
+	defer sub.Unsubscribe()
+	for {
+		select {
+		case <-stop:
+			return
+		case event := <-sub.Events():
+			relayBlock := event.RelayBlock
+			w.mu.Lock()
+			if err := relayBlock.Validate(); err != nil {
+				w.invalid[nodeID+" "+relayBlock.ID] = err.Error()
+			} else if proposed(relayBlock.ID) {
+				w.invalid[nodeID+" "+relayBlock.ID] = "proposed by the adversary"
+			}
+			for _, tx := range relayBlock.CrossShardTxs {
+				w.finalized[nodeID+" "+tx.Hash] = true
+			}
+			w.mu.Unlock()
+		}
+	}
+}
+
+// carried reports whether a node finalized relay blocks carrying all txs
+func (w *relayWatch) carried(nodeID string, txs []*core.Transaction) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, tx := range txs {
+		if !w.finalized[nodeID+" "+tx.Hash] {
+			return false
+		}
+	}
+	return true
+}
+
+// check checks that no honest node finalized an invalid relay block
+func (w *relayWatch) check() Property {
+	const name = "no-invalid-relay-blocks"
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.invalid) > 0 {
+		keys := make([]string, 0, len(w.invalid))
+		for key := range w.invalid {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return violated(name, Safety, "finalized %s: %s", keys[0], w.invalid[keys[0]])
+	}
+	return holds(name, Safety, "no honest node finalized an invalid relay block")
+}
+
+// checkFinalized checks that a node finalized relay blocks carrying txs
+func checkFinalized(w *relayWatch, nodeID string, txs []*core.Transaction) Property {
+	const name = "relay-blocks-finalized"
+	if !w.carried(nodeID, txs) {
+		return violated(name, Liveness, "%s did not finalize the honest cross-shard transfers", nodeID)
+	}
+	return holds(name, Liveness, "%s finalized all %d honest cross-shard transfers", nodeID, len(txs))
+}
+
+// checkSlashed checks that every node has slashed a relay
+func checkSlashed(nodes []*network.Node, relay string) Property {
+	const name = "relay-slashed"
+	for _, node := range nodes {
+		if !node.ShardManager.GetRelayConsensus().IsRelaySlashed(relay) {
+			return violated(name, Accountability, "%s has not slashed %s", node.ID, relay)
+		}
+	}
+	return holds(name, Accountability, "every honest node slashed %s", relay)
+}
@@ -0,0 +1,44 @@
+package byzantine
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"lscc/config"
+	"lscc/utils"
+)
+
+func TestMain(m *testing.M) {
+	utils.GetLogger().SetLevel("", utils.LogLevelError)
+	os.Exit(m.Run())
+}
+
+// TestScenarios runs every scenario and fails on any violated property, or
+// on an adversary that never got to misbehave
+func TestScenarios(t *testing.T) {
+	base := config.DefaultConfig()
+	base.Tracing.CollectorURL = ""
+
+	for _, scenario := range Scenarios() {
+		t.Run(scenario.Name, func(t *testing.T) {
+			report, err := Run(base, scenario.Name, Options{Duration: 10 * time.Second, Seed: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for behavior, count := range report.Actions {
+				if count == 0 {
+					t.Errorf("%s never misbehaved", behavior)
+				}
+			}
+			for _, property := range report.Properties {
+				if !property.Holds {
+					t.Errorf("%s %s violated: %s", property.Kind, property.Name, property.Detail)
+				}
+			}
+			if !report.Passed() {
+				t.Fatalf("scenario failed against adversary %s", report.Adversary)
+			}
+		})
+	}
+}
@@ -27,7 +27,7 @@ func NewCLI(node *network.Node, shardManager *sharding.Manager) *CLI {
         return &CLI{
                 node:         node,
                 shardManager: shardManager,
-                logger:       utils.GetLogger(),
+                logger:       utils.GetLogger().Named("cli"),
         }
 }
 
@@ -39,8 +39,8 @@ func (cli *CLI) Run() {
         createTxCmd := flag.NewFlagSet("createtx", flag.ExitOnError)
         createTxFrom := createTxCmd.String("from", "", "Sender address")
         createTxTo := createTxCmd.String("to", "", "Recipient address")
-        createTxAmount := createTxCmd.Float64("amount", 0.0, "Amount to send")
-        createTxFee := createTxCmd.Float64("fee", 0.001, "Transaction fee")
+        createTxAmount := utils.AmountFlag(createTxCmd, "amount", 0, "Amount to send")
+        createTxFee := utils.AmountFlag(createTxCmd, "fee", utils.MustParseAmount("0.001"), "Transaction fee")
         createTxShard := createTxCmd.Int("shard", -1, "Target shard (default: auto-assign)")
         
         getBlockCmd := flag.NewFlagSet("getblock", flag.ExitOnError)
@@ -126,7 +126,7 @@ func (cli *CLI) showStatus() {
 }
 
 // createTransaction creates a new transaction
-func (cli *CLI) createTransaction(from, to string, amount, fee float64, targetShard int) {
+func (cli *CLI) createTransaction(from, to string, amount, fee core.Amount, targetShard int) {
         if from == "" || to == "" || amount <= 0 {
                 fmt.Println("Error: Sender, recipient, and amount are required")
                 return
@@ -176,9 +176,7 @@ func (cli *CLI) createTransaction(from, to string, amount, fee float64, targetSh
                 return
         }
         
-        // Broadcast the transaction
-        cli.node.BroadcastTransaction(tx)
-        
+        // The node relays accepted transactions to its peers
         fmt.Printf("Transaction created and broadcast: %s\n", tx.Hash)
         if tx.IsCrossShard() {
                 fmt.Printf("Cross-shard transaction: Shard %d -> Shard %d\n", sourceShard, targetShard)
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"lscc/core"
+	"lscc/utils"
+)
+
+// channelInfo mirrors the node's GET /channels/{id} response
+type channelInfo struct {
+	Channel      *core.PaymentChannel `json:"channel"`
+	LatestUpdate *core.ChannelUpdate  `json:"latest_update"`
+}
+
+func runChannel(args []string) {
+	if len(args) < 1 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	fs, port := newFlagSet("channel " + args[0])
+	id := fs.String("id", "", "Channel ID")
+	from := fs.String("from", "", "Account submitting the transaction")
+	to := fs.String("to", "", "Counterparty address")
+	deposit := utils.AmountFlag(fs, "deposit", 0, "Amount locked in the channel")
+	seq := fs.Uint64("seq", 0, "Sequence number of the update")
+	balanceA := utils.AmountFlag(fs, "balance-a", 0, "Party A balance in the update")
+	balanceB := utils.AmountFlag(fs, "balance-b", 0, "Party B balance in the update")
+	keyA := fs.String("key-a", "", "Party A signing key (default: party A address)")
+	keyB := fs.String("key-b", "", "Party B signing key (default: party B address)")
+	key := fs.String("key", "", "Signing key (default: the -from address)")
+	fee := utils.AmountFlag(fs, "fee", utils.MustParseAmount("0.01"), "Transaction fee")
+	fs.Parse(args[1:])
+
+	c := newClient(*port)
+
+	switch args[0] {
+	case "open":
+		if *from == "" || *to == "" || *deposit <= 0 {
+			fmt.Println("Usage: lscc-cli channel open -from Alice -to Bob -deposit 100")
+			os.Exit(1)
+		}
+		tx, err := core.NewChannelOpenTransaction(*from, *to, *deposit, *fee, c.shardID())
+		if err != nil {
+			fail("Failed to create channel:", err)
+		}
+		c.submit("/channels/open", tx, keyOrAddress(*key, *from))
+		fmt.Println("Channel ID:", tx.Hash)
+
+	case "update":
+		info := c.channel(*id)
+		update := &core.ChannelUpdate{
+			ChannelID: *id,
+			Sequence:  *seq,
+			BalanceA:  *balanceA,
+			BalanceB:  *balanceB,
+		}
+		if err := update.Sign(info.Channel, info.Channel.PartyA, keyOrAddress(*keyA, info.Channel.PartyA)); err != nil {
+			fail("Failed to sign update:", err)
+		}
+		if err := update.Sign(info.Channel, info.Channel.PartyB, keyOrAddress(*keyB, info.Channel.PartyB)); err != nil {
+			fail("Failed to sign update:", err)
+		}
+		if err := c.do(http.MethodPost, "/channels/update", update, nil); err != nil {
+			fail("Update rejected:", err)
+		}
+		fmt.Printf("Channel %s updated to sequence %d\n", *id, *seq)
+
+	case "close", "dispute":
+		if *from == "" {
+			fmt.Printf("Usage: lscc-cli channel %s -id ID -from Alice\n", args[0])
+			os.Exit(1)
+		}
+		info := c.channel(*id)
+		update := info.LatestUpdate
+		if update == nil {
+			// Close with the opening state
+			update = &core.ChannelUpdate{ChannelID: *id}
+		}
+
+		var tx *core.Transaction
+		var err error
+		if args[0] == "close" {
+			tx, err = core.NewChannelCloseTransaction(*from, update, *fee, c.shardID())
+		} else {
+			tx, err = core.NewChannelDisputeTransaction(*from, update, *fee, c.shardID())
+		}
+		if err != nil {
+			fail("Failed to create transaction:", err)
+		}
+		c.submit("/channels/"+args[0], tx, keyOrAddress(*key, *from))
+		fmt.Printf("Channel %s %s submitted with sequence %d\n", *id, args[0], update.Sequence)
+
+	case "settle":
+		if *from == "" || *id == "" {
+			fmt.Println("Usage: lscc-cli channel settle -id ID -from Alice")
+			os.Exit(1)
+		}
+		tx, err := core.NewChannelSettleTransaction(*from, *id, *fee, c.shardID())
+		if err != nil {
+			fail("Failed to create transaction:", err)
+		}
+		c.submit("/channels/settle", tx, keyOrAddress(*key, *from))
+
+	case "show":
+		printJSON(c.channel(*id))
+
+	default:
+		fmt.Println("Unknown channel command:", args[0])
+		printUsage()
+		os.Exit(1)
+	}
+}
+
+// channel fetches a channel's record and latest off-chain update
+func (c *client) channel(id string) *channelInfo {
+	if id == "" {
+		fmt.Println("Error: -id is required")
+		os.Exit(1)
+	}
+
+	var info channelInfo
+	if err := c.do(http.MethodGet, "/channels/"+id, nil, &info); err != nil {
+		fail("Failed to fetch channel:", err)
+	}
+	return &info
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"lscc/core"
+	"lscc/utils"
+	"lscc/vm"
+)
+
+func runContract(args []string) {
+	if len(args) < 1 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	fs, port := newFlagSet("contract " + args[0])
+	from := fs.String("from", "", "Account sending the transaction")
+	address := fs.String("address", "", "Contract address")
+	method := fs.String("method", "", "Method to call")
+	callArgs := fs.String("args", "", "Comma-separated call arguments (integers, 0x hex or text)")
+	file := fs.String("file", "", "Contract assembly source")
+	code := fs.String("hex", "", "Hex-encoded contract code")
+	txHash := fs.String("tx", "", "Transaction hash")
+	id := fs.String("id", "", "Message ID")
+	shard := fs.Int("shard", -1, "Shard to inspect (default: the node's shard)")
+	gas := fs.Uint64("gas", 100000, "Gas limit")
+	value := utils.AmountFlag(fs, "value", 0, "Amount to attach")
+	fee := utils.AmountFlag(fs, "fee", 0, "Transaction fee (default: the gas limit at the chain's gas price)")
+	key := fs.String("key", "", "Signing key (default: sender address)")
+	fs.Parse(args[1:])
+
+	c := newClient(*port)
+	switch args[0] {
+	case "deploy":
+		if *from == "" || (*file == "") == (*code == "") {
+			fmt.Println("Usage: lscc-cli contract deploy -from Alice -file counter.asm [-args 1,2] [-value 5]")
+			os.Exit(1)
+		}
+		tx, err := core.NewContractDeployTransaction(*from, loadCode(*file, *code), parseArgs(*callArgs), *gas, *value, c.gasFee(*fee, *gas), c.shardID())
+		if err != nil {
+			fail("Failed to create deploy:", err)
+		}
+		c.submit("/send", tx, keyOrAddress(*key, *from))
+		fmt.Println("Contract address:", core.ContractAddress(tx.Hash))
+
+	case "call":
+		if *from == "" || *address == "" || *method == "" {
+			fmt.Println("Usage: lscc-cli contract call -from Alice -address ADDR -method increment [-args 1,2] [-value 5]")
+			os.Exit(1)
+		}
+		tx, err := core.NewContractCallTransaction(*from, *address, *method, parseArgs(*callArgs), *gas, *value, c.gasFee(*fee, *gas), c.shardID())
+		if err != nil {
+			fail("Failed to create call:", err)
+		}
+		c.submit("/send", tx, keyOrAddress(*key, *from))
+
+	case "query":
+		if *address == "" || *method == "" {
+			fmt.Println("Usage: lscc-cli contract query -address ADDR -method get [-args 1,2] [-from Alice]")
+			os.Exit(1)
+		}
+		query := map[string]interface{}{
+			"contract":  *address,
+			"caller":    *from,
+			"method":    *method,
+			"args":      parseArgs(*callArgs),
+			"gas_limit": *gas,
+		}
+		var receipt core.ContractReceipt
+		if err := c.do(http.MethodPost, "/contracts/query", query, &receipt); err != nil {
+			fail("Query failed:", err)
+		}
+		printJSON(receipt)
+
+	case "show":
+		if *address == "" {
+			fmt.Println("Usage: lscc-cli contract show -address ADDR")
+			os.Exit(1)
+		}
+		var contract map[string]interface{}
+		if err := c.do(http.MethodGet, "/contracts/"+*address, nil, &contract); err != nil {
+			fail("Failed to fetch contract:", err)
+		}
+		printJSON(contract)
+
+	case "list":
+		var contracts map[string]interface{}
+		if err := c.do(http.MethodGet, "/contracts", nil, &contracts); err != nil {
+			fail("Failed to list contracts:", err)
+		}
+		printJSON(contracts)
+
+	case "receipt":
+		if *txHash == "" {
+			fmt.Println("Usage: lscc-cli contract receipt -tx HASH")
+			os.Exit(1)
+		}
+		var receipt core.ContractReceipt
+		if err := c.do(http.MethodGet, "/receipts/"+*txHash, nil, &receipt); err != nil {
+			fail("Failed to fetch receipt:", err)
+		}
+		printJSON(receipt)
+
+	case "messages":
+		var messages map[string]interface{}
+		if err := c.do(http.MethodGet, "/messages"+shardQuery(*shard), nil, &messages); err != nil {
+			fail("Failed to list messages:", err)
+		}
+		printJSON(messages)
+
+	case "message":
+		if *id == "" {
+			fmt.Println("Usage: lscc-cli contract message -id ID [-shard N]")
+			os.Exit(1)
+		}
+		var message map[string]interface{}
+		if err := c.do(http.MethodGet, "/messages/"+*id+shardQuery(*shard), nil, &message); err != nil {
+			fail("Failed to fetch message:", err)
+		}
+		printJSON(message)
+
+	case "asm":
+		if (*file == "") == (*code == "") {
+			fmt.Println("Usage: lscc-cli contract asm -file counter.asm | -hex CODE")
+			os.Exit(1)
+		}
+		if *file != "" {
+			fmt.Println(hex.EncodeToString(loadCode(*file, "")))
+			return
+		}
+		listing, err := vm.Disassemble(loadCode("", *code))
+		if err != nil {
+			fail("Invalid code:", err)
+		}
+		fmt.Print(listing)
+
+	default:
+		fmt.Println("Unknown contract command:", args[0])
+		printUsage()
+		os.Exit(1)
+	}
+}
+
+// loadCode assembles a source file or decodes hex-encoded code
+func loadCode(file, code string) []byte {
+	if file != "" {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			fail("Failed to read source:", err)
+		}
+		assembled, err := vm.Assemble(string(source))
+		if err != nil {
+			fail("Failed to assemble "+file+":", err)
+		}
+		return assembled
+	}
+
+	decoded, err := hex.DecodeString(strings.TrimPrefix(code, "0x"))
+	if err != nil {
+		fail("Invalid code:", err)
+	}
+	return decoded
+}
+
+// shardQuery returns the ?shard=N parameter selecting a shard, or nothing
+// for the node's own shard
+func shardQuery(shard int) string {
+	if shard < 0 {
+		return ""
+	}
+	return "?shard=" + strconv.Itoa(shard)
+}
+
+// parseArgs splits comma-separated call arguments into VM values
+func parseArgs(text string) []vm.Value {
+	if text == "" {
+		return nil
+	}
+
+	var values []vm.Value
+	for _, field := range strings.Split(text, ",") {
+		value, err := vm.ParseValue(strings.TrimSpace(field))
+		if err != nil {
+			fail("Invalid argument "+field+":", err)
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// gasFee returns fee, or the cost of the gas limit at the node's gas price
+// when no fee was given
+func (c *client) gasFee(fee utils.Amount, gasLimit uint64) utils.Amount {
+	if fee > 0 {
+		return fee
+	}
+
+	var status struct {
+		GasPrice utils.Amount `json:"gas_price"`
+	}
+	if err := c.do(http.MethodGet, "/status", nil, &status); err != nil {
+		fail("Failed to query node status:", err)
+	}
+	cost, err := status.GasPrice.MulDiv(int64(gasLimit), 1)
+	if err != nil {
+		fail("Gas limit too high:", err)
+	}
+	return cost
+}
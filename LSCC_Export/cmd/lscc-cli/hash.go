@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"lscc/core"
+)
+
+// hashVector is a test vector of the canonical encoding: a transaction or
+// block with its expected payload and hash
+type hashVector struct {
+	Name        string            `json:"name"`
+	Transaction *core.Transaction `json:"transaction,omitempty"`
+	Block       *core.Block       `json:"block,omitempty"`
+	Payload     string            `json:"payload"` // Hex canonical encoding
+	Hash        string            `json:"hash"`
+}
+
+// compute returns the canonical payload and hash of the vector's object
+func (v *hashVector) compute() ([]byte, string, error) {
+	switch {
+	case v.Transaction != nil:
+		hash, err := v.Transaction.CalculateHash()
+		return v.Transaction.SigningPayload(), hash, err
+	case v.Block != nil:
+		hash, err := v.Block.Hash()
+		return v.Block.HeaderPayload(), hash, err
+	}
+	return nil, "", errors.New("vector has neither a transaction nor a block")
+}
+
+func runHash(args []string) {
+	fs, _ := newFlagSet("hash")
+	txFile := fs.String("tx", "", "Transaction JSON file to hash")
+	blockFile := fs.String("block", "", "Block JSON file to hash")
+	vectorsFile := fs.String("vectors", "", "Test vector file to check")
+	update := fs.Bool("update", false, "Rewrite the expected payloads and hashes in the vector file")
+	fs.Parse(args)
+
+	switch {
+	case *txFile != "":
+		var tx core.Transaction
+		readJSONFile(*txFile, &tx)
+		hash, _ := tx.CalculateHash()
+		printHash(tx.SigningPayload(), hash)
+	case *blockFile != "":
+		var block core.Block
+		readJSONFile(*blockFile, &block)
+		hash, _ := block.Hash()
+		printHash(block.HeaderPayload(), hash)
+	case *vectorsFile != "":
+		checkVectors(*vectorsFile, *update)
+	default:
+		fmt.Println("Usage: lscc-cli hash -tx FILE | -block FILE | -vectors FILE [-update]")
+		os.Exit(1)
+	}
+}
+
+// checkVectors recomputes every vector in a file and reports mismatches
+func checkVectors(path string, update bool) {
+	var vectors []*hashVector
+	readJSONFile(path, &vectors)
+
+	failed := 0
+	for _, v := range vectors {
+		payload, hash, err := v.compute()
+		if err != nil {
+			fail(fmt.Sprintf("Vector %q:", v.Name), err)
+		}
+		if update {
+			v.Payload = hex.EncodeToString(payload)
+			v.Hash = hash
+		}
+		if hex.EncodeToString(payload) != v.Payload || hash != v.Hash {
+			fmt.Printf("FAIL %s: got hash %s, want %s\n", v.Name, hash, v.Hash)
+			failed++
+			continue
+		}
+		fmt.Printf("ok   %s\n", v.Name)
+	}
+
+	if update {
+		data, err := json.MarshalIndent(vectors, "", "  ")
+		if err != nil {
+			fail("Failed to encode vectors:", err)
+		}
+		if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			fail("Failed to write vectors:", err)
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d vectors failed\n", failed, len(vectors))
+		os.Exit(1)
+	}
+}
+
+func printHash(payload []byte, hash string) {
+	fmt.Println("Payload:", hex.EncodeToString(payload))
+	fmt.Println("Hash:   ", hash)
+}
+
+// readJSONFile decodes a JSON file into v
+func readJSONFile(path string, v interface{}) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fail("Failed to read file:", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		fail("Failed to decode file:", err)
+	}
+}
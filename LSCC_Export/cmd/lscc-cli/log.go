@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// runLog shows or changes the log levels of the local node
+func runLog(args []string) {
+	if len(args) < 1 || args[0] != "level" {
+		fmt.Println("Usage: lscc-cli log level [-subsystem NAME] [-level LEVEL | -reset]")
+		os.Exit(1)
+	}
+
+	fs, port := newFlagSet("log level")
+	subsystem := fs.String("subsystem", "", "Subsystem to change (default: the default level)")
+	level := fs.String("level", "", "New level: error, warn, info, debug or trace")
+	reset := fs.Bool("reset", false, "Make the subsystem use the default level again")
+	fs.Parse(args[1:])
+
+	c := newClient(*port)
+	var levels map[string]interface{}
+	if *level == "" && !*reset {
+		if err := c.do(http.MethodGet, "/admin/log-level", nil, &levels); err != nil {
+			fail("Failed to query log levels:", err)
+		}
+		printJSON(levels)
+		return
+	}
+	if *reset && *subsystem == "" {
+		fail("Invalid flags:", fmt.Errorf("-reset needs -subsystem"))
+	}
+
+	req := map[string]string{"subsystem": *subsystem, "level": *level}
+	if *reset {
+		req["level"] = ""
+	}
+	if err := c.do(http.MethodPost, "/admin/log-level", req, &levels); err != nil {
+		fail("Failed to change log level:", err)
+	}
+	printJSON(levels)
+}
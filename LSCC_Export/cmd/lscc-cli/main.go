@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"lscc/core"
+	"lscc/utils"
+)
+
+// client talks to a node's REST API
+type client struct {
+	baseURL string
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	switch os.Args[1] {
+	case "send":
+		runSend(os.Args[2:])
+	case "balance":
+		runBalance(os.Args[2:])
+	case "tx":
+		runTx(os.Args[2:])
+	case "channel":
+		runChannel(os.Args[2:])
+	case "swap":
+		runSwap(os.Args[2:])
+	case "contract":
+		runContract(os.Args[2:])
+	case "stake", "unstake", "delegate":
+		runStake(os.Args[1], os.Args[2:])
+	case "validators":
+		runValidators(os.Args[2:])
+	case "supply":
+		runSupply(os.Args[2:])
+	case "hash":
+		runHash(os.Args[2:])
+	case "log":
+		runLog(os.Args[2:])
+	case "trace":
+		runTrace(os.Args[2:])
+	case "help":
+		printUsage()
+	default:
+		fmt.Println("Unknown command:", os.Args[1])
+		printUsage()
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	fmt.Println("Usage: lscc-cli COMMAND [flags]")
+	fmt.Println("  send -from ADDR -to ADDR -amount AMT [-fee FEE] [-key KEY]")
+	fmt.Println("  balance -address ADDR")
+	fmt.Println("  tx status -hash HASH [-shard N] [-json]")
+	fmt.Println("  tx show -hash HASH [-shard N]")
+	fmt.Println("  channel open -from ADDR -to ADDR -deposit AMT [-key KEY]")
+	fmt.Println("  channel update -id ID -seq N -balance-a AMT -balance-b AMT -key-a KEY -key-b KEY")
+	fmt.Println("  channel close -id ID -from ADDR [-key KEY]")
+	fmt.Println("  channel dispute -id ID -from ADDR [-key KEY]")
+	fmt.Println("  channel settle -id ID -from ADDR [-key KEY]")
+	fmt.Println("  channel show -id ID")
+	fmt.Println("  swap initiate -from ADDR -to ADDR -amount AMT -shard N -timeout BLOCKS [-key KEY]")
+	fmt.Println("  swap participate -id ID -amount AMT -shard N -timeout BLOCKS [-from ADDR] [-key KEY]")
+	fmt.Println("  swap claim -id ID -secret SECRET [-key KEY]")
+	fmt.Println("  swap refund -id ID")
+	fmt.Println("  swap show -id ID")
+	fmt.Println("  swap list")
+	fmt.Println("  contract deploy -from ADDR -file SRC | -hex CODE [-args A,B] [-value AMT] [-gas N] [-fee FEE] [-key KEY]")
+	fmt.Println("  contract call -from ADDR -address ADDR -method NAME [-args A,B] [-value AMT] [-gas N] [-fee FEE] [-key KEY]")
+	fmt.Println("  contract query -address ADDR -method NAME [-args A,B] [-from ADDR] [-gas N]")
+	fmt.Println("  contract show -address ADDR")
+	fmt.Println("  contract list")
+	fmt.Println("  contract receipt -tx HASH")
+	fmt.Println("  contract messages [-shard N]")
+	fmt.Println("  contract message -id ID [-shard N]")
+	fmt.Println("  contract asm -file SRC | -hex CODE")
+	fmt.Println("  stake -from ADDR -amount AMT [-key KEY]")
+	fmt.Println("  delegate -from ADDR -validator ADDR -amount AMT [-key KEY]")
+	fmt.Println("  unstake -from ADDR [-validator ADDR] -amount AMT [-key KEY]")
+	fmt.Println("  validators [-shard N] [-json]")
+	fmt.Println("  supply [-shard N]")
+	fmt.Println("  hash -tx FILE | -block FILE | -vectors FILE [-update]")
+	fmt.Println("  log level [-subsystem NAME] [-level LEVEL | -reset]")
+	fmt.Println("  trace -hash HASH [-file FILE,FILE] [-json]")
+	fmt.Println("All commands accept -port N (REST API port of the node, default 9000)")
+}
+
+// newFlagSet creates a flag set with the flags shared by all commands
+func newFlagSet(name string) (*flag.FlagSet, *int) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	port := fs.Int("port", 9000, "REST API port of the node")
+	return fs, port
+}
+
+func newClient(port int) *client {
+	return &client{baseURL: fmt.Sprintf("http://localhost:%d", port)}
+}
+
+// do sends a request and decodes the JSON response into out
+func (c *client) do(method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s: %s", resp.Status, apiErr.Error)
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+// shardID returns the shard of the node the client talks to
+func (c *client) shardID() int {
+	var status struct {
+		ShardID int `json:"shard_id"`
+	}
+	if err := c.do(http.MethodGet, "/status", nil, &status); err != nil {
+		fail("Failed to query node status:", err)
+	}
+	return status.ShardID
+}
+
+// chainID returns the chain ID of the node
+func (c *client) chainID() (string, error) {
+	var status struct {
+		ChainID string `json:"chain_id"`
+	}
+	if err := c.do(http.MethodGet, "/status", nil, &status); err != nil {
+		return "", err
+	}
+	return status.ChainID, nil
+}
+
+// submit stamps a transaction with the node's chain ID, signs it and posts
+// it to the given endpoint
+func (c *client) submit(path string, tx *core.Transaction, key string) {
+	chainID, err := c.chainID()
+	if err != nil {
+		fail("Failed to read chain ID:", err)
+	}
+	if tx.ChainID != chainID {
+		tx.ChainID = chainID
+		tx.Hash, _ = tx.CalculateHash()
+	}
+	if err := tx.Sign(key); err != nil {
+		fail("Failed to sign transaction:", err)
+	}
+	if err := c.do(http.MethodPost, path, tx, nil); err != nil {
+		fail("Transaction rejected:", err)
+	}
+	fmt.Println("Transaction submitted:", tx.Hash)
+}
+
+// printJSON pretty-prints a value
+func printJSON(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fail("Failed to format response:", err)
+	}
+	fmt.Println(string(data))
+}
+
+func fail(msg string, err error) {
+	fmt.Println(msg, err)
+	os.Exit(1)
+}
+
+// keyOrAddress returns the signing key, defaulting to the account address
+func keyOrAddress(key, address string) string {
+	if key != "" {
+		return key
+	}
+	return address
+}
+
+func runSend(args []string) {
+	fs, port := newFlagSet("send")
+	from := fs.String("from", "", "Sender address")
+	to := fs.String("to", "", "Receiver address")
+	amount := utils.AmountFlag(fs, "amount", 0, "Amount to send")
+	fee := utils.AmountFlag(fs, "fee", utils.MustParseAmount("0.01"), "Transaction fee")
+	key := fs.String("key", "", "Signing key (default: sender address)")
+	fs.Parse(args)
+
+	if *from == "" || *to == "" || *amount <= 0 {
+		fmt.Println("Usage: lscc-cli send -from Alice -to Bob -amount 10")
+		os.Exit(1)
+	}
+
+	c := newClient(*port)
+	shard := c.shardID()
+	tx, err := core.NewTransaction(*from, *to, *amount, *fee, shard, shard, 0, core.RegularTransaction)
+	if err != nil {
+		fail("Failed to create transaction:", err)
+	}
+	c.submit("/send", tx, keyOrAddress(*key, *from))
+}
+
+func runBalance(args []string) {
+	fs, port := newFlagSet("balance")
+	address := fs.String("address", "", "Account address")
+	fs.Parse(args)
+
+	if *address == "" {
+		fmt.Println("Usage: lscc-cli balance -address Alice")
+		os.Exit(1)
+	}
+
+	var balance map[string]interface{}
+	if err := newClient(*port).do(http.MethodGet, "/balance?address="+url.QueryEscape(*address), nil, &balance); err != nil {
+		fail("Failed to query balance:", err)
+	}
+	printJSON(balance)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"lscc/core"
+	"lscc/utils"
+)
+
+// validatorsInfo mirrors the node's GET /validators response
+type validatorsInfo struct {
+	ShardID      int                   `json:"shard_id"`
+	ValidatorSet *core.ValidatorSet    `json:"validator_set"`
+	Validators   []*core.ValidatorInfo `json:"validators"`
+	Unbonding    []*core.Unbonding     `json:"unbonding"`
+	MinStake     core.Amount           `json:"min_stake"`
+	EpochLength  int                   `json:"epoch_length"`
+}
+
+// runStake handles the stake, unstake and delegate commands
+func runStake(command string, args []string) {
+	fs, port := newFlagSet(command)
+	from := fs.String("from", "", "Account bonding or unbonding stake")
+	validator := fs.String("validator", "", "Validator address (default: the -from address)")
+	amount := utils.AmountFlag(fs, "amount", 0, "Amount of stake")
+	fee := utils.AmountFlag(fs, "fee", utils.MustParseAmount("0.01"), "Transaction fee")
+	key := fs.String("key", "", "Signing key (default: the -from address)")
+	fs.Parse(args)
+
+	if *validator == "" {
+		*validator = *from
+	}
+	if *from == "" || *amount <= 0 || (command == "delegate" && *validator == *from) {
+		switch command {
+		case "delegate":
+			fmt.Println("Usage: lscc-cli delegate -from Alice -validator node1 -amount 100")
+		default:
+			fmt.Printf("Usage: lscc-cli %s -from node1 -amount 1000\n", command)
+		}
+		os.Exit(1)
+	}
+
+	c := newClient(*port)
+	shard := c.shardID()
+
+	var tx *core.Transaction
+	var err error
+	switch command {
+	case "stake":
+		tx, err = core.NewStakeTransaction(*from, *amount, *fee, shard)
+	case "delegate":
+		tx, err = core.NewDelegateTransaction(*from, *validator, *amount, *fee, shard)
+	case "unstake":
+		tx, err = core.NewUnstakeTransaction(*from, *validator, *amount, *fee, shard)
+	}
+	if err != nil {
+		fail("Failed to create transaction:", err)
+	}
+	c.submit("/send", tx, keyOrAddress(*key, *from))
+}
+
+// runValidators prints the stake distribution of a shard
+func runValidators(args []string) {
+	fs, port := newFlagSet("validators")
+	shard := fs.Int("shard", -1, "Shard to inspect (default: the node's shard)")
+	asJSON := fs.Bool("json", false, "Print the raw response")
+	fs.Parse(args)
+
+	path := "/validators"
+	if *shard >= 0 {
+		path += "?shard=" + strconv.Itoa(*shard)
+	}
+
+	var info validatorsInfo
+	if err := newClient(*port).do(http.MethodGet, path, nil, &info); err != nil {
+		fail("Failed to query validators:", err)
+	}
+	if *asJSON {
+		printJSON(info)
+		return
+	}
+
+	set := info.ValidatorSet
+	fmt.Printf("Shard %d, epoch %d (set computed at height %d, min stake %s)\n",
+		info.ShardID, set.Epoch, set.Height, info.MinStake)
+	fmt.Printf("%-20s %14s %14s %14s %8s %7s %s\n",
+		"VALIDATOR", "SELF", "DELEGATED", "TOTAL", "POWER", "ACTIVE", "DELEGATORS")
+	for _, v := range info.Validators {
+		power := 0.0
+		if stake, active := set.Validators[v.Address]; active && set.TotalStake > 0 {
+			power = stake.Coins() / set.TotalStake.Coins() * 100
+		}
+		status := strconv.FormatBool(v.Active)
+		if v.Slashed {
+			status = "slashed"
+		}
+		fmt.Printf("%-20s %14s %14s %14s %7.2f%% %7s %d\n",
+			v.Address, v.SelfStake, v.DelegatedStake, v.TotalStake, power, status, len(v.Delegations))
+	}
+	for _, u := range info.Unbonding {
+		fmt.Printf("Unbonding: %s from %s, %s until height %d\n",
+			u.Delegator, u.Validator, u.Amount, u.CompletionHeight)
+	}
+}
+
+// runSupply prints the supply accounting of a shard
+func runSupply(args []string) {
+	fs, port := newFlagSet("supply")
+	shard := fs.Int("shard", -1, "Shard to inspect (default: the node's shard)")
+	fs.Parse(args)
+
+	path := "/supply"
+	if *shard >= 0 {
+		path += "?shard=" + strconv.Itoa(*shard)
+	}
+
+	var supply map[string]interface{}
+	if err := newClient(*port).do(http.MethodGet, path, nil, &supply); err != nil {
+		fail("Failed to query supply:", err)
+	}
+	printJSON(supply)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"lscc/core"
+	"lscc/sharding"
+	"lscc/utils"
+)
+
+func runSwap(args []string) {
+	if len(args) < 1 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	fs, port := newFlagSet("swap " + args[0])
+	id := fs.String("id", "", "Swap ID (the hash lock)")
+	from := fs.String("from", "", "Account locking funds")
+	to := fs.String("to", "", "Counterparty address")
+	amount := utils.AmountFlag(fs, "amount", 0, "Amount to lock")
+	shard := fs.Int("shard", -1, "Shard holding the lock (default: the node's shard)")
+	timeout := fs.Uint64("timeout", 0, "Blocks until the lock can be refunded")
+	secret := fs.String("secret", "", "Hex-encoded secret revealed by the claim")
+	key := fs.String("key", "", "Signing key (default: the locking or claiming address)")
+	fee := utils.AmountFlag(fs, "fee", utils.MustParseAmount("0.01"), "Transaction fee")
+	fs.Parse(args[1:])
+
+	c := newClient(*port)
+	if *shard < 0 && (args[0] == "initiate" || args[0] == "participate") {
+		*shard = c.shardID()
+	}
+
+	switch args[0] {
+	case "initiate":
+		if *from == "" || *to == "" || *amount <= 0 || *timeout == 0 {
+			fmt.Println("Usage: lscc-cli swap initiate -from Alice -to Bob -amount 10 -shard 0 -timeout 20")
+			os.Exit(1)
+		}
+		preimage, hashLock, err := core.NewSecret()
+		if err != nil {
+			fail("Failed to generate secret:", err)
+		}
+		tx, err := core.NewHTLCLockTransaction(*from, *to, *amount, *fee, *shard, hashLock, c.shardHeight(*shard)+*timeout)
+		if err != nil {
+			fail("Failed to create lock:", err)
+		}
+		c.submitSwap("/swaps/initiate", tx, keyOrAddress(*key, *from), tx)
+		fmt.Println("Swap ID:", hashLock)
+		fmt.Println("Secret: ", preimage)
+		fmt.Println("Keep the secret private until you claim the counter-lock.")
+
+	case "participate":
+		swap := c.swap(*id)
+		if *amount <= 0 || *timeout == 0 {
+			fmt.Println("Usage: lscc-cli swap participate -id ID -amount 5 -shard 2 -timeout 10")
+			os.Exit(1)
+		}
+		sender := *from
+		if sender == "" {
+			sender = swap.Initiator.Recipient
+		}
+		tx, err := core.NewHTLCLockTransaction(sender, swap.Initiator.Sender, *amount, *fee, *shard, swap.ID, c.shardHeight(*shard)+*timeout)
+		if err != nil {
+			fail("Failed to create counter-lock:", err)
+		}
+		c.submitSwap("/swaps/participate", tx, keyOrAddress(*key, sender), swapRequest{SwapID: swap.ID, Transaction: tx})
+
+	case "claim":
+		swap := c.swap(*id)
+		if *secret == "" || swap.Participant == nil {
+			fmt.Println("Usage: lscc-cli swap claim -id ID -secret SECRET (after the counter-lock is placed)")
+			os.Exit(1)
+		}
+		leg := swap.Participant
+		tx, err := core.NewHTLCClaimTransaction(leg.Recipient, leg.LockID, *secret, *fee, leg.ShardID)
+		if err != nil {
+			fail("Failed to create claim:", err)
+		}
+		c.submitSwap("/swaps/claim", tx, keyOrAddress(*key, leg.Recipient), swapRequest{SwapID: swap.ID, Transaction: tx})
+
+	case "refund":
+		var swap sharding.AtomicSwap
+		if err := c.do(http.MethodPost, "/swaps/refund", swapRequest{SwapID: c.swap(*id).ID}, &swap); err != nil {
+			fail("Refund rejected:", err)
+		}
+		printJSON(swap)
+
+	case "show":
+		printJSON(c.swap(*id))
+
+	case "list":
+		var swaps map[string]interface{}
+		if err := c.do(http.MethodGet, "/swaps", nil, &swaps); err != nil {
+			fail("Failed to list swaps:", err)
+		}
+		printJSON(swaps)
+
+	default:
+		fmt.Println("Unknown swap command:", args[0])
+		printUsage()
+		os.Exit(1)
+	}
+}
+
+// swapRequest mirrors the body of the node's swap step endpoints
+type swapRequest struct {
+	SwapID      string            `json:"swap_id"`
+	Transaction *core.Transaction `json:"transaction"`
+}
+
+// submitSwap signs a swap transaction and posts body to the given endpoint
+func (c *client) submitSwap(path string, tx *core.Transaction, key string, body interface{}) {
+	if err := tx.Sign(key); err != nil {
+		fail("Failed to sign transaction:", err)
+	}
+	var swap sharding.AtomicSwap
+	if err := c.do(http.MethodPost, path, body, &swap); err != nil {
+		fail("Transaction rejected:", err)
+	}
+	fmt.Println("Transaction submitted:", tx.Hash)
+	fmt.Println("Swap status:", swap.Status)
+}
+
+// swap fetches a swap from the node
+func (c *client) swap(id string) *sharding.AtomicSwap {
+	if id == "" {
+		fmt.Println("Error: -id is required")
+		os.Exit(1)
+	}
+
+	var swap sharding.AtomicSwap
+	if err := c.do(http.MethodGet, "/swaps/"+id, nil, &swap); err != nil {
+		fail("Failed to fetch swap:", err)
+	}
+	return &swap
+}
+
+// shardHeight returns the current chain height of a shard
+func (c *client) shardHeight(shardID int) uint64 {
+	var status struct {
+		Height uint64 `json:"blockchain_height"`
+	}
+	if err := c.do(http.MethodGet, "/shard?id="+strconv.Itoa(shardID), nil, &status); err != nil {
+		fail("Failed to query shard:", err)
+	}
+	return status.Height
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"lscc/tracing"
+)
+
+// runTrace prints the timeline of a transaction's trace, read from a node
+// or from trace files written by nodes
+func runTrace(args []string) {
+	fs, port := newFlagSet("trace")
+	hash := fs.String("hash", "", "Transaction hash")
+	files := fs.String("file", "", "Comma-separated trace files to read instead of querying the node")
+	asJSON := fs.Bool("json", false, "Print the spans as an OTLP/JSON export request")
+	fs.Parse(args)
+
+	if *hash == "" {
+		fmt.Println("Usage: lscc-cli trace -hash HASH [-file FILE,FILE] [-json]")
+		os.Exit(1)
+	}
+
+	var spans []tracing.Span
+	if *files != "" {
+		spans = readTraceFiles(strings.Split(*files, ","), *hash)
+	} else {
+		var raw json.RawMessage
+		if err := newClient(*port).do(http.MethodGet, "/traces/"+*hash, nil, &raw); err != nil {
+			fail("Failed to query trace:", err)
+		}
+		decoded, err := tracing.DecodeOTLP(raw)
+		if err != nil {
+			fail("Failed to read trace:", err)
+		}
+		spans = decoded
+	}
+	if len(spans) == 0 {
+		fail("Failed to read trace:", fmt.Errorf("no spans for transaction %s", *hash))
+	}
+	tracing.SortSpans(spans)
+
+	if *asJSON {
+		data, err := tracing.EncodeOTLP(spans)
+		if err != nil {
+			fail("Failed to format trace:", err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+	printTimeline(*hash, spans)
+}
+
+// readTraceFiles returns the spans of a transaction's trace found in trace
+// files, leaving out spans found in more than one file
+func readTraceFiles(paths []string, hash string) []tracing.Span {
+	traceID := tracing.TraceID(hash)
+	seen := make(map[string]bool)
+
+	var spans []tracing.Span
+	for _, path := range paths {
+		all, err := tracing.ReadFile(strings.TrimSpace(path))
+		if err != nil {
+			fail("Failed to read trace file:", err)
+		}
+		for _, span := range all {
+			if span.TraceID != traceID || seen[span.SpanID] {
+				continue
+			}
+			seen[span.SpanID] = true
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// printTimeline prints spans ordered by start time, each with its offset
+// from the first span, its duration, node and attributes
+func printTimeline(hash string, spans []tracing.Span) {
+	start, end := spans[0].Start, spans[0].End
+	for _, span := range spans {
+		if span.End.After(end) {
+			end = span.End
+		}
+	}
+	fmt.Printf("Trace %s of transaction %s: %d spans over %s\n\n",
+		spans[0].TraceID, hash, len(spans), end.Sub(start).Round(time.Millisecond))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OFFSET\tDURATION\tNODE\tSPAN\tATTRIBUTES")
+	for _, span := range spans {
+		fmt.Fprintf(w, "+%s\t%s\t%s\t%s\t%s\n",
+			span.Start.Sub(start).Round(time.Millisecond),
+			span.End.Sub(span.Start).Round(time.Microsecond),
+			span.Service,
+			span.Name,
+			formatAttributes(span))
+	}
+	w.Flush()
+}
+
+// formatAttributes formats a span's attributes, other than the transaction
+// hash, and its error as key=value pairs
+func formatAttributes(span tracing.Span) string {
+	keys := make([]string, 0, len(span.Attributes))
+	for key := range span.Attributes {
+		if key != "tx.hash" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys)+1)
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", key, span.Attributes[key]))
+	}
+	if span.Error != "" {
+		pairs = append(pairs, fmt.Sprintf("error=%q", span.Error))
+	}
+	return strings.Join(pairs, " ")
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"lscc/core"
+)
+
+func runTx(args []string) {
+	if len(args) < 1 {
+		printUsage()
+		os.Exit(1)
+	}
+
+	fs, port := newFlagSet("tx " + args[0])
+	hash := fs.String("hash", "", "Transaction hash")
+	shard := fs.Int("shard", -1, "Shard to look in (default: the node's shard)")
+	asJSON := fs.Bool("json", false, "Print the raw response")
+	fs.Parse(args[1:])
+
+	if *hash == "" {
+		fmt.Printf("Usage: lscc-cli tx %s -hash HASH [-shard N]\n", args[0])
+		os.Exit(1)
+	}
+
+	c := newClient(*port)
+	switch args[0] {
+	case "status":
+		var receipt core.Receipt
+		if err := c.do(http.MethodGet, "/tx/"+*hash+"/receipt"+shardQuery(*shard), nil, &receipt); err != nil {
+			fail("Failed to fetch transaction:", err)
+		}
+		if *asJSON {
+			printJSON(receipt)
+			return
+		}
+		printReceipt(&receipt)
+
+	case "show":
+		var status core.TransactionStatus
+		if err := c.do(http.MethodGet, "/tx/"+*hash+shardQuery(*shard), nil, &status); err != nil {
+			fail("Failed to fetch transaction:", err)
+		}
+		printJSON(status)
+
+	default:
+		fmt.Println("Unknown tx command:", args[0])
+		printUsage()
+		os.Exit(1)
+	}
+}
+
+// printReceipt prints a transaction receipt in a readable form
+func printReceipt(receipt *core.Receipt) {
+	fmt.Printf("Transaction %s\n", receipt.TxHash)
+	fmt.Printf("  Status:        %s\n", receipt.Status)
+	fmt.Printf("  Shard:         %d\n", receipt.ShardID)
+	if receipt.BlockHash != "" {
+		fmt.Printf("  Block:         %d (%s), index %d\n", receipt.BlockHeight, receipt.BlockHash, receipt.Index)
+		fmt.Printf("  Confirmations: %d\n", receipt.Confirmations)
+		fmt.Printf("  Fee paid:      %s\n", receipt.FeePaid)
+	}
+	if receipt.Contract != nil {
+		fmt.Printf("  Gas used:      %d of %d\n", receipt.Contract.GasUsed, receipt.Contract.GasLimit)
+	}
+	if receipt.Error != "" {
+		fmt.Printf("  Error:         %s\n", receipt.Error)
+	}
+}
@@ -3,15 +3,28 @@ package config
 import (
 	"encoding/json"
 	"os"
+
+	"lscc/tracing"
+	"lscc/utils"
 )
 
+// DefaultChainID is the chain ID of networks that do not set one
+const DefaultChainID = "lscc-local"
+
 // Config holds the configuration for the LSCC node
 type Config struct {
+	// Chain configuration
+	ChainID     string `json:"chain_id,omitempty"`
+	GenesisFile string `json:"genesis_file,omitempty"` // Genesis spec; without one the genesis is derived from this config
+	GenesisTime int64  `json:"genesis_time,omitempty"`
+	GenesisHash string `json:"-"` // Set when the genesis is applied
+
 	// Node configuration
 	NodeID         string   `json:"node_id"`
 	Port           int      `json:"port"`
 	BootstrapNodes []string `json:"bootstrap_nodes"`
 	IsRelay        bool     `json:"is_relay"`
+	ValidatorKey   string   `json:"validator_key,omitempty"` // Hex ed25519 private key matching the node's genesis pub_key
 
 	// Sharding configuration
 	ShardID          int `json:"shard_id"`
@@ -23,17 +36,61 @@ type Config struct {
 	ShardingStrategy int `json:"sharding_strategy"`
 
 	// Consensus configuration
-	ConsensusType      string `json:"consensus_type"`
-	BlockTime          int    `json:"block_time"`
-	MinConfirmations   int    `json:"min_confirmations"`
-	MaxTransPerBlock   int    `json:"max_transactions_per_block"`
-	CrossChannelVerify bool   `json:"cross_channel_verify"`
+	ConsensusType      string       `json:"consensus_type"`
+	BlockTime          int          `json:"block_time"`
+	MinConfirmations   int          `json:"min_confirmations"`
+	MaxTransPerBlock   int          `json:"max_transactions_per_block"`
+	CrossChannelVerify bool         `json:"cross_channel_verify"`
+	SlashFraction      float64      `json:"slash_fraction"`
+	MinStake           utils.Amount `json:"min_stake"`
+	EpochLength        int          `json:"epoch_length"`
+	UnbondingPeriod    int          `json:"unbonding_period"`
+	BlockReward        utils.Amount `json:"block_reward"`
+	RelayRewardShare   float64      `json:"relay_reward_share"`
+	GasPrice           utils.Amount `json:"gas_price"`
+	MaxContractGas     uint64       `json:"max_contract_gas"`
+
+	// Typed parameters of each consensus engine, keyed by consensus type
+	ConsensusParams map[string]json.RawMessage `json:"consensus_params,omitempty"`
+
+	// Consensus each shard runs. Shards without a spec run the node's own
+	// consensus_type.
+	Shards []ShardSpec `json:"shards,omitempty"`
 
 	// Network configuration
 	ConnectionTimeout int    `json:"connection_timeout"`
 	SyncInterval      int    `json:"sync_interval"`
 	PeerLimit         int    `json:"peer_limit"`
 	DataDir           string `json:"data_dir"`
+	APIPort           int    `json:"api_port"`
+
+	// Logging configuration; the log file is kept under DataDir
+	Logging utils.LogOptions `json:"logging"`
+
+	// Tracing configuration; spans of transactions are exported to a file
+	// under DataDir, a collector, or both
+	Tracing tracing.Options `json:"tracing"`
+
+	// State configuration
+	Allocations            []Allocation       `json:"allocations"`
+	Validators             []GenesisValidator `json:"validators,omitempty"`
+	ChannelChallengePeriod int                `json:"channel_challenge_period"`
+}
+
+// Allocation credits an account in a shard's genesis state
+type Allocation struct {
+	Address string       `json:"address"`
+	Amount  utils.Amount `json:"amount"`
+	ShardID int          `json:"shard_id"`
+}
+
+// ShardSpec pins the consensus engine of a shard. Every node of the shard
+// must run the same engine with the same parameters.
+type ShardSpec struct {
+	ShardID          int             `json:"shard_id"`
+	ConsensusType    string          `json:"consensus_type"`
+	ConsensusParams  json.RawMessage `json:"consensus_params,omitempty"`
+	MinConfirmations int             `json:"min_confirmations,omitempty"` // 0 keeps the node default
 }
 
 // LoadConfig loads configuration from a JSON file
@@ -70,6 +127,7 @@ func SaveConfig(config *Config, path string) error {
 // DefaultConfig returns a default configuration
 func DefaultConfig() *Config {
 	return &Config{
+		ChainID:           DefaultChainID,
 		NodeID:            "",
 		Port:              8000,
 		BootstrapNodes:    []string{},
@@ -86,9 +144,69 @@ func DefaultConfig() *Config {
 		MinConfirmations:  6,
 		MaxTransPerBlock:  1000,
 		CrossChannelVerify: true,
+		SlashFraction:     0.5, // share of stake burned on proven misbehaviour
+		MinStake:          utils.Coins(1000), // own stake needed to join the validator set
+		EpochLength:       10, // blocks between validator set updates
+		UnbondingPeriod:   20, // blocks unbonded stake stays locked
+		BlockReward:       utils.Coins(5), // minted per block
+		RelayRewardShare:  0.2, // share of cross-shard fees paid to relays
+		GasPrice:          1, // base units per unit of contract gas
+		MaxContractGas:    1000000,
 		ConnectionTimeout: 30, // seconds
 		SyncInterval:      60, // seconds
 		PeerLimit:         50,
 		DataDir:           "./data",
+		APIPort:           9000,
+		Logging:           utils.LogOptions{Level: "info", Format: utils.LogFormatText},
+		Allocations:       []Allocation{},
+		ChannelChallengePeriod: 10, // blocks
+	}
+}
+
+// LayerOfShard returns the layer a shard belongs to. Shards are numbered
+// layer by layer, so shard IDs 0..ShardCount-1 form layer 0, the next
+// ShardCount IDs form layer 1, and so on.
+func (c *Config) LayerOfShard(shardID int) int {
+	if c.ShardCount <= 0 || shardID < 0 {
+		return 0
+	}
+	return shardID / c.ShardCount
+}
+
+// ShardSpec returns the consensus spec of a shard, if the config pins one
+func (c *Config) ShardSpec(shardID int) (ShardSpec, bool) {
+	for _, spec := range c.Shards {
+		if spec.ShardID == shardID {
+			return spec, true
+		}
+	}
+	return ShardSpec{}, false
+}
+
+// ForShard returns a copy of the config as seen by a shard: the shard ID is
+// set and, if the shard has a spec, its consensus engine, parameters and
+// confirmation depth replace the node's own
+func (c *Config) ForShard(shardID int) *Config {
+	shardConfig := *c
+	shardConfig.ShardID = shardID
+
+	spec, exists := c.ShardSpec(shardID)
+	if !exists {
+		return &shardConfig
+	}
+
+	shardConfig.ConsensusType = spec.ConsensusType
+	if spec.MinConfirmations > 0 {
+		shardConfig.MinConfirmations = spec.MinConfirmations
+	}
+	shardConfig.ConsensusParams = make(map[string]json.RawMessage, len(c.ConsensusParams)+1)
+	for name, raw := range c.ConsensusParams {
+		shardConfig.ConsensusParams[name] = raw
+	}
+	if len(spec.ConsensusParams) > 0 {
+		shardConfig.ConsensusParams[spec.ConsensusType] = spec.ConsensusParams
+	} else {
+		delete(shardConfig.ConsensusParams, spec.ConsensusType)
 	}
+	return &shardConfig
 }
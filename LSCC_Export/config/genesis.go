@@ -0,0 +1,264 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"lscc/utils"
+)
+
+// Genesis describes the initial state shared by every node of a network:
+// the chain ID, the shard layout, the consensus of each shard, the initial
+// validators and the allocations. Nodes only peer with nodes whose genesis
+// hash matches their own.
+type Genesis struct {
+	ChainID     string `json:"chain_id"`
+	GenesisTime int64  `json:"genesis_time"` // Unix timestamp of every shard's genesis block
+
+	// Shard layout
+	ShardCount int `json:"shard_count"`
+	LayerCount int `json:"layer_count"`
+
+	// Default consensus of shards without a spec, and the per-shard specs
+	ConsensusType   string                     `json:"consensus_type"`
+	ConsensusParams map[string]json.RawMessage `json:"consensus_params,omitempty"`
+	Shards          []ShardSpec                `json:"shards,omitempty"`
+
+	// Chain parameters
+	BlockTime              int          `json:"block_time"`
+	MinConfirmations       int          `json:"min_confirmations"`
+	SlashFraction          float64      `json:"slash_fraction"`
+	MinStake               utils.Amount `json:"min_stake"`
+	EpochLength            int          `json:"epoch_length"`
+	UnbondingPeriod        int          `json:"unbonding_period"`
+	BlockReward            utils.Amount `json:"block_reward"`
+	RelayRewardShare       float64      `json:"relay_reward_share"`
+	GasPrice               utils.Amount `json:"gas_price"`
+	MaxContractGas         uint64       `json:"max_contract_gas"`
+	ChannelChallengePeriod int          `json:"channel_challenge_period"`
+
+	// Initial state
+	Validators  []GenesisValidator `json:"validators,omitempty"`
+	Allocations []Allocation       `json:"allocations,omitempty"`
+}
+
+// GenesisValidator bonds a validator's self stake in a shard's genesis state
+// and registers the key its signatures are checked against when it is
+// accused of misbehaviour
+type GenesisValidator struct {
+	Address string       `json:"address"`
+	Stake   utils.Amount `json:"stake"`
+	ShardID int          `json:"shard_id"`
+	PubKey  string       `json:"pub_key,omitempty"` // Hex ed25519 public key
+}
+
+// LoadGenesis loads a genesis file and validates it
+func LoadGenesis(path string) (*Genesis, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	genesis := &Genesis{}
+	if err := json.Unmarshal(data, genesis); err != nil {
+		return nil, err
+	}
+	if err := genesis.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid genesis %s: %w", path, err)
+	}
+	return genesis, nil
+}
+
+// SaveGenesis saves a genesis file
+func SaveGenesis(genesis *Genesis, path string) error {
+	data, err := json.MarshalIndent(genesis, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// Validate checks the genesis layout and initial state are consistent
+func (g *Genesis) Validate() error {
+	if g.ChainID == "" {
+		return errors.New("chain_id is required")
+	}
+	if g.ShardCount <= 0 || g.LayerCount <= 0 {
+		return errors.New("shard_count and layer_count must be positive")
+	}
+	if g.ConsensusType == "" {
+		return errors.New("consensus_type is required")
+	}
+
+	// Each shard's genesis supply must fit in an amount
+	shardTotal := g.ShardCount * g.LayerCount
+	supply := make([]utils.Amount, shardTotal)
+	for _, alloc := range g.Allocations {
+		if alloc.ShardID < 0 || alloc.ShardID >= shardTotal {
+			return fmt.Errorf("allocation to %s in unknown shard %d", alloc.Address, alloc.ShardID)
+		}
+		if alloc.Amount <= 0 {
+			return fmt.Errorf("allocation to %s must be positive", alloc.Address)
+		}
+		total, err := supply[alloc.ShardID].Add(alloc.Amount)
+		if err != nil {
+			return fmt.Errorf("genesis supply of shard %d: %w", alloc.ShardID, err)
+		}
+		supply[alloc.ShardID] = total
+	}
+
+	seen := make(map[string]bool, len(g.Validators))
+	for _, validator := range g.Validators {
+		if validator.ShardID < 0 || validator.ShardID >= shardTotal {
+			return fmt.Errorf("validator %s in unknown shard %d", validator.Address, validator.ShardID)
+		}
+		if validator.Stake < g.MinStake || validator.Stake <= 0 {
+			return fmt.Errorf("validator %s stakes %s, minimum is %s", validator.Address, validator.Stake, g.MinStake)
+		}
+		total, err := supply[validator.ShardID].Add(validator.Stake)
+		if err != nil {
+			return fmt.Errorf("genesis supply of shard %d: %w", validator.ShardID, err)
+		}
+		supply[validator.ShardID] = total
+
+		if validator.PubKey != "" && !utils.ValidPublicKey(validator.PubKey) {
+			return fmt.Errorf("validator %s has an invalid pub_key", validator.Address)
+		}
+
+		key := fmt.Sprintf("%d/%s", validator.ShardID, validator.Address)
+		if seen[key] {
+			return fmt.Errorf("validator %s listed twice in shard %d", validator.Address, validator.ShardID)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// Hash returns the hash identifying the network. It covers every field, so
+// nodes started from different genesis files never agree on it.
+func (g *Genesis) Hash() (string, error) {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Genesis returns the genesis described by the config's own chain settings,
+// used when the node is started without a genesis file
+func (c *Config) Genesis() *Genesis {
+	chainID := c.ChainID
+	if chainID == "" {
+		chainID = DefaultChainID
+	}
+	return &Genesis{
+		ChainID:                chainID,
+		GenesisTime:            c.GenesisTime,
+		ShardCount:             c.ShardCount,
+		LayerCount:             c.LayerCount,
+		ConsensusType:          c.ConsensusType,
+		ConsensusParams:        c.ConsensusParams,
+		Shards:                 c.Shards,
+		BlockTime:              c.BlockTime,
+		MinConfirmations:       c.MinConfirmations,
+		SlashFraction:          c.SlashFraction,
+		MinStake:               c.MinStake,
+		EpochLength:            c.EpochLength,
+		UnbondingPeriod:        c.UnbondingPeriod,
+		BlockReward:            c.BlockReward,
+		RelayRewardShare:       c.RelayRewardShare,
+		GasPrice:               c.GasPrice,
+		MaxContractGas:         c.MaxContractGas,
+		ChannelChallengePeriod: c.ChannelChallengePeriod,
+		Validators:             c.Validators,
+		Allocations:            c.Allocations,
+	}
+}
+
+// ApplyGenesis replaces the config's chain settings with the genesis and
+// records its hash. Every shard gets a spec, shards the genesis does not pin
+// running its default engine. The node's own consensus_type is kept so it
+// can be checked against its shard; a node that declares none adopts its
+// shard's engine and parameters.
+func (c *Config) ApplyGenesis(genesis *Genesis) error {
+	hash, err := genesis.Hash()
+	if err != nil {
+		return err
+	}
+
+	c.ChainID = genesis.ChainID
+	c.GenesisTime = genesis.GenesisTime
+	c.ShardCount = genesis.ShardCount
+	c.LayerCount = genesis.LayerCount
+	c.Shards = genesis.ShardSpecs()
+	c.BlockTime = genesis.BlockTime
+	c.MinConfirmations = genesis.MinConfirmations
+	c.SlashFraction = genesis.SlashFraction
+	c.MinStake = genesis.MinStake
+	c.EpochLength = genesis.EpochLength
+	c.UnbondingPeriod = genesis.UnbondingPeriod
+	c.BlockReward = genesis.BlockReward
+	c.RelayRewardShare = genesis.RelayRewardShare
+	c.GasPrice = genesis.GasPrice
+	c.MaxContractGas = genesis.MaxContractGas
+	c.ChannelChallengePeriod = genesis.ChannelChallengePeriod
+	c.Validators = genesis.Validators
+	c.Allocations = genesis.Allocations
+	c.GenesisHash = hash
+
+	if spec, exists := c.ShardSpec(c.ShardID); exists {
+		if c.ConsensusType == "" {
+			c.ConsensusType = spec.ConsensusType
+		}
+		if _, declared := c.ConsensusParams[c.ConsensusType]; !declared && c.ConsensusType == spec.ConsensusType && len(spec.ConsensusParams) > 0 {
+			params := make(map[string]json.RawMessage, len(c.ConsensusParams)+1)
+			for name, raw := range c.ConsensusParams {
+				params[name] = raw
+			}
+			params[spec.ConsensusType] = spec.ConsensusParams
+			c.ConsensusParams = params
+		}
+	}
+	return nil
+}
+
+// ShardSpecs returns the consensus spec of every shard, with shards the
+// genesis does not pin running its default engine and parameters
+func (g *Genesis) ShardSpecs() []ShardSpec {
+	shardTotal := g.ShardCount * g.LayerCount
+	specs := make([]ShardSpec, 0, shardTotal)
+	for shardID := 0; shardID < shardTotal; shardID++ {
+		spec := ShardSpec{
+			ShardID:         shardID,
+			ConsensusType:   g.ConsensusType,
+			ConsensusParams: g.ConsensusParams[g.ConsensusType],
+		}
+		for _, pinned := range g.Shards {
+			if pinned.ShardID == shardID {
+				spec = pinned
+				break
+			}
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// InitGenesis applies the genesis file named by the config, or the genesis
+// described by the config itself when it names none
+func (c *Config) InitGenesis() error {
+	if c.GenesisFile == "" {
+		return c.ApplyGenesis(c.Genesis())
+	}
+
+	genesis, err := LoadGenesis(c.GenesisFile)
+	if err != nil {
+		return err
+	}
+	return c.ApplyGenesis(genesis)
+}
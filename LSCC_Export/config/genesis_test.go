@@ -0,0 +1,174 @@
+package config
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lscc/utils"
+)
+
+// testGenesis returns a valid genesis of two shards in one layer, with
+// shard 1 pinned to PBFT
+func testGenesis() *Genesis {
+	return &Genesis{
+		ChainID:          "lscc-test",
+		GenesisTime:      1000000,
+		ShardCount:       2,
+		LayerCount:       1,
+		ConsensusType:    "pos",
+		ConsensusParams:  map[string]json.RawMessage{"pos": json.RawMessage(`{"block_time":5}`)},
+		Shards:           []ShardSpec{{ShardID: 1, ConsensusType: "pbft", MinConfirmations: 1}},
+		BlockTime:        5,
+		MinConfirmations: 6,
+		MinStake:         utils.Coins(1000),
+		Validators: []GenesisValidator{
+			{Address: "node1", Stake: utils.Coins(1000), ShardID: 0},
+		},
+		Allocations: []Allocation{
+			{Address: "alice", Amount: utils.Coins(100), ShardID: 1},
+		},
+	}
+}
+
+func TestGenesisValidate(t *testing.T) {
+	if err := testGenesis().Validate(); err != nil {
+		t.Fatalf("valid genesis refused: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Genesis)
+		want   string
+	}{
+		{"without a chain ID", func(g *Genesis) { g.ChainID = "" }, "chain_id"},
+		{"without shards", func(g *Genesis) { g.ShardCount = 0 }, "shard_count"},
+		{"without consensus", func(g *Genesis) { g.ConsensusType = "" }, "consensus_type"},
+		{"allocating in an unknown shard", func(g *Genesis) { g.Allocations[0].ShardID = 2 }, "unknown shard"},
+		{"allocating nothing", func(g *Genesis) { g.Allocations[0].Amount = 0 }, "positive"},
+		{"staking below the minimum", func(g *Genesis) { g.Validators[0].Stake = utils.Coins(999) }, "minimum"},
+		{"with a validator in an unknown shard", func(g *Genesis) { g.Validators[0].ShardID = -1 }, "unknown shard"},
+		{"with a bad validator key", func(g *Genesis) { g.Validators[0].PubKey = "abcd" }, "pub_key"},
+		{"listing a validator twice", func(g *Genesis) { g.Validators = append(g.Validators, g.Validators[0]) }, "twice"},
+		{"overflowing a shard's supply", func(g *Genesis) {
+			g.Allocations = append(g.Allocations, Allocation{Address: "bob", Amount: utils.MaxAmount, ShardID: 1})
+		}, "supply of shard 1"},
+	}
+	for _, tt := range tests {
+		genesis := testGenesis()
+		tt.modify(genesis)
+		if err := genesis.Validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("genesis %s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	// A validator may stake in several shards
+	genesis := testGenesis()
+	genesis.Validators = append(genesis.Validators, GenesisValidator{Address: "node1", Stake: utils.Coins(1000), ShardID: 1})
+	if err := genesis.Validate(); err != nil {
+		t.Errorf("validator staking in two shards refused: %v", err)
+	}
+}
+
+func TestGenesisHash(t *testing.T) {
+	hash, err := testGenesis().Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := testGenesis().Hash(); again != hash {
+		t.Error("hash of the same genesis differs")
+	}
+
+	for name, modify := range map[string]func(*Genesis){
+		"chain ID":     func(g *Genesis) { g.ChainID = "other" },
+		"genesis time": func(g *Genesis) { g.GenesisTime++ },
+		"shard spec":   func(g *Genesis) { g.Shards[0].ConsensusType = "pow" },
+		"allocation":   func(g *Genesis) { g.Allocations[0].Amount++ },
+		"validator":    func(g *Genesis) { g.Validators[0].Stake++ },
+	} {
+		genesis := testGenesis()
+		modify(genesis)
+		if other, _ := genesis.Hash(); other == hash {
+			t.Errorf("changing the %s keeps the hash", name)
+		}
+	}
+
+	// Saving and loading keeps the hash
+	path := filepath.Join(t.TempDir(), "genesis.json")
+	if err := SaveGenesis(testGenesis(), path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadGenesis(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loadedHash, _ := loaded.Hash(); loadedHash != hash {
+		t.Error("hash changed through a genesis file")
+	}
+
+	invalid := testGenesis()
+	invalid.ChainID = ""
+	if err := SaveGenesis(invalid, path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadGenesis(path); err == nil {
+		t.Error("invalid genesis file loaded")
+	}
+}
+
+func TestApplyGenesis(t *testing.T) {
+	genesis := testGenesis()
+	hash, err := genesis.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A node of shard 1 declaring no engine adopts its shard's
+	cfg := DefaultConfig()
+	cfg.ShardID = 1
+	cfg.ConsensusType = ""
+	if err := cfg.ApplyGenesis(genesis); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GenesisHash != hash || cfg.ChainID != "lscc-test" || cfg.ShardCount != 2 || cfg.LayerCount != 1 {
+		t.Errorf("config = chain %s, genesis %s, %d shards in %d layers", cfg.ChainID, cfg.GenesisHash, cfg.ShardCount, cfg.LayerCount)
+	}
+	if cfg.ConsensusType != "pbft" {
+		t.Errorf("consensus = %s, want the shard's pbft", cfg.ConsensusType)
+	}
+	if len(cfg.Allocations) != 1 || len(cfg.Validators) != 1 || cfg.MinStake != utils.Coins(1000) {
+		t.Error("initial state not taken from the genesis")
+	}
+
+	// Every shard gets a spec; unpinned shards run the default engine
+	if len(cfg.Shards) != 2 {
+		t.Fatalf("%d shard specs, want 2", len(cfg.Shards))
+	}
+	if spec, _ := cfg.ShardSpec(0); spec.ConsensusType != "pos" || string(spec.ConsensusParams) != `{"block_time":5}` {
+		t.Errorf("shard 0 spec = %s %s, want the default pos", spec.ConsensusType, spec.ConsensusParams)
+	}
+	if shard := cfg.ForShard(1); shard.MinConfirmations != 1 {
+		t.Errorf("shard 1 confirms at %d, want its spec's 1", shard.MinConfirmations)
+	}
+
+	// A node declaring an engine keeps it, to be checked against its shard
+	declared := DefaultConfig()
+	declared.ShardID = 1
+	declared.ConsensusType = "pos"
+	if err := declared.ApplyGenesis(genesis); err != nil {
+		t.Fatal(err)
+	}
+	if declared.ConsensusType != "pos" {
+		t.Errorf("declared consensus replaced by %s", declared.ConsensusType)
+	}
+
+	// Without a genesis file the config describes its own genesis
+	own := DefaultConfig()
+	if err := own.InitGenesis(); err != nil {
+		t.Fatal(err)
+	}
+	if own.GenesisHash == "" || own.GenesisHash == hash || own.ChainID != DefaultChainID {
+		t.Errorf("own genesis hash = %q on chain %s", own.GenesisHash, own.ChainID)
+	}
+}
@@ -45,32 +45,31 @@ type ConsensusEngine interface {
         
         // HandleConsensusMessage handles consensus-specific messages
         HandleConsensusMessage(message []byte) error
+        
+        // SetRelaySelector sets how the relay rewarded for carrying
+        // cross-shard traffic out of the shard is chosen
+        SetRelaySelector(selector RelaySelector)
+        
+        // SetRelayChecker sets how the recipients of relay fees in blocks
+        // are checked against the active relay set
+        SetRelayChecker(checker RelayChecker)
+        
+        // SetMessageSender sets how the engine broadcasts consensus messages
+        // to the other nodes of its shard
+        SetMessageSender(sender MessageSender)
 }
 
-// NewConsensusEngine creates a new consensus engine based on the config
-func NewConsensusEngine(config *config.Config, blockchain *core.Blockchain) (ConsensusEngine, error) {
-        logger := utils.GetLogger()
-        
-        switch ConsensusType(config.ConsensusType) {
-        case ProofOfStake:
-                logger.Info("Initializing Proof of Stake consensus engine")
-                return NewPoSConsensus(config, blockchain)
-        case CrossChannel:
-                logger.Info("Initializing Cross-Channel consensus engine")
-                // Implement Cross-Channel consensus
-                return nil, utils.NewError("Cross-Channel consensus not implemented yet")
-        case ProofOfWork:
-                logger.Info("Initializing Proof of Work consensus engine")
-                // Implement PoW consensus
-                return nil, utils.NewError("Proof of Work consensus not implemented yet")
-        case PBFT:
-                logger.Info("Initializing PBFT consensus engine")
-                // Implement PBFT consensus
-                return nil, utils.NewError("PBFT consensus not implemented yet")
-        default:
-                logger.Warn("Unknown consensus type, defaulting to PoS", "type", config.ConsensusType)
-                return NewPoSConsensus(config, blockchain)
-        }
+// MessageSender broadcasts an engine's consensus message to the other nodes
+// of its shard. The network delivers it to their HandleConsensusMessage.
+type MessageSender func(message []byte) error
+
+// BranchValidator is implemented by engines under which a branch that
+// outranks the chain, see core.Blockchain.Outranks, replaces the blocks it
+// forks from. Engines that finalize
+// every block they commit never switch branches.
+type BranchValidator interface {
+        // ValidateBranchBlock validates a block on top of its parent
+        ValidateBranchBlock(block *core.Block, prev *core.Block) bool
 }
 
 // ConsensusParams holds parameters for the consensus algorithm
@@ -80,8 +79,9 @@ type ConsensusParams struct {
         MinConfirmations int // Minimum confirmations required
         
         // PoS specific parameters
-        MinStake         float64 // Minimum stake required for validators
-        StakingReward    float64 // Reward for staking
+        MinStake         core.Amount // Minimum stake required for validators
+        StakingReward    core.Amount // Block reward minted for the producer
+        SlashFraction    float64 // Share of stake burned when a validator is slashed
         
         // PBFT specific parameters
         Validators       []string // List of validator node IDs
@@ -98,8 +98,9 @@ func DefaultConsensusParams() ConsensusParams {
         return ConsensusParams{
                 BlockTime:        5,
                 MinConfirmations: 6,
-                MinStake:         1000.0,
-                StakingReward:    5.0,
+                MinStake:         utils.Coins(1000),
+                StakingReward:    utils.Coins(5),
+                SlashFraction:    0.5,
                 Validators:       []string{},
                 ViewChangeTimeout: 30,
                 CrossChannelVerify: true,
@@ -107,3 +108,53 @@ func DefaultConsensusParams() ConsensusParams {
                 CrossLayerTimeout:  15,
         }
 }
+
+// fillBlock stamps a block with the time of the chain's clock and adds the
+// pending transactions that still apply, up to the block size limit, and
+// the cross-shard references waiting to be anchored
+func fillBlock(blockchain *core.Blockchain, cfg *config.Config, block *core.Block, logger *utils.Logger) {
+        block.Header.Timestamp = blockchain.Now().Unix()
+        
+        // Add transactions to the block (up to max limit), skipping any that
+        // no longer apply on top of the transactions already selected
+        trialState := blockchain.State.Copy()
+        trialState.BeginBlock(block.Header.Height)
+        txCount := 0
+        for _, tx := range blockchain.GetPendingTransactions() {
+                if txCount >= cfg.MaxTransPerBlock {
+                        break
+                }
+                
+                // Transfers leaving the shard are debited here; other
+                // cross-shard transactions belong to another shard
+                if tx.IsCrossShard() && tx.SourceShard != blockchain.Config.ShardID {
+                        continue
+                }
+                
+                if err := trialState.ApplyTransaction(tx, block.Header.Height); err != nil {
+                        logger.Debug("Skipping transaction", "txHash", tx.Hash, "error", err)
+                        continue
+                }
+                
+                block.AddTransaction(*tx)
+                txCount++
+        }
+        
+        // Anchor headers from other shards (e.g. lower layers) queued by the
+        // sharding layer router
+        for _, ref := range blockchain.GetPendingCrossRefs() {
+                block.AddCrossReference(ref.ShardID, ref.BlockHash, ref.Height)
+        }
+}
+
+// signBlock signs a block as the node, and with the node's validator key
+// when it has one so the block can be held against it as evidence
+func signBlock(cfg *config.Config, block *core.Block) error {
+        if err := block.Sign(cfg.NodeID); err != nil {
+                return err
+        }
+        if cfg.ValidatorKey == "" {
+                return nil
+        }
+        return block.SignWithKey(cfg.ValidatorKey)
+}
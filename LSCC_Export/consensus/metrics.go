@@ -0,0 +1,25 @@
+package consensus
+
+import (
+	"strconv"
+	"time"
+
+	"lscc/core"
+	"lscc/metrics"
+)
+
+// roundDuration is the time from the start of a consensus round to its block
+// being added to the chain, per shard, layer and engine
+var roundDuration = metrics.Default().NewHistogramVec(
+	"lscc_consensus_round_duration_seconds",
+	"Duration of consensus rounds that produced a block",
+	metrics.DefBuckets,
+	"shard", "layer", "engine",
+)
+
+// observeRound records a round of an engine that started at the given time
+// and has just added its block to the chain
+func observeRound(bc *core.Blockchain, engine ConsensusType, started time.Time) {
+	roundDuration.With(strconv.Itoa(bc.Config.ShardID), strconv.Itoa(bc.Layer), string(engine)).
+		Observe(time.Since(started).Seconds())
+}
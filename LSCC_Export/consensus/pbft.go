@@ -0,0 +1,1119 @@
+package consensus
+
+import (
+        "encoding/json"
+        "errors"
+        "fmt"
+        "sync"
+        "time"
+
+        "lscc/config"
+        "lscc/core"
+        "lscc/utils"
+)
+
+func init() {
+        Register(PBFT, EngineSpec{
+                Description: "PBFT with a fixed committee and immediate finality",
+                DefaultParams: func(cfg *config.Config) EngineParams {
+                        return &PBFTParams{
+                                Validators:        []string{cfg.NodeID},
+                                ViewChangeTimeout: DefaultConsensusParams().ViewChangeTimeout,
+                        }
+                },
+                New: func(cfg *config.Config, blockchain *core.Blockchain, params EngineParams) (ConsensusEngine, error) {
+                        return NewPBFTConsensus(cfg, blockchain, *params.(*PBFTParams))
+                },
+        })
+}
+
+// PBFT
+//
+// A fixed committee of n validators tolerates f = (n-1)/3 faulty members.
+// The primary for a height rotates through the committee with the height
+// and the view. It proposes a block in a pre-prepare message; members that
+// accept it send prepare, and after 2f+1 prepares send commit. A block with
+// 2f+1 commits is added to the chain and finalized as a checkpoint at once.
+//
+// Views count from zero at every height. When no block commits within the
+// view change timeout, a member stops taking part in the view and sends a
+// view-change for the next one, carrying the certificate of the block it
+// prepared at the height, if any: the signed votes of a quorum for it. Once
+// f+1 members ask to leave a view, the others join them. The primary of the
+// new view starts it with a new-view carrying 2f+1 view-changes and the block
+// to agree on: the block of the latest prepared certificate among them, so a
+// block that may have committed is never replaced, or a new block if none
+// was prepared. Members check the new-view against its view-changes and
+// prepare its block.
+
+// PBFTParams are the parameters of the PBFT engine
+type PBFTParams struct {
+        Validators        []string `json:"validators"`          // Committee node IDs
+        ViewChangeTimeout int      `json:"view_change_timeout"` // Seconds without a commit before the view changes
+}
+
+// Validate checks the PBFT parameters
+func (p *PBFTParams) Validate() error {
+        if len(p.Validators) == 0 {
+                return errors.New("validators must name at least one committee member")
+        }
+        seen := make(map[string]bool, len(p.Validators))
+        for _, validator := range p.Validators {
+                if validator == "" {
+                        return errors.New("validators cannot be empty")
+                }
+                if seen[validator] {
+                        return fmt.Errorf("validator %s is listed twice", validator)
+                }
+                seen[validator] = true
+        }
+        if p.ViewChangeTimeout <= 0 {
+                return errors.New("view_change_timeout must be positive")
+        }
+        return nil
+}
+
+// pbftPhase is a step of the PBFT protocol
+type pbftPhase string
+
+const (
+        pbftPrePrepare pbftPhase = "pre-prepare"
+        pbftPrepare    pbftPhase = "prepare"
+        pbftCommit     pbftPhase = "commit"
+        pbftViewChange pbftPhase = "view-change"
+        pbftNewView    pbftPhase = "new-view"
+        pbftCatchUp    pbftPhase = "catch-up" // Asks for the block decided at a height
+        pbftDecided    pbftPhase = "decided"  // Answers a catch-up with the block and its commits
+)
+
+// maxDecided bounds the heights whose commit certificates a member keeps to
+// let members that fell behind catch up
+const maxDecided = 1024
+
+// pbftMessage is a signed PBFT protocol message
+type pbftMessage struct {
+        Phase       pbftPhase        `json:"phase"`
+        View        uint64           `json:"view"`
+        Height      uint64           `json:"height"`
+        BlockHash   string           `json:"block_hash"`             // For a view-change, the prepared block's
+        Block       *core.Block      `json:"block,omitempty"`        // Pre-prepare and new-view only
+        Prepared    *pbftCertificate `json:"prepared,omitempty"`     // View-change only
+        ViewChanges []*pbftMessage   `json:"view_changes,omitempty"` // New-view only
+        Committed   *pbftCertificate `json:"committed,omitempty"`    // Decided only
+        NodeID      string           `json:"node_id"`
+        Signature   string           `json:"signature"`
+}
+
+// pbftCertificate proves a block was prepared in a view: the pre-prepare
+// or new-view that proposed it and the prepares of a quorum of the
+// committee. A commit certificate holds the commits of a quorum instead and
+// proves the block was decided.
+type pbftCertificate struct {
+        Block *core.Block    `json:"block"`
+        Votes []*pbftMessage `json:"votes"`
+}
+
+// signingPayload returns the bytes a member signs for a message
+func (m *pbftMessage) signingPayload() []byte {
+        return []byte(fmt.Sprintf("pbft:%s:%d:%d:%s", m.Phase, m.View, m.Height, m.BlockHash))
+}
+
+// verify checks the message signature was made over its contents by the
+// member it names as its sender
+func (m *pbftMessage) verify() error {
+        // utils.Sign produces "signed:<key prefix>:<payload hash>"
+        payload := m.signingPayload()
+        expected, err := utils.Sign(payload, m.NodeID)
+        if err != nil {
+                return err
+        }
+        if m.Signature != expected || !utils.VerifySignature(payload, m.Signature, m.NodeID) {
+                return errors.New("invalid PBFT message signature")
+        }
+        return nil
+}
+
+// vote returns the message without the block and proofs it carries, which
+// its signature does not cover
+func (m *pbftMessage) vote() *pbftMessage {
+        vote := *m
+        vote.Block = nil
+        vote.Prepared = nil
+        vote.ViewChanges = nil
+        vote.Committed = nil
+        return &vote
+}
+
+// pbftRound collects the votes for one block proposed in the current view
+type pbftRound struct {
+        block      *core.Block
+        prepares   map[string]*pbftMessage // Proposal and prepares by sender
+        commits    map[string]*pbftMessage // Commits by sender
+        sentCommit bool
+}
+
+// PBFTConsensus implements PBFT over a fixed committee
+type PBFTConsensus struct {
+        blockchain   *core.Blockchain
+        config       *config.Config
+        params       PBFTParams
+        view         uint64                  // View at the current height
+        nextView     uint64                  // View asked for, above view while leaving it
+        height       uint64                  // Height being agreed on
+        rounds       map[string]*pbftRound   // Rounds in the current view by block hash
+        prepared     *pbftCertificate        // Latest block prepared at the current height
+        viewChanges  map[string]*pbftMessage // Latest view-change at the current height by sender
+        future       []*pbftMessage          // Messages for later views, replayed when one starts
+        decided      map[uint64]*pbftCertificate // Commit certificates of recent heights
+        catchUpAt    time.Time                   // When this member last asked to catch up
+        proposed     bool
+        lastProgress time.Time
+        heightStart  time.Time // When the current height started
+        running      bool
+        stopChan     chan struct{}
+        mu           sync.Mutex
+        logger       *utils.Logger
+        rewards      *rewarder
+        send         MessageSender
+}
+
+// NewPBFTConsensus creates a new PBFT consensus engine
+func NewPBFTConsensus(config *config.Config, blockchain *core.Blockchain, params PBFTParams) (*PBFTConsensus, error) {
+        return &PBFTConsensus{
+                blockchain:   blockchain,
+                config:       config,
+                params:       params,
+                rounds:       make(map[string]*pbftRound),
+                viewChanges:  make(map[string]*pbftMessage),
+                decided:      make(map[uint64]*pbftCertificate),
+                lastProgress: time.Now(),
+                stopChan:     make(chan struct{}),
+                logger:       utils.GetLogger().Named("consensus"),
+                rewards:      newRewarder(PBFT, config),
+        }, nil
+}
+
+// Start starts the consensus engine
+func (pbft *PBFTConsensus) Start() error {
+        pbft.mu.Lock()
+        defer pbft.mu.Unlock()
+
+        if pbft.running {
+                return errors.New("consensus already running")
+        }
+
+        pbft.running = true
+        go pbft.consensusLoop()
+
+        pbft.logger.Info("PBFT consensus started",
+                "committee", len(pbft.params.Validators),
+                "quorum", pbft.quorum())
+        return nil
+}
+
+// Stop stops the consensus engine
+func (pbft *PBFTConsensus) Stop() error {
+        pbft.mu.Lock()
+        defer pbft.mu.Unlock()
+
+        if !pbft.running {
+                return errors.New("consensus not running")
+        }
+
+        close(pbft.stopChan)
+        pbft.running = false
+
+        pbft.logger.Info("PBFT consensus stopped")
+        return nil
+}
+
+// consensusLoop proposes blocks when this node is primary and changes view
+// when the committee stops making progress
+func (pbft *PBFTConsensus) consensusLoop() {
+        ticker := time.NewTicker(time.Duration(pbft.config.BlockTime) * time.Second)
+        defer ticker.Stop()
+
+        // Move to the next height as soon as the chain grows, by a commit or
+        // by a block synced from peers, rather than at the next tick
+        var chainEvents <-chan core.Event
+        if bus := pbft.blockchain.Events(); bus != nil {
+                sub := bus.Subscribe("pbft", 16, core.EventBlockAdded, core.EventBlockReorged)
+                defer sub.Unsubscribe()
+                chainEvents = sub.Events()
+        }
+
+        for {
+                select {
+                case <-pbft.stopChan:
+                        return
+                case event := <-chainEvents:
+                        if event.ShardID == pbft.config.ShardID {
+                                pbft.mu.Lock()
+                                pbft.syncHeight()
+                                pbft.mu.Unlock()
+                        }
+                case <-ticker.C:
+                        pbft.mu.Lock()
+                        pbft.syncHeight()
+                        if time.Since(pbft.lastProgress) > time.Duration(pbft.params.ViewChangeTimeout)*time.Second {
+                                if err := pbft.startViewChange(pbft.nextView + 1); err != nil {
+                                        pbft.logger.Error("Failed to send view change", "error", err)
+                                }
+                        }
+                        // Later views are started by their primary's new-view
+                        if !pbft.proposed && pbft.view == 0 && !pbft.leavingView() && pbft.primary() == pbft.config.NodeID {
+                                if err := pbft.propose(); err != nil {
+                                        pbft.logger.Error("Failed to propose block", "error", err)
+                                }
+                        }
+                        pbft.mu.Unlock()
+                }
+        }
+}
+
+// faulty returns the number of faulty members tolerated, f
+func (pbft *PBFTConsensus) faulty() int {
+        return (len(pbft.params.Validators) - 1) / 3
+}
+
+// quorum returns the number of matching votes needed, 2f+1
+func (pbft *PBFTConsensus) quorum() int {
+        return 2*pbft.faulty() + 1
+}
+
+// primary returns the member proposing at the current height and view.
+// Must be called with the lock held.
+func (pbft *PBFTConsensus) primary() string {
+        return pbft.primaryFor(pbft.height, pbft.view)
+}
+
+// primaryFor returns the member proposing at a height and view
+func (pbft *PBFTConsensus) primaryFor(height, view uint64) string {
+        validators := pbft.params.Validators
+        return validators[(height+view)%uint64(len(validators))]
+}
+
+// leavingView reports whether this member asked to leave the current view.
+// Must be called with the lock held.
+func (pbft *PBFTConsensus) leavingView() bool {
+        return pbft.nextView > pbft.view
+}
+
+// isMember checks if a node belongs to the committee
+func (pbft *PBFTConsensus) isMember(nodeID string) bool {
+        for _, validator := range pbft.params.Validators {
+                if validator == nodeID {
+                        return true
+                }
+        }
+        return false
+}
+
+// syncHeight moves to the next height once the chain has grown, dropping
+// the rounds of the old height. Must be called with the lock held.
+func (pbft *PBFTConsensus) syncHeight() {
+        next := pbft.blockchain.GetHeight() + 1
+        if next == pbft.height {
+                return
+        }
+        pbft.height = next
+        pbft.view = 0
+        pbft.nextView = 0
+        pbft.rounds = make(map[string]*pbftRound)
+        pbft.prepared = nil
+        pbft.viewChanges = make(map[string]*pbftMessage)
+        pbft.future = nil
+        pbft.proposed = false
+        pbft.lastProgress = time.Now()
+        pbft.heightStart = pbft.lastProgress
+}
+
+// enterView starts a later view at the current height. Must be called with
+// the lock held.
+func (pbft *PBFTConsensus) enterView(view uint64) {
+        pbft.view = view
+        pbft.nextView = view
+        pbft.rounds = make(map[string]*pbftRound)
+        pbft.proposed = false
+        pbft.lastProgress = time.Now()
+        pbft.logger.Warn("PBFT view changed", "view", view, "height", pbft.height, "primary", pbft.primary())
+}
+
+// startViewChange stops taking part in the current view and asks the
+// committee to move to a later one, reporting the block this member
+// prepared at the height. Must be called with the lock held.
+func (pbft *PBFTConsensus) startViewChange(view uint64) error {
+        pbft.nextView = view
+        pbft.lastProgress = time.Now()
+
+        msg := &pbftMessage{Phase: pbftViewChange, View: view}
+        if pbft.prepared != nil {
+                hash, err := pbft.prepared.Block.Hash()
+                if err != nil {
+                        return err
+                }
+                msg.BlockHash = hash
+                msg.Prepared = pbft.prepared
+        }
+        if err := pbft.sign(msg); err != nil {
+                return err
+        }
+        pbft.logger.Warn("PBFT view change",
+                "view", pbft.view, "nextView", view, "height", pbft.height, "prepared", msg.BlockHash)
+
+        sendErr := pbft.transmit(msg)
+        if err := pbft.recordViewChange(msg); err != nil {
+                return err
+        }
+        return sendErr
+}
+
+// recordViewChange keeps a member's request to leave the current view,
+// joins a later view once f+1 members asked for one, and starts a view this
+// member is primary of once a quorum asked for it. Must be called with the
+// lock held.
+func (pbft *PBFTConsensus) recordViewChange(msg *pbftMessage) error {
+        if msg.View <= pbft.view {
+                return nil
+        }
+        if latest := pbft.viewChanges[msg.NodeID]; latest != nil && latest.View >= msg.View {
+                return nil
+        }
+        pbft.viewChanges[msg.NodeID] = msg
+
+        // f+1 members include an honest one, so join the lowest view they
+        // ask for rather than wait for the own timeout
+        leaving, lowest := 0, uint64(0)
+        for _, vc := range pbft.viewChanges {
+                if vc.View > pbft.nextView {
+                        leaving++
+                        if lowest == 0 || vc.View < lowest {
+                                lowest = vc.View
+                        }
+                }
+        }
+        if leaving > pbft.faulty() {
+                return pbft.startViewChange(lowest)
+        }
+
+        // Having asked for a later view, this member must not start an
+        // earlier one: its view-change may be counted there without a block
+        // it would prepare here
+        if msg.View < pbft.nextView || pbft.primaryFor(pbft.height, msg.View) != pbft.config.NodeID {
+                return nil
+        }
+        proof := make([]*pbftMessage, 0, len(pbft.viewChanges))
+        for _, vc := range pbft.viewChanges {
+                if vc.View >= msg.View {
+                        proof = append(proof, vc)
+                }
+        }
+        if len(proof) < pbft.quorum() {
+                return nil
+        }
+        return pbft.sendNewView(msg.View, proof)
+}
+
+// sendNewView starts a view this member is primary of, proposing the block
+// of the latest prepared certificate among the view-changes, or a new one.
+// Must be called with the lock held.
+func (pbft *PBFTConsensus) sendNewView(view uint64, proof []*pbftMessage) error {
+        block := latestPrepared(proof)
+        if block == nil {
+                var err error
+                if block, err = pbft.CreateBlock(); err != nil {
+                        return err
+                }
+        }
+        hash, err := block.Hash()
+        if err != nil {
+                return err
+        }
+
+        pbft.enterView(view)
+        msg := &pbftMessage{Phase: pbftNewView, View: view, BlockHash: hash, Block: block, ViewChanges: proof}
+        if err := pbft.sign(msg); err != nil {
+                return err
+        }
+        pbft.proposed = true
+        round := pbft.round(hash)
+        round.block = block
+        round.prepares[pbft.config.NodeID] = msg.vote()
+
+        if err := pbft.transmit(msg); err != nil {
+                return err
+        }
+        pbft.logger.Info("PBFT new view started", "height", pbft.height, "view", view, "hash", hash)
+        return pbft.replayFuture()
+}
+
+// latestPrepared returns the block of the certificate of the latest view
+// among verified view-changes, if any
+func latestPrepared(viewChanges []*pbftMessage) *core.Block {
+        var latest *pbftCertificate
+        for _, vc := range viewChanges {
+                if vc.Prepared == nil {
+                        continue
+                }
+                if latest == nil || vc.Prepared.Votes[0].View > latest.Votes[0].View {
+                        latest = vc.Prepared
+                }
+        }
+        if latest == nil {
+                return nil
+        }
+        return latest.Block
+}
+
+// verifyCertificate checks that a certificate proves its block was prepared
+// at a height, returning the view it was prepared in and the block hash
+func (pbft *PBFTConsensus) verifyCertificate(cert *pbftCertificate, height uint64) (uint64, string, error) {
+        if cert.Block == nil || len(cert.Votes) == 0 {
+                return 0, "", errors.New("prepared certificate needs a block and votes")
+        }
+        hash, err := cert.Block.Hash()
+        if err != nil {
+                return 0, "", err
+        }
+        view := cert.Votes[0].View
+        signers := make(map[string]bool, len(cert.Votes))
+        for _, vote := range cert.Votes {
+                if vote.View != view || vote.Height != height || vote.BlockHash != hash {
+                        return 0, "", errors.New("prepared certificate votes are not for one block")
+                }
+                proposal := vote.Phase == pbftPrePrepare || vote.Phase == pbftNewView
+                if vote.Phase != pbftPrepare && !(proposal && vote.NodeID == pbft.primaryFor(height, view)) {
+                        return 0, "", fmt.Errorf("prepared certificate holds a %s from %s", vote.Phase, vote.NodeID)
+                }
+                if !pbft.isMember(vote.NodeID) {
+                        return 0, "", fmt.Errorf("prepared certificate holds a vote from non-member %s", vote.NodeID)
+                }
+                if err := vote.verify(); err != nil {
+                        return 0, "", err
+                }
+                signers[vote.NodeID] = true
+        }
+        if len(signers) < pbft.quorum() {
+                return 0, "", fmt.Errorf("prepared certificate has %d votes, quorum is %d", len(signers), pbft.quorum())
+        }
+        return view, hash, nil
+}
+
+// verifyViewChange checks a view-change and the certificate it carries
+func (pbft *PBFTConsensus) verifyViewChange(msg *pbftMessage) error {
+        if msg.Prepared == nil {
+                if msg.BlockHash != "" {
+                        return errors.New("view change names a prepared block without its certificate")
+                }
+                return nil
+        }
+        view, hash, err := pbft.verifyCertificate(msg.Prepared, msg.Height)
+        if err != nil {
+                return fmt.Errorf("view change from %s: %w", msg.NodeID, err)
+        }
+        if hash != msg.BlockHash || view >= msg.View {
+                return fmt.Errorf("view change from %s does not match its certificate", msg.NodeID)
+        }
+        return nil
+}
+
+// handleNewView checks that a new-view carries a quorum of view-changes and
+// proposes the block they require, then starts its view and prepares the
+// block. Must be called with the lock held.
+func (pbft *PBFTConsensus) handleNewView(msg *pbftMessage) error {
+        if msg.View <= pbft.view || msg.View < pbft.nextView {
+                return nil
+        }
+        if msg.NodeID != pbft.primaryFor(msg.Height, msg.View) {
+                return fmt.Errorf("new view %d from %s, primary is %s",
+                        msg.View, msg.NodeID, pbft.primaryFor(msg.Height, msg.View))
+        }
+        if msg.Block == nil {
+                return errors.New("new view must carry its block")
+        }
+        if hash, err := msg.Block.Hash(); err != nil || hash != msg.BlockHash {
+                return errors.New("new view block does not match its hash")
+        }
+
+        signers := make(map[string]bool, len(msg.ViewChanges))
+        for _, vc := range msg.ViewChanges {
+                if vc.Phase != pbftViewChange || vc.Height != msg.Height || vc.View < msg.View || !pbft.isMember(vc.NodeID) {
+                        return errors.New("new view carries an invalid view change")
+                }
+                if err := vc.verify(); err != nil {
+                        return err
+                }
+                if err := pbft.verifyViewChange(vc); err != nil {
+                        return err
+                }
+                signers[vc.NodeID] = true
+        }
+        if len(signers) < pbft.quorum() {
+                return fmt.Errorf("new view carries %d view changes, quorum is %d", len(signers), pbft.quorum())
+        }
+
+        // A block that may have committed in an earlier view must be kept
+        if required := latestPrepared(msg.ViewChanges); required != nil {
+                if hash, err := required.Hash(); err != nil || hash != msg.BlockHash {
+                        return errors.New("new view does not propose the latest prepared block")
+                }
+        } else if msg.Block.Header.ValidatorID != msg.NodeID {
+                return errors.New("new view must carry the primary's block")
+        }
+        if !pbft.ValidateBlock(msg.Block) {
+                return errors.New("invalid block in new view")
+        }
+
+        pbft.enterView(msg.View)
+        pbft.proposed = true
+        round := pbft.round(msg.BlockHash)
+        round.block = msg.Block
+        round.prepares[msg.NodeID] = msg.vote()
+        if err := pbft.prepare(round, msg.BlockHash); err != nil {
+                return err
+        }
+        if err := pbft.advance(msg.BlockHash); err != nil {
+                return err
+        }
+        return pbft.replayFuture()
+}
+
+// replayFuture handles the messages kept for the view just started. Must be
+// called with the lock held.
+func (pbft *PBFTConsensus) replayFuture() error {
+        future := pbft.future
+        pbft.future = nil
+        for _, msg := range future {
+                if msg.View < pbft.view {
+                        continue
+                }
+                if err := pbft.handle(msg); err != nil {
+                        pbft.logger.Debug("Replayed PBFT message rejected", "phase", msg.Phase, "from", msg.NodeID, "error", err)
+                }
+        }
+        return nil
+}
+
+// prepare records and sends this member's prepare for a proposed block.
+// Must be called with the lock held.
+func (pbft *PBFTConsensus) prepare(round *pbftRound, hash string) error {
+        msg := &pbftMessage{Phase: pbftPrepare, View: pbft.view, BlockHash: hash}
+        if err := pbft.sign(msg); err != nil {
+                return err
+        }
+        round.prepares[pbft.config.NodeID] = msg
+        return pbft.transmit(msg)
+}
+
+// propose creates a block and sends it to the committee in a pre-prepare.
+// Must be called with the lock held.
+func (pbft *PBFTConsensus) propose() error {
+        block, err := pbft.CreateBlock()
+        if err != nil {
+                return err
+        }
+        hash, err := block.Hash()
+        if err != nil {
+                return err
+        }
+
+        msg := &pbftMessage{Phase: pbftPrePrepare, View: pbft.view, BlockHash: hash, Block: block}
+        if err := pbft.sign(msg); err != nil {
+                return err
+        }
+        pbft.proposed = true
+        round := pbft.round(hash)
+        round.block = block
+        round.prepares[pbft.config.NodeID] = msg.vote()
+
+        if err := pbft.transmit(msg); err != nil {
+                return err
+        }
+        pbft.logger.Info("PBFT block proposed", "height", pbft.height, "view", pbft.view, "hash", hash)
+        return pbft.advance(hash)
+}
+
+// round returns the round for a block hash at the current height. Must be
+// called with the lock held.
+func (pbft *PBFTConsensus) round(hash string) *pbftRound {
+        round, exists := pbft.rounds[hash]
+        if !exists {
+                round = &pbftRound{
+                        prepares: make(map[string]*pbftMessage),
+                        commits:  make(map[string]*pbftMessage),
+                }
+                pbft.rounds[hash] = round
+        }
+        return round
+}
+
+// sign signs a message of this member at the current height. Must be called
+// with the lock held.
+func (pbft *PBFTConsensus) sign(msg *pbftMessage) error {
+        return pbft.signAt(msg, pbft.height)
+}
+
+// signAt signs a message of this member about a height
+func (pbft *PBFTConsensus) signAt(msg *pbftMessage, height uint64) error {
+        msg.Height = height
+        msg.NodeID = pbft.config.NodeID
+        signature, err := utils.Sign(msg.signingPayload(), pbft.config.NodeID)
+        if err != nil {
+                return err
+        }
+        msg.Signature = signature
+        return nil
+}
+
+// transmit sends a signed message to the committee. Must be called with the
+// lock held.
+func (pbft *PBFTConsensus) transmit(msg *pbftMessage) error {
+        if pbft.send == nil {
+                return nil
+        }
+        data, err := json.Marshal(msg)
+        if err != nil {
+                return err
+        }
+        return pbft.send(data)
+}
+
+// advance keeps the certificate of a block with a prepare quorum and sends
+// commit for it, and commits the block once it has a commit quorum. A
+// member leaving the view counts votes but sends none. Must be called with
+// the lock held.
+func (pbft *PBFTConsensus) advance(hash string) error {
+        round := pbft.rounds[hash]
+        if round == nil || round.block == nil {
+                return nil
+        }
+
+        if len(round.prepares) >= pbft.quorum() {
+                if pbft.prepared == nil || pbft.prepared.Votes[0].View < pbft.view {
+                        cert := &pbftCertificate{Block: round.block}
+                        for _, vote := range round.prepares {
+                                cert.Votes = append(cert.Votes, vote)
+                        }
+                        pbft.prepared = cert
+                }
+                if !round.sentCommit && !pbft.leavingView() {
+                        round.sentCommit = true
+                        msg := &pbftMessage{Phase: pbftCommit, View: pbft.view, BlockHash: hash}
+                        if err := pbft.sign(msg); err != nil {
+                                return err
+                        }
+                        round.commits[pbft.config.NodeID] = msg
+                        if err := pbft.transmit(msg); err != nil {
+                                return err
+                        }
+                }
+        }
+
+        if len(round.commits) >= pbft.quorum() {
+                return pbft.commit(hash, commitCertificate(round))
+        }
+        return nil
+}
+
+// commitCertificate returns the certificate of a round's commits
+func commitCertificate(round *pbftRound) *pbftCertificate {
+        cert := &pbftCertificate{Block: round.block}
+        for _, vote := range round.commits {
+                cert.Votes = append(cert.Votes, vote)
+        }
+        return cert
+}
+
+// commit adds a block with a commit quorum to the chain and finalizes it,
+// keeping the commits so members behind can catch up. Must be called with
+// the lock held.
+func (pbft *PBFTConsensus) commit(hash string, cert *pbftCertificate) error {
+        block := cert.Block
+        if err := pbft.blockchain.AddBlock(block); err != nil {
+                return err
+        }
+        if err := pbft.blockchain.FinalizeCheckpoint(block.Header.Height, hash); err != nil {
+                return err
+        }
+        observeRound(pbft.blockchain, PBFT, pbft.heightStart)
+        pbft.decided[block.Header.Height] = cert
+        if block.Header.Height > maxDecided {
+                delete(pbft.decided, block.Header.Height-maxDecided)
+        }
+
+        pbft.logger.Info("PBFT block committed",
+                "height", block.Header.Height,
+                "view", pbft.view,
+                "primary", block.Header.ValidatorID,
+                "transactions", len(block.Transactions))
+        pbft.syncHeight()
+        return nil
+}
+
+// CreateBlock creates a new block with pending transactions
+func (pbft *PBFTConsensus) CreateBlock() (*core.Block, error) {
+        latestBlock := pbft.blockchain.GetLatestBlock()
+        if latestBlock == nil {
+                return nil, errors.New("no blocks in blockchain")
+        }
+        lastHash, err := latestBlock.Hash()
+        if err != nil {
+                return nil, err
+        }
+
+        newBlock := core.NewBlock(
+                lastHash,
+                latestBlock.Header.Height+1,
+                pbft.config.ShardID,
+                latestBlock.Header.Layer,
+                pbft.config.NodeID,
+        )
+        fillBlock(pbft.blockchain, pbft.config, newBlock, pbft.logger)
+
+        // Split the block reward across the committee
+        if err := pbft.rewards.addCoinbase(newBlock, pbft.params.Validators); err != nil {
+                return nil, err
+        }
+
+        if err := signBlock(pbft.config, newBlock); err != nil {
+                return nil, err
+        }
+        return newBlock, nil
+}
+
+// ValidateBlock validates a block according to PBFT rules
+func (pbft *PBFTConsensus) ValidateBlock(block *core.Block) bool {
+        if !pbft.isMember(block.Header.ValidatorID) {
+                pbft.logger.Warn("Block from non-member", "validator", block.Header.ValidatorID)
+                return false
+        }
+        if !block.VerifySignature(block.Header.ValidatorID) {
+                pbft.logger.Warn("Invalid block signature")
+                return false
+        }
+        if !block.IsValid(pbft.blockchain.GetLatestBlock()) {
+                pbft.logger.Warn("Invalid block structure")
+                return false
+        }
+        if err := pbft.blockchain.CheckTimestamp(block); err != nil {
+                pbft.logger.Warn("Invalid block timestamp", "error", err)
+                return false
+        }
+        for _, tx := range block.Transactions {
+                if !tx.IsValid() {
+                        pbft.logger.Warn("Invalid transaction in block", "txHash", tx.Hash)
+                        return false
+                }
+        }
+
+        // The block reward must be split across the committee
+        if err := pbft.rewards.verifyCoinbase(block, pbft.params.Validators); err != nil {
+                pbft.logger.Warn("Invalid coinbase", "error", err)
+                return false
+        }
+        return true
+}
+
+// ProcessBlock adds a block received outside the protocol, which is only
+// accepted once it has a commit quorum. Members that fell behind get the
+// blocks they missed with their commits through catch-up messages instead.
+func (pbft *PBFTConsensus) ProcessBlock(block *core.Block) error {
+        hash, err := block.Hash()
+        if err != nil {
+                return err
+        }
+
+        pbft.mu.Lock()
+        defer pbft.mu.Unlock()
+
+        pbft.syncHeight()
+        if block.Header.Height < pbft.height {
+                // Already committed through the protocol
+                return nil
+        }
+        round := pbft.rounds[hash]
+        if round == nil || len(round.commits) < pbft.quorum() {
+                return errors.New("block has not been committed by the PBFT committee")
+        }
+        cert := commitCertificate(round)
+        cert.Block = block
+        return pbft.commit(hash, cert)
+}
+
+// catchUp asks the committee for the block decided at the current height,
+// once messages of later heights show the others have moved on. Must be
+// called with the lock held.
+func (pbft *PBFTConsensus) catchUp() error {
+        if time.Since(pbft.catchUpAt) < time.Duration(pbft.config.BlockTime)*time.Second {
+                return nil
+        }
+        pbft.catchUpAt = time.Now()
+        msg := &pbftMessage{Phase: pbftCatchUp}
+        if err := pbft.sign(msg); err != nil {
+                return err
+        }
+        pbft.logger.Info("PBFT member behind, catching up", "height", pbft.height)
+        return pbft.transmit(msg)
+}
+
+// sendDecided answers a catch-up with the block decided at its height and
+// the commits that decided it, if this member kept them. Must be called
+// with the lock held.
+func (pbft *PBFTConsensus) sendDecided(height uint64) error {
+        cert := pbft.decided[height]
+        if cert == nil {
+                return nil
+        }
+        hash, err := cert.Block.Hash()
+        if err != nil {
+                return err
+        }
+        msg := &pbftMessage{Phase: pbftDecided, View: cert.Votes[0].View, BlockHash: hash, Committed: cert}
+        if err := pbft.signAt(msg, height); err != nil {
+                return err
+        }
+        return pbft.transmit(msg)
+}
+
+// handleDecided commits the block of a verified commit certificate for the
+// current height and asks for the next one. Must be called with the lock
+// held.
+func (pbft *PBFTConsensus) handleDecided(msg *pbftMessage) error {
+        if msg.Committed == nil {
+                return errors.New("decided message must carry its commits")
+        }
+        hash, err := pbft.verifyCommitCertificate(msg.Committed, msg.Height)
+        if err != nil {
+                return fmt.Errorf("decided message from %s: %w", msg.NodeID, err)
+        }
+        if hash != msg.BlockHash {
+                return fmt.Errorf("decided message from %s does not match its commits", msg.NodeID)
+        }
+        if !pbft.ValidateBlock(msg.Committed.Block) {
+                return errors.New("invalid decided block")
+        }
+        if err := pbft.commit(hash, msg.Committed); err != nil {
+                return err
+        }
+        pbft.logger.Info("PBFT block caught up", "height", msg.Height, "from", msg.NodeID)
+
+        // Ask for the next height right away; a member that is up to date
+        // has no certificate for it yet and stays silent
+        pbft.catchUpAt = time.Time{}
+        return pbft.catchUp()
+}
+
+// verifyCommitCertificate checks that a certificate holds the commits of a
+// quorum for its block in one view at a height, returning the block hash
+func (pbft *PBFTConsensus) verifyCommitCertificate(cert *pbftCertificate, height uint64) (string, error) {
+        if cert.Block == nil || len(cert.Votes) == 0 {
+                return "", errors.New("commit certificate needs a block and votes")
+        }
+        hash, err := cert.Block.Hash()
+        if err != nil {
+                return "", err
+        }
+        if cert.Block.Header.Height != height {
+                return "", errors.New("commit certificate block is for another height")
+        }
+        view := cert.Votes[0].View
+        signers := make(map[string]bool, len(cert.Votes))
+        for _, vote := range cert.Votes {
+                if vote.Phase != pbftCommit || vote.View != view || vote.Height != height || vote.BlockHash != hash {
+                        return "", errors.New("commit certificate votes are not commits of one block")
+                }
+                if !pbft.isMember(vote.NodeID) {
+                        return "", fmt.Errorf("commit certificate holds a vote from non-member %s", vote.NodeID)
+                }
+                if err := vote.verify(); err != nil {
+                        return "", err
+                }
+                signers[vote.NodeID] = true
+        }
+        if len(signers) < pbft.quorum() {
+                return "", fmt.Errorf("commit certificate has %d commits, quorum is %d", len(signers), pbft.quorum())
+        }
+        return hash, nil
+}
+
+// maxFutureMessages bounds the messages kept per committee member for views
+// not started yet
+const maxFutureMessages = 8
+
+// HandleConsensusMessage handles a PBFT protocol message from a committee
+// member
+func (pbft *PBFTConsensus) HandleConsensusMessage(message []byte) error {
+        var msg pbftMessage
+        if err := json.Unmarshal(message, &msg); err != nil {
+                return fmt.Errorf("invalid PBFT message: %w", err)
+        }
+        if !pbft.isMember(msg.NodeID) {
+                return fmt.Errorf("PBFT message from non-member %s", msg.NodeID)
+        }
+        if err := msg.verify(); err != nil {
+                return err
+        }
+
+        pbft.mu.Lock()
+        defer pbft.mu.Unlock()
+
+        pbft.syncHeight()
+        return pbft.handle(&msg)
+}
+
+// handle handles a verified message. Messages for a view not started yet are
+// kept until it starts. Must be called with the lock held.
+func (pbft *PBFTConsensus) handle(msg *pbftMessage) error {
+        switch msg.Phase {
+        case pbftCatchUp:
+                if msg.Height < pbft.height {
+                        return pbft.sendDecided(msg.Height)
+                }
+                return nil
+        case pbftDecided:
+                if msg.Height > pbft.height {
+                        return pbft.catchUp()
+                }
+                if msg.Height < pbft.height {
+                        return nil
+                }
+                return pbft.handleDecided(msg)
+        }
+
+        if msg.Height > pbft.height {
+                // The committee decided the current height without this
+                // member
+                if err := pbft.catchUp(); err != nil {
+                        return err
+                }
+        }
+        if msg.Height != pbft.height {
+                pbft.logger.Debug("Ignoring PBFT message for another height",
+                        "phase", msg.Phase, "height", msg.Height, "view", msg.View, "from", msg.NodeID)
+                return nil
+        }
+
+        switch msg.Phase {
+        case pbftViewChange:
+                if err := pbft.verifyViewChange(msg); err != nil {
+                        return err
+                }
+                return pbft.recordViewChange(msg)
+        case pbftNewView:
+                return pbft.handleNewView(msg)
+        }
+
+        if msg.View > pbft.view {
+                kept := 0
+                for _, future := range pbft.future {
+                        if future.NodeID == msg.NodeID {
+                                kept++
+                        }
+                }
+                if kept < maxFutureMessages {
+                        pbft.future = append(pbft.future, msg)
+                }
+                return nil
+        }
+        if msg.View < pbft.view {
+                pbft.logger.Debug("Ignoring PBFT message for an earlier view",
+                        "phase", msg.Phase, "height", msg.Height, "view", msg.View, "from", msg.NodeID)
+                return nil
+        }
+
+        switch msg.Phase {
+        case pbftPrePrepare:
+                if msg.NodeID != pbft.primary() {
+                        return fmt.Errorf("pre-prepare from %s, primary is %s", msg.NodeID, pbft.primary())
+                }
+                if msg.Block == nil || msg.Block.Header.ValidatorID != msg.NodeID {
+                        return errors.New("pre-prepare must carry the primary's block")
+                }
+                if hash, err := msg.Block.Hash(); err != nil || hash != msg.BlockHash {
+                        return errors.New("pre-prepare block does not match its hash")
+                }
+                if !pbft.ValidateBlock(msg.Block) {
+                        return errors.New("invalid block in pre-prepare")
+                }
+                if pbft.proposed || pbft.leavingView() {
+                        // An equivocating primary sent another block first, or
+                        // this member gave up on the view: keep this one
+                        // without preparing it, so it can still be committed if
+                        // the rest of the committee prepared it
+                        if round := pbft.round(msg.BlockHash); round.block == nil {
+                                round.block = msg.Block
+                                round.prepares[msg.NodeID] = msg.vote()
+                                if pbft.proposed {
+                                        pbft.logger.Warn("Conflicting PBFT pre-prepare",
+                                                "height", msg.Height, "view", msg.View, "primary", msg.NodeID, "hash", msg.BlockHash)
+                                }
+                        }
+                        return pbft.advance(msg.BlockHash)
+                }
+
+                pbft.proposed = true
+                pbft.lastProgress = time.Now()
+                round := pbft.round(msg.BlockHash)
+                round.block = msg.Block
+                round.prepares[msg.NodeID] = msg.vote()
+                if err := pbft.prepare(round, msg.BlockHash); err != nil {
+                        return err
+                }
+
+        case pbftPrepare:
+                pbft.round(msg.BlockHash).prepares[msg.NodeID] = msg.vote()
+
+        case pbftCommit:
+                pbft.round(msg.BlockHash).commits[msg.NodeID] = msg.vote()
+
+        default:
+                return fmt.Errorf("unknown PBFT phase %q", msg.Phase)
+        }
+
+        return pbft.advance(msg.BlockHash)
+}
+
+// SetRelaySelector sets how the relay rewarded for cross-shard traffic is
+// chosen
+func (pbft *PBFTConsensus) SetRelaySelector(selector RelaySelector) {
+        pbft.rewards.SetRelaySelector(selector)
+}
+
+// SetRelayChecker sets how relay payouts in blocks are checked
+func (pbft *PBFTConsensus) SetRelayChecker(checker RelayChecker) {
+        pbft.rewards.SetRelayChecker(checker)
+}
+
+// SetMessageSender sets how protocol messages reach the committee
+func (pbft *PBFTConsensus) SetMessageSender(sender MessageSender) {
+        pbft.mu.Lock()
+        defer pbft.mu.Unlock()
+        pbft.send = sender
+}
+
+// GetType returns the type of consensus algorithm
+func (pbft *PBFTConsensus) GetType() ConsensusType {
+        return PBFT
+}
+
+// GetStatus returns the current status of the consensus engine
+func (pbft *PBFTConsensus) GetStatus() map[string]interface{} {
+        pbft.mu.Lock()
+        defer pbft.mu.Unlock()
+
+        return map[string]interface{}{
+                "type":                string(PBFT),
+                "running":             pbft.running,
+                "committee":           pbft.params.Validators,
+                "quorum":              pbft.quorum(),
+                "view":                pbft.view,
+                "next_view":           pbft.nextView,
+                "view_changes":        len(pbft.viewChanges),
+                "height":              pbft.height,
+                "primary":             pbft.primary(),
+                "open_rounds":         len(pbft.rounds),
+                "view_change_timeout": pbft.params.ViewChangeTimeout,
+                "finalized_height":    pbft.blockchain.Finality.FinalizedHeight(),
+                "block_reward":        pbft.config.BlockReward,
+        }
+}
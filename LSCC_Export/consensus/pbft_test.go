@@ -0,0 +1,185 @@
+package consensus
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"lscc/config"
+	"lscc/core"
+	"lscc/utils"
+)
+
+// testCommittee is the committee of the test engines; node2 is the first
+// primary, of height 1 in view 0
+var testCommittee = []string{"node1", "node2", "node3", "node4"}
+
+// newTestPBFT returns a stopped PBFT engine of a committee member on a
+// fresh chain
+func newTestPBFT(t *testing.T, nodeID string) *PBFTConsensus {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.NodeID = nodeID
+	cfg.ConsensusType = string(PBFT)
+	pbft, err := NewPBFTConsensus(cfg, core.NewBlockchain(cfg), PBFTParams{
+		Validators: testCommittee, ViewChangeTimeout: 30,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pbft
+}
+
+// testMessage returns an encoded PBFT message at height 1 in view 0 signed
+// with a key
+func testMessage(t *testing.T, phase pbftPhase, hash, nodeID, key string, block *core.Block) []byte {
+	t.Helper()
+	return encodeMessage(t, signedMessage(t, &pbftMessage{
+		Phase: phase, Height: 1, BlockHash: hash, Block: block, NodeID: nodeID,
+	}, key))
+}
+
+// signedMessage signs a PBFT message with a key
+func signedMessage(t *testing.T, msg *pbftMessage, key string) *pbftMessage {
+	t.Helper()
+	signature, err := utils.Sign(msg.signingPayload(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Signature = signature
+	return msg
+}
+
+// encodeMessage encodes a PBFT message as it travels between members
+func encodeMessage(t *testing.T, msg *pbftMessage) []byte {
+	t.Helper()
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// proposeTo hands a member the primary's proposal for height 1
+func proposeTo(t *testing.T, member *PBFTConsensus) string {
+	t.Helper()
+	block, err := newTestPBFT(t, "node2").CreateBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := block.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := member.HandleConsensusMessage(testMessage(t, pbftPrePrepare, hash, "node2", "node2", block)); err != nil {
+		t.Fatalf("pre-prepare refused: %v", err)
+	}
+	return hash
+}
+
+func TestPBFTForgedVotesDoNotCount(t *testing.T) {
+	member := newTestPBFT(t, "node1")
+	hash := proposeTo(t, member)
+
+	// Prepares and commits of the other members, signed by someone else,
+	// are refused
+	for _, phase := range []pbftPhase{pbftPrepare, pbftCommit} {
+		for _, nodeID := range []string{"node2", "node3", "node4"} {
+			if err := member.HandleConsensusMessage(testMessage(t, phase, hash, nodeID, "mallory", nil)); err == nil {
+				t.Errorf("%s of %s signed by mallory accepted", phase, nodeID)
+			}
+		}
+	}
+
+	// Nor does a signature with another member's key prefix and a matching
+	// payload hash pass
+	msg := &pbftMessage{Phase: pbftCommit, Height: 1, BlockHash: hash, NodeID: "node3"}
+	msg.Signature = fmt.Sprintf("signed:node4:%s", utils.Hash(msg.signingPayload())[:16])
+	data, _ := json.Marshal(msg)
+	if err := member.HandleConsensusMessage(data); err == nil {
+		t.Error("commit of node3 with node4's signature accepted")
+	}
+
+	if height := member.blockchain.GetHeight(); height != 0 {
+		t.Fatalf("forged votes committed the block: height %d", height)
+	}
+}
+
+func TestPBFTCommitsWithQuorum(t *testing.T) {
+	member := newTestPBFT(t, "node1")
+	hash := proposeTo(t, member)
+
+	// With the primary's proposal and its own prepare, one more prepare
+	// makes the quorum of three; two more commits commit the block
+	steps := [][]byte{
+		testMessage(t, pbftPrepare, hash, "node3", "node3", nil),
+		testMessage(t, pbftCommit, hash, "node2", "node2", nil),
+		testMessage(t, pbftCommit, hash, "node3", "node3", nil),
+	}
+	for _, data := range steps {
+		if err := member.HandleConsensusMessage(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if height := member.blockchain.GetHeight(); height != 1 {
+		t.Fatalf("height = %d after a commit quorum, want 1", height)
+	}
+}
+
+// decidedMessage returns the answer of node2 to a catch-up for height 1,
+// carrying the commits of node2 to node4 signed with their keys
+func decidedMessage(t *testing.T, block *core.Block, hash string, keys map[string]string) []byte {
+	t.Helper()
+	cert := &pbftCertificate{Block: block}
+	for _, nodeID := range []string{"node2", "node3", "node4"} {
+		cert.Votes = append(cert.Votes, signedMessage(t, &pbftMessage{
+			Phase: pbftCommit, Height: 1, BlockHash: hash, NodeID: nodeID,
+		}, keys[nodeID]))
+	}
+	return encodeMessage(t, signedMessage(t, &pbftMessage{
+		Phase: pbftDecided, Height: 1, BlockHash: hash, NodeID: "node2", Committed: cert,
+	}, "node2"))
+}
+
+func TestPBFTCatchesUpWithCommitCertificate(t *testing.T) {
+	block, err := newTestPBFT(t, "node2").CreateBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := block.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A member that missed the whole round refuses the block without the
+	// commits of a quorum
+	member := newTestPBFT(t, "node1")
+	if err := member.ProcessBlock(block); err == nil {
+		t.Fatal("block without commits accepted")
+	}
+
+	// Commits signed by someone other than the members they name do not
+	// make a certificate
+	forged := decidedMessage(t, block, hash, map[string]string{
+		"node2": "mallory", "node3": "mallory", "node4": "mallory",
+	})
+	if err := member.HandleConsensusMessage(forged); err == nil {
+		t.Fatal("decided block with forged commits accepted")
+	}
+	if height := member.blockchain.GetHeight(); height != 0 {
+		t.Fatalf("forged commits committed the block: height %d", height)
+	}
+
+	valid := decidedMessage(t, block, hash, map[string]string{
+		"node2": "node2", "node3": "node3", "node4": "node4",
+	})
+	if err := member.HandleConsensusMessage(valid); err != nil {
+		t.Fatalf("decided block refused: %v", err)
+	}
+	if height := member.blockchain.GetHeight(); height != 1 {
+		t.Fatalf("height = %d after catching up, want 1", height)
+	}
+	if member.decided[1] == nil {
+		t.Fatal("caught up member cannot pass the commits on")
+	}
+}
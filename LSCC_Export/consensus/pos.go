@@ -1,8 +1,11 @@
 package consensus
 
 import (
+        "crypto/sha256"
+        "encoding/binary"
         "errors"
-        "math/rand"
+        "fmt"
+        "sort"
         "sync"
         "time"
 
@@ -11,11 +14,47 @@ import (
         "lscc/utils"
 )
 
+// proposerTimeout is how many block times a scheduled proposer has to
+// extend the chain before the next proposer of the schedule takes over
+const proposerTimeout = 3
+
+func init() {
+        Register(ProofOfStake, EngineSpec{
+                Description: "Proof of Stake with stake-weighted proposers from the on-chain validator set",
+                DefaultParams: func(cfg *config.Config) EngineParams {
+                        return &PoSParams{MinStake: cfg.MinStake}
+                },
+                New: func(cfg *config.Config, blockchain *core.Blockchain, params EngineParams) (ConsensusEngine, error) {
+                        pos, err := NewPoSConsensus(cfg, blockchain)
+                        if err != nil {
+                                return nil, err
+                        }
+                        pos.params.MinStake = params.(*PoSParams).MinStake
+                        return pos, nil
+                },
+        })
+}
+
+// PoSParams are the parameters of the PoS engine
+type PoSParams struct {
+        // Stake a validator registered in-process needs, used until
+        // validators have staked on-chain
+        MinStake core.Amount `json:"min_stake"`
+}
+
+// Validate checks the PoS parameters
+func (p *PoSParams) Validate() error {
+        if p.MinStake < 0 {
+                return errors.New("min_stake cannot be negative")
+        }
+        return nil
+}
+
 // PoSConsensus implements a simple Proof-of-Stake consensus
 type PoSConsensus struct {
         blockchain      *core.Blockchain
         config          *config.Config
-        validators      map[string]float64 // maps validator ID to stake
+        validators      map[string]core.Amount // maps validator ID to stake
         running         bool
         stopChan        chan struct{}
         mu              sync.RWMutex
@@ -23,27 +62,34 @@ type PoSConsensus struct {
         logger          *utils.Logger
         params          ConsensusParams
         lastBlockTime   time.Time
+        rewards         *rewarder
 }
 
 // NewPoSConsensus creates a new PoS consensus engine
 func NewPoSConsensus(config *config.Config, blockchain *core.Blockchain) (*PoSConsensus, error) {
-        return &PoSConsensus{
+        pos := &PoSConsensus{
                 blockchain:    blockchain,
                 config:        config,
-                validators:    make(map[string]float64),
+                validators:    make(map[string]core.Amount),
                 running:       false,
                 stopChan:      make(chan struct{}),
                 pendingBlocks: make(map[string]*core.Block),
-                logger:        utils.GetLogger(),
+                logger:        utils.GetLogger().Named("consensus"),
                 params: ConsensusParams{
                         BlockTime:        config.BlockTime,
                         MinConfirmations: config.MinConfirmations,
-                        MinStake:         1000.0, // Minimum stake to be a validator
-                        StakingReward:    5.0,    // Reward per block for validators
+                        MinStake:         config.MinStake, // Minimum own stake to be a validator
+                        StakingReward:    config.BlockReward, // Reward per block for the proposer
+                        SlashFraction:    config.SlashFraction,
                 },
                 lastBlockTime: time.Now(),
-        },
-        nil
+                rewards:       newRewarder(ProofOfStake, config),
+        }
+        
+        // Slash validators proven to misbehave by evidence in the chain
+        blockchain.OnSlash(pos.handleSlashing)
+        
+        return pos, nil
 }
 
 // Start starts the consensus engine
@@ -55,6 +101,11 @@ func (pos *PoSConsensus) Start() error {
                 return errors.New("consensus already running")
         }
 
+        // Without validators no block could ever be made or accepted
+        if set := pos.blockchain.State.GetValidatorSet(); len(set.Validators) == 0 && len(pos.validators) == 0 {
+                return fmt.Errorf("no PoS validators for shard %d: list them in the genesis validators", pos.config.ShardID)
+        }
+
         pos.running = true
         go pos.consensusLoop()
 
@@ -91,6 +142,7 @@ func (pos *PoSConsensus) consensusLoop() {
                         // Check if it's our turn to create a block
                         if pos.isValidatorTurn(pos.config.NodeID) {
                                 pos.logger.Info("It's our turn to create a block")
+                                started := time.Now()
                                 block, err := pos.CreateBlock()
                                 if err != nil {
                                         pos.logger.Error("Failed to create block", "error", err)
@@ -103,6 +155,7 @@ func (pos *PoSConsensus) consensusLoop() {
                                         pos.logger.Error("Failed to process block", "error", err)
                                         continue
                                 }
+                                observeRound(pos.blockchain, ProofOfStake, started)
 
                                 // Reset the timer for the next block
                                 pos.lastBlockTime = time.Now()
@@ -112,47 +165,106 @@ func (pos *PoSConsensus) consensusLoop() {
 }
 
 // isValidatorTurn checks if it's the validator's turn to create a block
+// on top of the chain's latest block
 func (pos *PoSConsensus) isValidatorTurn(validatorID string) bool {
-        // In a real PoS, this would be determined by stake weight
-        // For simplicity, we'll use a simple random selection algorithm
-        
-        // Get current validators
+        latestBlock := pos.blockchain.GetLatestBlock()
+        if latestBlock == nil {
+                return false
+        }
+        // A block must be stamped after its parent
+        now := pos.blockchain.Now().Unix()
+        if now <= latestBlock.Header.Timestamp {
+                return false
+        }
+        proposer, err := pos.scheduledProposer(latestBlock, now)
+        if err != nil {
+                return false
+        }
+        return proposer == validatorID
+}
+
+// scheduledProposer returns the validator scheduled to propose the block on
+// top of prev stamped at a time. Every node draws the same one: the draw is
+// weighted by stake over the current epoch's validator set and seeded by
+// the hash and height of the parent, and by the proposer round, which moves
+// on each time proposerTimeout block times pass without a block.
+func (pos *PoSConsensus) scheduledProposer(prev *core.Block, timestamp int64) (string, error) {
         validators := pos.getValidators()
         if len(validators) == 0 {
-                return false
+                return "", errors.New("no validators")
         }
+        stakes := pos.validatorStakes()
         
-        // For demo purposes, randomly select a validator based on current time
-        rand.Seed(time.Now().UnixNano())
-        selectedIndex := rand.Intn(len(validators))
-        selectedValidator := validators[selectedIndex]
+        prevHash, err := prev.Hash()
+        if err != nil {
+                return "", err
+        }
+        round := pos.proposerRound(prev, timestamp)
+        digest := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%d", prevHash, prev.Header.Height+1, round)))
+        seed := binary.BigEndian.Uint64(digest[:8])
         
-        return selectedValidator == validatorID
+        var total core.Amount
+        for _, validator := range validators {
+                total += stakes[validator]
+        }
+        if total <= 0 {
+                return validators[seed%uint64(len(validators))], nil
+        }
+        
+        pick := core.Amount(seed % uint64(total))
+        for _, validator := range validators {
+                pick -= stakes[validator]
+                if pick < 0 {
+                        return validator, nil
+                }
+        }
+        return validators[len(validators)-1], nil
 }
 
-// getValidators returns a list of active validators
+// proposerRound returns the proposer round a time falls in on top of prev
+func (pos *PoSConsensus) proposerRound(prev *core.Block, timestamp int64) int64 {
+        blockTime := int64(pos.params.BlockTime)
+        if blockTime < 1 {
+                blockTime = 1
+        }
+        elapsed := timestamp - prev.Header.Timestamp
+        if elapsed <= 0 {
+                return 0
+        }
+        return elapsed / (proposerTimeout * blockTime)
+}
+
+// getValidators returns a sorted list of active validators
 func (pos *PoSConsensus) getValidators() []string {
-        pos.mu.RLock()
-        defer pos.mu.RUnlock()
-        
-        validators := make([]string, 0, len(pos.validators))
-        for validator := range pos.validators {
+        stakes := pos.validatorStakes()
+        validators := make([]string, 0, len(stakes))
+        for validator := range stakes {
                 validators = append(validators, validator)
         }
-        
-        // If no validators registered yet, use this node as default
-        if len(validators) == 0 {
-                validators = append(validators, pos.config.NodeID)
+        sort.Strings(validators)
+        return validators
+}
+
+// validatorStakes returns the stake of each active validator. The current
+// epoch's validator set from the chain state takes precedence; until anyone
+// has staked on-chain, validators registered in-process are used instead.
+func (pos *PoSConsensus) validatorStakes() map[string]core.Amount {
+        if set := pos.blockchain.State.GetValidatorSet(); len(set.Validators) > 0 {
+                return set.Validators
         }
         
-        return validators
+        pos.mu.RLock()
+        defer pos.mu.RUnlock()
+        
+        stakes := make(map[string]core.Amount, len(pos.validators))
+        for validator, stake := range pos.validators {
+                stakes[validator] = stake
+        }
+        return stakes
 }
 
 // CreateBlock creates a new block with pending transactions
 func (pos *PoSConsensus) CreateBlock() (*core.Block, error) {
-        // Get pending transactions
-        pendingTxs := pos.blockchain.GetPendingTransactions()
-        
         // Get the latest block
         latestBlock := pos.blockchain.GetLatestBlock()
         if latestBlock == nil {
@@ -173,27 +285,16 @@ func (pos *PoSConsensus) CreateBlock() (*core.Block, error) {
                 pos.config.NodeID,
         )
         
-        // Add transactions to the block (up to max limit)
-        txCount := 0
-        for _, tx := range pendingTxs {
-                if txCount >= pos.config.MaxTransPerBlock {
-                        break
-                }
-                
-                // Skip cross-shard transactions for now, they need special handling
-                if tx.IsCrossShard() {
-                        continue
-                }
-                
-                newBlock.AddTransaction(*tx)
-                txCount++
-        }
+        // Add pending transactions and cross-shard references
+        fillBlock(pos.blockchain, pos.config, newBlock, pos.logger)
         
-        // Add cross-shard references if any
-        // In a real implementation, this would pull from other shards
+        // Pay the block reward and fees to this proposer
+        if err := pos.rewards.addCoinbase(newBlock, nil); err != nil {
+                return nil, err
+        }
         
         // Sign the block
-        err = newBlock.Sign(pos.config.NodeID)
+        err = signBlock(pos.config, newBlock)
         if err != nil {
                 return nil, err
         }
@@ -222,6 +323,37 @@ func (pos *PoSConsensus) ValidateBlock(block *core.Block) bool {
                 return false
         }
         
+        // The timestamp picks the proposer round, so it must follow the
+        // parent and fall in a round this node's clock has reached;
+        // otherwise a validator could stamp its block with whichever round
+        // schedules it
+        if block.Header.Timestamp <= latestBlock.Header.Timestamp {
+                pos.logger.Warn("Block not stamped after its parent",
+                        "timestamp", block.Header.Timestamp,
+                        "parent", latestBlock.Header.Timestamp)
+                return false
+        }
+        now := pos.blockchain.Now().Unix()
+        if pos.proposerRound(latestBlock, block.Header.Timestamp) > pos.proposerRound(latestBlock, now) {
+                pos.logger.Warn("Block from a proposer round not reached yet",
+                        "validator", block.Header.ValidatorID,
+                        "timestamp", block.Header.Timestamp)
+                return false
+        }
+        
+        // Only the scheduled proposer may extend the chain
+        proposer, err := pos.scheduledProposer(latestBlock, block.Header.Timestamp)
+        if err != nil || proposer != block.Header.ValidatorID {
+                pos.logger.Warn("Block from unscheduled proposer",
+                        "validator", block.Header.ValidatorID,
+                        "scheduled", proposer)
+                return false
+        }
+        if err := pos.blockchain.CheckTimestamp(block); err != nil {
+                pos.logger.Warn("Invalid block timestamp", "error", err)
+                return false
+        }
+        
         // Validate all transactions in the block
         for _, tx := range block.Transactions {
                 if !tx.IsValid() {
@@ -230,16 +362,25 @@ func (pos *PoSConsensus) ValidateBlock(block *core.Block) bool {
                 }
         }
         
+        // The block reward and fees must go to the proposer
+        if err := pos.rewards.verifyCoinbase(block, nil); err != nil {
+                pos.logger.Warn("Invalid coinbase", "error", err)
+                return false
+        }
+        
         return true
 }
 
-// isValidator checks if a node is a validator
+// isValidator checks if a node may produce blocks: a member of the current
+// epoch's validator set, or until anyone has staked on-chain, a validator
+// registered in-process. It takes the same set proposers are drawn from.
 func (pos *PoSConsensus) isValidator(nodeID string) bool {
-        pos.mu.RLock()
-        defer pos.mu.RUnlock()
-        
-        // For demo purposes, all nodes are validators
-        return true
+        for _, validator := range pos.getValidators() {
+                if validator == nodeID {
+                        return true
+                }
+        }
+        return false
 }
 
 // ProcessBlock processes a new block and adds it to the blockchain
@@ -255,15 +396,10 @@ func (pos *PoSConsensus) ProcessBlock(block *core.Block) error {
                 return err
         }
         
-        // Update last block time
+        // Update last block time; transactions are marked confirmed by the
+        // chain's finality tracker once their block is final
         pos.lastBlockTime = time.Now()
         
-        // Mark transactions as confirmed
-        for _, tx := range block.Transactions {
-                txCopy := tx
-                txCopy.Confirm()
-        }
-        
         pos.logger.Info("Block processed successfully", 
                 "height", block.Header.Height, 
                 "validator", block.Header.ValidatorID,
@@ -286,6 +422,7 @@ func (pos *PoSConsensus) GetStatus() map[string]interface{} {
         for validator := range pos.validators {
                 validators = append(validators, validator)
         }
+        validatorSet := pos.blockchain.State.GetValidatorSet()
         
         return map[string]interface{}{
                 "type":              string(ProofOfStake),
@@ -296,18 +433,38 @@ func (pos *PoSConsensus) GetStatus() map[string]interface{} {
                 "pending_blocks":    len(pos.pendingBlocks),
                 "block_time":        pos.params.BlockTime,
                 "min_confirmations": pos.params.MinConfirmations,
+                "finalized_height":  pos.blockchain.Finality.FinalizedHeight(),
+                "epoch":             validatorSet.Epoch,
+                "validator_set":     validatorSet.Validators,
+                "min_stake":         pos.params.MinStake,
+                "block_reward":      pos.params.StakingReward,
         }
 }
 
-// HandleConsensusMessage handles consensus-specific messages
+// HandleConsensusMessage handles consensus-specific messages. PoS agrees
+// on blocks through block propagation alone and has none.
 func (pos *PoSConsensus) HandleConsensusMessage(message []byte) error {
-        // In a real implementation, this would parse and handle different consensus messages
-        // For now, we'll just return nil
         return nil
 }
 
-// RegisterValidator registers a new validator with the specified stake
-func (pos *PoSConsensus) RegisterValidator(nodeID string, stake float64) error {
+// SetMessageSender is a no-op: PoS sends no consensus messages
+func (pos *PoSConsensus) SetMessageSender(sender MessageSender) {}
+
+// SetRelaySelector sets how the relay rewarded for cross-shard traffic is
+// chosen
+func (pos *PoSConsensus) SetRelaySelector(selector RelaySelector) {
+        pos.rewards.SetRelaySelector(selector)
+}
+
+// SetRelayChecker sets how relay payouts in blocks are checked
+func (pos *PoSConsensus) SetRelayChecker(checker RelayChecker) {
+        pos.rewards.SetRelayChecker(checker)
+}
+
+// RegisterValidator registers a validator in-process with the specified
+// stake. Registered validators produce blocks only until validators have
+// staked on-chain; see core.StakeTransaction.
+func (pos *PoSConsensus) RegisterValidator(nodeID string, stake core.Amount) error {
         pos.mu.Lock()
         defer pos.mu.Unlock()
         
@@ -315,6 +472,10 @@ func (pos *PoSConsensus) RegisterValidator(nodeID string, stake float64) error {
                 return errors.New("stake amount below minimum required")
         }
         
+        if pos.blockchain.State.IsSlashed(nodeID) {
+                return errors.New("validator has been slashed")
+        }
+        
         pos.validators[nodeID] = stake
         pos.logger.Info("New validator registered", "nodeID", nodeID, "stake", stake)
         
@@ -329,3 +490,29 @@ func (pos *PoSConsensus) UnregisterValidator(nodeID string) {
         delete(pos.validators, nodeID)
         pos.logger.Info("Validator unregistered", "nodeID", nodeID)
 }
+
+// handleSlashing removes a slashed validator from the in-process validator
+// set. Its on-chain stake has already been cut by the chain state.
+func (pos *PoSConsensus) handleSlashing(slashing *core.Slashing) {
+        pos.mu.Lock()
+        defer pos.mu.Unlock()
+        
+        delete(pos.validators, slashing.Offender)
+        
+        var burned core.Amount
+        if record, exists := pos.blockchain.State.GetSlashing(slashing.Offender); exists {
+                burned = record.Burned
+        }
+        var remaining core.Amount
+        for _, validator := range pos.blockchain.State.GetValidators() {
+                if validator.Address == slashing.Offender {
+                        remaining = validator.TotalStake
+                }
+        }
+        
+        pos.logger.Warn("Validator slashed", 
+                "nodeID", slashing.Offender,
+                "evidence", slashing.Type,
+                "burned", burned,
+                "remaining", remaining)
+}
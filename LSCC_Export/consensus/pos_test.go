@@ -0,0 +1,107 @@
+package consensus
+
+import (
+	"testing"
+	"time"
+
+	"lscc/config"
+	"lscc/core"
+)
+
+// testGenesisTime is the genesis timestamp of the test PoS chains
+const testGenesisTime = 1000000
+
+// fixedClock is a clock stopped at a Unix time
+type fixedClock int64
+
+func (c fixedClock) Now() time.Time {
+	return time.Unix(int64(c), 0)
+}
+
+// newTestPoS returns a stopped PoS engine of a node on a fresh chain with
+// node1 and node2 as equal validators, its clock stopped at a time
+func newTestPoS(t *testing.T, nodeID string, now int64) *PoSConsensus {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.NodeID = nodeID
+	cfg.ShardID = 0
+	cfg.GenesisTime = testGenesisTime
+	blockchain := core.NewBlockchain(cfg)
+	blockchain.SetClock(fixedClock(now))
+	pos, err := NewPoSConsensus(cfg, blockchain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, validator := range []string{"node1", "node2"} {
+		if err := pos.RegisterValidator(validator, cfg.MinStake); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pos
+}
+
+// proposeAt returns the block a validator proposes at height 1 at a time
+func proposeAt(t *testing.T, proposer string, timestamp int64) *core.Block {
+	t.Helper()
+	block, err := newTestPoS(t, proposer, timestamp).CreateBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestPoSProposerRoundsFollowTheClock(t *testing.T) {
+	pos := newTestPoS(t, "node1", testGenesisTime)
+	genesis := pos.blockchain.GetLatestBlock()
+	roundLength := int64(proposerTimeout * pos.params.BlockTime)
+
+	// Find a later round scheduling the other validator, so two
+	// proposers can claim height 1
+	first, err := pos.scheduledProposer(genesis, testGenesisTime+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var second string
+	var round int64
+	for round = 1; round < 64; round++ {
+		second, err = pos.scheduledProposer(genesis, testGenesisTime+round*roundLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if second != first {
+			break
+		}
+	}
+	if second == first {
+		t.Fatal("no round schedules the other validator")
+	}
+	secondStart := testGenesisTime + round*roundLength
+
+	// The second proposer cannot claim the height while the clock is in
+	// the round before its own, even stamping the block within the
+	// allowed clock drift
+	early := proposeAt(t, second, secondStart)
+	pos.blockchain.SetClock(fixedClock(secondStart - 1))
+	if pos.ValidateBlock(early) {
+		t.Error("block from a proposer round the clock has not reached accepted")
+	}
+
+	// Nor can the first proposer stamp its block with its parent's time
+	stale := proposeAt(t, first, testGenesisTime)
+	if pos.ValidateBlock(stale) {
+		t.Error("block stamped with its parent's time accepted")
+	}
+
+	// Each proposer's block is valid in its own round once reached
+	pos.blockchain.SetClock(fixedClock(testGenesisTime + 1))
+	if block := proposeAt(t, first, testGenesisTime+1); !pos.ValidateBlock(block) {
+		t.Error("block of the first proposer refused in its round")
+	}
+	pos.blockchain.SetClock(fixedClock(secondStart))
+	if !pos.ValidateBlock(early) {
+		t.Error("block of the second proposer refused once its round is reached")
+	}
+	if block := proposeAt(t, first, secondStart); pos.ValidateBlock(block) {
+		t.Error("block of the first proposer accepted in another's round")
+	}
+}
@@ -0,0 +1,300 @@
+package consensus
+
+import (
+        "errors"
+        "strings"
+        "sync"
+        "time"
+
+        "lscc/config"
+        "lscc/core"
+        "lscc/utils"
+)
+
+func init() {
+        Register(ProofOfWork, EngineSpec{
+                Description: "Proof of Work with a leading-zero target on the block header hash",
+                DefaultParams: func(cfg *config.Config) EngineParams {
+                        return &PoWParams{Difficulty: 4, MaxNonce: 1 << 22}
+                },
+                New: func(cfg *config.Config, blockchain *core.Blockchain, params EngineParams) (ConsensusEngine, error) {
+                        return NewPoWConsensus(cfg, blockchain, *params.(*PoWParams))
+                },
+        })
+}
+
+// PoWParams are the parameters of the PoW engine
+type PoWParams struct {
+        Difficulty uint32 `json:"difficulty"` // Leading zero hex digits of a valid block hash
+        MaxNonce   uint64 `json:"max_nonce"`  // Nonces tried per mining round
+}
+
+// Validate checks the PoW parameters
+func (p *PoWParams) Validate() error {
+        if p.Difficulty < 1 || p.Difficulty > 16 {
+                return errors.New("difficulty must be between 1 and 16")
+        }
+        if p.MaxNonce == 0 {
+                return errors.New("max_nonce must be positive")
+        }
+        return nil
+}
+
+// errNoNonce is returned when a mining round finds no valid nonce
+var errNoNonce = errors.New("no nonce meets the difficulty target")
+
+// PoWConsensus implements Proof-of-Work: every node mines, and the first
+// block whose header hash meets the difficulty target extends the chain
+type PoWConsensus struct {
+        blockchain    *core.Blockchain
+        config        *config.Config
+        params        PoWParams
+        running       bool
+        stopChan      chan struct{}
+        mu            sync.RWMutex
+        logger        *utils.Logger
+        lastBlockTime time.Time
+        blocksMined   int
+        rewards       *rewarder
+}
+
+// NewPoWConsensus creates a new PoW consensus engine
+func NewPoWConsensus(config *config.Config, blockchain *core.Blockchain, params PoWParams) (*PoWConsensus, error) {
+        return &PoWConsensus{
+                blockchain:    blockchain,
+                config:        config,
+                params:        params,
+                stopChan:      make(chan struct{}),
+                logger:        utils.GetLogger().Named("consensus"),
+                lastBlockTime: time.Now(),
+                rewards:       newRewarder(ProofOfWork, config),
+        }, nil
+}
+
+// Start starts mining
+func (pow *PoWConsensus) Start() error {
+        pow.mu.Lock()
+        defer pow.mu.Unlock()
+
+        if pow.running {
+                return errors.New("consensus already running")
+        }
+
+        pow.running = true
+        go pow.miningLoop()
+
+        pow.logger.Info("PoW consensus started", "difficulty", pow.params.Difficulty)
+        return nil
+}
+
+// Stop stops mining
+func (pow *PoWConsensus) Stop() error {
+        pow.mu.Lock()
+        defer pow.mu.Unlock()
+
+        if !pow.running {
+                return errors.New("consensus not running")
+        }
+
+        close(pow.stopChan)
+        pow.running = false
+
+        pow.logger.Info("PoW consensus stopped")
+        return nil
+}
+
+// miningLoop mines a block every block time
+func (pow *PoWConsensus) miningLoop() {
+        ticker := time.NewTicker(time.Duration(pow.config.BlockTime) * time.Second)
+        defer ticker.Stop()
+
+        for {
+                select {
+                case <-pow.stopChan:
+                        return
+                case <-ticker.C:
+                        started := time.Now()
+                        block, err := pow.CreateBlock()
+                        if errors.Is(err, errNoNonce) {
+                                pow.logger.Debug("Mining round found no block, retrying")
+                                continue
+                        }
+                        if err != nil {
+                                pow.logger.Error("Failed to mine block", "error", err)
+                                continue
+                        }
+
+                        if err := pow.ProcessBlock(block); err != nil {
+                                // A peer's block may have taken the height first
+                                if latest := pow.blockchain.GetLatestBlock(); latest.Header.Height >= block.Header.Height {
+                                        pow.logger.Debug("Mined block went stale", "height", block.Header.Height)
+                                        continue
+                                }
+                                pow.logger.Error("Failed to process mined block", "error", err)
+                                continue
+                        }
+                        observeRound(pow.blockchain, ProofOfWork, started)
+
+                        pow.mu.Lock()
+                        pow.blocksMined++
+                        pow.mu.Unlock()
+                }
+        }
+}
+
+// CreateBlock assembles a block with pending transactions and mines it
+func (pow *PoWConsensus) CreateBlock() (*core.Block, error) {
+        latestBlock := pow.blockchain.GetLatestBlock()
+        if latestBlock == nil {
+                return nil, errors.New("no blocks in blockchain")
+        }
+        lastHash, err := latestBlock.Hash()
+        if err != nil {
+                return nil, err
+        }
+
+        newBlock := core.NewBlock(
+                lastHash,
+                latestBlock.Header.Height+1,
+                pow.config.ShardID,
+                latestBlock.Header.Layer,
+                pow.config.NodeID,
+        )
+        fillBlock(pow.blockchain, pow.config, newBlock, pow.logger)
+
+        // Pay the block reward and fees to this miner
+        if err := pow.rewards.addCoinbase(newBlock, nil); err != nil {
+                return nil, err
+        }
+
+        newBlock.Header.Difficulty = pow.params.Difficulty
+        for nonce := uint64(0); nonce < pow.params.MaxNonce; nonce++ {
+                newBlock.Header.Nonce = nonce
+                hash, err := newBlock.Hash()
+                if err != nil {
+                        return nil, err
+                }
+                if meetsTarget(hash, pow.params.Difficulty) {
+                        if err := signBlock(pow.config, newBlock); err != nil {
+                                return nil, err
+                        }
+                        return newBlock, nil
+                }
+        }
+        return nil, errNoNonce
+}
+
+// meetsTarget checks that a block hash starts with difficulty zero digits
+func meetsTarget(hash string, difficulty uint32) bool {
+        return strings.HasPrefix(hash, strings.Repeat("0", int(difficulty)))
+}
+
+// ValidateBlock validates a block according to PoW rules
+func (pow *PoWConsensus) ValidateBlock(block *core.Block) bool {
+        return pow.ValidateBranchBlock(block, pow.blockchain.GetLatestBlock())
+}
+
+// ValidateBranchBlock validates a block of a competing branch on top of
+// its parent. The longest valid branch wins under proof of work.
+func (pow *PoWConsensus) ValidateBranchBlock(block *core.Block, prev *core.Block) bool {
+        if block.Header.Difficulty < pow.params.Difficulty {
+                pow.logger.Warn("Block difficulty below target", "difficulty", block.Header.Difficulty)
+                return false
+        }
+        hash, err := block.Hash()
+        if err != nil || !meetsTarget(hash, block.Header.Difficulty) {
+                pow.logger.Warn("Block hash does not meet its difficulty", "hash", hash)
+                return false
+        }
+
+        if !block.VerifySignature(block.Header.ValidatorID) {
+                pow.logger.Warn("Invalid block signature")
+                return false
+        }
+        if !block.IsValid(prev) {
+                pow.logger.Warn("Invalid block structure")
+                return false
+        }
+        if err := pow.blockchain.CheckTimestamp(block); err != nil {
+                pow.logger.Warn("Invalid block timestamp", "error", err)
+                return false
+        }
+        for _, tx := range block.Transactions {
+                if !tx.IsValid() {
+                        pow.logger.Warn("Invalid transaction in block", "txHash", tx.Hash)
+                        return false
+                }
+        }
+
+        // The block reward and fees must go to the miner
+        if err := pow.rewards.verifyCoinbase(block, nil); err != nil {
+                pow.logger.Warn("Invalid coinbase", "error", err)
+                return false
+        }
+        return true
+}
+
+// ProcessBlock validates a mined block and adds it to the blockchain
+func (pow *PoWConsensus) ProcessBlock(block *core.Block) error {
+        if !pow.ValidateBlock(block) {
+                return errors.New("invalid block")
+        }
+        if err := pow.blockchain.AddBlock(block); err != nil {
+                return err
+        }
+
+        pow.mu.Lock()
+        pow.lastBlockTime = time.Now()
+        pow.mu.Unlock()
+
+        pow.logger.Info("Block processed successfully",
+                "height", block.Header.Height,
+                "miner", block.Header.ValidatorID,
+                "nonce", block.Header.Nonce,
+                "transactions", len(block.Transactions))
+        return nil
+}
+
+// GetType returns the type of consensus algorithm
+func (pow *PoWConsensus) GetType() ConsensusType {
+        return ProofOfWork
+}
+
+// GetStatus returns the current status of the consensus engine
+func (pow *PoWConsensus) GetStatus() map[string]interface{} {
+        pow.mu.RLock()
+        defer pow.mu.RUnlock()
+
+        return map[string]interface{}{
+                "type":              string(ProofOfWork),
+                "running":           pow.running,
+                "difficulty":        pow.params.Difficulty,
+                "max_nonce":         pow.params.MaxNonce,
+                "blocks_mined":      pow.blocksMined,
+                "last_block_time":   pow.lastBlockTime,
+                "block_time":        pow.config.BlockTime,
+                "min_confirmations": pow.config.MinConfirmations,
+                "finalized_height":  pow.blockchain.Finality.FinalizedHeight(),
+                "block_reward":      pow.config.BlockReward,
+        }
+}
+
+// HandleConsensusMessage handles consensus-specific messages. PoW agrees on
+// blocks through block propagation alone and has none.
+func (pow *PoWConsensus) HandleConsensusMessage(message []byte) error {
+        return nil
+}
+
+// SetRelaySelector sets how the relay rewarded for cross-shard traffic is
+// chosen
+func (pow *PoWConsensus) SetRelaySelector(selector RelaySelector) {
+        pow.rewards.SetRelaySelector(selector)
+}
+
+// SetRelayChecker sets how relay payouts in blocks are checked
+func (pow *PoWConsensus) SetRelayChecker(checker RelayChecker) {
+        pow.rewards.SetRelayChecker(checker)
+}
+
+// SetMessageSender is a no-op: PoW sends no consensus messages
+func (pow *PoWConsensus) SetMessageSender(sender MessageSender) {}
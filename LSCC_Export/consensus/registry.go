@@ -0,0 +1,208 @@
+package consensus
+
+import (
+        "bytes"
+        "encoding/json"
+        "fmt"
+        "sort"
+        "sync"
+
+        "lscc/config"
+        "lscc/core"
+        "lscc/utils"
+)
+
+// Engine registry
+//
+// Consensus algorithms plug in by registering an EngineSpec under their
+// name, usually from an init function in the file implementing them. The
+// node's consensus_type selects the engine; its typed parameters are read
+// from consensus_params[consensus_type] on top of the engine's defaults and
+// validated before the engine is created.
+//
+// A config may also pin the engine of individual shards (see
+// config.ShardSpec). A node only starts, and only keeps peers of its own
+// shard, when its engine and parameters match the shard's spec.
+
+// EngineParams are the typed parameters of a consensus engine
+type EngineParams interface {
+        // Validate checks the parameters are usable
+        Validate() error
+}
+
+// EngineSpec describes how to create a registered consensus engine
+type EngineSpec struct {
+        // Description is a one-line summary of the algorithm
+        Description string
+
+        // DefaultParams returns the engine's parameters derived from the node
+        // configuration, before consensus_params are applied
+        DefaultParams func(cfg *config.Config) EngineParams
+
+        // New creates the engine with validated parameters
+        New func(cfg *config.Config, blockchain *core.Blockchain, params EngineParams) (ConsensusEngine, error)
+}
+
+var (
+        registry   = make(map[ConsensusType]EngineSpec)
+        registryMu sync.RWMutex
+)
+
+// Register makes a consensus engine available under a name. It panics if
+// the name is taken, as registration happens at program start.
+func Register(name ConsensusType, spec EngineSpec) {
+        registryMu.Lock()
+        defer registryMu.Unlock()
+
+        if _, exists := registry[name]; exists {
+                panic(fmt.Sprintf("consensus engine %q registered twice", name))
+        }
+        registry[name] = spec
+}
+
+// Registered returns the names of all registered engines, sorted
+func Registered() []ConsensusType {
+        registryMu.RLock()
+        defer registryMu.RUnlock()
+
+        names := make([]ConsensusType, 0, len(registry))
+        for name := range registry {
+                names = append(names, name)
+        }
+        sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+        return names
+}
+
+// Describe returns the description of a registered engine
+func Describe(name ConsensusType) (string, bool) {
+        registryMu.RLock()
+        defer registryMu.RUnlock()
+
+        spec, exists := registry[name]
+        return spec.Description, exists
+}
+
+// lookup returns the spec of a registered engine
+func lookup(name ConsensusType) (EngineSpec, error) {
+        registryMu.RLock()
+        spec, exists := registry[name]
+        registryMu.RUnlock()
+
+        if !exists {
+                return EngineSpec{}, utils.NewError("unknown consensus type",
+                        "type", name, "registered", Registered())
+        }
+        return spec, nil
+}
+
+// LoadParams returns the validated parameters of the configured engine
+func LoadParams(cfg *config.Config) (EngineParams, error) {
+        name := ConsensusType(cfg.ConsensusType)
+        spec, err := lookup(name)
+        if err != nil {
+                return nil, err
+        }
+
+        params := spec.DefaultParams(cfg)
+        if raw, exists := cfg.ConsensusParams[string(name)]; exists {
+                decoder := json.NewDecoder(bytes.NewReader(raw))
+                decoder.DisallowUnknownFields()
+                if err := decoder.Decode(params); err != nil {
+                        return nil, fmt.Errorf("invalid %s consensus parameters: %w", name, err)
+                }
+        }
+        if err := params.Validate(); err != nil {
+                return nil, fmt.Errorf("invalid %s consensus parameters: %w", name, err)
+        }
+        return params, nil
+}
+
+// ValidateConfig checks that the configured consensus engine and every
+// shard's engine are registered with valid parameters, and that the node
+// runs the engine its shard requires
+func ValidateConfig(cfg *config.Config) error {
+        if _, err := LoadParams(cfg); err != nil {
+                return err
+        }
+
+        shardTotal := cfg.ShardCount * cfg.LayerCount
+        seen := make(map[int]bool, len(cfg.Shards))
+        for _, spec := range cfg.Shards {
+                if spec.ShardID < 0 || spec.ShardID >= shardTotal {
+                        return fmt.Errorf("shard spec for unknown shard %d (shards 0..%d)", spec.ShardID, shardTotal-1)
+                }
+                if seen[spec.ShardID] {
+                        return fmt.Errorf("shard %d has more than one consensus spec", spec.ShardID)
+                }
+                seen[spec.ShardID] = true
+
+                if spec.MinConfirmations < 0 {
+                        return fmt.Errorf("shard %d: min_confirmations must not be negative", spec.ShardID)
+                }
+                if _, err := LoadParams(cfg.ForShard(spec.ShardID)); err != nil {
+                        return fmt.Errorf("shard %d: %w", spec.ShardID, err)
+                }
+        }
+
+        if cfg.ShardID >= 0 {
+                return CheckShard(cfg, cfg.ShardID)
+        }
+        return nil
+}
+
+// CheckShard refuses a node whose consensus engine or parameters differ
+// from the ones the shard runs
+func CheckShard(cfg *config.Config, shardID int) error {
+        spec, exists := cfg.ShardSpec(shardID)
+        if !exists {
+                return nil
+        }
+        if cfg.ConsensusType != spec.ConsensusType {
+                return utils.NewError("node consensus does not match its shard",
+                        "shard", shardID, "node", cfg.ConsensusType, "shard_consensus", spec.ConsensusType)
+        }
+
+        nodeFingerprint, err := Fingerprint(cfg)
+        if err != nil {
+                return err
+        }
+        shardFingerprint, err := Fingerprint(cfg.ForShard(shardID))
+        if err != nil {
+                return err
+        }
+        if nodeFingerprint != shardFingerprint {
+                return utils.NewError("node consensus parameters do not match its shard",
+                        "shard", shardID, "consensus", spec.ConsensusType)
+        }
+        return nil
+}
+
+// Fingerprint returns a digest of the configured engine and its effective
+// parameters. Nodes of a shard exchange it to agree on consensus.
+func Fingerprint(cfg *config.Config) (string, error) {
+        params, err := LoadParams(cfg)
+        if err != nil {
+                return "", err
+        }
+        encoded, err := json.Marshal(params)
+        if err != nil {
+                return "", err
+        }
+        return utils.Hash(append([]byte(cfg.ConsensusType+":"), encoded...)), nil
+}
+
+// NewConsensusEngine creates the consensus engine selected by the config
+func NewConsensusEngine(cfg *config.Config, blockchain *core.Blockchain) (ConsensusEngine, error) {
+        params, err := LoadParams(cfg)
+        if err != nil {
+                return nil, err
+        }
+
+        spec, err := lookup(ConsensusType(cfg.ConsensusType))
+        if err != nil {
+                return nil, err
+        }
+        utils.GetLogger().Named("consensus").Info("Initializing consensus engine",
+                "type", cfg.ConsensusType, "description", spec.Description)
+        return spec.New(cfg, blockchain, params)
+}
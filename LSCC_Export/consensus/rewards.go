@@ -0,0 +1,208 @@
+package consensus
+
+import (
+        "errors"
+        "fmt"
+        "sync"
+
+        "lscc/config"
+        "lscc/core"
+)
+
+// Block rewards
+//
+// Every block producer ends its block with a coinbase transaction paying out
+// the block reward and the fees collected in the block. Who is paid depends
+// on the consensus type:
+//   - PoW: the miner takes the block reward and the fees.
+//   - PoS: the proposer takes the block reward and the fees.
+//   - PBFT: the block reward is split evenly across the committee that
+//     committed the block; the proposer takes the fees.
+// In every case RelayRewardShare of the fees paid by transfers leaving the
+// shard goes to the relay node that carries them; without a relay it stays
+// with the producer.
+
+// RelaySelector returns the relay node carrying cross-shard traffic out of
+// the engine's shard
+type RelaySelector func() (string, error)
+
+// RelayChecker reports whether a node is in the active relay set
+type RelayChecker func(nodeID string) bool
+
+// rewarder builds and checks coinbase transactions for a consensus type
+type rewarder struct {
+        consensusType ConsensusType
+        shardID       int
+        blockReward   core.Amount
+        relayShare    float64
+        selectRelay   RelaySelector
+        isRelay       RelayChecker
+        mu            sync.RWMutex
+}
+
+// newRewarder creates a rewarder for the given consensus type
+func newRewarder(consensusType ConsensusType, cfg *config.Config) *rewarder {
+        return &rewarder{
+                consensusType: consensusType,
+                shardID:       cfg.ShardID,
+                blockReward:   cfg.BlockReward,
+                relayShare:    cfg.RelayRewardShare,
+        }
+}
+
+// SetRelaySelector sets how the relay rewarded for cross-shard traffic is
+// chosen
+func (r *rewarder) SetRelaySelector(selector RelaySelector) {
+        r.mu.Lock()
+        defer r.mu.Unlock()
+        r.selectRelay = selector
+}
+
+// SetRelayChecker sets how relay payouts in other producers' blocks are
+// checked
+func (r *rewarder) SetRelayChecker(checker RelayChecker) {
+        r.mu.Lock()
+        defer r.mu.Unlock()
+        r.isRelay = checker
+}
+
+// payouts returns the coinbase payouts for a block produced by producer and,
+// for PBFT, committed by committee
+func (r *rewarder) payouts(producer string, committee []string, txs []core.Transaction) ([]core.Payout, error) {
+        var payouts []core.Payout
+        add := func(recipient string, amount core.Amount, kind core.PayoutKind) {
+                if amount > 0 {
+                        payouts = append(payouts, core.Payout{Recipient: recipient, Amount: amount, Kind: kind})
+                }
+        }
+
+        if r.consensusType == PBFT && len(committee) > 0 {
+                share, err := r.committeeShare(len(committee))
+                if err != nil {
+                        return nil, err
+                }
+                for _, member := range committee {
+                        add(member, share, core.PayoutBlockReward)
+                }
+        } else {
+                add(producer, r.blockReward, core.PayoutBlockReward)
+        }
+
+        fees := core.CollectFees(txs)
+        producerFees := fees.Total
+        if relay, ok := r.relay(); ok && fees.CrossShard > 0 {
+                relayFees, err := fees.CrossShard.Fraction(r.relayShare)
+                if err != nil {
+                        return nil, err
+                }
+                add(relay, relayFees, core.PayoutRelay)
+                producerFees -= relayFees
+        }
+        add(producer, producerFees, core.PayoutFees)
+        return payouts, nil
+}
+
+// committeeShare returns each committee member's share of the block reward.
+// The remainder of an uneven split is not minted.
+func (r *rewarder) committeeShare(committeeSize int) (core.Amount, error) {
+        return r.blockReward.MulDiv(1, int64(committeeSize))
+}
+
+// relay returns the relay to reward, if one is available
+func (r *rewarder) relay() (string, bool) {
+        r.mu.RLock()
+        selectRelay := r.selectRelay
+        r.mu.RUnlock()
+
+        if selectRelay == nil || r.relayShare <= 0 {
+                return "", false
+        }
+        relay, err := selectRelay()
+        if err != nil || relay == "" {
+                return "", false
+        }
+        return relay, true
+}
+
+// addCoinbase appends the coinbase transaction to a block before it is
+// signed
+func (r *rewarder) addCoinbase(block *core.Block, committee []string) error {
+        producer := block.Header.ValidatorID
+        payouts, err := r.payouts(producer, committee, block.Transactions)
+        if err != nil {
+                return err
+        }
+        if len(payouts) == 0 {
+                return nil
+        }
+
+        coinbase, err := core.NewCoinbaseTransaction(producer, block.Header.Height, payouts, r.shardID)
+        if err != nil {
+                return err
+        }
+        if err := coinbase.Sign(producer); err != nil {
+                return err
+        }
+        block.AddTransaction(*coinbase)
+        return nil
+}
+
+// verifyCoinbase checks that a block's coinbase pays the block reward and
+// the producer's fees to the recipients the consensus type entitles, and
+// relay fees to an active relay. The amounts are limited by the chain state.
+func (r *rewarder) verifyCoinbase(block *core.Block, committee []string) error {
+        var coinbase *core.Transaction
+        for i := range block.Transactions {
+                if block.Transactions[i].Type == core.ConsensusTransaction {
+                        coinbase = &block.Transactions[i]
+                }
+        }
+        if coinbase == nil {
+                return nil
+        }
+
+        payload, err := core.DecodeCoinbase(coinbase)
+        if err != nil {
+                return err
+        }
+        if coinbase.From != block.Header.ValidatorID {
+                return errors.New("coinbase was not created by the block producer")
+        }
+
+        r.mu.RLock()
+        isRelay := r.isRelay
+        r.mu.RUnlock()
+
+        entitled := map[string]bool{block.Header.ValidatorID: true}
+        if r.consensusType == PBFT {
+                for _, member := range committee {
+                        entitled[member] = true
+                }
+        }
+        for _, payout := range payload.Payouts {
+                switch payout.Kind {
+                case core.PayoutBlockReward:
+                        if !entitled[payout.Recipient] {
+                                return fmt.Errorf("%s is not entitled to the block reward", payout.Recipient)
+                        }
+                        if r.consensusType == PBFT && len(committee) > 0 {
+                                share, err := r.committeeShare(len(committee))
+                                if err != nil {
+                                        return err
+                                }
+                                if payout.Amount != share {
+                                        return errors.New("block reward is not split evenly across the committee")
+                                }
+                        }
+                case core.PayoutFees:
+                        if payout.Recipient != block.Header.ValidatorID {
+                                return fmt.Errorf("%s is not entitled to the block fees", payout.Recipient)
+                        }
+                case core.PayoutRelay:
+                        if isRelay == nil || !isRelay(payout.Recipient) {
+                                return fmt.Errorf("%s is not an active relay entitled to relay fees", payout.Recipient)
+                        }
+                }
+        }
+        return nil
+}
@@ -0,0 +1,92 @@
+package consensus
+
+import (
+	"encoding/json"
+	"testing"
+
+	"lscc/config"
+	"lscc/core"
+	"lscc/utils"
+)
+
+// newTestRewarder returns a PoS rewarder of shard 0 paying relay1 for
+// cross-shard traffic, with relay1 and relay2 as the active relays
+func newTestRewarder() *rewarder {
+	cfg := config.DefaultConfig()
+	cfg.ShardID = 0
+	r := newRewarder(ProofOfStake, cfg)
+	r.SetRelaySelector(func() (string, error) { return "relay1", nil })
+	r.SetRelayChecker(func(nodeID string) bool { return nodeID == "relay1" || nodeID == "relay2" })
+	return r
+}
+
+// rewardedBlock returns a block of node1 carrying a transfer to shard 1
+// and the coinbase paying for it
+func rewardedBlock(t *testing.T, r *rewarder) *core.Block {
+	t.Helper()
+	block := core.NewBlock("prev", 1, 0, 0, "node1")
+	tx, err := core.NewTransaction("alice", "bob", utils.Coins(10), utils.Coins(1), 0, 1, 0, core.CrossShardTransaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.AddTransaction(*tx)
+	if err := r.addCoinbase(block, nil); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// redirectRelayShare rewrites the relay payout of a block's coinbase to pay
+// someone else
+func redirectRelayShare(t *testing.T, block *core.Block, recipient string) {
+	t.Helper()
+	coinbase := &block.Transactions[len(block.Transactions)-1]
+	payload, err := core.DecodeCoinbase(coinbase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirected := false
+	for i := range payload.Payouts {
+		if payload.Payouts[i].Kind == core.PayoutRelay {
+			payload.Payouts[i].Recipient = recipient
+			redirected = true
+		}
+	}
+	if !redirected {
+		t.Fatal("coinbase has no relay payout")
+	}
+	if coinbase.Data, err = json.Marshal(payload); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyCoinbaseChecksRelayRecipients(t *testing.T) {
+	r := newTestRewarder()
+	if err := r.verifyCoinbase(rewardedBlock(t, r), nil); err != nil {
+		t.Fatalf("coinbase paying the selected relay refused: %v", err)
+	}
+
+	// Another active relay may be paid, as relay selection can differ
+	// between producer and validator
+	block := rewardedBlock(t, r)
+	redirectRelayShare(t, block, "relay2")
+	if err := r.verifyCoinbase(block, nil); err != nil {
+		t.Errorf("coinbase paying an active relay refused: %v", err)
+	}
+
+	// The producer cannot take the relay share for itself, or hand it to
+	// anyone outside the relay set
+	for _, recipient := range []string{"node1", "mallory"} {
+		block := rewardedBlock(t, r)
+		redirectRelayShare(t, block, recipient)
+		if err := r.verifyCoinbase(block, nil); err == nil {
+			t.Errorf("coinbase redirecting the relay share to %s accepted", recipient)
+		}
+	}
+
+	// Without a relay set to check against, no relay payout is accepted
+	unchecked := newRewarder(ProofOfStake, config.DefaultConfig())
+	if err := unchecked.verifyCoinbase(rewardedBlock(t, r), nil); err == nil {
+		t.Error("relay payout accepted without a relay set")
+	}
+}
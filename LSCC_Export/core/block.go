@@ -3,9 +3,10 @@ package core
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"time"
+
+	"lscc/utils"
 )
 
 // Block represents a block in the blockchain
@@ -14,10 +15,12 @@ type Block struct {
 	Transactions []Transaction `json:"transactions"`
 	ShardID      int           `json:"shard_id"`
 	Signature    string        `json:"signature"`
+	KeySignature string        `json:"key_signature,omitempty"` // Header signed with the validator's registered key
 }
 
 // BlockHeader contains metadata of a block
 type BlockHeader struct {
+	ChainID        string    `json:"chain_id"`
 	Version        uint32    `json:"version"`
 	PreviousHash   string    `json:"previous_hash"`
 	MerkleRoot     string    `json:"merkle_root"`
@@ -41,6 +44,7 @@ type CrossRef struct {
 func NewBlock(prevHash string, height uint64, shardID int, layer int, validatorID string) *Block {
 	block := &Block{
 		Header: BlockHeader{
+			ChainID:      ChainID(),
 			Version:      1,
 			PreviousHash: prevHash,
 			Timestamp:    time.Now().Unix(),
@@ -97,15 +101,9 @@ func (b *Block) CalculateMerkleRoot() string {
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
-// Hash calculates the hash of the block
+// Hash calculates the hash of the block from its canonical header payload
 func (b *Block) Hash() (string, error) {
-	headerBytes, err := json.Marshal(b.Header)
-	if err != nil {
-		return "", err
-	}
-	hasher := sha256.New()
-	hasher.Write(headerBytes)
-	return hex.EncodeToString(hasher.Sum(nil)), nil
+	return hashPayload(b.HeaderPayload()), nil
 }
 
 // Sign signs the block with the provided private key
@@ -118,7 +116,18 @@ func (b *Block) Sign(privateKey string) error {
 	}
 	
 	// Simulate signature (in reality, would use crypto library)
-	b.Signature = fmt.Sprintf("signed:%s:%s", privateKey[:8], hash)
+	b.Signature = fmt.Sprintf("signed:%s:%s", keyPrefix(privateKey), hash)
+	return nil
+}
+
+// SignWithKey signs the block header with the validator's registered key,
+// which is what lets the header be held against the validator as evidence
+func (b *Block) SignWithKey(privateKey string) error {
+	signature, err := utils.SignWithKey(b.HeaderPayload(), privateKey)
+	if err != nil {
+		return err
+	}
+	b.KeySignature = signature
 	return nil
 }
 
@@ -145,6 +154,16 @@ func (b *Block) IsValid(prevBlock *Block) bool {
 		if b.Header.Height != prevBlock.Header.Height+1 {
 			return false
 		}
+
+		// Blocks and their transactions never move between chains
+		if b.Header.ChainID != prevBlock.Header.ChainID {
+			return false
+		}
+	}
+	for i := range b.Transactions {
+		if b.Transactions[i].ChainID != b.Header.ChainID {
+			return false
+		}
 	}
 	
 	// Verify merkle root
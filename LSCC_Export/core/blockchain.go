@@ -2,8 +2,9 @@ package core
 
 import (
         "errors"
+        "fmt"
+        "sort"
         "sync"
-        "time"
 
         "lscc/config"
         "lscc/utils"
@@ -14,65 +15,219 @@ type Blockchain struct {
         Blocks       []*Block
         Transactions map[string]*Transaction // Map of transaction hash to transaction
         Config       *config.Config
+        State        *State
+        Finality     *FinalityTracker
+        Layer        int
+        included     map[string]TxLocation // Maps transaction hash to its position in the chain
+        dropped      map[string]*droppedTx // Pool transactions that stopped applying
+        crossRefs    []CrossRef // Headers from other shards waiting to be anchored
+        slashHandlers []func(*Slashing)
+        events       *EventBus // Where block and transaction events are published
+        clock        Clock     // Time new blocks are stamped with
+        creditVerifier CreditVerifier // Checks incoming credits against their source
         mu           sync.RWMutex
         logger       *utils.Logger
 }
 
 // NewBlockchain creates a new blockchain with a genesis block
 func NewBlockchain(cfg *config.Config) *Blockchain {
-        logger := utils.GetLogger()
+        logger := utils.GetLogger().Named("core")
         bc := &Blockchain{
                 Blocks:       []*Block{},
                 Transactions: make(map[string]*Transaction),
                 Config:       cfg,
+                Finality:     NewFinalityTracker(cfg.MinConfirmations),
+                Layer:        cfg.LayerOfShard(cfg.ShardID),
+                included:     make(map[string]TxLocation),
+                dropped:      make(map[string]*droppedTx),
+                crossRefs:    []CrossRef{},
+                clock:        SystemClock,
                 logger:       logger,
         }
 
+        bc.State = bc.genesisState()
+
         // Create genesis block
-        genesis := createGenesisBlock(cfg.ShardID)
+        genesis := createGenesisBlock(cfg, bc.Layer)
         bc.AddBlock(genesis)
 
-        logger.Info("Blockchain initialized with genesis block", "shardID", cfg.ShardID)
+        logger.Info("Blockchain initialized with genesis block", "shardID", cfg.ShardID, "layer", bc.Layer)
         return bc
 }
 
-// createGenesisBlock creates the genesis block for the blockchain
-func createGenesisBlock(shardID int) *Block {
-        genesisBlock := NewBlock("0", 0, shardID, 0, "genesis")
-        genesisBlock.Header.Timestamp = time.Now().Unix()
+// genesisState returns the state before the genesis block: the genesis
+// allocations and validator stakes belonging to this shard
+func (bc *Blockchain) genesisState() *State {
+        state := NewState(StateParams{
+                ChannelChallengePeriod: uint64(bc.Config.ChannelChallengePeriod),
+                MinStake:               bc.Config.MinStake,
+                EpochLength:            uint64(bc.Config.EpochLength),
+                UnbondingPeriod:        uint64(bc.Config.UnbondingPeriod),
+                SlashFraction:          bc.Config.SlashFraction,
+                BlockReward:            bc.Config.BlockReward,
+                RelayRewardShare:       bc.Config.RelayRewardShare,
+                GasPrice:               bc.Config.GasPrice,
+                MaxContractGas:         bc.Config.MaxContractGas,
+        })
+        for _, alloc := range bc.Config.Allocations {
+                if alloc.ShardID == bc.Config.ShardID {
+                        if err := state.Credit(alloc.Address, alloc.Amount); err != nil {
+                                bc.logger.Error("Skipping genesis allocation", "address", alloc.Address, "error", err)
+                        }
+                }
+        }
+        for _, validator := range bc.Config.Validators {
+                if validator.ShardID == bc.Config.ShardID {
+                        if err := state.GenesisStake(validator.Address, validator.Stake); err != nil {
+                                bc.logger.Error("Skipping genesis stake", "validator", validator.Address, "error", err)
+                        }
+                }
+                // Keys are registered in every shard, as evidence against a
+                // validator or relay can be submitted to any of them
+                if validator.PubKey != "" {
+                        if err := state.RegisterKey(validator.Address, validator.PubKey); err != nil {
+                                bc.logger.Error("Skipping genesis key", "validator", validator.Address, "error", err)
+                        }
+                }
+        }
+        return state
+}
+
+// createGenesisBlock creates the genesis block for the blockchain. It only
+// depends on the genesis, so every node of a shard builds the same block,
+// and it commits to the genesis hash in place of a previous block hash.
+func createGenesisBlock(cfg *config.Config, layer int) *Block {
+        prevHash := "0"
+        if cfg.GenesisHash != "" {
+                prevHash = cfg.GenesisHash
+        }
+        genesisBlock := NewBlock(prevHash, 0, cfg.ShardID, layer, "genesis")
+        genesisBlock.Header.ChainID = cfg.ChainID
+        if genesisBlock.Header.ChainID == "" {
+                genesisBlock.Header.ChainID = config.DefaultChainID
+        }
+        genesisBlock.Header.Timestamp = cfg.GenesisTime
         genesisBlock.Header.MerkleRoot = genesisBlock.CalculateMerkleRoot()
         return genesisBlock
 }
 
 // AddBlock adds a block to the blockchain
 func (bc *Blockchain) AddBlock(block *Block) error {
+        txs := make([]*Transaction, len(block.Transactions))
+        for i := range block.Transactions {
+                txs[i] = &block.Transactions[i]
+        }
+        if err := bc.verifyCredits(txs...); err != nil {
+                return err
+        }
+        if err := bc.addBlock(block); err != nil {
+                return err
+        }
+        bc.notifySlashings(slashingsIn(block))
+        bc.publish(Event{Type: EventBlockAdded, Block: block})
+        return nil
+}
+
+// addBlock validates a block and appends it to the chain
+func (bc *Blockchain) addBlock(block *Block) error {
         bc.mu.Lock()
         defer bc.mu.Unlock()
 
         // Validate block before adding
+        if err := checkTimestamp(block, bc.clock.Now()); err != nil {
+                return err
+        }
         if len(bc.Blocks) > 0 {
                 lastBlock := bc.Blocks[len(bc.Blocks)-1]
+                if block.Header.Height <= lastBlock.Header.Height {
+                        if bc.Finality.IsFinalized(block.Header.Height) {
+                                return fmt.Errorf("%w: height %d", ErrFinalizedReorg, block.Header.Height)
+                        }
+                        return errors.New("block does not extend the chain")
+                }
                 if !block.IsValid(lastBlock) {
                         return errors.New("invalid block")
                 }
         }
 
-        // Add transactions to the transaction pool
-        for _, tx := range block.Transactions {
-                bc.Transactions[tx.Hash] = &tx
+        hash, err := block.Hash()
+        if err != nil {
+                return err
+        }
+
+        // Apply the block's transactions to the shard state
+        if err := bc.State.ApplyTransactions(block.Transactions, block.Header.Height); err != nil {
+                return fmt.Errorf("invalid block state transition: %w", err)
+        }
+
+        // Record transactions and where they were included
+        for i := range block.Transactions {
+                tx := &block.Transactions[i]
+                bc.Transactions[tx.Hash] = tx
+                bc.included[tx.Hash] = TxLocation{Height: block.Header.Height, Index: i}
+                delete(bc.dropped, tx.Hash)
         }
 
         // Add block to the chain
         bc.Blocks = append(bc.Blocks, block)
+        bc.dropInvalidPending(block.Header.Height + 1)
+        bc.pruneCrossRefs(block.Header.CrossRefs)
+        before, after := bc.Finality.setTip(block.Header.Height)
+        bc.markFinalized(before, after)
         bc.logger.Info("Added new block to the chain", 
                 "height", block.Header.Height,
-                "hash", block.Hash,
+                "hash", hash,
                 "txs", len(block.Transactions),
                 "shardID", block.ShardID)
         
         return nil
 }
 
+// AddPendingCrossRef queues a header from another shard to be anchored in
+// the next block produced on this chain
+func (bc *Blockchain) AddPendingCrossRef(ref CrossRef) {
+        bc.mu.Lock()
+        defer bc.mu.Unlock()
+
+        for _, existing := range bc.crossRefs {
+                if existing.BlockHash == ref.BlockHash {
+                        return
+                }
+        }
+        bc.crossRefs = append(bc.crossRefs, ref)
+}
+
+// GetPendingCrossRefs returns the headers waiting to be anchored
+func (bc *Blockchain) GetPendingCrossRefs() []CrossRef {
+        bc.mu.RLock()
+        defer bc.mu.RUnlock()
+
+        refs := make([]CrossRef, len(bc.crossRefs))
+        copy(refs, bc.crossRefs)
+        return refs
+}
+
+// pruneCrossRefs drops pending references that a committed block anchored.
+// Must be called with the lock held.
+func (bc *Blockchain) pruneCrossRefs(anchored []CrossRef) {
+        if len(anchored) == 0 || len(bc.crossRefs) == 0 {
+                return
+        }
+
+        done := make(map[string]bool, len(anchored))
+        for _, ref := range anchored {
+                done[ref.BlockHash] = true
+        }
+
+        remaining := bc.crossRefs[:0]
+        for _, ref := range bc.crossRefs {
+                if !done[ref.BlockHash] {
+                        remaining = append(remaining, ref)
+                }
+        }
+        bc.crossRefs = remaining
+}
+
 // GetBlockByHeight retrieves a block by its height
 func (bc *Blockchain) GetBlockByHeight(height uint64) *Block {
         bc.mu.RLock()
@@ -103,6 +258,23 @@ func (bc *Blockchain) GetBlockByHash(hash string) *Block {
         return nil
 }
 
+// GetBlockRange returns up to limit consecutive blocks starting at height
+// from, in ascending order
+func (bc *Blockchain) GetBlockRange(from uint64, limit int) []*Block {
+        bc.mu.RLock()
+        defer bc.mu.RUnlock()
+
+        if limit <= 0 || from >= uint64(len(bc.Blocks)) {
+                return nil
+        }
+        to := from + uint64(limit)
+        if to > uint64(len(bc.Blocks)) {
+                to = uint64(len(bc.Blocks))
+        }
+        blocks := make([]*Block, 0, to-from)
+        return append(blocks, bc.Blocks[from:to]...)
+}
+
 // GetLatestBlock returns the latest block in the chain
 func (bc *Blockchain) GetLatestBlock() *Block {
         bc.mu.RLock()
@@ -148,8 +320,27 @@ func (bc *Blockchain) GetTransaction(hash string) *Transaction {
         return tx
 }
 
+// ChainID returns the ID of the chain, fixed by its genesis block
+func (bc *Blockchain) ChainID() string {
+        bc.mu.RLock()
+        defer bc.mu.RUnlock()
+        return bc.Blocks[0].Header.ChainID
+}
+
 // AddTransaction adds a transaction to the pool
 func (bc *Blockchain) AddTransaction(tx *Transaction) error {
+        if err := bc.verifyCredits(tx); err != nil {
+                return err
+        }
+        if err := bc.addTransaction(tx); err != nil {
+                return err
+        }
+        bc.publish(Event{Type: EventTxAccepted, Transaction: tx})
+        return nil
+}
+
+// addTransaction validates a transaction and adds it to the pool
+func (bc *Blockchain) addTransaction(tx *Transaction) error {
         bc.mu.Lock()
         defer bc.mu.Unlock()
 
@@ -162,23 +353,44 @@ func (bc *Blockchain) AddTransaction(tx *Transaction) error {
         if !tx.IsValid() {
                 return errors.New("invalid transaction")
         }
+        if chainID := bc.Blocks[0].Header.ChainID; tx.ChainID != chainID {
+                return fmt.Errorf("transaction is for chain %q, not %q", tx.ChainID, chainID)
+        }
+
+        // Check the transaction applies against the current state
+        nextHeight := uint64(0)
+        if len(bc.Blocks) > 0 {
+                nextHeight = bc.Blocks[len(bc.Blocks)-1].Header.Height + 1
+        }
+        if err := bc.State.Copy().ApplyTransaction(tx, nextHeight); err != nil {
+                return err
+        }
 
         bc.Transactions[tx.Hash] = tx
+        delete(bc.dropped, tx.Hash)
         bc.logger.Info("Added new transaction to pool", "hash", tx.Hash)
         return nil
 }
 
-// GetPendingTransactions returns all pending transactions
+// GetPendingTransactions returns all transactions not yet included in a
+// block, oldest first
 func (bc *Blockchain) GetPendingTransactions() []*Transaction {
         bc.mu.RLock()
         defer bc.mu.RUnlock()
 
         var pendingTxs []*Transaction
-        for _, tx := range bc.Transactions {
-                if !tx.IsConfirmed {
+        for hash, tx := range bc.Transactions {
+                if _, included := bc.included[hash]; !included {
                         pendingTxs = append(pendingTxs, tx)
                 }
         }
+
+        sort.Slice(pendingTxs, func(i, j int) bool {
+                if pendingTxs[i].Timestamp != pendingTxs[j].Timestamp {
+                        return pendingTxs[i].Timestamp < pendingTxs[j].Timestamp
+                }
+                return pendingTxs[i].Hash < pendingTxs[j].Hash
+        })
         return pendingTxs
 }
 
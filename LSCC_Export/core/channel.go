@@ -0,0 +1,266 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"lscc/utils"
+)
+
+// Payment channel lifecycle
+//
+// A channel is opened on-chain by PartyA locking a deposit. The parties then
+// exchange ChannelUpdates off-chain, each splitting the deposit between them
+// and carrying a strictly increasing sequence number signed by both. Either
+// party may close the channel on-chain with the latest update it holds; this
+// starts a challenge period during which the counterparty can dispute with a
+// higher-sequence update. Once the period ends, a settle transaction pays out
+// the final balances.
+
+// ChannelStatus is the on-chain status of a payment channel
+type ChannelStatus string
+
+const (
+	// ChannelOpen channels accept off-chain updates
+	ChannelOpen ChannelStatus = "open"
+	// ChannelClosing channels are in their challenge period
+	ChannelClosing ChannelStatus = "closing"
+	// ChannelSettled channels have paid out and are final
+	ChannelSettled ChannelStatus = "settled"
+)
+
+// PaymentChannel is the on-chain record of a channel between two accounts
+type PaymentChannel struct {
+	ID           string        `json:"id"`
+	PartyA       string        `json:"party_a"`
+	PartyB       string        `json:"party_b"`
+	Deposit      Amount        `json:"deposit"`
+	BalanceA     Amount        `json:"balance_a"`
+	BalanceB     Amount        `json:"balance_b"`
+	Sequence     uint64        `json:"sequence"`
+	Status       ChannelStatus `json:"status"`
+	OpenedAt     uint64        `json:"opened_at"`
+	ClosedBy     string        `json:"closed_by,omitempty"`
+	ChallengeEnd uint64        `json:"challenge_end,omitempty"`
+}
+
+// ChannelUpdate is an off-chain balance update signed by both parties
+type ChannelUpdate struct {
+	ChannelID  string `json:"channel_id"`
+	Sequence   uint64 `json:"sequence"`
+	BalanceA   Amount `json:"balance_a"`
+	BalanceB   Amount `json:"balance_b"`
+	SignatureA string `json:"signature_a"`
+	SignatureB string `json:"signature_b"`
+}
+
+// ChannelSettlePayload identifies the channel a settle transaction pays out
+type ChannelSettlePayload struct {
+	ChannelID string `json:"channel_id"`
+}
+
+// SigningPayload returns the bytes both parties sign for an update
+func (u *ChannelUpdate) SigningPayload() []byte {
+	return []byte(fmt.Sprintf("channel:%s:%d:%d:%d", u.ChannelID, u.Sequence, u.BalanceA, u.BalanceB))
+}
+
+// Sign adds one party's signature to the update
+func (u *ChannelUpdate) Sign(ch *PaymentChannel, party, privateKey string) error {
+	signature, err := utils.Sign(u.SigningPayload(), privateKey)
+	if err != nil {
+		return err
+	}
+
+	switch party {
+	case ch.PartyA:
+		u.SignatureA = signature
+	case ch.PartyB:
+		u.SignatureB = signature
+	default:
+		return fmt.Errorf("%s is not a party to channel %s", party, ch.ID)
+	}
+	return nil
+}
+
+// Verify checks that an update is well-formed for the channel and signed by
+// both parties
+func (u *ChannelUpdate) Verify(ch *PaymentChannel) error {
+	if u.ChannelID != ch.ID {
+		return errors.New("update is for a different channel")
+	}
+	if u.BalanceA < 0 || u.BalanceB < 0 {
+		return errors.New("negative channel balance")
+	}
+	if total, err := u.BalanceA.Add(u.BalanceB); err != nil || total != ch.Deposit {
+		return fmt.Errorf("balances must sum to the channel deposit %s", ch.Deposit)
+	}
+
+	payload := u.SigningPayload()
+	if !utils.VerifySignature(payload, u.SignatureA, ch.PartyA) {
+		return errors.New("missing or invalid signature from party A")
+	}
+	if !utils.VerifySignature(payload, u.SignatureB, ch.PartyB) {
+		return errors.New("missing or invalid signature from party B")
+	}
+	return nil
+}
+
+// NewChannelOpenTransaction creates a transaction opening a channel funded
+// by from with the given deposit
+func NewChannelOpenTransaction(from, to string, deposit, fee Amount, shardID int) (*Transaction, error) {
+	return NewTransaction(from, to, deposit, fee, shardID, shardID, 0, ChannelOpenTransaction)
+}
+
+// NewChannelCloseTransaction creates a transaction starting the challenge
+// period of a channel with the latest update held by from
+func NewChannelCloseTransaction(from string, update *ChannelUpdate, fee Amount, shardID int) (*Transaction, error) {
+	return newPayloadTransaction(from, from, 0, fee, shardID, ChannelCloseTransaction, update)
+}
+
+// NewChannelDisputeTransaction creates a transaction replacing the closing
+// state of a channel with a newer update
+func NewChannelDisputeTransaction(from string, update *ChannelUpdate, fee Amount, shardID int) (*Transaction, error) {
+	return newPayloadTransaction(from, from, 0, fee, shardID, ChannelDisputeTransaction, update)
+}
+
+// NewChannelSettleTransaction creates a transaction paying out a channel
+// whose challenge period has ended
+func NewChannelSettleTransaction(from, channelID string, fee Amount, shardID int) (*Transaction, error) {
+	return newPayloadTransaction(from, from, 0, fee, shardID, ChannelSettleTransaction, ChannelSettlePayload{ChannelID: channelID})
+}
+
+// GetChannel returns a copy of a channel's on-chain record
+func (s *State) GetChannel(id string) (*PaymentChannel, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ch, exists := s.channels[id]
+	if !exists {
+		return nil, false
+	}
+	chCopy := *ch
+	return &chCopy, true
+}
+
+// GetChannels returns copies of all channel records
+func (s *State) GetChannels() []*PaymentChannel {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	channels := make([]*PaymentChannel, 0, len(s.channels))
+	for _, ch := range s.channels {
+		chCopy := *ch
+		channels = append(channels, &chCopy)
+	}
+	return channels
+}
+
+// applyChannelTransaction applies a channel lifecycle transaction. Must be
+// called with the lock held.
+func (s *State) applyChannelTransaction(tx *Transaction, height uint64) error {
+	switch tx.Type {
+	case ChannelOpenTransaction:
+		if tx.From == tx.To {
+			return errors.New("cannot open a channel with yourself")
+		}
+		if _, exists := s.channels[tx.Hash]; exists {
+			return errors.New("channel already exists")
+		}
+		if err := s.debitWithFee(tx.From, tx.Amount, tx.Fee); err != nil {
+			return err
+		}
+		s.channels[tx.Hash] = &PaymentChannel{
+			ID:       tx.Hash,
+			PartyA:   tx.From,
+			PartyB:   tx.To,
+			Deposit:  tx.Amount,
+			BalanceA: tx.Amount,
+			Status:   ChannelOpen,
+			OpenedAt: height,
+		}
+		return nil
+
+	case ChannelCloseTransaction, ChannelDisputeTransaction:
+		var update ChannelUpdate
+		if err := json.Unmarshal(tx.Data, &update); err != nil {
+			return fmt.Errorf("invalid channel update: %w", err)
+		}
+		ch, exists := s.channels[update.ChannelID]
+		if !exists {
+			return errors.New("channel not found")
+		}
+		if tx.From != ch.PartyA && tx.From != ch.PartyB {
+			return errors.New("only channel parties may close or dispute")
+		}
+
+		if tx.Type == ChannelCloseTransaction {
+			if ch.Status != ChannelOpen {
+				return errors.New("channel is not open")
+			}
+		} else {
+			if ch.Status != ChannelClosing {
+				return errors.New("channel is not closing")
+			}
+			if height > ch.ChallengeEnd {
+				return errors.New("challenge period has ended")
+			}
+			if update.Sequence <= ch.Sequence {
+				return errors.New("dispute must carry a newer sequence number")
+			}
+		}
+
+		// Sequence 0 is the implicit opening state and needs no signatures
+		if update.Sequence > 0 {
+			if err := update.Verify(ch); err != nil {
+				return err
+			}
+		} else {
+			update.BalanceA, update.BalanceB = ch.Deposit, 0
+		}
+
+		if err := s.debit(tx.From, tx.Fee); err != nil {
+			return err
+		}
+
+		ch.BalanceA = update.BalanceA
+		ch.BalanceB = update.BalanceB
+		ch.Sequence = update.Sequence
+		if tx.Type == ChannelCloseTransaction {
+			ch.Status = ChannelClosing
+			ch.ClosedBy = tx.From
+			ch.ChallengeEnd = height + s.params.ChannelChallengePeriod
+		}
+		return nil
+
+	case ChannelSettleTransaction:
+		var payload ChannelSettlePayload
+		if err := json.Unmarshal(tx.Data, &payload); err != nil {
+			return fmt.Errorf("invalid settle payload: %w", err)
+		}
+		ch, exists := s.channels[payload.ChannelID]
+		if !exists {
+			return errors.New("channel not found")
+		}
+		if ch.Status != ChannelClosing {
+			return errors.New("channel is not closing")
+		}
+		if height <= ch.ChallengeEnd {
+			return fmt.Errorf("challenge period ends at height %d", ch.ChallengeEnd)
+		}
+		if err := s.debit(tx.From, tx.Fee); err != nil {
+			return err
+		}
+
+		if err := s.credit(ch.PartyA, ch.BalanceA); err != nil {
+			return err
+		}
+		if err := s.credit(ch.PartyB, ch.BalanceB); err != nil {
+			return err
+		}
+		ch.Status = ChannelSettled
+		return nil
+	}
+
+	return fmt.Errorf("unsupported channel transaction type %d", tx.Type)
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"lscc/utils"
+)
+
+// newTestState returns a state with a two-block challenge period in which
+// alice and bob hold 100 coins each
+func newTestState(t *testing.T) *State {
+	t.Helper()
+	state := NewState(StateParams{ChannelChallengePeriod: 2})
+	for _, address := range []string{"alice", "bob"} {
+		if err := state.Credit(address, utils.Coins(100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return state
+}
+
+// openChannel opens a channel of alice to bob with a deposit of 10 coins
+func openChannel(t *testing.T, state *State) *PaymentChannel {
+	t.Helper()
+	tx, err := NewChannelOpenTransaction("alice", "bob", utils.Coins(10), utils.Coins(1), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.ApplyTransaction(tx, 1); err != nil {
+		t.Fatal(err)
+	}
+	ch, _ := state.GetChannel(tx.Hash)
+	return ch
+}
+
+// channelUpdate returns an update of a channel signed by the given parties
+func channelUpdate(t *testing.T, ch *PaymentChannel, sequence uint64, coinsA, coinsB int64, signers ...string) *ChannelUpdate {
+	t.Helper()
+	update := &ChannelUpdate{
+		ChannelID: ch.ID,
+		Sequence:  sequence,
+		BalanceA:  utils.Coins(coinsA),
+		BalanceB:  utils.Coins(coinsB),
+	}
+	for _, party := range signers {
+		if err := update.Sign(ch, party, party); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return update
+}
+
+// applyChannelUpdate closes or disputes a channel with an update at a height
+func applyChannelUpdate(state *State, txType TransactionType, from string, update *ChannelUpdate, height uint64) error {
+	var tx *Transaction
+	var err error
+	if txType == ChannelCloseTransaction {
+		tx, err = NewChannelCloseTransaction(from, update, utils.Coins(1), 0)
+	} else {
+		tx, err = NewChannelDisputeTransaction(from, update, utils.Coins(1), 0)
+	}
+	if err != nil {
+		return err
+	}
+	return state.ApplyTransaction(tx, height)
+}
+
+func TestChannelUpdateVerify(t *testing.T) {
+	ch := openChannel(t, newTestState(t))
+	if err := channelUpdate(t, ch, 1, 6, 4, "alice", "bob").Verify(ch); err != nil {
+		t.Fatalf("update signed by both parties refused: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		update *ChannelUpdate
+		want   string
+	}{
+		{"signed by alice only", channelUpdate(t, ch, 1, 6, 4, "alice"), "party B"},
+		{"signed by bob only", channelUpdate(t, ch, 1, 6, 4, "bob"), "party A"},
+		{"not summing to the deposit", channelUpdate(t, ch, 1, 6, 5, "alice", "bob"), "deposit"},
+		{"with a negative balance", channelUpdate(t, ch, 1, 11, -1, "alice", "bob"), "negative"},
+	}
+	for _, tt := range tests {
+		if err := tt.update.Verify(ch); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("update %s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	other := *ch
+	other.ID = "other"
+	if err := channelUpdate(t, &other, 1, 6, 4, "alice", "bob").Verify(ch); err == nil {
+		t.Error("update of another channel accepted")
+	}
+	if err := (&ChannelUpdate{}).Sign(ch, "carol", "carol"); err == nil {
+		t.Error("update signed by a non-party")
+	}
+}
+
+func TestChannelLifecycle(t *testing.T) {
+	state := newTestState(t)
+	ch := openChannel(t, state)
+	if balance := state.GetBalance("alice"); balance != utils.Coins(89) {
+		t.Fatalf("alice = %s after opening, want 89", balance)
+	}
+
+	// bob closes with an old update; alice disputes with a newer one
+	if err := applyChannelUpdate(state, ChannelCloseTransaction, "bob", channelUpdate(t, ch, 1, 8, 2, "alice", "bob"), 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyChannelUpdate(state, ChannelDisputeTransaction, "alice", channelUpdate(t, ch, 1, 8, 2, "alice", "bob"), 3); err == nil {
+		t.Error("dispute with the same sequence accepted")
+	}
+	if err := applyChannelUpdate(state, ChannelDisputeTransaction, "carol", channelUpdate(t, ch, 2, 3, 7, "alice", "bob"), 3); err == nil {
+		t.Error("dispute of a non-party accepted")
+	}
+	if err := applyChannelUpdate(state, ChannelDisputeTransaction, "alice", channelUpdate(t, ch, 2, 7, 3, "alice"), 3); err == nil {
+		t.Error("dispute signed by one party accepted")
+	}
+	if err := applyChannelUpdate(state, ChannelDisputeTransaction, "alice", channelUpdate(t, ch, 2, 7, 3, "alice", "bob"), 4); err != nil {
+		t.Fatalf("dispute within the challenge period refused: %v", err)
+	}
+	if err := applyChannelUpdate(state, ChannelDisputeTransaction, "alice", channelUpdate(t, ch, 3, 9, 1, "alice", "bob"), 5); err == nil {
+		t.Error("dispute after the challenge period accepted")
+	}
+
+	settle, err := NewChannelSettleTransaction("bob", ch.ID, utils.Coins(1), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.ApplyTransaction(settle, 4); err == nil {
+		t.Fatal("channel settled within its challenge period")
+	}
+	if err := state.ApplyTransaction(settle, 5); err != nil {
+		t.Fatalf("settle after the challenge period refused: %v", err)
+	}
+
+	// alice deposited 10 coins and paid 1 to open and 1 to dispute, bob
+	// paid 1 to close and 1 to settle
+	want := map[string]utils.Amount{"alice": utils.Coins(88 + 7), "bob": utils.Coins(98 + 3)}
+	for address, balance := range want {
+		if got := state.GetBalance(address); got != balance {
+			t.Errorf("%s = %s after settling, want %s", address, got, balance)
+		}
+	}
+	if err := state.ApplyTransaction(settle, 6); err == nil {
+		t.Error("channel settled twice")
+	}
+	if settled, _ := state.GetChannel(ch.ID); settled.Status != ChannelSettled {
+		t.Errorf("status = %s, want %s", settled.Status, ChannelSettled)
+	}
+}
+
+func TestChannelCloseWithOpeningState(t *testing.T) {
+	state := newTestState(t)
+	ch := openChannel(t, state)
+
+	// Sequence 0 is the opening state, returning the whole deposit to alice
+	// whatever balances it names
+	if err := applyChannelUpdate(state, ChannelCloseTransaction, "alice", channelUpdate(t, ch, 0, 0, 10), 2); err != nil {
+		t.Fatal(err)
+	}
+	closing, _ := state.GetChannel(ch.ID)
+	if closing.BalanceA != utils.Coins(10) || closing.BalanceB != 0 {
+		t.Errorf("closing balances = %s/%s, want 10/0", closing.BalanceA, closing.BalanceB)
+	}
+	if closing.ChallengeEnd != 4 || closing.ClosedBy != "alice" {
+		t.Errorf("closed by %s until %d, want alice until 4", closing.ClosedBy, closing.ChallengeEnd)
+	}
+	if err := applyChannelUpdate(state, ChannelCloseTransaction, "bob", channelUpdate(t, ch, 1, 5, 5, "alice", "bob"), 3); err == nil {
+		t.Error("closing channel closed again")
+	}
+}
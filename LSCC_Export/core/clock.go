@@ -0,0 +1,69 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaxFutureBlockTime is how far ahead of a node's clock a block may be
+// stamped. Blocks further ahead are rejected until the clock catches up, so a
+// node whose clock runs fast cannot push the chain's time forward.
+const MaxFutureBlockTime = 15 * time.Second
+
+// ErrFutureBlock is returned for a block stamped too far ahead of the clock
+var ErrFutureBlock = errors.New("block timestamp is too far in the future")
+
+// Clock tells the time of a node. Chains read the host's clock unless given
+// another, as simulations do to run nodes whose clocks are off.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock reads the host's clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the host's clock
+var SystemClock Clock = systemClock{}
+
+// OffsetClock is the host's clock shifted by a fixed offset
+type OffsetClock time.Duration
+
+// Now returns the host's time plus the offset
+func (c OffsetClock) Now() time.Time {
+	return time.Now().Add(time.Duration(c))
+}
+
+// SetClock sets the clock new blocks of the chain are stamped with
+func (bc *Blockchain) SetClock(clock Clock) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.clock = clock
+}
+
+// Now returns the time of the chain's clock
+func (bc *Blockchain) Now() time.Time {
+	bc.mu.RLock()
+	clock := bc.clock
+	bc.mu.RUnlock()
+	return clock.Now()
+}
+
+// CheckTimestamp rejects a block stamped more than MaxFutureBlockTime ahead of
+// the chain's clock
+func (bc *Blockchain) CheckTimestamp(block *Block) error {
+	return checkTimestamp(block, bc.Now())
+}
+
+// checkTimestamp rejects a block stamped too far ahead of now
+func checkTimestamp(block *Block, now time.Time) error {
+	if limit := now.Add(MaxFutureBlockTime).Unix(); block.Header.Timestamp > limit {
+		return fmt.Errorf("%w: %d is %ds ahead of the clock", ErrFutureBlock,
+			block.Header.Timestamp, block.Header.Timestamp-now.Unix())
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package core
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckTimestamp(t *testing.T) {
+	now := time.Unix(1000, 0)
+	block := NewBlock("prev", 1, 0, 0, "node1")
+
+	block.Header.Timestamp = now.Add(MaxFutureBlockTime).Unix()
+	if err := checkTimestamp(block, now); err != nil {
+		t.Errorf("block at the bound rejected: %v", err)
+	}
+
+	block.Header.Timestamp = now.Add(MaxFutureBlockTime + time.Second).Unix()
+	if err := checkTimestamp(block, now); !errors.Is(err, ErrFutureBlock) {
+		t.Errorf("block past the bound: err = %v, want %v", err, ErrFutureBlock)
+	}
+
+	// A clock running fast accepts what a correct one refuses
+	if err := checkTimestamp(block, now.Add(time.Minute)); err != nil {
+		t.Errorf("block rejected by a fast clock: %v", err)
+	}
+}
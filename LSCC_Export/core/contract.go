@@ -0,0 +1,451 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"lscc/vm"
+)
+
+// Smart contracts
+//
+// A deploy transaction stores contract code in the shard state at an address
+// derived from its hash and runs the code once with the method "init". A
+// call transaction runs a contract's code with a method and arguments; value
+// it carries is credited to the contract. Code runs in the sandboxed
+// interpreter of the vm package and can only touch its own storage, read
+// balances and pay out of the contract's balance.
+//
+// Both transactions name a gas limit. The fee must cover the limit at the
+// chain's gas price and is charged in full, whatever the call uses. A call
+// that runs out of gas, reverts or fails is still included: only its fee is
+// taken, its storage writes and transfers are undone, attached value stays
+// with the sender and its receipt records the error.
+
+// contractAddressPrefix starts the address of every contract
+const contractAddressPrefix = "contract-"
+
+// ContractInitMethod is the method run when a contract is deployed
+const ContractInitMethod = "init"
+
+// ContractDeployPayload carries the code of a deploy transaction and the
+// arguments of its init call
+type ContractDeployPayload struct {
+	Code     []byte     `json:"code"`
+	Args     []vm.Value `json:"args,omitempty"`
+	GasLimit uint64     `json:"gas_limit"`
+}
+
+// ContractCallPayload names the method and arguments of a call transaction
+type ContractCallPayload struct {
+	Method   string     `json:"method"`
+	Args     []vm.Value `json:"args,omitempty"`
+	GasLimit uint64     `json:"gas_limit"`
+}
+
+// Contract is the on-chain record of a deployed contract. Its funds are the
+// balance of its address.
+type Contract struct {
+	Address   string `json:"address"`
+	Creator   string `json:"creator"`
+	Code      []byte `json:"code"`
+	CodeHash  string `json:"code_hash"`
+	CreatedAt uint64 `json:"created_at"`
+
+	storage map[string]StorageEntry // By encoded key
+}
+
+// StorageEntry is a value stored by a contract
+type StorageEntry struct {
+	Key   vm.Value `json:"key"`
+	Value vm.Value `json:"value"`
+}
+
+// ReceiptStatus is the outcome of a contract transaction
+type ReceiptStatus string
+
+const (
+	// ReceiptSuccess calls ran to completion and their effects were applied
+	ReceiptSuccess ReceiptStatus = "success"
+	// ReceiptFailed calls only paid their fee
+	ReceiptFailed ReceiptStatus = "failed"
+)
+
+// ContractReceipt records the outcome of a contract transaction
+type ContractReceipt struct {
+	TxHash   string        `json:"tx_hash"`
+	Contract string        `json:"contract"`
+	Method   string        `json:"method"`
+	Height   uint64        `json:"height"`
+	Status   ReceiptStatus `json:"status"`
+	GasLimit uint64        `json:"gas_limit"`
+	GasUsed  uint64        `json:"gas_used"`
+	Return   *vm.Value     `json:"return,omitempty"`
+	Logs     []vm.Log      `json:"logs,omitempty"`
+	Messages []string      `json:"messages,omitempty"` // Asynchronous calls sent
+	Message  string        `json:"message,omitempty"`  // Message delivered, for message deliveries
+	Error    string        `json:"error,omitempty"`
+}
+
+// ContractAddress returns the address of the contract deployed by a
+// transaction
+func ContractAddress(deployTxHash string) string {
+	if len(deployTxHash) > 32 {
+		deployTxHash = deployTxHash[:32]
+	}
+	return contractAddressPrefix + deployTxHash
+}
+
+// NewContractDeployTransaction creates a transaction deploying code,
+// endowing the contract with amount
+func NewContractDeployTransaction(from string, code []byte, args []vm.Value, gasLimit uint64, amount, fee Amount, shardID int) (*Transaction, error) {
+	return newPayloadTransaction(from, from, amount, fee, shardID, ContractDeployTransaction, ContractDeployPayload{
+		Code:     code,
+		Args:     args,
+		GasLimit: gasLimit,
+	})
+}
+
+// NewContractCallTransaction creates a transaction calling a contract's
+// method, attaching amount
+func NewContractCallTransaction(from, contract, method string, args []vm.Value, gasLimit uint64, amount, fee Amount, shardID int) (*Transaction, error) {
+	return newPayloadTransaction(from, contract, amount, fee, shardID, ContractCallTransaction, ContractCallPayload{
+		Method:   method,
+		Args:     args,
+		GasLimit: gasLimit,
+	})
+}
+
+// Storage returns the contract's storage, ordered by key
+func (c *Contract) Storage() []StorageEntry {
+	keys := make([]string, 0, len(c.storage))
+	for key := range c.storage {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([]StorageEntry, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, c.storage[key])
+	}
+	return entries
+}
+
+// copy returns a copy of the contract with its own storage map
+func (c *Contract) copy() *Contract {
+	cp := *c
+	cp.storage = make(map[string]StorageEntry, len(c.storage))
+	for key, entry := range c.storage {
+		cp.storage[key] = entry
+	}
+	return &cp
+}
+
+// GetContract returns a copy of a contract's on-chain record
+func (s *State) GetContract(address string) (*Contract, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	contract, exists := s.contracts[address]
+	if !exists {
+		return nil, false
+	}
+	return contract.copy(), true
+}
+
+// GetContracts returns the addresses of the deployed contracts
+func (s *State) GetContracts() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	addresses := make([]string, 0, len(s.contracts))
+	for address := range s.contracts {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// GetContractReceipt returns the receipt of a contract transaction
+func (s *State) GetContractReceipt(txHash string) (*ContractReceipt, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	receipt, exists := s.receipts[txHash]
+	if !exists {
+		return nil, false
+	}
+	receiptCopy := *receipt
+	return &receiptCopy, true
+}
+
+// QueryContract runs a contract method against the current state without
+// applying its effects, for reading contract state
+func (s *State) QueryContract(address, caller, method string, args []vm.Value, gasLimit uint64, height uint64) (*ContractReceipt, error) {
+	trial := s.Copy()
+	contract, exists := trial.contracts[address]
+	if !exists {
+		return nil, fmt.Errorf("contract %s not found", address)
+	}
+	if gasLimit == 0 || gasLimit > trial.params.MaxContractGas {
+		gasLimit = trial.params.MaxContractGas
+	}
+
+	host := newContractHost(trial, contract, &Transaction{TargetShard: -1}, height)
+	result := vm.Execute(contract.Code, vm.Context{
+		Caller:   caller,
+		Address:  address,
+		Height:   height,
+		Method:   method,
+		Args:     args,
+		GasLimit: gasLimit,
+	}, host)
+	return newContractReceipt("", address, method, height, gasLimit, result), nil
+}
+
+// applyContractTransaction applies a deploy or call transaction. Must be
+// called with the lock held.
+func (s *State) applyContractTransaction(tx *Transaction, height uint64) error {
+	var contract *Contract
+	var method string
+	var args []vm.Value
+	var gasLimit uint64
+
+	switch tx.Type {
+	case ContractDeployTransaction:
+		var payload ContractDeployPayload
+		if err := json.Unmarshal(tx.Data, &payload); err != nil {
+			return fmt.Errorf("invalid deploy payload: %w", err)
+		}
+		if err := vm.Validate(payload.Code); err != nil {
+			return fmt.Errorf("invalid contract code: %w", err)
+		}
+		address := ContractAddress(tx.Hash)
+		if _, exists := s.contracts[address]; exists {
+			return errors.New("contract already exists")
+		}
+		codeHash := sha256.Sum256(payload.Code)
+		contract = &Contract{
+			Address:   address,
+			Creator:   tx.From,
+			Code:      payload.Code,
+			CodeHash:  hex.EncodeToString(codeHash[:]),
+			CreatedAt: height,
+			storage:   make(map[string]StorageEntry),
+		}
+		method, args, gasLimit = ContractInitMethod, payload.Args, payload.GasLimit
+
+	case ContractCallTransaction:
+		var payload ContractCallPayload
+		if err := json.Unmarshal(tx.Data, &payload); err != nil {
+			return fmt.Errorf("invalid call payload: %w", err)
+		}
+		existing, exists := s.contracts[tx.To]
+		if !exists {
+			return fmt.Errorf("contract %s not found", tx.To)
+		}
+		contract = existing
+		method, args, gasLimit = payload.Method, payload.Args, payload.GasLimit
+
+	default:
+		return fmt.Errorf("unsupported contract transaction type %d", tx.Type)
+	}
+
+	if err := s.chargeGas(tx, gasLimit); err != nil {
+		return err
+	}
+
+	// Attached value moves with the rest of the call's effects, so a failed
+	// call leaves it with the sender
+	host := newContractHost(s, contract, tx, height)
+	if tx.Amount > 0 {
+		host.balances[tx.From] = s.balances[tx.From] - tx.Amount
+		credited, err := Amount(host.Balance(contract.Address)).Add(tx.Amount)
+		if err != nil {
+			return err
+		}
+		host.balances[contract.Address] = credited
+	}
+
+	result := vm.Execute(contract.Code, vm.Context{
+		Caller:   tx.From,
+		Address:  contract.Address,
+		Value:    int64(tx.Amount),
+		Height:   height,
+		Method:   method,
+		Args:     args,
+		GasLimit: gasLimit,
+	}, host)
+	if result.Err == nil {
+		host.commit()
+		if tx.Type == ContractDeployTransaction {
+			s.contracts[contract.Address] = contract
+		}
+	}
+	s.receipts[tx.Hash] = newContractReceipt(tx.Hash, contract.Address, method, height, gasLimit, result)
+	return nil
+}
+
+// chargeGas checks a contract transaction's gas limit and that its sender
+// can pay the fee and attached value, then takes the fee. Must be called
+// with the lock held.
+func (s *State) chargeGas(tx *Transaction, gasLimit uint64) error {
+	if s.params.MaxContractGas == 0 {
+		return errors.New("contracts are disabled on this chain")
+	}
+	if gasLimit == 0 || gasLimit > s.params.MaxContractGas {
+		return fmt.Errorf("gas limit must be between 1 and %d", s.params.MaxContractGas)
+	}
+	minFee, err := s.params.GasPrice.MulDiv(int64(gasLimit), 1)
+	if err != nil {
+		return err
+	}
+	if tx.Fee < minFee {
+		return fmt.Errorf("fee %s does not cover %d gas at %s per gas", tx.Fee, gasLimit, s.params.GasPrice)
+	}
+
+	total, err := tx.Amount.Add(tx.Fee)
+	if err != nil {
+		return err
+	}
+	if s.balances[tx.From] < total {
+		return fmt.Errorf("%w: %s has %s, needs %s", ErrInsufficientBalance, tx.From, s.balances[tx.From], total)
+	}
+	return s.debit(tx.From, tx.Fee)
+}
+
+// newContractReceipt records the result of a contract call
+func newContractReceipt(txHash, contract, method string, height, gasLimit uint64, result *vm.Result) *ContractReceipt {
+	receipt := &ContractReceipt{
+		TxHash:   txHash,
+		Contract: contract,
+		Method:   method,
+		Height:   height,
+		Status:   ReceiptSuccess,
+		GasLimit: gasLimit,
+		GasUsed:  result.GasUsed,
+		Return:   result.Return,
+		Logs:     result.Logs,
+		Messages: result.Calls,
+	}
+	if result.Err != nil {
+		receipt.Status = ReceiptFailed
+		receipt.Error = result.Err.Error()
+	}
+	return receipt
+}
+
+// contractHost buffers the storage writes, balance changes and messages of a
+// contract call so they can be committed if it succeeds or dropped if it
+// fails
+type contractHost struct {
+	state    *State
+	contract *Contract
+	origin   string // Transaction running the call
+	shardID  int
+	height   uint64
+	storage  map[string]StorageEntry
+	balances map[string]Amount
+	messages []*ContractMessage
+}
+
+func newContractHost(state *State, contract *Contract, tx *Transaction, height uint64) *contractHost {
+	return &contractHost{
+		state:    state,
+		contract: contract,
+		origin:   tx.Hash,
+		shardID:  tx.TargetShard,
+		height:   height,
+		storage:  make(map[string]StorageEntry),
+		balances: make(map[string]Amount),
+	}
+}
+
+// Load returns the value stored under key, including buffered writes
+func (h *contractHost) Load(key vm.Value) (vm.Value, bool) {
+	encoded := string(key.Encode())
+	if entry, written := h.storage[encoded]; written {
+		return entry.Value, true
+	}
+	entry, exists := h.contract.storage[encoded]
+	return entry.Value, exists
+}
+
+// Store buffers a storage write
+func (h *contractHost) Store(key, value vm.Value) {
+	h.storage[string(key.Encode())] = StorageEntry{Key: key, Value: value}
+}
+
+// Balance returns an account's balance, including buffered transfers
+func (h *contractHost) Balance(address string) int64 {
+	if balance, touched := h.balances[address]; touched {
+		return int64(balance)
+	}
+	return int64(h.state.balances[address])
+}
+
+// Transfer buffers a payment from the contract to an account
+func (h *contractHost) Transfer(to string, amount int64) error {
+	from := h.contract.Address
+	if h.Balance(from) < amount {
+		return fmt.Errorf("%w: contract has %s, needs %s", ErrInsufficientBalance, Amount(h.Balance(from)), Amount(amount))
+	}
+	h.balances[from] = Amount(h.Balance(from) - amount)
+	credited, err := Amount(h.Balance(to)).Add(Amount(amount))
+	if err != nil {
+		return err
+	}
+	h.balances[to] = credited
+	return nil
+}
+
+// Send takes a call's value from the contract's balance and buffers the
+// call's message
+func (h *contractHost) Send(call vm.Call) (string, error) {
+	from := h.contract.Address
+	if h.Balance(from) < call.Value {
+		return "", fmt.Errorf("%w: contract has %s, needs %s", ErrInsufficientBalance, Amount(h.Balance(from)), Amount(call.Value))
+	}
+	h.balances[from] = Amount(h.Balance(from) - call.Value)
+
+	msg := &ContractMessage{
+		ID:          messageID(h.origin, len(h.messages)),
+		Kind:        MessageCall,
+		SourceShard: h.shardID,
+		TargetShard: call.Shard,
+		Sender:      from,
+		Contract:    call.Contract,
+		Method:      call.Method,
+		Args:        []vm.Value{call.Arg},
+		Value:       Amount(call.Value),
+		GasLimit:    call.Gas,
+		Callback:    call.Callback,
+		Origin:      h.origin,
+		Height:      h.height,
+	}
+	h.messages = append(h.messages, msg)
+	return msg.ID, nil
+}
+
+// commit applies the buffered writes and queues the buffered messages for
+// delivery. Storing integer zero deletes a key.
+func (h *contractHost) commit() {
+	for key, entry := range h.storage {
+		if entry.Value.Equal(vm.Int(0)) {
+			delete(h.contract.storage, key)
+			continue
+		}
+		h.contract.storage[key] = entry
+	}
+	for address, balance := range h.balances {
+		h.state.balances[address] = balance
+	}
+	for _, msg := range h.messages {
+		h.state.outbox[msg.ID] = msg
+		h.state.supply.TransferredOut += msg.Value
+	}
+}
@@ -0,0 +1,100 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Incoming credits
+//
+// A credit pays out value debited in another shard or layer. Transfer
+// credits name the debits they pay out in their data; contract message
+// deliveries carry the message sent. Neither is valid on its own word: a
+// chain only accepts a credit, in its pool or in a block, once its credit
+// verifier, set by the sharding layer, finds the debits final at their
+// source or carried by a finalized relay block. The state records every
+// debit paid out, so none is credited twice.
+
+// CreditSource names the debits an incoming transfer credit pays out
+type CreditSource struct {
+	SourceShard  int      `json:"source_shard"`
+	TxHashes     []string `json:"tx_hashes"`                // Transactions debited in the source shard
+	RelayBlockID string   `json:"relay_block_id,omitempty"` // Finalized relay block carrying them, if any
+}
+
+// CreditVerifier checks that the debits an incoming credit pays out
+// happened
+type CreditVerifier func(tx *Transaction) error
+
+// CreditSource returns the debits a transfer credit pays out
+func (tx *Transaction) CreditSource() (*CreditSource, error) {
+	if !tx.IsIncomingCredit() || tx.Type == ContractMessageTransaction {
+		return nil, fmt.Errorf("transaction %s is not a transfer credit", tx.Hash)
+	}
+	var source CreditSource
+	if err := json.Unmarshal(tx.Data, &source); err != nil {
+		return nil, fmt.Errorf("credit %s does not name its source: %w", tx.Hash, err)
+	}
+	if len(source.TxHashes) == 0 {
+		return nil, fmt.Errorf("credit %s does not name the debits it pays out", tx.Hash)
+	}
+	return &source, nil
+}
+
+// SetCreditVerifier sets how incoming credits are checked. Without one, the
+// chain refuses every credit.
+func (bc *Blockchain) SetCreditVerifier(verifier CreditVerifier) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.creditVerifier = verifier
+}
+
+// verifyCredits checks the incoming credits among transactions. The
+// verifier may read this chain, so it must be called without the lock.
+func (bc *Blockchain) verifyCredits(txs ...*Transaction) error {
+	bc.mu.RLock()
+	verifier := bc.creditVerifier
+	bc.mu.RUnlock()
+
+	for _, tx := range txs {
+		if !tx.IsIncomingCredit() {
+			continue
+		}
+		if verifier == nil {
+			return errors.New("incoming credits cannot be verified on this chain")
+		}
+		if err := verifier(tx); err != nil {
+			return fmt.Errorf("unverified credit %s: %w", tx.Hash, err)
+		}
+	}
+	return nil
+}
+
+// applyCredit credits a transfer paid out from another shard or layer,
+// once per debit. Must be called with the lock held.
+func (s *State) applyCredit(tx *Transaction) error {
+	source, err := tx.CreditSource()
+	if err != nil {
+		return err
+	}
+	paid := make(map[string]bool, len(source.TxHashes))
+	for _, hash := range source.TxHashes {
+		if credit, exists := s.credited[hash]; exists {
+			return fmt.Errorf("transfer %s already credited by %s", hash, credit)
+		}
+		if paid[hash] {
+			return fmt.Errorf("transfer %s paid out twice", hash)
+		}
+		paid[hash] = true
+	}
+
+	if err := s.credit(tx.To, tx.Amount); err != nil {
+		return err
+	}
+	for _, hash := range source.TxHashes {
+		s.credited[hash] = tx.Hash
+	}
+	s.supply.TransferredIn += tx.Amount
+	return nil
+}
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+// hashVector is a test vector of the canonical encoding: a transaction or
+// a block with its expected payload and hash
+type hashVector struct {
+	Name        string       `json:"name"`
+	Transaction *Transaction `json:"transaction,omitempty"`
+	Block       *Block       `json:"block,omitempty"`
+	Payload     string       `json:"payload"`
+	Hash        string       `json:"hash"`
+}
+
+func TestHashVectors(t *testing.T) {
+	data, err := os.ReadFile("testdata/hash_vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors []hashVector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) == 0 {
+		t.Fatal("no vectors in testdata/hash_vectors.json")
+	}
+
+	for _, v := range vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			var payload []byte
+			var hash string
+			var err error
+			switch {
+			case v.Transaction != nil:
+				payload = v.Transaction.SigningPayload()
+				hash, err = v.Transaction.CalculateHash()
+			case v.Block != nil:
+				payload = v.Block.HeaderPayload()
+				hash, err = v.Block.Hash()
+			default:
+				t.Fatal("vector has neither a transaction nor a block")
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(payload); got != v.Payload {
+				t.Errorf("payload = %s, want %s", got, v.Payload)
+			}
+			if hash != v.Hash {
+				t.Errorf("hash = %s, want %s", hash, v.Hash)
+			}
+		})
+	}
+}
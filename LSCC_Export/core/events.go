@@ -0,0 +1,261 @@
+package core
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Events
+//
+// The chains, the sharding layer and the network publish what happens to
+// them on an event bus shared by a node's subsystems. Subscribers receive the
+// events of the types they asked for on a bounded queue; publishing never
+// blocks, so an event for a subscriber whose queue is full is dropped and
+// counted, per subscriber and per event type.
+
+// EventType names a kind of event
+type EventType string
+
+const (
+	// EventBlockAdded is published when a block extends a chain
+	EventBlockAdded EventType = "block_added"
+	// EventBlockReorged is published when a branch replaces blocks of a chain
+	EventBlockReorged EventType = "block_reorged"
+	// EventTxAccepted is published when a transaction enters a pool or, for
+	// cross-shard transactions, the sharding layer
+	EventTxAccepted EventType = "tx_accepted"
+	// EventRelayBlockFinalized is published when relays finalize a relay block
+	EventRelayBlockFinalized EventType = "relay_block_finalized"
+	// EventPeerConnected is published when a peer completes its handshake
+	EventPeerConnected EventType = "peer_connected"
+	// EventShardRebalanced is published when nodes are reassigned to shards
+	EventShardRebalanced EventType = "shard_rebalanced"
+)
+
+// Event is something that happened in a node. The payload field matching
+// the type is set; ShardID is -1 for events not tied to one shard.
+type Event struct {
+	Type        EventType    `json:"type"`
+	ShardID     int          `json:"shard_id"`
+	Time        int64        `json:"time"`
+	Block       *Block       `json:"block,omitempty"`
+	Transaction *Transaction `json:"transaction,omitempty"`
+	RelayBlock  *RelayBlock  `json:"relay_block,omitempty"`
+	Reorg       *Reorg       `json:"reorg,omitempty"`
+	Peer        *PeerInfo    `json:"peer,omitempty"`
+	Rebalance   *Rebalance   `json:"rebalance,omitempty"`
+}
+
+// Reorg describes a reorganization: the blocks from ForkHeight onwards were
+// replaced by a longer branch
+type Reorg struct {
+	ForkHeight uint64   `json:"fork_height"`
+	Removed    []*Block `json:"removed"`
+	Added      []*Block `json:"added"`
+}
+
+// PeerInfo identifies a connected peer
+type PeerInfo struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	ShardID int    `json:"shard_id"`
+	IsRelay bool   `json:"is_relay"`
+}
+
+// Rebalance gives the shard of every node after a rebalancing
+type Rebalance struct {
+	Assignments map[string]int `json:"assignments"`
+}
+
+// EventBus delivers published events to subscribers
+type EventBus struct {
+	subscribers map[uint64]*Subscription
+	nextID      uint64
+	published   map[EventType]uint64
+	dropped     map[EventType]uint64
+	mu          sync.RWMutex
+	statsMu     sync.Mutex
+}
+
+// Subscription receives the events of some types from a bus
+type Subscription struct {
+	id      uint64
+	name    string
+	bus     *EventBus
+	types   map[EventType]bool
+	events  chan Event
+	dropped uint64
+	closed  bool
+}
+
+// EventBusStats counts the events published on a bus and those dropped
+// because a subscriber's queue was full
+type EventBusStats struct {
+	Published   map[EventType]uint64 `json:"published"`
+	Dropped     map[EventType]uint64 `json:"dropped"`
+	Subscribers []SubscriberStats    `json:"subscribers"`
+}
+
+// SubscriberStats describes the queue of a subscriber
+type SubscriberStats struct {
+	Name     string      `json:"name"`
+	Types    []EventType `json:"types,omitempty"`
+	Queued   int         `json:"queued"`
+	Capacity int         `json:"capacity"`
+	Dropped  uint64      `json:"dropped"`
+}
+
+// NewEventBus creates an event bus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[uint64]*Subscription),
+		published:   make(map[EventType]uint64),
+		dropped:     make(map[EventType]uint64),
+	}
+}
+
+// Subscribe creates a subscription holding up to queueSize undelivered
+// events of the given types, or of every type if none are given. The name
+// identifies the subscriber in the bus statistics.
+func (b *EventBus) Subscribe(name string, queueSize int, types ...EventType) *Subscription {
+	if queueSize < 1 {
+		queueSize = 1
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.nextID++
+	sub := &Subscription{
+		id:     b.nextID,
+		name:   name,
+		bus:    b,
+		types:  make(map[EventType]bool),
+		events: make(chan Event, queueSize),
+	}
+	for _, eventType := range types {
+		sub.types[eventType] = true
+	}
+	b.subscribers[sub.id] = sub
+	return sub
+}
+
+// Publish delivers an event to its subscribers without blocking. A nil bus
+// discards events.
+func (b *EventBus) Publish(event Event) {
+	if b == nil {
+		return
+	}
+	if event.Time == 0 {
+		event.Time = time.Now().Unix()
+	}
+
+	b.mu.RLock()
+	var dropped uint64
+	for _, sub := range b.subscribers {
+		if len(sub.types) > 0 && !sub.types[event.Type] {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+			dropped++
+		}
+	}
+	b.mu.RUnlock()
+
+	b.statsMu.Lock()
+	b.published[event.Type]++
+	b.dropped[event.Type] += dropped
+	b.statsMu.Unlock()
+}
+
+// Stats returns the bus counters and the state of every subscriber queue,
+// ordered by subscription
+func (b *EventBus) Stats() EventBusStats {
+	stats := EventBusStats{
+		Published:   make(map[EventType]uint64),
+		Dropped:     make(map[EventType]uint64),
+		Subscribers: []SubscriberStats{},
+	}
+
+	b.statsMu.Lock()
+	for eventType, count := range b.published {
+		stats.Published[eventType] = count
+	}
+	for eventType, count := range b.dropped {
+		stats.Dropped[eventType] = count
+	}
+	b.statsMu.Unlock()
+
+	b.mu.RLock()
+	subs := make([]*Subscription, 0, len(b.subscribers))
+	for _, sub := range b.subscribers {
+		subs = append(subs, sub)
+	}
+	b.mu.RUnlock()
+
+	sort.Slice(subs, func(i, j int) bool { return subs[i].id < subs[j].id })
+	for _, sub := range subs {
+		types := make([]EventType, 0, len(sub.types))
+		for eventType := range sub.types {
+			types = append(types, eventType)
+		}
+		sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+		stats.Subscribers = append(stats.Subscribers, SubscriberStats{
+			Name:     sub.name,
+			Types:    types,
+			Queued:   len(sub.events),
+			Capacity: cap(sub.events),
+			Dropped:  sub.Dropped(),
+		})
+	}
+	return stats
+}
+
+// Events returns the channel events are delivered on. It is closed when the
+// subscription ends.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns how many events were dropped because the queue was full
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Unsubscribe ends the subscription and closes its channel
+func (s *Subscription) Unsubscribe() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(s.bus.subscribers, s.id)
+	close(s.events)
+}
+
+// SetEventBus sets the bus the chain publishes its events on
+func (bc *Blockchain) SetEventBus(bus *EventBus) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.events = bus
+}
+
+// Events returns the bus the chain publishes its events on, nil if none
+func (bc *Blockchain) Events() *EventBus {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return bc.events
+}
+
+// publish publishes an event of the chain's shard
+func (bc *Blockchain) publish(event Event) {
+	event.ShardID = bc.Config.ShardID
+	bc.Events().Publish(event)
+}
@@ -0,0 +1,113 @@
+package core
+
+import "testing"
+
+// receive returns the events queued on a subscription
+func receive(sub *Subscription) []Event {
+	var events []Event
+	for {
+		select {
+		case event := <-sub.Events():
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestEventBusDeliversSubscribedTypes(t *testing.T) {
+	bus := NewEventBus()
+	blocks := bus.Subscribe("blocks", 4, EventBlockAdded)
+	all := bus.Subscribe("all", 4)
+
+	bus.Publish(Event{Type: EventBlockAdded, ShardID: 0})
+	bus.Publish(Event{Type: EventTxAccepted, ShardID: 1, Time: 7})
+
+	if events := receive(blocks); len(events) != 1 || events[0].Type != EventBlockAdded || events[0].Time == 0 {
+		t.Errorf("blocks subscriber received %+v, want one timestamped block event", events)
+	}
+	events := receive(all)
+	if len(events) != 2 || events[1].Type != EventTxAccepted || events[1].Time != 7 {
+		t.Errorf("subscriber to every type received %+v", events)
+	}
+
+	// A nil bus discards events
+	var nilBus *EventBus
+	nilBus.Publish(Event{Type: EventBlockAdded})
+}
+
+func TestEventBusDropsForFullQueues(t *testing.T) {
+	bus := NewEventBus()
+	slow := bus.Subscribe("slow", 1, EventTxAccepted)
+	fast := bus.Subscribe("fast", 8, EventTxAccepted)
+
+	for i := 0; i < 3; i++ {
+		bus.Publish(Event{Type: EventTxAccepted})
+	}
+	bus.Publish(Event{Type: EventBlockAdded})
+
+	if events := receive(fast); len(events) != 3 {
+		t.Errorf("fast subscriber received %d events, want 3", len(events))
+	}
+	if slow.Dropped() != 2 {
+		t.Errorf("slow subscriber dropped %d events, want 2", slow.Dropped())
+	}
+
+	stats := bus.Stats()
+	if stats.Published[EventTxAccepted] != 3 || stats.Published[EventBlockAdded] != 1 {
+		t.Errorf("published %v", stats.Published)
+	}
+	if stats.Dropped[EventTxAccepted] != 2 || stats.Dropped[EventBlockAdded] != 0 {
+		t.Errorf("dropped %v", stats.Dropped)
+	}
+	if len(stats.Subscribers) != 2 {
+		t.Fatalf("%d subscribers, want 2", len(stats.Subscribers))
+	}
+	got := stats.Subscribers[0]
+	if got.Name != "slow" || got.Queued != 1 || got.Capacity != 1 || got.Dropped != 2 ||
+		len(got.Types) != 1 || got.Types[0] != EventTxAccepted {
+		t.Errorf("slow subscriber stats = %+v", got)
+	}
+}
+
+func TestUnsubscribeClosesEvents(t *testing.T) {
+	bus := NewEventBus()
+	sub := bus.Subscribe("sub", 0)
+	if cap(sub.events) != 1 {
+		t.Errorf("queue of size 0 holds %d events, want 1", cap(sub.events))
+	}
+
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+	if _, open := <-sub.Events(); open {
+		t.Error("events channel open after unsubscribing")
+	}
+	bus.Publish(Event{Type: EventBlockAdded})
+	if stats := bus.Stats(); len(stats.Subscribers) != 0 {
+		t.Errorf("%d subscribers after unsubscribing", len(stats.Subscribers))
+	}
+}
+
+func TestChainPublishesEvents(t *testing.T) {
+	bc := receiptChain(t)
+	bus := NewEventBus()
+	bc.SetEventBus(bus)
+	sub := bus.Subscribe("chain", 8)
+
+	tx := payment(t, "alice", "bob", 3)
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	block := mineBlock(t, bc)
+
+	events := receive(sub)
+	if len(events) != 2 {
+		t.Fatalf("chain published %d events, want 2", len(events))
+	}
+	if events[0].Type != EventTxAccepted || events[0].Transaction.Hash != tx.Hash || events[0].ShardID != 0 {
+		t.Errorf("first event = %+v, want acceptance of %s", events[0], tx.Hash)
+	}
+	if events[1].Type != EventBlockAdded || events[1].Block != block {
+		t.Errorf("second event = %+v, want the added block", events[1])
+	}
+}
@@ -0,0 +1,427 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"lscc/utils"
+)
+
+// Slashing
+//
+// Misbehaviour is proven on-chain by an evidence transaction. Two kinds of
+// evidence are accepted:
+//   - Equivocation: two different headers for the same shard and height,
+//     both signed by the same validator.
+//   - Invalid relay vote: a relay's signed vote for a relay block whose
+//     committed contents break the relay block rules.
+//
+// Verified evidence records a Slashing for the offender in the state and
+// burns part of the stake bonded to it. The consensus engine and the sharding
+// layer react to it by removing the offender from the validator and relay
+// sets.
+
+// EvidenceType identifies the kind of misbehaviour proven by evidence
+type EvidenceType string
+
+const (
+	// EvidenceEquivocation proves a validator signed two headers at one height
+	EvidenceEquivocation EvidenceType = "equivocation"
+	// EvidenceInvalidRelayVote proves a relay approved an invalid relay block
+	EvidenceInvalidRelayVote EvidenceType = "invalid_relay_vote"
+)
+
+// SignedHeader is a block header with the signatures of its validator
+type SignedHeader struct {
+	Header       BlockHeader `json:"header"`
+	ShardID      int         `json:"shard_id"`
+	Signature    string      `json:"signature"`
+	KeySignature string      `json:"key_signature,omitempty"`
+}
+
+// Evidence proves misbehaviour by a validator or relay
+type Evidence struct {
+	Type       EvidenceType  `json:"type"`
+	Offender   string        `json:"offender"`
+	HeaderA    *SignedHeader `json:"header_a,omitempty"`
+	HeaderB    *SignedHeader `json:"header_b,omitempty"`
+	Vote       *RelayVote    `json:"vote,omitempty"`
+	RelayBlock *RelayBlock   `json:"relay_block,omitempty"`
+}
+
+// Slashing is the on-chain record of a punished offender
+type Slashing struct {
+	Offender   string       `json:"offender"`
+	Type       EvidenceType `json:"type"`
+	EvidenceID string       `json:"evidence_id"`
+	Reporter   string       `json:"reporter"`
+	Height     uint64       `json:"height"`
+	Burned     Amount       `json:"burned"` // Stake burned from the offender's bonds
+}
+
+// NewSignedHeader captures the signed header of a block
+func NewSignedHeader(block *Block) *SignedHeader {
+	return &SignedHeader{
+		Header:       block.Header,
+		ShardID:      block.ShardID,
+		Signature:    block.Signature,
+		KeySignature: block.KeySignature,
+	}
+}
+
+// payload returns the canonical encoding of the header and its shard, as
+// Block.HeaderPayload does for the block it was taken from
+func (sh *SignedHeader) payload() []byte {
+	block := Block{Header: sh.Header, ShardID: sh.ShardID}
+	return block.HeaderPayload()
+}
+
+// hash returns the hash of the header, matching Block.Hash
+func (sh *SignedHeader) hash() (string, error) {
+	return hashPayload(sh.payload()), nil
+}
+
+// verify checks that the signature was made over this header by its
+// validator
+func (sh *SignedHeader) verify() error {
+	payload := sh.payload()
+	// Block.Sign produces "signed:<key prefix>:<header hash>"
+	if sh.Signature != fmt.Sprintf("signed:%s:%s", keyPrefix(sh.Header.ValidatorID), hashPayload(payload)) ||
+		!utils.VerifySignature(payload, sh.Signature, sh.Header.ValidatorID) {
+		return errors.New("header signature does not match the header and its validator")
+	}
+	return nil
+}
+
+// signedWith checks that the header was signed with a registered key
+func (sh *SignedHeader) signedWith(publicKey string) bool {
+	return utils.VerifyKeySignature(sh.payload(), sh.KeySignature, publicKey)
+}
+
+// NewEquivocationEvidence creates evidence from two conflicting blocks
+func NewEquivocationEvidence(a, b *Block) *Evidence {
+	return &Evidence{
+		Type:     EvidenceEquivocation,
+		Offender: a.Header.ValidatorID,
+		HeaderA:  NewSignedHeader(a),
+		HeaderB:  NewSignedHeader(b),
+	}
+}
+
+// NewInvalidRelayVoteEvidence creates evidence from a vote for an invalid
+// relay block
+func NewInvalidRelayVoteEvidence(vote *RelayVote, rb *RelayBlock) *Evidence {
+	// Votes are not part of the proof
+	blockCopy := *rb
+	blockCopy.Votes = nil
+	return &Evidence{
+		Type:       EvidenceInvalidRelayVote,
+		Offender:   vote.Relay,
+		Vote:       vote,
+		RelayBlock: &blockCopy,
+	}
+}
+
+// ID returns a hash identifying the evidence
+func (e *Evidence) ID() (string, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// Verify checks that the evidence proves misbehaviour by its offender, as
+// far as it can without the offender's key: the signatures it checks can be
+// produced by anyone who knows the offender's name. VerifyKey completes it.
+func (e *Evidence) Verify() error {
+	switch e.Type {
+	case EvidenceEquivocation:
+		if e.HeaderA == nil || e.HeaderB == nil {
+			return errors.New("equivocation evidence needs two headers")
+		}
+		a, b := e.HeaderA, e.HeaderB
+		if a.Header.ValidatorID != b.Header.ValidatorID {
+			return errors.New("headers name different validators")
+		}
+		if a.Header.ValidatorID != e.Offender {
+			return errors.New("headers were not produced by the offender")
+		}
+		if a.Header.ChainID != b.Header.ChainID || a.ShardID != b.ShardID || a.Header.Height != b.Header.Height {
+			return errors.New("headers are not for the same chain, shard and height")
+		}
+		hashA, err := a.hash()
+		if err != nil {
+			return err
+		}
+		hashB, err := b.hash()
+		if err != nil {
+			return err
+		}
+		if hashA == hashB {
+			return errors.New("headers are identical")
+		}
+		if err := a.verify(); err != nil {
+			return err
+		}
+		return b.verify()
+
+	case EvidenceInvalidRelayVote:
+		if e.Vote == nil || e.RelayBlock == nil {
+			return errors.New("relay vote evidence needs a vote and a relay block")
+		}
+		if e.Vote.Relay != e.Offender {
+			return errors.New("vote was not cast by the offender")
+		}
+		if err := e.Vote.Verify(); err != nil {
+			return err
+		}
+		if e.Vote.RelayBlockID != e.RelayBlock.ID {
+			return errors.New("vote is for a different relay block")
+		}
+		return e.RelayBlock.provesInvalid(e.Vote.RelayBlockHash)
+	}
+
+	return fmt.Errorf("unknown evidence type %q", e.Type)
+}
+
+// VerifyKey checks that the signatures proving the misbehaviour were made
+// with the offender's registered key
+func (e *Evidence) VerifyKey(publicKey string) error {
+	switch e.Type {
+	case EvidenceEquivocation:
+		if e.HeaderA == nil || e.HeaderB == nil {
+			return errors.New("equivocation evidence needs two headers")
+		}
+		if !e.HeaderA.signedWith(publicKey) || !e.HeaderB.signedWith(publicKey) {
+			return errors.New("headers are not signed with the offender's registered key")
+		}
+		return nil
+
+	case EvidenceInvalidRelayVote:
+		if e.Vote == nil {
+			return errors.New("relay vote evidence needs a vote")
+		}
+		if !utils.VerifyKeySignature(e.Vote.SigningPayload(), e.Vote.KeySignature, publicKey) {
+			return errors.New("vote is not signed with the offender's registered key")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown evidence type %q", e.Type)
+}
+
+// NewEvidenceTransaction creates a transaction submitting evidence against
+// an offender
+func NewEvidenceTransaction(reporter string, evidence *Evidence, fee Amount, shardID int) (*Transaction, error) {
+	return newPayloadTransaction(reporter, evidence.Offender, 0, fee, shardID, EvidenceTransaction, evidence)
+}
+
+// IsSlashed reports whether an account has been slashed
+func (s *State) IsSlashed(address string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, slashed := s.slashings[address]
+	return slashed
+}
+
+// GetSlashing returns a copy of an offender's slashing record
+func (s *State) GetSlashing(offender string) (*Slashing, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	slashing, exists := s.slashings[offender]
+	if !exists {
+		return nil, false
+	}
+	slashingCopy := *slashing
+	return &slashingCopy, true
+}
+
+// GetSlashings returns copies of all slashing records
+func (s *State) GetSlashings() []*Slashing {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	slashings := make([]*Slashing, 0, len(s.slashings))
+	for _, slashing := range s.slashings {
+		slashingCopy := *slashing
+		slashings = append(slashings, &slashingCopy)
+	}
+	return slashings
+}
+
+// RegisterKey registers the public key an account's signatures are checked
+// against when evidence accuses it. A registered key cannot be replaced, so
+// an offender cannot escape evidence signed with its old key.
+func (s *State) RegisterKey(address, publicKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !utils.ValidPublicKey(publicKey) {
+		return fmt.Errorf("invalid public key for %s", address)
+	}
+	if registered, exists := s.keys[address]; exists && registered != publicKey {
+		return fmt.Errorf("%s already has a registered key", address)
+	}
+	s.keys[address] = publicKey
+	return nil
+}
+
+// GetKey returns an account's registered public key
+func (s *State) GetKey(address string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	publicKey, exists := s.keys[address]
+	return publicKey, exists
+}
+
+// VerifyEvidence checks that evidence proves misbehaviour by its offender
+// with signatures made by the offender's registered key
+func (s *State) VerifyEvidence(evidence *Evidence) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.verifyEvidence(evidence)
+}
+
+// verifyEvidence is VerifyEvidence with the lock held
+func (s *State) verifyEvidence(evidence *Evidence) error {
+	if err := evidence.Verify(); err != nil {
+		return err
+	}
+	publicKey, registered := s.keys[evidence.Offender]
+	if !registered {
+		return fmt.Errorf("%s has no registered key to check the evidence against", evidence.Offender)
+	}
+	return evidence.VerifyKey(publicKey)
+}
+
+// applyEvidenceTransaction verifies evidence and records the slashing. Must
+// be called with the lock held.
+func (s *State) applyEvidenceTransaction(tx *Transaction, height uint64) error {
+	var evidence Evidence
+	if err := json.Unmarshal(tx.Data, &evidence); err != nil {
+		return fmt.Errorf("invalid evidence: %w", err)
+	}
+	if tx.To != evidence.Offender {
+		return errors.New("evidence transaction must name the offender as recipient")
+	}
+	if _, slashed := s.slashings[evidence.Offender]; slashed {
+		return fmt.Errorf("%s has already been slashed", evidence.Offender)
+	}
+	if err := s.verifyEvidence(&evidence); err != nil {
+		return fmt.Errorf("invalid evidence: %w", err)
+	}
+	id, err := evidence.ID()
+	if err != nil {
+		return err
+	}
+	if err := s.debit(tx.From, tx.Fee); err != nil {
+		return err
+	}
+
+	s.slashings[evidence.Offender] = &Slashing{
+		Offender:   evidence.Offender,
+		Type:       evidence.Type,
+		EvidenceID: id,
+		Reporter:   tx.From,
+		Height:     height,
+		Burned:     s.slashStake(evidence.Offender),
+	}
+	s.supply.Slashed += s.slashings[evidence.Offender].Burned
+	return nil
+}
+
+// slashingsIn returns the slashings recorded by a block's evidence
+// transactions
+func slashingsIn(block *Block) []*Slashing {
+	var slashings []*Slashing
+	for _, tx := range block.Transactions {
+		if tx.Type != EvidenceTransaction {
+			continue
+		}
+		var evidence Evidence
+		if err := json.Unmarshal(tx.Data, &evidence); err != nil {
+			continue
+		}
+		id, err := evidence.ID()
+		if err != nil {
+			continue
+		}
+		slashings = append(slashings, &Slashing{
+			Offender:   evidence.Offender,
+			Type:       evidence.Type,
+			EvidenceID: id,
+			Reporter:   tx.From,
+			Height:     block.Header.Height,
+		})
+	}
+	return slashings
+}
+
+// OnSlash registers a function called with every slashing recorded by a
+// block added to the chain
+func (bc *Blockchain) OnSlash(handler func(*Slashing)) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	bc.slashHandlers = append(bc.slashHandlers, handler)
+}
+
+// notifySlashings passes slashings to the registered handlers
+func (bc *Blockchain) notifySlashings(slashings []*Slashing) {
+	if len(slashings) == 0 {
+		return
+	}
+
+	bc.mu.RLock()
+	handlers := append([]func(*Slashing){}, bc.slashHandlers...)
+	bc.mu.RUnlock()
+
+	for _, slashing := range slashings {
+		bc.logger.Warn("Offender slashed",
+			"offender", slashing.Offender,
+			"type", slashing.Type,
+			"reporter", slashing.Reporter,
+			"height", slashing.Height)
+		for _, handler := range handlers {
+			handler(slashing)
+		}
+	}
+}
+
+// DetectEquivocation checks whether a block conflicts with the block the
+// same validator produced at that height on this chain
+func (bc *Blockchain) DetectEquivocation(block *Block) (*Evidence, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	height := block.Header.Height
+	if height == 0 || height >= uint64(len(bc.Blocks)) {
+		return nil, false
+	}
+	existing := bc.Blocks[height]
+	if existing.Header.ValidatorID != block.Header.ValidatorID || existing.ShardID != block.ShardID {
+		return nil, false
+	}
+
+	existingHash, err := existing.Hash()
+	if err != nil {
+		return nil, false
+	}
+	blockHash, err := block.Hash()
+	if err != nil || blockHash == existingHash {
+		return nil, false
+	}
+
+	evidence := NewEquivocationEvidence(existing, block)
+	if bc.State.VerifyEvidence(evidence) != nil {
+		return nil, false
+	}
+	return evidence, true
+}
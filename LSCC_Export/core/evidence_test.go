@@ -0,0 +1,135 @@
+package core
+
+import (
+	"testing"
+
+	"lscc/utils"
+)
+
+// signedBlock returns a block of shard 3 at height 5 signed with key
+func signedBlock(t *testing.T, validator, key string, timestamp int64) *Block {
+	t.Helper()
+	block := NewBlock("prev", 5, 3, 0, validator)
+	block.Header.Timestamp = timestamp
+	if err := block.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestEquivocationEvidence(t *testing.T) {
+	a := signedBlock(t, "node1", "node1", 100)
+	b := signedBlock(t, "node1", "node1", 101)
+	if err := NewEquivocationEvidence(a, b).Verify(); err != nil {
+		t.Fatalf("valid evidence rejected: %v", err)
+	}
+
+	forged := signedBlock(t, "node1", "node2", 101)
+	if err := NewEquivocationEvidence(a, forged).Verify(); err == nil {
+		t.Error("evidence with a header signed by another key accepted")
+	}
+
+	unsigned := signedBlock(t, "node1", "node1", 101)
+	unsigned.Signature = ""
+	if err := NewEquivocationEvidence(a, unsigned).Verify(); err == nil {
+		t.Error("evidence with an unsigned header accepted")
+	}
+
+	other := signedBlock(t, "node2", "node2", 101)
+	if err := NewEquivocationEvidence(a, other).Verify(); err == nil {
+		t.Error("evidence with headers of different validators accepted")
+	}
+
+	higher := NewBlock("prev", 6, 3, 0, "node1")
+	if err := higher.Sign("node1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewEquivocationEvidence(a, higher).Verify(); err == nil {
+		t.Error("evidence with headers at different heights accepted")
+	}
+
+	// The signature covers the shard, so it cannot be moved to another
+	moved := NewEquivocationEvidence(a, b)
+	moved.HeaderA.ShardID, moved.HeaderB.ShardID = 4, 4
+	if err := moved.Verify(); err == nil {
+		t.Error("evidence with headers moved to another shard accepted")
+	}
+}
+
+// keyedBlock returns signedBlock also signed with an ed25519 key
+func keyedBlock(t *testing.T, validator, privateKey string, timestamp int64) *Block {
+	t.Helper()
+	block := signedBlock(t, validator, validator, timestamp)
+	if err := block.SignWithKey(privateKey); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// applyEvidence submits evidence to a state in a transaction from a reporter
+func applyEvidence(t *testing.T, state *State, evidence *Evidence) error {
+	t.Helper()
+	tx, err := NewEvidenceTransaction("reporter", evidence, 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state.ApplyTransaction(tx, 6)
+}
+
+func TestEvidenceNeedsRegisteredKey(t *testing.T) {
+	validatorKey, validatorPub, err := utils.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	thirdPartyKey, _, err := utils.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := NewState(StateParams{SlashFraction: 0.5})
+	if err := state.RegisterKey("node1", validatorPub); err != nil {
+		t.Fatal(err)
+	}
+
+	// Anyone can produce headers that pass Verify for a validator they
+	// name, with or without a key of their own
+	a := signedBlock(t, "node1", "node1", 100)
+	b := signedBlock(t, "node1", "node1", 101)
+	thirdParty := NewEquivocationEvidence(a, b)
+	if err := thirdParty.Verify(); err != nil {
+		t.Fatalf("third party evidence does not even pass Verify: %v", err)
+	}
+	if err := applyEvidence(t, state, thirdParty); err == nil {
+		t.Error("evidence without key signatures slashed node1")
+	}
+	forged := NewEquivocationEvidence(keyedBlock(t, "node1", thirdPartyKey, 100), keyedBlock(t, "node1", thirdPartyKey, 101))
+	if err := applyEvidence(t, state, forged); err == nil {
+		t.Error("evidence signed with a third party's key slashed node1")
+	}
+	if state.IsSlashed("node1") {
+		t.Fatal("node1 slashed on third party evidence")
+	}
+
+	// Without a registered key nothing can be checked
+	unregistered := NewEquivocationEvidence(keyedBlock(t, "node2", thirdPartyKey, 100), keyedBlock(t, "node2", thirdPartyKey, 101))
+	if err := applyEvidence(t, state, unregistered); err == nil {
+		t.Error("evidence against a validator without a registered key accepted")
+	}
+
+	// Headers signed with the validator's own key prove equivocation
+	proof := NewEquivocationEvidence(keyedBlock(t, "node1", validatorKey, 100), keyedBlock(t, "node1", validatorKey, 101))
+	if err := applyEvidence(t, state, proof); err != nil {
+		t.Fatalf("evidence signed with the registered key refused: %v", err)
+	}
+	if !state.IsSlashed("node1") {
+		t.Fatal("node1 not slashed on valid evidence")
+	}
+
+	// A registered key cannot be swapped for another
+	_, otherPub, err := utils.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.RegisterKey("node1", otherPub); err == nil {
+		t.Error("registered key replaced")
+	}
+}
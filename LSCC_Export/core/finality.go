@@ -0,0 +1,314 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Finality
+//
+// A block is final once it is buried under MinConfirmations blocks
+// (including itself), or once a BFT engine finalizes it, or any later block,
+// as a checkpoint. Final blocks can never be replaced by a reorganization,
+// and their transactions are marked confirmed.
+
+// ErrFinalizedReorg is returned when a block or branch would replace a
+// finalized block
+var ErrFinalizedReorg = errors.New("cannot reorganize past a finalized block")
+
+// FinalityTracker tracks the confirmation depth of a chain and the highest
+// BFT-finalized checkpoint
+type FinalityTracker struct {
+	minConfirmations uint64
+	tip              uint64
+	checkpoint       uint64
+	checkpointHash   string
+	mu               sync.RWMutex
+}
+
+// NewFinalityTracker creates a tracker finalizing blocks at the given depth
+func NewFinalityTracker(minConfirmations int) *FinalityTracker {
+	if minConfirmations < 1 {
+		minConfirmations = 1
+	}
+	return &FinalityTracker{minConfirmations: uint64(minConfirmations)}
+}
+
+// Confirmations returns how many blocks, including itself, confirm a block
+// at the given height
+func (ft *FinalityTracker) Confirmations(height uint64) uint64 {
+	ft.mu.RLock()
+	defer ft.mu.RUnlock()
+
+	if height > ft.tip {
+		return 0
+	}
+	return ft.tip - height + 1
+}
+
+// FinalizedHeight returns the height of the highest final block
+func (ft *FinalityTracker) FinalizedHeight() uint64 {
+	ft.mu.RLock()
+	defer ft.mu.RUnlock()
+	return ft.finalizedHeight()
+}
+
+// finalizedHeight returns the highest final height. Must be called with the
+// lock held.
+func (ft *FinalityTracker) finalizedHeight() uint64 {
+	finalized := ft.checkpoint
+	if ft.tip+1 >= ft.minConfirmations && ft.tip+1-ft.minConfirmations > finalized {
+		finalized = ft.tip + 1 - ft.minConfirmations
+	}
+	return finalized
+}
+
+// IsFinalized reports whether the block at the given height is final
+func (ft *FinalityTracker) IsFinalized(height uint64) bool {
+	return height <= ft.FinalizedHeight()
+}
+
+// setTip records a new chain tip and returns the finalized height before
+// and after it
+func (ft *FinalityTracker) setTip(tip uint64) (uint64, uint64) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	before := ft.finalizedHeight()
+	ft.tip = tip
+	return before, ft.finalizedHeight()
+}
+
+// addCheckpoint records a BFT-finalized block and returns the finalized
+// height before and after it
+func (ft *FinalityTracker) addCheckpoint(height uint64, hash string) (uint64, uint64) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	before := ft.finalizedHeight()
+	if height > ft.checkpoint {
+		ft.checkpoint = height
+		ft.checkpointHash = hash
+	}
+	return before, ft.finalizedHeight()
+}
+
+// GetStatus returns the status of the finality tracker
+func (ft *FinalityTracker) GetStatus() map[string]interface{} {
+	ft.mu.RLock()
+	defer ft.mu.RUnlock()
+
+	return map[string]interface{}{
+		"min_confirmations": ft.minConfirmations,
+		"finalized_height":  ft.finalizedHeight(),
+		"checkpoint_height": ft.checkpoint,
+		"checkpoint_hash":   ft.checkpointHash,
+	}
+}
+
+// TransactionStatus reports where a transaction is and how final it is
+type TransactionStatus struct {
+	Transaction   *Transaction `json:"transaction"`
+	Status        TxStatus     `json:"status"` // pending, included, confirmed or failed
+	BlockHeight   uint64       `json:"block_height,omitempty"`
+	Index         int          `json:"index"` // Position in the block, -1 outside of one
+	Confirmations uint64       `json:"confirmations"`
+	Finalized     bool         `json:"finalized"`
+}
+
+// GetTransactionStatus returns the inclusion and finality status of a
+// transaction known to the chain
+func (bc *Blockchain) GetTransactionStatus(hash string) (*TransactionStatus, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	if dropped, exists := bc.dropped[hash]; exists {
+		return &TransactionStatus{Transaction: dropped.tx, Status: TxFailed, Index: -1}, true
+	}
+	tx, exists := bc.Transactions[hash]
+	if !exists {
+		return nil, false
+	}
+
+	status := &TransactionStatus{Transaction: tx, Status: TxPending, Index: -1}
+	if location, included := bc.included[hash]; included {
+		status.Status = TxIncluded
+		status.BlockHeight = location.Height
+		status.Index = location.Index
+		status.Confirmations = bc.Finality.Confirmations(location.Height)
+		status.Finalized = bc.Finality.IsFinalized(location.Height)
+		if status.Finalized {
+			status.Status = TxConfirmed
+		}
+	}
+	return status, true
+}
+
+// FinalizeCheckpoint records a block finalized by a BFT engine, making it
+// and every block below it final
+func (bc *Blockchain) FinalizeCheckpoint(height uint64, hash string) error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if height >= uint64(len(bc.Blocks)) {
+		return fmt.Errorf("no block at height %d", height)
+	}
+	blockHash, err := bc.Blocks[height].Hash()
+	if err != nil {
+		return err
+	}
+	if blockHash != hash {
+		return fmt.Errorf("checkpoint %s does not match block %s at height %d", hash, blockHash, height)
+	}
+
+	before, after := bc.Finality.addCheckpoint(height, hash)
+	bc.markFinalized(before, after)
+	return nil
+}
+
+// markFinalized marks the transactions of newly final blocks as confirmed.
+// Must be called with the lock held.
+func (bc *Blockchain) markFinalized(before, after uint64) {
+	for height := before + 1; height <= after && height < uint64(len(bc.Blocks)); height++ {
+		block := bc.Blocks[height]
+		for i := range block.Transactions {
+			block.Transactions[i].Confirm()
+		}
+	}
+}
+
+// Outranks reports whether a branch ending in tip should replace the chain:
+// it is longer, or as long with a lower tip hash. The tie-break lets nodes
+// that mined equally long branches at the same time settle on one of them.
+func (bc *Blockchain) Outranks(tip *Block) bool {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+	return outranks(tip, bc.Blocks[len(bc.Blocks)-1])
+}
+
+// outranks reports whether a branch ending in tip outranks a chain ending
+// in current
+func outranks(tip, current *Block) bool {
+	if tip.Header.Height != current.Header.Height {
+		return tip.Header.Height > current.Header.Height
+	}
+	tipHash, err := tip.Hash()
+	if err != nil {
+		return false
+	}
+	currentHash, err := current.Hash()
+	if err != nil {
+		return false
+	}
+	return tipHash < currentHash
+}
+
+// Reorganize replaces the blocks from the branch's first height onwards with
+// the branch, which must connect to the chain, outrank it and leave every
+// finalized block in place. Incoming credits of the branch are checked as
+// AddBlock checks them, before any state is touched. The state is rebuilt
+// from genesis; transactions of replaced blocks return to the pool.
+func (bc *Blockchain) Reorganize(branch []*Block) error {
+	for _, block := range branch {
+		txs := make([]*Transaction, len(block.Transactions))
+		for i := range block.Transactions {
+			txs[i] = &block.Transactions[i]
+		}
+		if err := bc.verifyCredits(txs...); err != nil {
+			return fmt.Errorf("block at height %d in branch: %w", block.Header.Height, err)
+		}
+	}
+
+	removed, err := bc.reorganize(branch)
+	if err != nil {
+		return err
+	}
+	bc.publish(Event{Type: EventBlockReorged, Reorg: &Reorg{
+		ForkHeight: branch[0].Header.Height,
+		Removed:    removed,
+		Added:      branch,
+	}})
+	for _, block := range branch {
+		bc.notifySlashings(slashingsIn(block))
+		bc.publish(Event{Type: EventBlockAdded, Block: block})
+	}
+	return nil
+}
+
+// reorganize validates a branch and swaps it into the chain, returning the
+// blocks it replaced
+func (bc *Blockchain) reorganize(branch []*Block) ([]*Block, error) {
+	if len(branch) == 0 {
+		return nil, errors.New("empty branch")
+	}
+
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	forkHeight := branch[0].Header.Height
+	if forkHeight == 0 || forkHeight > uint64(len(bc.Blocks)) {
+		return nil, errors.New("branch does not connect to the chain")
+	}
+	if finalized := bc.Finality.FinalizedHeight(); forkHeight <= finalized {
+		return nil, fmt.Errorf("%w: branch forks at height %d, finalized height is %d", ErrFinalizedReorg, forkHeight, finalized)
+	}
+	if !outranks(branch[len(branch)-1], bc.Blocks[len(bc.Blocks)-1]) {
+		return nil, errors.New("branch does not outrank the current chain")
+	}
+
+	prev := bc.Blocks[forkHeight-1]
+	now := bc.clock.Now()
+	for _, block := range branch {
+		if !block.IsValid(prev) {
+			return nil, fmt.Errorf("invalid block at height %d in branch", block.Header.Height)
+		}
+		if err := checkTimestamp(block, now); err != nil {
+			return nil, fmt.Errorf("block at height %d in branch: %w", block.Header.Height, err)
+		}
+		prev = block
+	}
+
+	// Replay the kept blocks and the branch on a fresh genesis state
+	state := bc.genesisState()
+	for _, block := range bc.Blocks[:forkHeight] {
+		if err := state.ApplyTransactions(block.Transactions, block.Header.Height); err != nil {
+			return nil, err
+		}
+	}
+	for _, block := range branch {
+		if err := state.ApplyTransactions(block.Transactions, block.Header.Height); err != nil {
+			return nil, fmt.Errorf("invalid block state transition at height %d: %w", block.Header.Height, err)
+		}
+	}
+
+	for _, block := range bc.Blocks[forkHeight:] {
+		for i := range block.Transactions {
+			delete(bc.included, block.Transactions[i].Hash)
+		}
+	}
+	for _, block := range branch {
+		for i := range block.Transactions {
+			tx := &block.Transactions[i]
+			bc.Transactions[tx.Hash] = tx
+			bc.included[tx.Hash] = TxLocation{Height: block.Header.Height, Index: i}
+			delete(bc.dropped, tx.Hash)
+		}
+	}
+
+	removed := append([]*Block(nil), bc.Blocks[forkHeight:]...)
+	bc.Blocks = append(bc.Blocks[:forkHeight:forkHeight], branch...)
+	bc.State.replaceWith(state)
+	bc.dropInvalidPending(bc.Blocks[len(bc.Blocks)-1].Header.Height + 1)
+
+	tip := bc.Blocks[len(bc.Blocks)-1].Header.Height
+	before, after := bc.Finality.setTip(tip)
+	bc.markFinalized(before, after)
+
+	bc.logger.Warn("Chain reorganized",
+		"forkHeight", forkHeight,
+		"replacedBlocks", len(removed),
+		"newBlocks", len(branch),
+		"height", tip)
+	return removed, nil
+}
@@ -0,0 +1,90 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"lscc/config"
+	"lscc/utils"
+)
+
+// testChain returns a chain of a default shard whose credits are all
+// refused, as no debit is known anywhere
+func testChain(t *testing.T) *Blockchain {
+	t.Helper()
+	bc := NewBlockchain(config.DefaultConfig())
+	bc.SetCreditVerifier(func(tx *Transaction) error {
+		return errors.New("no such debit")
+	})
+	return bc
+}
+
+// childBlock returns a block on top of prev carrying txs
+func childBlock(t *testing.T, prev *Block, validator string, txs ...Transaction) *Block {
+	t.Helper()
+	prevHash, err := prev.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := NewBlock(prevHash, prev.Header.Height+1, prev.ShardID, prev.Header.Layer, validator)
+	for _, tx := range txs {
+		block.AddTransaction(tx)
+	}
+	block.Header.MerkleRoot = block.CalculateMerkleRoot()
+	if err := block.Sign(validator); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// forgedCredit returns a credit paying out a transfer that never happened
+func forgedCredit(t *testing.T, to string) Transaction {
+	t.Helper()
+	tx, err := NewTransaction("shard1", to, utils.Coins(1000000), 0, 0, 0, 0, CrossShardTransaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Data, err = json.Marshal(CreditSource{SourceShard: 1, TxHashes: []string{"fake"}}); err != nil {
+		t.Fatal(err)
+	}
+	if tx.Hash, err = tx.CalculateHash(); err != nil {
+		t.Fatal(err)
+	}
+	return *tx
+}
+
+func TestReorganizeRefusesForgedCredit(t *testing.T) {
+	bc := testChain(t)
+	genesis := bc.GetLatestBlock()
+	if err := bc.AddBlock(childBlock(t, genesis, "node1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The credit is refused in a block extending the chain
+	credit := forgedCredit(t, "mallory")
+	if err := bc.AddBlock(childBlock(t, bc.GetLatestBlock(), "node1", credit)); err == nil {
+		t.Fatal("AddBlock accepted a forged credit")
+	}
+
+	// And in a longer branch replacing it
+	b1 := childBlock(t, genesis, "node2")
+	b2 := childBlock(t, b1, "node2", credit)
+	err := bc.Reorganize([]*Block{b1, b2})
+	if err == nil || !strings.Contains(err.Error(), "unverified credit") {
+		t.Fatalf("Reorganize: err = %v, want an unverified credit", err)
+	}
+	if balance := bc.State.GetBalance("mallory"); balance != 0 {
+		t.Fatalf("mallory's balance = %s after a refused branch", balance)
+	}
+	if bc.GetHeight() != 1 {
+		t.Fatalf("height = %d after a refused branch, want 1", bc.GetHeight())
+	}
+
+	// The branch without the credit replaces the chain
+	b2 = childBlock(t, b1, "node2")
+	if err := bc.Reorganize([]*Block{b1, b2}); err != nil {
+		t.Fatalf("Reorganize of a valid branch: %v", err)
+	}
+}
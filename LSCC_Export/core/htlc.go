@@ -0,0 +1,229 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Hash-time-locked transfers
+//
+// A lock transaction moves funds from the sender into a hash lock naming the
+// recipient, the SHA-256 hash of a secret preimage and a timeout height. Until
+// the timeout the recipient can claim the funds by revealing the preimage;
+// after it only the sender can take them back with a refund. Claims and
+// refunds pay their fee out of the released funds, so the claimant does not
+// need a balance in the shard.
+
+// LockStatus is the on-chain status of a hash lock
+type LockStatus string
+
+const (
+	// LockActive locks hold funds that can still be claimed or refunded
+	LockActive LockStatus = "active"
+	// LockClaimed locks have been released to the recipient
+	LockClaimed LockStatus = "claimed"
+	// LockRefunded locks have been returned to the sender
+	LockRefunded LockStatus = "refunded"
+)
+
+// HashLock is the on-chain record of a hash-time-locked transfer
+type HashLock struct {
+	ID        string     `json:"id"`
+	Sender    string     `json:"sender"`
+	Recipient string     `json:"recipient"`
+	Amount    Amount     `json:"amount"`
+	HashLock  string     `json:"hash_lock"`
+	Timeout   uint64     `json:"timeout"`
+	Status    LockStatus `json:"status"`
+	LockedAt  uint64     `json:"locked_at"`
+	Preimage  string     `json:"preimage,omitempty"`
+}
+
+// HTLCLockPayload carries the lock condition of a lock transaction
+type HTLCLockPayload struct {
+	HashLock string `json:"hash_lock"`
+	Timeout  uint64 `json:"timeout"`
+}
+
+// HTLCClaimPayload reveals the preimage releasing a lock
+type HTLCClaimPayload struct {
+	LockID   string `json:"lock_id"`
+	Preimage string `json:"preimage"`
+}
+
+// HTLCRefundPayload identifies the lock a refund transaction returns
+type HTLCRefundPayload struct {
+	LockID string `json:"lock_id"`
+}
+
+// HashSecret returns the hex-encoded SHA-256 hash of a hex-encoded preimage
+func HashSecret(preimage string) (string, error) {
+	secret, err := hex.DecodeString(preimage)
+	if err != nil {
+		return "", fmt.Errorf("preimage must be hex encoded: %w", err)
+	}
+	hash := sha256.Sum256(secret)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// NewSecret generates a random preimage and its hash lock
+func NewSecret() (preimage, hashLock string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	preimage = hex.EncodeToString(secret)
+	hashLock, err = HashSecret(preimage)
+	return preimage, hashLock, err
+}
+
+// NewHTLCLockTransaction creates a transaction locking amount for to until
+// the preimage of hashLock is revealed or the timeout height passes
+func NewHTLCLockTransaction(from, to string, amount, fee Amount, shardID int, hashLock string, timeout uint64) (*Transaction, error) {
+	return newPayloadTransaction(from, to, amount, fee, shardID, HTLCLockTransaction, HTLCLockPayload{
+		HashLock: hashLock,
+		Timeout:  timeout,
+	})
+}
+
+// NewHTLCClaimTransaction creates a transaction releasing a lock to its
+// recipient by revealing the preimage
+func NewHTLCClaimTransaction(from, lockID, preimage string, fee Amount, shardID int) (*Transaction, error) {
+	return newPayloadTransaction(from, from, 0, fee, shardID, HTLCClaimTransaction, HTLCClaimPayload{
+		LockID:   lockID,
+		Preimage: preimage,
+	})
+}
+
+// NewHTLCRefundTransaction creates a transaction returning an expired lock
+// to its sender
+func NewHTLCRefundTransaction(from, lockID string, fee Amount, shardID int) (*Transaction, error) {
+	return newPayloadTransaction(from, from, 0, fee, shardID, HTLCRefundTransaction, HTLCRefundPayload{
+		LockID: lockID,
+	})
+}
+
+// GetLock returns a copy of a hash lock's on-chain record
+func (s *State) GetLock(id string) (*HashLock, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lock, exists := s.locks[id]
+	if !exists {
+		return nil, false
+	}
+	lockCopy := *lock
+	return &lockCopy, true
+}
+
+// applyHTLCTransaction applies a hash lock transaction. Must be called with
+// the lock held.
+func (s *State) applyHTLCTransaction(tx *Transaction, height uint64) error {
+	switch tx.Type {
+	case HTLCLockTransaction:
+		var payload HTLCLockPayload
+		if err := json.Unmarshal(tx.Data, &payload); err != nil {
+			return fmt.Errorf("invalid lock payload: %w", err)
+		}
+		if tx.From == tx.To {
+			return errors.New("cannot lock funds for yourself")
+		}
+		if hash, err := hex.DecodeString(payload.HashLock); err != nil || len(hash) != sha256.Size {
+			return errors.New("hash lock must be a hex-encoded SHA-256 hash")
+		}
+		if payload.Timeout <= height {
+			return fmt.Errorf("timeout %d has already passed at height %d", payload.Timeout, height)
+		}
+		if _, exists := s.locks[tx.Hash]; exists {
+			return errors.New("lock already exists")
+		}
+		if err := s.debitWithFee(tx.From, tx.Amount, tx.Fee); err != nil {
+			return err
+		}
+		s.locks[tx.Hash] = &HashLock{
+			ID:        tx.Hash,
+			Sender:    tx.From,
+			Recipient: tx.To,
+			Amount:    tx.Amount,
+			HashLock:  payload.HashLock,
+			Timeout:   payload.Timeout,
+			Status:    LockActive,
+			LockedAt:  height,
+		}
+		return nil
+
+	case HTLCClaimTransaction:
+		var payload HTLCClaimPayload
+		if err := json.Unmarshal(tx.Data, &payload); err != nil {
+			return fmt.Errorf("invalid claim payload: %w", err)
+		}
+		lock, err := s.activeLock(payload.LockID, tx.Fee)
+		if err != nil {
+			return err
+		}
+		if tx.From != lock.Recipient {
+			return errors.New("only the recipient may claim a lock")
+		}
+		if height > lock.Timeout {
+			return fmt.Errorf("lock expired at height %d", lock.Timeout)
+		}
+		hash, err := HashSecret(payload.Preimage)
+		if err != nil {
+			return err
+		}
+		if hash != lock.HashLock {
+			return errors.New("preimage does not match hash lock")
+		}
+
+		if err := s.credit(lock.Recipient, lock.Amount-tx.Fee); err != nil {
+			return err
+		}
+		lock.Status = LockClaimed
+		lock.Preimage = payload.Preimage
+		return nil
+
+	case HTLCRefundTransaction:
+		var payload HTLCRefundPayload
+		if err := json.Unmarshal(tx.Data, &payload); err != nil {
+			return fmt.Errorf("invalid refund payload: %w", err)
+		}
+		lock, err := s.activeLock(payload.LockID, tx.Fee)
+		if err != nil {
+			return err
+		}
+		if tx.From != lock.Sender {
+			return errors.New("only the sender may refund a lock")
+		}
+		if height <= lock.Timeout {
+			return fmt.Errorf("lock can be refunded after height %d", lock.Timeout)
+		}
+
+		if err := s.credit(lock.Sender, lock.Amount-tx.Fee); err != nil {
+			return err
+		}
+		lock.Status = LockRefunded
+		return nil
+	}
+
+	return fmt.Errorf("unsupported hash lock transaction type %d", tx.Type)
+}
+
+// activeLock returns an active lock whose funds can cover the fee of the
+// transaction releasing it. Must be called with the lock held.
+func (s *State) activeLock(id string, fee Amount) (*HashLock, error) {
+	lock, exists := s.locks[id]
+	if !exists {
+		return nil, errors.New("lock not found")
+	}
+	if lock.Status != LockActive {
+		return nil, fmt.Errorf("lock is already %s", lock.Status)
+	}
+	if fee > lock.Amount {
+		return nil, errors.New("fee exceeds locked amount")
+	}
+	return lock, nil
+}
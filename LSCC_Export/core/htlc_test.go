@@ -0,0 +1,183 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"lscc/utils"
+)
+
+// lockFunds locks 10 coins of alice for bob until height 5
+func lockFunds(t *testing.T, state *State, hashLock string) *HashLock {
+	t.Helper()
+	tx, err := NewHTLCLockTransaction("alice", "bob", utils.Coins(10), utils.Coins(1), 0, hashLock, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.ApplyTransaction(tx, 1); err != nil {
+		t.Fatal(err)
+	}
+	lock, _ := state.GetLock(tx.Hash)
+	return lock
+}
+
+// claim releases a lock with a preimage at a height
+func claim(state *State, from, lockID, preimage string, height uint64) error {
+	tx, err := NewHTLCClaimTransaction(from, lockID, preimage, utils.Coins(1), 0)
+	if err != nil {
+		return err
+	}
+	return state.ApplyTransaction(tx, height)
+}
+
+// refund returns a lock to its sender at a height
+func refund(state *State, from, lockID string, height uint64) error {
+	tx, err := NewHTLCRefundTransaction(from, lockID, utils.Coins(1), 0)
+	if err != nil {
+		return err
+	}
+	return state.ApplyTransaction(tx, height)
+}
+
+func TestHashSecret(t *testing.T) {
+	preimage, hashLock, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := HashSecret(preimage); err != nil || hash != hashLock {
+		t.Errorf("HashSecret(preimage) = %s, %v; want %s", hash, err, hashLock)
+	}
+	// SHA-256 of the empty preimage
+	if hash, _ := HashSecret(""); hash != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("HashSecret(\"\") = %s", hash)
+	}
+	if _, err := HashSecret("not hex"); err == nil {
+		t.Error("preimage that is not hex accepted")
+	}
+}
+
+func TestHTLCLockChecks(t *testing.T) {
+	_, hashLock, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		to       string
+		hashLock string
+		timeout  uint64
+		want     string
+	}{
+		{"for the sender", "alice", hashLock, 5, "yourself"},
+		{"with a short hash", "bob", hashLock[:32], 5, "SHA-256"},
+		{"with a timeout passed", "bob", hashLock, 1, "already passed"},
+	}
+	for _, tt := range tests {
+		state := newTestState(t)
+		tx, err := NewHTLCLockTransaction("alice", tt.to, utils.Coins(10), utils.Coins(1), 0, tt.hashLock, tt.timeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := state.ApplyTransaction(tx, 1); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("lock %s: err = %v, want %q", tt.name, err, tt.want)
+		}
+		if balance := state.GetBalance("alice"); balance != utils.Coins(100) {
+			t.Errorf("lock %s debited alice: %s", tt.name, balance)
+		}
+	}
+}
+
+func TestHTLCClaim(t *testing.T) {
+	state := newTestState(t)
+	preimage, hashLock, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := lockFunds(t, state, hashLock)
+	if balance := state.GetBalance("alice"); balance != utils.Coins(89) {
+		t.Fatalf("alice = %s after locking, want 89", balance)
+	}
+
+	wrong, _, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := claim(state, "bob", lock.ID, wrong, 2); err == nil {
+		t.Error("claim with the wrong preimage accepted")
+	}
+	if err := claim(state, "carol", lock.ID, preimage, 2); err == nil {
+		t.Error("claim by someone other than the recipient accepted")
+	}
+	if err := refund(state, "alice", lock.ID, 5); err == nil {
+		t.Error("refund before the timeout accepted")
+	}
+	if err := claim(state, "bob", lock.ID, preimage, 6); err == nil {
+		t.Error("claim after the timeout accepted")
+	}
+
+	if err := claim(state, "bob", lock.ID, preimage, 5); err != nil {
+		t.Fatalf("claim at the timeout refused: %v", err)
+	}
+	// The claim's fee is paid out of the locked funds
+	if balance := state.GetBalance("bob"); balance != utils.Coins(109) {
+		t.Errorf("bob = %s after claiming, want 109", balance)
+	}
+	claimed, _ := state.GetLock(lock.ID)
+	if claimed.Status != LockClaimed || claimed.Preimage != preimage {
+		t.Errorf("lock is %s revealing %q, want claimed revealing the preimage", claimed.Status, claimed.Preimage)
+	}
+	if err := claim(state, "bob", lock.ID, preimage, 5); err == nil {
+		t.Error("lock claimed twice")
+	}
+	if err := refund(state, "alice", lock.ID, 6); err == nil {
+		t.Error("claimed lock refunded")
+	}
+}
+
+func TestHTLCRefund(t *testing.T) {
+	state := newTestState(t)
+	_, hashLock, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := lockFunds(t, state, hashLock)
+
+	if err := refund(state, "bob", lock.ID, 6); err == nil {
+		t.Error("refund by the recipient accepted")
+	}
+	if err := refund(state, "alice", lock.ID, 6); err != nil {
+		t.Fatalf("refund after the timeout refused: %v", err)
+	}
+	// alice paid 1 coin to lock and 1 to refund
+	if balance := state.GetBalance("alice"); balance != utils.Coins(98) {
+		t.Errorf("alice = %s after the refund, want 98", balance)
+	}
+	if refunded, _ := state.GetLock(lock.ID); refunded.Status != LockRefunded {
+		t.Errorf("lock is %s, want %s", refunded.Status, LockRefunded)
+	}
+	if err := refund(state, "alice", lock.ID, 7); err == nil {
+		t.Error("lock refunded twice")
+	}
+}
+
+func TestHTLCFeeMustFitTheLock(t *testing.T) {
+	state := newTestState(t)
+	preimage, hashLock, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := NewHTLCLockTransaction("alice", "bob", utils.Coins(1), 0, 0, hashLock, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.ApplyTransaction(tx, 1); err != nil {
+		t.Fatal(err)
+	}
+	claimTx, err := NewHTLCClaimTransaction("bob", tx.Hash, preimage, utils.Coins(2), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.ApplyTransaction(claimTx, 2); err == nil || !strings.Contains(err.Error(), "fee exceeds") {
+		t.Errorf("claim paying more fee than locked: err = %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"lscc/vm"
+)
+
+// Cross-shard contract messages
+//
+// A contract calls a contract in another shard with XCALL. The call's value
+// leaves the sender's balance and the call is recorded as a message in the
+// sending shard's outbox. Once the transaction that sent it is final, the
+// sharding layer delivers the message to the target shard, where a relay
+// signed ContractMessageTransaction runs the called method in a later block.
+//
+// The called contract's result travels back the same way as a callback
+// message, which runs the sender's callback method with the arguments
+// (call message ID, 1 on success or 0 on failure, return value or error).
+// A failed call's value is refunded with its callback, which is sent even
+// when the sender named no callback method. Gas for the call and its
+// callback is paid by the sending transaction; the callback gets whatever
+// the call left.
+
+// MessageKind tells calls and their callbacks apart
+type MessageKind string
+
+const (
+	// MessageCall messages run a method of a contract in the target shard
+	MessageCall MessageKind = "call"
+	// MessageCallback messages return a call's result to its sender
+	MessageCallback MessageKind = "callback"
+)
+
+// ContractMessage is an asynchronous message between contracts, usually in
+// different shards
+type ContractMessage struct {
+	ID          string      `json:"id"`
+	Kind        MessageKind `json:"kind"`
+	SourceShard int         `json:"source_shard"`
+	TargetShard int         `json:"target_shard"`
+	Sender      string      `json:"sender"`   // Contract the message comes from
+	Contract    string      `json:"contract"` // Contract the message is for
+	Method      string      `json:"method,omitempty"`
+	Args        []vm.Value  `json:"args,omitempty"`
+	Value       Amount      `json:"value"` // Attached to a call, or refunded by a callback
+	GasLimit    uint64      `json:"gas_limit"`
+	Callback    string      `json:"callback,omitempty"` // Sender method run with a call's result
+	CallID      string      `json:"call_id,omitempty"`  // Call a callback answers
+	Origin      string      `json:"origin"`             // Transaction that sent the message
+	Height      uint64      `json:"height"`             // Height of the block that sent it
+}
+
+// MessageDelivery records where a message was delivered
+type MessageDelivery struct {
+	MessageID string `json:"message_id"`
+	TxHash    string `json:"tx_hash"`
+	Height    uint64 `json:"height"`
+}
+
+// messageID derives the ID of the n-th message sent by a transaction
+func messageID(origin string, n int) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("message:%s:%d", origin, n)))
+	return hex.EncodeToString(hash[:])
+}
+
+// CallbackID derives the ID of the callback answering a call
+func CallbackID(callID string) string {
+	hash := sha256.Sum256([]byte("callback:" + callID))
+	return hex.EncodeToString(hash[:])
+}
+
+// Reply creates the callback returning a call's result to its sender. A
+// failed call refunds its value. gasUsed is the gas the call used.
+func (m *ContractMessage) Reply(result *vm.Result, gasUsed uint64, origin string, height uint64) *ContractMessage {
+	status, output := vm.Int(1), vm.Int(0)
+	refund := Amount(0)
+	if result.Err != nil {
+		status, output = vm.Int(0), vm.String(result.Err.Error())
+		refund = m.Value
+	} else if result.Return != nil {
+		output = *result.Return
+	}
+
+	gasLeft := uint64(0)
+	if gasUsed < m.GasLimit {
+		gasLeft = m.GasLimit - gasUsed
+	}
+	return &ContractMessage{
+		ID:          CallbackID(m.ID),
+		Kind:        MessageCallback,
+		SourceShard: m.TargetShard,
+		TargetShard: m.SourceShard,
+		Sender:      m.Contract,
+		Contract:    m.Sender,
+		Method:      m.Callback,
+		Args:        []vm.Value{vm.String(m.ID), status, output},
+		Value:       refund,
+		GasLimit:    gasLeft,
+		CallID:      m.ID,
+		Origin:      origin,
+		Height:      height,
+	}
+}
+
+// Bounce creates the failure callback of a call that cannot be delivered,
+// refunding its value
+func (m *ContractMessage) Bounce(reason error) *ContractMessage {
+	return m.Reply(&vm.Result{Err: reason}, 0, m.Origin, m.Height)
+}
+
+// NewContractMessageTransaction creates the transaction delivering a message
+// in its target shard. It carries the message's value and is signed by the
+// relay that carries it.
+func NewContractMessageTransaction(msg *ContractMessage, relayID string) (*Transaction, error) {
+	tx, err := newPayloadTransaction(msg.Sender, msg.Contract, msg.Value, 0, msg.TargetShard, ContractMessageTransaction, msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Sign(relayID); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// GetContractMessages returns the messages sent from the shard, ordered by
+// the height that sent them
+func (s *State) GetContractMessages() []*ContractMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := make([]*ContractMessage, 0, len(s.outbox))
+	for _, msg := range s.outbox {
+		msgCopy := *msg
+		messages = append(messages, &msgCopy)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		if messages[i].Height != messages[j].Height {
+			return messages[i].Height < messages[j].Height
+		}
+		return messages[i].ID < messages[j].ID
+	})
+	return messages
+}
+
+// GetContractMessage returns a message sent from the shard
+func (s *State) GetContractMessage(id string) (*ContractMessage, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	msg, exists := s.outbox[id]
+	if !exists {
+		return nil, false
+	}
+	msgCopy := *msg
+	return &msgCopy, true
+}
+
+// GetMessageDelivery returns the delivery of a message to the shard
+func (s *State) GetMessageDelivery(id string) (*MessageDelivery, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	delivery, exists := s.delivered[id]
+	if !exists {
+		return nil, false
+	}
+	deliveryCopy := *delivery
+	return &deliveryCopy, true
+}
+
+// applyContractMessage delivers a message from another shard. A call runs
+// the called method and sends back its callback; a callback refunds any
+// value and runs the sender's callback method. Either way the delivery is
+// included and recorded in a receipt. Must be called with the lock held.
+func (s *State) applyContractMessage(tx *Transaction, height uint64) error {
+	var msg ContractMessage
+	if err := json.Unmarshal(tx.Data, &msg); err != nil {
+		return fmt.Errorf("invalid message payload: %w", err)
+	}
+	if msg.Kind != MessageCall && msg.Kind != MessageCallback {
+		return fmt.Errorf("unknown message kind %q", msg.Kind)
+	}
+	if msg.ID == "" || msg.TargetShard != tx.TargetShard {
+		return errors.New("message is not addressed to this shard")
+	}
+	if tx.From != msg.Sender || tx.To != msg.Contract || tx.Amount != msg.Value || tx.Fee != 0 {
+		return errors.New("transaction does not match the message it delivers")
+	}
+	if _, delivered := s.delivered[msg.ID]; delivered {
+		return fmt.Errorf("message %s already delivered", msg.ID)
+	}
+	if msg.GasLimit > s.params.MaxContractGas {
+		msg.GasLimit = s.params.MaxContractGas
+	}
+
+	// A callback's refund is returned whatever its callback method does
+	if msg.Kind == MessageCallback && msg.Value > 0 {
+		if err := s.credit(msg.Contract, msg.Value); err != nil {
+			return err
+		}
+	}
+	s.delivered[msg.ID] = &MessageDelivery{MessageID: msg.ID, TxHash: tx.Hash, Height: height}
+	s.supply.TransferredIn += msg.Value
+
+	contract, exists := s.contracts[msg.Contract]
+	var result *vm.Result
+	switch {
+	case msg.Kind == MessageCallback && (msg.Method == "" || !exists):
+		// Nothing to run: the callback only returned the refund
+		result = &vm.Result{}
+	case !exists:
+		result = &vm.Result{Err: fmt.Errorf("contract %s not found", msg.Contract)}
+	default:
+		result = s.runContractMessage(contract, &msg, tx, height)
+	}
+
+	receipt := newContractReceipt(tx.Hash, msg.Contract, msg.Method, height, msg.GasLimit, result)
+	receipt.Message = msg.ID
+	s.receipts[tx.Hash] = receipt
+
+	if msg.Kind == MessageCall && (msg.Callback != "" || (result.Err != nil && msg.Value > 0)) {
+		reply := msg.Reply(result, result.GasUsed, tx.Hash, height)
+		s.outbox[reply.ID] = reply
+		s.supply.TransferredOut += reply.Value
+	}
+	return nil
+}
+
+// runContractMessage runs the method named by a message. A call's value
+// only reaches the contract if the call succeeds; otherwise it goes back
+// with the callback. Must be called with the lock held.
+func (s *State) runContractMessage(contract *Contract, msg *ContractMessage, tx *Transaction, height uint64) *vm.Result {
+	host := newContractHost(s, contract, tx, height)
+	value := Amount(0)
+	if msg.Kind == MessageCall && msg.Value > 0 {
+		credited, err := Amount(host.Balance(contract.Address)).Add(msg.Value)
+		if err != nil {
+			return &vm.Result{Err: err}
+		}
+		host.balances[contract.Address] = credited
+		value = msg.Value
+	}
+
+	result := vm.Execute(contract.Code, vm.Context{
+		Caller:   msg.Sender,
+		Address:  contract.Address,
+		Value:    int64(value),
+		Height:   height,
+		Method:   msg.Method,
+		Args:     msg.Args,
+		GasLimit: msg.GasLimit,
+	}, host)
+	if result.Err == nil {
+		host.commit()
+	}
+	return result
+}
@@ -0,0 +1,125 @@
+package core
+
+// Transaction receipts
+//
+// Every transaction a chain has seen has a receipt reporting where it is: in
+// the pool, included in a block, confirmed in a final block, or failed,
+// either dropped from the pool because it no longer applies or included with
+// a failed contract execution. Cross-shard transactions waiting to be
+// delivered are reported as in flight by the sharding layer, which holds them
+// outside of any chain.
+
+// TxStatus is the lifecycle stage of a transaction
+type TxStatus string
+
+const (
+	// TxPending transactions wait in the pool
+	TxPending TxStatus = "pending"
+	// TxIncluded transactions are in a block that is not final yet
+	TxIncluded TxStatus = "included"
+	// TxConfirmed transactions are in a final block
+	TxConfirmed TxStatus = "confirmed"
+	// TxFailed transactions were dropped from the pool, or were included
+	// but their contract execution failed
+	TxFailed TxStatus = "failed"
+	// TxCrossShardInFlight transactions are between shards
+	TxCrossShardInFlight TxStatus = "cross-shard-in-flight"
+)
+
+// TxLocation is the position of a transaction in the chain
+type TxLocation struct {
+	Height uint64 `json:"height"`
+	Index  int    `json:"index"`
+}
+
+// droppedTx is a pool transaction that stopped applying to the state
+type droppedTx struct {
+	tx  *Transaction
+	err string
+}
+
+// Receipt reports the outcome of a transaction
+type Receipt struct {
+	TxHash        string           `json:"tx_hash"`
+	Status        TxStatus         `json:"status"`
+	ShardID       int              `json:"shard_id"`
+	BlockHash     string           `json:"block_hash,omitempty"`
+	BlockHeight   uint64           `json:"block_height,omitempty"`
+	Index         int              `json:"index"` // Position in the block, -1 outside of one
+	Confirmations uint64           `json:"confirmations"`
+	FeePaid       Amount           `json:"fee_paid"`
+	Error         string           `json:"error,omitempty"`
+	Contract      *ContractReceipt `json:"contract,omitempty"`
+}
+
+// NewInFlightReceipt creates the receipt of a cross-shard transaction held
+// by the sharding layer
+func NewInFlightReceipt(tx *Transaction) *Receipt {
+	return &Receipt{
+		TxHash:  tx.Hash,
+		Status:  TxCrossShardInFlight,
+		ShardID: tx.SourceShard,
+		Index:   -1,
+	}
+}
+
+// GetReceipt returns the receipt of a transaction known to the chain
+func (bc *Blockchain) GetReceipt(hash string) (*Receipt, bool) {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	receipt := &Receipt{TxHash: hash, ShardID: bc.Config.ShardID, Index: -1}
+	if dropped, exists := bc.dropped[hash]; exists {
+		receipt.Status = TxFailed
+		receipt.Error = dropped.err
+		return receipt, true
+	}
+
+	tx, exists := bc.Transactions[hash]
+	if !exists {
+		return nil, false
+	}
+	location, included := bc.included[hash]
+	if !included {
+		receipt.Status = TxPending
+		return receipt, true
+	}
+
+	receipt.Status = TxIncluded
+	if bc.Finality.IsFinalized(location.Height) {
+		receipt.Status = TxConfirmed
+	}
+	receipt.BlockHeight = location.Height
+	receipt.Index = location.Index
+	receipt.Confirmations = bc.Finality.Confirmations(location.Height)
+	receipt.FeePaid = tx.Fee
+	if location.Height < uint64(len(bc.Blocks)) {
+		if blockHash, err := bc.Blocks[location.Height].Hash(); err == nil {
+			receipt.BlockHash = blockHash
+		}
+	}
+	if contract, exists := bc.State.GetContractReceipt(hash); exists {
+		receipt.Contract = contract
+		if contract.Status == ReceiptFailed {
+			receipt.Status = TxFailed
+			receipt.Error = contract.Error
+		}
+	}
+	return receipt, true
+}
+
+// dropInvalidPending removes the pool transactions that no longer apply to
+// the state, such as spends of a balance that a block has already spent,
+// and records why. Must be called with the lock held.
+func (bc *Blockchain) dropInvalidPending(nextHeight uint64) {
+	for hash, tx := range bc.Transactions {
+		if _, included := bc.included[hash]; included {
+			continue
+		}
+		if err := bc.State.Copy().ApplyTransaction(tx, nextHeight); err != nil {
+			delete(bc.Transactions, hash)
+			bc.dropped[hash] = &droppedTx{tx: tx, err: err.Error()}
+			bc.logger.Info("Dropped transaction from pool", "hash", hash, "error", err)
+		}
+	}
+}
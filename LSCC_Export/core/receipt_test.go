@@ -0,0 +1,135 @@
+package core
+
+import (
+	"testing"
+
+	"lscc/config"
+	"lscc/utils"
+)
+
+// receiptChain returns a chain of shard 0 confirming blocks at depth 2, in
+// which alice holds 10 coins
+func receiptChain(t *testing.T) *Blockchain {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.ShardID = 0
+	cfg.MinConfirmations = 2
+	cfg.Allocations = []config.Allocation{{Address: "alice", Amount: utils.Coins(10), ShardID: 0}}
+	return NewBlockchain(cfg)
+}
+
+// payment returns a signed transfer in shard 0
+func payment(t *testing.T, from, to string, coins int64) *Transaction {
+	t.Helper()
+	tx, err := NewTransaction(from, to, utils.Coins(coins), utils.Coins(1), 0, 0, 0, RegularTransaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Sign(from); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// mineBlock adds a block of the chain's pending transactions
+func mineBlock(t *testing.T, bc *Blockchain) *Block {
+	t.Helper()
+	var txs []Transaction
+	for _, tx := range bc.GetPendingTransactions() {
+		txs = append(txs, *tx)
+	}
+	block := childBlock(t, bc.GetLatestBlock(), "node1", txs...)
+	if err := bc.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// receipt returns the receipt of a transaction known to the chain
+func receipt(t *testing.T, bc *Blockchain, hash string) *Receipt {
+	t.Helper()
+	r, exists := bc.GetReceipt(hash)
+	if !exists {
+		t.Fatalf("no receipt for %s", hash)
+	}
+	return r
+}
+
+func TestReceiptLifecycle(t *testing.T) {
+	bc := receiptChain(t)
+	tx := payment(t, "alice", "bob", 3)
+	if err := bc.AddTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if r := receipt(t, bc, tx.Hash); r.Status != TxPending || r.Index != -1 || r.BlockHash != "" {
+		t.Errorf("pooled receipt = %+v, want pending outside of a block", r)
+	}
+
+	block := mineBlock(t, bc)
+	blockHash, err := block.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := receipt(t, bc, tx.Hash)
+	if r.Status != TxIncluded || r.Confirmations != 1 {
+		t.Errorf("receipt = %s with %d confirmations, want included with 1", r.Status, r.Confirmations)
+	}
+	if r.BlockHash != blockHash || r.BlockHeight != 1 || r.Index != 0 || r.ShardID != 0 {
+		t.Errorf("receipt locates the tx at %s height %d index %d shard %d", r.BlockHash, r.BlockHeight, r.Index, r.ShardID)
+	}
+	if r.FeePaid != utils.Coins(1) {
+		t.Errorf("fee paid = %s, want 1", r.FeePaid)
+	}
+
+	mineBlock(t, bc)
+	if r := receipt(t, bc, tx.Hash); r.Status != TxConfirmed || r.Confirmations != 2 {
+		t.Errorf("receipt = %s with %d confirmations, want confirmed with 2", r.Status, r.Confirmations)
+	}
+	status, exists := bc.GetTransactionStatus(tx.Hash)
+	if !exists || !status.Finalized || status.Status != TxConfirmed {
+		t.Errorf("status = %+v, want finalized", status)
+	}
+
+	if _, exists := bc.GetReceipt("unknown"); exists {
+		t.Error("receipt of an unknown transaction")
+	}
+}
+
+func TestReceiptOfDroppedTransaction(t *testing.T) {
+	bc := receiptChain(t)
+
+	// Both spends apply on their own, but not together
+	first, second := payment(t, "alice", "bob", 6), payment(t, "alice", "carol", 6)
+	for _, tx := range []*Transaction{first, second} {
+		if err := bc.AddTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	block := childBlock(t, bc.GetLatestBlock(), "node1", *first)
+	if err := bc.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	r := receipt(t, bc, second.Hash)
+	if r.Status != TxFailed || r.Error == "" {
+		t.Errorf("receipt of the dropped spend = %s %q, want failed with the reason", r.Status, r.Error)
+	}
+	if pending := bc.GetPendingTransactions(); len(pending) != 0 {
+		t.Errorf("%d transactions left in the pool", len(pending))
+	}
+	status, exists := bc.GetTransactionStatus(second.Hash)
+	if !exists || status.Status != TxFailed || status.Transaction.Hash != second.Hash {
+		t.Errorf("status of the dropped spend = %+v", status)
+	}
+}
+
+func TestInFlightReceipt(t *testing.T) {
+	tx, err := NewTransaction("alice", "bob", utils.Coins(1), 0, 2, 3, 0, CrossShardTransaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewInFlightReceipt(tx)
+	if r.Status != TxCrossShardInFlight || r.ShardID != 2 || r.Index != -1 || r.TxHash != tx.Hash {
+		t.Errorf("in-flight receipt = %+v", r)
+	}
+}
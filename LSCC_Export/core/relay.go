@@ -0,0 +1,201 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"lscc/utils"
+)
+
+// RelayBlock batches cross-shard transactions bound for a target shard.
+// Relay nodes vote on it and it is final once enough relays approve.
+type RelayBlock struct {
+	ID            string                `json:"id"`
+	Timestamp     int64                 `json:"timestamp"`
+	CrossShardTxs []*Transaction        `json:"cross_shard_txs"`
+	SourceShards  []int                 `json:"source_shards"`
+	TargetShards  []int                 `json:"target_shards"`
+	Hash          string                `json:"hash"`
+	Votes         map[string]*RelayVote `json:"votes"`
+	IsFinalized   bool                  `json:"is_finalized"`
+	CreatedBy     string                `json:"created_by"`
+	Signature     string                `json:"signature,omitempty"` // CreatedBy's signature over the ID and hash
+}
+
+// RelayVote is a relay node's signed approval of a relay block
+type RelayVote struct {
+	RelayBlockID   string `json:"relay_block_id"`
+	RelayBlockHash string `json:"relay_block_hash"`
+	Relay          string `json:"relay"`
+	Signature      string `json:"signature"`
+	KeySignature   string `json:"key_signature,omitempty"` // Signed with the relay's registered key
+}
+
+// CalculateHash calculates the hash of a relay block from its ID, timestamp
+// and transaction hashes
+func (rb *RelayBlock) CalculateHash() string {
+	hashData := fmt.Sprintf("%s:%d", rb.ID, rb.Timestamp)
+	for _, tx := range rb.CrossShardTxs {
+		hashData += ":" + tx.Hash
+	}
+	hash := sha256.Sum256([]byte(hashData))
+	return hex.EncodeToString(hash[:])
+}
+
+// Copy returns a deep copy of the relay block, which stays intact while
+// votes are added to the original
+func (rb *RelayBlock) Copy() *RelayBlock {
+	copied := *rb
+	copied.CrossShardTxs = make([]*Transaction, len(rb.CrossShardTxs))
+	for i, tx := range rb.CrossShardTxs {
+		txCopy := *tx
+		copied.CrossShardTxs[i] = &txCopy
+	}
+	copied.SourceShards = append([]int(nil), rb.SourceShards...)
+	copied.TargetShards = append([]int(nil), rb.TargetShards...)
+	copied.Votes = make(map[string]*RelayVote, len(rb.Votes))
+	for relay, vote := range rb.Votes {
+		voteCopy := *vote
+		copied.Votes[relay] = &voteCopy
+	}
+	return &copied
+}
+
+// Validate checks the structure of a relay block and the transactions it
+// carries, including their signatures
+func (rb *RelayBlock) Validate() error {
+	if rb.ID == "" || rb.Hash == "" {
+		return errors.New("relay block is missing its ID or hash")
+	}
+	if rb.CalculateHash() != rb.Hash {
+		return errors.New("relay block hash mismatch")
+	}
+	for _, tx := range rb.CrossShardTxs {
+		if !tx.IsValid() {
+			return fmt.Errorf("transaction %s is invalid", tx.Hash)
+		}
+	}
+	return rb.validateContents()
+}
+
+// validateContents checks the rules that depend only on data committed to
+// by the relay block hash, so a violation proves the block was invalid when
+// a relay voted for it
+func (rb *RelayBlock) validateContents() error {
+	if len(rb.CrossShardTxs) == 0 {
+		return errors.New("relay block carries no transactions")
+	}
+	for _, tx := range rb.CrossShardTxs {
+		if tx.Hash == "" {
+			return fmt.Errorf("transaction from %s to %s is missing its hash", tx.From, tx.To)
+		}
+		if !tx.IsCrossShard() {
+			return fmt.Errorf("transaction %s is not cross-shard", tx.Hash)
+		}
+		if tx.Amount < 0 || tx.Fee < 0 || (tx.Amount == 0 && tx.carriesValue()) {
+			return fmt.Errorf("transaction %s has an invalid amount", tx.Hash)
+		}
+	}
+	return nil
+}
+
+// provesInvalid checks that the relay block is exactly the one with the
+// given hash and that its committed contents break a relay block rule
+func (rb *RelayBlock) provesInvalid(hash string) error {
+	if rb.CalculateHash() != hash {
+		return errors.New("relay block does not match the voted hash")
+	}
+	for _, tx := range rb.CrossShardTxs {
+		if txHash, err := tx.CalculateHash(); err != nil || txHash != tx.Hash {
+			return fmt.Errorf("transaction %s does not match its hash", tx.Hash)
+		}
+	}
+	if rb.validateContents() == nil {
+		return errors.New("relay block is valid")
+	}
+	return nil
+}
+
+// SigningPayload returns the bytes the creator of a relay block signs. The
+// hash commits to the contents, so the signature covers them too.
+func (rb *RelayBlock) SigningPayload() []byte {
+	return []byte(fmt.Sprintf("relay-block:%s:%s", rb.ID, rb.Hash))
+}
+
+// Sign signs the relay block as its creator
+func (rb *RelayBlock) Sign(privateKey string) error {
+	signature, err := utils.Sign(rb.SigningPayload(), privateKey)
+	if err != nil {
+		return err
+	}
+	rb.Signature = signature
+	return nil
+}
+
+// VerifySignature checks that the creator named by the relay block signed it
+func (rb *RelayBlock) VerifySignature() error {
+	if rb.CreatedBy == "" {
+		return errors.New("relay block is missing its creator")
+	}
+	// utils.Sign produces "signed:<key prefix>:<payload hash>"
+	expected, err := utils.Sign(rb.SigningPayload(), rb.CreatedBy)
+	if err != nil {
+		return err
+	}
+	if rb.Signature != expected || !utils.VerifySignature(rb.SigningPayload(), rb.Signature, rb.CreatedBy) {
+		return errors.New("invalid relay block signature")
+	}
+	return nil
+}
+
+// NewRelayVote creates a relay's signed vote for a relay block
+func NewRelayVote(rb *RelayBlock, relay, privateKey string) (*RelayVote, error) {
+	vote := &RelayVote{
+		RelayBlockID:   rb.ID,
+		RelayBlockHash: rb.Hash,
+		Relay:          relay,
+	}
+	signature, err := utils.Sign(vote.SigningPayload(), privateKey)
+	if err != nil {
+		return nil, err
+	}
+	vote.Signature = signature
+	return vote, nil
+}
+
+// SignWithKey signs the vote with the relay's registered key, which is what
+// lets the vote be held against the relay as evidence
+func (v *RelayVote) SignWithKey(privateKey string) error {
+	signature, err := utils.SignWithKey(v.SigningPayload(), privateKey)
+	if err != nil {
+		return err
+	}
+	v.KeySignature = signature
+	return nil
+}
+
+// SigningPayload returns the bytes a relay signs for a vote
+func (v *RelayVote) SigningPayload() []byte {
+	return []byte(fmt.Sprintf("relay-vote:%s:%s", v.RelayBlockID, v.RelayBlockHash))
+}
+
+// Verify checks the vote's signature
+func (v *RelayVote) Verify() error {
+	if v.Relay == "" {
+		return errors.New("vote is missing its relay")
+	}
+	if !signatureCommitsTo(v.SigningPayload(), v.Signature) ||
+		!utils.VerifySignature(v.SigningPayload(), v.Signature, v.Relay) {
+		return errors.New("invalid relay vote signature")
+	}
+	return nil
+}
+
+// signatureCommitsTo checks that a signature produced by utils.Sign was made
+// over the given data, so a signature cannot be moved to other data
+func signatureCommitsTo(data []byte, signature string) bool {
+	return strings.HasSuffix(signature, ":"+utils.Hash(data)[:16])
+}
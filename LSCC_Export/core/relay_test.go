@@ -0,0 +1,58 @@
+package core
+
+import "testing"
+
+func TestRelayBlockCopy(t *testing.T) {
+	rb := &RelayBlock{
+		ID:            "relay-1",
+		CrossShardTxs: []*Transaction{{Hash: "tx1", SourceShard: 0, TargetShard: 1}},
+		SourceShards:  []int{0},
+		TargetShards:  []int{1},
+		Votes:         map[string]*RelayVote{"node1": {RelayBlockID: "relay-1", Relay: "node1"}},
+	}
+	copied := rb.Copy()
+
+	// Changes to the original after the copy do not reach it
+	rb.Votes["node2"] = &RelayVote{RelayBlockID: "relay-1", Relay: "node2"}
+	rb.Votes["node1"].Signature = "changed"
+	rb.CrossShardTxs[0].Hash = "changed"
+	rb.TargetShards[0] = 2
+
+	if len(copied.Votes) != 1 || copied.Votes["node1"].Signature != "" {
+		t.Errorf("copy shares the votes of the original: %v", copied.Votes)
+	}
+	if copied.CrossShardTxs[0].Hash != "tx1" {
+		t.Errorf("copy shares the transactions of the original")
+	}
+	if copied.TargetShards[0] != 1 {
+		t.Errorf("copy shares the target shards of the original")
+	}
+}
+
+func TestRelayBlockSignature(t *testing.T) {
+	rb := &RelayBlock{ID: "relay-1", Timestamp: 1, CreatedBy: "node1"}
+	rb.Hash = rb.CalculateHash()
+	if err := rb.VerifySignature(); err == nil {
+		t.Error("unsigned relay block accepted")
+	}
+
+	if err := rb.Sign("node2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rb.VerifySignature(); err == nil {
+		t.Error("relay block signed by another node accepted")
+	}
+
+	if err := rb.Sign("node1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rb.VerifySignature(); err != nil {
+		t.Errorf("relay block signed by its creator rejected: %v", err)
+	}
+
+	// The signature does not carry over to other contents
+	rb.Hash = "other"
+	if err := rb.VerifySignature(); err == nil {
+		t.Error("signature accepted for another relay block hash")
+	}
+}
@@ -0,0 +1,198 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Block rewards
+//
+// A block may end with a coinbase transaction, produced by the consensus
+// engine, that mints the block reward and pays out the fees collected in the
+// block. The state only enforces the amounts: at most BlockReward is minted,
+// at most RelayRewardShare of the fees paid by transfers leaving the shard
+// goes to relays, and no more than the collected fees are paid out. Who is
+// paid is up to the consensus engine. Fees a block does not pay out are
+// burned.
+
+// PayoutKind identifies what a coinbase payout is for
+type PayoutKind string
+
+const (
+	// PayoutBlockReward pays newly minted block reward
+	PayoutBlockReward PayoutKind = "block_reward"
+	// PayoutFees pays collected fees to the block producer
+	PayoutFees PayoutKind = "fees"
+	// PayoutRelay pays collected fees to a relay carrying cross-shard traffic
+	PayoutRelay PayoutKind = "relay"
+)
+
+// Payout is a single credit made by a coinbase transaction
+type Payout struct {
+	Recipient string     `json:"recipient"`
+	Amount    Amount     `json:"amount"`
+	Kind      PayoutKind `json:"kind"`
+}
+
+// CoinbasePayload lists the payouts of a coinbase transaction
+type CoinbasePayload struct {
+	Height  uint64   `json:"height"`
+	Payouts []Payout `json:"payouts"`
+}
+
+// BlockFees are the fees collected by a block's transactions
+type BlockFees struct {
+	Total      Amount `json:"total"`
+	CrossShard Amount `json:"cross_shard"` // Paid by transfers leaving the shard
+}
+
+// SupplyInfo accounts for the funds of a shard. Total always equals
+// Genesis + Minted + TransferredIn - TransferredOut - FeesBurned - Slashed.
+type SupplyInfo struct {
+	Genesis        Amount `json:"genesis"`
+	Minted         Amount `json:"minted"`
+	TransferredIn  Amount `json:"transferred_in"`
+	TransferredOut Amount `json:"transferred_out"`
+	FeesCollected  Amount `json:"fees_collected"`
+	FeesPaid       Amount `json:"fees_paid"`
+	FeesBurned     Amount `json:"fees_burned"`
+	Slashed        Amount `json:"slashed"`
+	Total          Amount `json:"total"`
+}
+
+// NewCoinbaseTransaction creates the coinbase transaction of the block at
+// the given height, signed by its producer
+func NewCoinbaseTransaction(producer string, height uint64, payouts []Payout, shardID int) (*Transaction, error) {
+	var total Amount
+	for _, payout := range payouts {
+		var err error
+		if total, err = total.Add(payout.Amount); err != nil {
+			return nil, err
+		}
+	}
+	return newPayloadTransaction(producer, producer, total, 0, shardID, ConsensusTransaction, CoinbasePayload{
+		Height:  height,
+		Payouts: payouts,
+	})
+}
+
+// DecodeCoinbase returns the payouts of a coinbase transaction
+func DecodeCoinbase(tx *Transaction) (*CoinbasePayload, error) {
+	if tx.Type != ConsensusTransaction {
+		return nil, errors.New("not a coinbase transaction")
+	}
+	var payload CoinbasePayload
+	if err := json.Unmarshal(tx.Data, &payload); err != nil {
+		return nil, fmt.Errorf("invalid coinbase payload: %w", err)
+	}
+	return &payload, nil
+}
+
+// CollectFees returns the fees a block's transactions pay in this shard.
+// Credits arriving from another shard or layer paid their fee at the source.
+// The fees were debited from balances, so they sum without overflow once
+// the block's transactions have been applied.
+func CollectFees(txs []Transaction) BlockFees {
+	var fees BlockFees
+	for i := range txs {
+		tx := &txs[i]
+		if tx.Type == ConsensusTransaction || tx.IsIncomingCredit() {
+			continue
+		}
+		fees.Total += tx.Fee
+		if tx.IsCrossShard() {
+			fees.CrossShard += tx.Fee
+		}
+	}
+	return fees
+}
+
+// GetSupplyInfo returns the supply accounting of the shard
+func (s *State) GetSupplyInfo() (SupplyInfo, error) {
+	total, err := s.GetSupply()
+	if err != nil {
+		return SupplyInfo{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	supply := s.supply
+	supply.Total = total
+	return supply, nil
+}
+
+// endBlock records the fees collected by a block and applies its coinbase
+// transaction, if any
+func (s *State) endBlock(coinbase *Transaction, height uint64, fees BlockFees) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.supply.FeesCollected += fees.Total
+	if coinbase == nil {
+		s.supply.FeesBurned += fees.Total
+		return nil
+	}
+	return s.applyCoinbase(coinbase, height, fees)
+}
+
+// applyCoinbase checks a coinbase transaction against the block reward and
+// the fees collected by its block, pays it out and burns the fees it leaves.
+// Must be called with the lock held.
+func (s *State) applyCoinbase(tx *Transaction, height uint64, fees BlockFees) error {
+	payload, err := DecodeCoinbase(tx)
+	if err != nil {
+		return err
+	}
+	if payload.Height != height {
+		return fmt.Errorf("coinbase is for height %d, not %d", payload.Height, height)
+	}
+
+	totals := make(map[PayoutKind]Amount)
+	var total Amount
+	for _, payout := range payload.Payouts {
+		if payout.Recipient == "" || payout.Amount <= 0 {
+			return errors.New("coinbase payouts need a recipient and a positive amount")
+		}
+		switch payout.Kind {
+		case PayoutBlockReward, PayoutFees, PayoutRelay:
+		default:
+			return fmt.Errorf("unknown payout kind %q", payout.Kind)
+		}
+		if totals[payout.Kind], err = totals[payout.Kind].Add(payout.Amount); err != nil {
+			return err
+		}
+		if total, err = total.Add(payout.Amount); err != nil {
+			return err
+		}
+	}
+	reward, feesPaid, relayPaid := totals[PayoutBlockReward], totals[PayoutFees], totals[PayoutRelay]
+
+	relayShare, err := fees.CrossShard.Fraction(s.params.RelayRewardShare)
+	if err != nil {
+		return err
+	}
+	if total != tx.Amount {
+		return errors.New("coinbase amount does not match its payouts")
+	}
+	if reward > s.params.BlockReward {
+		return fmt.Errorf("coinbase mints %s, block reward is %s", reward, s.params.BlockReward)
+	}
+	if relayPaid > relayShare {
+		return fmt.Errorf("coinbase pays relays %s, more than their share %s of the cross-shard fees", relayPaid, relayShare)
+	}
+	if feesPaid+relayPaid > fees.Total {
+		return fmt.Errorf("coinbase pays %s in fees, block collected %s", feesPaid+relayPaid, fees.Total)
+	}
+
+	for _, payout := range payload.Payouts {
+		if err := s.credit(payout.Recipient, payout.Amount); err != nil {
+			return err
+		}
+	}
+	s.supply.Minted += reward
+	s.supply.FeesPaid += feesPaid + relayPaid
+	s.supply.FeesBurned += fees.Total - feesPaid - relayPaid
+	return nil
+}
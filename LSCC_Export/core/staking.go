@@ -0,0 +1,342 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Staking
+//
+// A stake transaction bonds funds from an account's balance to the account
+// itself as a validator; a delegate transaction bonds them to an existing
+// validator. An unstake transaction starts unbonding part of a bond: the
+// funds stop counting toward the validator's stake at once and return to the
+// delegator's balance once the unbonding period has passed. Slashing an
+// offender burns SlashFraction of every bond and unbonding backing it.
+//
+// The validator set is recomputed from the bonds at every epoch boundary
+// and stays fixed for the epoch, so stake changes take effect at the next
+// epoch. Only validators whose own stake reaches MinStake are included.
+
+// Bond is stake a delegator has bonded to a validator. A validator's own
+// stake is a bond to itself.
+type Bond struct {
+	Delegator string `json:"delegator"`
+	Validator string `json:"validator"`
+	Amount    Amount `json:"amount"`
+}
+
+// Unbonding is stake waiting out the unbonding period
+type Unbonding struct {
+	Delegator        string `json:"delegator"`
+	Validator        string `json:"validator"`
+	Amount           Amount `json:"amount"`
+	CreationHeight   uint64 `json:"creation_height"`
+	CompletionHeight uint64 `json:"completion_height"`
+}
+
+// ValidatorInfo summarizes the stake bonded to a validator
+type ValidatorInfo struct {
+	Address        string            `json:"address"`
+	SelfStake      Amount            `json:"self_stake"`
+	DelegatedStake Amount            `json:"delegated_stake"`
+	TotalStake     Amount            `json:"total_stake"`
+	Delegations    map[string]Amount `json:"delegations"`
+	Active         bool              `json:"active"` // In the current epoch's validator set
+	Slashed        bool              `json:"slashed"`
+}
+
+// ValidatorSet is the set of validators for an epoch with their voting power
+type ValidatorSet struct {
+	Epoch      uint64            `json:"epoch"`
+	Height     uint64            `json:"height"` // Height the set was computed at
+	Validators map[string]Amount `json:"validators"`
+	TotalStake Amount            `json:"total_stake"`
+}
+
+// NewStakeTransaction creates a transaction bonding amount to the sender
+// as a validator
+func NewStakeTransaction(validator string, amount, fee Amount, shardID int) (*Transaction, error) {
+	return NewTransaction(validator, validator, amount, fee, shardID, shardID, 0, StakeTransaction)
+}
+
+// NewDelegateTransaction creates a transaction bonding amount to a validator
+func NewDelegateTransaction(delegator, validator string, amount, fee Amount, shardID int) (*Transaction, error) {
+	return NewTransaction(delegator, validator, amount, fee, shardID, shardID, 0, DelegateTransaction)
+}
+
+// NewUnstakeTransaction creates a transaction unbonding amount of the
+// sender's bond to a validator
+func NewUnstakeTransaction(delegator, validator string, amount, fee Amount, shardID int) (*Transaction, error) {
+	return NewTransaction(delegator, validator, amount, fee, shardID, shardID, 0, UnstakeTransaction)
+}
+
+// bondKey returns the key of a delegator's bond to a validator
+func bondKey(delegator, validator string) string {
+	return delegator + "/" + validator
+}
+
+// EpochOf returns the epoch a height belongs to
+func (s *State) EpochOf(height uint64) uint64 {
+	if s.params.EpochLength == 0 {
+		return 0
+	}
+	return height / s.params.EpochLength
+}
+
+// GetValidatorSet returns a copy of the current epoch's validator set
+func (s *State) GetValidatorSet() *ValidatorSet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set := *s.validatorSet
+	set.Validators = make(map[string]Amount, len(s.validatorSet.Validators))
+	for validator, stake := range s.validatorSet.Validators {
+		set.Validators[validator] = stake
+	}
+	return &set
+}
+
+// GetValidators returns the stake bonded to every validator, largest first
+func (s *State) GetValidators() []*ValidatorInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byAddress := make(map[string]*ValidatorInfo)
+	for _, bond := range s.bonds {
+		info, exists := byAddress[bond.Validator]
+		if !exists {
+			info = &ValidatorInfo{
+				Address:     bond.Validator,
+				Delegations: make(map[string]Amount),
+			}
+			byAddress[bond.Validator] = info
+		}
+		if bond.Delegator == bond.Validator {
+			info.SelfStake += bond.Amount
+		} else {
+			info.DelegatedStake += bond.Amount
+			info.Delegations[bond.Delegator] += bond.Amount
+		}
+		info.TotalStake += bond.Amount
+	}
+
+	validators := make([]*ValidatorInfo, 0, len(byAddress))
+	for address, info := range byAddress {
+		_, info.Active = s.validatorSet.Validators[address]
+		_, info.Slashed = s.slashings[address]
+		validators = append(validators, info)
+	}
+	sort.Slice(validators, func(i, j int) bool {
+		if validators[i].TotalStake != validators[j].TotalStake {
+			return validators[i].TotalStake > validators[j].TotalStake
+		}
+		return validators[i].Address < validators[j].Address
+	})
+	return validators
+}
+
+// GetUnbondings returns copies of the unbondings still waiting out the
+// unbonding period
+func (s *State) GetUnbondings() []*Unbonding {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	unbondings := make([]*Unbonding, 0, len(s.unbondings))
+	for _, unbonding := range s.unbondings {
+		unbondingCopy := *unbonding
+		unbondings = append(unbondings, &unbondingCopy)
+	}
+	return unbondings
+}
+
+// GenesisStake bonds a validator's self stake in the genesis state. The
+// stake joins the validator set when the genesis block is applied.
+func (s *State) GenesisStake(validator string, amount Amount) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := bondKey(validator, validator)
+	bond, exists := s.bonds[key]
+	if !exists {
+		bond = &Bond{Delegator: validator, Validator: validator}
+		s.bonds[key] = bond
+	}
+	stake, err := bond.Amount.Add(amount)
+	if err != nil {
+		return err
+	}
+	genesis, err := s.supply.Genesis.Add(amount)
+	if err != nil {
+		return err
+	}
+	bond.Amount = stake
+	s.supply.Genesis = genesis
+	return nil
+}
+
+// BeginBlock runs the state transitions due at the start of a block:
+// matured unbondings are paid out and, at an epoch boundary, the validator
+// set is recomputed from the bonds
+func (s *State) BeginBlock(height uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	remaining := s.unbondings[:0]
+	for _, unbonding := range s.unbondings {
+		if unbonding.CompletionHeight <= height {
+			// Unbonded stake was taken from the supply, so it cannot overflow
+			s.balances[unbonding.Delegator] += unbonding.Amount
+			continue
+		}
+		remaining = append(remaining, unbonding)
+	}
+	s.unbondings = remaining
+
+	if s.params.EpochLength == 0 || height%s.params.EpochLength == 0 {
+		s.validatorSet = s.computeValidatorSet(height)
+	}
+}
+
+// computeValidatorSet builds the validator set from the current bonds. Must
+// be called with the lock held.
+func (s *State) computeValidatorSet(height uint64) *ValidatorSet {
+	set := &ValidatorSet{
+		Epoch:      s.EpochOf(height),
+		Height:     height,
+		Validators: make(map[string]Amount),
+	}
+
+	selfStake := make(map[string]Amount)
+	totalStake := make(map[string]Amount)
+	for _, bond := range s.bonds {
+		if bond.Delegator == bond.Validator {
+			selfStake[bond.Validator] += bond.Amount
+		}
+		totalStake[bond.Validator] += bond.Amount
+	}
+
+	for validator, stake := range totalStake {
+		if _, slashed := s.slashings[validator]; slashed {
+			continue
+		}
+		if self := selfStake[validator]; self <= 0 || self < s.params.MinStake {
+			continue
+		}
+		set.Validators[validator] = stake
+		set.TotalStake += stake
+	}
+	return set
+}
+
+// applyStakingTransaction applies a stake, delegate or unstake transaction.
+// Must be called with the lock held.
+func (s *State) applyStakingTransaction(tx *Transaction, height uint64) error {
+	switch tx.Type {
+	case StakeTransaction:
+		if tx.From != tx.To {
+			return errors.New("stake must be bonded to the sender")
+		}
+		return s.bond(tx)
+
+	case DelegateTransaction:
+		if tx.From == tx.To {
+			return errors.New("use a stake transaction to bond to yourself")
+		}
+		if self, exists := s.bonds[bondKey(tx.To, tx.To)]; !exists || self.Amount <= 0 {
+			return fmt.Errorf("%s is not a validator", tx.To)
+		}
+		return s.bond(tx)
+
+	case UnstakeTransaction:
+		key := bondKey(tx.From, tx.To)
+		bond, exists := s.bonds[key]
+		if !exists {
+			return fmt.Errorf("%s has no stake bonded to %s", tx.From, tx.To)
+		}
+		if tx.Amount > bond.Amount {
+			return fmt.Errorf("cannot unbond %s, only %s is bonded", tx.Amount, bond.Amount)
+		}
+		if err := s.debit(tx.From, tx.Fee); err != nil {
+			return err
+		}
+
+		bond.Amount -= tx.Amount
+		if bond.Amount <= 0 {
+			delete(s.bonds, key)
+		}
+		s.unbondings = append(s.unbondings, &Unbonding{
+			Delegator:        tx.From,
+			Validator:        tx.To,
+			Amount:           tx.Amount,
+			CreationHeight:   height,
+			CompletionHeight: height + s.params.UnbondingPeriod,
+		})
+		return nil
+	}
+
+	return fmt.Errorf("unsupported staking transaction type %d", tx.Type)
+}
+
+// bond moves a transaction's amount from the sender's balance into its bond
+// to the recipient. Must be called with the lock held.
+func (s *State) bond(tx *Transaction) error {
+	if _, slashed := s.slashings[tx.To]; slashed {
+		return fmt.Errorf("validator %s has been slashed", tx.To)
+	}
+	if err := s.debitWithFee(tx.From, tx.Amount, tx.Fee); err != nil {
+		return err
+	}
+
+	key := bondKey(tx.From, tx.To)
+	bond, exists := s.bonds[key]
+	if !exists {
+		bond = &Bond{Delegator: tx.From, Validator: tx.To}
+		s.bonds[key] = bond
+	}
+	bond.Amount += tx.Amount
+	return nil
+}
+
+// slashStake burns SlashFraction of the bonds and unbondings backing a
+// validator, removes it from the validator set and returns the amount
+// burned. Must be called with the lock held.
+func (s *State) slashStake(validator string) Amount {
+	var burned Amount
+	for key, bond := range s.bonds {
+		if bond.Validator != validator {
+			continue
+		}
+		cut := s.slashCut(bond.Amount)
+		bond.Amount -= cut
+		burned += cut
+		if bond.Amount <= 0 {
+			delete(s.bonds, key)
+		}
+	}
+	for _, unbonding := range s.unbondings {
+		if unbonding.Validator != validator {
+			continue
+		}
+		cut := s.slashCut(unbonding.Amount)
+		unbonding.Amount -= cut
+		burned += cut
+	}
+
+	if stake, active := s.validatorSet.Validators[validator]; active {
+		delete(s.validatorSet.Validators, validator)
+		s.validatorSet.TotalStake -= stake
+	}
+	return burned
+}
+
+// slashCut returns the share of a stake burned by a slashing, never more
+// than the stake itself
+func (s *State) slashCut(stake Amount) Amount {
+	cut, err := stake.Fraction(s.params.SlashFraction)
+	if err != nil || cut > stake {
+		return stake
+	}
+	return cut
+}
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"lscc/utils"
+)
+
+// newStakingState returns a state with epochs of 10 blocks, a minimum self
+// stake of 50 coins and an unbonding period of 5 blocks, in which alice,
+// bob and carol hold 100 coins each
+func newStakingState(t *testing.T) *State {
+	t.Helper()
+	state := NewState(StateParams{
+		MinStake:        utils.Coins(50),
+		EpochLength:     10,
+		UnbondingPeriod: 5,
+		SlashFraction:   0.5,
+	})
+	for _, address := range []string{"alice", "bob", "carol"} {
+		if err := state.Credit(address, utils.Coins(100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return state
+}
+
+// applyStaking applies a staking transaction of coins with a fee of 1 coin
+func applyStaking(state *State, txType TransactionType, from, to string, coins int64, height uint64) error {
+	var tx *Transaction
+	var err error
+	switch txType {
+	case StakeTransaction:
+		// Built by hand, as a stake names its sender as the validator
+		tx, err = NewTransaction(from, to, utils.Coins(coins), utils.Coins(1), 0, 0, 0, StakeTransaction)
+	case DelegateTransaction:
+		tx, err = NewDelegateTransaction(from, to, utils.Coins(coins), utils.Coins(1), 0)
+	default:
+		tx, err = NewUnstakeTransaction(from, to, utils.Coins(coins), utils.Coins(1), 0)
+	}
+	if err != nil {
+		return err
+	}
+	return state.ApplyTransaction(tx, height)
+}
+
+func TestStakingChecks(t *testing.T) {
+	state := newStakingState(t)
+	tests := []struct {
+		name   string
+		txType TransactionType
+		from   string
+		to     string
+		coins  int64
+		want   string
+	}{
+		{"stake for another", StakeTransaction, "alice", "bob", 10, "bonded to the sender"},
+		{"delegation to yourself", DelegateTransaction, "alice", "alice", 10, "stake transaction"},
+		{"delegation to a non-validator", DelegateTransaction, "alice", "bob", 10, "not a validator"},
+		{"stake beyond the balance", StakeTransaction, "alice", "alice", 100, ErrInsufficientBalance.Error()},
+		{"unstake without a bond", UnstakeTransaction, "alice", "alice", 10, "no stake"},
+	}
+	for _, tt := range tests {
+		if err := applyStaking(state, tt.txType, tt.from, tt.to, tt.coins, 1); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	if err := applyStaking(state, StakeTransaction, "alice", "alice", 60, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyStaking(state, UnstakeTransaction, "alice", "alice", 61, 2); err == nil {
+		t.Error("unbonded more than the bond")
+	}
+}
+
+func TestValidatorSetChangesAtEpochs(t *testing.T) {
+	state := newStakingState(t)
+	if err := applyStaking(state, StakeTransaction, "alice", "alice", 60, 1); err != nil {
+		t.Fatal(err)
+	}
+	// bob's own stake is below the minimum, however much is delegated to him
+	if err := applyStaking(state, StakeTransaction, "bob", "bob", 10, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyStaking(state, DelegateTransaction, "carol", "bob", 90, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyStaking(state, DelegateTransaction, "carol", "alice", 5, 1); err != nil {
+		t.Fatalf("delegation refused: %v", err)
+	}
+
+	state.BeginBlock(9)
+	if set := state.GetValidatorSet(); len(set.Validators) != 0 {
+		t.Fatalf("validator set changed within the epoch: %v", set.Validators)
+	}
+
+	state.BeginBlock(10)
+	set := state.GetValidatorSet()
+	if set.Epoch != 1 || set.Height != 10 {
+		t.Errorf("set of epoch %d at height %d, want epoch 1 at 10", set.Epoch, set.Height)
+	}
+	if len(set.Validators) != 1 || set.Validators["alice"] != utils.Coins(65) || set.TotalStake != utils.Coins(65) {
+		t.Errorf("validators = %v with %s in total, want alice with 65", set.Validators, set.TotalStake)
+	}
+
+	validators := state.GetValidators()
+	if len(validators) != 2 || validators[0].Address != "bob" {
+		t.Fatalf("validators = %d, want bob first by stake", len(validators))
+	}
+	bob, alice := validators[0], validators[1]
+	if bob.SelfStake != utils.Coins(10) || bob.DelegatedStake != utils.Coins(90) || bob.Active {
+		t.Errorf("bob = %+v, want 10 own and 90 delegated, inactive", bob)
+	}
+	if alice.TotalStake != utils.Coins(65) || alice.Delegations["carol"] != utils.Coins(5) || !alice.Active {
+		t.Errorf("alice = %+v, want 65 with 5 from carol, active", alice)
+	}
+}
+
+func TestUnbondingPaysOutAfterPeriod(t *testing.T) {
+	state := newStakingState(t)
+	if err := applyStaking(state, StakeTransaction, "alice", "alice", 60, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyStaking(state, UnstakeTransaction, "alice", "alice", 20, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	unbondings := state.GetUnbondings()
+	if len(unbondings) != 1 || unbondings[0].Amount != utils.Coins(20) || unbondings[0].CompletionHeight != 8 {
+		t.Fatalf("unbondings = %+v, want 20 completing at height 8", unbondings)
+	}
+	if validators := state.GetValidators(); validators[0].SelfStake != utils.Coins(40) {
+		t.Errorf("self stake = %s, want the unbonded stake gone at once", validators[0].SelfStake)
+	}
+	state.BeginBlock(7)
+	if balance := state.GetBalance("alice"); balance != utils.Coins(38) {
+		t.Errorf("alice = %s before the unbonding completes, want 38", balance)
+	}
+
+	state.BeginBlock(8)
+	if balance := state.GetBalance("alice"); balance != utils.Coins(58) {
+		t.Errorf("alice = %s after the unbonding completes, want 58", balance)
+	}
+	if unbondings := state.GetUnbondings(); len(unbondings) != 0 {
+		t.Errorf("%d unbondings left", len(unbondings))
+	}
+}
+
+func TestSlashStakeBurnsBondsAndUnbondings(t *testing.T) {
+	state := newStakingState(t)
+	if err := applyStaking(state, StakeTransaction, "alice", "alice", 80, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyStaking(state, DelegateTransaction, "carol", "alice", 40, 1); err != nil {
+		t.Fatal(err)
+	}
+	state.BeginBlock(10)
+	if err := applyStaking(state, UnstakeTransaction, "carol", "alice", 10, 11); err != nil {
+		t.Fatal(err)
+	}
+
+	state.mu.Lock()
+	burned := state.slashStake("alice")
+	state.mu.Unlock()
+
+	// Half of alice's 80, carol's 30 bonded and 10 unbonding
+	if burned != utils.Coins(60) {
+		t.Errorf("burned %s, want 60", burned)
+	}
+	if set := state.GetValidatorSet(); len(set.Validators) != 0 || set.TotalStake != 0 {
+		t.Errorf("slashed validator left in the set: %v", set.Validators)
+	}
+	if unbondings := state.GetUnbondings(); len(unbondings) != 1 || unbondings[0].Amount != utils.Coins(5) {
+		t.Errorf("unbondings = %+v, want 5 left", unbondings)
+	}
+	if validators := state.GetValidators(); len(validators) != 1 || validators[0].TotalStake != utils.Coins(55) {
+		t.Errorf("validators = %+v, want alice with 55 left", validators)
+	}
+
+	// Once recorded as slashed, no stake can be bonded to the validator
+	state.slashings["alice"] = &Slashing{}
+	if err := applyStaking(state, DelegateTransaction, "bob", "alice", 10, 12); err == nil || !strings.Contains(err.Error(), "slashed") {
+		t.Errorf("delegation to a slashed validator: err = %v", err)
+	}
+}
@@ -0,0 +1,309 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"lscc/utils"
+)
+
+// ErrInsufficientBalance is returned when an account cannot cover a debit
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
+// StateParams holds the chain parameters that govern state transitions
+type StateParams struct {
+	ChannelChallengePeriod uint64  // Blocks a closing channel can be disputed
+	MinStake               Amount  // Own stake a validator needs to join the validator set
+	EpochLength            uint64  // Blocks between validator set updates
+	UnbondingPeriod        uint64  // Blocks unbonded stake stays locked
+	SlashFraction          float64 // Share of a slashed validator's stake that is burned
+	BlockReward            Amount  // Maximum minted by a block's coinbase
+	RelayRewardShare       float64 // Share of cross-shard fees a coinbase may pay relays
+	GasPrice               Amount  // Minimum fee per unit of contract gas
+	MaxContractGas         uint64  // Largest gas limit of a contract transaction; 0 disables contracts
+}
+
+// State holds the account balances, on-chain channel records, hash locks,
+// slashing records, stake, contracts and supply accounting of a shard
+type State struct {
+	params       StateParams
+	balances     map[string]Amount
+	channels     map[string]*PaymentChannel
+	locks        map[string]*HashLock
+	slashings    map[string]*Slashing // By offender
+	keys         map[string]string    // Registered public keys, by address
+	bonds        map[string]*Bond     // By delegator and validator
+	unbondings   []*Unbonding
+	contracts    map[string]*Contract
+	receipts     map[string]*ContractReceipt // By transaction hash
+	outbox       map[string]*ContractMessage // Messages sent from the shard, by ID
+	delivered    map[string]*MessageDelivery // Messages delivered to the shard, by ID
+	credited     map[string]string           // Credit paying out each debit from another shard, by debit hash
+	validatorSet *ValidatorSet
+	supply       SupplyInfo
+	mu           sync.RWMutex
+}
+
+// NewState creates an empty state
+func NewState(params StateParams) *State {
+	return &State{
+		params:       params,
+		balances:     make(map[string]Amount),
+		channels:     make(map[string]*PaymentChannel),
+		locks:        make(map[string]*HashLock),
+		slashings:    make(map[string]*Slashing),
+		keys:         make(map[string]string),
+		bonds:        make(map[string]*Bond),
+		contracts:    make(map[string]*Contract),
+		receipts:     make(map[string]*ContractReceipt),
+		outbox:       make(map[string]*ContractMessage),
+		delivered:    make(map[string]*MessageDelivery),
+		credited:     make(map[string]string),
+		validatorSet: &ValidatorSet{Validators: make(map[string]Amount)},
+	}
+}
+
+// Copy returns a deep copy of the state, used to trial-apply transactions
+func (s *State) Copy() *State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cp := NewState(s.params)
+	for addr, balance := range s.balances {
+		cp.balances[addr] = balance
+	}
+	for id, ch := range s.channels {
+		chCopy := *ch
+		cp.channels[id] = &chCopy
+	}
+	for id, lock := range s.locks {
+		lockCopy := *lock
+		cp.locks[id] = &lockCopy
+	}
+	for offender, slashing := range s.slashings {
+		slashingCopy := *slashing
+		cp.slashings[offender] = &slashingCopy
+	}
+	for address, publicKey := range s.keys {
+		cp.keys[address] = publicKey
+	}
+	for key, bond := range s.bonds {
+		bondCopy := *bond
+		cp.bonds[key] = &bondCopy
+	}
+	for _, unbonding := range s.unbondings {
+		unbondingCopy := *unbonding
+		cp.unbondings = append(cp.unbondings, &unbondingCopy)
+	}
+	for address, contract := range s.contracts {
+		cp.contracts[address] = contract.copy()
+	}
+	// Receipts, messages, deliveries and credits are never modified once
+	// written
+	for hash, receipt := range s.receipts {
+		cp.receipts[hash] = receipt
+	}
+	for id, msg := range s.outbox {
+		cp.outbox[id] = msg
+	}
+	for id, delivery := range s.delivered {
+		cp.delivered[id] = delivery
+	}
+	for hash, credit := range s.credited {
+		cp.credited[hash] = credit
+	}
+	setCopy := *s.validatorSet
+	setCopy.Validators = make(map[string]Amount, len(s.validatorSet.Validators))
+	for validator, stake := range s.validatorSet.Validators {
+		setCopy.Validators[validator] = stake
+	}
+	cp.validatorSet = &setCopy
+	cp.supply = s.supply
+	return cp
+}
+
+// GetBalance returns the balance of an account
+func (s *State) GetBalance(address string) Amount {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.balances[address]
+}
+
+// Credit adds genesis funds to an account
+func (s *State) Credit(address string, amount Amount) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.credit(address, amount); err != nil {
+		return err
+	}
+	s.supply.Genesis += amount
+	return nil
+}
+
+// CanDebit checks if an account can cover the given amount
+func (s *State) CanDebit(address string, amount Amount) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.balances[address] >= amount
+}
+
+// credit adds funds to an account. Must be called with the lock held.
+func (s *State) credit(address string, amount Amount) error {
+	balance, err := s.balances[address].Add(amount)
+	if err != nil {
+		return err
+	}
+	s.balances[address] = balance
+	return nil
+}
+
+// debit removes funds from an account. Must be called with the lock held.
+func (s *State) debit(address string, amount Amount) error {
+	if amount < 0 {
+		return fmt.Errorf("cannot debit negative amount %s", amount)
+	}
+	if s.balances[address] < amount {
+		return fmt.Errorf("%w: %s has %s, needs %s", ErrInsufficientBalance, address, s.balances[address], amount)
+	}
+	s.balances[address] -= amount
+	return nil
+}
+
+// debitWithFee removes an amount and a fee from an account. Must be called
+// with the lock held.
+func (s *State) debitWithFee(address string, amount, fee Amount) error {
+	total, err := amount.Add(fee)
+	if err != nil {
+		return err
+	}
+	return s.debit(address, total)
+}
+
+// ApplyTransaction applies a transaction included in a block at the given
+// height. The state is left untouched if the transaction cannot be applied.
+func (s *State) ApplyTransaction(tx *Transaction, height uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch tx.Type {
+	case ChannelOpenTransaction, ChannelCloseTransaction,
+		ChannelDisputeTransaction, ChannelSettleTransaction:
+		return s.applyChannelTransaction(tx, height)
+	case HTLCLockTransaction, HTLCClaimTransaction, HTLCRefundTransaction:
+		return s.applyHTLCTransaction(tx, height)
+	case EvidenceTransaction:
+		return s.applyEvidenceTransaction(tx, height)
+	case StakeTransaction, DelegateTransaction, UnstakeTransaction:
+		return s.applyStakingTransaction(tx, height)
+	case ContractDeployTransaction, ContractCallTransaction:
+		return s.applyContractTransaction(tx, height)
+	case ContractMessageTransaction:
+		return s.applyContractMessage(tx, height)
+	case ConsensusTransaction:
+		return errors.New("coinbase transactions are only valid at the end of a block")
+	}
+
+	// Credits delivered from another shard or layer were already debited at
+	// their source
+	if tx.IsIncomingCredit() {
+		return s.applyCredit(tx)
+	}
+
+	if tx.IsCrossShard() {
+		// Outgoing cross-shard transfers are credited by the target shard
+		if err := s.debitWithFee(tx.From, tx.Amount, tx.Fee); err != nil {
+			return err
+		}
+		s.supply.TransferredOut += tx.Amount
+		return nil
+	}
+
+	if err := s.debitWithFee(tx.From, tx.Amount, tx.Fee); err != nil {
+		return err
+	}
+	return s.credit(tx.To, tx.Amount)
+}
+
+// ApplyTransactions applies a block's transactions atomically, between the
+// block's start-of-block transitions and its coinbase: either all of them
+// are applied or the state is left untouched
+func (s *State) ApplyTransactions(txs []Transaction, height uint64) error {
+	trial := s.Copy()
+	trial.BeginBlock(height)
+
+	var coinbase *Transaction
+	for i := range txs {
+		if txs[i].Type == ConsensusTransaction {
+			if i != len(txs)-1 {
+				return fmt.Errorf("transaction %s: coinbase must be the last transaction of a block", txs[i].Hash)
+			}
+			coinbase = &txs[i]
+			continue
+		}
+		if err := trial.ApplyTransaction(&txs[i], height); err != nil {
+			return fmt.Errorf("transaction %s: %w", txs[i].Hash, err)
+		}
+	}
+	if err := trial.endBlock(coinbase, height, CollectFees(txs)); err != nil {
+		if coinbase != nil {
+			return fmt.Errorf("transaction %s: %w", coinbase.Hash, err)
+		}
+		return err
+	}
+
+	s.replaceWith(trial)
+	return nil
+}
+
+// replaceWith takes over the contents of another state
+func (s *State) replaceWith(other *State) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.balances = other.balances
+	s.channels = other.channels
+	s.locks = other.locks
+	s.slashings = other.slashings
+	s.keys = other.keys
+	s.bonds = other.bonds
+	s.unbondings = other.unbondings
+	s.contracts = other.contracts
+	s.receipts = other.receipts
+	s.outbox = other.outbox
+	s.delivered = other.delivered
+	s.credited = other.credited
+	s.validatorSet = other.validatorSet
+	s.supply = other.supply
+}
+
+// GetSupply returns the total of all account balances, channel deposits,
+// locked funds and stake, or utils.ErrAmountOverflow if it exceeds an
+// Amount
+func (s *State) GetSupply() (Amount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	held := make([]Amount, 0, len(s.balances)+len(s.channels)+len(s.locks)+len(s.bonds)+len(s.unbondings))
+	for _, balance := range s.balances {
+		held = append(held, balance)
+	}
+	for _, ch := range s.channels {
+		if ch.Status != ChannelSettled {
+			held = append(held, ch.Deposit)
+		}
+	}
+	for _, lock := range s.locks {
+		if lock.Status == LockActive {
+			held = append(held, lock.Amount)
+		}
+	}
+	for _, bond := range s.bonds {
+		held = append(held, bond.Amount)
+	}
+	for _, unbonding := range s.unbondings {
+		held = append(held, unbonding.Amount)
+	}
+	return utils.SumAmounts(held...)
+}
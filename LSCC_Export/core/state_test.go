@@ -0,0 +1,31 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"lscc/utils"
+)
+
+func TestGetSupplyOverflow(t *testing.T) {
+	state := NewState(StateParams{})
+	for _, address := range []string{"alice", "bob"} {
+		if err := state.Credit(address, utils.Coins(10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if total, err := state.GetSupply(); err != nil || total != utils.Coins(20) {
+		t.Fatalf("supply = %s, %v; want 20", total, err)
+	}
+
+	// Each balance fits, but not their total
+	if err := state.Credit("carol", utils.MaxAmount-utils.Coins(10)); err != nil {
+		t.Fatal(err)
+	}
+	if total, err := state.GetSupply(); !errors.Is(err, utils.ErrAmountOverflow) {
+		t.Fatalf("supply = %s, %v; want %v", total, err, utils.ErrAmountOverflow)
+	}
+	if _, err := state.GetSupplyInfo(); !errors.Is(err, utils.ErrAmountOverflow) {
+		t.Errorf("supply info err = %v, want %v", err, utils.ErrAmountOverflow)
+	}
+}
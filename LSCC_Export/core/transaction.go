@@ -1,8 +1,6 @@
 package core
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"time"
 )
@@ -19,15 +17,44 @@ const (
 	ConsensusTransaction
 	// Layer-to-layer transaction
 	LayerTransaction
+	// Opens a payment channel, locking the sender's deposit
+	ChannelOpenTransaction
+	// Starts a channel's challenge period with a signed balance update
+	ChannelCloseTransaction
+	// Replaces a closing channel's state with a newer signed update
+	ChannelDisputeTransaction
+	// Pays out a channel after its challenge period
+	ChannelSettleTransaction
+	// Locks funds for a recipient behind a hashlock and timeout
+	HTLCLockTransaction
+	// Releases hashlocked funds to the recipient by revealing the preimage
+	HTLCClaimTransaction
+	// Returns hashlocked funds to the sender after the timeout
+	HTLCRefundTransaction
+	// Submits evidence of validator or relay misbehaviour
+	EvidenceTransaction
+	// Bonds the sender's funds to itself as a validator
+	StakeTransaction
+	// Bonds the sender's funds to a validator
+	DelegateTransaction
+	// Starts unbonding the sender's stake from a validator
+	UnstakeTransaction
+	// Deploys contract code, optionally endowing the contract
+	ContractDeployTransaction
+	// Calls a contract method, optionally attaching value
+	ContractCallTransaction
+	// Delivers a message from a contract in another shard
+	ContractMessageTransaction
 )
 
 // Transaction represents a transaction in the blockchain
 type Transaction struct {
 	Hash        string          `json:"hash"`
+	ChainID     string          `json:"chain_id"`
 	From        string          `json:"from"`
 	To          string          `json:"to"`
-	Amount      float64         `json:"amount"`
-	Fee         float64         `json:"fee"`
+	Amount      Amount          `json:"amount"`
+	Fee         Amount          `json:"fee"`
 	Data        []byte          `json:"data"`
 	Timestamp   int64           `json:"timestamp"`
 	Type        TransactionType `json:"type"`
@@ -40,8 +67,9 @@ type Transaction struct {
 }
 
 // NewTransaction creates a new transaction
-func NewTransaction(from, to string, amount, fee float64, sourceShard, targetShard, layer int, txType TransactionType) (*Transaction, error) {
+func NewTransaction(from, to string, amount, fee Amount, sourceShard, targetShard, layer int, txType TransactionType) (*Transaction, error) {
 	tx := &Transaction{
+		ChainID:     ChainID(),
 		From:        from,
 		To:          to,
 		Amount:      amount,
@@ -64,28 +92,37 @@ func NewTransaction(from, to string, amount, fee float64, sourceShard, targetSha
 	return tx, nil
 }
 
-// CalculateHash calculates the hash of the transaction
-func (tx *Transaction) CalculateHash() (string, error) {
-	// Create a temporary copy without the hash and signature fields
-	txCopy := *tx
-	txCopy.Hash = ""
-	txCopy.Signature = ""
+// newPayloadTransaction creates an intra-shard transaction carrying a JSON
+// payload in Data
+func newPayloadTransaction(from, to string, amount, fee Amount, shardID int, txType TransactionType, payload interface{}) (*Transaction, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
 
-	txJSON, err := json.Marshal(txCopy)
+	tx, err := NewTransaction(from, to, amount, fee, shardID, shardID, 0, txType)
+	if err != nil {
+		return nil, err
+	}
+	tx.Data = data
+	tx.Hash, err = tx.CalculateHash()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return tx, nil
+}
 
-	hasher := sha256.New()
-	hasher.Write(txJSON)
-	return hex.EncodeToString(hasher.Sum(nil)), nil
+// CalculateHash calculates the hash of the transaction from its canonical
+// signing payload
+func (tx *Transaction) CalculateHash() (string, error) {
+	return hashPayload(tx.SigningPayload()), nil
 }
 
 // Sign signs the transaction with the provided private key
 func (tx *Transaction) Sign(privateKey string) error {
 	// In a real implementation, this would use actual cryptographic signing
 	// For this example, we'll just set a dummy signature
-	tx.Signature = "signed:" + tx.Hash[:8] + ":" + privateKey[:8]
+	tx.Signature = "signed:" + tx.Hash[:8] + ":" + keyPrefix(privateKey)
 	return nil
 }
 
@@ -101,6 +138,33 @@ func (tx *Transaction) IsCrossShard() bool {
 	return tx.SourceShard != tx.TargetShard
 }
 
+// IsCrossLayer checks if the transaction moves value between layers.
+// Layer is the layer the transaction originates from; the destination
+// layer is implied by TargetShard.
+func (tx *Transaction) IsCrossLayer() bool {
+	return tx.Type == LayerTransaction
+}
+
+// IsIncomingCredit checks if the transaction credits value that was
+// already debited in another shard or layer. Such credits, and contract
+// message deliveries, are created by the sharding layer in the receiving
+// shard with SourceShard == TargetShard.
+func (tx *Transaction) IsIncomingCredit() bool {
+	return (tx.Type == CrossShardTransaction || tx.Type == LayerTransaction ||
+		tx.Type == ContractMessageTransaction) && tx.SourceShard == tx.TargetShard
+}
+
+// carriesValue checks if the transaction must move a positive amount
+func (tx *Transaction) carriesValue() bool {
+	switch tx.Type {
+	case ChannelCloseTransaction, ChannelDisputeTransaction, ChannelSettleTransaction,
+		HTLCClaimTransaction, HTLCRefundTransaction, EvidenceTransaction,
+		ContractDeployTransaction, ContractCallTransaction, ContractMessageTransaction:
+		return false
+	}
+	return true
+}
+
 // IsValid checks if the transaction is valid
 func (tx *Transaction) IsValid() bool {
 	// Check if the transaction has a valid signature
@@ -109,7 +173,13 @@ func (tx *Transaction) IsValid() bool {
 	}
 
 	// Check for valid amounts
-	if tx.Amount <= 0 || tx.Fee < 0 {
+	if tx.Amount < 0 || tx.Fee < 0 {
+		return false
+	}
+	if _, err := tx.Amount.Add(tx.Fee); err != nil {
+		return false
+	}
+	if tx.Amount == 0 && tx.carriesValue() {
 		return false
 	}
 
@@ -130,6 +200,14 @@ func (tx *Transaction) Confirm() {
 	tx.IsConfirmed = true
 }
 
+// keyPrefix returns the leading part of a key embedded in placeholder signatures
+func keyPrefix(key string) string {
+	if len(key) > 8 {
+		return key[:8]
+	}
+	return key
+}
+
 // UnmarshalTransaction deserializes a transaction from JSON
 func UnmarshalTransaction(data []byte) (*Transaction, error) {
 	var tx Transaction
@@ -5,9 +5,12 @@ import (
         "fmt"
         "os"
         "os/signal"
+        "path/filepath"
         "syscall"
 
         "lscc/config"
+        "lscc/consensus"
+        "lscc/core"
         "lscc/network"
         "lscc/sharding"
         "lscc/utils"
@@ -21,15 +24,42 @@ var (
         bootstrapIP = flag.String("bootstrap", "", "Bootstrap node IP:port")
         isRelay     = flag.Bool("relay", false, "Run as a relay node")
         shardID     = flag.Int("shard", -1, "Shard ID (-1 for automatic assignment)")
-        verbosity   = flag.Int("verbosity", 3, "Log verbosity (0-5)")
+        verbosity   = flag.Int("verbosity", 2, "Log verbosity (0 error to 4 trace); overrides the configured level")
 )
 
 func main() {
+        // Generate a local testnet instead of running a node
+        if len(os.Args) > 1 && os.Args[1] == "init" {
+                if err := runInit(os.Args[2:]); err != nil {
+                        fmt.Fprintln(os.Stderr, "init:", err)
+                        os.Exit(1)
+                }
+                return
+        }
+
+        // Benchmark an in-process network instead of running a node
+        if len(os.Args) > 1 && os.Args[1] == "bench" {
+                if err := runBench(os.Args[2:]); err != nil {
+                        fmt.Fprintln(os.Stderr, "bench:", err)
+                        os.Exit(1)
+                }
+                return
+        }
+
+        // Run Byzantine fault injection scenarios instead of running a node
+        if len(os.Args) > 1 && os.Args[1] == "byzantine" {
+                if err := runByzantine(os.Args[2:]); err != nil {
+                        fmt.Fprintln(os.Stderr, "byzantine:", err)
+                        os.Exit(1)
+                }
+                return
+        }
+
         flag.Parse()
 
         // Initialize logger
         utils.InitLogger(*verbosity)
-        logger := utils.GetLogger()
+        logger := utils.GetLogger().Named("node")
         logger.Info("Starting LSCC Node...")
 
         // Load configuration
@@ -44,6 +74,17 @@ func main() {
                 }
         }
 
+        // Apply the logging configuration; an explicit -verbosity wins
+        if err := utils.ConfigureLogging(cfg.Logging, cfg.DataDir); err != nil {
+                logger.Error("Invalid logging configuration", "error", err)
+                os.Exit(1)
+        }
+        flag.Visit(func(f *flag.Flag) {
+                if f.Name == "verbosity" {
+                        utils.InitLogger(*verbosity)
+                }
+        })
+        
         // Override config with command line args
         if *nodeID != "" {
                 cfg.NodeID = *nodeID
@@ -72,6 +113,23 @@ func main() {
                 logger.Info("Generated node ID", "id", id)
         }
 
+        // Apply the genesis, resolving its path against the config file
+        if cfg.GenesisFile != "" && !filepath.IsAbs(cfg.GenesisFile) {
+                cfg.GenesisFile = filepath.Join(filepath.Dir(*configFile), cfg.GenesisFile)
+        }
+        if err := cfg.InitGenesis(); err != nil {
+                logger.Error("Failed to load genesis", "error", err)
+                os.Exit(1)
+        }
+        core.SetChainID(cfg.ChainID)
+        logger.Info("Genesis applied", "chainID", cfg.ChainID, "genesisHash", cfg.GenesisHash)
+
+        // Check the consensus engine and its parameters before starting
+        if err := consensus.ValidateConfig(cfg); err != nil {
+                logger.Error("Invalid consensus configuration", "error", err)
+                os.Exit(1)
+        }
+
         // Create sharding manager
         shardManager := sharding.NewManager(cfg)
 
@@ -0,0 +1,440 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metrics
+//
+// A registry holds counters, gauges and histograms, each a family of series
+// told apart by label values, and writes them in the Prometheus text
+// exposition format. Values that are cheaper to read when scraped than to
+// keep up to date, like a pool size, are set by collect hooks run before
+// every write.
+
+// ContentType is the content type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Registry holds metric families
+type Registry struct {
+	families map[string]family
+	hooks    map[uint64]func()
+	nextHook uint64
+	mu       sync.Mutex
+}
+
+// family is a named set of series of one metric type
+type family interface {
+	write(w *bufio.Writer)
+}
+
+var (
+	defaultRegistry *Registry
+	defaultOnce     sync.Once
+)
+
+// Default returns the registry shared by a process's subsystems
+func Default() *Registry {
+	defaultOnce.Do(func() {
+		defaultRegistry = NewRegistry()
+	})
+	return defaultRegistry
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]family),
+		hooks:    make(map[uint64]func()),
+	}
+}
+
+// register adds a family, returning the one already registered under the
+// name if there is one. Registering a name twice with another type panics.
+func (r *Registry) register(name string, f family) family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, exists := r.families[name]; exists {
+		if fmt.Sprintf("%T", existing) != fmt.Sprintf("%T", f) {
+			panic(fmt.Sprintf("metric %s registered with another type", name))
+		}
+		return existing
+	}
+	r.families[name] = f
+	return f
+}
+
+// OnCollect adds a hook run before every write, returning a function that
+// removes it
+func (r *Registry) OnCollect(hook func()) func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextHook++
+	id := r.nextHook
+	r.hooks[id] = hook
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		delete(r.hooks, id)
+	}
+}
+
+// WriteText runs the collect hooks and writes every family, ordered by
+// name, in the text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	ids := make([]uint64, 0, len(r.hooks))
+	for id := range r.hooks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	hooks := make([]func(), 0, len(ids))
+	for _, id := range ids {
+		hooks = append(hooks, r.hooks[id])
+	}
+	r.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	families := make([]family, 0, len(names))
+	for _, name := range names {
+		families = append(families, r.families[name])
+	}
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+// desc describes a family and keeps its series by label values
+type desc struct {
+	name   string
+	help   string
+	labels []string
+}
+
+// key joins label values into a series key
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s takes %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// writeHeader writes the HELP and TYPE lines of a family
+func (d *desc) writeHeader(w *bufio.Writer, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, metricType)
+}
+
+// writeSample writes one sample line. extraName and extraValue give an
+// additional label, such as a histogram bucket's le, if extraName is set.
+func (d *desc) writeSample(w *bufio.Writer, suffix string, values []string, extraName, extraValue string, value float64) {
+	w.WriteString(d.name)
+	w.WriteString(suffix)
+	if len(values) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range d.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabel(values[i]))
+		}
+		if extraName != "" {
+			if len(values) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraName, escapeLabel(extraValue))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatValue(value))
+	w.WriteByte('\n')
+}
+
+// CounterVec is a family of counters
+type CounterVec struct {
+	desc
+	series map[string]*Counter
+	mu     sync.Mutex
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	values []string
+	value  float64
+	mu     sync.Mutex
+}
+
+// NewCounterVec registers a counter family with the given label names
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return r.register(name, &CounterVec{
+		desc:   desc{name: name, help: help, labels: labels},
+		series: make(map[string]*Counter),
+	}).(*CounterVec)
+}
+
+// With returns the counter with the given label values, creating it at
+// zero if needed
+func (v *CounterVec) With(values ...string) *Counter {
+	key := v.key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	counter, exists := v.series[key]
+	if !exists {
+		counter = &Counter{values: append([]string(nil), values...)}
+		v.series[key] = counter
+	}
+	return counter
+}
+
+// Inc adds one to a counter
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds a non-negative amount to a counter
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("counter cannot decrease")
+	}
+	c.mu.Lock()
+	c.value += delta
+	c.mu.Unlock()
+}
+
+// Value returns the current count
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.writeHeader(w, "counter")
+	for _, key := range sortedKeys(v.series) {
+		counter := v.series[key]
+		v.writeSample(w, "", counter.values, "", "", counter.Value())
+	}
+}
+
+// GaugeVec is a family of gauges
+type GaugeVec struct {
+	desc
+	series map[string]*Gauge
+	mu     sync.Mutex
+}
+
+// Gauge is a value that goes up and down
+type Gauge struct {
+	values []string
+	value  float64
+	mu     sync.Mutex
+}
+
+// NewGaugeVec registers a gauge family with the given label names
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return r.register(name, &GaugeVec{
+		desc:   desc{name: name, help: help, labels: labels},
+		series: make(map[string]*Gauge),
+	}).(*GaugeVec)
+}
+
+// With returns the gauge with the given label values, creating it at zero
+// if needed
+func (v *GaugeVec) With(values ...string) *Gauge {
+	key := v.key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	gauge, exists := v.series[key]
+	if !exists {
+		gauge = &Gauge{values: append([]string(nil), values...)}
+		v.series[key] = gauge
+	}
+	return gauge
+}
+
+// Set sets a gauge
+func (g *Gauge) Set(value float64) {
+	g.mu.Lock()
+	g.value = value
+	g.mu.Unlock()
+}
+
+// Add adds an amount, possibly negative, to a gauge
+func (g *Gauge) Add(delta float64) {
+	g.mu.Lock()
+	g.value += delta
+	g.mu.Unlock()
+}
+
+// Value returns the current value
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value
+}
+
+func (v *GaugeVec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.writeHeader(w, "gauge")
+	for _, key := range sortedKeys(v.series) {
+		gauge := v.series[key]
+		v.writeSample(w, "", gauge.values, "", "", gauge.Value())
+	}
+}
+
+// HistogramVec is a family of histograms sharing bucket bounds
+type HistogramVec struct {
+	desc
+	buckets []float64
+	series  map[string]*Histogram
+	mu      sync.Mutex
+}
+
+// Histogram counts observations into buckets
+type Histogram struct {
+	values  []string
+	buckets []float64
+	counts  []uint64 // Observations per bucket, not cumulative
+	count   uint64
+	sum     float64
+	mu      sync.Mutex
+}
+
+// NewHistogramVec registers a histogram family with the given upper bucket
+// bounds, in increasing order, and label names. A +Inf bucket is implied.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			panic(fmt.Sprintf("metric %s has unsorted buckets", name))
+		}
+	}
+	return r.register(name, &HistogramVec{
+		desc:    desc{name: name, help: help, labels: labels},
+		buckets: append([]float64(nil), buckets...),
+		series:  make(map[string]*Histogram),
+	}).(*HistogramVec)
+}
+
+// With returns the histogram with the given label values, creating it
+// empty if needed
+func (v *HistogramVec) With(values ...string) *Histogram {
+	key := v.key(values)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	histogram, exists := v.series[key]
+	if !exists {
+		histogram = &Histogram{
+			values:  append([]string(nil), values...),
+			buckets: v.buckets,
+			counts:  make([]uint64, len(v.buckets)+1),
+		}
+		v.series[key] = histogram
+	}
+	return histogram
+}
+
+// Observe adds an observation
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.buckets, value)
+
+	h.mu.Lock()
+	h.counts[i]++
+	h.count++
+	h.sum += value
+	h.mu.Unlock()
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.writeHeader(w, "histogram")
+	for _, key := range sortedKeys(v.series) {
+		h := v.series[key]
+		h.mu.Lock()
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += h.counts[i]
+			v.writeSample(w, "_bucket", h.values, "le", formatValue(bound), float64(cumulative))
+		}
+		v.writeSample(w, "_bucket", h.values, "le", "+Inf", float64(h.count))
+		v.writeSample(w, "_sum", h.values, "", "", h.sum)
+		v.writeSample(w, "_count", h.values, "", "", float64(h.count))
+		h.mu.Unlock()
+	}
+}
+
+// sortedKeys returns the keys of a series map in order
+func sortedKeys[T any](series map[string]T) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatValue formats a sample value
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// escapeHelp escapes a HELP text
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// escapeLabel escapes a label value
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+// DefBuckets are bucket bounds in seconds for durations from milliseconds
+// to a minute
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
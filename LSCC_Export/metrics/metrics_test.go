@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+// text returns the exposition of a registry
+func text(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounterVec("requests_total", "Requests served", "code")
+	requests.With("500").Inc()
+	requests.With("200").Add(2.5)
+	r.NewGaugeVec("temperature", "Current\ntemperature \\ in C").With().Set(-3)
+	latency := r.NewHistogramVec("latency_seconds", "Request latency", []float64{0.1, 1}, "path")
+	latency.With(`/a"b`).Observe(0.1)
+	latency.With(`/a"b`).Observe(0.5)
+	latency.With(`/a"b`).Observe(7)
+
+	want := `# HELP latency_seconds Request latency
+# TYPE latency_seconds histogram
+latency_seconds_bucket{path="/a\"b",le="0.1"} 1
+latency_seconds_bucket{path="/a\"b",le="1"} 2
+latency_seconds_bucket{path="/a\"b",le="+Inf"} 3
+latency_seconds_sum{path="/a\"b"} 7.6
+latency_seconds_count{path="/a\"b"} 3
+# HELP requests_total Requests served
+# TYPE requests_total counter
+requests_total{code="200"} 2.5
+requests_total{code="500"} 1
+# HELP temperature Current\ntemperature \\ in C
+# TYPE temperature gauge
+temperature -3
+`
+	if got := text(t, r); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegisterReturnsExistingFamily(t *testing.T) {
+	r := NewRegistry()
+	first := r.NewCounterVec("events_total", "Events", "type")
+	first.With("a").Inc()
+	if second := r.NewCounterVec("events_total", "Events", "type"); second != first || second.With("a").Value() != 1 {
+		t.Error("registering a name twice created a second family")
+	}
+
+	tests := []struct {
+		name string
+		f    func()
+	}{
+		{"registering a name with another type", func() { r.NewGaugeVec("events_total", "Events") }},
+		{"passing too few label values", func() { first.With() }},
+		{"decreasing a counter", func() { first.With("a").Add(-1) }},
+		{"giving unsorted buckets", func() { r.NewHistogramVec("sizes", "Sizes", []float64{2, 1}) }},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s did not panic", tt.name)
+				}
+			}()
+			tt.f()
+		}()
+	}
+}
+
+func TestCollectHooks(t *testing.T) {
+	r := NewRegistry()
+	gauge := r.NewGaugeVec("pool_size", "Pool size").With()
+	var order []int
+	r.OnCollect(func() { order = append(order, 1); gauge.Set(4) })
+	remove := r.OnCollect(func() { order = append(order, 2) })
+
+	if got := text(t, r); !strings.Contains(got, "pool_size 4\n") {
+		t.Errorf("exposition without the collected value:\n%s", got)
+	}
+	remove()
+	text(t, r)
+	if len(order) != 3 || order[0] != 1 || order[1] != 2 || order[2] != 1 {
+		t.Errorf("hooks ran in order %v, want [1 2 1]", order)
+	}
+}
@@ -0,0 +1,71 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"lscc/utils"
+)
+
+// logLevelRequest changes the level of a subsystem, or the default level if
+// Subsystem is empty. An empty Level makes the subsystem use the default
+// level again.
+type logLevelRequest struct {
+	Subsystem string `json:"subsystem"`
+	Level     string `json:"level"`
+}
+
+// requireLocal rejects requests that do not come from the node's own host.
+// Admin endpoints change how the node runs and are only served locally.
+func requireLocal(w http.ResponseWriter, r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return true
+		}
+	}
+	writeError(w, http.StatusForbidden, errors.New("admin endpoints are only served to local clients"))
+	return false
+}
+
+// handleLogLevel serves /admin/log-level: GET returns the levels in effect,
+// POST changes one
+func (n *Node) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		if requireLocal(w, r) {
+			writeJSON(w, http.StatusOK, utils.GetLogger().Levels())
+		}
+		return
+	}
+	if !requireMethod(w, r, http.MethodPost) || !requireLocal(w, r) {
+		return
+	}
+
+	var req logLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	logger := utils.GetLogger()
+	switch {
+	case req.Level == "" && req.Subsystem == "":
+		writeError(w, http.StatusBadRequest, errors.New("level is required"))
+		return
+	case req.Level == "":
+		logger.ResetLevel(req.Subsystem)
+	default:
+		level, err := utils.ParseLogLevel(req.Level)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		logger.SetLevel(req.Subsystem, level)
+	}
+
+	n.logger.Info("Log level changed", "subsystem", req.Subsystem, "level", req.Level)
+	writeJSON(w, http.StatusOK, logger.Levels())
+}
@@ -0,0 +1,521 @@
+package network
+
+import (
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"lscc/core"
+	"lscc/sharding"
+)
+
+// API v1
+//
+// The versioned API under /api/v1 serves the endpoints of the
+// specification's Appendix B. Every error has the body
+// {"error": {"code": "ERR00N", "message": "..."}}, using the codes of
+// Appendix A, extended with ERR009 to ERR011 for problems with the request
+// itself. Block lists are paginated with an opaque cursor. The node serves
+// an OpenAPI document of the API at /api/v1/openapi.json and checks at
+// startup that it documents exactly the routes served.
+
+const apiPrefix = "/api/v1"
+
+// Error codes of API responses
+const (
+	ErrCodeInvalidTransaction  = "ERR001" // Invalid transaction format
+	ErrCodeInsufficientBalance = "ERR002" // Insufficient balance
+	ErrCodeInvalidSignature    = "ERR003" // Invalid signature
+	ErrCodeInvalidBlock        = "ERR004" // Invalid block
+	ErrCodeShardMismatch       = "ERR005" // Shard mismatch or unknown shard
+	ErrCodeRelayFailure        = "ERR006" // Cross-shard relay failure
+	ErrCodeNetworkFailure      = "ERR007" // Network connection failure
+	ErrCodeConfiguration       = "ERR008" // Configuration error
+	ErrCodeNotFound            = "ERR009" // Unknown block, transaction or endpoint
+	ErrCodeInvalidRequest      = "ERR010" // Missing or malformed parameter
+	ErrCodeMethodNotAllowed    = "ERR011" // Method not allowed
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// apiError is the error body of API responses
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiRoute is an endpoint of the versioned API
+type apiRoute struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+}
+
+// apiBlock is a block with its hash
+type apiBlock struct {
+	Hash string `json:"hash"`
+	*core.Block
+}
+
+// apiPeer is a connected peer
+type apiPeer struct {
+	ID        string `json:"id"`
+	Address   string `json:"address"`
+	Connected bool   `json:"connected"`
+}
+
+// apiRoutes lists the endpoints of the versioned API, relative to apiPrefix
+func (n *Node) apiRoutes() []apiRoute {
+	return []apiRoute{
+		{http.MethodGet, "/node/status", n.handleAPINodeStatus},
+		{http.MethodGet, "/blockchain/info", n.handleAPIBlockchainInfo},
+		{http.MethodGet, "/blockchain/block", n.handleAPIBlock},
+		{http.MethodGet, "/blockchain/blocks", n.handleAPIBlocks},
+		{http.MethodGet, "/blockchain/transaction", n.handleAPITransaction},
+		{http.MethodPost, "/transaction/create", n.handleAPICreateTransaction},
+		{http.MethodGet, "/transaction/status", n.handleAPITransactionStatus},
+		{http.MethodGet, "/peers", n.handleAPIPeers},
+		{http.MethodGet, "/shards/info", n.handleAPIShards},
+		{http.MethodGet, "/openapi.json", n.handleAPISpec},
+	}
+}
+
+// registerAPI mounts the versioned API on a mux
+func (n *Node) registerAPI(mux *http.ServeMux) {
+	routes := n.apiRoutes()
+	if err := validateOpenAPI(openAPISpec, routes); err != nil {
+		n.logger.Warn("OpenAPI document does not match the API", "code", ErrCodeConfiguration, "error", err)
+	}
+
+	for _, route := range routes {
+		mux.HandleFunc(apiPrefix+route.path, apiHandler(route))
+	}
+	mux.HandleFunc(apiPrefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Errorf("unknown endpoint %s", r.URL.Path))
+	})
+}
+
+// apiHandler wraps a route's handler with its method check
+func apiHandler(route apiRoute) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			writeJSON(w, http.StatusOK, nil)
+			return
+		}
+		if r.Method != route.method {
+			writeAPIError(w, http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		route.handler(w, r)
+	}
+}
+
+// writeAPIError writes an API error response
+func writeAPIError(w http.ResponseWriter, status int, code string, err error) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": apiError{Code: code, Message: err.Error()},
+	})
+}
+
+// apiChain returns the chain of the shard named by the request's ?shard=N
+// parameter, writing an error response if there is none
+func (n *Node) apiChain(w http.ResponseWriter, r *http.Request) (*core.Blockchain, bool) {
+	chain, status, err := n.requestChain(r)
+	if err != nil {
+		code := ErrCodeShardMismatch
+		if status == http.StatusBadRequest {
+			code = ErrCodeInvalidRequest
+		}
+		writeAPIError(w, status, code, err)
+		return nil, false
+	}
+	return chain, true
+}
+
+// queryUint parses an optional unsigned integer query parameter
+func queryUint(r *http.Request, name string, fallback uint64) (uint64, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", name, value)
+	}
+	return parsed, nil
+}
+
+// encodeCursor and decodeCursor convert the height a page starts at to and
+// from the opaque cursor handed to clients
+func encodeCursor(height uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("height:" + strconv.FormatUint(height, 10)))
+}
+
+func decodeCursor(cursor string) (uint64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		if strings.HasPrefix(string(data), "height:") {
+			if parsed, err := strconv.ParseUint(strings.TrimPrefix(string(data), "height:"), 10, 64); err == nil {
+				return parsed, nil
+			}
+		}
+	}
+	return 0, errors.New("invalid cursor")
+}
+
+// newAPIBlock pairs a block with its hash
+func newAPIBlock(block *core.Block) (*apiBlock, error) {
+	hash, err := block.Hash()
+	if err != nil {
+		return nil, err
+	}
+	return &apiBlock{Hash: hash, Block: block}, nil
+}
+
+// handleAPINodeStatus returns the node status
+func (n *Node) handleAPINodeStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, n.GetStatus())
+}
+
+// handleAPIBlockchainInfo returns the height, tip and finality of a chain
+func (n *Node) handleAPIBlockchainInfo(w http.ResponseWriter, r *http.Request) {
+	chain, ok := n.apiChain(w, r)
+	if !ok {
+		return
+	}
+
+	info := map[string]interface{}{
+		"chain_id": chain.ChainID(),
+		"shard_id": chain.Config.ShardID,
+		"layer":    chain.Layer,
+		"height":   chain.GetHeight(),
+		"finality": chain.Finality.GetStatus(),
+		"pending":  len(chain.GetPendingTransactions()),
+	}
+	if latest := chain.GetLatestBlock(); latest != nil {
+		if hash, err := latest.Hash(); err == nil {
+			info["latest_hash"] = hash
+		}
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// handleAPIBlock returns a block by ?height=N or ?hash=H
+func (n *Node) handleAPIBlock(w http.ResponseWriter, r *http.Request) {
+	chain, ok := n.apiChain(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	var block *core.Block
+	switch {
+	case query.Get("hash") != "" && query.Get("height") != "":
+		writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidRequest, errors.New("give either height or hash, not both"))
+		return
+	case query.Get("hash") != "":
+		block = chain.GetBlockByHash(query.Get("hash"))
+	case query.Get("height") != "":
+		height, err := queryUint(r, "height", 0)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err)
+			return
+		}
+		block = chain.GetBlockByHeight(height)
+	default:
+		writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidRequest, errors.New("height or hash is required"))
+		return
+	}
+	if block == nil {
+		writeAPIError(w, http.StatusNotFound, ErrCodeNotFound, errors.New("block not found"))
+		return
+	}
+
+	result, err := newAPIBlock(block)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, ErrCodeInvalidBlock, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleAPIBlocks returns the blocks between ?from=N and ?to=N, inclusive,
+// a page of ?limit=N at a time. The next page is requested with the
+// returned cursor.
+func (n *Node) handleAPIBlocks(w http.ResponseWriter, r *http.Request) {
+	chain, ok := n.apiChain(w, r)
+	if !ok {
+		return
+	}
+
+	tip := chain.GetHeight()
+	from, err := queryUint(r, "from", 0)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err)
+		return
+	}
+	to, err := queryUint(r, "to", tip)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err)
+		return
+	}
+	limit, err := queryUint(r, "limit", defaultPageSize)
+	if err != nil || limit == 0 || limit > maxPageSize {
+		writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Errorf("limit must be between 1 and %d", maxPageSize))
+		return
+	}
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		if from, err = decodeCursor(cursor); err != nil {
+			writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidRequest, err)
+			return
+		}
+	}
+	if to > tip {
+		to = tip
+	}
+
+	blocks := []*apiBlock{}
+	if from <= to {
+		count := to - from + 1
+		if count > limit {
+			count = limit
+		}
+		for _, block := range chain.GetBlockRange(from, int(count)) {
+			result, err := newAPIBlock(block)
+			if err != nil {
+				writeAPIError(w, http.StatusInternalServerError, ErrCodeInvalidBlock, err)
+				return
+			}
+			blocks = append(blocks, result)
+		}
+	}
+
+	page := map[string]interface{}{
+		"blocks": blocks,
+		"count":  len(blocks),
+		"height": tip,
+	}
+	if len(blocks) > 0 {
+		if next := blocks[len(blocks)-1].Header.Height + 1; next <= to {
+			page["next_cursor"] = encodeCursor(next)
+		}
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleAPITransaction returns a transaction by ?hash=H with its status
+func (n *Node) handleAPITransaction(w http.ResponseWriter, r *http.Request) {
+	chain, ok := n.apiChain(w, r)
+	if !ok {
+		return
+	}
+
+	hash := r.URL.Query().Get("hash")
+	if hash == "" {
+		writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidRequest, errors.New("hash is required"))
+		return
+	}
+	txStatus, exists := n.lookupTransaction(chain, hash)
+	if !exists {
+		writeAPIError(w, http.StatusNotFound, ErrCodeNotFound, errors.New("transaction not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, txStatus)
+}
+
+// handleAPITransactionStatus returns the receipt of a transaction by ?hash=H
+func (n *Node) handleAPITransactionStatus(w http.ResponseWriter, r *http.Request) {
+	chain, ok := n.apiChain(w, r)
+	if !ok {
+		return
+	}
+
+	hash := r.URL.Query().Get("hash")
+	if hash == "" {
+		writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidRequest, errors.New("hash is required"))
+		return
+	}
+	receipt, exists := n.lookupReceipt(chain, hash)
+	if !exists {
+		writeAPIError(w, http.StatusNotFound, ErrCodeNotFound, errors.New("transaction not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, receipt)
+}
+
+// handleAPICreateTransaction accepts a signed transaction and returns its
+// receipt
+func (n *Node) handleAPICreateTransaction(w http.ResponseWriter, r *http.Request) {
+	var tx core.Transaction
+	if err := json.NewDecoder(r.Body).Decode(&tx); err != nil {
+		writeAPIError(w, http.StatusBadRequest, ErrCodeInvalidTransaction, fmt.Errorf("invalid transaction data: %w", err))
+		return
+	}
+
+	receipt, code, err := n.acceptTransaction(&tx)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, code, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, receipt)
+}
+
+// acceptTransaction submits a transaction received through the versioned
+// APIs and returns its receipt, or the error code and error it was rejected
+// with
+func (n *Node) acceptTransaction(tx *core.Transaction) (*core.Receipt, string, error) {
+	if !tx.VerifySignature() {
+		return nil, ErrCodeInvalidSignature, errors.New("invalid signature")
+	}
+	if !tx.IsCrossShard() && tx.SourceShard != n.Config.ShardID {
+		return nil, ErrCodeShardMismatch,
+			fmt.Errorf("transaction belongs to shard %d, this node serves shard %d", tx.SourceShard, n.Config.ShardID)
+	}
+
+	if err := n.SubmitTransaction(tx); err != nil {
+		n.logger.Warn("Rejected transaction", "txHash", tx.Hash, "error", err)
+		return nil, submitErrorCode(tx, err), err
+	}
+
+	receipt, exists := n.lookupReceipt(n.Blockchain, tx.Hash)
+	if !exists {
+		receipt = &core.Receipt{TxHash: tx.Hash, Status: core.TxPending, ShardID: tx.SourceShard, Index: -1}
+	}
+	return receipt, "", nil
+}
+
+// submitErrorCode classifies why a transaction was rejected
+func submitErrorCode(tx *core.Transaction, err error) string {
+	switch {
+	case errors.Is(err, core.ErrInsufficientBalance):
+		return ErrCodeInsufficientBalance
+	case errors.Is(err, sharding.ErrUnknownShard):
+		return ErrCodeShardMismatch
+	case tx.IsCrossShard():
+		return ErrCodeRelayFailure
+	}
+	return ErrCodeInvalidTransaction
+}
+
+// handleAPIPeers lists the node's peers
+func (n *Node) handleAPIPeers(w http.ResponseWriter, r *http.Request) {
+	peers := []apiPeer{}
+	for _, peer := range n.GetPeers() {
+		peers = append(peers, apiPeer{ID: peer.ID, Address: peer.Address, Connected: peer.IsConnected()})
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"peers": peers,
+		"count": len(peers),
+	})
+}
+
+// handleAPIShards returns the status of every shard and of the cross-shard
+// services
+func (n *Node) handleAPIShards(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, n.ShardManager.GetStatus())
+}
+
+// handleAPISpec serves the OpenAPI document of the API
+func (n *Node) handleAPISpec(w http.ResponseWriter, r *http.Request) {
+	if !json.Valid(openAPISpec) {
+		writeAPIError(w, http.StatusInternalServerError, ErrCodeConfiguration, errors.New("invalid OpenAPI document"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(openAPISpec)
+}
+
+// validateOpenAPI checks that an OpenAPI document describes exactly the
+// given routes, that every operation documents its error responses and that
+// every component reference resolves
+func validateOpenAPI(spec []byte, routes []apiRoute) error {
+	var doc struct {
+		OpenAPI    string                                `json:"openapi"`
+		Servers    []struct{ URL string }                `json:"servers"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components map[string]map[string]json.RawMessage `json:"components"`
+	}
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return fmt.Errorf("invalid document: %w", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return fmt.Errorf("unsupported OpenAPI version %q", doc.OpenAPI)
+	}
+	if len(doc.Servers) == 0 || doc.Servers[0].URL != apiPrefix {
+		return fmt.Errorf("server URL must be %s", apiPrefix)
+	}
+	if _, exists := doc.Components["schemas"]["Error"]; !exists {
+		return errors.New("missing Error schema")
+	}
+
+	served := make(map[string]bool)
+	for _, route := range routes {
+		operation := strings.ToLower(route.method) + " " + route.path
+		served[operation] = true
+		if _, documented := doc.Paths[route.path][strings.ToLower(route.method)]; !documented {
+			return fmt.Errorf("%s is not documented", operation)
+		}
+	}
+	for path, operations := range doc.Paths {
+		for method, raw := range operations {
+			operation := method + " " + path
+			if !served[operation] {
+				return fmt.Errorf("%s is documented but not served", operation)
+			}
+			var op struct {
+				Responses map[string]json.RawMessage `json:"responses"`
+			}
+			if err := json.Unmarshal(raw, &op); err != nil {
+				return fmt.Errorf("%s: %w", operation, err)
+			}
+			if _, exists := op.Responses["default"]; !exists {
+				return fmt.Errorf("%s does not document its errors", operation)
+			}
+		}
+	}
+
+	var tree interface{}
+	json.Unmarshal(spec, &tree)
+	return checkRefs(tree, doc.Components)
+}
+
+// checkRefs checks that every $ref in a JSON tree names a known component
+func checkRefs(node interface{}, components map[string]map[string]json.RawMessage) error {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			if ref, isRef := child.(string); isRef && key == "$ref" {
+				section, name, _ := strings.Cut(strings.TrimPrefix(ref, "#/components/"), "/")
+				if _, exists := components[section][name]; !strings.HasPrefix(ref, "#/components/") || !exists {
+					return fmt.Errorf("unresolved reference %s", ref)
+				}
+				continue
+			}
+			if err := checkRefs(child, components); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range value {
+			if err := checkRefs(child, components); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
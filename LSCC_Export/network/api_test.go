@@ -0,0 +1,144 @@
+package network
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"lscc/utils"
+)
+
+// errorCodes are the error codes API responses carry
+var errorCodes = []string{
+	ErrCodeInvalidTransaction, ErrCodeInsufficientBalance, ErrCodeInvalidSignature,
+	ErrCodeInvalidBlock, ErrCodeShardMismatch, ErrCodeRelayFailure,
+	ErrCodeNetworkFailure, ErrCodeConfiguration, ErrCodeNotFound,
+	ErrCodeInvalidRequest, ErrCodeMethodNotAllowed,
+}
+
+// openAPIDocument is the part of the OpenAPI document the tests check
+type openAPIDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas struct {
+			Error struct {
+				Properties struct {
+					Error struct {
+						Properties struct {
+							Code struct {
+								Enum []string `json:"enum"`
+							} `json:"code"`
+						} `json:"properties"`
+					} `json:"error"`
+				} `json:"properties"`
+			} `json:"Error"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+// serveAPI returns a server of the versioned API of a node that has no
+// chain; only routing and the document are served meaningfully
+func serveAPI(t *testing.T) (*Node, *httptest.Server) {
+	t.Helper()
+	n := &Node{logger: utils.GetLogger()}
+	mux := http.NewServeMux()
+	n.registerAPI(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return n, server
+}
+
+// fetchOpenAPI fetches and decodes the served OpenAPI document
+func fetchOpenAPI(t *testing.T, server *httptest.Server) ([]byte, *openAPIDocument) {
+	t.Helper()
+	resp, err := http.Get(server.URL + apiPrefix + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET openapi.json: status %d", resp.StatusCode)
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		t.Fatalf("served document is not JSON: %v", err)
+	}
+	var doc openAPIDocument
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return raw, &doc
+}
+
+func TestOpenAPIDocumentsRoutes(t *testing.T) {
+	n, server := serveAPI(t)
+	raw, doc := fetchOpenAPI(t, server)
+
+	if err := validateOpenAPI(raw, n.apiRoutes()); err != nil {
+		t.Fatalf("served document does not match the API: %v", err)
+	}
+	for _, route := range n.apiRoutes() {
+		if _, documented := doc.Paths[route.path][strings.ToLower(route.method)]; !documented {
+			t.Errorf("%s %s%s is not documented", route.method, apiPrefix, route.path)
+		}
+	}
+
+	// A route served but left out of the document is caught
+	extra := append(n.apiRoutes(), apiRoute{http.MethodGet, "/undocumented", nil})
+	if err := validateOpenAPI(raw, extra); err == nil {
+		t.Error("undocumented route not reported")
+	}
+}
+
+func TestOpenAPIDocumentsErrorCodes(t *testing.T) {
+	_, server := serveAPI(t)
+	_, doc := fetchOpenAPI(t, server)
+
+	documented := append([]string(nil), doc.Components.Schemas.Error.Properties.Error.Properties.Code.Enum...)
+	codes := append([]string(nil), errorCodes...)
+	sort.Strings(documented)
+	sort.Strings(codes)
+	if strings.Join(documented, ",") != strings.Join(codes, ",") {
+		t.Fatalf("documented error codes %v, want %v", documented, codes)
+	}
+
+	// Errors the router itself writes carry documented codes
+	inEnum := make(map[string]bool)
+	for _, code := range documented {
+		inEnum[code] = true
+	}
+	for _, tc := range []struct {
+		method, path string
+		status       int
+		code         string
+	}{
+		{http.MethodGet, "/unknown", http.StatusNotFound, ErrCodeNotFound},
+		{http.MethodPost, "/openapi.json", http.StatusMethodNotAllowed, ErrCodeMethodNotAllowed},
+	} {
+		req, err := http.NewRequest(tc.method, server.URL+apiPrefix+tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Error apiError `json:"error"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s %s: error body: %v", tc.method, tc.path, err)
+		}
+		if resp.StatusCode != tc.status || body.Error.Code != tc.code {
+			t.Errorf("%s %s = %d %s, want %d %s", tc.method, tc.path, resp.StatusCode, body.Error.Code, tc.status, tc.code)
+		}
+		if !inEnum[body.Error.Code] {
+			t.Errorf("%s %s: code %s is not documented", tc.method, tc.path, body.Error.Code)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package network
+
+import (
+	"errors"
+
+	"lscc/core"
+)
+
+// StoreChannelUpdate keeps the latest co-signed off-chain update for a
+// channel so either party can later close or dispute with it
+func (n *Node) StoreChannelUpdate(update *core.ChannelUpdate) error {
+	ch, exists := n.Blockchain.State.GetChannel(update.ChannelID)
+	if !exists {
+		return errors.New("channel not found")
+	}
+	if ch.Status == core.ChannelSettled {
+		return errors.New("channel is settled")
+	}
+	if update.Sequence <= ch.Sequence {
+		return errors.New("update is older than the on-chain channel state")
+	}
+	if err := update.Verify(ch); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if latest, exists := n.channelUpdates[update.ChannelID]; exists && latest.Sequence >= update.Sequence {
+		return errors.New("a newer update is already stored")
+	}
+	n.channelUpdates[update.ChannelID] = update
+
+	n.logger.Info("Stored off-chain channel update",
+		"channelID", update.ChannelID,
+		"sequence", update.Sequence)
+	return nil
+}
+
+// GetChannelUpdate returns the latest off-chain update stored for a channel
+func (n *Node) GetChannelUpdate(channelID string) *core.ChannelUpdate {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.channelUpdates[channelID]
+}
@@ -0,0 +1,71 @@
+package network
+
+import (
+	"encoding/json"
+	"time"
+
+	"lscc/utils"
+)
+
+// maxSeenConsensusMessages bounds the set of consensus messages remembered
+// to stop gossip loops
+const maxSeenConsensusMessages = 10000
+
+// sendConsensusMessage wraps an engine's message for this node's shard and
+// broadcasts it to all peers
+func (n *Node) sendConsensusMessage(data []byte) error {
+	msg := ConsensusMessage{
+		Type:      string(n.Consensus.GetType()),
+		ShardID:   n.Config.ShardID,
+		NodeID:    n.ID,
+		Timestamp: time.Now().Unix(),
+		Data:      data,
+	}
+	n.markConsensusSeen(&msg)
+	n.broadcastMessage(MessageTypeConsensus, msg)
+	return nil
+}
+
+// handleConsensusMessage delivers a consensus message for this node's
+// shard and engine to the engine, and gossips messages not seen before to
+// the other peers
+func (n *Node) handleConsensusMessage(data json.RawMessage) error {
+	var msg ConsensusMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return err
+	}
+	if !n.markConsensusSeen(&msg) {
+		return nil
+	}
+
+	// Gossip on so members without a direct connection receive it
+	n.broadcastMessage(MessageTypeConsensus, msg)
+
+	if msg.ShardID != n.Config.ShardID {
+		return nil
+	}
+	if msg.Type != string(n.Consensus.GetType()) {
+		n.logger.Warn("Consensus message for another engine",
+			"type", msg.Type, "engine", n.Consensus.GetType(), "from", msg.NodeID)
+		return nil
+	}
+	return n.Consensus.HandleConsensusMessage(msg.Data)
+}
+
+// markConsensusSeen records a consensus message and reports whether it is
+// new
+func (n *Node) markConsensusSeen(msg *ConsensusMessage) bool {
+	id := utils.Hash(append([]byte(msg.Type+":"+msg.NodeID+":"), msg.Data...))
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.seenConsensus[id] {
+		return false
+	}
+	if len(n.seenConsensus) >= maxSeenConsensusMessages {
+		n.seenConsensus = make(map[string]bool)
+	}
+	n.seenConsensus[id] = true
+	return true
+}
@@ -0,0 +1,173 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"lscc/core"
+	"lscc/vm"
+)
+
+// contractQuery is the body of a read-only contract call
+type contractQuery struct {
+	Contract string     `json:"contract"`
+	Caller   string     `json:"caller"`
+	Method   string     `json:"method"`
+	Args     []vm.Value `json:"args,omitempty"`
+	GasLimit uint64     `json:"gas_limit,omitempty"`
+}
+
+// handleContracts lists the contracts deployed in a shard. Other shards are
+// inspected with ?shard=N.
+func (n *Node) handleContracts(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	chain, status, err := n.requestChain(r)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	contracts := chain.State.GetContracts()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"contracts": contracts,
+		"count":     len(contracts),
+	})
+}
+
+// handleContract returns a contract's record, storage and balance
+func (n *Node) handleContract(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	chain, status, err := n.requestChain(r)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	address := strings.TrimPrefix(r.URL.Path, "/contracts/")
+	contract, exists := chain.State.GetContract(address)
+	if !exists {
+		writeError(w, http.StatusNotFound, errors.New("contract not found"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"contract": contract,
+		"storage":  contract.Storage(),
+		"balance":  chain.State.GetBalance(address),
+	})
+}
+
+// handleContractQuery runs a contract method against the current state of
+// this node's shard without submitting a transaction
+func (n *Node) handleContractQuery(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var query contractQuery
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid query: %w", err))
+		return
+	}
+
+	height := n.Blockchain.GetHeight() + 1
+	receipt, err := n.Blockchain.State.QueryContract(query.Contract, query.Caller, query.Method, query.Args, query.GasLimit, height)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, receipt)
+}
+
+// handleReceipt returns the receipt of a contract transaction
+func (n *Node) handleReceipt(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	chain, status, err := n.requestChain(r)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	hash := strings.TrimPrefix(r.URL.Path, "/receipts/")
+	receipt, exists := chain.State.GetContractReceipt(hash)
+	if !exists {
+		writeError(w, http.StatusNotFound, errors.New("receipt not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, receipt)
+}
+
+// handleMessages lists the contract messages sent from a shard
+func (n *Node) handleMessages(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	chain, status, err := n.requestChain(r)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	messages := chain.State.GetContractMessages()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"messages": messages,
+		"count":    len(messages),
+	})
+}
+
+// handleMessage returns a contract message sent from a shard with its
+// delivery, the receipt of its delivery and, for a call, its callback
+func (n *Node) handleMessage(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	chain, status, err := n.requestChain(r)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/messages/")
+	msg, exists := chain.State.GetContractMessage(id)
+	if !exists {
+		writeError(w, http.StatusNotFound, errors.New("message not found"))
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": msg,
+		"status":  "pending",
+	}
+	target, err := n.ShardManager.GetShard(msg.TargetShard)
+	if err != nil {
+		// The call bounces back to its sender as a failed callback
+		response["status"] = "undeliverable"
+		if bounce, delivered := chain.State.GetMessageDelivery(core.CallbackID(msg.ID)); delivered {
+			response["callback_delivery"] = bounce
+		}
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+	if delivery, delivered := target.Blockchain.State.GetMessageDelivery(msg.ID); delivered {
+		response["status"] = "delivered"
+		response["delivery"] = delivery
+		if receipt, exists := target.Blockchain.State.GetContractReceipt(delivery.TxHash); exists {
+			response["receipt"] = receipt
+		}
+	}
+	if msg.Kind == core.MessageCall {
+		if callback, exists := target.Blockchain.State.GetContractMessage(core.CallbackID(msg.ID)); exists {
+			response["callback"] = callback
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,112 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"lscc/core"
+)
+
+// SubmitEvidence wraps evidence in a transaction from this node and submits
+// it to the local shard
+func (n *Node) SubmitEvidence(evidence *core.Evidence) (*core.Transaction, error) {
+	if err := n.Blockchain.State.VerifyEvidence(evidence); err != nil {
+		return nil, fmt.Errorf("invalid evidence: %w", err)
+	}
+
+	tx, err := core.NewEvidenceTransaction(n.ID, evidence, 0, n.Config.ShardID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Sign(n.ID); err != nil {
+		return nil, err
+	}
+	if err := n.SubmitTransaction(tx); err != nil {
+		return nil, err
+	}
+
+	n.logger.Warn("Submitted slashing evidence",
+		"offender", evidence.Offender,
+		"type", evidence.Type,
+		"txHash", tx.Hash)
+	return tx, nil
+}
+
+// reportEvidence submits evidence found while processing blocks or votes
+func (n *Node) reportEvidence(evidence *core.Evidence) {
+	if _, err := n.SubmitEvidence(evidence); err != nil {
+		n.logger.Debug("Evidence not submitted", "offender", evidence.Offender, "error", err)
+	}
+}
+
+// handleEvidence accepts slashing evidence
+func (n *Node) handleEvidence(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var evidence core.Evidence
+	if err := json.NewDecoder(r.Body).Decode(&evidence); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid evidence: %w", err))
+		return
+	}
+
+	tx, err := n.SubmitEvidence(&evidence)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, tx)
+}
+
+// handleSlashings lists the offenders slashed in this shard
+func (n *Node) handleSlashings(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	slashings := n.Blockchain.State.GetSlashings()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"slashings": slashings,
+		"count":     len(slashings),
+	})
+}
+
+// handleRelayVote accepts a relay's signed vote for a relay block
+func (n *Node) handleRelayVote(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var vote core.RelayVote
+	if err := json.NewDecoder(r.Body).Decode(&vote); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid relay vote: %w", err))
+		return
+	}
+
+	if err := n.ShardManager.GetRelayConsensus().SubmitRelayVote(&vote); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, vote)
+}
+
+// handleRelayBlock accepts a relay block proposed by a relay
+func (n *Node) handleRelayBlock(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var relayBlock core.RelayBlock
+	if err := json.NewDecoder(r.Body).Decode(&relayBlock); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid relay block: %w", err))
+		return
+	}
+
+	if err := n.ShardManager.GetRelayConsensus().SubmitRelayBlock(&relayBlock); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": relayBlock.ID, "hash": relayBlock.Hash})
+}
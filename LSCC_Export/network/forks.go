@@ -0,0 +1,152 @@
+package network
+
+import (
+	"fmt"
+
+	"lscc/consensus"
+	"lscc/core"
+)
+
+// maxSideBlocks bounds the blocks of competing branches a node keeps
+const maxSideBlocks = 256
+
+// extendsTip reports whether a block of the node's shard builds on the
+// latest block of its chain
+func (n *Node) extendsTip(block *core.Block) bool {
+	if block.ShardID != n.Config.ShardID {
+		return true
+	}
+	latest := n.Blockchain.GetLatestBlock()
+	if latest == nil {
+		return true
+	}
+	latestHash, err := latest.Hash()
+	if err != nil {
+		return true
+	}
+	return block.Header.PreviousHash == latestHash && block.Header.Height == latest.Header.Height+1
+}
+
+// handleForkBlock keeps a block that does not build on the chain's tip and
+// switches the chain to the branch it completes once that branch outranks
+// it: is longer, or as long with a lower tip hash.
+// Missing parents are requested from the peer that sent the block, which
+// also lets a node that fell behind catch up. Only engines under which the
+// longest branch wins take part; the others reject the block.
+func (n *Node) handleForkBlock(peer *Peer, block *core.Block) error {
+	validator, ok := n.Consensus.(consensus.BranchValidator)
+	if !ok {
+		return fmt.Errorf("block at height %d does not extend the chain", block.Header.Height)
+	}
+	hash, err := block.Hash()
+	if err != nil {
+		return err
+	}
+	if n.Blockchain.GetBlockByHash(hash) != nil {
+		return nil
+	}
+	if finalized := n.Blockchain.Finality.FinalizedHeight(); block.Header.Height <= finalized {
+		return fmt.Errorf("block at height %d forks below finalized height %d", block.Header.Height, finalized)
+	}
+
+	n.mu.Lock()
+	if len(n.sideBlocks) >= maxSideBlocks {
+		n.pruneSideBlocks()
+	}
+	blockCopy := *block
+	n.sideBlocks[hash] = &blockCopy
+	branch, missing := n.branchTo(&blockCopy)
+	n.mu.Unlock()
+
+	if missing != "" {
+		request := NewBlockRequestMessage(hash, missing, 0)
+		return peer.SendMessage(MessageTypeBlockRequest, request)
+	}
+	if len(branch) == 0 || !n.Blockchain.Outranks(branch[len(branch)-1]) {
+		return nil
+	}
+
+	prev := n.Blockchain.GetBlockByHeight(branch[0].Header.Height - 1)
+	for _, b := range branch {
+		if !validator.ValidateBranchBlock(b, prev) {
+			return fmt.Errorf("invalid block at height %d in branch", b.Header.Height)
+		}
+		prev = b
+	}
+	if err := n.Blockchain.Reorganize(branch); err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	for _, b := range branch {
+		if h, err := b.Hash(); err == nil {
+			delete(n.sideBlocks, h)
+		}
+	}
+	n.mu.Unlock()
+
+	n.logger.Info("Switched to outranking branch",
+		"forkHeight", branch[0].Header.Height,
+		"height", branch[len(branch)-1].Header.Height,
+		"peerID", peer.ID)
+	return nil
+}
+
+// branchTo walks back from a kept block to the chain and forward through
+// the kept blocks built on it, returning the branch in height order, or the
+// hash of the first parent the node lacks. Must be called with the lock
+// held.
+func (n *Node) branchTo(block *core.Block) ([]*core.Block, string) {
+	var branch []*core.Block
+	for b := block; ; {
+		branch = append(branch, b)
+		parent := n.Blockchain.GetBlockByHash(b.Header.PreviousHash)
+		if parent != nil {
+			if parent.Header.Height+1 != b.Header.Height {
+				return nil, ""
+			}
+			break
+		}
+		next, kept := n.sideBlocks[b.Header.PreviousHash]
+		if !kept {
+			return nil, b.Header.PreviousHash
+		}
+		b = next
+	}
+	for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+		branch[i], branch[j] = branch[j], branch[i]
+	}
+
+	// Kept blocks waiting for this one, e.g. the children of a requested
+	// parent, extend the branch
+	for extended := true; extended; {
+		extended = false
+		tipHash, err := branch[len(branch)-1].Hash()
+		if err != nil {
+			break
+		}
+		for _, b := range n.sideBlocks {
+			if b.Header.PreviousHash == tipHash && b.Header.Height == branch[len(branch)-1].Header.Height+1 {
+				branch = append(branch, b)
+				extended = true
+				break
+			}
+		}
+	}
+	return branch, ""
+}
+
+// pruneSideBlocks drops kept blocks that can no longer replace any block
+// of the chain, or all of them if none can be dropped that way. Must be
+// called with the lock held.
+func (n *Node) pruneSideBlocks() {
+	finalized := n.Blockchain.Finality.FinalizedHeight()
+	for hash, b := range n.sideBlocks {
+		if b.Header.Height <= finalized {
+			delete(n.sideBlocks, hash)
+		}
+	}
+	if len(n.sideBlocks) >= maxSideBlocks {
+		n.sideBlocks = make(map[string]*core.Block)
+	}
+}
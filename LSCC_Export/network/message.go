@@ -40,12 +40,16 @@ type Message struct {
 
 // HandshakeMessage is sent when a peer connects
 type HandshakeMessage struct {
-        NodeID       string `json:"node_id"`
-        Version      string `json:"version"`
-        ShardID      int    `json:"shard_id"`
-        IsRelay      bool   `json:"is_relay"`
-        Port         int    `json:"port"`
-        Timestamp    int64  `json:"timestamp"`
+        NodeID               string `json:"node_id"`
+        Version              string `json:"version"`
+        ShardID              int    `json:"shard_id"`
+        IsRelay              bool   `json:"is_relay"`
+        Port                 int    `json:"port"`
+        Timestamp            int64  `json:"timestamp"`
+        ConsensusType        string `json:"consensus_type"`
+        ConsensusFingerprint string `json:"consensus_fingerprint"` // Digest of the engine and its parameters
+        ChainID              string `json:"chain_id"`
+        GenesisHash          string `json:"genesis_hash"`
 }
 
 // PeerInfo contains information about a peer
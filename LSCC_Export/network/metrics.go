@@ -0,0 +1,172 @@
+package network
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"lscc/core"
+	"lscc/metrics"
+)
+
+// Node metrics. Chain metrics are labeled by the shard and layer of the
+// chain; cross-shard latency by those of the source shard and the target
+// shard.
+var (
+	uptimeGauge = metrics.Default().NewGaugeVec(
+		"lscc_node_uptime_seconds",
+		"Seconds since the node started",
+	)
+	heightGauge = metrics.Default().NewGaugeVec(
+		"lscc_block_height",
+		"Height of the chain tip",
+		"shard", "layer",
+	)
+	finalizedHeightGauge = metrics.Default().NewGaugeVec(
+		"lscc_finalized_height",
+		"Height of the last finalized block",
+		"shard", "layer",
+	)
+	mempoolGauge = metrics.Default().NewGaugeVec(
+		"lscc_mempool_size",
+		"Transactions waiting in the pool",
+		"shard", "layer",
+	)
+	peersGauge = metrics.Default().NewGaugeVec(
+		"lscc_peers",
+		"Connected peers",
+		"shard", "layer",
+	)
+	blockInterval = metrics.Default().NewHistogramVec(
+		"lscc_block_interval_seconds",
+		"Time between the timestamps of consecutive blocks",
+		[]float64{1, 2, 5, 10, 15, 30, 60, 120, 300},
+		"shard", "layer",
+	)
+	blockTransactions = metrics.Default().NewCounterVec(
+		"lscc_block_transactions_total",
+		"Transactions included in blocks added to the chain",
+		"shard", "layer",
+	)
+	acceptedTransactions = metrics.Default().NewCounterVec(
+		"lscc_transactions_accepted_total",
+		"Transactions accepted into a pool or by the sharding layer",
+		"shard", "layer",
+	)
+	crossShardLatency = metrics.Default().NewHistogramVec(
+		"lscc_cross_shard_latency_seconds",
+		"Time from the submission of a cross-shard transaction to the finalization of its relay block",
+		[]float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+		"shard", "layer", "target_shard",
+	)
+)
+
+// metricsQueueSize bounds the events waiting to be recorded
+const metricsQueueSize = 1024
+
+// maxTrackedCrossShard bounds the cross-shard transactions whose submission
+// time is remembered; older ones are forgotten first
+const maxTrackedCrossShard = 10000
+
+// shardLabels returns the shard and layer label values of a shard
+func (n *Node) shardLabels(shardID int) []string {
+	return []string{strconv.Itoa(shardID), strconv.Itoa(n.Config.LayerOfShard(shardID))}
+}
+
+// collectMetrics sets the gauges read when metrics are scraped
+func (n *Node) collectMetrics() {
+	n.mu.RLock()
+	startedAt := n.startedAt
+	peers := len(n.Peers)
+	n.mu.RUnlock()
+
+	labels := n.shardLabels(n.Config.ShardID)
+	uptimeGauge.With().Set(time.Since(startedAt).Seconds())
+	heightGauge.With(labels...).Set(float64(n.Blockchain.GetHeight()))
+	finalizedHeightGauge.With(labels...).Set(float64(n.Blockchain.Finality.FinalizedHeight()))
+	mempoolGauge.With(labels...).Set(float64(len(n.Blockchain.GetPendingTransactions())))
+	peersGauge.With(labels...).Set(float64(peers))
+}
+
+// recordMetrics records the metrics of chain and relay events until the
+// node stops
+func (n *Node) recordMetrics(sub *core.Subscription) {
+	defer sub.Unsubscribe()
+
+	// Submission times of cross-shard transactions awaiting their relay block
+	submitted := make(map[string]time.Time)
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case event := <-sub.Events():
+			switch event.Type {
+			case core.EventBlockAdded:
+				n.recordBlock(event.ShardID, event.Block)
+			case core.EventTxAccepted:
+				acceptedTransactions.With(n.shardLabels(event.ShardID)...).Inc()
+				tx := event.Transaction
+				if !tx.IsCrossShard() || tx.IsCrossLayer() || tx.IsIncomingCredit() {
+					continue
+				}
+				if _, seen := submitted[tx.Hash]; !seen {
+					if len(submitted) >= maxTrackedCrossShard {
+						forgetOldest(submitted)
+					}
+					submitted[tx.Hash] = time.Now()
+				}
+			case core.EventRelayBlockFinalized:
+				for _, tx := range event.RelayBlock.CrossShardTxs {
+					started, tracked := submitted[tx.Hash]
+					if !tracked {
+						continue
+					}
+					delete(submitted, tx.Hash)
+					labels := append(n.shardLabels(tx.SourceShard), strconv.Itoa(tx.TargetShard))
+					crossShardLatency.With(labels...).Observe(time.Since(started).Seconds())
+				}
+			}
+		}
+	}
+}
+
+// recordBlock records a block added to the chain of a shard
+func (n *Node) recordBlock(shardID int, block *core.Block) {
+	labels := n.shardLabels(shardID)
+	blockTransactions.With(labels...).Add(float64(len(block.Transactions)))
+
+	if block.Header.Height == 0 {
+		return
+	}
+	shard, err := n.ShardManager.GetShard(shardID)
+	if err != nil {
+		return
+	}
+	if prev := shard.Blockchain.GetBlockByHeight(block.Header.Height - 1); prev != nil {
+		blockInterval.With(labels...).Observe(float64(block.Header.Timestamp - prev.Header.Timestamp))
+	}
+}
+
+// forgetOldest drops the earliest submission from a set of tracked
+// cross-shard transactions
+func forgetOldest(submitted map[string]time.Time) {
+	var oldestHash string
+	var oldest time.Time
+	for hash, at := range submitted {
+		if oldestHash == "" || at.Before(oldest) {
+			oldestHash, oldest = hash, at
+		}
+	}
+	delete(submitted, oldestHash)
+}
+
+// handleMetrics serves the process's metrics in the Prometheus text format
+func (n *Node) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", metrics.ContentType)
+	if err := metrics.Default().WriteText(w); err != nil {
+		n.logger.Error("Failed to write metrics", "error", err)
+	}
+}
@@ -0,0 +1,103 @@
+package network
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lscc/core"
+	"lscc/metrics"
+	"lscc/utils"
+)
+
+// waitFor polls a condition until it holds or a second has passed
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRecordMetricsCrossShardLatency(t *testing.T) {
+	n := newRPCNode(t)
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	defer n.cancel()
+	bus := n.ShardManager.Events()
+	go n.recordMetrics(bus.Subscribe("metrics", metricsQueueSize))
+
+	tx, err := core.NewTransaction("alice", "bob", utils.Coins(1), 0, 0, 1, 0, core.CrossShardTransaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := acceptedTransactions.With("0", "0")
+	latency := crossShardLatency.With("0", "0", "1")
+	acceptedBefore, latencyBefore := accepted.Value(), latency.Count()
+
+	// Only the first relay block carrying the transaction is observed
+	bus.Publish(core.Event{Type: core.EventTxAccepted, ShardID: 0, Transaction: tx})
+	relayBlock := &core.RelayBlock{ID: "relay1", CrossShardTxs: []*core.Transaction{tx}}
+	bus.Publish(core.Event{Type: core.EventRelayBlockFinalized, ShardID: -1, RelayBlock: relayBlock})
+	bus.Publish(core.Event{Type: core.EventRelayBlockFinalized, ShardID: -1, RelayBlock: relayBlock})
+
+	// Events are recorded in order, so once a later one is counted the
+	// relay blocks have been recorded
+	marker := acceptedTransactions.With("1", "0")
+	markerBefore := marker.Value()
+	bus.Publish(core.Event{Type: core.EventTxAccepted, ShardID: 1, Transaction: &core.Transaction{Hash: "marker"}})
+	waitFor(t, "the events to be recorded", func() bool { return marker.Value() > markerBefore })
+
+	if got := accepted.Value() - acceptedBefore; got != 1 {
+		t.Errorf("accepted transactions grew by %v, want 1", got)
+	}
+	if got := latency.Count() - latencyBefore; got != 1 {
+		t.Errorf("cross-shard latency has %d new observations, want 1", got)
+	}
+
+	n.cancel()
+	waitFor(t, "the recorder to unsubscribe", func() bool { return len(bus.Stats().Subscribers) == 0 })
+}
+
+func TestHandleMetrics(t *testing.T) {
+	n := newRPCNode(t)
+	n.startedAt = time.Now()
+	n.collectMetrics()
+	server := httptest.NewServer(http.HandlerFunc(n.handleMetrics))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != metrics.ContentType {
+		t.Fatalf("metrics answered %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	for _, line := range []string{
+		"# TYPE lscc_block_height gauge\n",
+		`lscc_block_height{shard="0",layer="0"} 0` + "\n",
+		`lscc_mempool_size{shard="0",layer="0"} 0` + "\n",
+		"# TYPE lscc_cross_shard_latency_seconds histogram\n",
+	} {
+		if !strings.Contains(string(body), line) {
+			t.Errorf("metrics lack %q", line)
+		}
+	}
+
+	resp, err = http.Post(server.URL, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST answered %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
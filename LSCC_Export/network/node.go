@@ -2,9 +2,9 @@ package network
 
 import (
         "context"
-        "encoding/json"
         "fmt"
         "net"
+        "net/http"
         "strconv"
         "sync"
         "time"
@@ -12,6 +12,7 @@ import (
         "lscc/config"
         "lscc/consensus"
         "lscc/core"
+        "lscc/metrics"
         "lscc/sharding"
         "lscc/utils"
 )
@@ -27,6 +28,14 @@ type Node struct {
         Consensus     consensus.ConsensusEngine
         Config        *config.Config
         listener      net.Listener
+        transport     Transport // How the node listens and dials peers
+        apiServer     *http.Server
+        channelUpdates map[string]*core.ChannelUpdate // Latest off-chain update per channel
+        seenConsensus map[string]bool // Consensus messages already handled
+        sideBlocks    map[string]*core.Block // Blocks of competing branches, by hash
+        consensusFingerprint string // Digest of the engine and parameters, exchanged in handshakes
+        startedAt     time.Time
+        stopMetrics   func() // Removes the node's metrics collect hook
         ctx           context.Context
         cancel        context.CancelFunc
         mu            sync.RWMutex
@@ -36,7 +45,7 @@ type Node struct {
 
 // NewNode creates a new network node
 func NewNode(cfg *config.Config, shardManager *sharding.Manager) (*Node, error) {
-        logger := utils.GetLogger()
+        logger := utils.GetLogger().Named("network")
         
         // Get the shard this node belongs to
         shardID, err := shardManager.GetNodeShard(cfg.NodeID)
@@ -50,6 +59,15 @@ func NewNode(cfg *config.Config, shardManager *sharding.Manager) (*Node, error)
                 return nil, err
         }
         
+        // Refuse to join a shard that runs a different consensus engine
+        if err := consensus.CheckShard(cfg, shardID); err != nil {
+                return nil, err
+        }
+        fingerprint, err := consensus.Fingerprint(cfg)
+        if err != nil {
+                return nil, err
+        }
+        
         // Create blockchain from shard
         blockchain := shard.Blockchain
         
@@ -59,6 +77,11 @@ func NewNode(cfg *config.Config, shardManager *sharding.Manager) (*Node, error)
                 ID:           cfg.NodeID,
                 Port:         cfg.Port,
                 Peers:        make(map[string]*Peer),
+                channelUpdates: make(map[string]*core.ChannelUpdate),
+                seenConsensus: make(map[string]bool),
+                sideBlocks:   make(map[string]*core.Block),
+                consensusFingerprint: fingerprint,
+                transport:    tcpTransport{},
                 Blockchain:   blockchain,
                 ShardManager: shardManager,
                 Config:       cfg,
@@ -75,6 +98,22 @@ func NewNode(cfg *config.Config, shardManager *sharding.Manager) (*Node, error)
         }
         node.Consensus = consensusEngine
         
+        // Reward the relay that carries this shard's cross-shard traffic,
+        // accept relay rewards only for active relays, and let the engine
+        // talk to the rest of the shard
+        consensusEngine.SetRelaySelector(func() (string, error) {
+                return shardManager.RelayForShard(cfg.ShardID)
+        })
+        consensusEngine.SetRelayChecker(shardManager.IsActiveRelay)
+        consensusEngine.SetMessageSender(node.sendConsensusMessage)
+        
+        // Report misbehaviour found by the sharding layer, and drop slashed
+        // relays from the relay set
+        shardManager.SetEvidenceHandler(node.reportEvidence)
+        blockchain.OnSlash(func(slashing *core.Slashing) {
+                shardManager.SlashRelay(slashing.Offender)
+        })
+        
         logger.Info("Node created", 
                 "nodeID", node.ID, 
                 "shardID", shardID, 
@@ -91,11 +130,32 @@ func (n *Node) Start() error {
                 return fmt.Errorf("node already running")
         }
         n.isRunning = true
+        n.startedAt = time.Now()
+        n.stopMetrics = metrics.Default().OnCollect(n.collectMetrics)
         n.mu.Unlock()
         
+        // Relay accepted transactions to peers and record metrics and traces;
+        // subscribing before anything else starts means no event is missed
+        txEvents := n.ShardManager.Events().Subscribe("gossip", gossipQueueSize, core.EventTxAccepted)
+        go n.gossipTransactions(txEvents)
+        if n.Consensus.GetType() != consensus.PBFT {
+                blockEvents := n.ShardManager.Events().Subscribe("block-gossip", gossipQueueSize, core.EventBlockAdded)
+                go n.gossipBlocks(blockEvents)
+        }
+        metricEvents := n.ShardManager.Events().Subscribe("metrics", metricsQueueSize,
+                core.EventBlockAdded, core.EventTxAccepted, core.EventRelayBlockFinalized)
+        go n.recordMetrics(metricEvents)
+        traceEvents := n.ShardManager.Events().Subscribe("tracing", tracingQueueSize,
+                core.EventBlockAdded, core.EventTxAccepted)
+        go n.recordTraces(traceEvents)
+        if err := n.startTracing(); err != nil {
+                n.logger.Error("Failed to start tracing", "error", err)
+                return err
+        }
+        
         // Start listening for incoming connections
         addr := fmt.Sprintf("0.0.0.0:%d", n.Port)
-        listener, err := net.Listen("tcp", addr)
+        listener, err := n.transport.Listen(addr)
         if err != nil {
                 n.logger.Error("Failed to start node listener", "error", err)
                 return err
@@ -109,6 +169,20 @@ func (n *Node) Start() error {
                 return err
         }
         
+        // Start cross-shard and cross-layer services
+        err = n.ShardManager.Start()
+        if err != nil {
+                n.logger.Error("Failed to start shard manager", "error", err)
+                return err
+        }
+        
+        // Start REST API
+        err = n.startAPI()
+        if err != nil {
+                n.logger.Error("Failed to start REST API", "error", err)
+                return err
+        }
+        
         // Connect to bootstrap nodes
         for _, bootstrapAddr := range n.Config.BootstrapNodes {
                 go n.connectToPeer(bootstrapAddr)
@@ -127,31 +201,39 @@ func (n *Node) Start() error {
 // Stop stops the node
 func (n *Node) Stop() error {
         n.mu.Lock()
-        defer n.mu.Unlock()
-        
         if !n.isRunning {
+                n.mu.Unlock()
                 return fmt.Errorf("node not running")
         }
+        n.isRunning = false
         
         // Cancel context to stop all goroutines
         n.cancel()
+        n.stopMetrics()
         
         // Close listener
         if n.listener != nil {
                 n.listener.Close()
         }
         
-        // Stop consensus engine
-        if n.Consensus != nil {
-                n.Consensus.Stop()
+        // Stop REST API
+        if n.apiServer != nil {
+                n.apiServer.Close()
         }
         
         // Close all peer connections
         for _, peer := range n.Peers {
                 peer.Disconnect()
         }
+        n.mu.Unlock()
+        
+        // The engine and the sharding services broadcast through the node
+        // while holding their own locks, so they are stopped without its lock
+        if n.Consensus != nil {
+                n.Consensus.Stop()
+        }
+        n.ShardManager.Stop()
         
-        n.isRunning = false
         n.logger.Info("Node stopped", "nodeID", n.ID)
         
         return nil
@@ -178,32 +260,19 @@ func (n *Node) acceptConnections() {
 
 // handleConnection handles a new incoming connection
 func (n *Node) handleConnection(conn net.Conn) {
-        // Set connection deadline
+        // The peer must complete its handshake before the deadline
         conn.SetDeadline(time.Now().Add(time.Duration(n.Config.ConnectionTimeout) * time.Second))
         
-        // Read handshake message
-        var handshake HandshakeMessage
-        decoder := json.NewDecoder(conn)
-        if err := decoder.Decode(&handshake); err != nil {
-                n.logger.Error("Error decoding handshake", "error", err)
-                conn.Close()
-                return
-        }
-        
-        // Create peer
+        // Create peer; its ID is set by the handshake, which is checked
+        // like any other message
         peer := NewPeer(
-                handshake.NodeID,
+                "",
                 conn.RemoteAddr().String(),
                 conn,
                 n,
         )
         
-        // Add peer
-        n.mu.Lock()
-        n.Peers[peer.ID] = peer
-        n.mu.Unlock()
-        
-        n.logger.Info("New peer connected", "peerID", peer.ID, "address", peer.Address)
+        n.logger.Info("New peer connected", "address", peer.Address)
         
         // Start peer message handling
         peer.Start()
@@ -233,7 +302,7 @@ func (n *Node) connectToPeer(address string) {
         n.mu.RUnlock()
         
         // Connect to peer
-        conn, err := net.DialTimeout("tcp", address, time.Duration(n.Config.ConnectionTimeout)*time.Second)
+        conn, err := n.transport.Dial(address, time.Duration(n.Config.ConnectionTimeout)*time.Second)
         if err != nil {
                 n.logger.Error("Failed to connect to peer", "address", address, "error", err)
                 return
@@ -259,12 +328,16 @@ func (n *Node) connectToPeer(address string) {
 // sendHandshake sends a handshake message to a peer
 func (n *Node) sendHandshake(peer *Peer) {
         handshake := HandshakeMessage{
-                NodeID:       n.ID,
-                Version:      "1.0.0",
-                ShardID:      n.Config.ShardID,
-                IsRelay:      n.Config.IsRelay,
-                Port:         n.Port,
-                Timestamp:    time.Now().Unix(),
+                NodeID:               n.ID,
+                Version:              "1.0.0",
+                ShardID:              n.Config.ShardID,
+                IsRelay:              n.Config.IsRelay,
+                Port:                 n.Port,
+                Timestamp:            time.Now().Unix(),
+                ConsensusType:        n.Config.ConsensusType,
+                ConsensusFingerprint: n.consensusFingerprint,
+                ChainID:              n.Config.ChainID,
+                GenesisHash:          n.Config.GenesisHash,
         }
         
         peer.SendMessage(MessageTypeHandshake, handshake)
@@ -285,6 +358,51 @@ func (n *Node) broadcastMessage(messageType MessageType, data interface{}) {
         }
 }
 
+// gossipQueueSize bounds the accepted transactions waiting to be relayed
+const gossipQueueSize = 1024
+
+// gossipTransactions relays the transactions accepted by this node's shard,
+// and cross-shard transactions, to peers until the node stops. Credits are
+// created by every node's sharding layer and are never relayed.
+func (n *Node) gossipTransactions(sub *core.Subscription) {
+        defer sub.Unsubscribe()
+        
+        for {
+                select {
+                case <-n.ctx.Done():
+                        return
+                case event := <-sub.Events():
+                        tx := event.Transaction
+                        if tx.IsIncomingCredit() {
+                                continue
+                        }
+                        if event.ShardID == n.Config.ShardID || tx.IsCrossShard() {
+                                n.BroadcastTransaction(tx)
+                        }
+                }
+        }
+}
+
+// gossipBlocks announces the blocks this node adds to its shard's chain
+// from its own proposals until the node stops, so peers extend their chains
+// with them or weigh them in fork choice. PBFT blocks travel in the
+// engine's own messages instead.
+func (n *Node) gossipBlocks(sub *core.Subscription) {
+        defer sub.Unsubscribe()
+
+        for {
+                select {
+                case <-n.ctx.Done():
+                        return
+                case event := <-sub.Events():
+                        block := event.Block
+                        if event.ShardID == n.Config.ShardID && block.Header.ValidatorID == n.ID {
+                                n.BroadcastBlock(block)
+                        }
+                }
+        }
+}
+
 // broadcastTransaction broadcasts a transaction to all peers
 func (n *Node) BroadcastTransaction(tx *core.Transaction) {
         n.broadcastMessage(MessageTypeTransaction, tx)
@@ -382,17 +500,37 @@ func (n *Node) GetStatus() map[string]interface{} {
         
         status := map[string]interface{}{
                 "node_id":        n.ID,
+                "chain_id":       n.Config.ChainID,
+                "genesis_hash":   n.Config.GenesisHash,
                 "is_running":     n.isRunning,
+                "uptime_seconds": n.uptime(),
                 "peer_count":     len(n.Peers),
                 "shard_id":       n.Config.ShardID,
                 "is_relay":       n.Config.IsRelay,
                 "blockchain_height": n.Blockchain.GetHeight(),
+                "finality":       n.Blockchain.Finality.GetStatus(),
                 "consensus_type": n.Consensus.GetType(),
+                "gas_price":      n.Config.GasPrice,
+                "events":         n.ShardManager.Events().Stats(),
+        }
+        if supply, err := n.Blockchain.State.GetSupplyInfo(); err != nil {
+                status["supply_error"] = err.Error()
+        } else {
+                status["supply"] = supply
         }
         
         return status
 }
 
+// uptime returns the whole seconds since the node started, zero if it is
+// not running. Must be called with the lock held.
+func (n *Node) uptime() int64 {
+        if !n.isRunning {
+                return 0
+        }
+        return int64(time.Since(n.startedAt).Seconds())
+}
+
 // String returns a string representation of the node
 func (n *Node) String() string {
         return fmt.Sprintf("Node{ID: %s, Port: %d, PeerCount: %d, ShardID: %d, IsRelay: %v}",
@@ -19,6 +19,7 @@ type Peer struct {
         node           *Node
         sendMu         sync.Mutex
         isConnected    bool
+        handshaked     bool // Set once the peer's handshake is accepted; used by the receive loop only
         disconnectOnce sync.Once
         lastSeen       time.Time
         logger         *utils.Logger
@@ -33,7 +34,7 @@ func NewPeer(id string, address string, conn net.Conn, node *Node) *Peer {
                 node:        node,
                 isConnected: true,
                 lastSeen:    time.Now(),
-                logger:      utils.GetLogger(),
+                logger:      utils.GetLogger().Named("network"),
         }
 }
 
@@ -69,6 +70,13 @@ func (p *Peer) receiveLoop() {
 
 // handleMessage processes a received message
 func (p *Peer) handleMessage(msg Message) error {
+        // Both ends send their handshake first, so anything else before it
+        // comes from a peer that skipped the checks
+        if !p.handshaked && msg.Type != MessageTypeHandshake {
+                p.Disconnect()
+                return fmt.Errorf("message of type %d from %s before its handshake", msg.Type, p.Address)
+        }
+
         switch msg.Type {
         case MessageTypeHandshake:
                 return p.handleHandshake(msg.Data)
@@ -84,6 +92,8 @@ func (p *Peer) handleMessage(msg Message) error {
                 return p.handleBlockRequest(msg.Data)
         case MessageTypeBlockResponse:
                 return p.handleBlockResponse(msg.Data)
+        case MessageTypeConsensus:
+                return p.node.handleConsensusMessage(msg.Data)
         default:
                 return fmt.Errorf("unknown message type: %d", msg.Type)
         }
@@ -97,18 +107,50 @@ func (p *Peer) handleHandshake(data json.RawMessage) error {
                 return err
         }
 
+        // Nodes of different networks never share a chain
+        if handshake.GenesisHash != p.node.Config.GenesisHash {
+                p.Disconnect()
+                return fmt.Errorf("peer %s is on chain %s with genesis %s, expected %s",
+                        handshake.NodeID, handshake.ChainID, handshake.GenesisHash, p.node.Config.GenesisHash)
+        }
+
+        // Nodes of one shard must agree on its consensus engine
+        if handshake.ShardID == p.node.Config.ShardID &&
+                (handshake.ConsensusType != p.node.Config.ConsensusType ||
+                        handshake.ConsensusFingerprint != p.node.consensusFingerprint) {
+                p.Disconnect()
+                return fmt.Errorf("peer %s runs %s consensus with different parameters or engine than shard %d (%s)",
+                        handshake.NodeID, handshake.ConsensusType, handshake.ShardID, p.node.Config.ConsensusType)
+        }
+
+        // The handshake succeeded, so lift the deadline set for it
+        p.conn.SetDeadline(time.Time{})
+        p.handshaked = true
+
         // Update peer ID if not set
         if p.ID == "" {
                 p.ID = handshake.NodeID
                 p.node.mu.Lock()
                 p.node.Peers[p.ID] = p
                 p.node.mu.Unlock()
+                
+                p.node.ShardManager.Events().Publish(core.Event{
+                        Type:    core.EventPeerConnected,
+                        ShardID: handshake.ShardID,
+                        Peer: &core.PeerInfo{
+                                ID:      p.ID,
+                                Address: p.Address,
+                                ShardID: handshake.ShardID,
+                                IsRelay: handshake.IsRelay,
+                        },
+                })
         }
 
         p.logger.Info("Received handshake from peer", 
                 "peerID", p.ID, 
                 "version", handshake.Version,
-                "shardID", handshake.ShardID)
+                "shardID", handshake.ShardID,
+                "consensus", handshake.ConsensusType)
 
         return nil
 }
@@ -139,6 +181,11 @@ func (p *Peer) handleTransaction(data json.RawMessage) error {
                 return err
         }
 
+        // Credits from other shards are only created locally by the sharding layer
+        if tx.IsIncomingCredit() {
+                return fmt.Errorf("refusing relayed cross-shard credit %s", tx.Hash)
+        }
+
         // Check if transaction is cross-shard
         if tx.IsCrossShard() {
                 // Process using shard manager
@@ -148,13 +195,8 @@ func (p *Peer) handleTransaction(data json.RawMessage) error {
                 err = p.node.Blockchain.AddTransaction(&tx)
         }
 
-        if err != nil {
-                return err
-        }
-
-        // Relay to other peers
-        p.node.BroadcastTransaction(&tx)
-        return nil
+        // Accepted transactions are relayed to other peers by the node's gossip
+        return err
 }
 
 // handleBlock processes a received block
@@ -165,6 +207,38 @@ func (p *Peer) handleBlock(data json.RawMessage) error {
                 return err
         }
 
+        // Blocks of other shards are validated by the nodes of their shard;
+        // the chain of this node cannot tell whether they are valid
+        if block.ShardID != p.node.Config.ShardID {
+                // If this is a relay node, propagate to appropriate shards.
+                // This is simplified; a real implementation would be more complex
+                if p.node.Config.IsRelay {
+                        blockHash, _ := block.Hash()
+                        p.logger.Info("Relay node propagating block",
+                                "blockHash", blockHash,
+                                "fromShard", block.ShardID)
+                }
+                return nil
+        }
+
+        // Blocks already on the chain, e.g. announced by several peers, need
+        // no handling
+        if hash, err := block.Hash(); err == nil && p.node.Blockchain.GetBlockByHash(hash) != nil {
+                return nil
+        }
+
+        // A second block from the same validator at a height we already
+        // have is proof of equivocation
+        if evidence, found := p.node.Blockchain.DetectEquivocation(&block); found {
+                p.node.reportEvidence(evidence)
+                return fmt.Errorf("equivocating block from %s at height %d", block.Header.ValidatorID, block.Header.Height)
+        }
+
+        // Blocks of competing branches go to fork choice
+        if !p.node.extendsTip(&block) {
+                return p.node.handleForkBlock(p, &block)
+        }
+
         // Validate and process block
         if !p.node.Consensus.ValidateBlock(&block) {
                 return fmt.Errorf("invalid block")
@@ -175,17 +249,6 @@ func (p *Peer) handleBlock(data json.RawMessage) error {
                 return err
         }
 
-        // If this is a relay node and the block is from a different shard,
-        // propagate to appropriate shards
-        if p.node.Config.IsRelay && block.ShardID != p.node.Config.ShardID {
-                // Determine target shards and propagate
-                // This is simplified; a real implementation would be more complex
-                blockHash, _ := block.Hash()
-                p.logger.Info("Relay node propagating block", 
-                        "blockHash", blockHash, 
-                        "fromShard", block.ShardID)
-        }
-
         return nil
 }
 
@@ -224,7 +287,16 @@ func (p *Peer) handleBlockResponse(data json.RawMessage) error {
                 return err
         }
 
-        // Process the block
+        // Only blocks of this node's shard were asked for; another shard's
+        // block would be checked against the wrong chain
+        if response.Block.ShardID != p.node.Config.ShardID {
+                return fmt.Errorf("block response from shard %d, not %d", response.Block.ShardID, p.node.Config.ShardID)
+        }
+
+        // Process the block, or keep it for fork choice
+        if !p.node.extendsTip(&response.Block) {
+                return p.node.handleForkBlock(p, &response.Block)
+        }
         if !p.node.Consensus.ValidateBlock(&response.Block) {
                 return fmt.Errorf("invalid block in response")
         }
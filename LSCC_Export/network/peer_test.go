@@ -0,0 +1,65 @@
+package network
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"lscc/config"
+	"lscc/core"
+)
+
+func TestBlockResponseFromAnotherShardRefused(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.ShardID = 0
+	bc := core.NewBlockchain(cfg)
+	n := &Node{Config: cfg, Blockchain: bc}
+	peer := NewPeer("peer1", "127.0.0.1:0", nil, n)
+
+	// A block of shard 1 that would extend shard 0's tip by height alone
+	genesis := bc.GetLatestBlock()
+	prevHash, err := genesis.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := core.NewBlock(prevHash, 1, 1, 0, "node1")
+	block.Header.MerkleRoot = block.CalculateMerkleRoot()
+	data, err := json.Marshal(BlockResponseMessage{RequestID: "1", Block: *block})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := peer.handleBlockResponse(data); err == nil || !strings.Contains(err.Error(), "shard 1") {
+		t.Fatalf("block response of another shard: err = %v", err)
+	}
+	if height := bc.GetHeight(); height != 0 {
+		t.Fatalf("height = %d after another shard's block, want 0", height)
+	}
+}
+
+func TestHandshakeFromAnotherGenesisRefused(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.ShardID = 0
+	if err := cfg.InitGenesis(); err != nil {
+		t.Fatal(err)
+	}
+	n := &Node{Config: cfg}
+	peer := NewPeer("", "127.0.0.1:0", nil, n)
+
+	data, err := json.Marshal(HandshakeMessage{
+		NodeID:        "node2",
+		ShardID:       0,
+		ConsensusType: cfg.ConsensusType,
+		ChainID:       cfg.ChainID,
+		GenesisHash:   "other",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := peer.handleHandshake(data); err == nil || !strings.Contains(err.Error(), "genesis") {
+		t.Fatalf("handshake with another genesis: err = %v", err)
+	}
+	if peer.IsConnected() || peer.handshaked {
+		t.Error("peer of another network kept")
+	}
+}
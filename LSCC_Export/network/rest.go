@@ -0,0 +1,415 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"lscc/core"
+	"lscc/sharding"
+	"lscc/tracing"
+)
+
+// router sets up the node's REST API
+func (n *Node) router() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", n.handleStatus)
+	mux.HandleFunc("/send", n.handleSend)
+	mux.HandleFunc("/balance", n.handleBalance)
+	mux.HandleFunc("/tx/", n.handleTransactionStatus)
+	mux.HandleFunc("/validators", n.handleValidators)
+	mux.HandleFunc("/supply", n.handleSupply)
+
+	mux.HandleFunc("/channels", n.handleChannels)
+	mux.HandleFunc("/channels/", n.handleChannel)
+	mux.HandleFunc("/channels/open", n.handleChannelTx(core.ChannelOpenTransaction))
+	mux.HandleFunc("/channels/update", n.handleChannelUpdate)
+	mux.HandleFunc("/channels/close", n.handleChannelTx(core.ChannelCloseTransaction))
+	mux.HandleFunc("/channels/dispute", n.handleChannelTx(core.ChannelDisputeTransaction))
+	mux.HandleFunc("/channels/settle", n.handleChannelTx(core.ChannelSettleTransaction))
+
+	mux.HandleFunc("/evidence", n.handleEvidence)
+	mux.HandleFunc("/slashings", n.handleSlashings)
+	mux.HandleFunc("/relay/votes", n.handleRelayVote)
+	mux.HandleFunc("/relay/blocks", n.handleRelayBlock)
+
+	mux.HandleFunc("/shard", n.handleShard)
+	mux.HandleFunc("/swaps", n.handleSwaps)
+	mux.HandleFunc("/swaps/", n.handleSwap)
+	mux.HandleFunc("/swaps/initiate", n.handleSwapInitiate)
+	mux.HandleFunc("/swaps/participate", n.handleSwapTx((*sharding.SwapCoordinator).Participate))
+	mux.HandleFunc("/swaps/claim", n.handleSwapTx((*sharding.SwapCoordinator).Claim))
+	mux.HandleFunc("/swaps/refund", n.handleSwapRefund)
+
+	mux.HandleFunc("/contracts", n.handleContracts)
+	mux.HandleFunc("/contracts/", n.handleContract)
+	mux.HandleFunc("/contracts/query", n.handleContractQuery)
+	mux.HandleFunc("/receipts/", n.handleReceipt)
+	mux.HandleFunc("/messages", n.handleMessages)
+	mux.HandleFunc("/messages/", n.handleMessage)
+
+	mux.HandleFunc("/rpc", n.handleRPC)
+	mux.HandleFunc("/events", n.handleEvents)
+	mux.HandleFunc("/metrics", n.handleMetrics)
+	mux.HandleFunc("/admin/log-level", n.handleLogLevel)
+	mux.HandleFunc("/traces/", n.handleTrace)
+	mux.HandleFunc("/v1/traces", n.handleTraceImport)
+	n.registerAPI(mux)
+	return mux
+}
+
+// startAPI starts the REST API server if an API port is configured
+func (n *Node) startAPI() error {
+	if n.Config.APIPort <= 0 {
+		return nil
+	}
+
+	n.apiServer = &http.Server{
+		Addr:         fmt.Sprintf("0.0.0.0:%d", n.Config.APIPort),
+		Handler:      n.router(),
+		ReadTimeout:  time.Duration(n.Config.ConnectionTimeout) * time.Second,
+		WriteTimeout: time.Duration(n.Config.ConnectionTimeout) * time.Second,
+	}
+
+	go func() {
+		if err := n.apiServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			n.logger.Error("REST API server error", "error", err)
+		}
+	}()
+
+	n.logger.Info("REST API listening", "address", n.apiServer.Addr)
+	return nil
+}
+
+// writeJSON writes a JSON response with CORS headers
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": err.Error(),
+	})
+}
+
+// requireMethod rejects requests that do not use the given method
+func requireMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == http.MethodOptions {
+		writeJSON(w, http.StatusOK, nil)
+		return false
+	}
+	if r.Method != method {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return false
+	}
+	return true
+}
+
+// SubmitTransaction validates a transaction and hands it to the local chain
+// or the shard manager, whose acceptance relays it to peers
+func (n *Node) SubmitTransaction(tx *core.Transaction) error {
+	if tx.IsIncomingCredit() {
+		return errors.New("cross-shard credits cannot be submitted directly")
+	}
+
+	// The trace's root span covers the submission; later spans are
+	// recorded as the transaction moves through pools, shards and relays
+	span := tracing.Default().StartRoot(tx.Hash, "tx.submit",
+		"source_shard", tx.SourceShard,
+		"target_shard", tx.TargetShard,
+		"tx.type", tx.Type)
+	defer span.End()
+
+	var err error
+	if tx.IsCrossShard() {
+		err = n.ShardManager.ProcessCrossShardTransaction(tx)
+	} else {
+		err = n.Blockchain.AddTransaction(tx)
+	}
+	span.Fail(err)
+	return err
+}
+
+// handleStatus returns the node status
+func (n *Node) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, n.GetStatus())
+}
+
+// handleSend accepts a signed transaction
+func (n *Node) handleSend(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var tx core.Transaction
+	if err := json.NewDecoder(r.Body).Decode(&tx); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid transaction data: %w", err))
+		return
+	}
+
+	if err := n.SubmitTransaction(&tx); err != nil {
+		n.logger.Warn("Rejected transaction", "txHash", tx.Hash, "error", err)
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	n.logger.Info("Transaction accepted via API", "txHash", tx.Hash)
+	writeJSON(w, http.StatusAccepted, tx)
+}
+
+// handleBalance returns the balance of an account in this node's shard
+func (n *Node) handleBalance(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	address := r.URL.Query().Get("address")
+	if address == "" {
+		writeError(w, http.StatusBadRequest, errors.New("address is required"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"address":  address,
+		"balance":  n.Blockchain.State.GetBalance(address),
+		"shard_id": n.Config.ShardID,
+	})
+}
+
+// handleTransactionStatus serves /tx/{hash}, a transaction with its status,
+// confirmation depth and finality, and /tx/{hash}/receipt, its receipt.
+// Transactions of other shards are looked up with ?shard=N; cross-shard
+// transactions not yet in a chain are reported as in flight.
+func (n *Node) handleTransactionStatus(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	chain, status, err := n.requestChain(r)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	hash := strings.TrimPrefix(r.URL.Path, "/tx/")
+	if strings.HasSuffix(hash, "/receipt") {
+		hash = strings.TrimSuffix(hash, "/receipt")
+		receipt, exists := n.lookupReceipt(chain, hash)
+		if !exists {
+			writeError(w, http.StatusNotFound, errors.New("transaction not found"))
+			return
+		}
+		writeJSON(w, http.StatusOK, receipt)
+		return
+	}
+
+	txStatus, exists := n.lookupTransaction(chain, hash)
+	if !exists {
+		writeError(w, http.StatusNotFound, errors.New("transaction not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, txStatus)
+}
+
+// lookupTransaction returns a transaction of a chain with its status, or a
+// cross-shard transaction in flight
+func (n *Node) lookupTransaction(chain *core.Blockchain, hash string) (*core.TransactionStatus, bool) {
+	if txStatus, exists := chain.GetTransactionStatus(hash); exists {
+		return txStatus, true
+	}
+	tx, inFlight := n.ShardManager.FindCrossShardTransaction(hash)
+	if !inFlight {
+		return nil, false
+	}
+	return &core.TransactionStatus{Transaction: tx, Status: core.TxCrossShardInFlight, Index: -1}, true
+}
+
+// lookupReceipt returns the receipt of a transaction of a chain, or of a
+// cross-shard transaction in flight
+func (n *Node) lookupReceipt(chain *core.Blockchain, hash string) (*core.Receipt, bool) {
+	if receipt, exists := chain.GetReceipt(hash); exists {
+		return receipt, true
+	}
+	tx, inFlight := n.ShardManager.FindCrossShardTransaction(hash)
+	if !inFlight {
+		return nil, false
+	}
+	return core.NewInFlightReceipt(tx), true
+}
+
+// requestChain returns the chain of the shard named by the request's
+// ?shard=N parameter, defaulting to this node's shard. On failure it returns
+// the HTTP status to respond with.
+func (n *Node) requestChain(r *http.Request) (*core.Blockchain, int, error) {
+	shardParam := r.URL.Query().Get("shard")
+	if shardParam == "" {
+		return n.Blockchain, http.StatusOK, nil
+	}
+	shardID, err := strconv.Atoi(shardParam)
+	if err != nil || shardID < 0 {
+		return nil, http.StatusBadRequest, errors.New("invalid shard id")
+	}
+	chain, err := n.shardChain(shardID)
+	if err != nil {
+		return nil, http.StatusNotFound, err
+	}
+	return chain, http.StatusOK, nil
+}
+
+// shardChain returns the chain of a shard, or this node's chain for a
+// negative shard ID
+func (n *Node) shardChain(shardID int) (*core.Blockchain, error) {
+	if shardID < 0 {
+		return n.Blockchain, nil
+	}
+	shard, err := n.ShardManager.GetShard(shardID)
+	if err != nil {
+		return nil, err
+	}
+	return shard.Blockchain, nil
+}
+
+// handleSupply returns the supply accounting of a shard: genesis funds,
+// minted rewards, fees collected, paid and burned, stake burned by slashing
+// and funds moved across shards. Other shards are inspected with ?shard=N.
+func (n *Node) handleSupply(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	chain, status, err := n.requestChain(r)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	supply, err := chain.State.GetSupplyInfo()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"shard_id":           chain.Config.ShardID,
+		"height":             chain.GetHeight(),
+		"supply":             supply,
+		"block_reward":       n.Config.BlockReward,
+		"relay_reward_share": n.Config.RelayRewardShare,
+	})
+}
+
+// handleValidators returns the stake distribution of a shard: the current
+// epoch's validator set, the stake bonded to every validator and the stake
+// still unbonding. Other shards are inspected with ?shard=N.
+func (n *Node) handleValidators(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	chain, status, err := n.requestChain(r)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"shard_id":      chain.Config.ShardID,
+		"validator_set": chain.State.GetValidatorSet(),
+		"validators":    chain.State.GetValidators(),
+		"unbonding":     chain.State.GetUnbondings(),
+		"min_stake":     n.Config.MinStake,
+		"epoch_length":  n.Config.EpochLength,
+	})
+}
+
+// handleChannels lists the payment channels recorded in this shard
+func (n *Node) handleChannels(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	channels := n.Blockchain.State.GetChannels()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"channels": channels,
+		"count":    len(channels),
+	})
+}
+
+// handleChannel returns a channel's on-chain record and the latest
+// off-chain update this node holds for it
+func (n *Node) handleChannel(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/channels/")
+	ch, exists := n.Blockchain.State.GetChannel(id)
+	if !exists {
+		writeError(w, http.StatusNotFound, errors.New("channel not found"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"channel":       ch,
+		"latest_update": n.GetChannelUpdate(id),
+	})
+}
+
+// handleChannelTx accepts a signed channel lifecycle transaction of the given type
+func (n *Node) handleChannelTx(txType core.TransactionType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireMethod(w, r, http.MethodPost) {
+			return
+		}
+
+		var tx core.Transaction
+		if err := json.NewDecoder(r.Body).Decode(&tx); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid transaction data: %w", err))
+			return
+		}
+		if tx.Type != txType {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("expected transaction type %d, got %d", txType, tx.Type))
+			return
+		}
+
+		if err := n.SubmitTransaction(&tx); err != nil {
+			n.logger.Warn("Rejected channel transaction", "txHash", tx.Hash, "error", err)
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, tx)
+	}
+}
+
+// handleChannelUpdate stores a co-signed off-chain channel update
+func (n *Node) handleChannelUpdate(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var update core.ChannelUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid channel update: %w", err))
+		return
+	}
+
+	if err := n.StoreChannelUpdate(&update); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, update)
+}
@@ -0,0 +1,511 @@
+package network
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"lscc/core"
+)
+
+// JSON-RPC 2.0
+//
+// The node serves JSON-RPC 2.0 at /rpc, over HTTP POST and over a WebSocket
+// opened with a GET upgrade request, where every text message is a request
+// or a batch. Parameters are given by position or by name. Besides the
+// standard error codes, failures carry the API error code (ERR001-ERR009) in
+// the error's data and map to server error -3200N; invalid parameters
+// (ERR010) use the standard -32602.
+
+// Standard JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+const (
+	// rpcMaxBatch bounds the number of requests in a batch
+	rpcMaxBatch = 100
+	// rpcMaxBody bounds the size of an HTTP request body
+	rpcMaxBody = 1 << 20
+)
+
+// rpcRequest is a JSON-RPC request. A request without an ID is a
+// notification and gets no response.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// rpcResponse is a JSON-RPC response, carrying either a result or an error
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// rpcError is a JSON-RPC error object
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// rpcErrorData names the API error code behind a JSON-RPC error
+type rpcErrorData struct {
+	ErrorCode string `json:"error_code"`
+}
+
+// rpcMethod is a JSON-RPC method. params names its parameters in order;
+// names ending in "?" are optional.
+type rpcMethod struct {
+	params  []string
+	handler func(p rpcParams) (interface{}, *rpcError)
+}
+
+// rpcParams are the positional parameters of a call
+type rpcParams []json.RawMessage
+
+// newAPIRPCError converts an API error code and error into a JSON-RPC error
+func newAPIRPCError(code string, err error) *rpcError {
+	rpcCode := rpcInternalError
+	if code == ErrCodeInvalidRequest {
+		rpcCode = rpcInvalidParams
+	} else if number, parseErr := strconv.Atoi(strings.TrimPrefix(code, "ERR")); parseErr == nil {
+		rpcCode = -32000 - number
+	}
+	return &rpcError{Code: rpcCode, Message: err.Error(), Data: rpcErrorData{ErrorCode: code}}
+}
+
+// invalidParams returns an invalid parameters error
+func invalidParams(format string, args ...interface{}) *rpcError {
+	return newAPIRPCError(ErrCodeInvalidRequest, fmt.Errorf(format, args...))
+}
+
+// has reports whether the i-th parameter was given
+func (p rpcParams) has(i int) bool {
+	return i < len(p) && len(p[i]) > 0 && string(p[i]) != "null"
+}
+
+// decode decodes a required parameter
+func (p rpcParams) decode(i int, name string, v interface{}) *rpcError {
+	if !p.has(i) {
+		return invalidParams("missing parameter %s", name)
+	}
+	if err := json.Unmarshal(p[i], v); err != nil {
+		return invalidParams("invalid parameter %s: %v", name, err)
+	}
+	return nil
+}
+
+// shard decodes an optional shard ID parameter, -1 meaning the node's shard
+func (p rpcParams) shard(i int) (int, *rpcError) {
+	shardID := -1
+	if !p.has(i) {
+		return shardID, nil
+	}
+	if err := p.decode(i, "shard", &shardID); err != nil {
+		return 0, err
+	}
+	if shardID < 0 {
+		return 0, invalidParams("invalid shard id %d", shardID)
+	}
+	return shardID, nil
+}
+
+// rpcChain returns the chain of the shard named by an optional parameter
+func (n *Node) rpcChain(p rpcParams, i int) (*core.Blockchain, *rpcError) {
+	shardID, rpcErr := p.shard(i)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	chain, err := n.shardChain(shardID)
+	if err != nil {
+		return nil, newAPIRPCError(ErrCodeShardMismatch, err)
+	}
+	return chain, nil
+}
+
+// rpcMethods lists the JSON-RPC methods the node serves. Subscriptions
+// belong to a WebSocket session, which is nil over HTTP.
+func (n *Node) rpcMethods(session *rpcSession) map[string]rpcMethod {
+	return map[string]rpcMethod{
+		"lscc_chainId":               {nil, n.rpcChainID},
+		"lscc_nodeStatus":            {nil, n.rpcNodeStatus},
+		"lscc_blockNumber":           {[]string{"shard?"}, n.rpcBlockNumber},
+		"lscc_getBlockByHeight":      {[]string{"height", "shard?"}, n.rpcGetBlockByHeight},
+		"lscc_getBlockByHash":        {[]string{"hash", "shard?"}, n.rpcGetBlockByHash},
+		"lscc_getTransactionByHash":  {[]string{"hash", "shard?"}, n.rpcGetTransactionByHash},
+		"lscc_getTransactionReceipt": {[]string{"hash", "shard?"}, n.rpcGetTransactionReceipt},
+		"lscc_getBalance":            {[]string{"address", "shard?"}, n.rpcGetBalance},
+		"lscc_sendTransaction":       {[]string{"transaction"}, n.rpcSendTransaction},
+		"lscc_shardInfo":             {[]string{"shard?"}, n.rpcShardInfo},
+		"lscc_subscribe": {[]string{"topic", "filter?"}, func(p rpcParams) (interface{}, *rpcError) {
+			return n.rpcSubscribe(session, p)
+		}},
+		"lscc_unsubscribe": {[]string{"subscription"}, func(p rpcParams) (interface{}, *rpcError) {
+			return n.rpcUnsubscribe(session, p)
+		}},
+	}
+}
+
+// handleRPC serves JSON-RPC over HTTP POST, or over a WebSocket for GET
+// upgrade requests
+func (n *Node) handleRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && isWebSocketUpgrade(r) {
+		n.serveRPCWebSocket(w, r)
+		return
+	}
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, rpcMaxBody))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, rpcResponse{
+			JSONRPC: "2.0",
+			Error:   &rpcError{Code: rpcInvalidRequest, Message: err.Error()},
+			ID:      json.RawMessage("null"),
+		})
+		return
+	}
+
+	response := n.handleRPCPayload(body, nil)
+	if response == nil {
+		// Only notifications: nothing to return
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(response)
+}
+
+// serveRPCWebSocket answers JSON-RPC messages on a WebSocket until the
+// client goes away
+func (n *Node) serveRPCWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer conn.Close()
+	n.logger.Debug("JSON-RPC WebSocket opened", "remote", r.RemoteAddr)
+
+	session := newRPCSession(conn)
+	defer session.close()
+
+	// Stopping the node ends the connection
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-n.ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		opcode, message, err := conn.ReadMessage()
+		if err != nil {
+			n.logger.Debug("JSON-RPC WebSocket closed", "remote", r.RemoteAddr, "reason", err)
+			return
+		}
+		if opcode != wsText {
+			continue
+		}
+		if response := n.handleRPCPayload(message, session); response != nil {
+			if err := conn.WriteMessage(wsText, response); err != nil {
+				return
+			}
+		}
+		session.start(n)
+	}
+}
+
+// handleRPCPayload answers a request or a batch, returning nil when there
+// is nothing to answer
+func (n *Node) handleRPCPayload(payload []byte, session *rpcSession) []byte {
+	payload = bytes.TrimSpace(payload)
+	if !json.Valid(payload) {
+		return marshalRPC(rpcErrorResponse(nil, rpcParseError, "parse error"))
+	}
+	if len(payload) == 0 || payload[0] != '[' {
+		response := n.handleRPCRequest(payload, session)
+		if response == nil {
+			return nil
+		}
+		return marshalRPC(response)
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(payload, &batch); err != nil {
+		return marshalRPC(rpcErrorResponse(nil, rpcParseError, "parse error"))
+	}
+	if len(batch) == 0 {
+		return marshalRPC(rpcErrorResponse(nil, rpcInvalidRequest, "empty batch"))
+	}
+	if len(batch) > rpcMaxBatch {
+		return marshalRPC(rpcErrorResponse(nil, rpcInvalidRequest, fmt.Sprintf("batch exceeds %d requests", rpcMaxBatch)))
+	}
+
+	responses := make([]*rpcResponse, 0, len(batch))
+	for _, request := range batch {
+		if response := n.handleRPCRequest(request, session); response != nil {
+			responses = append(responses, response)
+		}
+	}
+	if len(responses) == 0 {
+		return nil
+	}
+	return marshalRPC(responses)
+}
+
+// handleRPCRequest answers a single request, returning nil for
+// notifications
+func (n *Node) handleRPCRequest(raw json.RawMessage, session *rpcSession) (response *rpcResponse) {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" || !validRPCID(req.ID) {
+		return rpcErrorResponse(nil, rpcInvalidRequest, "invalid request")
+	}
+	notification := req.ID == nil
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			n.logger.Error("JSON-RPC method panicked", "method", req.Method, "panic", recovered)
+			response = rpcErrorResponse(req.ID, rpcInternalError, "internal error")
+		}
+		if notification {
+			response = nil
+		}
+	}()
+
+	method, exists := n.rpcMethods(session)[req.Method]
+	if !exists {
+		return rpcErrorResponse(req.ID, rpcMethodNotFound, fmt.Sprintf("method %s not found", req.Method))
+	}
+	params, rpcErr := method.bind(req.Params)
+	if rpcErr != nil {
+		return &rpcResponse{JSONRPC: "2.0", Error: rpcErr, ID: req.ID}
+	}
+
+	result, rpcErr := method.handler(params)
+	if rpcErr != nil {
+		return &rpcResponse{JSONRPC: "2.0", Error: rpcErr, ID: req.ID}
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return rpcErrorResponse(req.ID, rpcInternalError, err.Error())
+	}
+	return &rpcResponse{JSONRPC: "2.0", Result: data, ID: req.ID}
+}
+
+// bind converts positional or named parameters into positional ones
+func (m rpcMethod) bind(raw json.RawMessage) (rpcParams, *rpcError) {
+	raw = bytes.TrimSpace(raw)
+	var params rpcParams
+	switch {
+	case len(raw) == 0 || string(raw) == "null":
+	case raw[0] == '[':
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return nil, invalidParams("invalid params: %v", err)
+		}
+		if len(params) > len(m.params) {
+			return nil, invalidParams("expected at most %d parameters, got %d", len(m.params), len(params))
+		}
+	case raw[0] == '{':
+		var named map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &named); err != nil {
+			return nil, invalidParams("invalid params: %v", err)
+		}
+		params = make(rpcParams, len(m.params))
+		for i, name := range m.params {
+			params[i] = named[strings.TrimSuffix(name, "?")]
+			delete(named, strings.TrimSuffix(name, "?"))
+		}
+		for name := range named {
+			return nil, invalidParams("unknown parameter %s", name)
+		}
+	default:
+		return nil, invalidParams("params must be an array or an object")
+	}
+
+	for i, name := range m.params {
+		if !strings.HasSuffix(name, "?") && !params.has(i) {
+			return nil, invalidParams("missing parameter %s", name)
+		}
+	}
+	return params, nil
+}
+
+// validRPCID reports whether an ID is absent, a string, a number or null
+func validRPCID(id json.RawMessage) bool {
+	if id == nil {
+		return true
+	}
+	var value interface{}
+	if err := json.Unmarshal(id, &value); err != nil {
+		return false
+	}
+	switch value.(type) {
+	case nil, string, float64:
+		return true
+	}
+	return false
+}
+
+// rpcErrorResponse creates an error response. A nil ID is answered as null.
+func rpcErrorResponse(id json.RawMessage, code int, message string) *rpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return &rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: code, Message: message}, ID: id}
+}
+
+// marshalRPC encodes a response or batch of responses
+func marshalRPC(v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(rpcErrorResponse(nil, rpcInternalError, err.Error()))
+	}
+	return data
+}
+
+func (n *Node) rpcChainID(p rpcParams) (interface{}, *rpcError) {
+	return n.Blockchain.ChainID(), nil
+}
+
+func (n *Node) rpcNodeStatus(p rpcParams) (interface{}, *rpcError) {
+	return n.GetStatus(), nil
+}
+
+func (n *Node) rpcBlockNumber(p rpcParams) (interface{}, *rpcError) {
+	chain, rpcErr := n.rpcChain(p, 0)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	return chain.GetHeight(), nil
+}
+
+func (n *Node) rpcGetBlockByHeight(p rpcParams) (interface{}, *rpcError) {
+	var height uint64
+	if rpcErr := p.decode(0, "height", &height); rpcErr != nil {
+		return nil, rpcErr
+	}
+	chain, rpcErr := n.rpcChain(p, 1)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	return rpcBlock(chain.GetBlockByHeight(height))
+}
+
+func (n *Node) rpcGetBlockByHash(p rpcParams) (interface{}, *rpcError) {
+	var hash string
+	if rpcErr := p.decode(0, "hash", &hash); rpcErr != nil {
+		return nil, rpcErr
+	}
+	chain, rpcErr := n.rpcChain(p, 1)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	return rpcBlock(chain.GetBlockByHash(hash))
+}
+
+// rpcBlock returns a block with its hash, or a not found error
+func rpcBlock(block *core.Block) (interface{}, *rpcError) {
+	if block == nil {
+		return nil, newAPIRPCError(ErrCodeNotFound, errors.New("block not found"))
+	}
+	result, err := newAPIBlock(block)
+	if err != nil {
+		return nil, newAPIRPCError(ErrCodeInvalidBlock, err)
+	}
+	return result, nil
+}
+
+func (n *Node) rpcGetTransactionByHash(p rpcParams) (interface{}, *rpcError) {
+	var hash string
+	if rpcErr := p.decode(0, "hash", &hash); rpcErr != nil {
+		return nil, rpcErr
+	}
+	chain, rpcErr := n.rpcChain(p, 1)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	txStatus, exists := n.lookupTransaction(chain, hash)
+	if !exists {
+		return nil, newAPIRPCError(ErrCodeNotFound, errors.New("transaction not found"))
+	}
+	return txStatus, nil
+}
+
+func (n *Node) rpcGetTransactionReceipt(p rpcParams) (interface{}, *rpcError) {
+	var hash string
+	if rpcErr := p.decode(0, "hash", &hash); rpcErr != nil {
+		return nil, rpcErr
+	}
+	chain, rpcErr := n.rpcChain(p, 1)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	receipt, exists := n.lookupReceipt(chain, hash)
+	if !exists {
+		return nil, newAPIRPCError(ErrCodeNotFound, errors.New("transaction not found"))
+	}
+	return receipt, nil
+}
+
+func (n *Node) rpcGetBalance(p rpcParams) (interface{}, *rpcError) {
+	var address string
+	if rpcErr := p.decode(0, "address", &address); rpcErr != nil {
+		return nil, rpcErr
+	}
+	chain, rpcErr := n.rpcChain(p, 1)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	return map[string]interface{}{
+		"address":  address,
+		"balance":  chain.State.GetBalance(address),
+		"shard_id": chain.Config.ShardID,
+	}, nil
+}
+
+func (n *Node) rpcSendTransaction(p rpcParams) (interface{}, *rpcError) {
+	var tx core.Transaction
+	if err := json.Unmarshal(p[0], &tx); err != nil {
+		return nil, newAPIRPCError(ErrCodeInvalidTransaction, fmt.Errorf("invalid transaction data: %w", err))
+	}
+	receipt, code, err := n.acceptTransaction(&tx)
+	if err != nil {
+		return nil, newAPIRPCError(code, err)
+	}
+	return receipt, nil
+}
+
+func (n *Node) rpcShardInfo(p rpcParams) (interface{}, *rpcError) {
+	shardID, rpcErr := p.shard(0)
+	if rpcErr != nil {
+		return nil, rpcErr
+	}
+	if shardID < 0 {
+		return n.ShardManager.GetStatus(), nil
+	}
+	shard, err := n.ShardManager.GetShard(shardID)
+	if err != nil {
+		return nil, newAPIRPCError(ErrCodeShardMismatch, err)
+	}
+	return shard.GetStatus(), nil
+}
@@ -0,0 +1,206 @@
+package network
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lscc/config"
+	"lscc/sharding"
+	"lscc/utils"
+)
+
+// newRPCNode returns a stopped node of shard 0 out of two, in which alice
+// holds 100 coins
+func newRPCNode(t *testing.T) *Node {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.NodeID = "node1"
+	cfg.ShardID = 0
+	cfg.ShardCount = 2
+	cfg.LayerCount = 1
+	cfg.Allocations = []config.Allocation{{Address: "alice", Amount: utils.Coins(100), ShardID: 0}}
+	manager := sharding.NewManager(cfg)
+	shard, err := manager.GetShard(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Node{Config: cfg, Blockchain: shard.Blockchain, ShardManager: manager, logger: utils.GetLogger()}
+}
+
+// callRPC answers a JSON-RPC payload and decodes the single response
+func callRPC(t *testing.T, n *Node, payload string) *rpcResponse {
+	t.Helper()
+	data := n.handleRPCPayload([]byte(payload), nil)
+	if data == nil {
+		t.Fatalf("no response to %s", payload)
+	}
+	var response rpcResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		t.Fatalf("response %s: %v", data, err)
+	}
+	return &response
+}
+
+// rpcErrorCode returns the API error code in a JSON-RPC error's data
+func rpcErrorCode(rpcErr *rpcError) string {
+	data, _ := json.Marshal(rpcErr.Data)
+	var errData rpcErrorData
+	json.Unmarshal(data, &errData)
+	return errData.ErrorCode
+}
+
+func TestRPCResults(t *testing.T) {
+	n := newRPCNode(t)
+	response := callRPC(t, n, `{"jsonrpc":"2.0","method":"lscc_chainId","id":1}`)
+	if response.Error != nil || string(response.ID) != "1" {
+		t.Fatalf("chainId = %+v", response)
+	}
+	var chainID string
+	json.Unmarshal(response.Result, &chainID)
+	if chainID != n.Blockchain.ChainID() {
+		t.Errorf("chain ID = %q, want %q", chainID, n.Blockchain.ChainID())
+	}
+
+	// Parameters by position and by name give the same answer
+	for _, params := range []string{`["alice"]`, `{"address":"alice"}`, `["alice",0]`, `{"address":"alice","shard":0}`} {
+		response := callRPC(t, n, `{"jsonrpc":"2.0","method":"lscc_getBalance","params":`+params+`,"id":"a"}`)
+		if response.Error != nil {
+			t.Errorf("getBalance %s: %+v", params, response.Error)
+			continue
+		}
+		var result struct {
+			Balance utils.Amount `json:"balance"`
+			ShardID int          `json:"shard_id"`
+		}
+		json.Unmarshal(response.Result, &result)
+		if result.Balance != utils.Coins(100) || result.ShardID != 0 {
+			t.Errorf("getBalance %s = %s in shard %d, want 100 in shard 0", params, result.Balance, result.ShardID)
+		}
+	}
+
+	// Other shards are inspected with the shard parameter
+	response = callRPC(t, n, `{"jsonrpc":"2.0","method":"lscc_getBalance","params":["alice",1],"id":2}`)
+	if response.Error != nil || !strings.Contains(string(response.Result), `"shard_id":1`) {
+		t.Errorf("getBalance in shard 1 = %s, %+v", response.Result, response.Error)
+	}
+	response = callRPC(t, n, `{"jsonrpc":"2.0","method":"lscc_blockNumber","id":3}`)
+	if response.Error != nil || string(response.Result) != "0" {
+		t.Errorf("blockNumber = %s, %+v", response.Result, response.Error)
+	}
+	response = callRPC(t, n, `{"jsonrpc":"2.0","method":"lscc_getBlockByHeight","params":[0],"id":4}`)
+	if response.Error != nil || !strings.Contains(string(response.Result), `"hash"`) {
+		t.Errorf("getBlockByHeight(0) = %s, %+v", response.Result, response.Error)
+	}
+}
+
+func TestRPCErrors(t *testing.T) {
+	n := newRPCNode(t)
+	tests := []struct {
+		name    string
+		payload string
+		code    int
+		apiCode string
+	}{
+		{"malformed JSON", `{"jsonrpc":`, rpcParseError, ""},
+		{"without a version", `{"method":"lscc_chainId","id":1}`, rpcInvalidRequest, ""},
+		{"with an object ID", `{"jsonrpc":"2.0","method":"lscc_chainId","id":{}}`, rpcInvalidRequest, ""},
+		{"of an unknown method", `{"jsonrpc":"2.0","method":"lscc_mine","id":1}`, rpcMethodNotFound, ""},
+		{"missing a parameter", `{"jsonrpc":"2.0","method":"lscc_getBalance","id":1}`, rpcInvalidParams, ErrCodeInvalidRequest},
+		{"with too many parameters", `{"jsonrpc":"2.0","method":"lscc_getBalance","params":["a",0,1],"id":1}`, rpcInvalidParams, ErrCodeInvalidRequest},
+		{"with an unknown parameter", `{"jsonrpc":"2.0","method":"lscc_getBalance","params":{"address":"a","at":1},"id":1}`, rpcInvalidParams, ErrCodeInvalidRequest},
+		{"with a mistyped parameter", `{"jsonrpc":"2.0","method":"lscc_getBlockByHeight","params":["one"],"id":1}`, rpcInvalidParams, ErrCodeInvalidRequest},
+		{"with scalar params", `{"jsonrpc":"2.0","method":"lscc_getBalance","params":"a","id":1}`, rpcInvalidParams, ErrCodeInvalidRequest},
+		{"of an unknown shard", `{"jsonrpc":"2.0","method":"lscc_getBalance","params":["a",9],"id":1}`, -32005, ErrCodeShardMismatch},
+		{"of a missing block", `{"jsonrpc":"2.0","method":"lscc_getBlockByHeight","params":[9],"id":1}`, -32009, ErrCodeNotFound},
+		{"of a missing receipt", `{"jsonrpc":"2.0","method":"lscc_getTransactionReceipt","params":["none"],"id":1}`, -32009, ErrCodeNotFound},
+		{"sending an unsigned transaction", `{"jsonrpc":"2.0","method":"lscc_sendTransaction","params":[{"from":"alice"}],"id":1}`, -32003, ErrCodeInvalidSignature},
+		{"subscribing over HTTP", `{"jsonrpc":"2.0","method":"lscc_subscribe","params":["blocks"],"id":1}`, rpcInvalidParams, ErrCodeInvalidRequest},
+		{"of an empty batch", `[]`, rpcInvalidRequest, ""},
+	}
+	for _, tt := range tests {
+		response := callRPC(t, n, tt.payload)
+		if response.Error == nil {
+			t.Errorf("request %s answered %s", tt.name, response.Result)
+			continue
+		}
+		if response.Error.Code != tt.code || rpcErrorCode(response.Error) != tt.apiCode {
+			t.Errorf("request %s: error %d %s (%s), want %d %s", tt.name,
+				response.Error.Code, rpcErrorCode(response.Error), response.Error.Message, tt.code, tt.apiCode)
+		}
+	}
+}
+
+func TestRPCBatchesAndNotifications(t *testing.T) {
+	n := newRPCNode(t)
+	if data := n.handleRPCPayload([]byte(`{"jsonrpc":"2.0","method":"lscc_chainId"}`), nil); data != nil {
+		t.Errorf("notification answered with %s", data)
+	}
+	if data := n.handleRPCPayload([]byte(`{"jsonrpc":"2.0","method":"lscc_mine"}`), nil); data != nil {
+		t.Errorf("failing notification answered with %s", data)
+	}
+
+	// Responses keep the IDs of their requests; notifications get none
+	data := n.handleRPCPayload([]byte(`[
+		{"jsonrpc":"2.0","method":"lscc_blockNumber","id":"first"},
+		{"jsonrpc":"2.0","method":"lscc_chainId"},
+		{"jsonrpc":"2.0","method":"lscc_mine","id":2},
+		1
+	]`), nil)
+	var responses []rpcResponse
+	if err := json.Unmarshal(data, &responses); err != nil {
+		t.Fatalf("batch response %s: %v", data, err)
+	}
+	if len(responses) != 3 {
+		t.Fatalf("%d responses, want 3", len(responses))
+	}
+	if string(responses[0].ID) != `"first"` || responses[0].Error != nil {
+		t.Errorf("first response = %+v", responses[0])
+	}
+	if string(responses[1].ID) != "2" || responses[1].Error == nil || responses[1].Error.Code != rpcMethodNotFound {
+		t.Errorf("second response = %+v", responses[1])
+	}
+	if string(responses[2].ID) != "null" || responses[2].Error == nil || responses[2].Error.Code != rpcInvalidRequest {
+		t.Errorf("third response = %+v", responses[2])
+	}
+
+	batch := "[" + strings.TrimSuffix(strings.Repeat(`{"jsonrpc":"2.0","method":"lscc_chainId","id":1},`, rpcMaxBatch+1), ",") + "]"
+	if response := callRPC(t, n, batch); response.Error == nil || response.Error.Code != rpcInvalidRequest {
+		t.Errorf("oversized batch answered: %+v", response)
+	}
+}
+
+func TestRPCOverHTTP(t *testing.T) {
+	n := newRPCNode(t)
+	server := httptest.NewServer(http.HandlerFunc(n.handleRPC))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","method":"lscc_blockNumber","id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("call answered %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	resp, err = http.Post(server.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","method":"lscc_chainId"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("notification answered %d, want %d", resp.StatusCode, http.StatusNoContent)
+	}
+
+	resp, err = http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET without upgrade answered %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,464 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lscc/core"
+)
+
+// Subscriptions
+//
+// Clients follow the node through topics fed by the sharding manager's event
+// bus: newHeads (blocks added to a chain), pendingTransactions (transactions
+// entering a pool), receipts (receipts of an address's transactions as they
+// enter a pool and a block) and relayBlocks (finalized relay blocks). A
+// shard filter narrows any topic. Topics are served as Server-Sent Events at
+// /events and through lscc_subscribe on the JSON-RPC WebSocket.
+
+// Subscription topics
+const (
+	topicNewHeads            = "newHeads"
+	topicPendingTransactions = "pendingTransactions"
+	topicReceipts            = "receipts"
+	topicRelayBlocks         = "relayBlocks"
+)
+
+const (
+	// subscriptionQueueSize bounds the events queued for a slow subscriber
+	subscriptionQueueSize = 256
+	// rpcMaxSubscriptions bounds the subscriptions of a WebSocket connection
+	rpcMaxSubscriptions = 32
+	// sseKeepAlive is how often an idle event stream sends a comment
+	sseKeepAlive = 15 * time.Second
+)
+
+// subscriptionTopic names the events behind a topic and renders them into
+// the items sent to subscribers
+type subscriptionTopic struct {
+	events []core.EventType
+	render func(n *Node, filter subscriptionFilter, event core.Event) []interface{}
+}
+
+// subscriptionTopics lists the topics clients can subscribe to
+var subscriptionTopics = map[string]subscriptionTopic{
+	topicNewHeads:            {[]core.EventType{core.EventBlockAdded}, (*Node).renderNewHead},
+	topicPendingTransactions: {[]core.EventType{core.EventTxAccepted}, (*Node).renderPendingTransaction},
+	topicReceipts:            {[]core.EventType{core.EventTxAccepted, core.EventBlockAdded}, (*Node).renderReceipts},
+	topicRelayBlocks:         {[]core.EventType{core.EventRelayBlockFinalized}, (*Node).renderRelayBlock},
+}
+
+// subscriptionFilter narrows the items of a topic. Without a shard every
+// shard the node keeps is followed.
+type subscriptionFilter struct {
+	Address string `json:"address,omitempty"`
+	Shard   *int   `json:"shard,omitempty"`
+}
+
+// headSummary is a newHeads item
+type headSummary struct {
+	ShardID      int    `json:"shard_id"`
+	Height       uint64 `json:"height"`
+	Hash         string `json:"hash"`
+	PreviousHash string `json:"previous_hash"`
+	Timestamp    int64  `json:"timestamp"`
+	ValidatorID  string `json:"validator_id"`
+	TxCount      int    `json:"tx_count"`
+}
+
+// relayBlockSummary is a relayBlocks item
+type relayBlockSummary struct {
+	ID           string `json:"id"`
+	Hash         string `json:"hash"`
+	Timestamp    int64  `json:"timestamp"`
+	SourceShards []int  `json:"source_shards"`
+	TargetShards []int  `json:"target_shards"`
+	TxCount      int    `json:"tx_count"`
+	VoteCount    int    `json:"vote_count"`
+	CreatedBy    string `json:"created_by"`
+}
+
+// topicItem is an item of a topic, ready to send
+type topicItem struct {
+	topic string
+	item  interface{}
+}
+
+// eventStream delivers the items of some topics from one bus subscription
+type eventStream struct {
+	topics []string
+	filter subscriptionFilter
+	sub    *core.Subscription
+}
+
+// openEventStream validates topics and a filter and subscribes to the
+// events behind them
+func (n *Node) openEventStream(topics []string, filter subscriptionFilter) (*eventStream, error) {
+	if len(topics) == 0 {
+		return nil, errors.New("no topic given")
+	}
+	var types []core.EventType
+	for _, topic := range topics {
+		definition, exists := subscriptionTopics[topic]
+		if !exists {
+			return nil, fmt.Errorf("unknown topic %q", topic)
+		}
+		if topic == topicReceipts && filter.Address == "" {
+			return nil, errors.New("the receipts topic requires an address")
+		}
+		types = append(types, definition.events...)
+	}
+	if filter.Shard != nil {
+		if _, err := n.ShardManager.GetShard(*filter.Shard); err != nil {
+			return nil, fmt.Errorf("shard %d: %w", *filter.Shard, err)
+		}
+	}
+
+	return &eventStream{
+		topics: topics,
+		filter: filter,
+		sub:    n.ShardManager.Events().Subscribe("subscription:"+strings.Join(topics, ","), subscriptionQueueSize, types...),
+	}, nil
+}
+
+// items renders an event into the items of the stream's topics
+func (n *Node) streamItems(stream *eventStream, event core.Event) []topicItem {
+	var items []topicItem
+	for _, topic := range stream.topics {
+		definition := subscriptionTopics[topic]
+		if !hasEventType(definition.events, event.Type) {
+			continue
+		}
+		for _, item := range definition.render(n, stream.filter, event) {
+			items = append(items, topicItem{topic: topic, item: item})
+		}
+	}
+	return items
+}
+
+// hasEventType reports whether types lists an event type
+func hasEventType(types []core.EventType, eventType core.EventType) bool {
+	for _, t := range types {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesShard reports whether a filter lets a shard's events through
+func (f subscriptionFilter) matchesShard(shardID int) bool {
+	return f.Shard == nil || *f.Shard == shardID
+}
+
+func (n *Node) renderNewHead(filter subscriptionFilter, event core.Event) []interface{} {
+	if !filter.matchesShard(event.ShardID) {
+		return nil
+	}
+	hash, err := event.Block.Hash()
+	if err != nil {
+		return nil
+	}
+	header := event.Block.Header
+	return []interface{}{headSummary{
+		ShardID:      event.ShardID,
+		Height:       header.Height,
+		Hash:         hash,
+		PreviousHash: header.PreviousHash,
+		Timestamp:    header.Timestamp,
+		ValidatorID:  header.ValidatorID,
+		TxCount:      len(event.Block.Transactions),
+	}}
+}
+
+func (n *Node) renderPendingTransaction(filter subscriptionFilter, event core.Event) []interface{} {
+	if !filter.matchesShard(event.ShardID) {
+		return nil
+	}
+	return []interface{}{event.Transaction}
+}
+
+func (n *Node) renderReceipts(filter subscriptionFilter, event core.Event) []interface{} {
+	if !filter.matchesShard(event.ShardID) {
+		return nil
+	}
+	chain, err := n.shardChain(event.ShardID)
+	if err != nil {
+		return nil
+	}
+
+	var hashes []string
+	switch event.Type {
+	case core.EventTxAccepted:
+		if involves(event.Transaction, filter.Address) {
+			hashes = append(hashes, event.Transaction.Hash)
+		}
+	case core.EventBlockAdded:
+		for i := range event.Block.Transactions {
+			if tx := &event.Block.Transactions[i]; involves(tx, filter.Address) {
+				hashes = append(hashes, tx.Hash)
+			}
+		}
+	}
+
+	var receipts []interface{}
+	for _, hash := range hashes {
+		if receipt, exists := n.lookupReceipt(chain, hash); exists {
+			receipts = append(receipts, receipt)
+		}
+	}
+	return receipts
+}
+
+// involves reports whether an address sends or receives a transaction
+func involves(tx *core.Transaction, address string) bool {
+	return tx.From == address || tx.To == address
+}
+
+func (n *Node) renderRelayBlock(filter subscriptionFilter, event core.Event) []interface{} {
+	relayBlock := event.RelayBlock
+	if filter.Shard != nil && !containsShard(relayBlock.SourceShards, *filter.Shard) &&
+		!containsShard(relayBlock.TargetShards, *filter.Shard) {
+		return nil
+	}
+	return []interface{}{relayBlockSummary{
+		ID:           relayBlock.ID,
+		Hash:         relayBlock.Hash,
+		Timestamp:    relayBlock.Timestamp,
+		SourceShards: relayBlock.SourceShards,
+		TargetShards: relayBlock.TargetShards,
+		TxCount:      len(relayBlock.CrossShardTxs),
+		VoteCount:    len(relayBlock.Votes),
+		CreatedBy:    relayBlock.CreatedBy,
+	}}
+}
+
+// containsShard reports whether shards lists a shard
+func containsShard(shards []int, shardID int) bool {
+	for _, id := range shards {
+		if id == shardID {
+			return true
+		}
+	}
+	return false
+}
+
+// handleEvents streams topics as Server-Sent Events:
+// GET /events?topics=newHeads,receipts&address=A&shard=N. The stream runs
+// until the client goes away or the node stops.
+func (n *Node) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	query := r.URL.Query()
+	var topics []string
+	for _, topic := range strings.Split(query.Get("topics"), ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	filter := subscriptionFilter{Address: query.Get("address")}
+	if shardParam := query.Get("shard"); shardParam != "" {
+		shardID, err := strconv.Atoi(shardParam)
+		if err != nil || shardID < 0 {
+			writeError(w, http.StatusBadRequest, errors.New("invalid shard id"))
+			return
+		}
+		filter.Shard = &shardID
+	}
+
+	stream, err := n.openEventStream(topics, filter)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer stream.sub.Unsubscribe()
+
+	// The stream outlives the server's write timeout, so it takes over the
+	// connection and ends the response by closing it
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Time{})
+
+	rw.WriteString("HTTP/1.1 200 OK\r\n" +
+		"Content-Type: text/event-stream\r\n" +
+		"Cache-Control: no-cache\r\n" +
+		"Access-Control-Allow-Origin: *\r\n" +
+		"Connection: close\r\n\r\n" +
+		": subscribed to " + strings.Join(topics, ",") + "\n\n")
+	if err := rw.Flush(); err != nil {
+		return
+	}
+	n.logger.Debug("Event stream opened", "remote", r.RemoteAddr, "topics", topics)
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	var id uint64
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-keepAlive.C:
+			rw.WriteString(": keepalive\n\n")
+		case event, open := <-stream.sub.Events():
+			if !open {
+				return
+			}
+			for _, item := range n.streamItems(stream, event) {
+				data, err := json.Marshal(item.item)
+				if err != nil {
+					continue
+				}
+				id++
+				fmt.Fprintf(rw, "id: %d\nevent: %s\ndata: %s\n\n", id, item.topic, data)
+			}
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := rw.Flush(); err != nil {
+			n.logger.Debug("Event stream closed", "remote", r.RemoteAddr, "reason", err)
+			return
+		}
+	}
+}
+
+// rpcSession holds the subscriptions of a JSON-RPC WebSocket connection.
+// Subscriptions start delivering once the response creating them is sent.
+type rpcSession struct {
+	conn          *wsConn
+	subscriptions map[string]*eventStream
+	pending       []string
+	nextID        uint64
+	mu            sync.Mutex
+}
+
+// rpcNotification is a JSON-RPC notification sent to a subscriber
+type rpcNotification struct {
+	JSONRPC string                `json:"jsonrpc"`
+	Method  string                `json:"method"`
+	Params  rpcSubscriptionResult `json:"params"`
+}
+
+// rpcSubscriptionResult carries an item of a subscription
+type rpcSubscriptionResult struct {
+	Subscription string      `json:"subscription"`
+	Topic        string      `json:"topic"`
+	Result       interface{} `json:"result"`
+}
+
+// newRPCSession creates the session of a WebSocket connection
+func newRPCSession(conn *wsConn) *rpcSession {
+	return &rpcSession{conn: conn, subscriptions: make(map[string]*eventStream)}
+}
+
+// rpcSubscribe subscribes the session to a topic, returning the
+// subscription ID that tags its notifications
+func (n *Node) rpcSubscribe(session *rpcSession, p rpcParams) (interface{}, *rpcError) {
+	if session == nil {
+		return nil, invalidParams("subscriptions require a WebSocket connection")
+	}
+	var topic string
+	if rpcErr := p.decode(0, "topic", &topic); rpcErr != nil {
+		return nil, rpcErr
+	}
+	var filter subscriptionFilter
+	if p.has(1) {
+		if rpcErr := p.decode(1, "filter", &filter); rpcErr != nil {
+			return nil, rpcErr
+		}
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if len(session.subscriptions) >= rpcMaxSubscriptions {
+		return nil, invalidParams("connection exceeds %d subscriptions", rpcMaxSubscriptions)
+	}
+	stream, err := n.openEventStream([]string{topic}, filter)
+	if err != nil {
+		return nil, invalidParams("%v", err)
+	}
+	session.nextID++
+	id := fmt.Sprintf("0x%x", session.nextID)
+	session.subscriptions[id] = stream
+	session.pending = append(session.pending, id)
+	return id, nil
+}
+
+// rpcUnsubscribe ends a subscription of the session
+func (n *Node) rpcUnsubscribe(session *rpcSession, p rpcParams) (interface{}, *rpcError) {
+	if session == nil {
+		return nil, invalidParams("subscriptions require a WebSocket connection")
+	}
+	var id string
+	if rpcErr := p.decode(0, "subscription", &id); rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	session.mu.Lock()
+	stream, exists := session.subscriptions[id]
+	delete(session.subscriptions, id)
+	session.mu.Unlock()
+
+	if !exists {
+		return nil, newAPIRPCError(ErrCodeNotFound, fmt.Errorf("subscription %s not found", id))
+	}
+	stream.sub.Unsubscribe()
+	return true, nil
+}
+
+// start begins delivering the subscriptions created since the last call
+func (s *rpcSession) start(n *Node) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range s.pending {
+		if stream, exists := s.subscriptions[id]; exists {
+			go s.deliver(n, id, stream)
+		}
+	}
+	s.pending = nil
+}
+
+// deliver sends a subscription's items until it ends
+func (s *rpcSession) deliver(n *Node, id string, stream *eventStream) {
+	for event := range stream.sub.Events() {
+		for _, item := range n.streamItems(stream, event) {
+			notification := rpcNotification{
+				JSONRPC: "2.0",
+				Method:  "lscc_subscription",
+				Params:  rpcSubscriptionResult{Subscription: id, Topic: item.topic, Result: item.item},
+			}
+			if err := s.conn.WriteMessage(wsText, marshalRPC(notification)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// close ends every subscription of the session
+func (s *rpcSession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, stream := range s.subscriptions {
+		stream.sub.Unsubscribe()
+		delete(s.subscriptions, id)
+	}
+	s.pending = nil
+}
@@ -0,0 +1,214 @@
+package network
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lscc/core"
+	"lscc/utils"
+)
+
+// genesisOf returns the genesis block of a shard kept by the node
+func genesisOf(t *testing.T, n *Node, shardID int) *core.Block {
+	t.Helper()
+	chain, err := n.shardChain(shardID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain.GetBlockByHeight(0)
+}
+
+// nextEvent returns the next event of a stream's subscription
+func nextEvent(t *testing.T, stream *eventStream) core.Event {
+	t.Helper()
+	select {
+	case event := <-stream.sub.Events():
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event delivered")
+		return core.Event{}
+	}
+}
+
+func TestOpenEventStreamChecksTopics(t *testing.T) {
+	n := newRPCNode(t)
+	unknownShard := 9
+	tests := []struct {
+		name   string
+		topics []string
+		filter subscriptionFilter
+	}{
+		{"no topic", nil, subscriptionFilter{}},
+		{"an unknown topic", []string{"blocks"}, subscriptionFilter{}},
+		{"receipts without an address", []string{topicReceipts}, subscriptionFilter{}},
+		{"an unknown shard", []string{topicNewHeads}, subscriptionFilter{Shard: &unknownShard}},
+	}
+	for _, tt := range tests {
+		if stream, err := n.openEventStream(tt.topics, tt.filter); err == nil {
+			stream.sub.Unsubscribe()
+			t.Errorf("stream with %s opened", tt.name)
+		}
+	}
+	if subscribers := len(n.ShardManager.Events().Stats().Subscribers); subscribers != 0 {
+		t.Errorf("%d subscribers left by refused streams", subscribers)
+	}
+}
+
+func TestStreamItemsFollowFilters(t *testing.T) {
+	n := newRPCNode(t)
+	shard := 1
+	stream, err := n.openEventStream([]string{topicNewHeads, topicPendingTransactions}, subscriptionFilter{Shard: &shard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.sub.Unsubscribe()
+
+	bus := n.ShardManager.Events()
+	bus.Publish(core.Event{Type: core.EventBlockAdded, ShardID: 0, Block: genesisOf(t, n, 0)})
+	bus.Publish(core.Event{Type: core.EventBlockAdded, ShardID: 1, Block: genesisOf(t, n, 1)})
+	bus.Publish(core.Event{Type: core.EventPeerConnected, ShardID: 1, Peer: &core.PeerInfo{ID: "node2"}})
+
+	if items := n.streamItems(stream, nextEvent(t, stream)); len(items) != 0 {
+		t.Errorf("block of shard 0 rendered into %d items", len(items))
+	}
+	items := n.streamItems(stream, nextEvent(t, stream))
+	if len(items) != 1 || items[0].topic != topicNewHeads {
+		t.Fatalf("block of shard 1 rendered into %+v, want one newHeads item", items)
+	}
+	head := items[0].item.(headSummary)
+	if head.ShardID != 1 || head.Height != 0 || head.Hash == "" {
+		t.Errorf("head = %+v", head)
+	}
+	select {
+	case event := <-stream.sub.Events():
+		t.Errorf("%s event delivered to a stream not following it", event.Type)
+	default:
+	}
+}
+
+func TestReceiptsFollowAddress(t *testing.T) {
+	n := newRPCNode(t)
+	stream, err := n.openEventStream([]string{topicReceipts}, subscriptionFilter{Address: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.sub.Unsubscribe()
+
+	for _, to := range []string{"carol", "bob"} {
+		tx, err := core.NewTransaction("alice", to, utils.Coins(1), utils.Coins(1), 0, 0, 0, core.RegularTransaction)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Sign("alice"); err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Blockchain.AddTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+
+		items := n.streamItems(stream, nextEvent(t, stream))
+		if to == "carol" {
+			if len(items) != 0 {
+				t.Errorf("payment to carol rendered into %d items", len(items))
+			}
+			continue
+		}
+		if len(items) != 1 {
+			t.Fatalf("payment to bob rendered into %d items, want 1", len(items))
+		}
+		receipt := items[0].item.(*core.Receipt)
+		if receipt.TxHash != tx.Hash || receipt.Status != core.TxPending {
+			t.Errorf("receipt = %+v, want pending receipt of %s", receipt, tx.Hash)
+		}
+	}
+}
+
+func TestRPCSubscriptions(t *testing.T) {
+	n := newRPCNode(t)
+	session := newRPCSession(nil)
+	defer session.close()
+
+	data := n.handleRPCPayload([]byte(`{"jsonrpc":"2.0","method":"lscc_subscribe","params":["newHeads",{"shard":1}],"id":1}`), session)
+	var response rpcResponse
+	json.Unmarshal(data, &response)
+	var id string
+	if response.Error != nil || json.Unmarshal(response.Result, &id) != nil || id != "0x1" {
+		t.Fatalf("subscribe answered %s", data)
+	}
+	if len(session.pending) != 1 || session.subscriptions[id] == nil {
+		t.Fatalf("subscription %s not held for delivery", id)
+	}
+
+	response = *callRPC(t, n, `{"jsonrpc":"2.0","method":"lscc_subscribe","params":["receipts"],"id":2}`)
+	if response.Error == nil {
+		t.Errorf("subscription without a session answered %s", response.Result)
+	}
+	data = n.handleRPCPayload([]byte(`{"jsonrpc":"2.0","method":"lscc_subscribe","params":["receipts"],"id":2}`), session)
+	if !strings.Contains(string(data), `"code":-32602`) {
+		t.Errorf("receipts subscription without an address answered %s", data)
+	}
+
+	unsubscribe := []byte(`{"jsonrpc":"2.0","method":"lscc_unsubscribe","params":["0x1"],"id":3}`)
+	if data := n.handleRPCPayload(unsubscribe, session); !strings.Contains(string(data), `"result":true`) {
+		t.Errorf("unsubscribe answered %s", data)
+	}
+	if data := n.handleRPCPayload(unsubscribe, session); !strings.Contains(string(data), `"code":-32009`) {
+		t.Errorf("second unsubscribe answered %s", data)
+	}
+	if subscribers := len(n.ShardManager.Events().Stats().Subscribers); subscribers != 0 {
+		t.Errorf("%d subscribers left after unsubscribing", subscribers)
+	}
+}
+
+func TestEventStreamOverHTTP(t *testing.T) {
+	n := newRPCNode(t)
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	defer n.cancel()
+	server := httptest.NewServer(http.HandlerFunc(n.handleEvents))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?topics=receipts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("receipts stream without an address answered %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + "?topics=newHeads&shard=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("stream answered %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// The stream is subscribed once its opening comment arrives
+	reader := bufio.NewReader(resp.Body)
+	if line, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(line, ": subscribed") {
+		t.Fatalf("first line %q, %v", line, err)
+	}
+	n.ShardManager.Events().Publish(core.Event{Type: core.EventBlockAdded, ShardID: 1, Block: genesisOf(t, n, 1)})
+
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if lines[0] != "id: 1" || lines[1] != "event: newHeads" || !strings.Contains(lines[2], `"shard_id":1`) {
+		t.Errorf("event = %q", lines)
+	}
+}
@@ -0,0 +1,132 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"lscc/core"
+	"lscc/sharding"
+)
+
+// swapRequest is the body of the swap endpoints that act on an existing swap
+type swapRequest struct {
+	SwapID      string            `json:"swap_id"`
+	Transaction *core.Transaction `json:"transaction,omitempty"`
+}
+
+// handleShard returns the status of a shard, including its chain height
+func (n *Node) handleShard(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("shard id is required"))
+		return
+	}
+	shard, err := n.ShardManager.GetShard(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, shard.GetStatus())
+}
+
+// handleSwaps lists the atomic swaps coordinated by this node
+func (n *Node) handleSwaps(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	swaps := n.ShardManager.GetSwapCoordinator().GetSwaps()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"swaps": swaps,
+		"count": len(swaps),
+	})
+}
+
+// handleSwap returns a single swap
+func (n *Node) handleSwap(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/swaps/")
+	swap, exists := n.ShardManager.GetSwapCoordinator().GetSwap(id)
+	if !exists {
+		writeError(w, http.StatusNotFound, errors.New("swap not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, swap)
+}
+
+// handleSwapInitiate accepts the initiator's signed lock transaction
+func (n *Node) handleSwapInitiate(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var tx core.Transaction
+	if err := json.NewDecoder(r.Body).Decode(&tx); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid transaction data: %w", err))
+		return
+	}
+
+	swap, err := n.ShardManager.GetSwapCoordinator().Initiate(&tx)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, swap)
+}
+
+// handleSwapTx accepts a signed transaction advancing an existing swap
+func (n *Node) handleSwapTx(step func(*sharding.SwapCoordinator, string, *core.Transaction) (*sharding.AtomicSwap, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requireMethod(w, r, http.MethodPost) {
+			return
+		}
+
+		var req swapRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid swap request: %w", err))
+			return
+		}
+		if req.Transaction == nil {
+			writeError(w, http.StatusBadRequest, errors.New("transaction is required"))
+			return
+		}
+
+		swap, err := step(n.ShardManager.GetSwapCoordinator(), req.SwapID, req.Transaction)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, swap)
+	}
+}
+
+// handleSwapRefund refunds the expired locks of a swap
+func (n *Node) handleSwapRefund(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	var req swapRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid swap request: %w", err))
+		return
+	}
+
+	swap, err := n.ShardManager.GetSwapCoordinator().Refund(req.SwapID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, swap)
+}
@@ -0,0 +1,151 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lscc/core"
+	"lscc/tracing"
+)
+
+// tracingQueueSize bounds the events waiting to be recorded as spans
+const tracingQueueSize = 1024
+
+// traceFlushInterval is how often recorded spans are exported
+const traceFlushInterval = time.Second
+
+// maxTraceImport bounds the size of a span batch posted to /v1/traces
+const maxTraceImport = 8 << 20
+
+// startTracing sets the node as the service its spans are recorded for,
+// sets up the configured exporters and exports spans until the node stops
+func (n *Node) startTracing() error {
+	opts := n.Config.Tracing
+
+	var file, collector tracing.Exporter
+	if opts.File != "" {
+		path := opts.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(n.Config.DataDir, path)
+		}
+		exporter, err := tracing.NewFileExporter(path)
+		if err != nil {
+			return fmt.Errorf("failed to open trace file: %w", err)
+		}
+		file = exporter
+	}
+	if opts.CollectorURL != "" {
+		collector = tracing.NewCollectorExporter(opts.CollectorURL)
+	}
+
+	tracer := tracing.Default()
+	tracer.Configure(n.ID, file, collector)
+	go tracer.Run(n.ctx.Done(), traceFlushInterval, func(err error) {
+		n.logger.Warn("Failed to export spans", "error", err)
+	})
+	return nil
+}
+
+// recordTraces records the pool acceptance and block inclusion of
+// transactions in their traces until the node stops. Spans of a credit go
+// to the traces of the transactions it delivers.
+func (n *Node) recordTraces(sub *core.Subscription) {
+	defer sub.Unsubscribe()
+
+	tracer := tracing.Default()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case event := <-sub.Events():
+			switch event.Type {
+			case core.EventTxAccepted:
+				tx := event.Transaction
+				name := "mempool.add"
+				if tx.IsCrossShard() && !tx.IsIncomingCredit() {
+					name = "sharding.accept"
+				}
+				for _, origin := range tracer.Origins(tx.Hash) {
+					tracer.Event(origin, name, traceAttributes(tx, origin, "shard", event.ShardID)...)
+				}
+			case core.EventBlockAdded:
+				block := event.Block
+				blockHash, _ := block.Hash()
+				for i := range block.Transactions {
+					tx := &block.Transactions[i]
+					if tx.Type == core.ConsensusTransaction {
+						continue
+					}
+					for _, origin := range tracer.Origins(tx.Hash) {
+						tracer.Event(origin, "block.include", traceAttributes(tx, origin,
+							"shard", event.ShardID,
+							"block.height", block.Header.Height,
+							"block.hash", blockHash)...)
+					}
+				}
+			}
+		}
+	}
+}
+
+// traceAttributes adds the hash of a credit to the attributes of a span
+// recorded in the trace of the transaction it delivers
+func traceAttributes(tx *core.Transaction, origin string, keyvals ...interface{}) []interface{} {
+	if tx.Hash != origin {
+		keyvals = append(keyvals, "credit.hash", tx.Hash)
+	}
+	return keyvals
+}
+
+// handleTrace returns the spans this node holds for a transaction as an
+// OTLP/JSON export request
+func (n *Node) handleTrace(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+
+	hash := strings.TrimPrefix(r.URL.Path, "/traces/")
+	if hash == "" {
+		writeError(w, http.StatusBadRequest, errors.New("transaction hash is required"))
+		return
+	}
+	spans := tracing.Default().Trace(hash)
+	if len(spans) == 0 {
+		writeError(w, http.StatusNotFound, errors.New("trace not found"))
+		return
+	}
+
+	body, err := tracing.EncodeOTLP(spans)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// handleTraceImport accepts spans from other nodes in the OTLP/JSON format,
+// so a node can stand in for a collector
+func (n *Node) handleTraceImport(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxTraceImport))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	spans, err := tracing.DecodeOTLP(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	tracing.Default().Import(spans)
+	writeJSON(w, http.StatusOK, map[string]interface{}{})
+}
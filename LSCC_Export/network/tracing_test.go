@@ -0,0 +1,117 @@
+package network
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"lscc/core"
+	"lscc/tracing"
+)
+
+// spanNamed returns the span of a trace with the given name
+func spanNamed(spans []tracing.Span, name string) *tracing.Span {
+	for i := range spans {
+		if spans[i].Name == name {
+			return &spans[i]
+		}
+	}
+	return nil
+}
+
+func TestRecordTracesFollowCredits(t *testing.T) {
+	n := newRPCNode(t)
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	defer n.cancel()
+	tracer := tracing.Default()
+	go n.recordTraces(n.ShardManager.Events().Subscribe("tracing", tracingQueueSize))
+
+	origin := &core.Transaction{Hash: "trace-origin", Type: core.CrossShardTransaction, SourceShard: 0, TargetShard: 1}
+	credit := core.Transaction{Hash: "trace-credit", Type: core.RegularTransaction}
+	tracer.Link(credit.Hash, origin.Hash)
+	block := &core.Block{
+		Header:       core.BlockHeader{Height: 5},
+		Transactions: []core.Transaction{credit, {Hash: "trace-coinbase", Type: core.ConsensusTransaction}},
+		ShardID:      1,
+	}
+
+	bus := n.ShardManager.Events()
+	bus.Publish(core.Event{Type: core.EventTxAccepted, ShardID: 0, Transaction: origin})
+	bus.Publish(core.Event{Type: core.EventBlockAdded, ShardID: 1, Block: block})
+	waitFor(t, "the credit's inclusion to be traced", func() bool {
+		return spanNamed(tracer.Trace(origin.Hash), "block.include") != nil
+	})
+
+	spans := tracer.Trace(origin.Hash)
+	if accept := spanNamed(spans, "sharding.accept"); accept == nil || accept.Attributes["shard"] != 0 {
+		t.Errorf("acceptance span = %+v", accept)
+	}
+	include := spanNamed(spans, "block.include")
+	if include.Attributes["credit.hash"] != credit.Hash || include.Attributes["shard"] != 1 ||
+		include.Attributes["block.height"] != uint64(5) {
+		t.Errorf("inclusion span attributes = %v", include.Attributes)
+	}
+	if len(tracer.Trace(credit.Hash)) != 0 || len(tracer.Trace("trace-coinbase")) != 0 {
+		t.Error("credit or coinbase traced on their own")
+	}
+}
+
+func TestTraceEndpoints(t *testing.T) {
+	n := newRPCNode(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/traces/", n.handleTrace)
+	mux.HandleFunc("/v1/traces", n.handleTraceImport)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	start := time.Now()
+	body, err := tracing.EncodeOTLP([]tracing.Span{{
+		TraceID: tracing.TraceID("trace-imported"), SpanID: "0102030405060708",
+		Name: "tx.submit", Service: "node2", Start: start, End: start,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(server.URL+"/v1/traces", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("import answered %d", resp.StatusCode)
+	}
+	resp, err = http.Post(server.URL+"/v1/traces", "application/json", strings.NewReader("{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("malformed import answered %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	resp, err = http.Get(server.URL + "/traces/trace-imported")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	spans, err := tracing.DecodeOTLP(data)
+	if err != nil || len(spans) != 1 || spans[0].Service != "node2" {
+		t.Errorf("trace = %s, %v", data, err)
+	}
+
+	for path, want := range map[string]int{"/traces/": http.StatusBadRequest, "/traces/trace-unknown": http.StatusNotFound} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET %s answered %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
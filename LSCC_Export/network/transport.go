@@ -0,0 +1,33 @@
+package network
+
+import (
+	"net"
+	"time"
+)
+
+// Transport carries the connections between nodes. Nodes use TCP unless
+// given another transport, as simulations do to run nodes in one process.
+// Every Write on a connection carries one whole message.
+type Transport interface {
+	Listen(address string) (net.Listener, error)
+	Dial(address string, timeout time.Duration) (net.Conn, error)
+}
+
+// tcpTransport connects nodes over TCP
+type tcpTransport struct{}
+
+func (tcpTransport) Listen(address string) (net.Listener, error) {
+	return net.Listen("tcp", address)
+}
+
+func (tcpTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", address, timeout)
+}
+
+// SetTransport sets the transport the node listens and dials on. It must be
+// called before the node starts.
+func (n *Node) SetTransport(transport Transport) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.transport = transport
+}
@@ -7,6 +7,7 @@ import (
 
         "lscc/config"
         "lscc/core"
+        "lscc/tracing"
         "lscc/utils"
 )
 
@@ -18,10 +19,19 @@ type CrossChannel struct {
         pendingBlocks    map[string]*core.Block
         txConfirmations  map[string]map[int]bool // Maps tx hash to a map of shard IDs that confirmed it
         blockConfirmations map[string]map[int]bool // Maps block hash to a map of shard IDs that confirmed it
+        awaitingFinality []receipt                 // Confirmations held until final in the confirming shard
         mu               sync.RWMutex
         logger           *utils.Logger
 }
 
+// receipt is a shard's confirmation of a cross-shard transaction. It counts
+// once the transaction carrying it in that shard is final.
+type receipt struct {
+        txHash    string
+        shardID   int
+        localHash string
+}
+
 // NewCrossChannel creates a new cross-channel mechanism
 func NewCrossChannel(manager *Manager, cfg *config.Config) *CrossChannel {
         return &CrossChannel{
@@ -31,7 +41,7 @@ func NewCrossChannel(manager *Manager, cfg *config.Config) *CrossChannel {
                 pendingBlocks:    make(map[string]*core.Block),
                 txConfirmations:  make(map[string]map[int]bool),
                 blockConfirmations: make(map[string]map[int]bool),
-                logger:           utils.GetLogger(),
+                logger:           utils.GetLogger().Named("sharding"),
         }
 }
 
@@ -48,6 +58,10 @@ func (cc *CrossChannel) PropagateTransaction(tx *core.Transaction, sourceShard,
                 cc.txConfirmations[tx.Hash] = make(map[int]bool)
         }
         
+        tracing.Default().Event(tx.Hash, "crosschannel.propagate",
+                "source_shard", sourceShard,
+                "target_shard", targetShard)
+        
         cc.logger.Info("Transaction propagated through cross-channel", 
                 "txHash", tx.Hash, 
                 "sourceShard", sourceShard, 
@@ -107,6 +121,52 @@ func (cc *CrossChannel) ConfirmTransaction(txHash string, shardID int) error {
         return nil
 }
 
+// ConfirmWhenFinal records a shard's confirmation of a cross-shard
+// transaction, to be counted once localHash, the transaction that carries it
+// in that shard, is final under the shard's own consensus rules
+func (cc *CrossChannel) ConfirmWhenFinal(txHash string, shardID int, localHash string) {
+        cc.mu.Lock()
+        cc.awaitingFinality = append(cc.awaitingFinality, receipt{
+                txHash:    txHash,
+                shardID:   shardID,
+                localHash: localHash,
+        })
+        cc.mu.Unlock()
+
+        cc.logger.Debug("Cross-shard receipt awaiting finality",
+                "txHash", txHash,
+                "shardID", shardID,
+                "localHash", localHash)
+}
+
+// processReceipts counts the held confirmations whose transactions have
+// become final in their shards
+func (cc *CrossChannel) processReceipts() {
+        cc.mu.Lock()
+        receipts := cc.awaitingFinality
+        cc.awaitingFinality = nil
+        cc.mu.Unlock()
+
+        var waiting []receipt
+        for _, r := range receipts {
+                shard, err := cc.manager.GetShard(r.shardID)
+                if err != nil {
+                        continue
+                }
+                if !shard.IsTransactionFinal(r.localHash) {
+                        waiting = append(waiting, r)
+                        continue
+                }
+                if err := cc.ConfirmTransaction(r.txHash, r.shardID); err != nil {
+                        cc.logger.Debug("Dropped cross-shard receipt", "txHash", r.txHash, "error", err)
+                }
+        }
+
+        cc.mu.Lock()
+        cc.awaitingFinality = append(waiting, cc.awaitingFinality...)
+        cc.mu.Unlock()
+}
+
 // ConfirmBlock marks a block as confirmed by a shard
 func (cc *CrossChannel) ConfirmBlock(blockHash string, shardID int) error {
         cc.mu.Lock()
@@ -194,15 +254,24 @@ func (cc *CrossChannel) ProcessCrossShardTransaction(tx *core.Transaction) error
 
 // Start starts the cross-channel service
 func (cc *CrossChannel) Start() error {
-        // Start cleanup routine
+        receiptInterval := time.Duration(cc.config.BlockTime) * time.Second
+        if receiptInterval <= 0 {
+                receiptInterval = time.Second
+        }
+        
+        // Start cleanup and receipt routines
         go func() {
                 ticker := time.NewTicker(5 * time.Minute)
                 defer ticker.Stop()
+                receiptTicker := time.NewTicker(receiptInterval)
+                defer receiptTicker.Stop()
                 
                 for {
                         select {
                         case <-ticker.C:
                                 cc.CleanupOldTransactions()
+                        case <-receiptTicker.C:
+                                cc.processReceipts()
                         }
                 }
         }()
@@ -227,5 +296,6 @@ func (cc *CrossChannel) GetStatus() map[string]interface{} {
                 "pending_block_count": len(cc.pendingBlocks),
                 "tx_confirmations":    len(cc.txConfirmations),
                 "block_confirmations": len(cc.blockConfirmations),
+                "awaiting_finality":   len(cc.awaitingFinality),
         }
 }
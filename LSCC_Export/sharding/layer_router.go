@@ -0,0 +1,553 @@
+package sharding
+
+import (
+        "encoding/json"
+        "errors"
+        "fmt"
+        "sort"
+        "sync"
+        "time"
+
+        "lscc/config"
+        "lscc/core"
+        "lscc/tracing"
+        "lscc/utils"
+)
+
+// Layer transfer semantics
+//
+// Value may only move between adjacent layers, and always through a relay
+// node of the source shard. A transfer is debited in its source shard's
+// chain and only credited once that debit is final there; each credit names
+// the transfers it pays out, which the receiving chain checks.
+//   - Downward transfers (layer L+1 -> layer L) are delivered to the target
+//     shard in the first round after their debit is final.
+//   - Upward transfers (layer L -> layer L+1) are collected per source shard
+//     and settled in aggregate by the parent shard once per settlement
+//     round: one credit per recipient, netting all final transfers in the
+//     round. The lower shard's latest final header is anchored into the
+//     parent chain via a CrossRef in the same round.
+//
+// Finality follows each shard's own engine, so a PBFT shard's headers are
+// anchored as soon as they commit while a PoW or PoS shard's wait for its
+// confirmation depth. A credit only counts as the receiving shard's
+// confirmation once it is final there.
+
+// LayerSettlement is the record attached to an aggregate settlement credit
+type LayerSettlement struct {
+        core.CreditSource
+        SourceLayer  int    `json:"source_layer"`
+        AnchorHash   string `json:"anchor_hash"`
+        AnchorHeight uint64 `json:"anchor_height"`
+}
+
+// LayerRouter moves value between layers of the shard hierarchy
+type LayerRouter struct {
+        manager      *Manager
+        config       *config.Config
+        pendingDown  []*core.Transaction         // Downward transfers awaiting delivery
+        pendingUp    map[int][]*core.Transaction // Upward transfers awaiting settlement, by source shard
+        lastAnchored map[int]uint64              // Last anchored height, by lower-layer shard
+        settledCount int
+        routedCount  int
+        running      bool
+        stopChan     chan struct{}
+        mu           sync.Mutex
+        logger       *utils.Logger
+}
+
+// NewLayerRouter creates a new layer router
+func NewLayerRouter(manager *Manager, cfg *config.Config) *LayerRouter {
+        return &LayerRouter{
+                manager:      manager,
+                config:       cfg,
+                pendingUp:    make(map[int][]*core.Transaction),
+                lastAnchored: make(map[int]uint64),
+                logger:       utils.GetLogger().Named("sharding"),
+        }
+}
+
+// SettlementAddress returns the account that funds settlement credits for
+// transfers leaving a shard
+func SettlementAddress(shardID int) string {
+        return fmt.Sprintf("settlement:shard-%d", shardID)
+}
+
+// ParentShard returns the shard in the next layer up that settles for shardID
+func (lr *LayerRouter) ParentShard(shardID int) (int, error) {
+        layer := lr.config.LayerOfShard(shardID)
+        if layer+1 >= lr.manager.layerCount {
+                return -1, errors.New("shard is in the top layer")
+        }
+        return shardID + lr.config.ShardCount, nil
+}
+
+// validateRoute checks that a layer transaction connects adjacent layers
+func (lr *LayerRouter) validateRoute(tx *core.Transaction) (*Shard, *Shard, error) {
+        if !tx.IsCrossLayer() {
+                return nil, nil, errors.New("not a layer transaction")
+        }
+
+        source, err := lr.manager.GetShard(tx.SourceShard)
+        if err != nil {
+                return nil, nil, err
+        }
+        target, err := lr.manager.GetShard(tx.TargetShard)
+        if err != nil {
+                return nil, nil, err
+        }
+
+        if tx.Layer != source.Layer {
+                return nil, nil, fmt.Errorf("transaction layer %d does not match source shard layer %d", tx.Layer, source.Layer)
+        }
+        if diff := target.Layer - source.Layer; diff != 1 && diff != -1 {
+                return nil, nil, fmt.Errorf("layer transfers must target an adjacent layer (from %d to %d)", source.Layer, target.Layer)
+        }
+
+        return source, target, nil
+}
+
+// RouteLayerTransaction routes a transaction between adjacent layers
+func (lr *LayerRouter) RouteLayerTransaction(tx *core.Transaction) error {
+        if !tx.IsValid() {
+                return errors.New("invalid transaction")
+        }
+
+        source, target, err := lr.validateRoute(tx)
+        if err != nil {
+                return err
+        }
+
+        relayID, err := lr.manager.selectRelay(source)
+        if err != nil {
+                return err
+        }
+
+        // Debit the transfer in its source shard, then hand it to the
+        // cross-channel
+        if err := source.Blockchain.AddTransaction(tx); err != nil {
+                return err
+        }
+        source.AddCrossShardTransaction(tx)
+        err = lr.manager.crossChannel.PropagateTransaction(tx, source.ID, target.ID)
+        if err != nil {
+                return err
+        }
+        lr.manager.crossChannel.ConfirmWhenFinal(tx.Hash, source.ID, tx.Hash)
+
+        lr.mu.Lock()
+        lr.routedCount++
+        if target.Layer > source.Layer {
+                lr.pendingUp[source.ID] = append(lr.pendingUp[source.ID], tx)
+        } else {
+                lr.pendingDown = append(lr.pendingDown, tx)
+        }
+        lr.mu.Unlock()
+
+        lr.logger.Info("Layer transfer queued until final in its source shard",
+                "txHash", tx.Hash,
+                "sourceShard", source.ID,
+                "targetShard", target.ID,
+                "upward", target.Layer > source.Layer,
+                "relay", relayID)
+        return nil
+}
+
+// finalDebits splits transfers into those final in their source shard and
+// those still waiting. Transfers their source shard dropped are forgotten.
+func (lr *LayerRouter) finalDebits(txs []*core.Transaction) (final, waiting []*core.Transaction) {
+        for _, tx := range txs {
+                source, err := lr.manager.GetShard(tx.SourceShard)
+                if err != nil {
+                        continue
+                }
+                status, exists := source.Blockchain.GetTransactionStatus(tx.Hash)
+                switch {
+                case !exists || status.Status == core.TxFailed:
+                        lr.logger.Warn("Dropped layer transfer that was not debited",
+                                "txHash", tx.Hash,
+                                "sourceShard", tx.SourceShard)
+                case status.Finalized:
+                        final = append(final, tx)
+                default:
+                        waiting = append(waiting, tx)
+                }
+        }
+        return final, waiting
+}
+
+// deliverDown credits the downward transfers whose debits are final
+func (lr *LayerRouter) deliverDown() {
+        lr.mu.Lock()
+        pending := lr.pendingDown
+        lr.pendingDown = nil
+        lr.mu.Unlock()
+
+        final, waiting := lr.finalDebits(pending)
+        for _, tx := range final {
+                if err := lr.deliver(tx); err != nil {
+                        lr.logger.Error("Downward layer transfer failed",
+                                "txHash", tx.Hash,
+                                "targetShard", tx.TargetShard,
+                                "error", err)
+                        waiting = append(waiting, tx)
+                }
+        }
+
+        // Keep the rest for the next round
+        lr.mu.Lock()
+        lr.pendingDown = append(waiting, lr.pendingDown...)
+        lr.mu.Unlock()
+}
+
+// deliver credits a downward transfer final in its source shard
+func (lr *LayerRouter) deliver(tx *core.Transaction) error {
+        source, target, err := lr.validateRoute(tx)
+        if err != nil {
+                return err
+        }
+        relayID, err := lr.manager.selectRelay(source)
+        if err != nil {
+                return err
+        }
+
+        credit, err := newLayerCredit(tx.From, tx.To, tx.Amount, target, relayID, core.CreditSource{
+                SourceShard: source.ID,
+                TxHashes:    []string{tx.Hash},
+        })
+        if err != nil {
+                return err
+        }
+        tracing.Default().Link(credit.Hash, tx.Hash)
+        if err := target.Blockchain.AddTransaction(credit); err != nil {
+                return err
+        }
+        lr.manager.crossChannel.ConfirmWhenFinal(tx.Hash, target.ID, credit.Hash)
+        tracing.Default().Event(tx.Hash, "layer.deliver",
+                "credit.hash", credit.Hash,
+                "target_shard", target.ID,
+                "relay", relayID)
+
+        lr.logger.Info("Downward layer transfer delivered",
+                "txHash", tx.Hash,
+                "creditHash", credit.Hash,
+                "sourceShard", source.ID,
+                "targetShard", target.ID,
+                "relay", relayID)
+        return nil
+}
+
+// Settle runs one settlement round: downward transfers final in their
+// source shard are delivered, every lower-layer shard's latest final header
+// is anchored in its parent, and final upward transfers are credited in
+// aggregate
+func (lr *LayerRouter) Settle() {
+        lr.deliverDown()
+
+        lr.mu.Lock()
+        pending := lr.pendingUp
+        lr.pendingUp = make(map[int][]*core.Transaction)
+        lr.mu.Unlock()
+
+        for _, shard := range lr.lowerLayerShards() {
+                final, waiting := lr.finalDebits(pending[shard.ID])
+                anchor, err := lr.anchorShard(shard)
+                if err != nil {
+                        lr.logger.Error("Anchoring lower-layer header failed",
+                                "shardID", shard.ID,
+                                "error", err)
+                        waiting = append(final, waiting...)
+                } else if len(final) > 0 {
+                        if err := lr.settleShard(shard, anchor, final); err != nil {
+                                lr.logger.Error("Layer settlement failed",
+                                        "sourceShard", shard.ID,
+                                        "txs", len(final),
+                                        "error", err)
+                                waiting = append(final, waiting...)
+                        }
+                }
+
+                // Keep the rest for the next round
+                if len(waiting) > 0 {
+                        lr.mu.Lock()
+                        lr.pendingUp[shard.ID] = append(waiting, lr.pendingUp[shard.ID]...)
+                        lr.mu.Unlock()
+                }
+        }
+}
+
+// lowerLayerShards returns every shard below the top layer, ordered by ID
+func (lr *LayerRouter) lowerLayerShards() []*Shard {
+        lr.manager.mu.RLock()
+        defer lr.manager.mu.RUnlock()
+
+        shards := make([]*Shard, 0, len(lr.manager.Shards))
+        for _, shard := range lr.manager.Shards {
+                if shard.Layer+1 < lr.manager.layerCount {
+                        shards = append(shards, shard)
+                }
+        }
+        sort.Slice(shards, func(i, j int) bool { return shards[i].ID < shards[j].ID })
+        return shards
+}
+
+// anchorShard queues the latest final header of a lower-layer shard for
+// inclusion in its parent's next block
+func (lr *LayerRouter) anchorShard(shard *Shard) (core.CrossRef, error) {
+        final := shard.Blockchain.GetBlockByHeight(shard.Blockchain.Finality.FinalizedHeight())
+        if final == nil {
+                final = shard.Blockchain.GetBlockByHeight(0)
+        }
+        if final == nil {
+                return core.CrossRef{}, errors.New("shard has no genesis block")
+        }
+        hash, err := final.Hash()
+        if err != nil {
+                return core.CrossRef{}, err
+        }
+        anchor := core.CrossRef{
+                ShardID:   shard.ID,
+                BlockHash: hash,
+                Height:    final.Header.Height,
+        }
+
+        lr.mu.Lock()
+        last, seen := lr.lastAnchored[shard.ID]
+        if seen && last >= anchor.Height {
+                lr.mu.Unlock()
+                return anchor, nil
+        }
+        lr.lastAnchored[shard.ID] = anchor.Height
+        lr.mu.Unlock()
+
+        parentID, err := lr.ParentShard(shard.ID)
+        if err != nil {
+                return anchor, err
+        }
+        parent, err := lr.manager.GetShard(parentID)
+        if err != nil {
+                return anchor, err
+        }
+        parent.Blockchain.AddPendingCrossRef(anchor)
+
+        lr.logger.Debug("Anchored lower-layer header",
+                "shardID", shard.ID,
+                "height", anchor.Height,
+                "parentShard", parentID)
+
+        return anchor, nil
+}
+
+// settleShard credits the net upward transfers of one shard in its parent
+func (lr *LayerRouter) settleShard(shard *Shard, anchor core.CrossRef, txs []*core.Transaction) error {
+        parentID, err := lr.ParentShard(shard.ID)
+        if err != nil {
+                return err
+        }
+        parent, err := lr.manager.GetShard(parentID)
+        if err != nil {
+                return err
+        }
+        relayID, err := lr.manager.selectRelay(shard)
+        if err != nil {
+                return err
+        }
+
+        // Net the round's transfers per recipient
+        totals := make(map[string]core.Amount)
+        hashes := make(map[string][]string)
+        for _, tx := range txs {
+                total, err := totals[tx.To].Add(tx.Amount)
+                if err != nil {
+                        return err
+                }
+                totals[tx.To] = total
+                hashes[tx.To] = append(hashes[tx.To], tx.Hash)
+        }
+
+        recipients := make([]string, 0, len(totals))
+        for to := range totals {
+                recipients = append(recipients, to)
+        }
+        sort.Strings(recipients)
+
+        for _, to := range recipients {
+                record := LayerSettlement{
+                        CreditSource: core.CreditSource{
+                                SourceShard: shard.ID,
+                                TxHashes:    hashes[to],
+                        },
+                        SourceLayer:  shard.Layer,
+                        AnchorHash:   anchor.BlockHash,
+                        AnchorHeight: anchor.Height,
+                }
+                credit, err := newLayerCredit(SettlementAddress(shard.ID), to, totals[to], parent, relayID, record)
+                if err != nil {
+                        return err
+                }
+                tracing.Default().Link(credit.Hash, hashes[to]...)
+                if err := parent.Blockchain.AddTransaction(credit); err != nil {
+                        return err
+                }
+                for _, hash := range hashes[to] {
+                        lr.manager.crossChannel.ConfirmWhenFinal(hash, parent.ID, credit.Hash)
+                        tracing.Default().Event(hash, "layer.settle",
+                                "credit.hash", credit.Hash,
+                                "target_shard", parent.ID,
+                                "anchor.height", anchor.Height)
+                }
+        }
+
+        lr.mu.Lock()
+        lr.settledCount += len(txs)
+        lr.mu.Unlock()
+
+        lr.logger.Info("Layer settlement committed",
+                "sourceShard", shard.ID,
+                "parentShard", parent.ID,
+                "transfers", len(txs),
+                "recipients", len(recipients),
+                "anchorHeight", anchor.Height)
+
+        return nil
+}
+
+// newLayerCredit builds the transaction that credits a layer transfer in
+// the receiving shard, signed by the relay that carried it. The record
+// names the transfers paid out.
+func newLayerCredit(from, to string, amount core.Amount, target *Shard, relayID string, record interface{}) (*core.Transaction, error) {
+        credit, err := core.NewTransaction(from, to, amount, 0, target.ID, target.ID, target.Layer, core.LayerTransaction)
+        if err != nil {
+                return nil, err
+        }
+
+        if credit.Data, err = json.Marshal(record); err != nil {
+                return nil, err
+        }
+        credit.Hash, err = credit.CalculateHash()
+        if err != nil {
+                return nil, err
+        }
+        if err := credit.Sign(relayID); err != nil {
+                return nil, err
+        }
+        return credit, nil
+}
+
+// verifyCredit checks that a layer credit pays out transfers to its
+// recipient from an adjacent layer that are final in their source shard: a
+// single downward transfer from its sender, or upward transfers settled
+// from the source shard's settlement account
+func (lr *LayerRouter) verifyCredit(credit *core.Transaction) error {
+        source, err := credit.CreditSource()
+        if err != nil {
+                return err
+        }
+        sourceShard, err := lr.manager.GetShard(source.SourceShard)
+        if err != nil {
+                return err
+        }
+        target, err := lr.manager.GetShard(credit.TargetShard)
+        if err != nil {
+                return err
+        }
+
+        upward := target.Layer > sourceShard.Layer
+        if upward && credit.From != SettlementAddress(sourceShard.ID) {
+                return fmt.Errorf("settlement credit must be paid from %s", SettlementAddress(sourceShard.ID))
+        }
+        if !upward && len(source.TxHashes) != 1 {
+                return errors.New("downward credit must pay out a single transfer")
+        }
+
+        var total core.Amount
+        for _, hash := range source.TxHashes {
+                if !sourceShard.IsTransactionFinal(hash) {
+                        return fmt.Errorf("layer transfer %s is not final in shard %d", hash, sourceShard.ID)
+                }
+                transfer := sourceShard.Blockchain.GetTransaction(hash)
+                if !transfer.IsCrossLayer() || transfer.SourceShard != sourceShard.ID ||
+                        transfer.TargetShard != target.ID || transfer.To != credit.To {
+                        return fmt.Errorf("layer transfer %s is not to %s in shard %d", hash, credit.To, target.ID)
+                }
+                if !upward && transfer.From != credit.From {
+                        return fmt.Errorf("layer transfer %s is not from %s", hash, credit.From)
+                }
+                if total, err = total.Add(transfer.Amount); err != nil {
+                        return err
+                }
+        }
+        if total != credit.Amount {
+                return fmt.Errorf("credit of %s pays out transfers of %s", credit.Amount, total)
+        }
+        return nil
+}
+
+// Start starts periodic settlement rounds
+func (lr *LayerRouter) Start() error {
+        lr.mu.Lock()
+        defer lr.mu.Unlock()
+
+        if lr.running {
+                return errors.New("layer router already running")
+        }
+        lr.running = true
+        lr.stopChan = make(chan struct{})
+
+        interval := time.Duration(lr.config.BlockTime) * time.Second
+        if interval <= 0 {
+                interval = 5 * time.Second
+        }
+
+        go func(stop chan struct{}) {
+                ticker := time.NewTicker(interval)
+                defer ticker.Stop()
+
+                for {
+                        select {
+                        case <-stop:
+                                return
+                        case <-ticker.C:
+                                lr.Settle()
+                        }
+                }
+        }(lr.stopChan)
+
+        lr.logger.Info("Layer router started", "layers", lr.manager.layerCount, "interval", interval)
+        return nil
+}
+
+// Stop stops periodic settlement rounds
+func (lr *LayerRouter) Stop() error {
+        lr.mu.Lock()
+        defer lr.mu.Unlock()
+
+        if !lr.running {
+                return errors.New("layer router not running")
+        }
+        close(lr.stopChan)
+        lr.running = false
+
+        lr.logger.Info("Layer router stopped")
+        return nil
+}
+
+// GetStatus returns the status of the layer router
+func (lr *LayerRouter) GetStatus() map[string]interface{} {
+        lr.mu.Lock()
+        defer lr.mu.Unlock()
+
+        pending := 0
+        for _, txs := range lr.pendingUp {
+                pending += len(txs)
+        }
+
+        return map[string]interface{}{
+                "running":            lr.running,
+                "routed_transfers":   lr.routedCount,
+                "pending_delivery":   len(lr.pendingDown),
+                "pending_settlement": pending,
+                "settled_transfers":  lr.settledCount,
+                "anchored_shards":    len(lr.lastAnchored),
+        }
+}
@@ -0,0 +1,294 @@
+package sharding
+
+import (
+	"strings"
+	"testing"
+
+	"lscc/config"
+	"lscc/core"
+	"lscc/utils"
+)
+
+// newTestManager returns the manager of relay1 over one shard per layer,
+// each block final as soon as it is added. alice is funded in shard 0 and
+// bob in shard 1.
+func newTestManager(t *testing.T, layers int) *Manager {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.NodeID = "relay1"
+	cfg.ShardID = 0
+	cfg.IsRelay = true
+	cfg.ShardCount = 1
+	cfg.LayerCount = layers
+	cfg.MinConfirmations = 1
+	cfg.Allocations = []config.Allocation{
+		{Address: "alice", Amount: utils.Coins(100), ShardID: 0},
+		{Address: "bob", Amount: utils.Coins(100), ShardID: 1},
+	}
+	return NewManager(cfg)
+}
+
+// layerTransfer returns a signed transfer from a shard to another
+func layerTransfer(t *testing.T, from, to string, coins int64, source, target, layer int) *core.Transaction {
+	t.Helper()
+	tx, err := core.NewTransaction(from, to, utils.Coins(coins), 0, source, target, layer, core.LayerTransaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Sign(from); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// mine adds a block of a shard's pending transactions to its chain
+func mine(t *testing.T, shard *Shard) {
+	t.Helper()
+	prev := shard.Blockchain.GetLatestBlock()
+	prevHash, err := prev.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := core.NewBlock(prevHash, prev.Header.Height+1, shard.ID, shard.Layer, "relay1")
+	for _, tx := range shard.Blockchain.GetPendingTransactions() {
+		block.AddTransaction(*tx)
+	}
+	block.Header.MerkleRoot = block.CalculateMerkleRoot()
+	if err := block.Sign("relay1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := shard.Blockchain.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testShard returns a shard of a test manager
+func testShard(t *testing.T, m *Manager, shardID int) *Shard {
+	t.Helper()
+	shard, err := m.GetShard(shardID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return shard
+}
+
+// pendingCredits returns the layer credits pending in a shard, by recipient
+func pendingCredits(shard *Shard) map[string]*core.Transaction {
+	credits := make(map[string]*core.Transaction)
+	for _, tx := range shard.Blockchain.GetPendingTransactions() {
+		if tx.IsCrossLayer() && tx.IsIncomingCredit() {
+			credits[tx.To] = tx
+		}
+	}
+	return credits
+}
+
+func TestRouteLayerTransactionChecksLayers(t *testing.T) {
+	m := newTestManager(t, 3)
+	lr := m.GetLayerRouter()
+
+	tests := []struct {
+		name string
+		tx   *core.Transaction
+		want string
+	}{
+		{"skipping a layer", layerTransfer(t, "alice", "carol", 1, 0, 2, 0), "adjacent layer"},
+		{"within a layer", layerTransfer(t, "alice", "carol", 1, 0, 0, 0), "adjacent layer"},
+		{"from another layer", layerTransfer(t, "alice", "carol", 1, 0, 1, 1), "does not match"},
+		{"to an unknown shard", layerTransfer(t, "alice", "carol", 1, 0, 7, 0), ErrUnknownShard.Error()},
+	}
+	for _, tt := range tests {
+		err := lr.RouteLayerTransaction(tt.tx)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	transfer, err := core.NewTransaction("alice", "carol", utils.Coins(1), 0, 0, 1, 0, core.CrossShardTransaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer.Sign("alice")
+	if err := lr.RouteLayerTransaction(transfer); err == nil {
+		t.Error("cross-shard transfer routed between layers")
+	}
+	if status := lr.GetStatus(); status["routed_transfers"] != 0 {
+		t.Errorf("routed %v refused transfers", status["routed_transfers"])
+	}
+}
+
+func TestLayerSettlementCreditsFinalTransfers(t *testing.T) {
+	m := newTestManager(t, 2)
+	lr := m.GetLayerRouter()
+	lower, parent := testShard(t, m, 0), testShard(t, m, 1)
+
+	for _, tx := range []*core.Transaction{
+		layerTransfer(t, "alice", "carol", 10, 0, 1, 0),
+		layerTransfer(t, "alice", "carol", 5, 0, 1, 0),
+		layerTransfer(t, "alice", "dave", 3, 0, 1, 0),
+	} {
+		if err := lr.RouteLayerTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing is credited while the debits are not final
+	lr.Settle()
+	if credits := pendingCredits(parent); len(credits) != 0 {
+		t.Fatalf("%d credits settled before the debits are final", len(credits))
+	}
+
+	mine(t, lower)
+	lr.Settle()
+
+	// One credit per recipient, netting its transfers, paid from the lower
+	// shard's settlement account
+	credits := pendingCredits(parent)
+	want := map[string]utils.Amount{"carol": utils.Coins(15), "dave": utils.Coins(3)}
+	if len(credits) != len(want) {
+		t.Fatalf("%d settlement credits, want %d", len(credits), len(want))
+	}
+	for to, amount := range want {
+		credit := credits[to]
+		if credit == nil {
+			t.Fatalf("no settlement credit to %s", to)
+		}
+		if credit.Amount != amount || credit.From != SettlementAddress(0) {
+			t.Errorf("credit to %s = %s from %s, want %s from %s", to, credit.Amount, credit.From, amount, SettlementAddress(0))
+		}
+	}
+
+	// The lower shard's final header is anchored in the parent
+	anchored := false
+	for _, ref := range parent.Blockchain.GetPendingCrossRefs() {
+		if ref.ShardID == lower.ID && ref.Height == 1 {
+			anchored = true
+		}
+	}
+	if !anchored {
+		t.Error("final header of the lower shard not anchored in its parent")
+	}
+
+	mine(t, parent)
+	for to, amount := range want {
+		if balance := parent.Blockchain.State.GetBalance(to); balance != amount {
+			t.Errorf("balance of %s = %s, want %s", to, balance, amount)
+		}
+	}
+
+	// Settled transfers are not paid out again
+	lr.Settle()
+	if credits := pendingCredits(parent); len(credits) != 0 {
+		t.Errorf("%d transfers settled twice", len(credits))
+	}
+	if status := lr.GetStatus(); status["settled_transfers"] != 3 {
+		t.Errorf("settled transfers = %v, want 3", status["settled_transfers"])
+	}
+}
+
+func TestLayerRouterDeliversDownward(t *testing.T) {
+	m := newTestManager(t, 2)
+	lr := m.GetLayerRouter()
+	lower, upper := testShard(t, m, 0), testShard(t, m, 1)
+
+	if err := lr.RouteLayerTransaction(layerTransfer(t, "bob", "erin", 7, 1, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	lr.Settle()
+	if credits := pendingCredits(lower); len(credits) != 0 {
+		t.Fatal("downward transfer delivered before its debit is final")
+	}
+
+	mine(t, upper)
+	lr.Settle()
+	credit := pendingCredits(lower)["erin"]
+	if credit == nil {
+		t.Fatal("final downward transfer not delivered")
+	}
+	if credit.From != "bob" || credit.Amount != utils.Coins(7) {
+		t.Errorf("credit = %s from %s, want 7 from bob", credit.Amount, credit.From)
+	}
+	mine(t, lower)
+	if balance := lower.Blockchain.State.GetBalance("erin"); balance != utils.Coins(7) {
+		t.Errorf("balance of erin = %s, want 7", balance)
+	}
+}
+
+func TestVerifyCreditChecksTransfers(t *testing.T) {
+	m := newTestManager(t, 2)
+	lr := m.GetLayerRouter()
+	lower, upper := testShard(t, m, 0), testShard(t, m, 1)
+
+	up := layerTransfer(t, "alice", "carol", 10, 0, 1, 0)
+	down := layerTransfer(t, "bob", "erin", 7, 1, 0, 1)
+	for _, tx := range []*core.Transaction{up, down} {
+		if err := lr.RouteLayerTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// credit returns a layer credit paying out transfers
+	credit := func(from, to string, coins int64, target *Shard, source int, hashes ...string) *core.Transaction {
+		tx, err := newLayerCredit(from, to, utils.Coins(coins), target, "relay1", core.CreditSource{
+			SourceShard: source,
+			TxHashes:    hashes,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	settled := credit(SettlementAddress(0), "carol", 10, upper, 0, up.Hash)
+	delivered := credit("bob", "erin", 7, lower, 1, down.Hash)
+
+	// Credits of transfers not final in their source shard are refused
+	for _, tx := range []*core.Transaction{settled, delivered} {
+		if err := lr.verifyCredit(tx); err == nil || !strings.Contains(err.Error(), "not final") {
+			t.Errorf("credit to %s before its transfer is final: err = %v", tx.To, err)
+		}
+	}
+
+	mine(t, lower)
+	mine(t, upper)
+	for _, tx := range []*core.Transaction{settled, delivered} {
+		if err := lr.verifyCredit(tx); err != nil {
+			t.Errorf("credit to %s of a final transfer refused: %v", tx.To, err)
+		}
+	}
+
+	forged := map[string]*core.Transaction{
+		"more than the transfer":        credit(SettlementAddress(0), "carol", 11, upper, 0, up.Hash),
+		"to another recipient":          credit(SettlementAddress(0), "mallory", 10, upper, 0, up.Hash),
+		"upward not from settlement":    credit("alice", "carol", 10, upper, 0, up.Hash),
+		"downward from someone else":    credit("mallory", "erin", 7, lower, 1, down.Hash),
+		"downward of several transfers": credit("bob", "erin", 14, lower, 1, down.Hash, down.Hash),
+		"from the wrong shard":          credit(SettlementAddress(1), "erin", 7, upper, 1, down.Hash),
+		"of an unknown transfer":        credit(SettlementAddress(0), "carol", 10, upper, 0, "unknown"),
+	}
+	for name, tx := range forged {
+		if err := lr.verifyCredit(tx); err == nil {
+			t.Errorf("credit %s accepted", name)
+		}
+	}
+
+	// The receiving chain pays each transfer out once, even when one credit
+	// names it twice
+	twice := credit(SettlementAddress(0), "carol", 20, upper, 0, up.Hash, up.Hash)
+	if err := upper.Blockchain.AddTransaction(twice); err == nil || !strings.Contains(err.Error(), "paid out twice") {
+		t.Error("credit paying a transfer twice accepted")
+	}
+	if err := upper.Blockchain.AddTransaction(settled); err != nil {
+		t.Fatalf("settlement credit refused: %v", err)
+	}
+	mine(t, upper)
+	again := credit(SettlementAddress(0), "carol", 10, upper, 0, up.Hash)
+	again.Timestamp++
+	var err error
+	if again.Hash, err = again.CalculateHash(); err != nil {
+		t.Fatal(err)
+	}
+	again.Sign("relay1")
+	if err := upper.Blockchain.AddTransaction(again); err == nil || !strings.Contains(err.Error(), "already credited") {
+		t.Error("transfer credited again by another credit")
+	}
+}
@@ -2,6 +2,8 @@ package sharding
 
 import (
         "errors"
+        "fmt"
+        "sort"
         "sync"
 
         "lscc/config"
@@ -9,6 +11,9 @@ import (
         "lscc/utils"
 )
 
+// ErrUnknownShard is returned for a shard ID that does not exist
+var ErrUnknownShard = errors.New("shard does not exist")
+
 // ShardingStrategy defines different sharding strategies
 type ShardingStrategy int
 
@@ -32,11 +37,16 @@ type Manager struct {
         mu              sync.RWMutex
         logger          *utils.Logger
         crossChannel    *CrossChannel
+        relayConsensus  *CrossChannelConsensus
+        layerRouter     *LayerRouter
+        swaps           *SwapCoordinator
+        messages        *MessageRouter
+        events          *core.EventBus
 }
 
 // NewManager creates a new sharding manager
 func NewManager(cfg *config.Config) *Manager {
-        logger := utils.GetLogger()
+        logger := utils.GetLogger().Named("sharding")
         manager := &Manager{
                 Shards:      make(map[int]*Shard),
                 NodeToShard: make(map[string]int),
@@ -45,10 +55,16 @@ func NewManager(cfg *config.Config) *Manager {
                 strategy:    ShardingStrategy(cfg.ShardingStrategy),
                 layerCount:  cfg.LayerCount,
                 logger:      logger,
+                events:      core.NewEventBus(),
         }
         
         // Initialize cross-channel communication
         manager.crossChannel = NewCrossChannel(manager, cfg)
+        manager.relayConsensus = NewCrossChannelConsensus(cfg)
+        manager.relayConsensus.SetEventBus(manager.events)
+        manager.layerRouter = NewLayerRouter(manager, cfg)
+        manager.swaps = NewSwapCoordinator(manager, cfg)
+        manager.messages = NewMessageRouter(manager, cfg)
         
         // Initialize shards based on config
         manager.InitializeShards()
@@ -59,16 +75,20 @@ func NewManager(cfg *config.Config) *Manager {
 // InitializeShards creates the initial shard structure
 func (m *Manager) InitializeShards() {
         m.mu.Lock()
-        defer m.mu.Unlock()
         
         // Create shards for each layer
         for layer := 0; layer < m.layerCount; layer++ {
                 for shard := 0; shard < m.config.ShardCount; shard++ {
                         shardID := layer*m.config.ShardCount + shard
                         m.Shards[shardID] = NewShard(shardID, layer, m.config)
+                        m.Shards[shardID].Blockchain.SetEventBus(m.events)
+                        m.Shards[shardID].Blockchain.SetCreditVerifier(func(tx *core.Transaction) error {
+                                return m.verifyCredit(shardID, tx)
+                        })
                         m.logger.Info("Created shard", "shardID", shardID, "layer", layer)
                 }
         }
+        m.mu.Unlock()
         
         // Assign this node to a shard if shardID is specified
         if m.config.ShardID >= 0 && m.config.ShardID < len(m.Shards) {
@@ -86,7 +106,12 @@ func (m *Manager) AssignNodeToShard(nodeID string, shardID int, isRelay bool) er
         
         shard, exists := m.Shards[shardID]
         if !exists {
-                return errors.New("shard does not exist")
+                return ErrUnknownShard
+        }
+        
+        // Slashed relays may only rejoin as regular nodes
+        if isRelay && m.relayConsensus.IsRelaySlashed(nodeID) {
+                isRelay = false
         }
         
         // Remove node from current shard if it exists
@@ -103,6 +128,7 @@ func (m *Manager) AssignNodeToShard(nodeID string, shardID int, isRelay bool) er
         // Update relay nodes map
         if isRelay {
                 m.RelayNodes[nodeID] = true
+                m.relayConsensus.RegisterRelayNode(nodeID)
         } else {
                 delete(m.RelayNodes, nodeID)
         }
@@ -154,7 +180,7 @@ func (m *Manager) GetShard(shardID int) (*Shard, error) {
         
         shard, exists := m.Shards[shardID]
         if !exists {
-                return nil, errors.New("shard does not exist")
+                return nil, ErrUnknownShard
         }
         
         return shard, nil
@@ -180,6 +206,27 @@ func (m *Manager) GetShardForTransaction(tx *core.Transaction) (int, error) {
 
 // ProcessCrossShardTransaction processes a transaction that crosses shard boundaries
 func (m *Manager) ProcessCrossShardTransaction(tx *core.Transaction) error {
+        if err := m.processCrossShardTransaction(tx); err != nil {
+                return err
+        }
+        m.events.Publish(core.Event{Type: core.EventTxAccepted, ShardID: tx.SourceShard, Transaction: tx})
+        return nil
+}
+
+// processCrossShardTransaction hands a transaction to the layer router, or
+// to the cross-channel and the relay consensus
+func (m *Manager) processCrossShardTransaction(tx *core.Transaction) error {
+        // Transactions already in flight were accepted before, possibly
+        // relayed back by a peer
+        if _, exists := m.FindCrossShardTransaction(tx.Hash); exists {
+                return errors.New("transaction already exists")
+        }
+        
+        // Transfers between layers are routed and settled by the layer router
+        if tx.IsCrossLayer() {
+                return m.layerRouter.RouteLayerTransaction(tx)
+        }
+        
         // Validate transaction
         if !tx.IsValid() {
                 return errors.New("invalid transaction")
@@ -206,6 +253,12 @@ func (m *Manager) ProcessCrossShardTransaction(tx *core.Transaction) error {
                 return err
         }
         
+        // Batch into a relay block for the relay nodes to vote on
+        err = m.relayConsensus.SubmitCrossShardTransaction(tx)
+        if err != nil {
+                return err
+        }
+        
         m.logger.Info("Processed cross-shard transaction", 
                 "txHash", tx.Hash, 
                 "sourceShard", tx.SourceShard, 
@@ -214,6 +267,175 @@ func (m *Manager) ProcessCrossShardTransaction(tx *core.Transaction) error {
         return nil
 }
 
+// verifyCredit checks that a credit in a shard pays out debits made for it:
+// layer transfers and messages final in their source shard, or cross-shard
+// transfers carried by a finalized relay block
+func (m *Manager) verifyCredit(shardID int, tx *core.Transaction) error {
+        if tx.TargetShard != shardID {
+                return fmt.Errorf("credit for shard %d, not %d", tx.TargetShard, shardID)
+        }
+        switch tx.Type {
+        case core.ContractMessageTransaction:
+                return m.messages.verifyDelivery(tx)
+        case core.LayerTransaction:
+                return m.layerRouter.verifyCredit(tx)
+        case core.CrossShardTransaction:
+                return m.verifyCrossShardCredit(tx)
+        }
+        return errors.New("not a credit")
+}
+
+// verifyCrossShardCredit checks that a cross-shard credit pays out
+// transfers to its recipient carried by a finalized relay block, or final
+// in their source shard
+func (m *Manager) verifyCrossShardCredit(tx *core.Transaction) error {
+        source, err := tx.CreditSource()
+        if err != nil {
+                return err
+        }
+
+        var carried map[string]*core.Transaction
+        if source.RelayBlockID != "" {
+                relayBlock, exists := m.relayConsensus.GetRelayBlock(source.RelayBlockID)
+                if !exists || !relayBlock.IsFinalized {
+                        return fmt.Errorf("relay block %s is not finalized", source.RelayBlockID)
+                }
+                carried = make(map[string]*core.Transaction, len(relayBlock.CrossShardTxs))
+                for _, transfer := range relayBlock.CrossShardTxs {
+                        carried[transfer.Hash] = transfer
+                }
+        }
+        sourceShard, err := m.GetShard(source.SourceShard)
+        if err != nil {
+                return err
+        }
+
+        var total core.Amount
+        for _, hash := range source.TxHashes {
+                var transfer *core.Transaction
+                if carried != nil {
+                        if transfer = carried[hash]; transfer == nil {
+                                return fmt.Errorf("transfer %s is not in relay block %s", hash, source.RelayBlockID)
+                        }
+                } else {
+                        if !sourceShard.IsTransactionFinal(hash) {
+                                return fmt.Errorf("transfer %s is not final in shard %d", hash, source.SourceShard)
+                        }
+                        transfer = sourceShard.Blockchain.GetTransaction(hash)
+                }
+                if transfer.Type != core.CrossShardTransaction || transfer.SourceShard != source.SourceShard ||
+                        transfer.TargetShard != tx.TargetShard || transfer.To != tx.To {
+                        return fmt.Errorf("transfer %s is not to %s in shard %d", hash, tx.To, tx.TargetShard)
+                }
+                if total, err = total.Add(transfer.Amount); err != nil {
+                        return err
+                }
+        }
+        if total != tx.Amount {
+                return fmt.Errorf("credit of %s pays out transfers of %s", tx.Amount, total)
+        }
+        return nil
+}
+
+// FindCrossShardTransaction returns a cross-shard transaction held by any
+// shard. Such transactions are in flight: they live outside of the shards'
+// chains until delivered.
+func (m *Manager) FindCrossShardTransaction(txHash string) (*core.Transaction, bool) {
+        for _, shard := range m.shardsByID() {
+                if tx, exists := shard.GetCrossShardTransaction(txHash); exists {
+                        return tx, true
+                }
+        }
+        return nil, false
+}
+
+// RouteLayerTransaction routes a transaction between adjacent layers
+func (m *Manager) RouteLayerTransaction(tx *core.Transaction) error {
+        return m.layerRouter.RouteLayerTransaction(tx)
+}
+
+// GetLayerRouter returns the router that moves value between layers
+func (m *Manager) GetLayerRouter() *LayerRouter {
+        return m.layerRouter
+}
+
+// GetRelayConsensus returns the relay block consensus
+func (m *Manager) GetRelayConsensus() *CrossChannelConsensus {
+        return m.relayConsensus
+}
+
+// Events returns the bus the shard chains and relay consensus publish on
+func (m *Manager) Events() *core.EventBus {
+        return m.events
+}
+
+// SetClock sets the clock the shards' chains stamp new blocks with
+func (m *Manager) SetClock(clock core.Clock) {
+        for _, shard := range m.shardsByID() {
+                shard.Blockchain.SetClock(clock)
+        }
+}
+
+// SetEvidenceHandler sets the function that receives slashing evidence
+// gathered by the sharding layer
+func (m *Manager) SetEvidenceHandler(handler func(*core.Evidence)) {
+        m.relayConsensus.SetEvidenceHandler(handler)
+}
+
+// SlashRelay removes a slashed node from the relay set of the manager, its
+// shard and the relay block consensus
+func (m *Manager) SlashRelay(nodeID string) {
+        m.relayConsensus.SlashRelay(nodeID)
+        
+        m.mu.Lock()
+        defer m.mu.Unlock()
+        
+        if !m.RelayNodes[nodeID] {
+                return
+        }
+        delete(m.RelayNodes, nodeID)
+        if shardID, exists := m.NodeToShard[nodeID]; exists {
+                shard := m.Shards[shardID]
+                shard.RemoveNode(nodeID)
+                shard.AddNode(nodeID, false)
+        }
+        
+        m.logger.Warn("Relay node removed from relay set", "nodeID", nodeID)
+}
+
+// GetSwapCoordinator returns the coordinator of cross-shard atomic swaps
+func (m *Manager) GetSwapCoordinator() *SwapCoordinator {
+        return m.swaps
+}
+
+// GetMessageRouter returns the router that carries contract messages
+// between shards
+func (m *Manager) GetMessageRouter() *MessageRouter {
+        return m.messages
+}
+
+// Start starts the cross-shard and cross-layer services
+func (m *Manager) Start() error {
+        if err := m.crossChannel.Start(); err != nil {
+                return err
+        }
+        if err := m.layerRouter.Start(); err != nil {
+                return err
+        }
+        if err := m.swaps.Start(); err != nil {
+                return err
+        }
+        return m.messages.Start()
+}
+
+// Stop stops the cross-shard and cross-layer services
+func (m *Manager) Stop() error {
+        m.messages.Stop()
+        m.swaps.Stop()
+        m.layerRouter.Stop()
+        return m.crossChannel.Stop()
+}
+
 // ProcessCrossShardBlock processes a block from another shard
 func (m *Manager) ProcessCrossShardBlock(block *core.Block, sourceShard, targetShard int) error {
         targetShardObj, err := m.GetShard(targetShard)
@@ -234,6 +456,14 @@ func (m *Manager) RebalanceShards() error {
         // This would involve moving nodes between shards based on load metrics
         
         m.logger.Info("Shards rebalanced")
+        
+        m.mu.RLock()
+        assignments := make(map[string]int, len(m.NodeToShard))
+        for nodeID, shardID := range m.NodeToShard {
+                assignments[nodeID] = shardID
+        }
+        m.mu.RUnlock()
+        m.events.Publish(core.Event{Type: core.EventShardRebalanced, ShardID: -1, Rebalance: &core.Rebalance{Assignments: assignments}})
         return nil
 }
 
@@ -255,6 +485,55 @@ func (m *Manager) GetRelayNodes() []string {
         return relayNodes
 }
 
+// IsActiveRelay reports whether a node is in the relay set. Slashed relays
+// are removed from it.
+func (m *Manager) IsActiveRelay(nodeID string) bool {
+        m.mu.RLock()
+        defer m.mu.RUnlock()
+        return m.RelayNodes[nodeID]
+}
+
+// shardsByID returns every shard, ordered by ID
+func (m *Manager) shardsByID() []*Shard {
+        m.mu.RLock()
+        defer m.mu.RUnlock()
+
+        shards := make([]*Shard, 0, len(m.Shards))
+        for _, shard := range m.Shards {
+                shards = append(shards, shard)
+        }
+        sort.Slice(shards, func(i, j int) bool { return shards[i].ID < shards[j].ID })
+        return shards
+}
+
+// RelayForShard returns the relay node that carries traffic out of a shard
+func (m *Manager) RelayForShard(shardID int) (string, error) {
+        shard, err := m.GetShard(shardID)
+        if err != nil {
+                return "", err
+        }
+        return m.selectRelay(shard)
+}
+
+// selectRelay picks the relay node that carries traffic out of a shard,
+// preferring the shard's own relays over the global relay set
+func (m *Manager) selectRelay(source *Shard) (string, error) {
+        source.mu.RLock()
+        relays := append([]string(nil), source.RelayNodes...)
+        source.mu.RUnlock()
+
+        if len(relays) == 0 {
+                relays = m.GetRelayNodes()
+        }
+        if len(relays) == 0 {
+                return "", errors.New("no relay node available")
+        }
+
+        // Deterministic choice so every node routes through the same relay
+        sort.Strings(relays)
+        return relays[0], nil
+}
+
 // GetStatus returns the status of the sharding manager
 func (m *Manager) GetStatus() map[string]interface{} {
         m.mu.RLock()
@@ -273,5 +552,9 @@ func (m *Manager) GetStatus() map[string]interface{} {
                 "strategy":       int(m.strategy),
                 "shards":         shardStatuses,
                 "cross_channel":  m.crossChannel.GetStatus(),
+                "relay_consensus": m.relayConsensus.GetSystemStatus(),
+                "layer_router":   m.layerRouter.GetStatus(),
+                "swaps":          m.swaps.GetStatus(),
+                "messages":       m.messages.GetStatus(),
         }
 }
@@ -0,0 +1,221 @@
+package sharding
+
+import (
+        "bytes"
+        "encoding/json"
+        "errors"
+        "fmt"
+        "sync"
+        "time"
+
+        "lscc/config"
+        "lscc/core"
+        "lscc/utils"
+)
+
+// MessageRouter carries contract messages between shards. Each round it
+// collects the messages sent from every shard by transactions that are now
+// final there, and delivers each one to its target shard as a transaction
+// signed by the relay carrying it. A call to a shard that does not exist is
+// bounced straight back to its sender with its value.
+type MessageRouter struct {
+        manager   *Manager
+        config    *config.Config
+        delivered map[string]bool // Messages handed to their target shard
+        bounced   int
+        running   bool
+        stopChan  chan struct{}
+        mu        sync.Mutex
+        logger    *utils.Logger
+}
+
+// NewMessageRouter creates a new message router
+func NewMessageRouter(manager *Manager, cfg *config.Config) *MessageRouter {
+        return &MessageRouter{
+                manager:   manager,
+                config:    cfg,
+                delivered: make(map[string]bool),
+                logger:    utils.GetLogger().Named("sharding"),
+        }
+}
+
+// Process runs one delivery round
+func (mr *MessageRouter) Process() {
+        mr.mu.Lock()
+        defer mr.mu.Unlock()
+
+        for _, source := range mr.manager.shardsByID() {
+                for _, msg := range source.Blockchain.State.GetContractMessages() {
+                        if mr.delivered[msg.ID] || !source.IsTransactionFinal(msg.Origin) {
+                                continue
+                        }
+                        if err := mr.deliver(source, msg); err != nil {
+                                mr.logger.Warn("Failed to deliver contract message",
+                                        "messageID", msg.ID,
+                                        "sourceShard", source.ID,
+                                        "targetShard", msg.TargetShard,
+                                        "error", err)
+                        }
+                }
+        }
+}
+
+// deliver hands a message to its target shard through the cross-channel.
+// Must be called with the lock held.
+func (mr *MessageRouter) deliver(source *Shard, msg *core.ContractMessage) error {
+        target, err := mr.manager.GetShard(msg.TargetShard)
+        if err != nil {
+                if msg.Kind != core.MessageCall {
+                        return err
+                }
+                msg, target = msg.Bounce(err), source
+                mr.bounced++
+        }
+
+        if _, done := target.Blockchain.State.GetMessageDelivery(msg.ID); done {
+                mr.delivered[msg.ID] = true
+                return nil
+        }
+
+        relayID, err := mr.manager.selectRelay(source)
+        if err != nil {
+                return err
+        }
+        tx, err := core.NewContractMessageTransaction(msg, relayID)
+        if err != nil {
+                return err
+        }
+
+        if source.ID != target.ID {
+                if err := mr.manager.crossChannel.PropagateTransaction(tx, source.ID, target.ID); err != nil {
+                        return err
+                }
+        }
+        if err := target.Blockchain.AddTransaction(tx); err != nil {
+                return err
+        }
+        if source.ID != target.ID {
+                mr.manager.crossChannel.ConfirmTransaction(tx.Hash, source.ID)
+                mr.manager.crossChannel.ConfirmWhenFinal(tx.Hash, target.ID, tx.Hash)
+        }
+        mr.delivered[msg.ID] = true
+
+        mr.logger.Info("Contract message delivered",
+                "messageID", msg.ID,
+                "kind", msg.Kind,
+                "txHash", tx.Hash,
+                "sourceShard", source.ID,
+                "targetShard", target.ID,
+                "relay", relayID)
+
+        return nil
+}
+
+// verifyDelivery checks that a message delivery carries a message sent from
+// its source shard by a transaction final there, or the bounce of a call
+// final in the target shard to a shard that does not exist
+func (mr *MessageRouter) verifyDelivery(tx *core.Transaction) error {
+        var msg core.ContractMessage
+        if err := json.Unmarshal(tx.Data, &msg); err != nil {
+                return fmt.Errorf("invalid message payload: %w", err)
+        }
+
+        source, err := mr.manager.GetShard(msg.SourceShard)
+        if err == nil {
+                sent, exists := source.Blockchain.State.GetContractMessage(msg.ID)
+                if !exists || !sameMessage(sent, &msg) {
+                        return fmt.Errorf("message %s was not sent from shard %d", msg.ID, msg.SourceShard)
+                }
+                if !source.IsTransactionFinal(sent.Origin) {
+                        return fmt.Errorf("message %s is not final in shard %d", msg.ID, msg.SourceShard)
+                }
+                return nil
+        }
+
+        if msg.Kind != core.MessageCallback {
+                return err
+        }
+        target, err := mr.manager.GetShard(msg.TargetShard)
+        if err != nil {
+                return err
+        }
+        call, exists := target.Blockchain.State.GetContractMessage(msg.CallID)
+        if !exists || call.Kind != core.MessageCall || call.TargetShard != msg.SourceShard {
+                return fmt.Errorf("message %s does not bounce a call from shard %d", msg.ID, msg.TargetShard)
+        }
+        if msg.ID != core.CallbackID(call.ID) || msg.Contract != call.Sender || msg.Value != call.Value {
+                return fmt.Errorf("message %s does not refund call %s", msg.ID, call.ID)
+        }
+        if !target.IsTransactionFinal(call.Origin) {
+                return fmt.Errorf("call %s is not final in shard %d", call.ID, msg.TargetShard)
+        }
+        return nil
+}
+
+// sameMessage reports whether two messages encode the same
+func sameMessage(a, b *core.ContractMessage) bool {
+        encodedA, errA := json.Marshal(a)
+        encodedB, errB := json.Marshal(b)
+        return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
+
+// Start starts periodic delivery rounds
+func (mr *MessageRouter) Start() error {
+        mr.mu.Lock()
+        defer mr.mu.Unlock()
+
+        if mr.running {
+                return errors.New("message router already running")
+        }
+        mr.running = true
+        mr.stopChan = make(chan struct{})
+
+        interval := time.Duration(mr.config.BlockTime) * time.Second
+        if interval <= 0 {
+                interval = 5 * time.Second
+        }
+
+        go func(stop chan struct{}) {
+                ticker := time.NewTicker(interval)
+                defer ticker.Stop()
+
+                for {
+                        select {
+                        case <-stop:
+                                return
+                        case <-ticker.C:
+                                mr.Process()
+                        }
+                }
+        }(mr.stopChan)
+
+        mr.logger.Info("Message router started", "interval", interval)
+        return nil
+}
+
+// Stop stops periodic delivery rounds
+func (mr *MessageRouter) Stop() error {
+        mr.mu.Lock()
+        defer mr.mu.Unlock()
+
+        if !mr.running {
+                return errors.New("message router not running")
+        }
+        close(mr.stopChan)
+        mr.running = false
+
+        mr.logger.Info("Message router stopped")
+        return nil
+}
+
+// GetStatus returns the status of the message router
+func (mr *MessageRouter) GetStatus() map[string]interface{} {
+        mr.mu.Lock()
+        defer mr.mu.Unlock()
+
+        return map[string]interface{}{
+                "running":            mr.running,
+                "delivered_messages": len(mr.delivered),
+                "bounced_messages":   mr.bounced,
+        }
+}
@@ -0,0 +1,543 @@
+package sharding
+
+import (
+        "errors"
+        "fmt"
+        "sort"
+        "strconv"
+        "sync"
+        "time"
+
+        "lscc/config"
+        "lscc/core"
+        "lscc/metrics"
+        "lscc/tracing"
+        "lscc/utils"
+)
+
+// relayValidations counts relay block checks by the target shard of the
+// relay block and their result: a counted vote, a vote rejected because the
+// relay block is invalid, or a relay block found invalid before voting
+var relayValidations = metrics.Default().NewCounterVec(
+        "lscc_relay_block_validations_total",
+        "Relay block validations by result",
+        "shard", "layer", "result",
+)
+
+// relayBlocksFinalized counts finalized relay blocks by target shard
+var relayBlocksFinalized = metrics.Default().NewCounterVec(
+        "lscc_relay_blocks_finalized_total",
+        "Relay blocks finalized by the relay nodes",
+        "shard", "layer",
+)
+
+// CrossChannelConsensus batches cross-shard transactions into relay blocks
+// and finalizes them once enough relay nodes have voted for them
+type CrossChannelConsensus struct {
+        channels             map[string]*Channel
+        relayNodes           map[string]bool
+        slashedRelays        map[string]bool
+        crossShardQueues     map[int][]*core.Transaction
+        pendingRelayBlocks   map[string]*core.RelayBlock
+        validatedRelayBlocks map[string]*core.RelayBlock
+        validationThreshold  int
+        onEvidence           func(*core.Evidence)
+        events               *core.EventBus
+        config               *config.Config
+        mu                   sync.RWMutex
+        logger               *utils.Logger
+}
+
+// Channel tracks the cross-shard traffic between a pair of shards
+type Channel struct {
+        SourceShard     int
+        TargetShard     int
+        Transactions    []*core.Transaction
+        LastProcessed   int64
+        ValidationCount int
+        mu              sync.RWMutex
+}
+
+// relayBatchSize is the number of queued transactions for a target shard
+// that triggers a relay block
+const relayBatchSize = 5
+
+// NewCrossChannelConsensus creates a new relay block consensus
+func NewCrossChannelConsensus(cfg *config.Config) *CrossChannelConsensus {
+        return &CrossChannelConsensus{
+                channels:             make(map[string]*Channel),
+                relayNodes:           make(map[string]bool),
+                slashedRelays:        make(map[string]bool),
+                crossShardQueues:     make(map[int][]*core.Transaction),
+                pendingRelayBlocks:   make(map[string]*core.RelayBlock),
+                validatedRelayBlocks: make(map[string]*core.RelayBlock),
+                validationThreshold:  2, // Minimum validations needed
+                config:               cfg,
+                logger:               utils.GetLogger().Named("sharding"),
+        }
+}
+
+// SetEvidenceHandler sets the function that receives evidence against
+// relays voting for invalid relay blocks
+func (cc *CrossChannelConsensus) SetEvidenceHandler(handler func(*core.Evidence)) {
+        cc.mu.Lock()
+        defer cc.mu.Unlock()
+        cc.onEvidence = handler
+}
+
+// SetEventBus sets the bus finalized relay blocks are published on
+func (cc *CrossChannelConsensus) SetEventBus(bus *core.EventBus) {
+        cc.mu.Lock()
+        defer cc.mu.Unlock()
+        cc.events = bus
+}
+
+// RegisterRelayNode adds a relay node to the voting set
+func (cc *CrossChannelConsensus) RegisterRelayNode(nodeID string) error {
+        cc.mu.Lock()
+        defer cc.mu.Unlock()
+
+        if cc.slashedRelays[nodeID] {
+                return errors.New("relay node has been slashed")
+        }
+        cc.relayNodes[nodeID] = true
+        cc.logger.Info("Relay node registered", "nodeID", nodeID)
+        return nil
+}
+
+// SlashRelay removes a relay from the voting set for good and drops its
+// votes on relay blocks that are not final yet
+func (cc *CrossChannelConsensus) SlashRelay(nodeID string) {
+        cc.mu.Lock()
+        defer cc.mu.Unlock()
+
+        cc.slashedRelays[nodeID] = true
+        if !cc.relayNodes[nodeID] {
+                return
+        }
+        delete(cc.relayNodes, nodeID)
+        for _, relayBlock := range cc.pendingRelayBlocks {
+                delete(relayBlock.Votes, nodeID)
+        }
+
+        cc.logger.Warn("Relay node slashed", "nodeID", nodeID)
+}
+
+// IsRelaySlashed reports whether a relay has been slashed
+func (cc *CrossChannelConsensus) IsRelaySlashed(nodeID string) bool {
+        cc.mu.RLock()
+        defer cc.mu.RUnlock()
+        return cc.slashedRelays[nodeID]
+}
+
+// SubmitCrossShardTransaction queues a cross-shard transaction for the next
+// relay block to its target shard
+func (cc *CrossChannelConsensus) SubmitCrossShardTransaction(tx *core.Transaction) error {
+        cc.mu.Lock()
+        defer cc.mu.Unlock()
+
+        if tx.SourceShard == tx.TargetShard {
+                return fmt.Errorf("not a cross-shard transaction")
+        }
+
+        // Add to cross-shard queue for target shard
+        cc.crossShardQueues[tx.TargetShard] = append(cc.crossShardQueues[tx.TargetShard], tx)
+
+        // Create or update channel
+        channelID := fmt.Sprintf("%d-%d", tx.SourceShard, tx.TargetShard)
+        if cc.channels[channelID] == nil {
+                cc.channels[channelID] = &Channel{
+                        SourceShard:  tx.SourceShard,
+                        TargetShard:  tx.TargetShard,
+                        Transactions: []*core.Transaction{},
+                }
+        }
+
+        cc.channels[channelID].mu.Lock()
+        cc.channels[channelID].Transactions = append(cc.channels[channelID].Transactions, tx)
+        cc.channels[channelID].mu.Unlock()
+
+        cc.logger.Info("Cross-shard transaction submitted",
+                "txHash", tx.Hash,
+                "from", tx.SourceShard,
+                "to", tx.TargetShard)
+
+        // Check if we should create a relay block
+        if len(cc.crossShardQueues[tx.TargetShard]) >= relayBatchSize {
+                go cc.createRelayBlock(tx.TargetShard)
+        }
+
+        return nil
+}
+
+// createRelayBlock batches the queued transactions for a target shard
+func (cc *CrossChannelConsensus) createRelayBlock(targetShard int) {
+        cc.mu.Lock()
+        defer cc.mu.Unlock()
+
+        if len(cc.crossShardQueues[targetShard]) == 0 {
+                return
+        }
+
+        // Create relay block with pending cross-shard transactions
+        relayBlock := &core.RelayBlock{
+                ID:            fmt.Sprintf("relay_%d_%d", targetShard, time.Now().UnixNano()),
+                Timestamp:     time.Now().Unix(),
+                CrossShardTxs: make([]*core.Transaction, len(cc.crossShardQueues[targetShard])),
+                TargetShards:  []int{targetShard},
+                Votes:         make(map[string]*core.RelayVote),
+                IsFinalized:   false,
+                CreatedBy:     "relay_system",
+        }
+
+        copy(relayBlock.CrossShardTxs, cc.crossShardQueues[targetShard])
+        relayBlock.Hash = relayBlock.CalculateHash()
+
+        // Collect source shards
+        sourceShards := make(map[int]bool)
+        for _, tx := range relayBlock.CrossShardTxs {
+                sourceShards[tx.SourceShard] = true
+        }
+        for shard := range sourceShards {
+                relayBlock.SourceShards = append(relayBlock.SourceShards, shard)
+        }
+        sort.Ints(relayBlock.SourceShards)
+
+        cc.pendingRelayBlocks[relayBlock.ID] = relayBlock
+
+        // Clear the queue
+        cc.crossShardQueues[targetShard] = []*core.Transaction{}
+
+        for _, tx := range relayBlock.CrossShardTxs {
+                tracing.Default().Event(tx.Hash, "relay.create",
+                        "relay_block.id", relayBlock.ID,
+                        "relay_block.tx_count", len(relayBlock.CrossShardTxs),
+                        "target_shard", targetShard)
+        }
+
+        cc.logger.Info("Relay block created",
+                "id", relayBlock.ID,
+                "hash", relayBlock.Hash,
+                "txCount", len(relayBlock.CrossShardTxs),
+                "targetShard", targetShard)
+
+        // Start validation process
+        go cc.validateRelayBlock(relayBlock.ID)
+}
+
+// SubmitRelayBlock adds a relay block proposed by another relay to the
+// pending relay blocks, and has the local relays vote for it if it is
+// valid. Only active relays may propose, so the block must be signed by the
+// relay it names as its creator. A relay block whose contents are invalid
+// is kept, so votes for it can be reported as slashing evidence; one whose
+// hash does not commit to its contents proves nothing and is refused.
+func (cc *CrossChannelConsensus) SubmitRelayBlock(relayBlock *core.RelayBlock) error {
+        if relayBlock.ID == "" || relayBlock.CalculateHash() != relayBlock.Hash {
+                return errors.New("relay block hash mismatch")
+        }
+        if err := relayBlock.VerifySignature(); err != nil {
+                return err
+        }
+
+        cc.mu.Lock()
+        if !cc.relayNodes[relayBlock.CreatedBy] {
+                cc.mu.Unlock()
+                return errors.New("relay block is not from an active relay node")
+        }
+        if _, exists := cc.pendingRelayBlocks[relayBlock.ID]; exists {
+                cc.mu.Unlock()
+                return errors.New("relay block already exists")
+        }
+        if _, exists := cc.validatedRelayBlocks[relayBlock.ID]; exists {
+                cc.mu.Unlock()
+                return errors.New("relay block already exists")
+        }
+
+        // Votes are counted as they are submitted, never taken on trust
+        proposed := *relayBlock
+        proposed.Votes = make(map[string]*core.RelayVote)
+        proposed.IsFinalized = false
+        cc.pendingRelayBlocks[proposed.ID] = &proposed
+        cc.mu.Unlock()
+
+        cc.logger.Info("Relay block submitted",
+                "id", proposed.ID,
+                "hash", proposed.Hash,
+                "createdBy", proposed.CreatedBy,
+                "txCount", len(proposed.CrossShardTxs))
+
+        go cc.validateRelayBlock(proposed.ID)
+        return nil
+}
+
+// validateRelayBlock has each local relay check a pending relay block and
+// vote for it if it is valid
+func (cc *CrossChannelConsensus) validateRelayBlock(relayBlockID string) {
+        cc.mu.RLock()
+        relayBlock, exists := cc.pendingRelayBlocks[relayBlockID]
+        relays := make([]string, 0, len(cc.relayNodes))
+        for nodeID := range cc.relayNodes {
+                relays = append(relays, nodeID)
+        }
+        cc.mu.RUnlock()
+        if !exists {
+                return
+        }
+        sort.Strings(relays)
+
+        spans := traceRelayBlock(relayBlock, "relay.validate")
+        defer func() {
+                for _, span := range spans {
+                        span.End()
+                }
+        }()
+
+        if err := relayBlock.Validate(); err != nil {
+                cc.logger.Error("Invalid relay block", "relayBlockID", relayBlockID, "error", err)
+                cc.countValidation(relayBlock, "invalid_block")
+                for _, span := range spans {
+                        span.Fail(err)
+                }
+                return
+        }
+
+        votes := 0
+        defer func() {
+                for _, span := range spans {
+                        span.SetAttributes("relay_block.votes", votes)
+                }
+        }()
+        for _, nodeID := range relays {
+                vote, err := core.NewRelayVote(relayBlock, nodeID, nodeID)
+                if err != nil {
+                        continue
+                }
+                if err := cc.SubmitRelayVote(vote); err != nil {
+                        cc.logger.Debug("Relay vote not counted", "relayBlockID", relayBlockID, "validator", nodeID, "error", err)
+                        continue
+                }
+                votes++
+
+                cc.mu.RLock()
+                finalized := relayBlock.IsFinalized
+                cc.mu.RUnlock()
+                if finalized {
+                        break
+                }
+        }
+}
+
+// SubmitRelayVote records a relay's signed vote for a relay block. A vote
+// for an invalid relay block is reported as slashing evidence.
+func (cc *CrossChannelConsensus) SubmitRelayVote(vote *core.RelayVote) error {
+        if err := vote.Verify(); err != nil {
+                return err
+        }
+
+        cc.mu.Lock()
+        if !cc.relayNodes[vote.Relay] {
+                cc.mu.Unlock()
+                return errors.New("vote is not from an active relay node")
+        }
+        relayBlock, exists := cc.pendingRelayBlocks[vote.RelayBlockID]
+        if !exists {
+                relayBlock, exists = cc.validatedRelayBlocks[vote.RelayBlockID]
+        }
+        if !exists {
+                cc.mu.Unlock()
+                return errors.New("relay block not found")
+        }
+        if vote.RelayBlockHash != relayBlock.Hash {
+                cc.mu.Unlock()
+                return errors.New("vote is for a different relay block hash")
+        }
+
+        if err := relayBlock.Validate(); err != nil {
+                onEvidence := cc.onEvidence
+                cc.mu.Unlock()
+
+                cc.countValidation(relayBlock, "rejected_vote")
+                evidence := core.NewInvalidRelayVoteEvidence(vote, relayBlock)
+                if onEvidence != nil && evidence.Verify() == nil {
+                        cc.logger.Warn("Relay voted for an invalid relay block",
+                                "relay", vote.Relay,
+                                "relayBlockID", vote.RelayBlockID,
+                                "error", err)
+                        onEvidence(evidence)
+                }
+                return fmt.Errorf("vote for invalid relay block: %w", err)
+        }
+
+        relayBlock.Votes[vote.Relay] = vote
+        voteCount := len(relayBlock.Votes)
+        pending := !relayBlock.IsFinalized
+        cc.mu.Unlock()
+        cc.countValidation(relayBlock, "accepted_vote")
+
+        cc.logger.Info("Relay block validated",
+                "relayBlockID", vote.RelayBlockID,
+                "validator", vote.Relay,
+                "validationCount", voteCount)
+
+        // Check if we have enough validations
+        if pending && voteCount >= cc.validationThreshold {
+                cc.finalizeRelayBlock(vote.RelayBlockID)
+        }
+        return nil
+}
+
+// finalizeRelayBlock marks a relay block final and updates its channels
+func (cc *CrossChannelConsensus) finalizeRelayBlock(relayBlockID string) {
+        cc.mu.Lock()
+        defer cc.mu.Unlock()
+
+        relayBlock, exists := cc.pendingRelayBlocks[relayBlockID]
+        if !exists {
+                return
+        }
+
+        relayBlock.IsFinalized = true
+        cc.validatedRelayBlocks[relayBlockID] = relayBlock
+        delete(cc.pendingRelayBlocks, relayBlockID)
+
+        cc.logger.Info("Relay block finalized",
+                "relayBlockID", relayBlockID,
+                "validationCount", len(relayBlock.Votes),
+                "txCount", len(relayBlock.CrossShardTxs))
+        // Subscribers read the block without the lock while votes keep coming in
+        cc.events.Publish(core.Event{Type: core.EventRelayBlockFinalized, ShardID: -1, RelayBlock: relayBlock.Copy()})
+        for _, tx := range relayBlock.CrossShardTxs {
+                tracing.Default().Event(tx.Hash, "relay.finalize",
+                        "relay_block.id", relayBlockID,
+                        "relay_block.hash", relayBlock.Hash,
+                        "relay_block.votes", len(relayBlock.Votes),
+                        "target_shard", tx.TargetShard)
+        }
+        for _, targetShard := range relayBlock.TargetShards {
+                relayBlocksFinalized.With(cc.shardLabels(targetShard)...).Inc()
+        }
+
+        // Update channels
+        for _, targetShard := range relayBlock.TargetShards {
+                for _, sourceShard := range relayBlock.SourceShards {
+                        channelID := fmt.Sprintf("%d-%d", sourceShard, targetShard)
+                        if channel, exists := cc.channels[channelID]; exists {
+                                channel.mu.Lock()
+                                channel.LastProcessed = relayBlock.Timestamp
+                                channel.ValidationCount++
+                                channel.mu.Unlock()
+                        }
+                }
+        }
+}
+
+// countValidation counts a validation result against each target shard of
+// a relay block
+func (cc *CrossChannelConsensus) countValidation(relayBlock *core.RelayBlock, result string) {
+        for _, targetShard := range relayBlock.TargetShards {
+                relayValidations.With(append(cc.shardLabels(targetShard), result)...).Inc()
+        }
+}
+
+// traceRelayBlock starts a span in the trace of each transaction of a relay
+// block
+func traceRelayBlock(relayBlock *core.RelayBlock, name string) []*tracing.ActiveSpan {
+        spans := make([]*tracing.ActiveSpan, 0, len(relayBlock.CrossShardTxs))
+        for _, tx := range relayBlock.CrossShardTxs {
+                spans = append(spans, tracing.Default().StartSpan(tx.Hash, name,
+                        "relay_block.id", relayBlock.ID,
+                        "relay_block.hash", relayBlock.Hash,
+                        "target_shard", tx.TargetShard))
+        }
+        return spans
+}
+
+// shardLabels returns the shard and layer label values of a shard
+func (cc *CrossChannelConsensus) shardLabels(shardID int) []string {
+        return []string{strconv.Itoa(shardID), strconv.Itoa(cc.config.LayerOfShard(shardID))}
+}
+
+// GetRelayBlock returns a copy of a pending or finalized relay block
+func (cc *CrossChannelConsensus) GetRelayBlock(id string) (*core.RelayBlock, bool) {
+        cc.mu.RLock()
+        defer cc.mu.RUnlock()
+
+        relayBlock, exists := cc.pendingRelayBlocks[id]
+        if !exists {
+                relayBlock, exists = cc.validatedRelayBlocks[id]
+        }
+        if !exists {
+                return nil, false
+        }
+        return relayBlock.Copy(), true
+}
+
+// GetCrossShardTransactions returns the transactions of finalized relay
+// blocks targeting a shard
+func (cc *CrossChannelConsensus) GetCrossShardTransactions(shardID int) []*core.Transaction {
+        cc.mu.RLock()
+        defer cc.mu.RUnlock()
+
+        var transactions []*core.Transaction
+
+        // Look for finalized relay blocks targeting this shard
+        for _, relayBlock := range cc.validatedRelayBlocks {
+                for _, targetShard := range relayBlock.TargetShards {
+                        if targetShard == shardID {
+                                transactions = append(transactions, relayBlock.CrossShardTxs...)
+                        }
+                }
+        }
+
+        return transactions
+}
+
+// GetChannelStatus returns the status of the channel between two shards
+func (cc *CrossChannelConsensus) GetChannelStatus(sourceShardID, targetShardID int) map[string]interface{} {
+        cc.mu.RLock()
+        defer cc.mu.RUnlock()
+
+        channelID := fmt.Sprintf("%d-%d", sourceShardID, targetShardID)
+        channel, exists := cc.channels[channelID]
+
+        if !exists {
+                return map[string]interface{}{
+                        "exists":       false,
+                        "source_shard": sourceShardID,
+                        "target_shard": targetShardID,
+                }
+        }
+
+        channel.mu.RLock()
+        defer channel.mu.RUnlock()
+
+        return map[string]interface{}{
+                "exists":           true,
+                "source_shard":     channel.SourceShard,
+                "target_shard":     channel.TargetShard,
+                "pending_txs":      len(channel.Transactions),
+                "last_processed":   channel.LastProcessed,
+                "validation_count": channel.ValidationCount,
+        }
+}
+
+// GetSystemStatus returns the status of the relay block consensus
+func (cc *CrossChannelConsensus) GetSystemStatus() map[string]interface{} {
+        cc.mu.RLock()
+        defer cc.mu.RUnlock()
+
+        totalPendingTxs := 0
+        for _, queue := range cc.crossShardQueues {
+                totalPendingTxs += len(queue)
+        }
+
+        return map[string]interface{}{
+                "relay_nodes":            len(cc.relayNodes),
+                "slashed_relays":         len(cc.slashedRelays),
+                "active_channels":        len(cc.channels),
+                "pending_relay_blocks":   len(cc.pendingRelayBlocks),
+                "finalized_relay_blocks": len(cc.validatedRelayBlocks),
+                "total_pending_txs":      totalPendingTxs,
+                "validation_threshold":   cc.validationThreshold,
+        }
+}
@@ -1,6 +1,7 @@
 package sharding
 
 import (
+        "encoding/json"
         "sync"
 
         "lscc/config"
@@ -24,19 +25,18 @@ type Shard struct {
 
 // NewShard creates a new shard
 func NewShard(id int, layer int, cfg *config.Config) *Shard {
-        logger := utils.GetLogger()
+        logger := utils.GetLogger().Named("sharding")
         
-        // Create a config copy with the shard ID
-        shardConfig := *cfg
-        shardConfig.ShardID = id
+        // Create a config copy with the shard ID and the shard's consensus
+        shardConfig := cfg.ForShard(id)
         
         return &Shard{
                 ID:             id,
                 Layer:          layer,
-                Blockchain:     core.NewBlockchain(&shardConfig),
+                Blockchain:     core.NewBlockchain(shardConfig),
                 Nodes:          make([]string, 0),
                 RelayNodes:     make([]string, 0),
-                config:         &shardConfig,
+                config:         shardConfig,
                 logger:         logger,
                 crossShardTxs:  make(map[string]*core.Transaction),
                 pendingBlocks:  make(map[string]*core.Block),
@@ -148,6 +148,15 @@ func (s *Shard) GetCrossShardTransactions() []*core.Transaction {
         return txs
 }
 
+// GetCrossShardTransaction returns a cross-shard transaction held by the shard
+func (s *Shard) GetCrossShardTransaction(txHash string) (*core.Transaction, bool) {
+        s.mu.RLock()
+        defer s.mu.RUnlock()
+
+        tx, exists := s.crossShardTxs[txHash]
+        return tx, exists
+}
+
 // ProcessCrossShardBlock processes a block from another shard
 func (s *Shard) ProcessCrossShardBlock(block *core.Block, sourceShard int) error {
         // Handle cross-shard transactions that target this shard
@@ -164,6 +173,17 @@ func (s *Shard) ProcessCrossShardBlock(block *core.Block, sourceShard int) error
                                 s.Layer,
                                 tx.Type,
                         )
+                        if err == nil {
+                                // Name the transfer paid out, which must be
+                                // final in the source shard
+                                localTx.Data, err = json.Marshal(core.CreditSource{
+                                        SourceShard: sourceShard,
+                                        TxHashes:    []string{tx.Hash},
+                                })
+                        }
+                        if err == nil {
+                                localTx.Hash, err = localTx.CalculateHash()
+                        }
                         if err != nil {
                                 s.logger.Error("Failed to create local transaction from cross-shard tx", 
                                         "error", err, 
@@ -201,6 +221,20 @@ func (s *Shard) ProcessCrossShardBlock(block *core.Block, sourceShard int) error
         return nil
 }
 
+// ConsensusType returns the consensus engine the shard runs
+func (s *Shard) ConsensusType() string {
+        return s.config.ConsensusType
+}
+
+// IsTransactionFinal reports whether a transaction is in a final block of
+// the shard's chain. Finality follows the shard's engine: BFT engines
+// finalize blocks as checkpoints, the others once they are buried under the
+// shard's confirmation depth.
+func (s *Shard) IsTransactionFinal(txHash string) bool {
+        status, exists := s.Blockchain.GetTransactionStatus(txHash)
+        return exists && status.Finalized
+}
+
 // GetStatus returns the current status of the shard
 func (s *Shard) GetStatus() map[string]interface{} {
         s.mu.RLock()
@@ -214,5 +248,7 @@ func (s *Shard) GetStatus() map[string]interface{} {
                 "blockchain_height":   s.Blockchain.GetHeight(),
                 "cross_shard_tx_count": len(s.crossShardTxs),
                 "pending_blocks":      len(s.pendingBlocks),
+                "consensus_type":      s.config.ConsensusType,
+                "finality":            s.Blockchain.Finality.GetStatus(),
         }
 }
@@ -0,0 +1,591 @@
+package sharding
+
+import (
+        "encoding/json"
+        "errors"
+        "fmt"
+        "sort"
+        "sync"
+        "time"
+
+        "lscc/config"
+        "lscc/core"
+        "lscc/utils"
+)
+
+// Cross-shard atomic swaps
+//
+// A swap pairs two hash locks on the same secret, one per shard:
+//  1. The initiator locks funds for the participant in its shard with a
+//     hash of a secret only it knows.
+//  2. The participant locks funds for the initiator in the other shard with
+//     the same hash and an earlier expiry.
+//  3. The initiator claims the participant's lock, revealing the secret. The
+//     coordinator relays the secret through the cross-channel and claims the
+//     initiator's lock for the participant in the other shard.
+//
+// If the secret is never revealed, each lock is refunded to its sender once
+// it expires. Because the participant's lock expires first, the secret can
+// only appear while the initiator's lock is still claimable, so the swap
+// either completes on both shards or refunds on both.
+
+// SwapStatus is the progress of an atomic swap
+type SwapStatus string
+
+const (
+        // SwapInitiated swaps have a lock in the initiator's shard only
+        SwapInitiated SwapStatus = "initiated"
+        // SwapParticipated swaps have locks in both shards
+        SwapParticipated SwapStatus = "participated"
+        // SwapClaimed swaps have had their secret revealed and are completing
+        SwapClaimed SwapStatus = "claimed"
+        // SwapCompleted swaps have been claimed in both shards
+        SwapCompleted SwapStatus = "completed"
+        // SwapRefunded swaps have been refunded in every shard holding a lock
+        SwapRefunded SwapStatus = "refunded"
+)
+
+// SwapLeg is one side of a swap: a hash lock in a single shard
+type SwapLeg struct {
+        ShardID   int         `json:"shard_id"`
+        LockID    string      `json:"lock_id"`
+        Sender    string      `json:"sender"`
+        Recipient string      `json:"recipient"`
+        Amount    core.Amount `json:"amount"`
+        Fee       core.Amount `json:"fee"`
+        Timeout   uint64      `json:"timeout"`
+        ReleaseTx string      `json:"release_tx,omitempty"` // Claim or refund releasing the lock
+}
+
+// AtomicSwap tracks a pair of hash locks sharing one secret. Its ID is the
+// hash lock.
+type AtomicSwap struct {
+        ID          string     `json:"id"`
+        Initiator   *SwapLeg   `json:"initiator"`
+        Participant *SwapLeg   `json:"participant,omitempty"`
+        Status      SwapStatus `json:"status"`
+        Preimage    string     `json:"preimage,omitempty"`
+        CreatedAt   time.Time  `json:"created_at"`
+}
+
+// SwapCoordinator drives atomic swaps between shards
+type SwapCoordinator struct {
+        manager   *Manager
+        config    *config.Config
+        swaps     map[string]*AtomicSwap
+        completed int
+        refunded  int
+        running   bool
+        stopChan  chan struct{}
+        mu        sync.Mutex
+        logger    *utils.Logger
+}
+
+// NewSwapCoordinator creates a new swap coordinator
+func NewSwapCoordinator(manager *Manager, cfg *config.Config) *SwapCoordinator {
+        return &SwapCoordinator{
+                manager: manager,
+                config:  cfg,
+                swaps:   make(map[string]*AtomicSwap),
+                logger:  utils.GetLogger().Named("sharding"),
+        }
+}
+
+// newSwapLeg validates a signed lock transaction and describes the leg it
+// creates
+func newSwapLeg(tx *core.Transaction) (*SwapLeg, string, error) {
+        if tx.Type != core.HTLCLockTransaction {
+                return nil, "", errors.New("not a hash lock transaction")
+        }
+        if tx.SourceShard != tx.TargetShard {
+                return nil, "", errors.New("hash locks must stay within one shard")
+        }
+        if !tx.IsValid() {
+                return nil, "", errors.New("invalid transaction")
+        }
+
+        var payload core.HTLCLockPayload
+        if err := json.Unmarshal(tx.Data, &payload); err != nil {
+                return nil, "", fmt.Errorf("invalid lock payload: %w", err)
+        }
+
+        return &SwapLeg{
+                ShardID:   tx.SourceShard,
+                LockID:    tx.Hash,
+                Sender:    tx.From,
+                Recipient: tx.To,
+                Amount:    tx.Amount,
+                Fee:       tx.Fee,
+                Timeout:   payload.Timeout,
+        }, payload.HashLock, nil
+}
+
+// blocksLeft returns how many blocks a leg's shard can still produce before
+// the lock expires
+func (sc *SwapCoordinator) blocksLeft(leg *SwapLeg) (uint64, error) {
+        shard, err := sc.manager.GetShard(leg.ShardID)
+        if err != nil {
+                return 0, err
+        }
+        height := shard.Blockchain.GetHeight()
+        if leg.Timeout <= height {
+                return 0, nil
+        }
+        return leg.Timeout - height, nil
+}
+
+// Initiate submits the initiator's lock and starts tracking the swap
+func (sc *SwapCoordinator) Initiate(tx *core.Transaction) (*AtomicSwap, error) {
+        leg, hashLock, err := newSwapLeg(tx)
+        if err != nil {
+                return nil, err
+        }
+
+        sc.mu.Lock()
+        defer sc.mu.Unlock()
+
+        if _, exists := sc.swaps[hashLock]; exists {
+                return nil, errors.New("a swap with this hash lock already exists")
+        }
+
+        if err := sc.submitLock(leg, tx); err != nil {
+                return nil, err
+        }
+
+        swap := &AtomicSwap{
+                ID:        hashLock,
+                Initiator: leg,
+                Status:    SwapInitiated,
+                CreatedAt: time.Now(),
+        }
+        sc.swaps[swap.ID] = swap
+
+        sc.logger.Info("Atomic swap initiated",
+                "swapID", swap.ID,
+                "shardID", leg.ShardID,
+                "sender", leg.Sender,
+                "recipient", leg.Recipient,
+                "amount", leg.Amount,
+                "timeout", leg.Timeout)
+
+        return swap.copy(), nil
+}
+
+// Participate submits the participant's counter-lock for a swap
+func (sc *SwapCoordinator) Participate(swapID string, tx *core.Transaction) (*AtomicSwap, error) {
+        leg, hashLock, err := newSwapLeg(tx)
+        if err != nil {
+                return nil, err
+        }
+
+        sc.mu.Lock()
+        defer sc.mu.Unlock()
+
+        swap, exists := sc.swaps[swapID]
+        if !exists {
+                return nil, errors.New("swap not found")
+        }
+        if swap.Status != SwapInitiated {
+                return nil, fmt.Errorf("swap is already %s", swap.Status)
+        }
+        if hashLock != swap.ID {
+                return nil, errors.New("counter-lock must use the swap's hash lock")
+        }
+        if leg.Sender != swap.Initiator.Recipient || leg.Recipient != swap.Initiator.Sender {
+                return nil, errors.New("counter-lock must pay the initiator from the participant")
+        }
+
+        // The counter-lock must expire first so the initiator can only reveal
+        // the secret while its own lock is still claimable by the participant
+        initiatorLeft, err := sc.blocksLeft(swap.Initiator)
+        if err != nil {
+                return nil, err
+        }
+        participantLeft, err := sc.blocksLeft(leg)
+        if err != nil {
+                return nil, err
+        }
+        if participantLeft >= initiatorLeft {
+                return nil, fmt.Errorf("counter-lock must expire before the initiator's lock (%d blocks left)", initiatorLeft)
+        }
+
+        if err := sc.submitLock(leg, tx); err != nil {
+                return nil, err
+        }
+        swap.Participant = leg
+        swap.Status = SwapParticipated
+
+        sc.logger.Info("Atomic swap counter-lock placed",
+                "swapID", swap.ID,
+                "shardID", leg.ShardID,
+                "amount", leg.Amount,
+                "timeout", leg.Timeout)
+
+        return swap.copy(), nil
+}
+
+// Claim submits the initiator's claim of the counter-lock and relays the
+// revealed secret to the initiator's shard to claim the other lock for the
+// participant
+func (sc *SwapCoordinator) Claim(swapID string, tx *core.Transaction) (*AtomicSwap, error) {
+        if tx.Type != core.HTLCClaimTransaction {
+                return nil, errors.New("not a hash lock claim transaction")
+        }
+        var payload core.HTLCClaimPayload
+        if err := json.Unmarshal(tx.Data, &payload); err != nil {
+                return nil, fmt.Errorf("invalid claim payload: %w", err)
+        }
+
+        sc.mu.Lock()
+        defer sc.mu.Unlock()
+
+        swap, exists := sc.swaps[swapID]
+        if !exists {
+                return nil, errors.New("swap not found")
+        }
+        if swap.Status != SwapParticipated {
+                return nil, fmt.Errorf("swap cannot be claimed while %s", swap.Status)
+        }
+        if payload.LockID != swap.Participant.LockID || tx.SourceShard != swap.Participant.ShardID {
+                return nil, errors.New("claim must release the counter-lock")
+        }
+        hash, err := core.HashSecret(payload.Preimage)
+        if err != nil {
+                return nil, err
+        }
+        if hash != swap.ID {
+                return nil, errors.New("preimage does not match hash lock")
+        }
+
+        shard, err := sc.manager.GetShard(swap.Participant.ShardID)
+        if err != nil {
+                return nil, err
+        }
+        if err := shard.Blockchain.AddTransaction(tx); err != nil {
+                return nil, err
+        }
+        swap.Participant.ReleaseTx = tx.Hash
+        swap.Preimage = payload.Preimage
+        swap.Status = SwapClaimed
+
+        sc.logger.Info("Atomic swap secret revealed",
+                "swapID", swap.ID,
+                "shardID", swap.Participant.ShardID,
+                "claimTx", tx.Hash)
+
+        // A failed relay is retried by the next processing round
+        if err := sc.releaseLeg(swap, swap.Initiator, swap.Participant.ShardID); err != nil {
+                sc.logger.Warn("Failed to relay swap secret",
+                        "swapID", swap.ID,
+                        "targetShard", swap.Initiator.ShardID,
+                        "error", err)
+        }
+
+        return swap.copy(), nil
+}
+
+// Refund processes a swap immediately, refunding every expired lock that
+// has not been claimed
+func (sc *SwapCoordinator) Refund(swapID string) (*AtomicSwap, error) {
+        sc.mu.Lock()
+        defer sc.mu.Unlock()
+
+        swap, exists := sc.swaps[swapID]
+        if !exists {
+                return nil, errors.New("swap not found")
+        }
+        sc.learnPreimage(swap, swap.legs())
+        if swap.Preimage != "" {
+                sc.processSwap(swap)
+                return nil, errors.New("swap secret was revealed; locks can only be claimed")
+        }
+
+        sc.processSwap(swap)
+        if swap.Status != SwapRefunded && !sc.refundPending(swap) {
+                return nil, fmt.Errorf("initiator lock can be refunded after height %d in shard %d",
+                        swap.Initiator.Timeout, swap.Initiator.ShardID)
+        }
+        return swap.copy(), nil
+}
+
+// refundPending reports whether any leg of the swap has a refund submitted
+func (sc *SwapCoordinator) refundPending(swap *AtomicSwap) bool {
+        for _, leg := range swap.legs() {
+                if leg.ReleaseTx != "" {
+                        return true
+                }
+        }
+        return false
+}
+
+// submitLock adds a lock transaction to its shard. Must be called with the
+// lock held.
+func (sc *SwapCoordinator) submitLock(leg *SwapLeg, tx *core.Transaction) error {
+        shard, err := sc.manager.GetShard(leg.ShardID)
+        if err != nil {
+                return err
+        }
+        return shard.Blockchain.AddTransaction(tx)
+}
+
+// releaseLeg claims a leg with the swap's secret, or refunds it if the
+// secret is unknown, relaying the transaction from sourceShard through the
+// cross-channel. Must be called with the lock held.
+func (sc *SwapCoordinator) releaseLeg(swap *AtomicSwap, leg *SwapLeg, sourceShard int) error {
+        shard, err := sc.manager.GetShard(leg.ShardID)
+        if err != nil {
+                return err
+        }
+        relayID, err := sc.manager.selectRelay(shard)
+        if err != nil {
+                return err
+        }
+
+        var tx *core.Transaction
+        if swap.Preimage != "" {
+                tx, err = core.NewHTLCClaimTransaction(leg.Recipient, leg.LockID, swap.Preimage, leg.Fee, leg.ShardID)
+        } else {
+                tx, err = core.NewHTLCRefundTransaction(leg.Sender, leg.LockID, leg.Fee, leg.ShardID)
+        }
+        if err != nil {
+                return err
+        }
+
+        // The relay releases the lock on behalf of the party it pays
+        if err := tx.Sign(relayID); err != nil {
+                return err
+        }
+
+        if sourceShard != leg.ShardID {
+                if err := sc.manager.crossChannel.PropagateTransaction(tx, sourceShard, leg.ShardID); err != nil {
+                        return err
+                }
+        }
+        if err := shard.Blockchain.AddTransaction(tx); err != nil {
+                return err
+        }
+        if sourceShard != leg.ShardID {
+                sc.manager.crossChannel.ConfirmTransaction(tx.Hash, sourceShard)
+                sc.manager.crossChannel.ConfirmWhenFinal(tx.Hash, leg.ShardID, tx.Hash)
+        }
+        leg.ReleaseTx = tx.Hash
+
+        sc.logger.Info("Submitted swap release",
+                "swapID", swap.ID,
+                "shardID", leg.ShardID,
+                "txHash", tx.Hash,
+                "claim", swap.Preimage != "",
+                "relay", relayID)
+
+        return nil
+}
+
+// processSwap advances a swap: it releases every lock that is due and
+// updates the swap status from the on-chain lock records. Must be called
+// with the lock held.
+func (sc *SwapCoordinator) processSwap(swap *AtomicSwap) {
+        if swap.Status == SwapCompleted || swap.Status == SwapRefunded {
+                return
+        }
+
+        legs := swap.legs()
+        sc.learnPreimage(swap, legs)
+
+        claimed, refunded := 0, 0
+        for _, leg := range legs {
+                shard, err := sc.manager.GetShard(leg.ShardID)
+                if err != nil {
+                        continue
+                }
+                lock, exists := shard.Blockchain.State.GetLock(leg.LockID)
+                if !exists {
+                        continue
+                }
+
+                switch lock.Status {
+                case core.LockClaimed:
+                        claimed++
+                        continue
+                case core.LockRefunded:
+                        refunded++
+                        continue
+                }
+
+                // Leave a submitted release alone while it is in the pool
+                if leg.ReleaseTx != "" && shard.Blockchain.GetTransaction(leg.ReleaseTx) != nil {
+                        continue
+                }
+                if swap.Preimage == "" && shard.Blockchain.GetHeight() < leg.Timeout {
+                        continue
+                }
+
+                sourceShard := leg.ShardID
+                if swap.Preimage != "" && swap.Participant != nil {
+                        sourceShard = swap.Participant.ShardID
+                }
+                if err := sc.releaseLeg(swap, leg, sourceShard); err != nil {
+                        sc.logger.Warn("Failed to release swap lock",
+                                "swapID", swap.ID,
+                                "shardID", leg.ShardID,
+                                "error", err)
+                }
+        }
+
+        switch {
+        case claimed == len(legs) && swap.Participant != nil:
+                swap.Status = SwapCompleted
+                sc.completed++
+                sc.logger.Info("Atomic swap completed", "swapID", swap.ID)
+        case refunded == len(legs):
+                swap.Status = SwapRefunded
+                sc.refunded++
+                sc.logger.Info("Atomic swap refunded", "swapID", swap.ID)
+        }
+}
+
+// learnPreimage takes the swap secret from any lock claimed on chain, so
+// the remaining locks are claimed with it before any of them can be
+// refunded. Must be called with the lock held.
+func (sc *SwapCoordinator) learnPreimage(swap *AtomicSwap, legs []*SwapLeg) {
+        if swap.Preimage != "" {
+                return
+        }
+        for _, leg := range legs {
+                shard, err := sc.manager.GetShard(leg.ShardID)
+                if err != nil {
+                        continue
+                }
+                lock, exists := shard.Blockchain.State.GetLock(leg.LockID)
+                if !exists || lock.Status != core.LockClaimed || lock.Preimage == "" {
+                        continue
+                }
+
+                swap.Preimage = lock.Preimage
+                if swap.Status == SwapParticipated {
+                        swap.Status = SwapClaimed
+                }
+                sc.logger.Info("Atomic swap secret learned from claimed lock",
+                        "swapID", swap.ID,
+                        "shardID", leg.ShardID,
+                        "lockID", leg.LockID)
+                return
+        }
+}
+
+// Process advances every open swap
+func (sc *SwapCoordinator) Process() {
+        sc.mu.Lock()
+        defer sc.mu.Unlock()
+
+        for _, swap := range sc.swaps {
+                sc.processSwap(swap)
+        }
+}
+
+// GetSwap returns a copy of a swap
+func (sc *SwapCoordinator) GetSwap(id string) (*AtomicSwap, bool) {
+        sc.mu.Lock()
+        defer sc.mu.Unlock()
+
+        swap, exists := sc.swaps[id]
+        if !exists {
+                return nil, false
+        }
+        return swap.copy(), true
+}
+
+// GetSwaps returns copies of all swaps, newest first
+func (sc *SwapCoordinator) GetSwaps() []*AtomicSwap {
+        sc.mu.Lock()
+        defer sc.mu.Unlock()
+
+        swaps := make([]*AtomicSwap, 0, len(sc.swaps))
+        for _, swap := range sc.swaps {
+                swaps = append(swaps, swap.copy())
+        }
+        sort.Slice(swaps, func(i, j int) bool { return swaps[i].CreatedAt.After(swaps[j].CreatedAt) })
+        return swaps
+}
+
+// legs returns the locks placed for the swap so far
+func (swap *AtomicSwap) legs() []*SwapLeg {
+        if swap.Participant == nil {
+                return []*SwapLeg{swap.Initiator}
+        }
+        return []*SwapLeg{swap.Initiator, swap.Participant}
+}
+
+// copy returns a deep copy of the swap
+func (swap *AtomicSwap) copy() *AtomicSwap {
+        swapCopy := *swap
+        initiator := *swap.Initiator
+        swapCopy.Initiator = &initiator
+        if swap.Participant != nil {
+                participant := *swap.Participant
+                swapCopy.Participant = &participant
+        }
+        return &swapCopy
+}
+
+// Start starts periodic swap processing
+func (sc *SwapCoordinator) Start() error {
+        sc.mu.Lock()
+        defer sc.mu.Unlock()
+
+        if sc.running {
+                return errors.New("swap coordinator already running")
+        }
+        sc.running = true
+        sc.stopChan = make(chan struct{})
+
+        interval := time.Duration(sc.config.BlockTime) * time.Second
+        if interval <= 0 {
+                interval = 5 * time.Second
+        }
+
+        go func(stop chan struct{}) {
+                ticker := time.NewTicker(interval)
+                defer ticker.Stop()
+
+                for {
+                        select {
+                        case <-stop:
+                                return
+                        case <-ticker.C:
+                                sc.Process()
+                        }
+                }
+        }(sc.stopChan)
+
+        sc.logger.Info("Swap coordinator started", "interval", interval)
+        return nil
+}
+
+// Stop stops periodic swap processing
+func (sc *SwapCoordinator) Stop() error {
+        sc.mu.Lock()
+        defer sc.mu.Unlock()
+
+        if !sc.running {
+                return errors.New("swap coordinator not running")
+        }
+        close(sc.stopChan)
+        sc.running = false
+
+        sc.logger.Info("Swap coordinator stopped")
+        return nil
+}
+
+// GetStatus returns the status of the swap coordinator
+func (sc *SwapCoordinator) GetStatus() map[string]interface{} {
+        sc.mu.Lock()
+        defer sc.mu.Unlock()
+
+        return map[string]interface{}{
+                "running":         sc.running,
+                "swaps":           len(sc.swaps),
+                "open_swaps":      len(sc.swaps) - sc.completed - sc.refunded,
+                "completed_swaps": sc.completed,
+                "refunded_swaps":  sc.refunded,
+        }
+}
@@ -0,0 +1,237 @@
+package sharding
+
+import (
+	"strings"
+	"testing"
+
+	"lscc/core"
+	"lscc/utils"
+)
+
+// swapLock returns a signed lock of 10 coins in a shard
+func swapLock(t *testing.T, from, to string, shardID int, hashLock string, timeout uint64) *core.Transaction {
+	t.Helper()
+	tx, err := core.NewHTLCLockTransaction(from, to, utils.Coins(10), utils.Coins(1), shardID, hashLock, timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Sign(from); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// swapClaim returns a signed claim of a lock in a shard
+func swapClaim(t *testing.T, from, lockID, preimage string, shardID int) *core.Transaction {
+	t.Helper()
+	tx, err := core.NewHTLCClaimTransaction(from, lockID, preimage, utils.Coins(1), shardID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Sign(from); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// mineTo mines blocks in a shard until it reaches a height
+func mineTo(t *testing.T, shard *Shard, height uint64) {
+	t.Helper()
+	for shard.Blockchain.GetHeight() < height {
+		mine(t, shard)
+	}
+}
+
+// startSwap returns the coordinator of a swap of alice's 10 coins in shard
+// 0, locked until height 10, for bob's 10 coins in shard 1, locked until
+// height 5, with the secret only alice knows
+func startSwap(t *testing.T) (m *Manager, swap *AtomicSwap, preimage string) {
+	t.Helper()
+	m = newTestManager(t, 2)
+	sc := m.GetSwapCoordinator()
+	preimage, hashLock, err := core.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if swap, err = sc.Initiate(swapLock(t, "alice", "bob", 0, hashLock, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if swap, err = sc.Participate(swap.ID, swapLock(t, "bob", "alice", 1, hashLock, 5)); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, testShard(t, m, 0))
+	mine(t, testShard(t, m, 1))
+	return m, swap, preimage
+}
+
+// swapStatus returns the status of a swap after a processing round
+func swapStatus(t *testing.T, sc *SwapCoordinator, id string) SwapStatus {
+	t.Helper()
+	sc.Process()
+	swap, exists := sc.GetSwap(id)
+	if !exists {
+		t.Fatal("swap not found")
+	}
+	return swap.Status
+}
+
+func TestSwapParticipateChecksCounterLock(t *testing.T) {
+	m := newTestManager(t, 2)
+	sc := m.GetSwapCoordinator()
+	_, hashLock, err := core.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	swap, err := sc.Initiate(swapLock(t, "alice", "bob", 0, hashLock, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sc.Initiate(swapLock(t, "alice", "carol", 0, hashLock, 10)); err == nil {
+		t.Error("second swap on the same hash lock initiated")
+	}
+
+	_, otherLock, err := core.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		tx   *core.Transaction
+		want string
+	}{
+		{"on another hash lock", swapLock(t, "bob", "alice", 1, otherLock, 5), "hash lock"},
+		{"paying someone else", swapLock(t, "bob", "carol", 1, hashLock, 5), "pay the initiator"},
+		{"expiring with the initiator's lock", swapLock(t, "bob", "alice", 1, hashLock, 10), "expire before"},
+	}
+	for _, tt := range tests {
+		if _, err := sc.Participate(swap.ID, tt.tx); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("counter-lock %s: err = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+	if _, err := sc.Participate("unknown", swapLock(t, "bob", "alice", 1, hashLock, 5)); err == nil {
+		t.Error("counter-lock of an unknown swap accepted")
+	}
+
+	swap, err = sc.Participate(swap.ID, swapLock(t, "bob", "alice", 1, hashLock, 5))
+	if err != nil {
+		t.Fatalf("counter-lock refused: %v", err)
+	}
+	if swap.Status != SwapParticipated {
+		t.Errorf("status = %s, want %s", swap.Status, SwapParticipated)
+	}
+}
+
+func TestSwapCompletes(t *testing.T) {
+	m, swap, preimage := startSwap(t)
+	sc := m.GetSwapCoordinator()
+	initiatorShard, participantShard := testShard(t, m, 0), testShard(t, m, 1)
+
+	wrong, _, err := core.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sc.Claim(swap.ID, swapClaim(t, "alice", swap.Participant.LockID, wrong, 1)); err == nil {
+		t.Error("claim with the wrong secret accepted")
+	}
+	if _, err := sc.Claim(swap.ID, swapClaim(t, "alice", swap.Initiator.LockID, preimage, 0)); err == nil {
+		t.Error("claim of the initiator's own lock accepted")
+	}
+
+	// alice's claim reveals the secret, which claims her lock for bob in
+	// the other shard
+	claimed, err := sc.Claim(swap.ID, swapClaim(t, "alice", swap.Participant.LockID, preimage, 1))
+	if err != nil {
+		t.Fatalf("claim refused: %v", err)
+	}
+	if claimed.Status != SwapClaimed || claimed.Initiator.ReleaseTx == "" {
+		t.Fatalf("swap is %s with release %q, want claimed with the initiator's lock released", claimed.Status, claimed.Initiator.ReleaseTx)
+	}
+	mine(t, initiatorShard)
+	mine(t, participantShard)
+
+	if status := swapStatus(t, sc, swap.ID); status != SwapCompleted {
+		t.Fatalf("status = %s, want %s", status, SwapCompleted)
+	}
+	// Each receives 10 coins less the fee of the claim releasing them
+	if balance := initiatorShard.Blockchain.State.GetBalance("bob"); balance != utils.Coins(9) {
+		t.Errorf("bob = %s in shard 0, want 9", balance)
+	}
+	if balance := participantShard.Blockchain.State.GetBalance("alice"); balance != utils.Coins(9) {
+		t.Errorf("alice = %s in shard 1, want 9", balance)
+	}
+	if _, err := sc.Refund(swap.ID); err == nil {
+		t.Error("completed swap refunded")
+	}
+}
+
+func TestSwapLearnsSecretFromChain(t *testing.T) {
+	m, swap, preimage := startSwap(t)
+	sc := m.GetSwapCoordinator()
+	initiatorShard, participantShard := testShard(t, m, 0), testShard(t, m, 1)
+
+	// alice claims in shard 1 without the coordinator; the secret on chain
+	// still completes the swap
+	if err := participantShard.Blockchain.AddTransaction(swapClaim(t, "alice", swap.Participant.LockID, preimage, 1)); err != nil {
+		t.Fatal(err)
+	}
+	mine(t, participantShard)
+	if status := swapStatus(t, sc, swap.ID); status != SwapClaimed {
+		t.Fatalf("status = %s, want %s", status, SwapClaimed)
+	}
+
+	// Even past its timeout, the initiator's lock is not refunded while
+	// the claim is pending
+	mineTo(t, initiatorShard, 10)
+	mine(t, initiatorShard)
+	if status := swapStatus(t, sc, swap.ID); status != SwapCompleted {
+		t.Fatalf("status = %s, want %s", status, SwapCompleted)
+	}
+	if balance := initiatorShard.Blockchain.State.GetBalance("bob"); balance != utils.Coins(9) {
+		t.Errorf("bob = %s in shard 0, want 9", balance)
+	}
+}
+
+func TestSwapRefundsAfterTimeouts(t *testing.T) {
+	m, swap, _ := startSwap(t)
+	sc := m.GetSwapCoordinator()
+	initiatorShard, participantShard := testShard(t, m, 0), testShard(t, m, 1)
+
+	if _, err := sc.Refund(swap.ID); err == nil {
+		t.Fatal("swap refunded before any lock expired")
+	}
+
+	// bob's lock expires first and is refunded on its own
+	mineTo(t, participantShard, 5)
+	sc.Process()
+	mine(t, participantShard)
+	if status := swapStatus(t, sc, swap.ID); status != SwapParticipated {
+		t.Fatalf("status = %s with alice's lock active, want %s", status, SwapParticipated)
+	}
+	partial, err := sc.Refund(swap.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if partial.Initiator.ReleaseTx != "" {
+		t.Error("alice's lock released before its timeout")
+	}
+
+	mineTo(t, initiatorShard, 10)
+	if _, err := sc.Refund(swap.ID); err != nil {
+		t.Fatalf("refund after both timeouts refused: %v", err)
+	}
+	mine(t, initiatorShard)
+	if status := swapStatus(t, sc, swap.ID); status != SwapRefunded {
+		t.Fatalf("status = %s, want %s", status, SwapRefunded)
+	}
+
+	// Each paid 1 coin to lock and 1 to refund
+	if balance := initiatorShard.Blockchain.State.GetBalance("alice"); balance != utils.Coins(98) {
+		t.Errorf("alice = %s in shard 0, want 98", balance)
+	}
+	if balance := participantShard.Blockchain.State.GetBalance("bob"); balance != utils.Coins(98) {
+		t.Errorf("bob = %s in shard 1, want 98", balance)
+	}
+	if status := sc.GetStatus(); status["refunded_swaps"] != 1 {
+		t.Errorf("status reports %v refunded swaps, want 1", status["refunded_swaps"])
+	}
+}
@@ -0,0 +1,323 @@
+package simnet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"lscc/config"
+	"lscc/consensus"
+	"lscc/core"
+	"lscc/network"
+	"lscc/sharding"
+	"lscc/utils"
+)
+
+// Cluster
+//
+// A cluster is a set of nodes run in one process. The nodes talk over
+// loopback TCP like separate processes would, or over a simulated network
+// when the topology has one; only the genesis, the addresses and the relay
+// set are arranged by the cluster. Every node is a validator of its shard,
+// the first node of each shard is also its relay, and every node knows the
+// relays of all shards so relay blocks can be finalized.
+
+// nodeBalance is the genesis balance of every node
+var nodeBalance = utils.Coins(10000)
+
+// simPort is the port every node listens on in a simulated network, where
+// the node ID tells nodes apart
+const simPort = 9000
+
+// Topology describes a cluster
+type Topology struct {
+	Nodes             int          // Nodes in total, spread round-robin over the shards
+	Shards            int          // Shards of the single layer
+	ConsensusType     string       // Engine every shard runs
+	BlockTime         int          // Seconds between blocks
+	ViewChangeTimeout int          // Seconds without a commit before a PBFT view changes, 0 for the default
+	Confirmations     int          // Blocks that finalize a block by depth, 0 for the default
+	Accounts          int          // Funded accounts of each shard, see AccountName
+	Balance           utils.Amount // Genesis balance of every account
+	Network           *Network     // Network the nodes talk over, nil for loopback TCP
+}
+
+// Cluster is a running in-process network
+type Cluster struct {
+	Nodes   []*network.Node
+	Genesis *config.Genesis
+	Network *Network // Simulated network of the nodes, nil over TCP
+	dataDir string
+}
+
+// AccountName returns the name of the i-th funded account of a shard
+func AccountName(shardID, i int) string {
+	return fmt.Sprintf("s%d-acct%d", shardID, i)
+}
+
+// NodeName returns the ID of the i-th node of a cluster, counting from 0
+func NodeName(i int) string {
+	return fmt.Sprintf("node%d", i+1)
+}
+
+// StartCluster starts the nodes of a topology, each with a copy of base
+// for its settings, and waits until every node has a peer
+func StartCluster(base *config.Config, topo Topology) (*Cluster, error) {
+	if topo.Nodes < topo.Shards || topo.Shards <= 0 {
+		return nil, errors.New("need at least one node per shard")
+	}
+
+	dataDir, err := os.MkdirTemp("", "lscc-simnet-")
+	if err != nil {
+		return nil, err
+	}
+	c := &Cluster{Network: topo.Network, dataDir: dataDir}
+
+	addresses, ports, err := c.addresses(topo.Nodes)
+	if err != nil {
+		c.Stop()
+		return nil, err
+	}
+
+	var keys map[string]string
+	c.Genesis, keys, err = clusterGenesis(base, topo)
+	if err != nil {
+		c.Stop()
+		return nil, err
+	}
+	core.SetChainID(c.Genesis.ChainID)
+
+	// The first node of each shard relays its cross-shard traffic
+	relays := make(map[string]int)
+	for i := 0; i < topo.Shards; i++ {
+		relays[NodeName(i)] = i
+	}
+
+	for i := 0; i < topo.Nodes; i++ {
+		cfg := *base
+		cfg.NodeID = NodeName(i)
+		cfg.ValidatorKey = keys[cfg.NodeID]
+		cfg.Port = ports[i]
+		cfg.APIPort = 0
+		cfg.ShardID = i % topo.Shards
+		cfg.IsRelay = i < topo.Shards
+		cfg.ConsensusType = ""
+		cfg.ConsensusParams = nil
+		cfg.GenesisFile = ""
+		cfg.DataDir = filepath.Join(dataDir, cfg.NodeID)
+		cfg.BootstrapNodes = append([]string(nil), addresses[:i]...)
+		if err := cfg.ApplyGenesis(c.Genesis); err != nil {
+			c.Stop()
+			return nil, err
+		}
+		if err := consensus.ValidateConfig(&cfg); err != nil {
+			c.Stop()
+			return nil, fmt.Errorf("%s: %w", cfg.NodeID, err)
+		}
+
+		manager := sharding.NewManager(&cfg)
+		for relay, shardID := range relays {
+			if relay != cfg.NodeID {
+				manager.AssignNodeToShard(relay, shardID, true)
+			}
+		}
+		node, err := network.NewNode(&cfg, manager)
+		if err != nil {
+			c.Stop()
+			return nil, fmt.Errorf("%s: %w", cfg.NodeID, err)
+		}
+		if c.Network != nil {
+			node.SetTransport(c.Network.Endpoint(cfg.NodeID))
+		}
+		if err := node.Start(); err != nil {
+			c.Stop()
+			return nil, fmt.Errorf("%s: %w", cfg.NodeID, err)
+		}
+		c.Nodes = append(c.Nodes, node)
+	}
+
+	if err := c.waitConnected(10 * time.Second); err != nil {
+		c.Stop()
+		return nil, err
+	}
+	return c, nil
+}
+
+// addresses returns the address and port of every node: loopback ports
+// that were free when asked for, or the node IDs on a simulated network
+func (c *Cluster) addresses(count int) ([]string, []int, error) {
+	addresses := make([]string, 0, count)
+	ports := make([]int, 0, count)
+	if c.Network != nil {
+		for i := 0; i < count; i++ {
+			addresses = append(addresses, fmt.Sprintf("%s:%d", NodeName(i), simPort))
+			ports = append(ports, simPort)
+		}
+		return addresses, ports, nil
+	}
+
+	listeners := make([]net.Listener, 0, count)
+	defer func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}()
+	for i := 0; i < count; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, nil, err
+		}
+		listeners = append(listeners, listener)
+		port := listener.Addr().(*net.TCPAddr).Port
+		addresses = append(addresses, fmt.Sprintf("127.0.0.1:%d", port))
+		ports = append(ports, port)
+	}
+	return addresses, ports, nil
+}
+
+// clusterGenesis returns the genesis of a topology, every node a validator
+// of its shard with a fresh key and every account funded in its shard, and
+// the private keys of the nodes
+func clusterGenesis(base *config.Config, topo Topology) (*config.Genesis, map[string]string, error) {
+	genesis := base.Genesis()
+	genesis.GenesisTime = time.Now().Unix()
+	genesis.ShardCount = topo.Shards
+	genesis.LayerCount = 1
+	genesis.ConsensusType = topo.ConsensusType
+	genesis.ConsensusParams = nil
+	genesis.Shards = nil
+	genesis.BlockTime = topo.BlockTime
+	if topo.Confirmations > 0 {
+		genesis.MinConfirmations = topo.Confirmations
+	}
+	genesis.Validators = nil
+	genesis.Allocations = nil
+
+	members := make(map[int][]string)
+	keys := make(map[string]string, topo.Nodes)
+	for i := 0; i < topo.Nodes; i++ {
+		nodeID, shardID := NodeName(i), i%topo.Shards
+		members[shardID] = append(members[shardID], nodeID)
+		privateKey, publicKey, err := utils.GenerateKeyPair()
+		if err != nil {
+			return nil, nil, err
+		}
+		keys[nodeID] = privateKey
+		genesis.Allocations = append(genesis.Allocations, config.Allocation{
+			Address: nodeID, Amount: nodeBalance, ShardID: shardID,
+		})
+		genesis.Validators = append(genesis.Validators, config.GenesisValidator{
+			Address: nodeID, Stake: genesis.MinStake, ShardID: shardID, PubKey: publicKey,
+		})
+	}
+	for shardID := 0; shardID < topo.Shards; shardID++ {
+		for i := 0; i < topo.Accounts; i++ {
+			genesis.Allocations = append(genesis.Allocations, config.Allocation{
+				Address: AccountName(shardID, i), Amount: topo.Balance, ShardID: shardID,
+			})
+		}
+	}
+
+	// PBFT shards need their committee
+	if consensus.ConsensusType(topo.ConsensusType) == consensus.PBFT {
+		for shardID := 0; shardID < topo.Shards; shardID++ {
+			params := map[string]interface{}{"validators": members[shardID]}
+			if topo.ViewChangeTimeout > 0 {
+				params["view_change_timeout"] = topo.ViewChangeTimeout
+			}
+			encoded, err := json.Marshal(params)
+			if err != nil {
+				return nil, nil, err
+			}
+			genesis.Shards = append(genesis.Shards, config.ShardSpec{
+				ShardID: shardID, ConsensusType: topo.ConsensusType, ConsensusParams: encoded,
+			})
+		}
+	}
+
+	if err := genesis.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return genesis, keys, nil
+}
+
+// waitConnected waits until every node of a multi-node cluster has a peer
+func (c *Cluster) waitConnected(timeout time.Duration) error {
+	if len(c.Nodes) < 2 {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	for _, node := range c.Nodes {
+		for node.GetPeerCount() == 0 {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("%s has no peers after %s", node.ID, timeout)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	return nil
+}
+
+// Node returns the node with an ID, or nil
+func (c *Cluster) Node(nodeID string) *network.Node {
+	for _, node := range c.Nodes {
+		if node.ID == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+// NodesOfShard returns the nodes of a shard
+func (c *Cluster) NodesOfShard(shardID int) []*network.Node {
+	var nodes []*network.Node
+	for _, node := range c.Nodes {
+		if node.Config.ShardID == shardID {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// SetClockSkew shifts the clock a node stamps its blocks with and checks the
+// timestamps of other blocks against
+func (c *Cluster) SetClockSkew(nodeID string, offset time.Duration) error {
+	node := c.Node(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node %s", nodeID)
+	}
+	node.ShardManager.SetClock(core.OffsetClock(offset))
+	return nil
+}
+
+// Crash stops a node as if its process died; its data stays until the
+// cluster stops
+func (c *Cluster) Crash(nodeID string) error {
+	node := c.Node(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node %s", nodeID)
+	}
+	return node.Stop()
+}
+
+// Heights returns the height of the chain of each node's own shard, by
+// node ID
+func (c *Cluster) Heights() map[string]uint64 {
+	heights := make(map[string]uint64, len(c.Nodes))
+	for _, node := range c.Nodes {
+		heights[node.ID] = node.Blockchain.GetHeight()
+	}
+	return heights
+}
+
+// Stop stops the nodes and removes their data
+func (c *Cluster) Stop() {
+	for _, node := range c.Nodes {
+		node.Stop()
+	}
+	os.RemoveAll(c.dataDir)
+}
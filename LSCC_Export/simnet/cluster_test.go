@@ -0,0 +1,303 @@
+package simnet
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"lscc/config"
+	"lscc/core"
+	"lscc/network"
+	"lscc/utils"
+)
+
+func TestMain(m *testing.M) {
+	utils.GetLogger().SetLevel("", utils.LogLevelError)
+	os.Exit(m.Run())
+}
+
+// startTestCluster starts a cluster on a simulated network and stops it
+// when the test ends
+func startTestCluster(t *testing.T, net *Network, topo Topology) *Cluster {
+	t.Helper()
+	base := config.DefaultConfig()
+	base.Tracing.CollectorURL = ""
+	topo.Network = net
+	cluster, err := StartCluster(base, topo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Stop)
+	return cluster
+}
+
+// waitHeights waits until every node has reached a height
+func waitHeights(t *testing.T, nodes []*network.Node, height uint64, timeout time.Duration) {
+	t.Helper()
+	err := WaitFor(timeout, func() bool {
+		for _, node := range nodes {
+			if node.Blockchain.GetHeight() < height {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		for _, node := range nodes {
+			t.Logf("%s at height %d", node.ID, node.Blockchain.GetHeight())
+		}
+		t.Fatalf("nodes did not reach height %d in %s", height, timeout)
+	}
+}
+
+// blockHash returns the hash of a node's block at a height, or "" if it
+// has none
+func blockHash(node *network.Node, height uint64) string {
+	block := node.Blockchain.GetBlockByHeight(height)
+	if block == nil {
+		return ""
+	}
+	hash, _ := block.Hash()
+	return hash
+}
+
+// agree reports whether nodes hold the same blocks up to a height
+func agree(nodes []*network.Node, height uint64) bool {
+	for h := uint64(1); h <= height; h++ {
+		hash := blockHash(nodes[0], h)
+		for _, node := range nodes[1:] {
+			if hash == "" || blockHash(node, h) != hash {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// minHeight returns the lowest height among nodes
+func minHeight(nodes []*network.Node) uint64 {
+	height := nodes[0].Blockchain.GetHeight()
+	for _, node := range nodes[1:] {
+		if h := node.Blockchain.GetHeight(); h < height {
+			height = h
+		}
+	}
+	return height
+}
+
+// testTransfer returns a signed transfer between funded accounts
+func testTransfer(t *testing.T, from, to string, sourceShard, targetShard int, nonce uint64) *core.Transaction {
+	t.Helper()
+	txType := core.RegularTransaction
+	if sourceShard != targetShard {
+		txType = core.CrossShardTransaction
+	}
+	tx, err := core.NewTransaction(from, to, utils.Coins(1), 0, sourceShard, targetShard, 0, txType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Nonce = nonce
+	if tx.Hash, err = tx.CalculateHash(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Sign(from); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// TestPBFTViewChangeUnderPartition isolates a member of a four-node
+// committee: the heights it is the first primary of must be decided by
+// another primary after a view change, and the member must rejoin once
+// the partition heals
+func TestPBFTViewChangeUnderPartition(t *testing.T) {
+	net := NewNetwork(1)
+	net.SetDefaultLink(Link{Latency: 5 * time.Millisecond, Jitter: 5 * time.Millisecond})
+	cluster := startTestCluster(t, net, Topology{
+		Nodes: 4, Shards: 1, ConsensusType: "pbft", BlockTime: 1, ViewChangeTimeout: 2,
+	})
+	waitHeights(t, cluster.Nodes, 1, 20*time.Second)
+
+	isolated := cluster.Nodes[1]
+	net.Partition([]string{isolated.ID})
+	rest := []*network.Node{cluster.Nodes[0], cluster.Nodes[2], cluster.Nodes[3]}
+	start := minHeight(rest)
+
+	// Four more blocks include a height whose first primary is isolated
+	waitHeights(t, rest, start+5, 60*time.Second)
+	if !agree(rest, start+5) {
+		t.Fatal("connected members disagree on the chain")
+	}
+
+	changed := false
+	for h := start + 1; h <= start+5; h++ {
+		// Heights count from 1, so the isolated member, second in the
+		// committee, is the first primary of the heights 1 modulo 4
+		if h%4 != 1 {
+			continue
+		}
+		proposer := rest[0].Blockchain.GetBlockByHeight(h).Header.ValidatorID
+		if proposer == isolated.ID {
+			t.Fatalf("block %d proposed by the isolated member", h)
+		}
+		changed = true
+	}
+	if !changed {
+		t.Fatal("no height of the isolated primary was decided")
+	}
+
+	// Once healed, the isolated member catches up on the heights it
+	// missed from their commits and takes part again
+	net.Heal()
+	target := minHeight(rest) + 2
+	waitHeights(t, cluster.Nodes, target, 60*time.Second)
+	if !agree(cluster.Nodes, target) {
+		t.Fatal("the isolated member did not rejoin the chain")
+	}
+}
+
+// TestPBFTRecoversFromSplit splits a four-node committee in halves, which
+// cannot commit and time out into different views, and checks it agrees
+// on a view and commits again once healed
+func TestPBFTRecoversFromSplit(t *testing.T) {
+	net := NewNetwork(2)
+	net.SetDefaultLink(Link{Latency: 5 * time.Millisecond, Jitter: 5 * time.Millisecond})
+	cluster := startTestCluster(t, net, Topology{
+		Nodes: 4, Shards: 1, ConsensusType: "pbft", BlockTime: 1, ViewChangeTimeout: 2,
+	})
+	waitHeights(t, cluster.Nodes, 1, 20*time.Second)
+
+	net.Partition([]string{"node1", "node2"}, []string{"node3", "node4"})
+	time.Sleep(500 * time.Millisecond)
+	split := minHeight(cluster.Nodes)
+	time.Sleep(7 * time.Second)
+	for _, node := range cluster.Nodes {
+		if node.Blockchain.GetHeight() > split+1 {
+			t.Fatalf("%s committed at height %d without a quorum", node.ID, node.Blockchain.GetHeight())
+		}
+	}
+
+	net.Heal()
+	waitHeights(t, cluster.Nodes, split+4, 60*time.Second)
+	if !agree(cluster.Nodes, split+4) {
+		t.Fatal("members disagree on the chain after the split")
+	}
+}
+
+// TestPoWForkUnderLatency mines on two halves of a lossy, slow network
+// until each has its own branch, then checks the nodes settle on one
+// branch once they can reach each other. The branches are buried deeper
+// than the default confirmation depth by then, which would finalize the
+// fork in each half, so the test finalizes deeper.
+func TestPoWForkUnderLatency(t *testing.T) {
+	net := NewNetwork(3)
+	net.SetDefaultLink(Link{Latency: 100 * time.Millisecond, Jitter: 100 * time.Millisecond, Loss: 0.02})
+	cluster := startTestCluster(t, net, Topology{
+		Nodes: 4, Shards: 1, ConsensusType: "pow", BlockTime: 1, Confirmations: 30,
+	})
+
+	net.Partition([]string{"node1", "node2"}, []string{"node3", "node4"})
+	fork := minHeight(cluster.Nodes) + 1
+	left, right := cluster.Nodes[:2], cluster.Nodes[2:]
+	waitHeights(t, cluster.Nodes, fork+2, 60*time.Second)
+	if blockHash(left[0], fork+1) == blockHash(right[0], fork+1) {
+		t.Fatal("the halves did not fork")
+	}
+	before := make(map[string]string)
+	for _, node := range cluster.Nodes {
+		before[node.ID] = blockHash(node, fork+1)
+	}
+
+	net.Heal()
+	err := WaitFor(60*time.Second, func() bool {
+		return agree(cluster.Nodes, fork+1)
+	})
+	if err != nil {
+		for _, node := range cluster.Nodes {
+			t.Logf("%s height %d finalized %d fork %s", node.ID, node.Blockchain.GetHeight(), node.Blockchain.Finality.FinalizedHeight(), blockHash(node, fork+1)[:8])
+		}
+		t.Fatal("nodes did not settle on one branch")
+	}
+
+	replaced := 0
+	for _, node := range cluster.Nodes {
+		if blockHash(node, fork+1) != before[node.ID] {
+			replaced++
+		}
+	}
+	if replaced == 0 {
+		t.Fatal("no node switched branches")
+	}
+}
+
+// TestCrossShardDeliveryFailure sends cross-shard transfers while the
+// target shard is cut off: the source relays finalize them, but the target
+// never learns of them and refuses a credit that names their relay block
+func TestCrossShardDeliveryFailure(t *testing.T) {
+	net := NewNetwork(4)
+	net.SetDefaultLink(Link{Latency: 5 * time.Millisecond})
+	cluster := startTestCluster(t, net, Topology{
+		Nodes: 2, Shards: 2, ConsensusType: "pbft", BlockTime: 1, Accounts: 5, Balance: utils.Coins(100),
+	})
+	source, target := cluster.NodesOfShard(0)[0], cluster.NodesOfShard(1)[0]
+
+	finalized := source.ShardManager.Events().Subscribe("test", 16, core.EventRelayBlockFinalized)
+	defer finalized.Unsubscribe()
+
+	net.Partition([]string{source.ID})
+	var txs []*core.Transaction
+	for i := 0; i < 5; i++ {
+		tx := testTransfer(t, AccountName(0, i), AccountName(1, i), 0, 1, 1)
+		if err := source.SubmitTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+	}
+
+	var relayBlock *core.RelayBlock
+	select {
+	case event := <-finalized.Events():
+		relayBlock = event.RelayBlock
+	case <-time.After(20 * time.Second):
+		t.Fatal("source relays did not finalize the transfers")
+	}
+	if len(relayBlock.CrossShardTxs) != len(txs) {
+		t.Fatalf("relay block carries %d transfers, want %d", len(relayBlock.CrossShardTxs), len(txs))
+	}
+
+	// Transfers are gossiped in the background, so keep the partition until
+	// they have surely been sent, then give anything resent time to arrive
+	time.Sleep(2 * time.Second)
+	net.Heal()
+	time.Sleep(2 * time.Second)
+	for _, tx := range txs {
+		if _, found := target.ShardManager.FindCrossShardTransaction(tx.Hash); found {
+			t.Fatalf("transfer %s reached the target shard across the partition", tx.Hash)
+		}
+	}
+	if _, found := target.ShardManager.GetRelayConsensus().GetRelayBlock(relayBlock.ID); found {
+		t.Fatal("target shard knows the relay block finalized across the partition")
+	}
+
+	// A credit paying out the undelivered transfer is refused
+	credit, err := core.NewTransaction(txs[0].From, txs[0].To, txs[0].Amount, 0, 1, 1, 0, core.CrossShardTransaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credit.Data, err = json.Marshal(core.CreditSource{
+		SourceShard: 0, TxHashes: []string{txs[0].Hash}, RelayBlockID: relayBlock.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credit.Hash, err = credit.CalculateHash(); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.Blockchain.AddTransaction(credit); err == nil {
+		t.Fatal("target shard accepted a credit for an undelivered transfer")
+	}
+	if balance := target.Blockchain.State.GetBalance(txs[0].To); balance != utils.Coins(100) {
+		t.Fatalf("recipient balance = %s, want %s", balance, utils.Coins(100))
+	}
+}
@@ -0,0 +1,463 @@
+package simnet
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"lscc/network"
+)
+
+// Simulated network
+//
+// A network carries the messages of nodes run in one process over
+// in-memory connections. Each node reaches it through an endpoint named
+// after the node, which serves as the host of its addresses. Every message
+// is delayed by the latency of its link, plus a random jitter, and may be
+// lost; messages between nodes on different sides of a partition are
+// dropped, and no new connections are made across it. Messages on a
+// connection arrive in order, as over TCP.
+//
+// Faults are drawn from a random source seeded by the caller, so a run
+// sees the same faults for the same sequence of messages. Nodes still run
+// their own goroutines and timers, so the interleaving of messages between
+// runs can differ.
+//
+// A node can be given a filter that rewrites, delays or drops the messages
+// it writes, which is how misbehaving nodes are simulated.
+
+// Link describes the conditions of messages from one node to another
+type Link struct {
+	Latency time.Duration // Delay of every message
+	Jitter  time.Duration // Upper bound of a random delay added to the latency
+	Loss    float64       // Probability that a message is lost, from 0 to 1
+}
+
+// Stats count the messages a network has carried
+type Stats struct {
+	Sent        uint64 `json:"sent"`
+	Delivered   uint64 `json:"delivered"`
+	Lost        uint64 `json:"lost"`        // Dropped by the loss of their link
+	Partitioned uint64 `json:"partitioned"` // Dropped by a partition
+	Filtered    uint64 `json:"filtered"`    // Dropped by the sender's filter
+}
+
+// Outgoing is a message a filter lets a node send
+type Outgoing struct {
+	Data  []byte
+	Delay time.Duration // How much later than written the message is sent
+}
+
+// Filter rewrites a message a node writes to another node. It returns the
+// messages to send in its place, none to drop it.
+type Filter func(to string, message []byte) []Outgoing
+
+// Network is an in-memory network between nodes
+type Network struct {
+	defaultLink Link
+	links       map[[2]string]Link
+	groups      map[string]int // Side of a partition of each named host
+	filters     map[string]Filter
+	listeners   map[string]*listener
+	rng         *rand.Rand
+	stats       Stats
+	nextPort    int // Port of the next outgoing connection
+	mu          sync.Mutex
+}
+
+// NewNetwork creates a network without latency or loss whose faults are
+// drawn from a source with the given seed
+func NewNetwork(seed int64) *Network {
+	return &Network{
+		links:     make(map[[2]string]Link),
+		filters:   make(map[string]Filter),
+		listeners: make(map[string]*listener),
+		rng:       rand.New(rand.NewSource(seed)),
+		nextPort:  40000,
+	}
+}
+
+// SetDefaultLink sets the conditions of links without their own
+func (n *Network) SetDefaultLink(link Link) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.defaultLink = link
+}
+
+// SetLink sets the conditions of messages from one node to another
+func (n *Network) SetLink(from, to string, link Link) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.links[[2]string{from, to}] = link
+}
+
+// Partition splits the network into groups of nodes that cannot reach each
+// other. Nodes not named in any group form one more group, so naming a
+// single node isolates it.
+func (n *Network) Partition(groups ...[]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, host := range group {
+			n.groups[host] = i + 1
+		}
+	}
+}
+
+// Heal removes the partition
+func (n *Network) Heal() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.groups = nil
+}
+
+// SetFilter sets the filter of the messages a node writes; nil removes it
+func (n *Network) SetFilter(host string, filter Filter) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if filter == nil {
+		delete(n.filters, host)
+		return
+	}
+	n.filters[host] = filter
+}
+
+// filter returns the filter of a node, or nil
+func (n *Network) filter(host string) Filter {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.filters[host]
+}
+
+// Stats returns the message counts of the network
+func (n *Network) Stats() Stats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.stats
+}
+
+// Endpoint returns the transport of a node. Its addresses have the node as
+// their host.
+func (n *Network) Endpoint(host string) network.Transport {
+	return &endpoint{network: n, host: host}
+}
+
+// reachable reports whether a partition lets two hosts talk. Must be
+// called with the lock held.
+func (n *Network) reachable(from, to string) bool {
+	return n.groups == nil || n.groups[from] == n.groups[to]
+}
+
+// route decides the fate of a message: its delay, or that it is dropped
+func (n *Network) route(from, to string) (time.Duration, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.stats.Sent++
+	if !n.reachable(from, to) {
+		n.stats.Partitioned++
+		return 0, false
+	}
+	link, exists := n.links[[2]string{from, to}]
+	if !exists {
+		link = n.defaultLink
+	}
+	if link.Loss > 0 && n.rng.Float64() < link.Loss {
+		n.stats.Lost++
+		return 0, false
+	}
+	delay := link.Latency
+	if link.Jitter > 0 {
+		delay += time.Duration(n.rng.Int63n(int64(link.Jitter) + 1))
+	}
+	return delay, true
+}
+
+// delivered counts a delivered message
+func (n *Network) delivered() {
+	n.mu.Lock()
+	n.stats.Delivered++
+	n.mu.Unlock()
+}
+
+// endpoint is the transport of one node
+type endpoint struct {
+	network *Network
+	host    string
+}
+
+// Listen starts accepting connections to a port of the endpoint's host;
+// the host part of the address is ignored
+func (e *endpoint) Listen(address string) (net.Listener, error) {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	addr := simAddr(net.JoinHostPort(e.host, port))
+
+	e.network.mu.Lock()
+	defer e.network.mu.Unlock()
+	if _, exists := e.network.listeners[string(addr)]; exists {
+		return nil, fmt.Errorf("listen %s: address already in use", addr)
+	}
+	l := &listener{
+		network: e.network,
+		addr:    addr,
+		accept:  make(chan net.Conn, 64),
+		closed:  make(chan struct{}),
+	}
+	e.network.listeners[string(addr)] = l
+	return l, nil
+}
+
+// Dial connects to a node's address
+func (e *endpoint) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	n := e.network
+	n.mu.Lock()
+	l, exists := n.listeners[address]
+	if !exists {
+		n.mu.Unlock()
+		return nil, fmt.Errorf("dial %s: connection refused", address)
+	}
+	if !n.reachable(e.host, host) {
+		n.mu.Unlock()
+		return nil, fmt.Errorf("dial %s: network is unreachable", address)
+	}
+	local := simAddr(net.JoinHostPort(e.host, strconv.Itoa(n.nextPort)))
+	n.nextPort++
+	n.mu.Unlock()
+
+	clientEnd, serverEnd := net.Pipe()
+	client := newConn(n, clientEnd, e.host, host, local, simAddr(address))
+	server := newConn(n, serverEnd, host, e.host, simAddr(address), local)
+
+	select {
+	case l.accept <- server:
+		return client, nil
+	case <-l.closed:
+	case <-time.After(timeout):
+	}
+	client.Close()
+	server.Close()
+	return nil, fmt.Errorf("dial %s: connection refused", address)
+}
+
+// listener accepts the connections made to an address
+type listener struct {
+	network   *Network
+	addr      simAddr
+	accept    chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accept:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.network.mu.Lock()
+		delete(l.network.listeners, string(l.addr))
+		l.network.mu.Unlock()
+	})
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
+
+// delivery is a message waiting for its delay to pass
+type delivery struct {
+	data []byte
+	at   time.Time
+}
+
+// conn is one end of an in-memory connection. Writes are queued and
+// written to the other end once their delay has passed; reads come from
+// the other end's deliveries.
+type conn struct {
+	net.Conn
+	network   *Network
+	from, to  string
+	local     simAddr
+	remote    simAddr
+	queue     chan delivery
+	lastAt    time.Time // Delivery time of the last queued message
+	closed    chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+}
+
+// connQueueSize bounds the messages in flight on a connection; writers
+// block when it is full
+const connQueueSize = 1024
+
+// newConn wraps one end of a pipe and starts delivering its writes
+func newConn(n *Network, end net.Conn, from, to string, local, remote simAddr) *conn {
+	c := &conn{
+		Conn:    end,
+		network: n,
+		from:    from,
+		to:      to,
+		local:   local,
+		remote:  remote,
+		queue:   make(chan delivery, connQueueSize),
+		closed:  make(chan struct{}),
+	}
+	go c.deliver()
+	return c
+}
+
+// Write passes a message through the sender's filter and queues what it
+// returns for delivery. Lost messages are reported as written, as the
+// sender of a lost packet cannot tell.
+func (c *conn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	message := append([]byte(nil), b...)
+	filter := c.network.filter(c.from)
+	if filter == nil {
+		if err := c.send(message); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	outgoing := filter(c.to, message)
+	if len(outgoing) == 0 {
+		c.network.mu.Lock()
+		c.network.stats.Filtered++
+		c.network.mu.Unlock()
+	}
+	for _, out := range outgoing {
+		if out.Delay > 0 {
+			data := out.Data
+			time.AfterFunc(out.Delay, func() { c.send(data) })
+			continue
+		}
+		if err := c.send(out.Data); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// send queues a message for delivery once its link's delay has passed, or
+// drops it
+func (c *conn) send(data []byte) error {
+	delay, ok := c.network.route(c.from, c.to)
+	if !ok {
+		return nil
+	}
+
+	c.mu.Lock()
+	at := time.Now().Add(delay)
+	if at.Before(c.lastAt) {
+		at = c.lastAt
+	}
+	c.lastAt = at
+	c.mu.Unlock()
+
+	select {
+	case c.queue <- delivery{data: data, at: at}:
+		return nil
+	case <-c.closed:
+		return net.ErrClosed
+	}
+}
+
+// deliver writes queued messages to the other end once they are due
+func (c *conn) deliver() {
+	for {
+		select {
+		case <-c.closed:
+			return
+		case d := <-c.queue:
+			if wait := time.Until(d.at); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-c.closed:
+					return
+				}
+			}
+			if _, err := c.Conn.Write(d.data); err != nil {
+				return
+			}
+			c.network.delivered()
+		}
+	}
+}
+
+func (c *conn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// SetWriteDeadline is a no-op: writes are queued without blocking on the
+// reader
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// SetDeadline sets the read deadline
+func (c *conn) SetDeadline(t time.Time) error {
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// simAddr is a host:port address on a simulated network
+type simAddr string
+
+func (a simAddr) Network() string {
+	return "sim"
+}
+
+func (a simAddr) String() string {
+	return string(a)
+}
+
+// ErrTimeout is returned by WaitFor when its condition does not hold in
+// time
+var ErrTimeout = errors.New("condition not met before the timeout")
+
+// WaitFor polls a condition until it holds or the timeout passes
+func WaitFor(timeout time.Duration, condition func() bool) error {
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			return ErrTimeout
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+        "encoding/json"
+        "errors"
+        "flag"
+        "fmt"
+        "os"
+        "path/filepath"
+        "strconv"
+        "strings"
+        "time"
+
+        "lscc/config"
+        "lscc/consensus"
+        "lscc/utils"
+)
+
+// runInit generates a genesis file and one config per node for a local
+// testnet. Nodes are spread round-robin over the shards; each is funded,
+// bonded as a validator of its shard and bootstraps from the first node.
+func runInit(args []string) error {
+        defaults := config.DefaultConfig()
+
+        fs := flag.NewFlagSet("init", flag.ExitOnError)
+        out := fs.String("out", "testnet", "Directory to write the genesis and node configs to")
+        chainID := fs.String("chain-id", defaults.ChainID, "Chain ID of the network")
+        nodes := fs.Int("nodes", 4, "Number of nodes")
+        shards := fs.Int("shards", 1, "Shards per layer")
+        layers := fs.Int("layers", 1, "Number of layers")
+        engine := fs.String("consensus", defaults.ConsensusType, "Default consensus engine of the shards")
+        shardEngines := fs.String("shard-consensus", "", "Per-shard engines, e.g. 0=pos,1=pbft")
+        basePort := fs.Int("base-port", defaults.Port, "P2P port of the first node")
+        baseAPIPort := fs.Int("base-api-port", defaults.APIPort, "API port of the first node")
+        balance := utils.AmountFlag(fs, "balance", utils.Coins(10000), "Genesis balance of every node")
+        stake := utils.AmountFlag(fs, "stake", defaults.MinStake, "Genesis validator stake of every node")
+        fs.Parse(args)
+
+        if *nodes <= 0 {
+                return errors.New("need at least one node")
+        }
+
+        genesis := &config.Genesis{
+                ChainID:                *chainID,
+                GenesisTime:            time.Now().Unix(),
+                ShardCount:             *shards,
+                LayerCount:             *layers,
+                ConsensusType:          *engine,
+                BlockTime:              defaults.BlockTime,
+                MinConfirmations:       defaults.MinConfirmations,
+                SlashFraction:          defaults.SlashFraction,
+                MinStake:               defaults.MinStake,
+                EpochLength:            defaults.EpochLength,
+                UnbondingPeriod:        defaults.UnbondingPeriod,
+                BlockReward:            defaults.BlockReward,
+                RelayRewardShare:       defaults.RelayRewardShare,
+                GasPrice:               defaults.GasPrice,
+                MaxContractGas:         defaults.MaxContractGas,
+                ChannelChallengePeriod: defaults.ChannelChallengePeriod,
+        }
+
+        shardTotal := *shards * *layers
+        if shardTotal <= 0 {
+                return errors.New("shards and layers must be positive")
+        }
+        engines, err := parseShardEngines(*shardEngines, shardTotal)
+        if err != nil {
+                return err
+        }
+
+        // Spread the nodes over the shards and fund them
+        nodeIDs := make([]string, *nodes)
+        keys := make([]string, *nodes)
+        members := make(map[int][]string)
+        for i := range nodeIDs {
+                nodeIDs[i] = fmt.Sprintf("node%d", i+1)
+                shardID := i % shardTotal
+                members[shardID] = append(members[shardID], nodeIDs[i])
+                privateKey, publicKey, err := utils.GenerateKeyPair()
+                if err != nil {
+                        return err
+                }
+                keys[i] = privateKey
+
+                genesis.Allocations = append(genesis.Allocations, config.Allocation{
+                        Address: nodeIDs[i], Amount: *balance, ShardID: shardID,
+                })
+                genesis.Validators = append(genesis.Validators, config.GenesisValidator{
+                        Address: nodeIDs[i], Stake: *stake, ShardID: shardID, PubKey: publicKey,
+                })
+        }
+
+        // Pin the engine of every shard that differs from the default, and
+        // give PBFT shards their committee
+        for shardID := 0; shardID < shardTotal; shardID++ {
+                shardEngine, pinned := engines[shardID]
+                if !pinned {
+                        shardEngine = *engine
+                }
+                spec := config.ShardSpec{ShardID: shardID, ConsensusType: shardEngine}
+                if consensus.ConsensusType(shardEngine) == consensus.PBFT {
+                        spec.ConsensusParams, err = json.Marshal(map[string]interface{}{"validators": members[shardID]})
+                        if err != nil {
+                                return err
+                        }
+                        pinned = true
+                }
+                if pinned {
+                        genesis.Shards = append(genesis.Shards, spec)
+                }
+        }
+
+        if err := genesis.Validate(); err != nil {
+                return err
+        }
+        if err := os.MkdirAll(*out, 0755); err != nil {
+                return err
+        }
+        if err := config.SaveGenesis(genesis, filepath.Join(*out, "genesis.json")); err != nil {
+                return err
+        }
+
+        specs := genesis.ShardSpecs()
+        for i, nodeID := range nodeIDs {
+                shardID := i % shardTotal
+                cfg := config.DefaultConfig()
+                cfg.NodeID = nodeID
+                cfg.ValidatorKey = keys[i]
+                cfg.GenesisFile = filepath.Join("..", "genesis.json")
+                cfg.Port = *basePort + i
+                cfg.APIPort = *baseAPIPort + i
+                cfg.ShardID = shardID
+                cfg.IsRelay = members[shardID][0] == nodeID
+                cfg.ConsensusType = specs[shardID].ConsensusType
+                cfg.DataDir = "data"
+                if i > 0 {
+                        cfg.BootstrapNodes = []string{fmt.Sprintf("127.0.0.1:%d", *basePort)}
+                }
+
+                // Check the node the way it checks itself at startup
+                check := *cfg
+                if err := check.ApplyGenesis(genesis); err != nil {
+                        return err
+                }
+                if err := consensus.ValidateConfig(&check); err != nil {
+                        return fmt.Errorf("%s: %w", nodeID, err)
+                }
+
+                dir := filepath.Join(*out, nodeID)
+                if err := os.MkdirAll(dir, 0755); err != nil {
+                        return err
+                }
+                if err := config.SaveConfig(cfg, filepath.Join(dir, "config.json")); err != nil {
+                        return err
+                }
+        }
+
+        hash, err := genesis.Hash()
+        if err != nil {
+                return err
+        }
+        fmt.Printf("Initialized %s: %d nodes, %d shards, genesis %s\n", genesis.ChainID, *nodes, shardTotal, hash)
+        fmt.Printf("Start a node with: lscc-node --config=%s\n", filepath.Join(*out, nodeIDs[0], "config.json"))
+        return nil
+}
+
+// parseShardEngines parses a list of shard=engine pairs
+func parseShardEngines(list string, shardTotal int) (map[int]string, error) {
+        engines := make(map[int]string)
+        if list == "" {
+                return engines, nil
+        }
+        for _, pair := range strings.Split(list, ",") {
+                parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+                if len(parts) != 2 {
+                        return nil, fmt.Errorf("invalid shard consensus %q, expected shard=engine", pair)
+                }
+                shardID, err := strconv.Atoi(parts[0])
+                if err != nil || shardID < 0 || shardID >= shardTotal {
+                        return nil, fmt.Errorf("invalid shard %q", parts[0])
+                }
+                engines[shardID] = parts[1]
+        }
+        return engines, nil
+}
@@ -0,0 +1,347 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Options selects where a node exports its spans. Spans are kept in memory
+// for lookup either way.
+type Options struct {
+	File         string `json:"file,omitempty"`          // OTLP/JSON lines, relative to the data directory unless absolute
+	CollectorURL string `json:"collector_url,omitempty"` // OTLP/HTTP endpoint spans are posted to, e.g. http://host:4318/v1/traces
+}
+
+// ScopeName is the instrumentation scope of exported spans
+const ScopeName = "lscc"
+
+// OTLP/JSON encoding of spans, as accepted by OpenTelemetry collectors on
+// /v1/traces. Timestamps are nanoseconds since the epoch in decimal strings.
+type (
+	otlpExport struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"` // int64 as a decimal string
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// Span kind and status codes of OTLP
+const (
+	otlpKindInternal = 1
+	otlpStatusOK     = 1
+	otlpStatusError  = 2
+)
+
+// serviceNameKey is the resource attribute holding a span's node
+const serviceNameKey = "service.instance.id"
+
+// EncodeOTLP encodes spans as an OTLP/JSON export request, grouping them by
+// the node that recorded them
+func EncodeOTLP(spans []Span) ([]byte, error) {
+	byService := make(map[string][]otlpSpan)
+	for _, span := range spans {
+		byService[span.Service] = append(byService[span.Service], toOTLP(span))
+	}
+
+	services := make([]string, 0, len(byService))
+	for service := range byService {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	export := otlpExport{ResourceSpans: make([]otlpResourceSpans, 0, len(services))}
+	for _, service := range services {
+		export.ResourceSpans = append(export.ResourceSpans, otlpResourceSpans{
+			Resource: otlpResource{Attributes: []otlpKeyValue{
+				{Key: "service.name", Value: stringValue("lscc-node")},
+				{Key: serviceNameKey, Value: stringValue(service)},
+			}},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: ScopeName},
+				Spans: byService[service],
+			}},
+		})
+	}
+	return json.Marshal(export)
+}
+
+// DecodeOTLP decodes an OTLP/JSON export request into spans
+func DecodeOTLP(data []byte) ([]Span, error) {
+	var export otlpExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("invalid OTLP request: %v", err)
+	}
+
+	var spans []Span
+	for _, rs := range export.ResourceSpans {
+		service := ""
+		for _, attr := range rs.Resource.Attributes {
+			if attr.Key == serviceNameKey {
+				service, _ = attr.Value.value().(string)
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				span, err := fromOTLP(s)
+				if err != nil {
+					return nil, err
+				}
+				span.Service = service
+				spans = append(spans, span)
+			}
+		}
+	}
+	return spans, nil
+}
+
+// toOTLP converts a span to its OTLP form
+func toOTLP(span Span) otlpSpan {
+	keys := make([]string, 0, len(span.Attributes))
+	for key := range span.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]otlpKeyValue, 0, len(keys))
+	for _, key := range keys {
+		attrs = append(attrs, otlpKeyValue{Key: key, Value: toValue(span.Attributes[key])})
+	}
+
+	status := otlpStatus{Code: otlpStatusOK}
+	if span.Error != "" {
+		status = otlpStatus{Code: otlpStatusError, Message: span.Error}
+	}
+
+	return otlpSpan{
+		TraceID:           span.TraceID,
+		SpanID:            span.SpanID,
+		ParentSpanID:      span.ParentSpanID,
+		Name:              span.Name,
+		Kind:              otlpKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		Attributes:        attrs,
+		Status:            status,
+	}
+}
+
+// fromOTLP converts a span from its OTLP form
+func fromOTLP(s otlpSpan) (Span, error) {
+	if s.TraceID == "" || s.SpanID == "" {
+		return Span{}, fmt.Errorf("span %q has no trace or span ID", s.Name)
+	}
+	start, err := strconv.ParseInt(s.StartTimeUnixNano, 10, 64)
+	if err != nil {
+		return Span{}, fmt.Errorf("span %q has an invalid start time", s.Name)
+	}
+	end, err := strconv.ParseInt(s.EndTimeUnixNano, 10, 64)
+	if err != nil {
+		return Span{}, fmt.Errorf("span %q has an invalid end time", s.Name)
+	}
+
+	span := Span{
+		TraceID:      s.TraceID,
+		SpanID:       s.SpanID,
+		ParentSpanID: s.ParentSpanID,
+		Name:         s.Name,
+		Start:        time.Unix(0, start),
+		End:          time.Unix(0, end),
+		Attributes:   make(map[string]interface{}, len(s.Attributes)),
+	}
+	for _, attr := range s.Attributes {
+		span.Attributes[attr.Key] = attr.Value.value()
+	}
+	if s.Status.Code == otlpStatusError {
+		span.Error = s.Status.Message
+		if span.Error == "" {
+			span.Error = "error"
+		}
+	}
+	return span, nil
+}
+
+// stringValue returns a string attribute value
+func stringValue(s string) otlpValue {
+	return otlpValue{StringValue: &s}
+}
+
+// toValue converts an attribute value to its OTLP form
+func toValue(v interface{}) otlpValue {
+	switch v := v.(type) {
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		s := strconv.FormatInt(int64(v), 10)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpValue{IntValue: &s}
+	case uint64:
+		s := strconv.FormatUint(v, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	default:
+		return stringValue(fmt.Sprint(v))
+	}
+}
+
+// value returns the Go value of an OTLP attribute value
+func (v otlpValue) value() interface{} {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.IntValue != nil:
+		if n, err := strconv.ParseInt(*v.IntValue, 10, 64); err == nil {
+			return n
+		}
+		return *v.IntValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.DoubleValue != nil:
+		return *v.DoubleValue
+	}
+	return nil
+}
+
+// FileExporter appends spans to a file, one OTLP/JSON export request per
+// line
+type FileExporter struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileExporter creates an exporter writing to a file, creating its
+// directory if needed
+func NewFileExporter(path string) (*FileExporter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return &FileExporter{path: path}, nil
+}
+
+// Export appends spans to the file
+func (e *FileExporter) Export(spans []Span) error {
+	line, err := EncodeOTLP(spans)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	file, err := os.OpenFile(e.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// ReadFile reads the spans of a file written by a FileExporter
+func ReadFile(path string) ([]Span, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var spans []Span
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		decoded, err := DecodeOTLP(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		spans = append(spans, decoded...)
+	}
+	return spans, scanner.Err()
+}
+
+// CollectorExporter posts spans to an OTLP/HTTP collector, or to another
+// node's /v1/traces endpoint standing in for one
+type CollectorExporter struct {
+	url    string
+	client *http.Client
+}
+
+// NewCollectorExporter creates an exporter posting to a collector URL
+func NewCollectorExporter(url string) *CollectorExporter {
+	return &CollectorExporter{
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Export posts spans to the collector
+func (e *CollectorExporter) Export(spans []Span) error {
+	body, err := EncodeOTLP(spans)
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector %s returned %s", e.url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package tracing
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testSpans returns spans of two nodes with attributes of every type
+func testSpans() []Span {
+	start := time.Unix(1700000000, 5)
+	return []Span{
+		{
+			TraceID: TraceID("tx1"), SpanID: "0102030405060708", Name: "submit", Service: "node1",
+			Start: start, End: start.Add(time.Millisecond),
+			Attributes: map[string]interface{}{"tx.hash": "tx1", "shard": int64(2), "ok": true, "fee": 0.5},
+		},
+		{
+			TraceID: TraceID("tx1"), SpanID: "1112131415161718", ParentSpanID: "0102030405060708",
+			Name: "apply", Service: "node2", Start: start, End: start,
+			Attributes: map[string]interface{}{}, Error: "insufficient balance",
+		},
+	}
+}
+
+func TestOTLPRoundTrip(t *testing.T) {
+	data, err := EncodeOTLP(testSpans())
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans, err := DecodeOTLP(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(spans, testSpans()) {
+		t.Errorf("decoded %+v\nwant %+v", spans, testSpans())
+	}
+
+	for _, bad := range []string{
+		`{"resourceSpans":`,
+		`{"resourceSpans":[{"scopeSpans":[{"spans":[{"name":"x","spanId":"01"}]}]}]}`,
+		`{"resourceSpans":[{"scopeSpans":[{"spans":[{"name":"x","traceId":"01","spanId":"01","startTimeUnixNano":"soon"}]}]}]}`,
+	} {
+		if _, err := DecodeOTLP([]byte(bad)); err == nil {
+			t.Errorf("decoded %s", bad)
+		}
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces", "spans.jsonl")
+	exporter, err := NewFileExporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans := testSpans()
+	for _, span := range spans {
+		if err := exporter.Export([]Span{span}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, spans) {
+		t.Errorf("read %+v\nwant %+v", read, spans)
+	}
+}
+
+func TestCollectorExporter(t *testing.T) {
+	var received []Span
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		spans, err := DecodeOTLP(body)
+		if err != nil || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = append(received, spans...)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	exporter := NewCollectorExporter(server.URL)
+	if err := exporter.Export(testSpans()); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 {
+		t.Errorf("collector received %d spans, want 2", len(received))
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := exporter.Export(testSpans()); err == nil {
+		t.Error("export succeeded although the collector failed")
+	}
+}
@@ -0,0 +1,350 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Tracing
+//
+// A transaction is followed across nodes and shards by a trace whose ID is
+// derived from the transaction hash, so every node that handles the
+// transaction, or a credit delivering it to another shard, records its spans
+// under the same trace without the ID travelling with the transaction. All
+// spans of a trace are children of a root span whose ID is derived from the
+// trace ID the same way. A credit that delivers a transaction in another
+// shard is linked to the transaction, so spans of the credit are recorded in
+// the transaction's trace. Spans are kept in memory for lookup and exported
+// in the OpenTelemetry (OTLP/JSON) format.
+
+// Span is a timed operation of a trace
+type Span struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Service      string // Node that recorded the span
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+	Error        string // Set if the operation failed
+}
+
+// Exporter sends finished spans to a file or a collector
+type Exporter interface {
+	Export(spans []Span) error
+}
+
+// Limits of a tracer
+const (
+	maxTraces  = 10000 // Traces kept in memory; the oldest are dropped first
+	maxLinks   = 10000 // Credits linked to their origin; the oldest are dropped first
+	maxPending = 8192  // Spans waiting for export; later ones are not exported
+)
+
+// Tracer records spans, keeps recent traces and exports spans periodically
+// to a file and a collector
+type Tracer struct {
+	service   string
+	file      Exporter
+	collector Exporter
+	traces    map[string][]Span
+	order     []string            // Trace IDs in the order they were first seen
+	links     map[string][]string // Origin transaction hashes by credit hash
+	linkOrder []string
+	pending   []pendingSpan
+	dropped   uint64 // Spans not exported because the queue was full
+	mu        sync.Mutex
+}
+
+// pendingSpan is a span waiting for export
+type pendingSpan struct {
+	span     Span
+	imported bool // Received from another node, so not sent to the collector
+}
+
+var (
+	defaultTracer *Tracer
+	defaultOnce   sync.Once
+)
+
+// Default returns the tracer shared by a process's subsystems
+func Default() *Tracer {
+	defaultOnce.Do(func() {
+		defaultTracer = NewTracer("")
+	})
+	return defaultTracer
+}
+
+// NewTracer creates a tracer recording spans for a service
+func NewTracer(service string) *Tracer {
+	return &Tracer{
+		service: service,
+		traces:  make(map[string][]Span),
+		links:   make(map[string][]string),
+	}
+}
+
+// Configure sets the service spans are recorded for and where they are
+// exported; either exporter may be nil
+func (t *Tracer) Configure(service string, file, collector Exporter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.service = service
+	t.file = file
+	t.collector = collector
+}
+
+// TraceID returns the ID of the trace of a transaction: the first 16 bytes
+// of its hash, or of the hash of the hash if it is not hex
+func TraceID(txHash string) string {
+	if len(txHash) >= 32 {
+		if _, err := hex.DecodeString(txHash[:32]); err == nil {
+			return txHash[:32]
+		}
+	}
+	sum := sha256.Sum256([]byte(txHash))
+	return hex.EncodeToString(sum[:16])
+}
+
+// rootSpanID returns the ID of a trace's root span
+func rootSpanID(traceID string) string {
+	sum := sha256.Sum256([]byte("root:" + traceID))
+	return hex.EncodeToString(sum[:8])
+}
+
+// newSpanID returns a random span ID
+func newSpanID() string {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		sum := sha256.Sum256([]byte(time.Now().String()))
+		copy(id[:], sum[:])
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// ActiveSpan is a span being timed
+type ActiveSpan struct {
+	tracer *Tracer
+	span   Span
+}
+
+// StartSpan starts a child of the root span of a transaction's trace.
+// Attributes are given as key-value pairs.
+func (t *Tracer) StartSpan(txHash, name string, keyvals ...interface{}) *ActiveSpan {
+	traceID := TraceID(txHash)
+	return &ActiveSpan{tracer: t, span: Span{
+		TraceID:      traceID,
+		SpanID:       newSpanID(),
+		ParentSpanID: rootSpanID(traceID),
+		Name:         name,
+		Start:        time.Now(),
+		Attributes:   attributes(append([]interface{}{"tx.hash", txHash}, keyvals...)),
+	}}
+}
+
+// StartRoot starts the root span of a transaction's trace, recorded where
+// the transaction is submitted
+func (t *Tracer) StartRoot(txHash, name string, keyvals ...interface{}) *ActiveSpan {
+	span := t.StartSpan(txHash, name, keyvals...)
+	span.span.SpanID = span.span.ParentSpanID
+	span.span.ParentSpanID = ""
+	return span
+}
+
+// Link records that a transaction, such as a credit created in a target
+// shard, delivers the given origin transactions
+func (t *Tracer) Link(txHash string, origins ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, exists := t.links[txHash]; !exists {
+		if len(t.linkOrder) >= maxLinks {
+			delete(t.links, t.linkOrder[0])
+			t.linkOrder = t.linkOrder[1:]
+		}
+		t.linkOrder = append(t.linkOrder, txHash)
+	}
+	t.links[txHash] = append(t.links[txHash], origins...)
+}
+
+// Origins returns the transactions whose traces a transaction's spans
+// belong to: those it was linked to, or else the transaction itself
+func (t *Tracer) Origins(txHash string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if origins, linked := t.links[txHash]; linked {
+		return append([]string(nil), origins...)
+	}
+	return []string{txHash}
+}
+
+// Event records a span without duration in a transaction's trace
+func (t *Tracer) Event(txHash, name string, keyvals ...interface{}) {
+	t.StartSpan(txHash, name, keyvals...).End()
+}
+
+// SetAttributes adds key-value attributes to a span
+func (s *ActiveSpan) SetAttributes(keyvals ...interface{}) {
+	for key, value := range attributes(keyvals) {
+		s.span.Attributes[key] = value
+	}
+}
+
+// Fail marks a span as failed
+func (s *ActiveSpan) Fail(err error) {
+	if err != nil {
+		s.span.Error = err.Error()
+	}
+}
+
+// End finishes a span and records it
+func (s *ActiveSpan) End() {
+	s.span.End = time.Now()
+	s.tracer.record(s.span, false)
+}
+
+// attributes turns key-value pairs into attributes
+func attributes(keyvals []interface{}) map[string]interface{} {
+	attrs := make(map[string]interface{}, len(keyvals)/2)
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		switch v := keyvals[i+1].(type) {
+		case string, bool, int, int64, uint64, float64:
+			attrs[key] = v
+		case int32:
+			attrs[key] = int64(v)
+		case uint32:
+			attrs[key] = uint64(v)
+		case error:
+			attrs[key] = v.Error()
+		default:
+			attrs[key] = fmt.Sprint(v)
+		}
+	}
+	return attrs
+}
+
+// record stores a span and queues it for export
+func (t *Tracer) record(span Span, imported bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if span.Service == "" {
+		span.Service = t.service
+	}
+	if _, exists := t.traces[span.TraceID]; !exists {
+		if len(t.order) >= maxTraces {
+			delete(t.traces, t.order[0])
+			t.order = t.order[1:]
+		}
+		t.order = append(t.order, span.TraceID)
+	}
+	t.traces[span.TraceID] = append(t.traces[span.TraceID], span)
+
+	if t.file == nil && (t.collector == nil || imported) {
+		return
+	}
+	if len(t.pending) >= maxPending {
+		t.dropped++
+		return
+	}
+	t.pending = append(t.pending, pendingSpan{span: span, imported: imported})
+}
+
+// Import stores spans recorded by other nodes, as a collector does. They
+// are written to the trace file but not sent on to a collector.
+func (t *Tracer) Import(spans []Span) {
+	for _, span := range spans {
+		t.record(span, true)
+	}
+}
+
+// Trace returns the spans of a transaction's trace, ordered by start time
+func (t *Tracer) Trace(txHash string) []Span {
+	t.mu.Lock()
+	spans := append([]Span(nil), t.traces[TraceID(txHash)]...)
+	t.mu.Unlock()
+
+	SortSpans(spans)
+	return spans
+}
+
+// SortSpans orders spans by start time, root spans first among equals
+func SortSpans(spans []Span) {
+	sort.SliceStable(spans, func(i, j int) bool {
+		if !spans[i].Start.Equal(spans[j].Start) {
+			return spans[i].Start.Before(spans[j].Start)
+		}
+		return spans[i].ParentSpanID == "" && spans[j].ParentSpanID != ""
+	})
+}
+
+// Flush exports the queued spans
+func (t *Tracer) Flush() error {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = nil
+	file, collector := t.file, t.collector
+	t.mu.Unlock()
+
+	var all, own []Span
+	for _, p := range pending {
+		all = append(all, p.span)
+		if !p.imported {
+			own = append(own, p.span)
+		}
+	}
+
+	var firstErr error
+	if file != nil && len(all) > 0 {
+		firstErr = file.Export(all)
+	}
+	if collector != nil && len(own) > 0 {
+		if err := collector.Export(own); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Run flushes queued spans at every interval until stop is closed, then
+// flushes once more. Export errors are passed to onError.
+func (t *Tracer) Run(stop <-chan struct{}, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			if err := t.Flush(); err != nil {
+				onError(err)
+			}
+			return
+		case <-ticker.C:
+			if err := t.Flush(); err != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// Stats returns the traces kept, the spans waiting for export and those
+// dropped because the export queue was full
+func (t *Tracer) Stats() map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return map[string]interface{}{
+		"traces":        len(t.traces),
+		"pending_spans": len(t.pending),
+		"dropped_spans": t.dropped,
+		"file":          t.file != nil,
+		"collector":     t.collector != nil,
+	}
+}
@@ -0,0 +1,115 @@
+package tracing
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// recorder is an exporter keeping the spans it is given
+type recorder struct {
+	spans []Span
+	err   error
+}
+
+func (r *recorder) Export(spans []Span) error {
+	r.spans = append(r.spans, spans...)
+	return r.err
+}
+
+func TestTraceID(t *testing.T) {
+	hexHash := strings.Repeat("ab", 32)
+	if got := TraceID(hexHash); got != hexHash[:32] {
+		t.Errorf("trace of hex hash = %s, want its first 16 bytes", got)
+	}
+	for _, hash := range []string{"tx1", strings.Repeat("z", 64)} {
+		got := TraceID(hash)
+		if len(got) != 32 || got != TraceID(hash) {
+			t.Errorf("trace of %q = %q, want a stable 16-byte hex ID", hash, got)
+		}
+	}
+	if TraceID("tx1") == TraceID("tx2") {
+		t.Error("transactions share a trace")
+	}
+}
+
+func TestSpansShareTheTransactionTrace(t *testing.T) {
+	tracer := NewTracer("node1")
+	root := tracer.StartRoot("tx1", "submit", "shard", 0)
+	root.End()
+	child := tracer.StartSpan("tx1", "validate", "size", int32(3))
+	child.SetAttributes("valid", false)
+	child.Fail(errors.New("bad signature"))
+	child.End()
+	tracer.Event("tx2", "pool")
+
+	spans := tracer.Trace("tx1")
+	if len(spans) != 2 {
+		t.Fatalf("trace of tx1 has %d spans, want 2", len(spans))
+	}
+	if spans[0].Name != "submit" || spans[0].ParentSpanID != "" || spans[0].Service != "node1" {
+		t.Errorf("root span = %+v", spans[0])
+	}
+	got := spans[1]
+	if got.ParentSpanID != spans[0].SpanID || got.TraceID != spans[0].TraceID {
+		t.Errorf("span %s is not a child of the root span", got.Name)
+	}
+	if got.Error != "bad signature" || got.Attributes["tx.hash"] != "tx1" ||
+		got.Attributes["size"] != int64(3) || got.Attributes["valid"] != false {
+		t.Errorf("child span = %+v", got)
+	}
+
+	// Spans recorded on another node join the same trace under the same root
+	other := NewTracer("node2").StartSpan("tx1", "apply")
+	if other.span.ParentSpanID != spans[0].SpanID {
+		t.Error("another node's span has a different root")
+	}
+	if stats := tracer.Stats(); stats["traces"] != 2 || stats["pending_spans"] != 0 {
+		t.Errorf("stats = %v, want 2 traces and nothing queued without exporters", stats)
+	}
+}
+
+func TestLinkedCreditsJoinOriginTraces(t *testing.T) {
+	tracer := NewTracer("node1")
+	if origins := tracer.Origins("credit"); len(origins) != 1 || origins[0] != "credit" {
+		t.Errorf("origins of an unlinked transaction = %v", origins)
+	}
+	tracer.Link("credit", "tx1")
+	tracer.Link("credit", "tx2")
+	origins := tracer.Origins("credit")
+	if len(origins) != 2 || origins[0] != "tx1" || origins[1] != "tx2" {
+		t.Errorf("origins = %v, want [tx1 tx2]", origins)
+	}
+	origins[0] = "changed"
+	if tracer.Origins("credit")[0] != "tx1" {
+		t.Error("origins share the tracer's links")
+	}
+}
+
+func TestFlushSendsOwnSpansToTheCollector(t *testing.T) {
+	tracer := NewTracer("")
+	file, collector := &recorder{}, &recorder{err: errors.New("collector down")}
+	tracer.Configure("node1", file, collector)
+
+	tracer.Event("tx1", "submit")
+	tracer.Import([]Span{{TraceID: TraceID("tx1"), SpanID: "0102030405060708", Name: "apply", Service: "node2"}})
+	if stats := tracer.Stats(); stats["pending_spans"] != 2 {
+		t.Errorf("%v spans queued, want 2", stats["pending_spans"])
+	}
+
+	if err := tracer.Flush(); err == nil || err.Error() != "collector down" {
+		t.Errorf("flush error = %v, want the collector's", err)
+	}
+	if len(file.spans) != 2 {
+		t.Errorf("file received %d spans, want 2", len(file.spans))
+	}
+	if len(collector.spans) != 1 || collector.spans[0].Service != "node1" {
+		t.Errorf("collector received %+v, want the node's own span", collector.spans)
+	}
+	if len(tracer.Trace("tx1")) != 2 {
+		t.Error("imported span missing from the trace")
+	}
+	if err := tracer.Flush(); err != nil || len(file.spans) != 2 {
+		t.Errorf("second flush exported again: %v", err)
+	}
+}
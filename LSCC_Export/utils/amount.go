@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Amount is a token amount as an integer number of base units. One coin is
+// Coin base units; nothing smaller than one base unit can be represented.
+//
+// In JSON an amount is a decimal string in coins, e.g. "10.5". Plain JSON
+// numbers are accepted on input and parsed from their decimal text, never
+// through float64.
+type Amount int64
+
+// AmountDecimals is the number of decimal places of an amount in coins
+const AmountDecimals = 8
+
+// Coin is the number of base units in one coin
+const Coin Amount = 100000000
+
+// MaxAmount is the largest representable amount
+const MaxAmount Amount = math.MaxInt64
+
+// ErrAmountOverflow is returned when amount arithmetic overflows
+var ErrAmountOverflow = errors.New("amount overflow")
+
+// Coins returns an amount of whole coins. It is meant for constants and
+// panics on overflow.
+func Coins(n int64) Amount {
+	amount, err := Amount(n).MulDiv(int64(Coin), 1)
+	if err != nil {
+		panic(err)
+	}
+	return amount
+}
+
+// ParseAmount parses a decimal amount in coins, such as "10", "0.5" or
+// "-3.25". Amounts with more than AmountDecimals decimal places are rejected
+// rather than rounded, as they carry dust below one base unit.
+func ParseAmount(s string) (Amount, error) {
+	text := strings.TrimSpace(s)
+	negative := strings.HasPrefix(text, "-")
+	text = strings.TrimPrefix(text, "-")
+
+	whole, frac, hasPoint := strings.Cut(text, ".")
+	if whole == "" || (hasPoint && frac == "") || strings.HasPrefix(whole, "+") {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if len(frac) > AmountDecimals {
+		return 0, fmt.Errorf("amount %q has more than %d decimal places", s, AmountDecimals)
+	}
+	for _, digits := range []string{whole, frac} {
+		for _, c := range digits {
+			if c < '0' || c > '9' {
+				return 0, fmt.Errorf("invalid amount %q", s)
+			}
+		}
+	}
+
+	// Parsed with its sign, so the most negative amount fits
+	digits := whole + frac + strings.Repeat("0", AmountDecimals-len(frac))
+	if negative {
+		digits = "-" + digits
+	}
+	units, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("amount %q is out of range", s)
+	}
+	return Amount(units), nil
+}
+
+// MustParseAmount parses an amount and panics if it is invalid. It is
+// meant for constants.
+func MustParseAmount(s string) Amount {
+	amount, err := ParseAmount(s)
+	if err != nil {
+		panic(err)
+	}
+	return amount
+}
+
+// String formats the amount in coins, without trailing zeros
+func (a Amount) String() string {
+	sign := ""
+	units := uint64(a)
+	if a < 0 {
+		sign = "-"
+		units = uint64(-(a + 1)) + 1
+	}
+
+	whole := units / uint64(Coin)
+	frac := units % uint64(Coin)
+	if frac == 0 {
+		return fmt.Sprintf("%s%d", sign, whole)
+	}
+	fracText := strings.TrimRight(fmt.Sprintf("%0*d", AmountDecimals, frac), "0")
+	return fmt.Sprintf("%s%d.%s", sign, whole, fracText)
+}
+
+// Coins returns the amount in coins as a float, for display and statistics
+// only
+func (a Amount) Coins() float64 {
+	return float64(a) / float64(Coin)
+}
+
+// Add returns a + b, failing on overflow
+func (a Amount) Add(b Amount) (Amount, error) {
+	sum := a + b
+	if (b > 0 && sum < a) || (b < 0 && sum > a) {
+		return 0, fmt.Errorf("%w: %s + %s", ErrAmountOverflow, a, b)
+	}
+	return sum, nil
+}
+
+// Sub returns a - b, failing on overflow
+func (a Amount) Sub(b Amount) (Amount, error) {
+	diff := a - b
+	if (b > 0 && diff > a) || (b < 0 && diff < a) {
+		return 0, fmt.Errorf("%w: %s - %s", ErrAmountOverflow, a, b)
+	}
+	return diff, nil
+}
+
+// MulDiv returns a * num / den rounded towards zero, failing on overflow.
+// It scales amounts by exact ratios such as shares and fractions.
+func (a Amount) MulDiv(num, den int64) (Amount, error) {
+	if den == 0 {
+		return 0, errors.New("amount division by zero")
+	}
+	result := new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(num))
+	result.Quo(result, big.NewInt(den))
+	if !result.IsInt64() {
+		return 0, fmt.Errorf("%w: %s * %d / %d", ErrAmountOverflow, a, num, den)
+	}
+	return Amount(result.Int64()), nil
+}
+
+// Fraction scales the amount by a fraction between 0 and 1, given to the
+// precision of a basis point, rounding down
+func (a Amount) Fraction(fraction float64) (Amount, error) {
+	return a.MulDiv(int64(math.Round(fraction*10000)), 10000)
+}
+
+// SumAmounts adds amounts, failing on overflow
+func SumAmounts(amounts ...Amount) (Amount, error) {
+	var total Amount
+	for _, amount := range amounts {
+		var err error
+		if total, err = total.Add(amount); err != nil {
+			return 0, err
+		}
+	}
+	return total, nil
+}
+
+// Set parses a decimal amount in coins, so an *Amount can be used as a
+// flag.Value
+func (a *Amount) Set(s string) error {
+	amount, err := ParseAmount(s)
+	if err != nil {
+		return err
+	}
+	*a = amount
+	return nil
+}
+
+// AmountFlag defines an amount flag with the given default
+func AmountFlag(fs *flag.FlagSet, name string, value Amount, usage string) *Amount {
+	amount := value
+	fs.Var(&amount, name, usage)
+	return &amount
+}
+
+// MarshalJSON encodes the amount as a decimal string in coins
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(a.String())), nil
+}
+
+// UnmarshalJSON decodes a decimal string or number in coins. Numbers are
+// converted exactly, so one with dust below a base unit is rejected.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if text == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(text); err == nil {
+		return a.Set(unquoted)
+	}
+
+	number, ok := new(big.Rat).SetString(text)
+	if !ok {
+		return fmt.Errorf("invalid amount %s", text)
+	}
+	units := number.Mul(number, new(big.Rat).SetInt64(int64(Coin)))
+	if !units.IsInt() {
+		return fmt.Errorf("amount %s has more than %d decimal places", text, AmountDecimals)
+	}
+	if !units.Num().IsInt64() {
+		return fmt.Errorf("amount %s is out of range", text)
+	}
+	*a = Amount(units.Num().Int64())
+	return nil
+}
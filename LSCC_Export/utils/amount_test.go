@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		text string
+		want Amount
+	}{
+		{"0", 0},
+		{"10", Coins(10)},
+		{"0.5", Coin / 2},
+		{"-3.25", -(Coins(3) + Coin/4)},
+		{" 1.2 ", MustParseAmount("1.2")},
+		{"0.00000001", 1},
+		{"-0.00000001", -1},
+		{"1.10000000", 110000000},
+		{"007", Coins(7)},
+		{"92233720368.54775807", MaxAmount},
+		{"-92233720368.54775808", math.MinInt64},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.text)
+		if err != nil {
+			t.Errorf("ParseAmount(%q): %v", tt.text, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAmount(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestParseAmountRejects(t *testing.T) {
+	tests := []string{
+		"",
+		"-",
+		"abc",
+		"1.",
+		".5",
+		"+1",
+		"--1",
+		"1.2.3",
+		"1e8",
+		"0x10",
+		"1,5",
+		// More than 8 decimal places is dust below a base unit
+		"0.000000001",
+		"1.123456789",
+		"-0.000000015",
+		// Beyond int64 base units
+		"92233720368.54775808",
+		"-92233720368.54775809",
+		"100000000000",
+	}
+	for _, text := range tests {
+		if got, err := ParseAmount(text); err == nil {
+			t.Errorf("ParseAmount(%q) = %d, want an error", text, got)
+		}
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	tests := []struct {
+		amount Amount
+		want   string
+	}{
+		{0, "0"},
+		{1, "0.00000001"},
+		{-1, "-0.00000001"},
+		{Coins(10), "10"},
+		{Coin / 2, "0.5"},
+		{-(Coins(3) + Coin/4), "-3.25"},
+		{MaxAmount, "92233720368.54775807"},
+		{math.MinInt64, "-92233720368.54775808"},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.String(); got != tt.want {
+			t.Errorf("Amount(%d).String() = %q, want %q", int64(tt.amount), got, tt.want)
+		}
+		// Every amount survives a round trip through its text
+		parsed, err := ParseAmount(tt.want)
+		if err != nil || parsed != tt.amount {
+			t.Errorf("ParseAmount(%q) = %d, %v; want %d", tt.want, parsed, err, tt.amount)
+		}
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	data, err := json.Marshal(struct {
+		Amount Amount `json:"amount"`
+	}{MustParseAmount("10.5")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"amount":"10.5"}` {
+		t.Errorf("encoded as %s, want a decimal string", data)
+	}
+
+	tests := []struct {
+		json string
+		want Amount
+	}{
+		// Strings and numbers decode to the same amount
+		{`"10.5"`, MustParseAmount("10.5")},
+		{`10.5`, MustParseAmount("10.5")},
+		{`"0.00000001"`, 1},
+		{`0.00000001`, 1},
+		{`1e-8`, 1},
+		{`2E2`, Coins(200)},
+		{`-3.25`, MustParseAmount("-3.25")},
+		{`"-3.25"`, MustParseAmount("-3.25")},
+		// Numbers are converted exactly, not through float64, which
+		// would round this one
+		{`92233720368.54775807`, MaxAmount},
+		{`"92233720368.54775807"`, MaxAmount},
+	}
+	for _, tt := range tests {
+		var got Amount
+		if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+			t.Errorf("Unmarshal(%s): %v", tt.json, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Unmarshal(%s) = %d, want %d", tt.json, got, tt.want)
+		}
+	}
+
+	// null leaves the amount alone
+	kept := Coins(1)
+	if err := json.Unmarshal([]byte(`null`), &kept); err != nil || kept != Coins(1) {
+		t.Errorf("Unmarshal(null) = %d, %v", kept, err)
+	}
+
+	for _, input := range []string{
+		`0.000000001`,
+		`"0.000000001"`,
+		`1e-9`,
+		`92233720368.54775808`,
+		`"92233720368.54775808"`,
+		`1e20`,
+		`"abc"`,
+		`true`,
+		`[1]`,
+	} {
+		var got Amount
+		if err := json.Unmarshal([]byte(input), &got); err == nil {
+			t.Errorf("Unmarshal(%s) = %d, want an error", input, got)
+		}
+	}
+}
+
+func TestAmountArithmetic(t *testing.T) {
+	if sum, err := Coins(1).Add(Coins(2)); err != nil || sum != Coins(3) {
+		t.Errorf("1 + 2 = %s, %v", sum, err)
+	}
+	overflows := []struct {
+		name string
+		err  error
+	}{
+		{"max + 1", func() error { _, err := MaxAmount.Add(1); return err }()},
+		{"min + -1", func() error { _, err := Amount(math.MinInt64).Add(-1); return err }()},
+		{"min - 1", func() error { _, err := Amount(math.MinInt64).Sub(1); return err }()},
+		{"max - -1", func() error { _, err := MaxAmount.Sub(-1); return err }()},
+		{"max * 2", func() error { _, err := MaxAmount.MulDiv(2, 1); return err }()},
+		{"sum", func() error { _, err := SumAmounts(MaxAmount, 1); return err }()},
+	}
+	for _, tt := range overflows {
+		if !errors.Is(tt.err, ErrAmountOverflow) {
+			t.Errorf("%s: err = %v, want %v", tt.name, tt.err, ErrAmountOverflow)
+		}
+	}
+
+	// MulDiv works past int64 in between, rounding towards zero
+	if got, err := MaxAmount.MulDiv(3, 4); err != nil || got != Amount(6917529027641081855) {
+		t.Errorf("max * 3 / 4 = %d, %v", got, err)
+	}
+	if got, err := Amount(-7).MulDiv(1, 2); err != nil || got != -3 {
+		t.Errorf("-7 / 2 = %d, %v", got, err)
+	}
+	if _, err := Coins(1).MulDiv(1, 0); err == nil {
+		t.Error("division by zero succeeded")
+	}
+	if got, err := Coins(1).Fraction(0.2); err != nil || got != Coin/5 {
+		t.Errorf("0.2 of 1 = %s, %v", got, err)
+	}
+}
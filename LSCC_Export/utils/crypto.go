@@ -1,9 +1,11 @@
 package utils
 
 import (
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 )
 
@@ -17,26 +19,14 @@ func GenerateNodeID() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
-// GenerateKeyPair generates a key pair for signing transactions and blocks
-// Note: This is a simplified version. In a real implementation, use proper crypto libraries.
+// GenerateKeyPair generates an ed25519 key pair, hex encoded, for signing
+// with SignWithKey
 func GenerateKeyPair() (string, string, error) {
-	// Generate private key
-	privKeyBytes := make([]byte, 32)
-	_, err := rand.Read(privKeyBytes)
-	if err != nil {
-		return "", "", err
-	}
-	privateKey := hex.EncodeToString(privKeyBytes)
-	
-	// Generate public key (in a real system this would derive from private key)
-	pubKeyBytes := make([]byte, 32)
-	_, err = rand.Read(pubKeyBytes)
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
 		return "", "", err
 	}
-	publicKey := hex.EncodeToString(pubKeyBytes)
-	
-	return privateKey, publicKey, nil
+	return hex.EncodeToString(privateKey), hex.EncodeToString(publicKey), nil
 }
 
 // Hash computes the SHA-256 hash of the input data
@@ -51,7 +41,11 @@ func Hash(data []byte) string {
 func Sign(data []byte, privateKey string) (string, error) {
 	// Placeholder for actual signature logic
 	hash := Hash(data)
-	signature := fmt.Sprintf("signed:%s:%s", privateKey[:8], hash[:16])
+	keyPrefix := privateKey
+	if len(keyPrefix) > 8 {
+		keyPrefix = keyPrefix[:8]
+	}
+	signature := fmt.Sprintf("signed:%s:%s", keyPrefix, hash[:16])
 	return signature, nil
 }
 
@@ -62,6 +56,36 @@ func VerifySignature(data []byte, signature string, publicKey string) bool {
 	return len(signature) > 0
 }
 
+// SignWithKey signs data with a hex encoded ed25519 private key
+func SignWithKey(data []byte, privateKey string) (string, error) {
+	key, err := hex.DecodeString(privateKey)
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		return "", errors.New("invalid ed25519 private key")
+	}
+	return hex.EncodeToString(ed25519.Sign(ed25519.PrivateKey(key), data)), nil
+}
+
+// VerifyKeySignature verifies a SignWithKey signature against data and a
+// hex encoded ed25519 public key
+func VerifyKeySignature(data []byte, signature string, publicKey string) bool {
+	key, err := hex.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return false
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(key), data, sig)
+}
+
+// ValidPublicKey reports whether a string is a hex encoded ed25519 public
+// key
+func ValidPublicKey(publicKey string) bool {
+	key, err := hex.DecodeString(publicKey)
+	return err == nil && len(key) == ed25519.PublicKeySize
+}
+
 // GenerateRandomHex generates a random hex string of the specified length
 func GenerateRandomHex(length int) (string, error) {
 	bytes := make([]byte, length/2)
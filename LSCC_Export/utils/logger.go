@@ -1,14 +1,28 @@
 package utils
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+// Logging
+//
+// Every subsystem logs through a Logger named after it. A message carries
+// key-value fields, which are written as key=value pairs in the text format
+// and as object members in the JSON format. Each subsystem can have its own
+// level; subsystems without one use the default level. Levels can be changed
+// while the node runs.
+
 // LogLevel is the verbosity level for logging
 type LogLevel int
 
@@ -25,11 +39,74 @@ const (
 	LogLevelTrace
 )
 
-// Logger is a simple logging utility
+// levelNames are the names of the levels, indexed by level
+var levelNames = []string{"error", "warn", "info", "debug", "trace"}
+
+// String returns the name of a level
+func (l LogLevel) String() string {
+	if l < LogLevelError || l > LogLevelTrace {
+		return strconv.Itoa(int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLogLevel parses a level name, or a verbosity from 0 (error) to 4
+// (trace)
+func ParseLogLevel(s string) (LogLevel, error) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	for i, levelName := range levelNames {
+		if name == levelName {
+			return LogLevel(i), nil
+		}
+	}
+	if name == "warning" {
+		return LogLevelWarn, nil
+	}
+	if n, err := strconv.Atoi(name); err == nil && n >= int(LogLevelError) && n <= int(LogLevelTrace) {
+		return LogLevel(n), nil
+	}
+	return LogLevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// Log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LogOptions configure logging
+type LogOptions struct {
+	Level      string            `json:"level,omitempty"`       // Default level: error, warn, info, debug or trace
+	Format     string            `json:"format,omitempty"`      // text or json
+	Subsystems map[string]string `json:"subsystems,omitempty"`  // Levels of single subsystems
+	File       string            `json:"file,omitempty"`        // Log file, relative to the data directory; none if empty
+	MaxSizeMB  int               `json:"max_size_mb,omitempty"` // Size at which the log file is rotated, 100 MB if zero
+	MaxBackups int               `json:"max_backups,omitempty"` // Rotated log files kept
+}
+
+// Defaults for log file rotation
+const (
+	defaultLogMaxSizeMB  = 100
+	defaultLogMaxBackups = 5
+)
+
+// logSink is the output and the levels shared by all loggers
+type logSink struct {
+	out        io.Writer
+	console    io.Writer // Where logs go besides the log file
+	file       *rotatingFile
+	format     string
+	level      LogLevel
+	levels     map[string]LogLevel // Levels set for single subsystems
+	subsystems map[string]bool     // Subsystems that have a logger
+	mu         sync.RWMutex
+}
+
+// Logger writes leveled, structured messages for a subsystem
 type Logger struct {
-	level  LogLevel
-	logger *log.Logger
-	mu     sync.Mutex
+	sink      *logSink
+	subsystem string
+	fields    []interface{} // Fields added to every message
 }
 
 var (
@@ -37,20 +114,24 @@ var (
 	once     sync.Once
 )
 
-// GetLogger returns the singleton logger instance
+// GetLogger returns the root logger, for messages not tied to a subsystem
 func GetLogger() *Logger {
 	once.Do(func() {
-		instance = &Logger{
-			level:  LogLevelInfo,
-			logger: log.New(os.Stdout, "", log.LstdFlags),
-		}
+		instance = &Logger{sink: &logSink{
+			out:        os.Stdout,
+			console:    os.Stdout,
+			format:     LogFormatText,
+			level:      LogLevelInfo,
+			levels:     make(map[string]LogLevel),
+			subsystems: make(map[string]bool),
+		}}
 	})
 	return instance
 }
 
-// InitLogger initializes the logger with the specified level
+// InitLogger sets the default level from a verbosity from 0 (error) to 4
+// (trace); verbosities out of range are clamped
 func InitLogger(verbosity int) {
-	logger := GetLogger()
 	level := LogLevel(verbosity)
 	if level < LogLevelError {
 		level = LogLevelError
@@ -58,80 +139,387 @@ func InitLogger(verbosity int) {
 	if level > LogLevelTrace {
 		level = LogLevelTrace
 	}
-	logger.level = level
+	GetLogger().SetLevel("", level)
 }
 
-// formatLog formats a log message with additional context
-func (l *Logger) formatLog(level, msg string, keyvals ...interface{}) string {
-	// Get caller info
-	_, file, line, ok := runtime.Caller(2)
-	caller := "unknown"
-	if ok {
-		// Extract just the filename, not the full path
-		for i := len(file) - 1; i >= 0; i-- {
-			if file[i] == '/' {
-				file = file[i+1:]
-				break
-			}
+// SetLogOutput sets where logs go besides the log file, standard output
+// unless set
+func SetLogOutput(w io.Writer) {
+	sink := GetLogger().sink
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	sink.console = w
+	sink.out = w
+	if sink.file != nil {
+		sink.out = io.MultiWriter(w, sink.file)
+	}
+}
+
+// ConfigureLogging applies logging options. A log file is opened under
+// dataDir and replaces any file opened before.
+func ConfigureLogging(opts LogOptions, dataDir string) error {
+	level := LogLevelInfo
+	if opts.Level != "" {
+		parsed, err := ParseLogLevel(opts.Level)
+		if err != nil {
+			return err
 		}
-		caller = fmt.Sprintf("%s:%d", file, line)
+		level = parsed
 	}
-	
-	timestamp := time.Now().Format("2006-01-02 15:04:05.000")
-	logMsg := fmt.Sprintf("[%s] [%s] [%s] %s", timestamp, level, caller, msg)
-	
-	// Add key-value pairs if any
-	if len(keyvals) > 0 {
-		if len(keyvals)%2 != 0 {
-			// Ensure even number of elements by adding empty string
-			keyvals = append(keyvals, "")
+	levels := make(map[string]LogLevel, len(opts.Subsystems))
+	for subsystem, name := range opts.Subsystems {
+		parsed, err := ParseLogLevel(name)
+		if err != nil {
+			return fmt.Errorf("subsystem %s: %w", subsystem, err)
 		}
-		
-		for i := 0; i < len(keyvals); i += 2 {
-			key, val := keyvals[i], keyvals[i+1]
-			logMsg += fmt.Sprintf(" %v=%v", key, val)
+		levels[subsystem] = parsed
+	}
+	format := opts.Format
+	if format == "" {
+		format = LogFormatText
+	}
+	if format != LogFormatText && format != LogFormatJSON {
+		return fmt.Errorf("unknown log format %q", opts.Format)
+	}
+
+	var file *rotatingFile
+	if opts.File != "" {
+		maxSize := opts.MaxSizeMB
+		if maxSize <= 0 {
+			maxSize = defaultLogMaxSizeMB
+		}
+		maxBackups := opts.MaxBackups
+		if maxBackups <= 0 {
+			maxBackups = defaultLogMaxBackups
+		}
+		path := opts.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dataDir, path)
+		}
+		var err error
+		if file, err = openRotatingFile(path, int64(maxSize)<<20, maxBackups); err != nil {
+			return err
 		}
 	}
-	
-	return logMsg
+
+	sink := GetLogger().sink
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+
+	if sink.file != nil {
+		sink.file.Close()
+	}
+	sink.file = file
+	sink.out = sink.console
+	if file != nil {
+		sink.out = io.MultiWriter(sink.console, file)
+	}
+	sink.format = format
+	sink.level = level
+	sink.levels = levels
+	return nil
+}
+
+// Named returns the logger of a subsystem
+func (l *Logger) Named(subsystem string) *Logger {
+	l.sink.mu.Lock()
+	l.sink.subsystems[subsystem] = true
+	l.sink.mu.Unlock()
+	return &Logger{sink: l.sink, subsystem: subsystem, fields: l.fields}
+}
+
+// With returns a logger that adds key-value fields to every message
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(append(fields, l.fields...), keyvals...)
+	return &Logger{sink: l.sink, subsystem: l.subsystem, fields: fields}
 }
 
-// log logs a message with the given level
-func (l *Logger) log(logLevel LogLevel, levelName, msg string, keyvals ...interface{}) {
-	if logLevel > l.level {
+// SetLevel sets the level of a subsystem, or the default level if the
+// subsystem is empty
+func (l *Logger) SetLevel(subsystem string, level LogLevel) {
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+
+	if subsystem == "" {
+		l.sink.level = level
 		return
 	}
-	
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	
-	formatted := l.formatLog(levelName, msg, keyvals...)
-	l.logger.Println(formatted)
+	l.sink.levels[subsystem] = level
+}
+
+// ResetLevel makes a subsystem use the default level again
+func (l *Logger) ResetLevel(subsystem string) {
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+	delete(l.sink.levels, subsystem)
+}
+
+// LogLevels describes the levels in effect
+type LogLevels struct {
+	Default    string            `json:"default"`
+	Format     string            `json:"format"`
+	Subsystems map[string]string `json:"subsystems"` // Level of every known subsystem
+	Overrides  []string          `json:"overrides"`  // Subsystems with a level of their own
+}
+
+// Levels returns the default level and the level of every subsystem that
+// has a logger or a level of its own
+func (l *Logger) Levels() LogLevels {
+	l.sink.mu.RLock()
+	defer l.sink.mu.RUnlock()
+
+	levels := LogLevels{
+		Default:    l.sink.level.String(),
+		Format:     l.sink.format,
+		Subsystems: make(map[string]string),
+		Overrides:  []string{},
+	}
+	for subsystem := range l.sink.subsystems {
+		levels.Subsystems[subsystem] = l.sink.level.String()
+	}
+	for subsystem, level := range l.sink.levels {
+		levels.Subsystems[subsystem] = level.String()
+		levels.Overrides = append(levels.Overrides, subsystem)
+	}
+	sort.Strings(levels.Overrides)
+	return levels
+}
+
+// Enabled reports whether messages of a level are written for the logger's
+// subsystem
+func (l *Logger) Enabled(level LogLevel) bool {
+	l.sink.mu.RLock()
+	defer l.sink.mu.RUnlock()
+
+	threshold, exists := l.sink.levels[l.subsystem]
+	if !exists {
+		threshold = l.sink.level
+	}
+	return level <= threshold
+}
+
+// log writes a message if its level is enabled
+func (l *Logger) log(level LogLevel, msg string, keyvals ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	// Caller of Error, Info, ...
+	caller := "unknown"
+	if _, file, line, ok := runtime.Caller(2); ok {
+		caller = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+	fields := keyvals
+	if len(l.fields) > 0 {
+		fields = append(append([]interface{}{}, l.fields...), keyvals...)
+	}
+	if len(fields)%2 != 0 {
+		fields = append(fields, "")
+	}
+
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+
+	var line []byte
+	if l.sink.format == LogFormatJSON {
+		line = formatJSON(time.Now(), level, l.subsystem, caller, msg, fields)
+	} else {
+		line = formatText(time.Now(), level, l.subsystem, caller, msg, fields)
+	}
+	l.sink.out.Write(line)
+}
+
+// formatText formats a message as a line of text with key=value fields
+func formatText(t time.Time, level LogLevel, subsystem, caller, msg string, fields []interface{}) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] [%s]", t.Format("2006-01-02 15:04:05.000"), strings.ToUpper(level.String()))
+	if subsystem != "" {
+		fmt.Fprintf(&b, " [%s]", subsystem)
+	}
+	fmt.Fprintf(&b, " [%s] %s", caller, msg)
+	for i := 0; i < len(fields); i += 2 {
+		fmt.Fprintf(&b, " %s=%s", fieldKey(fields[i]), textValue(fields[i+1]))
+	}
+	b.WriteByte('\n')
+	return []byte(b.String())
+}
+
+// formatJSON formats a message as a JSON object on one line. Fields come
+// after the standard members and cannot replace them.
+func formatJSON(t time.Time, level LogLevel, subsystem, caller, msg string, fields []interface{}) []byte {
+	var b strings.Builder
+	member := func(key string, value interface{}) {
+		data, err := json.Marshal(value)
+		if err != nil {
+			data, _ = json.Marshal(fmt.Sprint(value))
+		}
+		keyData, _ := json.Marshal(key)
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		b.Write(keyData)
+		b.WriteByte(':')
+		b.Write(data)
+	}
+
+	b.WriteByte('{')
+	member("time", t.Format(time.RFC3339Nano))
+	member("level", level.String())
+	if subsystem != "" {
+		member("subsystem", subsystem)
+	}
+	member("caller", caller)
+	member("msg", msg)
+	reserved := map[string]bool{"time": true, "level": true, "subsystem": true, "caller": true, "msg": true}
+	for i := 0; i < len(fields); i += 2 {
+		key := fieldKey(fields[i])
+		if reserved[key] {
+			key = "field." + key
+		}
+		member(key, jsonValue(fields[i+1]))
+	}
+	b.WriteString("}\n")
+	return []byte(b.String())
+}
+
+// fieldKey returns a field key as a string
+func fieldKey(key interface{}) string {
+	if s, ok := key.(string); ok {
+		return s
+	}
+	return fmt.Sprint(key)
+}
+
+// textValue formats a field value, quoting it if it is empty or has spaces,
+// quotes or equal signs
+func textValue(value interface{}) string {
+	s := fmt.Sprint(jsonValue(value))
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// jsonValue turns errors and stringers into their text, which is what a
+// reader of the log wants rather than their structure
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
 }
 
 // Error logs an error message
 func (l *Logger) Error(msg string, keyvals ...interface{}) {
-	l.log(LogLevelError, "ERROR", msg, keyvals...)
+	l.log(LogLevelError, msg, keyvals...)
 }
 
 // Warn logs a warning message
 func (l *Logger) Warn(msg string, keyvals ...interface{}) {
-	l.log(LogLevelWarn, "WARN", msg, keyvals...)
+	l.log(LogLevelWarn, msg, keyvals...)
 }
 
 // Info logs an info message
 func (l *Logger) Info(msg string, keyvals ...interface{}) {
-	l.log(LogLevelInfo, "INFO", msg, keyvals...)
+	l.log(LogLevelInfo, msg, keyvals...)
 }
 
 // Debug logs a debug message
 func (l *Logger) Debug(msg string, keyvals ...interface{}) {
-	l.log(LogLevelDebug, "DEBUG", msg, keyvals...)
+	l.log(LogLevelDebug, msg, keyvals...)
 }
 
 // Trace logs a trace message
 func (l *Logger) Trace(msg string, keyvals ...interface{}) {
-	l.log(LogLevelTrace, "TRACE", msg, keyvals...)
+	l.log(LogLevelTrace, msg, keyvals...)
+}
+
+// rotatingFile is a log file that is renamed to path.1, shifting older
+// files up to path.N, once it would grow past its maximum size
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// openRotatingFile opens a log file for appending, creating its directory
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file at its path for appending
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write appends to the file, rotating it first if it would grow too large
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.file == nil {
+		return 0, errors.New("log file is closed")
+	}
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups, moves the file to the first backup and starts
+// a new file
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+
+	os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxBackups))
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		// Keep logging to the file as it is rather than to nothing
+		if openErr := f.open(); openErr != nil {
+			return fmt.Errorf("rotating log file: %v; reopening it: %v", err, openErr)
+		}
+		return fmt.Errorf("rotating log file: %w", err)
+	}
+	return f.open()
+}
+
+// Close closes the file
+func (f *rotatingFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
 }
 
 // NewError creates a formatted error
@@ -141,11 +529,11 @@ func NewError(msg string, keyvals ...interface{}) error {
 		if len(keyvals)%2 != 0 {
 			keyvals = append(keyvals, "")
 		}
-		
+
 		for i := 0; i < len(keyvals); i += 2 {
 			key, val := keyvals[i], keyvals[i+1]
 			errMsg += fmt.Sprintf(" %v=%v", key, val)
 		}
 	}
-	return fmt.Errorf(errMsg)
+	return errors.New(errMsg)
 }
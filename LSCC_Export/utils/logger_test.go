@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readLog returns the contents of a log file, or "" if it does not exist
+func readLog(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRotatingFileRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "node.log")
+	f, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Each line fills the file, so every write after the first rotates
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+		path + ".3": "",
+	}
+	for file, contents := range want {
+		if got := readLog(t, file); got != contents {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), got, contents)
+		}
+	}
+}
+
+func TestRotatingFileKeepsLoggingWhenRotationFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node.log")
+	f, err := openRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte("first\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory in the way of the backup makes the rename fail
+	if err := os.MkdirAll(filepath.Join(path+".1", "blocked"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("second\n")); err == nil || !strings.Contains(err.Error(), "rotating log file") {
+		t.Fatalf("write over the limit = %v, want the rotation error", err)
+	}
+
+	// The original file is reopened, so writing goes on once the backup
+	// is free
+	if f.file == nil {
+		t.Fatal("log file left closed after the failed rotation")
+	}
+	if got := readLog(t, path); got != "first\n" {
+		t.Errorf("log = %q after the failed rotation, want %q", got, "first\n")
+	}
+	if err := os.RemoveAll(path + ".1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("fourth\n")); err != nil {
+		t.Fatalf("write once the backup is free: %v", err)
+	}
+	if got := readLog(t, path+".1"); got != "first\n" {
+		t.Errorf("backup = %q, want the file as it was before rotating", got)
+	}
+	if got := readLog(t, path); got != "fourth\n" {
+		t.Errorf("log = %q, want %q", got, "fourth\n")
+	}
+}
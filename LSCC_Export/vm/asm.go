@@ -0,0 +1,159 @@
+package vm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Assemble translates assembly source into code. Each line holds one
+// instruction or a label; ';' starts a comment:
+//
+//	      PUSH 1            ; integer operand
+//	      PUSHB "count"     ; quoted string or 0x-prefixed hex operand
+//	loop:                   ; label
+//	      JUMPI loop        ; jump operands name a label
+func Assemble(source string) ([]byte, error) {
+	type fixup struct {
+		offset int
+		label  string
+		line   int
+	}
+
+	var code []byte
+	var fixups []fixup
+	labels := make(map[string]int)
+	byName := make(map[string]Opcode, len(opcodes))
+	for op, info := range opcodes {
+		byName[info.name] = op
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(source))
+	for line := 1; scanner.Scan(); line++ {
+		text := stripComment(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if strings.HasSuffix(text, ":") {
+			label := strings.TrimSuffix(text, ":")
+			if _, exists := labels[label]; exists {
+				return nil, fmt.Errorf("line %d: label %q defined twice", line, label)
+			}
+			labels[label] = len(code)
+			continue
+		}
+
+		mnemonic, operand, _ := strings.Cut(text, " ")
+		operand = strings.TrimSpace(operand)
+		op, known := byName[strings.ToUpper(mnemonic)]
+		if !known {
+			return nil, fmt.Errorf("line %d: unknown instruction %q", line, mnemonic)
+		}
+		if opcodes[op].immediate > 0 && operand == "" {
+			return nil, fmt.Errorf("line %d: %s needs an operand", line, op)
+		}
+		if opcodes[op].immediate == 0 && operand != "" {
+			return nil, fmt.Errorf("line %d: %s takes no operand", line, op)
+		}
+		code = append(code, byte(op))
+
+		switch op {
+		case PUSH:
+			n, err := strconv.ParseInt(operand, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid integer %q", line, operand)
+			}
+			code = binary.BigEndian.AppendUint64(code, uint64(n))
+		case PUSHB:
+			data, err := parseBytesOperand(operand)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			if len(data) > MaxValueSize {
+				return nil, fmt.Errorf("line %d: %w", line, ErrValueTooLarge)
+			}
+			code = binary.BigEndian.AppendUint16(code, uint16(len(data)))
+			code = append(code, data...)
+		case JUMP, JUMPI:
+			fixups = append(fixups, fixup{offset: len(code), label: operand, line: line})
+			code = append(code, 0, 0, 0, 0)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, f := range fixups {
+		target, exists := labels[f.label]
+		if !exists {
+			return nil, fmt.Errorf("line %d: unknown label %q", f.line, f.label)
+		}
+		binary.BigEndian.PutUint32(code[f.offset:], uint32(target))
+	}
+	return code, Validate(code)
+}
+
+// stripComment removes a trailing comment outside a quoted string and
+// surrounding whitespace
+func stripComment(line string) string {
+	quoted := false
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ';' && !quoted:
+			return strings.TrimSpace(line[:i])
+		}
+	}
+	return strings.TrimSpace(line)
+}
+
+// parseBytesOperand parses a quoted string or 0x-prefixed hex operand
+func parseBytesOperand(operand string) ([]byte, error) {
+	if strings.HasPrefix(operand, "\"") {
+		text, err := strconv.Unquote(operand)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", operand)
+		}
+		return []byte(text), nil
+	}
+	if strings.HasPrefix(operand, "0x") {
+		v, err := parseBytes(operand)
+		if err != nil {
+			return nil, err
+		}
+		return v.b, nil
+	}
+	return nil, fmt.Errorf("PUSHB operand must be a quoted string or 0x hex, got %s", operand)
+}
+
+// Disassemble formats code as assembly, one instruction per line prefixed
+// with its offset
+func Disassemble(code []byte) (string, error) {
+	if _, err := instructionStarts(code); err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	for pc := 0; pc < len(code); pc += instructionSize(code, pc) {
+		op := Opcode(code[pc])
+		fmt.Fprintf(&out, "%04d  %s", pc, op)
+		switch op {
+		case PUSH:
+			fmt.Fprintf(&out, " %d", int64(binary.BigEndian.Uint64(code[pc+1:])))
+		case PUSHB:
+			data := Bytes(code[pc+3 : pc+instructionSize(code, pc)])
+			if isPrintable(data.b) {
+				fmt.Fprintf(&out, " %q", data.b)
+			} else {
+				fmt.Fprintf(&out, " %s", data)
+			}
+		case JUMP, JUMPI:
+			fmt.Fprintf(&out, " %d", binary.BigEndian.Uint32(code[pc+1:]))
+		}
+		out.WriteString("\n")
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,125 @@
+package vm
+
+// Opcode is a VM instruction
+type Opcode byte
+
+const (
+	// Control flow
+	STOP   Opcode = 0x00 // Halt successfully without a return value
+	JUMP   Opcode = 0x01 // Jump to the 4-byte offset that follows
+	JUMPI  Opcode = 0x02 // Pop a condition and jump to the offset if it is truthy
+	RETURN Opcode = 0x03 // Pop a value and halt successfully, returning it
+	REVERT Opcode = 0x04 // Pop a message and halt, undoing every effect of the call
+
+	// Stack
+	PUSH  Opcode = 0x10 // Push the 8-byte big-endian integer that follows
+	PUSHB Opcode = 0x11 // Push the byte string that follows, after a 2-byte length
+	POP   Opcode = 0x12 // Drop the top value
+	DUP   Opcode = 0x13 // Duplicate the top value
+	SWAP  Opcode = 0x14 // Swap the top two values
+	OVER  Opcode = 0x15 // Copy the second value to the top
+
+	// Arithmetic and comparison; arithmetic fails on overflow
+	ADD Opcode = 0x20
+	SUB Opcode = 0x21
+	MUL Opcode = 0x22
+	DIV Opcode = 0x23
+	MOD Opcode = 0x24
+	LT  Opcode = 0x25
+	GT  Opcode = 0x26
+	EQ  Opcode = 0x27 // Compare any two values; pushes 1 or 0
+	NOT Opcode = 0x28 // Push 1 if the top value is falsy, 0 otherwise
+	AND Opcode = 0x29
+	OR  Opcode = 0x2a
+
+	// Byte strings
+	CONCAT Opcode = 0x30
+	LEN    Opcode = 0x31
+
+	// Call context
+	CALLER  Opcode = 0x40 // Push the account that sent the transaction
+	VALUE   Opcode = 0x41 // Push the amount attached to the call, in base units
+	ADDRESS Opcode = 0x42 // Push the contract's own address
+	BALANCE Opcode = 0x43 // Pop an address and push its balance
+	HEIGHT  Opcode = 0x44 // Push the height of the block being applied
+	METHOD  Opcode = 0x45 // Push the called method name
+	ARG     Opcode = 0x46 // Pop an index and push that call argument
+	ARGC    Opcode = 0x47 // Push the number of call arguments
+
+	// State
+	SLOAD    Opcode = 0x50 // Pop a key and push its stored value, or 0
+	SSTORE   Opcode = 0x51 // Pop a value and a key and store the value
+	TRANSFER Opcode = 0x52 // Pop an amount and an address and pay it from the contract
+	LOG      Opcode = 0x53 // Pop data and a topic and emit a log
+	XCALL    Opcode = 0x54 // Pop a call's callback, gas, value, argument, method, contract and shard, send it and push its message ID
+)
+
+// opInfo describes an opcode: its mnemonic, the size of its immediate
+// operand and its base gas cost
+type opInfo struct {
+	name      string
+	immediate int
+	gas       uint64
+}
+
+var opcodes = map[Opcode]opInfo{
+	STOP:   {"STOP", 0, 0},
+	JUMP:   {"JUMP", 4, 2},
+	JUMPI:  {"JUMPI", 4, 3},
+	RETURN: {"RETURN", 0, 1},
+	REVERT: {"REVERT", 0, 1},
+
+	PUSH:  {"PUSH", 8, 1},
+	PUSHB: {"PUSHB", 2, 1},
+	POP:   {"POP", 0, 1},
+	DUP:   {"DUP", 0, 1},
+	SWAP:  {"SWAP", 0, 1},
+	OVER:  {"OVER", 0, 1},
+
+	ADD: {"ADD", 0, 2},
+	SUB: {"SUB", 0, 2},
+	MUL: {"MUL", 0, 3},
+	DIV: {"DIV", 0, 4},
+	MOD: {"MOD", 0, 4},
+	LT:  {"LT", 0, 2},
+	GT:  {"GT", 0, 2},
+	EQ:  {"EQ", 0, 2},
+	NOT: {"NOT", 0, 1},
+	AND: {"AND", 0, 2},
+	OR:  {"OR", 0, 2},
+
+	CONCAT: {"CONCAT", 0, 3},
+	LEN:    {"LEN", 0, 1},
+
+	CALLER:  {"CALLER", 0, 2},
+	VALUE:   {"VALUE", 0, 2},
+	ADDRESS: {"ADDRESS", 0, 2},
+	BALANCE: {"BALANCE", 0, 20},
+	HEIGHT:  {"HEIGHT", 0, 2},
+	METHOD:  {"METHOD", 0, 2},
+	ARG:     {"ARG", 0, 2},
+	ARGC:    {"ARGC", 0, 2},
+
+	SLOAD:    {"SLOAD", 0, 50},
+	SSTORE:   {"SSTORE", 0, 200},
+	TRANSFER: {"TRANSFER", 0, 100},
+	LOG:      {"LOG", 0, 20},
+	XCALL:    {"XCALL", 0, 700},
+}
+
+// Gas charged per 32 bytes of data copied, stored or logged, on top of an
+// opcode's base cost
+const gasPerWord = 3
+
+// String returns the opcode's mnemonic
+func (op Opcode) String() string {
+	if info, ok := opcodes[op]; ok {
+		return info.name
+	}
+	return "INVALID"
+}
+
+// wordGas returns the gas charged for handling size bytes of data
+func wordGas(size int) uint64 {
+	return uint64((size+31)/32) * gasPerWord
+}
@@ -0,0 +1,189 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxValueSize is the largest byte string a value may hold
+const MaxValueSize = 1024
+
+// Value is a VM word: either a 64-bit signed integer or a byte string.
+// Addresses, method names and storage keys are byte strings; amounts are
+// integers in base units.
+//
+// In JSON an integer is a number and a byte string is a string, hex encoded
+// with a 0x prefix unless it is printable ASCII.
+type Value struct {
+	isBytes bool
+	n       int64
+	b       []byte
+}
+
+// Int returns an integer value
+func Int(n int64) Value {
+	return Value{n: n}
+}
+
+// Bytes returns a byte string value
+func Bytes(b []byte) Value {
+	return Value{isBytes: true, b: append([]byte{}, b...)}
+}
+
+// String returns a byte string value holding s
+func String(s string) Value {
+	return Value{isBytes: true, b: []byte(s)}
+}
+
+// IsBytes reports whether the value is a byte string
+func (v Value) IsBytes() bool {
+	return v.isBytes
+}
+
+// AsInt returns the integer held by the value
+func (v Value) AsInt() (int64, error) {
+	if v.isBytes {
+		return 0, errors.New("expected an integer, got bytes")
+	}
+	return v.n, nil
+}
+
+// AsBytes returns the byte string held by the value
+func (v Value) AsBytes() ([]byte, error) {
+	if !v.isBytes {
+		return nil, errors.New("expected bytes, got an integer")
+	}
+	return v.b, nil
+}
+
+// Truthy reports whether the value counts as true in a condition: a
+// non-zero integer or a non-empty byte string
+func (v Value) Truthy() bool {
+	if v.isBytes {
+		return len(v.b) > 0
+	}
+	return v.n != 0
+}
+
+// Equal reports whether two values have the same type and content
+func (v Value) Equal(other Value) bool {
+	if v.isBytes != other.isBytes {
+		return false
+	}
+	if v.isBytes {
+		return bytes.Equal(v.b, other.b)
+	}
+	return v.n == other.n
+}
+
+// Encode returns the canonical binary encoding of the value: a type tag
+// followed by 8 big-endian bytes for integers or the raw bytes of a byte
+// string. Storage is keyed by it.
+func (v Value) Encode() []byte {
+	if v.isBytes {
+		return append([]byte{1}, v.b...)
+	}
+	buf := make([]byte, 9)
+	binary.BigEndian.PutUint64(buf[1:], uint64(v.n))
+	return buf
+}
+
+// DecodeValue decodes a value from its canonical binary encoding
+func DecodeValue(data []byte) (Value, error) {
+	switch {
+	case len(data) == 9 && data[0] == 0:
+		return Int(int64(binary.BigEndian.Uint64(data[1:]))), nil
+	case len(data) >= 1 && data[0] == 1:
+		return Bytes(data[1:]), nil
+	}
+	return Value{}, errors.New("invalid value encoding")
+}
+
+// size returns the number of bytes the value occupies, for gas accounting
+func (v Value) size() int {
+	if v.isBytes {
+		return len(v.b)
+	}
+	return 8
+}
+
+// String formats the value as it appears in JSON, without quotes
+func (v Value) String() string {
+	if !v.isBytes {
+		return strconv.FormatInt(v.n, 10)
+	}
+	if isPrintable(v.b) {
+		return string(v.b)
+	}
+	return "0x" + hex.EncodeToString(v.b)
+}
+
+// isPrintable reports whether bytes can be shown as a plain JSON string
+// without being mistaken for hex
+func isPrintable(b []byte) bool {
+	if bytes.HasPrefix(b, []byte("0x")) {
+		return false
+	}
+	for _, c := range b {
+		if c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseValue parses a value from text: an integer, a 0x-prefixed hex
+// string, or any other text as a byte string
+func ParseValue(text string) (Value, error) {
+	if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return Int(n), nil
+	}
+	return parseBytes(text)
+}
+
+// parseBytes parses a byte string, decoding it if it is 0x-prefixed hex
+func parseBytes(text string) (Value, error) {
+	if strings.HasPrefix(text, "0x") {
+		b, err := hex.DecodeString(text[2:])
+		if err != nil {
+			return Value{}, fmt.Errorf("invalid hex value %q", text)
+		}
+		return Bytes(b), nil
+	}
+	return String(text), nil
+}
+
+// MarshalJSON encodes an integer as a number and a byte string as a string
+func (v Value) MarshalJSON() ([]byte, error) {
+	if !v.isBytes {
+		return []byte(strconv.FormatInt(v.n, 10)), nil
+	}
+	return json.Marshal(v.String())
+}
+
+// UnmarshalJSON decodes a number as an integer and a string as a byte
+// string
+func (v *Value) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		parsed, err := parseBytes(text)
+		if err != nil {
+			return err
+		}
+		*v = parsed
+		return nil
+	}
+
+	n, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid value %s", data)
+	}
+	*v = Int(n)
+	return nil
+}
@@ -0,0 +1,627 @@
+package vm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Execution limits
+const (
+	MaxCodeSize   = 24 * 1024 // Bytes of contract code
+	MaxStackDepth = 256       // Values on the stack
+	MaxArgs       = 16        // Arguments of a call
+	MaxLogs       = 64        // Logs emitted by a call
+	MaxCalls      = 16        // Asynchronous calls sent by a call
+)
+
+// Execution errors. Any of them fails the call and undoes its effects.
+var (
+	ErrOutOfGas         = errors.New("out of gas")
+	ErrStackUnderflow   = errors.New("stack underflow")
+	ErrStackOverflow    = errors.New("stack overflow")
+	ErrInvalidOpcode    = errors.New("invalid opcode")
+	ErrInvalidJump      = errors.New("invalid jump destination")
+	ErrIntegerOverflow  = errors.New("integer overflow")
+	ErrDivisionByZero   = errors.New("division by zero")
+	ErrValueTooLarge    = errors.New("value too large")
+	ErrTooManyLogs      = errors.New("too many logs")
+	ErrTooManyCalls     = errors.New("too many asynchronous calls")
+	ErrInvalidCall      = errors.New("asynchronous call needs a contract, a method, positive gas and a non-negative value")
+	ErrArgumentRange    = errors.New("argument index out of range")
+	ErrInvalidTransfer  = errors.New("transfer amount must be positive")
+	ErrTruncatedProgram = errors.New("truncated instruction")
+)
+
+// RevertError is returned when a contract executes REVERT
+type RevertError struct {
+	Message Value
+}
+
+func (e *RevertError) Error() string {
+	return "reverted: " + e.Message.String()
+}
+
+// Host gives a contract access to the chain state. The VM calls it with
+// the effects of a call as they happen; the host must buffer them and only
+// commit them if the call succeeds.
+type Host interface {
+	// Load returns the value stored under key, or false if none is
+	Load(key Value) (Value, bool)
+	// Store stores a value under key
+	Store(key, value Value)
+	// Balance returns the balance of an account in base units
+	Balance(address string) int64
+	// Transfer pays an amount from the contract's balance to an account
+	Transfer(to string, amount int64) error
+	// Send takes a call's value from the contract's balance and queues the
+	// call for delivery, returning its message ID
+	Send(call Call) (string, error)
+}
+
+// Call is an asynchronous call from a contract to a contract in any shard.
+// It runs in a later block of the target shard; its result is passed to the
+// callback method of the sending contract in a later block still.
+type Call struct {
+	Shard    int    // Shard of the called contract
+	Contract string // Address of the called contract
+	Method   string // Called method
+	Arg      Value  // Argument of the call
+	Value    int64  // Amount attached to the call, in base units
+	Gas      uint64 // Gas for the call and its callback, paid by the sender
+	Callback string // Method of the sender run with the result; empty for none
+}
+
+// Context describes a contract call
+type Context struct {
+	Caller   string  // Account that sent the transaction
+	Address  string  // Address of the called contract
+	Value    int64   // Amount attached to the call, in base units
+	Height   uint64  // Height of the block being applied
+	Method   string  // Called method
+	Args     []Value // Call arguments
+	GasLimit uint64  // Gas the call may use
+}
+
+// Log is an event emitted by a contract
+type Log struct {
+	Topic Value `json:"topic"`
+	Data  Value `json:"data"`
+}
+
+// Result is the outcome of a call
+type Result struct {
+	Return  *Value // Value passed to RETURN, if any
+	Logs    []Log
+	Calls   []string // Message IDs of the asynchronous calls sent
+	GasUsed uint64
+	Err     error // Why the call failed; nil on success
+}
+
+// Validate checks that code is a well-formed program: every opcode is
+// known, no instruction is truncated and every jump lands on an
+// instruction
+func Validate(code []byte) error {
+	if len(code) > MaxCodeSize {
+		return fmt.Errorf("code is %d bytes, limit is %d", len(code), MaxCodeSize)
+	}
+	starts, err := instructionStarts(code)
+	if err != nil {
+		return err
+	}
+	for pc := 0; pc < len(code); {
+		op := Opcode(code[pc])
+		if op == JUMP || op == JUMPI {
+			if target := int(binary.BigEndian.Uint32(code[pc+1:])); !starts[target] {
+				return fmt.Errorf("%w %d at offset %d", ErrInvalidJump, target, pc)
+			}
+		}
+		pc += instructionSize(code, pc)
+	}
+	return nil
+}
+
+// instructionStarts returns the offsets at which instructions start
+func instructionStarts(code []byte) (map[int]bool, error) {
+	starts := make(map[int]bool)
+	for pc := 0; pc < len(code); {
+		if _, ok := opcodes[Opcode(code[pc])]; !ok {
+			return nil, fmt.Errorf("%w 0x%02x at offset %d", ErrInvalidOpcode, code[pc], pc)
+		}
+		size := instructionSize(code, pc)
+		if size <= 0 || pc+size > len(code) {
+			return nil, fmt.Errorf("%w at offset %d", ErrTruncatedProgram, pc)
+		}
+		starts[pc] = true
+		pc += size
+	}
+	return starts, nil
+}
+
+// instructionSize returns the size of the instruction at pc, including its
+// immediate operand, or 0 if its length prefix is cut off
+func instructionSize(code []byte, pc int) int {
+	op := Opcode(code[pc])
+	size := 1 + opcodes[op].immediate
+	if op == PUSHB {
+		if pc+3 > len(code) {
+			return 0
+		}
+		size += int(binary.BigEndian.Uint16(code[pc+1:]))
+	}
+	return size
+}
+
+// machine is the state of a running call
+type machine struct {
+	code   []byte
+	starts map[int]bool
+	ctx    Context
+	host   Host
+	stack  []Value
+	result *Result
+}
+
+// Execute runs code for a call. Execution is deterministic: the result only
+// depends on the code, the context and the host's state.
+func Execute(code []byte, ctx Context, host Host) *Result {
+	result := &Result{}
+	if len(ctx.Args) > MaxArgs {
+		result.Err = fmt.Errorf("%d arguments, limit is %d", len(ctx.Args), MaxArgs)
+		return result
+	}
+	starts, err := instructionStarts(code)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	m := &machine{code: code, starts: starts, ctx: ctx, host: host, result: result}
+	result.Err = m.run()
+	if result.Err != nil {
+		result.Return = nil
+		result.Logs = nil
+		result.Calls = nil
+	}
+	return result
+}
+
+// useGas charges gas, failing once the limit is exceeded
+func (m *machine) useGas(gas uint64) error {
+	if m.result.GasUsed+gas > m.ctx.GasLimit {
+		m.result.GasUsed = m.ctx.GasLimit
+		return ErrOutOfGas
+	}
+	m.result.GasUsed += gas
+	return nil
+}
+
+func (m *machine) push(v Value) error {
+	if len(m.stack) >= MaxStackDepth {
+		return ErrStackOverflow
+	}
+	if v.size() > MaxValueSize {
+		return ErrValueTooLarge
+	}
+	m.stack = append(m.stack, v)
+	return nil
+}
+
+func (m *machine) pop() (Value, error) {
+	if len(m.stack) == 0 {
+		return Value{}, ErrStackUnderflow
+	}
+	v := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return v, nil
+}
+
+func (m *machine) popInt() (int64, error) {
+	v, err := m.pop()
+	if err != nil {
+		return 0, err
+	}
+	return v.AsInt()
+}
+
+func (m *machine) popBytes() ([]byte, error) {
+	v, err := m.pop()
+	if err != nil {
+		return nil, err
+	}
+	return v.AsBytes()
+}
+
+// popInts pops the two operands of a binary operation, the second operand
+// being on top
+func (m *machine) popInts() (int64, int64, error) {
+	b, err := m.popInt()
+	if err != nil {
+		return 0, 0, err
+	}
+	a, err := m.popInt()
+	return a, b, err
+}
+
+// popCall pops the operands of XCALL, the callback being on top
+func (m *machine) popCall() (Call, error) {
+	var call Call
+	callback, err := m.popBytes()
+	if err != nil {
+		return call, err
+	}
+	gas, err := m.popInt()
+	if err != nil {
+		return call, err
+	}
+	value, err := m.popInt()
+	if err != nil {
+		return call, err
+	}
+	arg, err := m.pop()
+	if err != nil {
+		return call, err
+	}
+	method, err := m.popBytes()
+	if err != nil {
+		return call, err
+	}
+	contract, err := m.popBytes()
+	if err != nil {
+		return call, err
+	}
+	shard, err := m.popInt()
+	if err != nil {
+		return call, err
+	}
+	if len(contract) == 0 || len(method) == 0 || gas <= 0 || value < 0 || shard < 0 || shard > math.MaxInt32 {
+		return call, ErrInvalidCall
+	}
+
+	return Call{
+		Shard:    int(shard),
+		Contract: string(contract),
+		Method:   string(method),
+		Arg:      arg,
+		Value:    value,
+		Gas:      uint64(gas),
+		Callback: string(callback),
+	}, nil
+}
+
+func boolValue(b bool) Value {
+	if b {
+		return Int(1)
+	}
+	return Int(0)
+}
+
+// run executes instructions until the program halts or fails
+func (m *machine) run() error {
+	pc := 0
+	for pc < len(m.code) {
+		op := Opcode(m.code[pc])
+		if err := m.useGas(opcodes[op].gas); err != nil {
+			return err
+		}
+		next := pc + instructionSize(m.code, pc)
+
+		switch op {
+		case STOP:
+			return nil
+
+		case JUMP, JUMPI:
+			target := int(binary.BigEndian.Uint32(m.code[pc+1:]))
+			if !m.starts[target] {
+				return ErrInvalidJump
+			}
+			jump := true
+			if op == JUMPI {
+				cond, err := m.pop()
+				if err != nil {
+					return err
+				}
+				jump = cond.Truthy()
+			}
+			if jump {
+				next = target
+			}
+
+		case RETURN:
+			v, err := m.pop()
+			if err != nil {
+				return err
+			}
+			m.result.Return = &v
+			return nil
+
+		case REVERT:
+			v, err := m.pop()
+			if err != nil {
+				return err
+			}
+			return &RevertError{Message: v}
+
+		case PUSH:
+			if err := m.push(Int(int64(binary.BigEndian.Uint64(m.code[pc+1:])))); err != nil {
+				return err
+			}
+
+		case PUSHB:
+			data := m.code[pc+3 : next]
+			if err := m.useGas(wordGas(len(data))); err != nil {
+				return err
+			}
+			if err := m.push(Bytes(data)); err != nil {
+				return err
+			}
+
+		case POP:
+			if _, err := m.pop(); err != nil {
+				return err
+			}
+
+		case DUP, OVER:
+			depth := 1
+			if op == OVER {
+				depth = 2
+			}
+			if len(m.stack) < depth {
+				return ErrStackUnderflow
+			}
+			if err := m.push(m.stack[len(m.stack)-depth]); err != nil {
+				return err
+			}
+
+		case SWAP:
+			if len(m.stack) < 2 {
+				return ErrStackUnderflow
+			}
+			top := len(m.stack) - 1
+			m.stack[top], m.stack[top-1] = m.stack[top-1], m.stack[top]
+
+		case ADD, SUB, MUL, DIV, MOD, LT, GT, AND, OR:
+			a, b, err := m.popInts()
+			if err != nil {
+				return err
+			}
+			v, err := arithmetic(op, a, b)
+			if err != nil {
+				return err
+			}
+			if err := m.push(v); err != nil {
+				return err
+			}
+
+		case EQ:
+			b, err := m.pop()
+			if err != nil {
+				return err
+			}
+			a, err := m.pop()
+			if err != nil {
+				return err
+			}
+			if err := m.push(boolValue(a.Equal(b))); err != nil {
+				return err
+			}
+
+		case NOT:
+			v, err := m.pop()
+			if err != nil {
+				return err
+			}
+			if err := m.push(boolValue(!v.Truthy())); err != nil {
+				return err
+			}
+
+		case CONCAT:
+			b, err := m.popBytes()
+			if err != nil {
+				return err
+			}
+			a, err := m.popBytes()
+			if err != nil {
+				return err
+			}
+			joined := append(append([]byte{}, a...), b...)
+			if err := m.useGas(wordGas(len(joined))); err != nil {
+				return err
+			}
+			if err := m.push(Bytes(joined)); err != nil {
+				return err
+			}
+
+		case LEN:
+			b, err := m.popBytes()
+			if err != nil {
+				return err
+			}
+			if err := m.push(Int(int64(len(b)))); err != nil {
+				return err
+			}
+
+		case CALLER, ADDRESS, METHOD:
+			text := m.ctx.Caller
+			if op == ADDRESS {
+				text = m.ctx.Address
+			} else if op == METHOD {
+				text = m.ctx.Method
+			}
+			if err := m.push(String(text)); err != nil {
+				return err
+			}
+
+		case VALUE:
+			if err := m.push(Int(m.ctx.Value)); err != nil {
+				return err
+			}
+
+		case HEIGHT:
+			if m.ctx.Height > math.MaxInt64 {
+				return ErrIntegerOverflow
+			}
+			if err := m.push(Int(int64(m.ctx.Height))); err != nil {
+				return err
+			}
+
+		case ARGC:
+			if err := m.push(Int(int64(len(m.ctx.Args)))); err != nil {
+				return err
+			}
+
+		case ARG:
+			index, err := m.popInt()
+			if err != nil {
+				return err
+			}
+			if index < 0 || index >= int64(len(m.ctx.Args)) {
+				return ErrArgumentRange
+			}
+			if err := m.push(m.ctx.Args[index]); err != nil {
+				return err
+			}
+
+		case BALANCE:
+			address, err := m.popBytes()
+			if err != nil {
+				return err
+			}
+			if err := m.push(Int(m.host.Balance(string(address)))); err != nil {
+				return err
+			}
+
+		case SLOAD:
+			key, err := m.pop()
+			if err != nil {
+				return err
+			}
+			v, exists := m.host.Load(key)
+			if !exists {
+				v = Int(0)
+			}
+			if err := m.push(v); err != nil {
+				return err
+			}
+
+		case SSTORE:
+			v, err := m.pop()
+			if err != nil {
+				return err
+			}
+			key, err := m.pop()
+			if err != nil {
+				return err
+			}
+			if err := m.useGas(wordGas(key.size() + v.size())); err != nil {
+				return err
+			}
+			m.host.Store(key, v)
+
+		case TRANSFER:
+			amount, err := m.popInt()
+			if err != nil {
+				return err
+			}
+			to, err := m.popBytes()
+			if err != nil {
+				return err
+			}
+			if amount <= 0 {
+				return ErrInvalidTransfer
+			}
+			if err := m.host.Transfer(string(to), amount); err != nil {
+				return err
+			}
+
+		case LOG:
+			data, err := m.pop()
+			if err != nil {
+				return err
+			}
+			topic, err := m.pop()
+			if err != nil {
+				return err
+			}
+			if len(m.result.Logs) >= MaxLogs {
+				return ErrTooManyLogs
+			}
+			if err := m.useGas(wordGas(topic.size() + data.size())); err != nil {
+				return err
+			}
+			m.result.Logs = append(m.result.Logs, Log{Topic: topic, Data: data})
+
+		case XCALL:
+			call, err := m.popCall()
+			if err != nil {
+				return err
+			}
+			if len(m.result.Calls) >= MaxCalls {
+				return ErrTooManyCalls
+			}
+			// The call's gas is paid up front out of the sender's
+			if err := m.useGas(call.Gas + wordGas(call.Arg.size())); err != nil {
+				return err
+			}
+			id, err := m.host.Send(call)
+			if err != nil {
+				return err
+			}
+			m.result.Calls = append(m.result.Calls, id)
+			if err := m.push(String(id)); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("%w 0x%02x at offset %d", ErrInvalidOpcode, byte(op), pc)
+		}
+
+		pc = next
+	}
+	return nil
+}
+
+// arithmetic applies a binary integer operation, failing on overflow
+func arithmetic(op Opcode, a, b int64) (Value, error) {
+	switch op {
+	case ADD:
+		if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+			return Value{}, ErrIntegerOverflow
+		}
+		return Int(a + b), nil
+	case SUB:
+		if (b < 0 && a > math.MaxInt64+b) || (b > 0 && a < math.MinInt64+b) {
+			return Value{}, ErrIntegerOverflow
+		}
+		return Int(a - b), nil
+	case MUL:
+		if a != 0 && b != 0 {
+			product := a * b
+			if product/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+				return Value{}, ErrIntegerOverflow
+			}
+		}
+		return Int(a * b), nil
+	case DIV, MOD:
+		if b == 0 {
+			return Value{}, ErrDivisionByZero
+		}
+		if a == math.MinInt64 && b == -1 {
+			return Value{}, ErrIntegerOverflow
+		}
+		if op == DIV {
+			return Int(a / b), nil
+		}
+		return Int(a % b), nil
+	case LT:
+		return boolValue(a < b), nil
+	case GT:
+		return boolValue(a > b), nil
+	case AND:
+		return boolValue(a != 0 && b != 0), nil
+	case OR:
+		return boolValue(a != 0 || b != 0), nil
+	}
+	return Value{}, ErrInvalidOpcode
+}
@@ -0,0 +1,537 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// testHost keeps a contract's storage and balances in memory
+type testHost struct {
+	storage  map[string]Value
+	balances map[string]int64
+	calls    []Call
+}
+
+func newTestHost() *testHost {
+	return &testHost{
+		storage:  make(map[string]Value),
+		balances: map[string]int64{"alice": 100, "contract": 50},
+	}
+}
+
+func (h *testHost) Load(key Value) (Value, bool) {
+	v, ok := h.storage[string(key.Encode())]
+	return v, ok
+}
+
+func (h *testHost) Store(key, value Value) {
+	h.storage[string(key.Encode())] = value
+}
+
+func (h *testHost) Balance(address string) int64 {
+	return h.balances[address]
+}
+
+func (h *testHost) Transfer(to string, amount int64) error {
+	if h.balances["contract"] < amount {
+		return errors.New("insufficient balance")
+	}
+	h.balances["contract"] -= amount
+	h.balances[to] += amount
+	return nil
+}
+
+func (h *testHost) Send(call Call) (string, error) {
+	h.calls = append(h.calls, call)
+	return fmt.Sprintf("msg-%d", len(h.calls)), nil
+}
+
+// testContext is the call the tests run code for
+func testContext() Context {
+	return Context{
+		Caller:   "alice",
+		Address:  "contract",
+		Value:    5,
+		Height:   9,
+		Method:   "run",
+		Args:     []Value{Int(7), String("x")},
+		GasLimit: 1000000,
+	}
+}
+
+// assemble assembles source or fails the test
+func assemble(t *testing.T, source string) []byte {
+	t.Helper()
+	code, err := Assemble(source)
+	if err != nil {
+		t.Fatalf("assemble %q: %v", source, err)
+	}
+	return code
+}
+
+// runOn runs code on a machine whose stack starts out holding stack, so the
+// stack effect of single instructions can be checked
+func runOn(t *testing.T, code []byte, stack []Value, ctx Context, host Host) (*machine, error) {
+	t.Helper()
+	starts, err := instructionStarts(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &machine{
+		code:   code,
+		starts: starts,
+		ctx:    ctx,
+		host:   host,
+		stack:  append([]Value{}, stack...),
+		result: &Result{},
+	}
+	return m, m.run()
+}
+
+func formatStack(stack []Value) string {
+	parts := make([]string, len(stack))
+	for i, v := range stack {
+		if v.IsBytes() {
+			parts[i] = fmt.Sprintf("%q", v.String())
+		} else {
+			parts[i] = v.String()
+		}
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+func TestOpcodeEffects(t *testing.T) {
+	stored := newTestHost()
+	stored.Store(String("k"), Int(42))
+
+	tests := []struct {
+		source string
+		before []Value
+		after  []Value
+		gas    uint64
+		host   *testHost
+	}{
+		{source: "STOP", before: []Value{Int(1)}, after: []Value{Int(1)}, gas: 0},
+		{source: "PUSH 7", after: []Value{Int(7)}, gas: 1},
+		{source: "PUSH -7", after: []Value{Int(-7)}, gas: 1},
+		{source: `PUSHB "abc"`, after: []Value{String("abc")}, gas: 1 + gasPerWord},
+		{source: "PUSHB 0x00ff", after: []Value{Bytes([]byte{0, 0xff})}, gas: 1 + gasPerWord},
+		{source: "POP", before: []Value{Int(1), Int(2)}, after: []Value{Int(1)}, gas: 1},
+		{source: "DUP", before: []Value{Int(1), Int(2)}, after: []Value{Int(1), Int(2), Int(2)}, gas: 1},
+		{source: "SWAP", before: []Value{Int(1), Int(2)}, after: []Value{Int(2), Int(1)}, gas: 1},
+		{source: "OVER", before: []Value{Int(1), Int(2)}, after: []Value{Int(1), Int(2), Int(1)}, gas: 1},
+
+		{source: "ADD", before: []Value{Int(2), Int(3)}, after: []Value{Int(5)}, gas: 2},
+		{source: "SUB", before: []Value{Int(2), Int(3)}, after: []Value{Int(-1)}, gas: 2},
+		{source: "MUL", before: []Value{Int(2), Int(-3)}, after: []Value{Int(-6)}, gas: 3},
+		{source: "DIV", before: []Value{Int(7), Int(2)}, after: []Value{Int(3)}, gas: 4},
+		{source: "MOD", before: []Value{Int(7), Int(2)}, after: []Value{Int(1)}, gas: 4},
+		{source: "LT", before: []Value{Int(2), Int(3)}, after: []Value{Int(1)}, gas: 2},
+		{source: "GT", before: []Value{Int(2), Int(3)}, after: []Value{Int(0)}, gas: 2},
+		{source: "EQ", before: []Value{String("a"), String("a")}, after: []Value{Int(1)}, gas: 2},
+		{source: "EQ", before: []Value{Int(1), String("1")}, after: []Value{Int(0)}, gas: 2},
+		{source: "NOT", before: []Value{Int(0)}, after: []Value{Int(1)}, gas: 1},
+		{source: "NOT", before: []Value{String("a")}, after: []Value{Int(0)}, gas: 1},
+		{source: "AND", before: []Value{Int(1), Int(0)}, after: []Value{Int(0)}, gas: 2},
+		{source: "OR", before: []Value{Int(1), Int(0)}, after: []Value{Int(1)}, gas: 2},
+
+		{source: "CONCAT", before: []Value{String("ab"), String("cd")}, after: []Value{String("abcd")}, gas: 3 + gasPerWord},
+		{source: "LEN", before: []Value{String("abc")}, after: []Value{Int(3)}, gas: 1},
+
+		{source: "CALLER", after: []Value{String("alice")}, gas: 2},
+		{source: "VALUE", after: []Value{Int(5)}, gas: 2},
+		{source: "ADDRESS", after: []Value{String("contract")}, gas: 2},
+		{source: "BALANCE", before: []Value{String("alice")}, after: []Value{Int(100)}, gas: 20},
+		{source: "HEIGHT", after: []Value{Int(9)}, gas: 2},
+		{source: "METHOD", after: []Value{String("run")}, gas: 2},
+		{source: "ARG", before: []Value{Int(1)}, after: []Value{String("x")}, gas: 2},
+		{source: "ARGC", after: []Value{Int(2)}, gas: 2},
+
+		{source: "SLOAD", before: []Value{String("k")}, after: []Value{Int(0)}, gas: 50},
+		{source: "SLOAD", before: []Value{String("k")}, after: []Value{Int(42)}, gas: 50, host: stored},
+		// Key and value take 1 + 8 bytes, one word
+		{source: "SSTORE", before: []Value{String("k"), Int(5)}, gas: 200 + gasPerWord},
+		{source: "TRANSFER", before: []Value{String("bob"), Int(10)}, gas: 100},
+		{source: "LOG", before: []Value{String("topic"), String("data")}, gas: 20 + gasPerWord},
+		// The call's own gas is charged with the opcode
+		{
+			source: "XCALL",
+			before: []Value{Int(1), String("c2"), String("m"), Int(0), Int(5), Int(100), String("cb")},
+			after:  []Value{String("msg-1")},
+			gas:    700 + 100 + gasPerWord,
+		},
+		{source: "RETURN", before: []Value{Int(1)}, gas: 1},
+	}
+
+	for _, tt := range tests {
+		name := fmt.Sprintf("%s %s", tt.source, formatStack(tt.before))
+		t.Run(name, func(t *testing.T) {
+			host := tt.host
+			if host == nil {
+				host = newTestHost()
+			}
+			m, err := runOn(t, assemble(t, tt.source), tt.before, testContext(), host)
+			if err != nil {
+				t.Fatalf("failed: %v", err)
+			}
+			if m.result.GasUsed != tt.gas {
+				t.Errorf("gas = %d, want %d", m.result.GasUsed, tt.gas)
+			}
+			if len(m.stack) != len(tt.after) {
+				t.Fatalf("stack = %s, want %s", formatStack(m.stack), formatStack(tt.after))
+			}
+			for i := range tt.after {
+				if !m.stack[i].Equal(tt.after[i]) {
+					t.Fatalf("stack = %s, want %s", formatStack(m.stack), formatStack(tt.after))
+				}
+			}
+		})
+	}
+}
+
+func TestHostEffects(t *testing.T) {
+	host := newTestHost()
+	code := assemble(t, `
+		PUSHB "k"
+		PUSH 5
+		SSTORE
+		PUSHB "bob"
+		PUSH 10
+		TRANSFER
+		PUSHB "topic"
+		PUSHB "data"
+		LOG
+		PUSHB "k"
+		SLOAD
+		RETURN
+	`)
+	result := Execute(code, testContext(), host)
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if result.Return == nil || !result.Return.Equal(Int(5)) {
+		t.Errorf("returned %v, want the stored 5", result.Return)
+	}
+	if v, _ := host.Load(String("k")); !v.Equal(Int(5)) {
+		t.Errorf("stored %v, want 5", v)
+	}
+	if host.balances["bob"] != 10 || host.balances["contract"] != 40 {
+		t.Errorf("balances after transfer: %v", host.balances)
+	}
+	if len(result.Logs) != 1 || !result.Logs[0].Topic.Equal(String("topic")) || !result.Logs[0].Data.Equal(String("data")) {
+		t.Errorf("logs = %v", result.Logs)
+	}
+}
+
+func TestControlFlow(t *testing.T) {
+	// Sum 1..10 with a loop
+	code := assemble(t, `
+		PUSH 0          ; sum
+		PUSH 10         ; counter
+	loop:
+		DUP
+		NOT
+		JUMPI done
+		SWAP
+		OVER
+		ADD
+		SWAP
+		PUSH 1
+		SUB
+		JUMP loop
+	done:
+		POP
+		RETURN
+	`)
+	result := Execute(code, testContext(), newTestHost())
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if result.Return == nil || !result.Return.Equal(Int(55)) {
+		t.Fatalf("returned %v, want 55", result.Return)
+	}
+
+	// REVERT fails the call with its message and drops its effects
+	host := newTestHost()
+	result = Execute(assemble(t, `
+		PUSHB "topic"
+		PUSHB "data"
+		LOG
+		PUSHB "no"
+		REVERT
+	`), testContext(), host)
+	var revert *RevertError
+	if !errors.As(result.Err, &revert) || !revert.Message.Equal(String("no")) {
+		t.Fatalf("err = %v, want a revert with \"no\"", result.Err)
+	}
+	if result.Logs != nil {
+		t.Error("reverted call kept its logs")
+	}
+
+	// Code jumping into an operand is refused before it runs
+	code = assemble(t, "PUSH 1\nJUMP end\nend:\nSTOP")
+	code[13] = 2 // Into the PUSH operand
+	if err := Validate(code); !errors.Is(err, ErrInvalidJump) {
+		t.Errorf("Validate = %v, want %v", err, ErrInvalidJump)
+	}
+	if result := Execute(code, testContext(), newTestHost()); !errors.Is(result.Err, ErrInvalidJump) {
+		t.Errorf("Execute = %v, want %v", result.Err, ErrInvalidJump)
+	}
+}
+
+func TestOutOfGas(t *testing.T) {
+	// PUSH, PUSH and ADD cost 4 gas in all
+	code := assemble(t, "PUSH 1\nPUSH 2\nADD\nRETURN")
+	ctx := testContext()
+	ctx.GasLimit = 5
+	if result := Execute(code, ctx, newTestHost()); result.Err != nil || result.GasUsed != 5 {
+		t.Fatalf("with exact gas: err %v, gas %d", result.Err, result.GasUsed)
+	}
+
+	ctx.GasLimit = 4
+	result := Execute(code, ctx, newTestHost())
+	if !errors.Is(result.Err, ErrOutOfGas) {
+		t.Fatalf("err = %v, want %v", result.Err, ErrOutOfGas)
+	}
+	if result.GasUsed != ctx.GasLimit || result.Return != nil {
+		t.Errorf("out of gas call used %d gas and returned %v", result.GasUsed, result.Return)
+	}
+
+	// An endless loop runs out of gas
+	ctx.GasLimit = 1000
+	if result := Execute(assemble(t, "loop:\nJUMP loop"), ctx, newTestHost()); !errors.Is(result.Err, ErrOutOfGas) {
+		t.Errorf("endless loop: err = %v, want %v", result.Err, ErrOutOfGas)
+	}
+
+	// Word gas is charged too: 40 bytes are two words
+	ctx.GasLimit = 1 + 2*gasPerWord - 1
+	long := fmt.Sprintf("PUSHB %q", strings.Repeat("a", 40))
+	if result := Execute(assemble(t, long), ctx, newTestHost()); !errors.Is(result.Err, ErrOutOfGas) {
+		t.Errorf("word gas: err = %v, want %v", result.Err, ErrOutOfGas)
+	}
+}
+
+func TestStackUnderflow(t *testing.T) {
+	tests := []struct {
+		source string
+		before []Value
+	}{
+		{"POP", nil},
+		{"DUP", nil},
+		{"SWAP", []Value{Int(1)}},
+		{"OVER", []Value{Int(1)}},
+		{"ADD", []Value{Int(1)}},
+		{"EQ", []Value{Int(1)}},
+		{"NOT", nil},
+		{"CONCAT", []Value{String("a")}},
+		{"LEN", nil},
+		{"ARG", nil},
+		{"BALANCE", nil},
+		{"SLOAD", nil},
+		{"SSTORE", []Value{Int(1)}},
+		{"TRANSFER", []Value{Int(1)}},
+		{"LOG", []Value{Int(1)}},
+		{"XCALL", []Value{String("c2"), String("m"), Int(0), Int(5), Int(100), String("cb")}},
+		{"RETURN", nil},
+		{"REVERT", nil},
+		{"JUMPI end\nend:\nSTOP", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			_, err := runOn(t, assemble(t, tt.source), tt.before, testContext(), newTestHost())
+			if !errors.Is(err, ErrStackUnderflow) {
+				t.Errorf("err = %v, want %v", err, ErrStackUnderflow)
+			}
+		})
+	}
+}
+
+func TestStackOverflow(t *testing.T) {
+	full := make([]Value, MaxStackDepth)
+	for i := range full {
+		full[i] = Int(int64(i))
+	}
+	for _, source := range []string{"PUSH 1", `PUSHB "a"`, "DUP", "OVER", "CALLER"} {
+		if _, err := runOn(t, assemble(t, source), full, testContext(), newTestHost()); !errors.Is(err, ErrStackOverflow) {
+			t.Errorf("%s on a full stack: err = %v, want %v", source, err, ErrStackOverflow)
+		}
+	}
+
+	// A loop pushing forever overflows before running out of gas
+	result := Execute(assemble(t, "loop:\nPUSH 1\nJUMP loop"), testContext(), newTestHost())
+	if !errors.Is(result.Err, ErrStackOverflow) {
+		t.Errorf("pushing loop: err = %v, want %v", result.Err, ErrStackOverflow)
+	}
+}
+
+func TestArithmeticErrors(t *testing.T) {
+	tests := []struct {
+		op   Opcode
+		a, b int64
+		err  error
+	}{
+		{ADD, math.MaxInt64, 1, ErrIntegerOverflow},
+		{ADD, math.MinInt64, -1, ErrIntegerOverflow},
+		{SUB, math.MinInt64, 1, ErrIntegerOverflow},
+		{SUB, math.MaxInt64, -1, ErrIntegerOverflow},
+		{MUL, math.MaxInt64, 2, ErrIntegerOverflow},
+		{MUL, math.MinInt64, -1, ErrIntegerOverflow},
+		{MUL, -1, math.MinInt64, ErrIntegerOverflow},
+		{DIV, math.MinInt64, -1, ErrIntegerOverflow},
+		{MOD, math.MinInt64, -1, ErrIntegerOverflow},
+		{DIV, 1, 0, ErrDivisionByZero},
+		{MOD, 1, 0, ErrDivisionByZero},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d %d", tt.op, tt.a, tt.b), func(t *testing.T) {
+			_, err := runOn(t, []byte{byte(tt.op)}, []Value{Int(tt.a), Int(tt.b)}, testContext(), newTestHost())
+			if !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	// The largest values that fit do not overflow
+	m, err := runOn(t, []byte{byte(ADD)}, []Value{Int(math.MaxInt64 - 1), Int(1)}, testContext(), newTestHost())
+	if err != nil || !m.stack[0].Equal(Int(math.MaxInt64)) {
+		t.Errorf("MaxInt64-1 + 1: %v, err %v", m.stack, err)
+	}
+
+	// Integer opcodes refuse byte strings
+	if _, err := runOn(t, []byte{byte(ADD)}, []Value{String("a"), Int(1)}, testContext(), newTestHost()); err == nil {
+		t.Error("ADD of a byte string succeeded")
+	}
+}
+
+// disassembledLine matches a line of Disassemble output
+var disassembledLine = regexp.MustCompile(`^(\d{4})  (\S+)(?: (.*))?$`)
+
+// reassemble turns Disassemble output back into assembly source, naming
+// jump targets with labels
+func reassemble(t *testing.T, listing string) string {
+	t.Helper()
+	lines := strings.Split(strings.TrimSuffix(listing, "\n"), "\n")
+	targets := make(map[string]bool)
+	parsed := make([][]string, len(lines))
+	for i, line := range lines {
+		match := disassembledLine.FindStringSubmatch(line)
+		if match == nil {
+			t.Fatalf("unexpected disassembly line %q", line)
+		}
+		parsed[i] = match
+		if match[2] == "JUMP" || match[2] == "JUMPI" {
+			targets[fmt.Sprintf("%04s", match[3])] = true
+		}
+	}
+
+	var source strings.Builder
+	for _, match := range parsed {
+		offset, mnemonic, operand := match[1], match[2], match[3]
+		if targets[offset] {
+			fmt.Fprintf(&source, "at%s:\n", offset)
+		}
+		if mnemonic == "JUMP" || mnemonic == "JUMPI" {
+			operand = fmt.Sprintf("at%04s", operand)
+		}
+		fmt.Fprintf(&source, "%s %s\n", mnemonic, operand)
+	}
+	return source.String()
+}
+
+func TestAssemblerRoundTrip(t *testing.T) {
+	source := `
+		PUSH 0
+		PUSH -42
+		PUSH 0x10
+		PUSHB "count ; not a comment"
+		PUSHB 0x00ff10
+		PUSHB "0x looks like hex"
+	loop:
+		DUP
+		JUMPI loop      ; back to a label
+		JUMP end
+		POP
+		SWAP
+		OVER
+		ADD
+		SUB
+		MUL
+		DIV
+		MOD
+		LT
+		GT
+		EQ
+		NOT
+		AND
+		OR
+		CONCAT
+		LEN
+		CALLER
+		VALUE
+		ADDRESS
+		BALANCE
+		HEIGHT
+		METHOD
+		ARG
+		ARGC
+		SLOAD
+		SSTORE
+		TRANSFER
+		LOG
+		XCALL
+		REVERT
+	end:
+		RETURN
+		STOP
+	`
+	code := assemble(t, source)
+	listing, err := Disassemble(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := Assemble(reassemble(t, listing))
+	if err != nil {
+		t.Fatalf("reassembling the listing: %v\n%s", err, listing)
+	}
+	if string(again) != string(code) {
+		t.Fatalf("round trip changed the code:\n%x\n%x", code, again)
+	}
+
+	// Every opcode appears in the program
+	seen := make(map[Opcode]bool)
+	for pc := 0; pc < len(code); pc += instructionSize(code, pc) {
+		seen[Opcode(code[pc])] = true
+	}
+	for op := range opcodes {
+		if !seen[op] {
+			t.Errorf("%s missing from the round trip", op)
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []string{
+		"FOO",
+		"PUSH",
+		"POP 1",
+		"PUSH abc",
+		"PUSHB abc",
+		"JUMP nowhere",
+		"a:\na:",
+		fmt.Sprintf("PUSHB %q", strings.Repeat("a", MaxValueSize+1)),
+	}
+	for _, source := range tests {
+		if _, err := Assemble(source); err == nil {
+			t.Errorf("Assemble(%q) succeeded", source)
+		}
+	}
+	for _, code := range [][]byte{{0xff}, {byte(PUSH), 1}, {byte(PUSHB), 0}} {
+		if err := Validate(code); err == nil {
+			t.Errorf("Validate(%x) succeeded", code)
+		}
+	}
+}
@@ -3,7 +3,7 @@ package consensus
 
 import (
 	"fmt"
-	"lscc/core"
+	"lscc-benchmark/core"
 	"lscc/utils"
 	"sync"
 )
@@ -25,7 +25,7 @@ func NewCrossChannel(sourceShardID, targetShardID int, relayNodes []string) *Cro
 		relayNodes:    relayNodes,
 		pendingTxs:    make(map[string]*core.Transaction),
 		confirmedTxs:  make(map[string]*core.Transaction),
-		logger:        utils.GetLogger(),
+		logger:        utils.GetLogger().Named("consensus"),
 	}
 }
 
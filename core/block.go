@@ -6,6 +6,8 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"time"
+
+	lscc "lscc/core"
 )
 
 type Block struct {
@@ -37,25 +39,24 @@ func NewBlock(index uint64, prevBlockHash string, transactions []*Transaction, v
 	return block
 }
 
+// CalculateHash hashes the node's canonical encoding of the block header,
+// so both trees hash alike
 func (b *Block) CalculateHash() string {
-	data, _ := json.Marshal(struct {
-		Index         uint64    `json:"index"`
-		PrevBlockHash string    `json:"prev_block_hash"`
-		Timestamp     time.Time `json:"timestamp"`
-		MerkleRoot    string    `json:"merkle_root"`
-		Validator     string    `json:"validator"`
-		ShardID       int       `json:"shard_id"`
-	}{
-		Index:         b.Index,
-		PrevBlockHash: b.PrevBlockHash,
-		Timestamp:     b.Timestamp,
-		MerkleRoot:    b.MerkleRoot,
-		Validator:     b.Validator,
-		ShardID:       b.ShardID,
-	})
-	
-	hash := sha256.Sum256(data)
-	return hex.EncodeToString(hash[:])
+	block := lscc.Block{
+		Header: lscc.BlockHeader{
+			ChainID:      lscc.ChainID(),
+			Version:      1,
+			PreviousHash: b.PrevBlockHash,
+			MerkleRoot:   b.MerkleRoot,
+			Timestamp:    b.Timestamp.Unix(),
+			Height:       b.Index,
+			ValidatorID:  b.Validator,
+			Layer:        b.Layer,
+		},
+		ShardID: b.ShardID,
+	}
+	hash, _ := block.Hash()
+	return hash
 }
 
 func (b *Block) calculateMerkleRoot() string {
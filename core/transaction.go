@@ -4,17 +4,19 @@ package core
 import (
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"time"
+
+	lscc "lscc/core"
+	"lscc/utils"
 )
 
 type Transaction struct {
 	ID        string    `json:"id"`
 	From      string    `json:"from"`
 	To        string    `json:"to"`
-	Amount    float64   `json:"amount"`
-	Fee       float64   `json:"fee"`
+	Amount    utils.Amount `json:"amount"`
+	Fee       utils.Amount `json:"fee"`
 	Timestamp time.Time `json:"timestamp"`
 	Hash      string    `json:"hash"`
 	Signature string    `json:"signature"`
@@ -22,7 +24,7 @@ type Transaction struct {
 	CrossShard bool     `json:"cross_shard"`
 }
 
-func NewTransaction(from, to string, amount, fee float64, shardID int) *Transaction {
+func NewTransaction(from, to string, amount, fee utils.Amount, shardID int) *Transaction {
 	tx := &Transaction{
 		ID:        generateTransactionID(),
 		From:      from,
@@ -38,27 +40,26 @@ func NewTransaction(from, to string, amount, fee float64, shardID int) *Transact
 	return tx
 }
 
+// CalculateHash hashes the node's canonical encoding of the transaction, so
+// both trees hash alike. The random ID goes in the data, keeping otherwise
+// identical transfers apart.
 func (tx *Transaction) CalculateHash() string {
-	data, _ := json.Marshal(struct {
-		ID        string    `json:"id"`
-		From      string    `json:"from"`
-		To        string    `json:"to"`
-		Amount    float64   `json:"amount"`
-		Fee       float64   `json:"fee"`
-		Timestamp time.Time `json:"timestamp"`
-		ShardID   int       `json:"shard_id"`
-	}{
-		ID:        tx.ID,
-		From:      tx.From,
-		To:        tx.To,
-		Amount:    tx.Amount,
-		Fee:       tx.Fee,
-		Timestamp: tx.Timestamp,
-		ShardID:   tx.ShardID,
-	})
-	
-	hash := sha256.Sum256(data)
-	return hex.EncodeToString(hash[:])
+	hash, _ := tx.canonical().CalculateHash()
+	return hash
+}
+
+func (tx *Transaction) canonical() *lscc.Transaction {
+	return &lscc.Transaction{
+		ChainID:     lscc.ChainID(),
+		From:        tx.From,
+		To:          tx.To,
+		Amount:      tx.Amount,
+		Fee:         tx.Fee,
+		Data:        []byte(tx.ID),
+		Timestamp:   tx.Timestamp.Unix(),
+		SourceShard: tx.ShardID,
+		TargetShard: tx.ShardID,
+	}
 }
 
 func (tx *Transaction) Validate() error {
@@ -77,6 +78,9 @@ func (tx *Transaction) Validate() error {
 	if tx.Fee < 0 {
 		return fmt.Errorf("invalid fee")
 	}
+	if _, err := tx.Amount.Add(tx.Fee); err != nil {
+		return fmt.Errorf("invalid amount: %w", err)
+	}
 	
 	if tx.Hash == "" {
 		return fmt.Errorf("invalid hash")
@@ -1,8 +1,11 @@
-module lscc
+module lscc-benchmark
 
 go 1.20
 
 require (
     github.com/stretchr/testify v1.8.1
+    lscc v0.0.0
 )
 
+// The node's packages (lscc/core, lscc/utils, ...) live in LSCC_Export
+replace lscc => ./LSCC_Export
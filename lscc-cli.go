@@ -2,46 +2,80 @@ package main
 
 import (
     "bytes"
-    "crypto/sha256"
-    "encoding/hex"
     "encoding/json"
     "flag"
     "fmt"
     "net/http"
     "os"
-    "time"
+
+    "lscc/core"
+    "lscc/utils"
 )
 
-type Transaction struct {
-    From      string  `json:"from"`
-    To        string  `json:"to"`
-    Amount    float64 `json:"amount"`
-    Timestamp int64   `json:"timestamp"`
-    Hash      string  `json:"hash"`
+// chainID asks the node for the chain ID to sign for
+func chainID(port int) (string, error) {
+    resp, err := http.Get(fmt.Sprintf("http://localhost:%d/status", port))
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+
+    var status struct {
+        ChainID string `json:"chain_id"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+        return "", err
+    }
+    return status.ChainID, nil
 }
 
 func main() {
     from := flag.String("from", "", "Sender address")
     to := flag.String("to", "", "Receiver address")
-    amount := flag.Float64("amount", 0.0, "Amount to send")
+    amountText := flag.String("amount", "", "Amount to send, e.g. 10.5")
+    feeText := flag.String("fee", "0.01", "Transaction fee")
+    key := flag.String("key", "", "Signing key (default: sender address)")
     port := flag.Int("port", 9000, "Port of REST API")
     flag.Parse()
 
-    if *from == "" || *to == "" || *amount <= 0 {
+    if *from == "" || *to == "" || *amountText == "" {
         fmt.Println("Usage: lscc-cli -from Alice -to Bob -amount 10 -port 9000")
         os.Exit(1)
     }
+    value, err := utils.ParseAmount(*amountText)
+    if err == nil && value <= 0 {
+        err = fmt.Errorf("amount must be positive")
+    }
+    if err != nil {
+        fmt.Println("Invalid -amount:", err)
+        os.Exit(1)
+    }
+    fee, err := utils.ParseAmount(*feeText)
+    if err != nil {
+        fmt.Println("Invalid -fee:", err)
+        os.Exit(1)
+    }
+    if *key == "" {
+        *key = *from
+    }
 
-    tx := Transaction{
-        From:      *from,
-        To:        *to,
-        Amount:    *amount,
-        Timestamp: time.Now().Unix(),
+    chain, err := chainID(*port)
+    if err != nil {
+        fmt.Println("Failed to read chain ID:", err)
+        os.Exit(1)
     }
 
-    data := fmt.Sprintf("%s:%s:%f:%d", tx.From, tx.To, tx.Amount, tx.Timestamp)
-    hash := sha256.Sum256([]byte(data))
-    tx.Hash = hex.EncodeToString(hash[:])
+    // Hash and sign with the node's canonical encoding for its chain
+    core.SetChainID(chain)
+    tx, err := core.NewTransaction(*from, *to, value, fee, 0, 0, 0, core.RegularTransaction)
+    if err != nil {
+        fmt.Println("Failed to create transaction:", err)
+        os.Exit(1)
+    }
+    if err := tx.Sign(*key); err != nil {
+        fmt.Println("Failed to sign transaction:", err)
+        os.Exit(1)
+    }
 
     jsonData, err := json.Marshal(tx)
     if err != nil {
@@ -57,6 +91,5 @@ func main() {
     }
     defer resp.Body.Close()
 
-    fmt.Println("Transaction sent:", resp.Status)
+    fmt.Println("Transaction sent:", resp.Status, tx.Hash)
 }
-
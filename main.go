@@ -2,8 +2,8 @@ package main
 
 import (
     "flag"
-    "lscc/config"
-    "lscc/network"
+    "lscc-benchmark/config"
+    "lscc-benchmark/network"
     "lscc/utils"
 )
 
@@ -16,7 +16,10 @@ func main() {
         panic(err)
     }
 
-    logger := utils.InitLoggerLevel(cfg.LoggingLevel)
+    if err := utils.ConfigureLogging(utils.LogOptions{Level: cfg.LoggingLevel}, ""); err != nil {
+        panic(err)
+    }
+    logger := utils.GetLogger().Named("node")
     node, err := network.NewNode(cfg, logger)
     if err != nil {
         logger.Error("Failed to create node", "error", err)
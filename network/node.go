@@ -2,13 +2,16 @@
 package network
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
-	"lscc/config"
-	"lscc/core"
-	"lscc/utils"
+	"lscc-benchmark/config"
+	"lscc-benchmark/core"
+	lsccconfig "lscc/config"
 	"lscc/consensus"
+	lscccore "lscc/core"
+	"lscc/utils"
 	"sync"
 	"time"
 )
@@ -18,7 +21,8 @@ type Node struct {
 	Config     *config.Config
 	Blockchain *core.Blockchain
 	Logger     *utils.Logger
-	consensus  core.Consensus
+	consensus  consensus.ConsensusEngine
+	startedAt  time.Time
 	mu         sync.Mutex
 }
 
@@ -43,23 +47,35 @@ func NewNode(cfg *config.Config, logger *utils.Logger) (*Node, error) {
 		Logger:     logger,
 	}
 
-	var err error
-	switch cfg.ConsensusType {
-	case "pos":
-		node.consensus, err = consensus.NewPoSConsensus(cfg, node.Blockchain)
-	case "pow":
-		node.consensus, err = consensus.NewPoWConsensus(cfg, node.Blockchain)
-	case "pbft":
-		node.consensus, err = consensus.NewPBFTConsensus(cfg, node.Blockchain)
-	default:
-		return nil, fmt.Errorf("unsupported consensus type: %s", cfg.ConsensusType)
-	}
+	engine, err := newConsensusEngine(cfg)
 	if err != nil {
 		return nil, err
 	}
+	node.consensus = engine
 	return node, nil
 }
 
+// newConsensusEngine creates the engine the config selects from the node's
+// consensus registry (lscc/consensus), over the node's chain of the shard
+func newConsensusEngine(cfg *config.Config) (consensus.ConsensusEngine, error) {
+	engineCfg := lsccconfig.DefaultConfig()
+	engineCfg.NodeID = cfg.NodeID
+	engineCfg.ShardID = cfg.ShardID
+	engineCfg.IsRelay = cfg.IsRelay
+	engineCfg.ConsensusType = cfg.ConsensusType
+	if cfg.ConsensusParams.Difficulty > 0 {
+		params, err := json.Marshal(map[string]int{"difficulty": cfg.ConsensusParams.Difficulty})
+		if err != nil {
+			return nil, err
+		}
+		engineCfg.ConsensusParams = map[string]json.RawMessage{string(consensus.ProofOfWork): params}
+	}
+	if err := consensus.ValidateConfig(engineCfg); err != nil {
+		return nil, err
+	}
+	return consensus.NewConsensusEngine(engineCfg, lscccore.NewBlockchain(engineCfg))
+}
+
 // CreateBlock creates a new block with pending transactions.
 func (n *Node) CreateBlock() *core.Block {
 	n.mu.Lock()
@@ -93,6 +109,7 @@ func (n *Node) CreateBlock() *core.Block {
 // Start starts the network node.
 func (n *Node) Start() error {
 	n.Logger.Info("=== Starting Node ===")
+	n.startedAt = time.Now()
 	n.Logger.Info("Node configuration", "shardID", n.Config.ShardID, "layer", n.Config.Layer, "port", n.Config.Port)
 
 	n.Logger.Info("Initializing blockchain...")
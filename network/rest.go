@@ -3,7 +3,8 @@ package network
 import (
 	"encoding/json"
 	"fmt"
-	"lscc/core"
+	"lscc-benchmark/core"
+	"lscc/utils"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -75,7 +76,7 @@ func (n *Node) handleStatus(w http.ResponseWriter, r *http.Request) {
 		"total_transactions":   totalTxs,
 		"status":               "running",
 		"timestamp":            time.Now().Unix(),
-		"uptime":               time.Now().Unix(),
+		"uptime":               int64(time.Since(n.startedAt).Seconds()),
 		"blockchain_info":      blockchainInfo,
 		"shard_details": map[string]interface{}{
 			"shard_id":     n.Config.ShardID,
@@ -149,7 +150,7 @@ func (n *Node) handleSend(w http.ResponseWriter, r *http.Request) {
 
 	// Set default values for missing fields
 	if tx.Fee == 0 {
-		tx.Fee = 0.01 // Default fee
+		tx.Fee = utils.MustParseAmount("0.01") // Default fee
 	}
 	if tx.SourceShard == 0 {
 		tx.SourceShard = n.Config.ShardID
@@ -272,7 +273,7 @@ func (n *Node) handleShardInfo(w http.ResponseWriter, r *http.Request) {
 			"listening_addr":  fmt.Sprintf("0.0.0.0:%d", n.Config.Port),
 		},
 		"performance_metrics": map[string]interface{}{
-			"uptime":          time.Now().Unix(),
+			"uptime":          int64(time.Since(n.startedAt).Seconds()),
 			"last_block_time": func() int64 {
 				lastBlock := n.Blockchain.GetLastBlock()
 				if lastBlock != nil {
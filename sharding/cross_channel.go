@@ -5,7 +5,7 @@ import (
     "crypto/sha256"
     "encoding/hex"
     "fmt"
-    "lscc/core"
+    "lscc-benchmark/core"
     "lscc/utils"
     "sync"
     "time"
@@ -51,7 +51,7 @@ func NewCrossChannelConsensus() *CrossChannelConsensus {
         pendingRelayBlocks:   make(map[string]*RelayBlock),
         validatedRelayBlocks: make(map[string]*RelayBlock),
         validationThreshold:  2, // Minimum validations needed
-        logger:               utils.InitLoggerLevel("debug"),
+        logger:               utils.GetLogger().Named("sharding"),
     }
 }
 